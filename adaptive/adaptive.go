@@ -0,0 +1,196 @@
+// Package adaptive provides middleware limiting the requests in flight to the limit adjusted
+// to the latencies and the failures observed, instead of the static limit of connlimit.
+//
+// The requests above the limit are rejected with 503 status code, so the load is shed before
+// the saturated backend queues them up.
+//
+//	// start with 20 requests in flight, let the limit grow up to 200
+//	g, _ := adaptive.NewGradient(20, 1, 200)
+//	l, _ := adaptive.New(fwd, g)
+package adaptive
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// Sample is the outcome of the request passed to the algorithm
+type Sample struct {
+	// RTT is the time the request was served in
+	RTT time.Duration
+	// InFlight is the amount of the requests in flight when the request has started, the request included
+	InFlight int
+	// Dropped is set for the requests failed because of the overload, see Dropped
+	Dropped bool
+}
+
+// Algorithm adjusts the limit to the samples, it's called with the lock of the limiter held
+type Algorithm interface {
+	// Limit returns the current limit of the requests in flight
+	Limit() int
+	// Observe adjusts the limit to the sample of the completed request
+	Observe(s Sample)
+}
+
+// Limiter rejects the requests above the limit of the algorithm
+type Limiter struct {
+	mutex     sync.Mutex
+	algorithm Algorithm
+	inFlight  int
+	next      http.Handler
+
+	dropped    func(code int) bool
+	clock      timetools.TimeProvider
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+// LimiterOption is a functional option setter for Limiter
+type LimiterOption func(l *Limiter) error
+
+// New returns the limiter adjusting the limit with the algorithm, see NewAIMD and NewGradient
+func New(next http.Handler, a Algorithm, options ...LimiterOption) (*Limiter, error) {
+	if a == nil {
+		return nil, fmt.Errorf("algorithm can not be nil")
+	}
+	l := &Limiter{
+		algorithm: a,
+		next:      next,
+	}
+	for _, o := range options {
+		if err := o(l); err != nil {
+			return nil, err
+		}
+	}
+	if l.dropped == nil {
+		l.dropped = defaultDropped
+	}
+	if l.clock == nil {
+		l.clock = &timetools.RealTime{}
+	}
+	if l.errHandler == nil {
+		l.errHandler = defaultErrHandler
+	}
+	if l.log == nil {
+		l.log = utils.NullLogger
+	}
+	return l, nil
+}
+
+func (l *Limiter) Wrap(next http.Handler) {
+	l.next = next
+}
+
+// Limit returns the current limit of the requests in flight
+func (l *Limiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.algorithm.Limit()
+}
+
+// InFlight returns the amount of the requests being served
+func (l *Limiter) InFlight() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inFlight
+}
+
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	inFlight, err := l.acquire()
+	if err != nil {
+		l.log.Infof("shedding request: %v", err)
+		l.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	start := l.clock.UtcNow()
+	p := &utils.ProxyWriter{W: w}
+	defer func() {
+		l.release(Sample{RTT: l.clock.UtcNow().Sub(start), InFlight: inFlight, Dropped: l.dropped(p.StatusCode())})
+	}()
+	l.next.ServeHTTP(p, req)
+}
+
+func (l *Limiter) acquire() (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if limit := l.algorithm.Limit(); l.inFlight >= limit {
+		return 0, &LimitError{limit: limit}
+	}
+	l.inFlight++
+	return l.inFlight, nil
+}
+
+func (l *Limiter) release(s Sample) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inFlight--
+	l.algorithm.Observe(s)
+}
+
+// LimitError is reported for the requests shed by the limiter
+type LimitError struct {
+	limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("concurrency limit reached: %d", e.limit)
+}
+
+// LimitErrHandler serves the shed requests with 503 status code
+type LimitErrHandler struct {
+}
+
+func (e *LimitErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	if _, ok := err.(*LimitError); ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+// Dropped sets the function telling the responses of the overloaded backend, they shrink the limit
+// regardless of the latency. The 503 and 504 responses are the dropped ones by default.
+func Dropped(f func(code int) bool) LimiterOption {
+	return func(l *Limiter) error {
+		l.dropped = f
+		return nil
+	}
+}
+
+// Clock sets the clock measuring the latencies
+func Clock(clock timetools.TimeProvider) LimiterOption {
+	return func(l *Limiter) error {
+		l.clock = clock
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler of the shed requests, 503 by default
+func ErrorHandler(h utils.ErrorHandler) LimiterOption {
+	return func(l *Limiter) error {
+		l.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger used by this middleware
+func Logger(log utils.Logger) LimiterOption {
+	return func(l *Limiter) error {
+		l.log = log
+		return nil
+	}
+}
+
+func defaultDropped(code int) bool {
+	return code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+var defaultErrHandler = &LimitErrHandler{}
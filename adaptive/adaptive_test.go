@@ -0,0 +1,105 @@
+package adaptive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestAdaptive(t *testing.T) { TestingT(t) }
+
+type LimiterSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&LimiterSuite{})
+
+func (s *LimiterSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *LimiterSuite) TestShedding(c *C) {
+	entered, release := make(chan bool), make(chan bool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Wait") != "" {
+			entered <- true
+			<-release
+		}
+		w.Write([]byte("hello"))
+	})
+	a, err := NewAIMD(2, 1, 10)
+	c.Assert(err, IsNil)
+	l, err := New(handler, a)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			re, _, err := testutils.Get(srv.URL, testutils.Header("Wait", "yes"))
+			c.Assert(err, IsNil)
+			c.Assert(re.StatusCode, Equals, http.StatusOK)
+			done <- true
+		}()
+		<-entered
+	}
+	c.Assert(l.InFlight(), Equals, 2)
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+
+	// the limit has grown with the requests completed at the limit, by one or two depending on the order
+	close(release)
+	<-done
+	<-done
+	for l.InFlight() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(l.Limit() >= 3, Equals, true, Commentf("limit %d", l.Limit()))
+}
+
+func (s *LimiterSuite) TestDropped(c *C) {
+	code := http.StatusOK
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.clock.Sleep(10 * time.Millisecond)
+		w.WriteHeader(code)
+	})
+	a, err := NewAIMD(10, 1, 10, Backoff(0.5), Timeout(time.Second))
+	c.Assert(err, IsNil)
+	l, err := New(handler, a, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+		return w.Code
+	}
+	code = http.StatusServiceUnavailable
+	c.Assert(serve(), Equals, http.StatusServiceUnavailable)
+	c.Assert(l.Limit(), Equals, 5)
+
+	// the errors of the application say nothing about the load
+	code = http.StatusInternalServerError
+	serve()
+	c.Assert(l.Limit(), Equals, 5)
+
+	l.dropped = func(code int) bool { return code >= 500 }
+	serve()
+	c.Assert(l.Limit(), Equals, 2)
+}
+
+func (s *LimiterSuite) TestBadOptions(c *C) {
+	_, err := New(nil, nil)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,88 @@
+package adaptive
+
+import (
+	"fmt"
+	"time"
+)
+
+// AIMD grows the limit by one for every request served in time while the limit is used up to the half at least,
+// and multiplies it by the backoff for every dropped or timed out request
+type AIMD struct {
+	limit    float64
+	min, max int
+	backoff  float64
+	timeout  time.Duration
+}
+
+// NewAIMD returns the algorithm with the initial limit clamped to [min, max], the backoff of 0.9 and no timeout
+func NewAIMD(initial, min, max int, options ...AIMDOption) (*AIMD, error) {
+	if min <= 0 || max < min {
+		return nil, fmt.Errorf("limits should be 0 < min <= max, got %d, %d", min, max)
+	}
+	a := &AIMD{limit: float64(clamp(initial, min, max)), min: min, max: max, backoff: 0.9}
+	for _, o := range options {
+		if err := o(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// AIMDOption is a functional option setter for AIMD
+type AIMDOption func(a *AIMD) error
+
+// Backoff sets the factor the limit is multiplied by on the drops, in (0, 1)
+func Backoff(f float64) AIMDOption {
+	return func(a *AIMD) error {
+		if f <= 0 || f >= 1 {
+			return fmt.Errorf("backoff should be in (0, 1), got %v", f)
+		}
+		a.backoff = f
+		return nil
+	}
+}
+
+// Timeout sets the latency above which the requests count as the dropped ones
+func Timeout(d time.Duration) AIMDOption {
+	return func(a *AIMD) error {
+		if d <= 0 {
+			return fmt.Errorf("timeout should be > 0, got %v", d)
+		}
+		a.timeout = d
+		return nil
+	}
+}
+
+func (a *AIMD) Limit() int {
+	return int(a.limit)
+}
+
+func (a *AIMD) Observe(s Sample) {
+	if s.Dropped || (a.timeout > 0 && s.RTT > a.timeout) {
+		a.limit *= a.backoff
+	} else if float64(s.InFlight)*2 >= a.limit {
+		// the limit grows only while it's used, the idle backend says nothing about its capacity
+		a.limit++
+	}
+	a.limit = clampFloat(a.limit, a.min, a.max)
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func clampFloat(v float64, min, max int) float64 {
+	if v < float64(min) {
+		return float64(min)
+	}
+	if v > float64(max) {
+		return float64(max)
+	}
+	return v
+}
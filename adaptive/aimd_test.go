@@ -0,0 +1,52 @@
+package adaptive
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type AIMDSuite struct{}
+
+var _ = Suite(&AIMDSuite{})
+
+func (s *AIMDSuite) TestIncrease(c *C) {
+	a, err := NewAIMD(4, 1, 6)
+	c.Assert(err, IsNil)
+
+	// the limit doesn't grow while it's not used
+	a.Observe(Sample{RTT: time.Millisecond, InFlight: 1})
+	c.Assert(a.Limit(), Equals, 4)
+
+	for i := 0; i < 5; i++ {
+		a.Observe(Sample{RTT: time.Millisecond, InFlight: 4})
+	}
+	c.Assert(a.Limit(), Equals, 6)
+}
+
+func (s *AIMDSuite) TestDecrease(c *C) {
+	a, err := NewAIMD(20, 2, 20, Timeout(100*time.Millisecond))
+	c.Assert(err, IsNil)
+
+	a.Observe(Sample{RTT: time.Millisecond, InFlight: 20, Dropped: true})
+	c.Assert(a.Limit(), Equals, 18)
+
+	a.Observe(Sample{RTT: time.Second, InFlight: 20})
+	c.Assert(a.Limit(), Equals, 16)
+
+	for i := 0; i < 100; i++ {
+		a.Observe(Sample{RTT: time.Second, InFlight: 20})
+	}
+	c.Assert(a.Limit(), Equals, 2)
+}
+
+func (s *AIMDSuite) TestBadOptions(c *C) {
+	for _, o := range [][]int{{1, 0, 10}, {1, 5, 4}} {
+		_, err := NewAIMD(o[0], o[1], o[2])
+		c.Assert(err, NotNil)
+	}
+	_, err := NewAIMD(1, 1, 10, Backoff(1))
+	c.Assert(err, NotNil)
+	_, err = NewAIMD(1, 1, 10, Timeout(0))
+	c.Assert(err, NotNil)
+}
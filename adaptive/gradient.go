@@ -0,0 +1,127 @@
+package adaptive
+
+import (
+	"fmt"
+	"math"
+)
+
+// Gradient compares the short term average of the latencies with the long term one, the limit shrinks
+// as the requests get queued up in the backend and the short term latencies grow, and grows by the queue
+// allowance of the square root of the limit while they stay close
+type Gradient struct {
+	limit     float64
+	min, max  int
+	tolerance float64
+	smoothing float64
+
+	short, long ewma
+}
+
+// NewGradient returns the algorithm with the initial limit clamped to [min, max], averaging the latencies
+// of the last 10 and 600 requests, with the tolerance of 1.5 and the smoothing of 0.2
+func NewGradient(initial, min, max int, options ...GradientOption) (*Gradient, error) {
+	if min <= 0 || max < min {
+		return nil, fmt.Errorf("limits should be 0 < min <= max, got %d, %d", min, max)
+	}
+	g := &Gradient{
+		limit:     float64(clamp(initial, min, max)),
+		min:       min,
+		max:       max,
+		tolerance: 1.5,
+		smoothing: 0.2,
+		short:     newEWMA(10),
+		long:      newEWMA(600),
+	}
+	for _, o := range options {
+		if err := o(g); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// GradientOption is a functional option setter for Gradient
+type GradientOption func(g *Gradient) error
+
+// Windows sets the amount of the requests the short and the long term latencies are averaged over
+func Windows(short, long int) GradientOption {
+	return func(g *Gradient) error {
+		if short <= 0 || long <= short {
+			return fmt.Errorf("windows should be 0 < short < long, got %d, %d", short, long)
+		}
+		g.short, g.long = newEWMA(short), newEWMA(long)
+		return nil
+	}
+}
+
+// Tolerance sets how many times the short term latency can exceed the long term one before the limit shrinks, >= 1
+func Tolerance(t float64) GradientOption {
+	return func(g *Gradient) error {
+		if t < 1 {
+			return fmt.Errorf("tolerance should be >= 1, got %v", t)
+		}
+		g.tolerance = t
+		return nil
+	}
+}
+
+// Smoothing sets the weight of the new limit, in (0, 1]
+func Smoothing(s float64) GradientOption {
+	return func(g *Gradient) error {
+		if s <= 0 || s > 1 {
+			return fmt.Errorf("smoothing should be in (0, 1], got %v", s)
+		}
+		g.smoothing = s
+		return nil
+	}
+}
+
+func (g *Gradient) Limit() int {
+	return int(g.limit)
+}
+
+func (g *Gradient) Observe(s Sample) {
+	rtt := float64(s.RTT)
+	if s.Dropped {
+		// the dropped requests are often fast, they count as the slowest ones seen recently
+		rtt = math.Max(rtt, g.short.value*g.tolerance*2)
+	}
+	short := g.short.add(rtt)
+	long := g.long.add(rtt)
+	if short <= 0 {
+		return
+	}
+	// the long term average follows the lasting latency changes faster, otherwise the limit stays low
+	if long/short > 2 {
+		g.long.value *= 0.95
+	}
+	if float64(s.InFlight)*2 < g.limit && !s.Dropped {
+		// the idle backend says nothing about its capacity
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*long/short))
+	limit := g.limit*gradient + math.Sqrt(g.limit)
+	g.limit = clampFloat(g.limit*(1-g.smoothing)+limit*g.smoothing, g.min, g.max)
+}
+
+// ewma is the exponentially weighted moving average of the window, the plain average until the window fills up
+type ewma struct {
+	window int
+	count  int
+	value  float64
+}
+
+func newEWMA(window int) ewma {
+	return ewma{window: window}
+}
+
+func (e *ewma) add(v float64) float64 {
+	if e.count < e.window {
+		e.count++
+		e.value += (v - e.value) / float64(e.count)
+		return e.value
+	}
+	factor := 2 / float64(e.window+1)
+	e.value = e.value*(1-factor) + v*factor
+	return e.value
+}
@@ -0,0 +1,59 @@
+package adaptive
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type GradientSuite struct{}
+
+var _ = Suite(&GradientSuite{})
+
+func (s *GradientSuite) TestSaturation(c *C) {
+	g, err := NewGradient(10, 1, 100, Windows(5, 100))
+	c.Assert(err, IsNil)
+
+	// the limit grows while the latencies are stable
+	for i := 0; i < 200; i++ {
+		g.Observe(Sample{RTT: 10 * time.Millisecond, InFlight: g.Limit()})
+	}
+	c.Assert(g.Limit(), Equals, 100)
+
+	// and shrinks once the requests queue up in the backend
+	for i := 0; i < 20; i++ {
+		g.Observe(Sample{RTT: 100 * time.Millisecond, InFlight: g.Limit()})
+	}
+	c.Assert(g.Limit() < 50, Equals, true, Commentf("limit %d", g.Limit()))
+}
+
+func (s *GradientSuite) TestIdle(c *C) {
+	g, err := NewGradient(10, 1, 100)
+	c.Assert(err, IsNil)
+	for i := 0; i < 100; i++ {
+		g.Observe(Sample{RTT: 10 * time.Millisecond, InFlight: 1})
+	}
+	c.Assert(g.Limit(), Equals, 10)
+}
+
+func (s *GradientSuite) TestDropped(c *C) {
+	g, err := NewGradient(50, 1, 100)
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		g.Observe(Sample{RTT: 10 * time.Millisecond, InFlight: 50})
+	}
+	limit := g.Limit()
+	for i := 0; i < 10; i++ {
+		g.Observe(Sample{RTT: time.Millisecond, InFlight: 1, Dropped: true})
+	}
+	c.Assert(g.Limit() < limit, Equals, true, Commentf("limit %d, was %d", g.Limit(), limit))
+}
+
+func (s *GradientSuite) TestBadOptions(c *C) {
+	_, err := NewGradient(1, 0, 10)
+	c.Assert(err, NotNil)
+	for _, o := range []GradientOption{Windows(10, 10), Tolerance(0.5), Smoothing(0)} {
+		_, err := NewGradient(1, 1, 10, o)
+		c.Assert(err, NotNil)
+	}
+}
@@ -0,0 +1,187 @@
+// Package auth provides Basic and JWT bearer authentication middlewares.
+//
+// Verified identity is attached to the request as Claims, so the downstream middlewares can use it,
+// e.g. rate limit by the subject with ClaimExtractor:
+//
+//	jwks, _ := auth.NewJWKS("https://example.com/.well-known/jwks.json")
+//	limiter, _ := ratelimit.New(next, auth.ClaimExtractor("sub"), rates)
+//	a, _ := auth.NewJWT(limiter, jwks, auth.Issuer("https://example.com"), auth.Audience("api"))
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+const (
+	Authorization   = "Authorization"
+	WWWAuthenticate = "Www-Authenticate"
+)
+
+// Claims are the verified attributes of the authenticated client, for Basic auth it's the username in sub claim
+type Claims map[string]interface{}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a shallow copy of the request carrying the claims
+func WithClaims(req *http.Request, c Claims) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), claimsKey{}, c))
+}
+
+// ClaimsFromRequest returns the claims of the authenticated request
+func ClaimsFromRequest(req *http.Request) (Claims, bool) {
+	c, ok := req.Context().Value(claimsKey{}).(Claims)
+	return c, ok
+}
+
+// ClaimExtractor returns source extractor using the value of the claim as the token
+func ClaimExtractor(claim string) utils.SourceExtractor {
+	return utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+		c, ok := ClaimsFromRequest(req)
+		if !ok {
+			return "", 0, fmt.Errorf("request is not authenticated")
+		}
+		val, ok := c[claim]
+		if !ok {
+			return "", 0, fmt.Errorf("claim %v is missing", claim)
+		}
+		return fmt.Sprintf("%v", val), 1, nil
+	})
+}
+
+// AuthError is passed to the error handler when the request fails authentication
+type AuthError struct {
+	// Scheme is the authentication scheme, Basic or Bearer
+	Scheme string
+	Realm  string
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%v authentication failed: %v", e.Scheme, e.Reason)
+}
+
+// AuthErrHandler replies with 401 and the challenge for the failed scheme
+type AuthErrHandler struct {
+}
+
+func (e *AuthErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	if ae, ok := err.(*AuthError); ok {
+		challenge := fmt.Sprintf("%v realm=%q", ae.Scheme, ae.Realm)
+		if ae.Scheme == "Bearer" {
+			challenge += `, error="invalid_token"`
+		}
+		w.Header().Set(WWWAuthenticate, challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+var defaultErrHandler = &AuthErrHandler{}
+
+// DefaultLeeway is the default allowed clock skew when checking exp and nbf claims
+const DefaultLeeway = 30 * time.Second
+
+type settings struct {
+	realm      string
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	clock      timetools.TimeProvider
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+func newSettings(options []AuthOption) (*settings, error) {
+	s := &settings{realm: "oxy", leeway: DefaultLeeway}
+	for _, o := range options {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.clock == nil {
+		s.clock = &timetools.RealTime{}
+	}
+	if s.errHandler == nil {
+		s.errHandler = defaultErrHandler
+	}
+	if s.log == nil {
+		s.log = utils.NullLogger
+	}
+	return s, nil
+}
+
+// AuthOption is a functional option setter for the authentication middlewares
+type AuthOption func(s *settings) error
+
+// Realm sets the realm sent in the WWW-Authenticate challenge
+func Realm(realm string) AuthOption {
+	return func(s *settings) error {
+		s.realm = realm
+		return nil
+	}
+}
+
+// Issuer requires JWT iss claim to match
+func Issuer(iss string) AuthOption {
+	return func(s *settings) error {
+		s.issuer = iss
+		return nil
+	}
+}
+
+// Audience requires JWT aud claim to contain the audience
+func Audience(aud string) AuthOption {
+	return func(s *settings) error {
+		s.audience = aud
+		return nil
+	}
+}
+
+// Leeway sets the allowed clock skew when checking JWT exp and nbf claims
+func Leeway(d time.Duration) AuthOption {
+	return func(s *settings) error {
+		if d < 0 {
+			return fmt.Errorf("leeway should be >= 0, got %v", d)
+		}
+		s.leeway = d
+		return nil
+	}
+}
+
+// Clock sets the time provider, intended for tests
+func Clock(clock timetools.TimeProvider) AuthOption {
+	return func(s *settings) error {
+		s.clock = clock
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler rejecting the requests, it receives *AuthError
+func ErrorHandler(h utils.ErrorHandler) AuthOption {
+	return func(s *settings) error {
+		s.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) AuthOption {
+	return func(s *settings) error {
+		s.log = l
+		return nil
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestAuth(t *testing.T) { TestingT(t) }
+
+type AuthSuite struct{}
+
+var _ = Suite(&AuthSuite{})
+
+func (s *AuthSuite) TestClaimExtractor(c *C) {
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	c.Assert(err, IsNil)
+
+	e := ClaimExtractor("sub")
+	_, _, err = e.Extract(req)
+	c.Assert(err, NotNil)
+
+	req = WithClaims(req, Claims{"sub": "alice", "tier": 2.0})
+	token, amount, err := e.Extract(req)
+	c.Assert(err, IsNil)
+	c.Assert(token, Equals, "alice")
+	c.Assert(amount, Equals, int64(1))
+
+	token, _, err = ClaimExtractor("tier").Extract(req)
+	c.Assert(err, IsNil)
+	c.Assert(token, Equals, "2")
+
+	_, _, err = ClaimExtractor("missing").Extract(req)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Credentials verifies username and password pairs
+type Credentials interface {
+	Authenticate(username, password string) bool
+}
+
+// Basic authenticates requests with Basic auth credentials
+type Basic struct {
+	next  http.Handler
+	creds Credentials
+	*settings
+}
+
+// NewBasic returns Basic auth middleware verifying credentials against the store, e.g. HtpasswdStore
+func NewBasic(next http.Handler, creds Credentials, options ...AuthOption) (*Basic, error) {
+	s, err := newSettings(options)
+	if err != nil {
+		return nil, err
+	}
+	return &Basic{next: next, creds: creds, settings: s}, nil
+}
+
+func (b *Basic) Wrap(next http.Handler) {
+	b.next = next
+}
+
+func (b *Basic) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ba, err := utils.ParseAuthHeader(req.Header.Get(Authorization))
+	if err != nil {
+		b.errHandler.ServeHTTP(w, req, &AuthError{Scheme: "Basic", Realm: b.realm, Reason: "missing credentials"})
+		return
+	}
+	if !b.creds.Authenticate(ba.Username, ba.Password) {
+		b.log.Infof("basic auth failed for %v", ba.Username)
+		b.errHandler.ServeHTTP(w, req, &AuthError{Scheme: "Basic", Realm: b.realm, Reason: "invalid credentials"})
+		return
+	}
+	b.next.ServeHTTP(w, WithClaims(req, Claims{"sub": ba.Username}))
+}
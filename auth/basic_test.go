@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/mailgun/oxy/testutils"
+	"golang.org/x/crypto/bcrypt"
+
+	. "gopkg.in/check.v1"
+)
+
+type BasicSuite struct{}
+
+var _ = Suite(&BasicSuite{})
+
+func (s *BasicSuite) newStore(c *C) *HtpasswdStore {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	c.Assert(err, IsNil)
+	store, err := NewHtpasswdStore(strings.NewReader(
+		"# users\n" +
+			"alice:" + string(hash) + "\n" +
+			"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+			"carol:plain\n"))
+	c.Assert(err, IsNil)
+	return store
+}
+
+func (s *BasicSuite) TestHtpasswd(c *C) {
+	store := s.newStore(c)
+	c.Assert(store.Authenticate("alice", "secret"), Equals, true)
+	c.Assert(store.Authenticate("alice", "wrong"), Equals, false)
+	c.Assert(store.Authenticate("bob", "secret"), Equals, true)
+	c.Assert(store.Authenticate("carol", "plain"), Equals, true)
+	c.Assert(store.Authenticate("dave", "secret"), Equals, false)
+
+	c.Assert(store.Load(strings.NewReader("dave:pass\n")), IsNil)
+	c.Assert(store.Authenticate("alice", "secret"), Equals, false)
+	c.Assert(store.Authenticate("dave", "pass"), Equals, true)
+
+	c.Assert(store.Load(strings.NewReader("malformed\n")), NotNil)
+	c.Assert(store.Load(strings.NewReader("eve:$apr1$salt$hash\n")), NotNil)
+	c.Assert(store.Authenticate("dave", "pass"), Equals, true)
+}
+
+func (s *BasicSuite) TestBasic(c *C) {
+	var sub string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		claims, _ := ClaimsFromRequest(req)
+		sub = claims.Subject()
+		w.Write([]byte("hello"))
+	})
+	b, err := NewBasic(backend, s.newStore(c), Realm("test"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(re.Header.Get(WWWAuthenticate), Equals, `Basic realm="test"`)
+
+	re, _, err = testutils.Get(srv.URL, testutils.BasicAuth("alice", "wrong"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnauthorized)
+
+	re, body, err := testutils.Get(srv.URL, testutils.BasicAuth("alice", "secret"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(sub, Equals, "alice")
+}
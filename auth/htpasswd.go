@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HtpasswdStore is the credentials store in htpasswd format. It supports bcrypt, {SHA} and plain text passwords,
+// lines starting with # are ignored. The store can be reloaded at runtime.
+type HtpasswdStore struct {
+	mtx   sync.RWMutex
+	users map[string]string
+}
+
+// NewHtpasswdStore parses htpasswd formatted credentials
+func NewHtpasswdStore(r io.Reader) (*HtpasswdStore, error) {
+	s := &HtpasswdStore{}
+	if err := s.Load(r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadHtpasswdFile reads the credentials from htpasswd file
+func LoadHtpasswdFile(path string) (*HtpasswdStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewHtpasswdStore(f)
+}
+
+// Load replaces the credentials, the store stays intact if the input is malformed
+func (s *HtpasswdStore) Load(r io.Reader) error {
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("malformed htpasswd line %d", line)
+		}
+		if strings.HasPrefix(parts[1], "$apr1$") {
+			return fmt.Errorf("unsupported apr1 hash for %v on line %d", parts[0], line)
+		}
+		users[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.users = users
+	return nil
+}
+
+func (s *HtpasswdStore) Authenticate(username, password string) bool {
+	s.mtx.RLock()
+	hash, ok := s.users[username]
+	s.mtx.RUnlock()
+	if !ok {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+	}
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+const (
+	// DefaultJWKSTTL is the default time the fetched key set is cached for
+	DefaultJWKSTTL = time.Hour
+	// DefaultJWKSMinRefresh is the default minimum interval between the fetches
+	DefaultJWKSMinRefresh = time.Minute
+	// MaxJWKSBytes limits the size of the key set response
+	MaxJWKSBytes = 1 << 20
+)
+
+// JWKS is a KeySource fetching and caching JSON Web Key Set from URL. The set is refetched once the TTL expires
+// or when a token is signed with an unknown key id, but not more often than the minimum refresh interval.
+// Concurrent lookups share a single fetch and the cached keys are not locked while it is running.
+type JWKS struct {
+	url        string
+	client     *http.Client
+	ttl        time.Duration
+	minRefresh time.Duration
+	clock      timetools.TimeProvider
+
+	mtx       sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchErr  error
+	attempted time.Time
+	// fetching is closed once the running fetch completes, nil if there is none
+	fetching chan struct{}
+}
+
+// JWKSOption is a functional option setter for JWKS
+type JWKSOption func(j *JWKS) error
+
+// JWKSClient sets the HTTP client used to fetch the key set
+func JWKSClient(c *http.Client) JWKSOption {
+	return func(j *JWKS) error {
+		j.client = c
+		return nil
+	}
+}
+
+// JWKSTTL sets the time the fetched key set is cached for
+func JWKSTTL(d time.Duration) JWKSOption {
+	return func(j *JWKS) error {
+		if d <= 0 {
+			return fmt.Errorf("ttl should be > 0, got %v", d)
+		}
+		j.ttl = d
+		return nil
+	}
+}
+
+// JWKSMinRefresh sets the minimum interval between the fetches, so tokens with unknown key ids
+// or the failing endpoint can't cause a fetch per request
+func JWKSMinRefresh(d time.Duration) JWKSOption {
+	return func(j *JWKS) error {
+		if d <= 0 {
+			return fmt.Errorf("min refresh should be > 0, got %v", d)
+		}
+		j.minRefresh = d
+		return nil
+	}
+}
+
+// JWKSClock sets the time provider, intended for tests
+func JWKSClock(clock timetools.TimeProvider) JWKSOption {
+	return func(j *JWKS) error {
+		j.clock = clock
+		return nil
+	}
+}
+
+// NewJWKS returns a key source fetching the keys from the URL lazily on the first use
+func NewJWKS(url string, options ...JWKSOption) (*JWKS, error) {
+	j := &JWKS{
+		url:        url,
+		ttl:        DefaultJWKSTTL,
+		minRefresh: DefaultJWKSMinRefresh,
+	}
+	for _, o := range options {
+		if err := o(j); err != nil {
+			return nil, err
+		}
+	}
+	if j.client == nil {
+		j.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if j.clock == nil {
+		j.clock = &timetools.RealTime{}
+	}
+	return j, nil
+}
+
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	_, known := j.keys[kid]
+	if done := j.fetching; done != nil {
+		// lookups of the cached keys don't wait for the running fetch
+		if !known {
+			j.mtx.Unlock()
+			<-done
+			j.mtx.Lock()
+		}
+	} else if j.shouldFetch(known) {
+		done := make(chan struct{})
+		j.fetching, j.attempted = done, j.clock.UtcNow()
+		j.mtx.Unlock()
+		keys, err := j.fetch()
+		j.mtx.Lock()
+		// keep serving the cached keys if the key set endpoint is down
+		if err == nil {
+			j.keys = keys
+		}
+		j.fetchErr = err
+		j.fetching = nil
+		close(done)
+	}
+	if j.keys == nil {
+		return nil, j.fetchErr
+	}
+	k, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%v'", kid)
+	}
+	return k, nil
+}
+
+// shouldFetch tells whether the key set has to be refetched, the caller holds the lock
+func (j *JWKS) shouldFetch(known bool) bool {
+	since := j.clock.UtcNow().Sub(j.attempted)
+	if since < j.minRefresh {
+		return false
+	}
+	return !known || since >= j.ttl
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *JWKS) fetch() (map[string]crypto.PublicKey, error) {
+	re, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer re.Body.Close()
+	if re.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %v: %v", j.url, re.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(re.Body, MaxJWKSBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxJWKSBytes {
+		return nil, fmt.Errorf("key set from %v exceeds %d bytes", j.url, MaxJWKSBytes)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode key set: %v", err)
+	}
+	return parseJWKs(set.Keys)
+}
+
+// parseJWKs converts the signing keys of the set, keys of unknown types are skipped
+func parseJWKs(set []jwk) (map[string]crypto.PublicKey, error) {
+	keys := make(map[string]crypto.PublicKey, len(set))
+	for _, k := range set {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("bad key %v: %v", k.Kid, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("bad key %v: %v", k.Kid, err)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("bad key %v: unsupported curve %v", k.Kid, k.Crv)
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("bad key %v: %v", k.Kid, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("bad key %v: %v", k.Kid, err)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
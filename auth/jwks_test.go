@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type JWKSSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&JWKSSuite{})
+
+func (s *JWKSSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func (s *JWKSSuite) TestFetchAndCache(c *C) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	fetches := 0
+	keys := []jwk{
+		{Kty: "RSA", Kid: "rsa", Use: "sig", N: encodeInt(rsaKey.N), E: encodeInt(big.NewInt(int64(rsaKey.E)))},
+		{Kty: "EC", Kid: "ec", Crv: "P-256", X: encodeInt(ecKey.X), Y: encodeInt(ecKey.Y)},
+		{Kty: "RSA", Kid: "enc", Use: "enc", N: encodeInt(rsaKey.N), E: encodeInt(big.NewInt(int64(rsaKey.E)))},
+	}
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	defer srv.Close()
+
+	j, err := NewJWKS(srv.URL, JWKSTTL(time.Hour), JWKSMinRefresh(time.Minute), JWKSClock(s.clock))
+	c.Assert(err, IsNil)
+
+	k, err := j.Key("rsa")
+	c.Assert(err, IsNil)
+	c.Assert(k.(*rsa.PublicKey).N.Cmp(rsaKey.N), Equals, 0)
+
+	k, err = j.Key("ec")
+	c.Assert(err, IsNil)
+	c.Assert(k.(*ecdsa.PublicKey).X.Cmp(ecKey.X), Equals, 0)
+	c.Assert(fetches, Equals, 1)
+
+	// encryption keys are skipped, unknown key id does not refetch within the minimum refresh interval
+	_, err = j.Key("enc")
+	c.Assert(err, NotNil)
+	c.Assert(fetches, Equals, 1)
+
+	// the key is rotated
+	keys[0].Kid = "rsa2"
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * time.Minute)
+	_, err = j.Key("rsa2")
+	c.Assert(err, IsNil)
+	c.Assert(fetches, Equals, 2)
+
+	// the key set expires
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * time.Hour)
+	_, err = j.Key("ec")
+	c.Assert(err, IsNil)
+	c.Assert(fetches, Equals, 3)
+}
+
+func (s *JWKSSuite) TestFetchFailure(c *C) {
+	fail := false
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"keys": [{"kty": "RSA", "kid": "rsa", "n": "AQAB", "e": "AQAB"}]}`))
+	})
+	defer srv.Close()
+
+	j, err := NewJWKS(srv.URL, JWKSClock(s.clock))
+	c.Assert(err, IsNil)
+
+	_, err = j.Key("rsa")
+	c.Assert(err, IsNil)
+
+	// cached keys are used while the endpoint is down
+	fail = true
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * DefaultJWKSTTL)
+	_, err = j.Key("rsa")
+	c.Assert(err, IsNil)
+
+	j, err = NewJWKS(srv.URL, JWKSClock(s.clock))
+	c.Assert(err, IsNil)
+	_, err = j.Key("rsa")
+	c.Assert(err, NotNil)
+}
+
+func (s *JWKSSuite) TestFetchFailureRateLimited(c *C) {
+	fetches := 0
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		fetches++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer srv.Close()
+
+	j, err := NewJWKS(srv.URL, JWKSMinRefresh(time.Minute), JWKSClock(s.clock))
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 3; i++ {
+		_, err = j.Key("rsa")
+		c.Assert(err, NotNil)
+	}
+	c.Assert(fetches, Equals, 1)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * time.Minute)
+	_, err = j.Key("rsa")
+	c.Assert(err, NotNil)
+	c.Assert(fetches, Equals, 2)
+}
+
+func (s *JWKSSuite) TestSlowFetchDoesNotBlockCachedKeys(c *C) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	slow := false
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if slow {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(`{"keys": [{"kty": "RSA", "kid": "rsa", "n": "AQAB", "e": "AQAB"}]}`))
+	})
+	defer srv.Close()
+	// deferred last, so the fetch is released before the server is closed
+	defer close(release)
+
+	j, err := NewJWKS(srv.URL, JWKSClock(s.clock))
+	c.Assert(err, IsNil)
+	_, err = j.Key("rsa")
+	c.Assert(err, IsNil)
+
+	// the token with unknown key id triggers the slow refetch
+	slow = true
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * DefaultJWKSMinRefresh)
+	go j.Key("other")
+	<-started
+
+	_, err = j.Key("rsa")
+	c.Assert(err, IsNil)
+}
+
+func (s *JWKSSuite) TestKeySetTooLarge(c *C) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"keys": [], "padding": "`))
+		w.Write(make([]byte, MaxJWKSBytes))
+	})
+	defer srv.Close()
+
+	j, err := NewJWKS(srv.URL, JWKSClock(s.clock))
+	c.Assert(err, IsNil)
+	_, err = j.Key("rsa")
+	c.Assert(err, ErrorMatches, ".*exceeds.*")
+}
+
+func (s *JWKSSuite) TestBadOptions(c *C) {
+	_, err := NewJWKS("http://localhost", JWKSMinRefresh(0))
+	c.Assert(err, NotNil)
+
+	_, err = NewJWKS("http://localhost", JWKSTTL(-time.Second))
+	c.Assert(err, NotNil)
+}
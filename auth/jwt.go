@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// KeySource returns the public key to verify the token signed with the key id
+type KeySource interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// StaticKeys is a KeySource with a fixed set of keys indexed by the key id
+type StaticKeys map[string]crypto.PublicKey
+
+func (s StaticKeys) Key(kid string) (crypto.PublicKey, error) {
+	k, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%v'", kid)
+	}
+	return k, nil
+}
+
+// JWT authenticates requests with JWT bearer tokens signed with RS256/384/512 or ES256/384/512
+type JWT struct {
+	next http.Handler
+	keys KeySource
+	*settings
+}
+
+// NewJWT returns JWT bearer authentication middleware verifying tokens with the keys from the source, e.g. JWKS
+func NewJWT(next http.Handler, keys KeySource, options ...AuthOption) (*JWT, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("key source can not be nil")
+	}
+	s, err := newSettings(options)
+	if err != nil {
+		return nil, err
+	}
+	return &JWT{next: next, keys: keys, settings: s}, nil
+}
+
+func (j *JWT) Wrap(next http.Handler) {
+	j.next = next
+}
+
+func (j *JWT) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	values := strings.Fields(req.Header.Get(Authorization))
+	if len(values) != 2 || !strings.EqualFold(values[0], "bearer") {
+		j.errHandler.ServeHTTP(w, req, &AuthError{Scheme: "Bearer", Realm: j.realm, Reason: "missing token"})
+		return
+	}
+	claims, err := j.Verify(values[1])
+	if err != nil {
+		j.log.Infof("jwt auth failed: %v", err)
+		j.errHandler.ServeHTTP(w, req, &AuthError{Scheme: "Bearer", Realm: j.realm, Reason: err.Error()})
+		return
+	}
+	j.next.ServeHTTP(w, WithClaims(req, claims))
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token signature and the registered claims and returns the token claims
+func (j *JWT) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	key, err := j.keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (j *JWT) checkClaims(c Claims) error {
+	now := j.clock.UtcNow()
+	if exp, ok := c["exp"].(float64); ok && now.After(unixTime(exp).Add(j.leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := c["nbf"].(float64); ok && now.Add(j.leeway).Before(unixTime(nbf)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if j.issuer != "" && c["iss"] != j.issuer {
+		return fmt.Errorf("unexpected issuer %v", c["iss"])
+	}
+	if j.audience != "" && !hasAudience(c["aud"], j.audience) {
+		return fmt.Errorf("token is not intended for %v", j.audience)
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, expected string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == expected
+	case []interface{}:
+		for _, v := range a {
+			if v == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm '%v'", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm '%v'", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %v", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %v", alg)
+		}
+		bits := k.Curve.Params().BitSize
+		if curveBits[hash] != bits {
+			return fmt.Errorf("key does not match algorithm %v", alg)
+		}
+		size := (bits + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm '%v'", alg)
+}
+
+// curveBits maps ES* hashes to the sizes of the curves they are used with
+var curveBits = map[crypto.Hash]int{
+	crypto.SHA256: 256,
+	crypto.SHA384: 384,
+	crypto.SHA512: 521,
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0).UTC()
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type JWTSuite struct {
+	clock  *timetools.FreezedTime
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	keys   StaticKeys
+}
+
+var _ = Suite(&JWTSuite{})
+
+func (s *JWTSuite) SetUpSuite(c *C) {
+	var err error
+	s.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	s.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	s.keys = StaticKeys{"rsa": &s.rsaKey.PublicKey, "ec": &s.ecKey.PublicKey}
+}
+
+func (s *JWTSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+// sign returns the token with the claims signed by RS256 or ES256 depending on the key id
+func sign(c *C, key crypto.Signer, kid string, claims Claims) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c.Assert(err, IsNil)
+	payload, err := json.Marshal(claims)
+	c.Assert(err, IsNil)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := crypto.SHA256.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+		c.Assert(err, IsNil)
+	case *ecdsa.PrivateKey:
+		r, ss, err := ecdsa.Sign(rand.Reader, k, digest)
+		c.Assert(err, IsNil)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		ss.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (s *JWTSuite) newJWT(c *C) *JWT {
+	j, err := NewJWT(nil, s.keys, Issuer("https://issuer"), Audience("api"), Leeway(time.Second), Clock(s.clock))
+	c.Assert(err, IsNil)
+	return j
+}
+
+func (s *JWTSuite) validClaims() Claims {
+	return Claims{
+		"sub": "alice",
+		"iss": "https://issuer",
+		"aud": []string{"web", "api"},
+		"exp": s.clock.UtcNow().Add(time.Hour).Unix(),
+	}
+}
+
+func (s *JWTSuite) TestVerify(c *C) {
+	j := s.newJWT(c)
+
+	claims, err := j.Verify(sign(c, s.rsaKey, "rsa", s.validClaims()))
+	c.Assert(err, IsNil)
+	c.Assert(claims.Subject(), Equals, "alice")
+
+	claims, err = j.Verify(sign(c, s.ecKey, "ec", s.validClaims()))
+	c.Assert(err, IsNil)
+	c.Assert(claims.Subject(), Equals, "alice")
+}
+
+func (s *JWTSuite) TestRejected(c *C) {
+	j := s.newJWT(c)
+	now := s.clock.UtcNow()
+
+	with := func(k string, v interface{}) Claims {
+		claims := s.validClaims()
+		claims[k] = v
+		return claims
+	}
+	testCases := []string{
+		"garbage",
+		sign(c, s.rsaKey, "ec", s.validClaims()),
+		sign(c, s.rsaKey, "unknown", s.validClaims()),
+		sign(c, s.rsaKey, "rsa", with("exp", now.Add(-2*time.Second).Unix())),
+		sign(c, s.rsaKey, "rsa", with("nbf", now.Add(2*time.Second).Unix())),
+		sign(c, s.rsaKey, "rsa", with("iss", "https://other")),
+		sign(c, s.rsaKey, "rsa", with("aud", "web")),
+	}
+	for i, token := range testCases {
+		_, err := j.Verify(token)
+		c.Assert(err, NotNil, Commentf("test case %d", i))
+	}
+
+	// tampered payload
+	token := sign(c, s.rsaKey, "rsa", s.validClaims())
+	other := sign(c, s.rsaKey, "rsa", with("sub", "mallory"))
+	parts, otherParts := strings.Split(token, "."), strings.Split(other, ".")
+	_, err := j.Verify(parts[0] + "." + otherParts[1] + "." + parts[2])
+	c.Assert(err, NotNil)
+
+	// unsigned token
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa"}`))
+	_, err = j.Verify(header + "." + parts[1] + ".")
+	c.Assert(err, NotNil)
+
+	// leeway covers the clock skew
+	_, err = j.Verify(sign(c, s.rsaKey, "rsa", with("exp", now.Unix())))
+	c.Assert(err, IsNil)
+}
+
+func (s *JWTSuite) TestServeHTTP(c *C) {
+	var sub string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		claims, _ := ClaimsFromRequest(req)
+		sub = claims.Subject()
+	})
+	j := s.newJWT(c)
+	j.Wrap(backend)
+
+	srv := httptest.NewServer(j)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(re.Header.Get(WWWAuthenticate), Equals, `Bearer realm="oxy", error="invalid_token"`)
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header(Authorization, "Bearer "+sign(c, s.rsaKey, "rsa", s.validClaims())))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(sub, Equals, "alice")
+}
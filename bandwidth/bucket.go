@@ -0,0 +1,63 @@
+package bandwidth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+// bucket is a token bucket counting bytes. Consumption is allowed to go into debt, so the caller
+// is delayed in proportion to the amount of bytes it has sent instead of being rejected.
+type bucket struct {
+	mtx sync.Mutex
+	// rate is the number of bytes added every second
+	rate float64
+	// burst is the maximum number of bytes that can accumulate in the bucket
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  timetools.TimeProvider
+}
+
+func newBucket(rate, burst int64, clock timetools.TimeProvider) *bucket {
+	return &bucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.UtcNow(),
+		clock:  clock,
+	}
+}
+
+// take consumes n bytes and returns the time the caller has to wait before sending them
+func (b *bucket) take(n int64) time.Duration {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	now := b.clock.UtcNow()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// checkRate validates the bucket parameters
+func checkRate(rate, burst int64) error {
+	if rate <= 0 {
+		return fmt.Errorf("rate should be > 0, got %d", rate)
+	}
+	if burst <= 0 {
+		return fmt.Errorf("burst should be > 0, got %d", burst)
+	}
+	return nil
+}
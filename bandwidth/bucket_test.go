@@ -0,0 +1,57 @@
+package bandwidth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestBandwidth(t *testing.T) { TestingT(t) }
+
+type BucketSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&BucketSuite{})
+
+func (s *BucketSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *BucketSuite) TestBurst(c *C) {
+	b := newBucket(100, 200, s.clock)
+	c.Assert(b.take(150), Equals, time.Duration(0))
+	c.Assert(b.take(50), Equals, time.Duration(0))
+	c.Assert(b.take(50), Equals, 500*time.Millisecond)
+}
+
+func (s *BucketSuite) TestDebt(c *C) {
+	b := newBucket(100, 100, s.clock)
+	c.Assert(b.take(100), Equals, time.Duration(0))
+	c.Assert(b.take(100), Equals, time.Second)
+	// the debt is accumulated until repaid
+	c.Assert(b.take(100), Equals, 2*time.Second)
+
+	s.clock.Sleep(2 * time.Second)
+	c.Assert(b.take(100), Equals, time.Second)
+}
+
+func (s *BucketSuite) TestRefillUpToBurst(c *C) {
+	b := newBucket(100, 100, s.clock)
+	c.Assert(b.take(100), Equals, time.Duration(0))
+
+	s.clock.Sleep(time.Hour)
+	c.Assert(b.take(100), Equals, time.Duration(0))
+	c.Assert(b.take(10), Equals, 100*time.Millisecond)
+}
+
+func (s *BucketSuite) TestCheckRate(c *C) {
+	c.Assert(checkRate(1, 1), IsNil)
+	c.Assert(checkRate(0, 1), NotNil)
+	c.Assert(checkRate(1, -1), NotNil)
+}
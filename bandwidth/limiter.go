@@ -0,0 +1,342 @@
+// Package bandwidth throttles the bytes sent to and received from the clients per source and globally,
+// so one client downloading or uploading huge files can't saturate the proxy or starve others.
+package bandwidth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+	"github.com/mailgun/ttlmap"
+)
+
+// DefaultCapacity is the default number of sources tracked by the limiter
+const DefaultCapacity = 65536
+
+// minBucketTTL is the minimum time in seconds an idle source bucket is kept
+const minBucketTTL = 60
+
+// Limiter delays the writes of the response body and the reads of the request body
+// once the source or the global rate is exceeded
+type Limiter struct {
+	next    http.Handler
+	extract utils.SourceExtractor
+
+	mtx      sync.Mutex
+	buckets  *ttlmap.TtlMap
+	capacity int
+
+	download limits
+	upload   limits
+
+	clock      timetools.TimeProvider
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+// limits are the rates applied in one direction, zero rate means no limit
+type limits struct {
+	keyRate, keyBurst       int64
+	globalRate, globalBurst int64
+	global                  *bucket
+}
+
+func (ls *limits) setKey(rate, burst int64) error {
+	if err := checkRate(rate, burst); err != nil {
+		return err
+	}
+	ls.keyRate, ls.keyBurst = rate, burst
+	return nil
+}
+
+func (ls *limits) setGlobal(rate, burst int64) error {
+	if err := checkRate(rate, burst); err != nil {
+		return err
+	}
+	ls.globalRate, ls.globalBurst = rate, burst
+	return nil
+}
+
+// bucketTTL returns the expiration in seconds of the idle source bucket, it is full again
+// after burst/rate seconds, so expiring it later loses nothing
+func (ls *limits) bucketTTL() int {
+	if ls.keyRate == 0 {
+		return 0
+	}
+	return int(ls.keyBurst/ls.keyRate) + 1
+}
+
+// sourceBuckets are the per source buckets, nil if the direction has no per key limit
+type sourceBuckets struct {
+	download *bucket
+	upload   *bucket
+}
+
+// LimiterOption is a functional option setter for the Limiter
+type LimiterOption func(l *Limiter) error
+
+// DownloadPerKey limits the bytes per second sent to every source returned by the extractor
+func DownloadPerKey(bytesPerSecond, burst int64) LimiterOption {
+	return func(l *Limiter) error {
+		return l.download.setKey(bytesPerSecond, burst)
+	}
+}
+
+// DownloadGlobal limits the bytes per second sent to all clients
+func DownloadGlobal(bytesPerSecond, burst int64) LimiterOption {
+	return func(l *Limiter) error {
+		return l.download.setGlobal(bytesPerSecond, burst)
+	}
+}
+
+// UploadPerKey limits the bytes per second read from the request bodies of every source returned by the extractor
+func UploadPerKey(bytesPerSecond, burst int64) LimiterOption {
+	return func(l *Limiter) error {
+		return l.upload.setKey(bytesPerSecond, burst)
+	}
+}
+
+// UploadGlobal limits the bytes per second read from the request bodies of all clients
+func UploadGlobal(bytesPerSecond, burst int64) LimiterOption {
+	return func(l *Limiter) error {
+		return l.upload.setGlobal(bytesPerSecond, burst)
+	}
+}
+
+// Capacity sets the maximum number of sources tracked by the limiter
+func Capacity(capacity int) LimiterOption {
+	return func(l *Limiter) error {
+		if capacity <= 0 {
+			return fmt.Errorf("bad capacity: %v", capacity)
+		}
+		l.capacity = capacity
+		return nil
+	}
+}
+
+// Clock sets the time provider, so tests can control the time
+func Clock(clock timetools.TimeProvider) LimiterOption {
+	return func(l *Limiter) error {
+		l.clock = clock
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler called when the source can not be extracted
+func ErrorHandler(h utils.ErrorHandler) LimiterOption {
+	return func(l *Limiter) error {
+		l.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger used by the limiter
+func Logger(log utils.Logger) LimiterOption {
+	return func(l *Limiter) error {
+		l.log = log
+		return nil
+	}
+}
+
+// New returns the bandwidth limiter, extract is required when the per key limit is set
+func New(next http.Handler, extract utils.SourceExtractor, opts ...LimiterOption) (*Limiter, error) {
+	l := &Limiter{
+		next:    next,
+		extract: extract,
+	}
+	for _, o := range opts {
+		if err := o(l); err != nil {
+			return nil, err
+		}
+	}
+	if l.download.keyRate == 0 && l.download.globalRate == 0 && l.upload.keyRate == 0 && l.upload.globalRate == 0 {
+		return nil, fmt.Errorf("provide per key or global limit")
+	}
+	if (l.download.keyRate != 0 || l.upload.keyRate != 0) && l.extract == nil {
+		return nil, fmt.Errorf("per key limit requires source extractor")
+	}
+	if l.clock == nil {
+		l.clock = &timetools.RealTime{}
+	}
+	if l.capacity == 0 {
+		l.capacity = DefaultCapacity
+	}
+	if l.errHandler == nil {
+		l.errHandler = utils.DefaultHandler
+	}
+	if l.log == nil {
+		l.log = utils.NullLogger
+	}
+	buckets, err := ttlmap.NewMapWithProvider(l.capacity, l.clock)
+	if err != nil {
+		return nil, err
+	}
+	l.buckets = buckets
+	for _, ls := range []*limits{&l.download, &l.upload} {
+		if ls.globalRate != 0 {
+			ls.global = newBucket(ls.globalRate, ls.globalBurst, l.clock)
+		}
+	}
+	return l, nil
+}
+
+// Wrap sets the next handler to be called by the limiter
+func (l *Limiter) Wrap(next http.Handler) {
+	l.next = next
+}
+
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var sb sourceBuckets
+	if l.download.keyRate != 0 || l.upload.keyRate != 0 {
+		source, _, err := l.extract.Extract(req)
+		if err != nil {
+			l.log.Warningf("failed to extract source of %v %v: %v", req.Method, req.URL, err)
+			l.errHandler.ServeHTTP(w, req, err)
+			return
+		}
+		sb = l.sourceBuckets(source)
+	}
+	if t := newThrottle(req, l.clock, sb.upload, l.upload.global); t != nil && req.Body != nil && req.Body != http.NoBody {
+		// shallow copy of the request to avoid side effects on the handlers in front of the limiter
+		outReq := *req
+		outReq.Body = &throttledReader{ReadCloser: req.Body, t: t}
+		req = &outReq
+	}
+	if t := newThrottle(req, l.clock, sb.download, l.download.global); t != nil {
+		w = &throttledWriter{ResponseWriter: w, t: t}
+	}
+	l.next.ServeHTTP(w, req)
+}
+
+func (l *Limiter) sourceBuckets(source string) sourceBuckets {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	ttl := l.download.bucketTTL()
+	if up := l.upload.bucketTTL(); up > ttl {
+		ttl = up
+	}
+	if ttl < minBucketTTL {
+		ttl = minBucketTTL
+	}
+	var sb *sourceBuckets
+	if val, ok := l.buckets.Get(source); ok {
+		sb = val.(*sourceBuckets)
+	} else {
+		sb = &sourceBuckets{}
+		if l.download.keyRate != 0 {
+			sb.download = newBucket(l.download.keyRate, l.download.keyBurst, l.clock)
+		}
+		if l.upload.keyRate != 0 {
+			sb.upload = newBucket(l.upload.keyRate, l.upload.keyBurst, l.clock)
+		}
+	}
+	// refresh the expiration on every request
+	l.buckets.Set(source, sb, ttl)
+	return *sb
+}
+
+// throttle splits the transfers into chunks no larger than the smallest burst
+// and waits until every bucket has enough tokens for the chunk
+type throttle struct {
+	req     *http.Request
+	buckets []*bucket
+	chunk   int
+	clock   timetools.TimeProvider
+}
+
+// newThrottle returns throttle for the non nil buckets or nil if there are none
+func newThrottle(req *http.Request, clock timetools.TimeProvider, buckets ...*bucket) *throttle {
+	t := &throttle{req: req, clock: clock}
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		t.buckets = append(t.buckets, b)
+		if t.chunk == 0 || int(b.burst) < t.chunk {
+			t.chunk = int(b.burst)
+		}
+	}
+	if len(t.buckets) == 0 {
+		return nil
+	}
+	return t
+}
+
+func (t *throttle) wait(n int64) error {
+	var delay time.Duration
+	for _, b := range t.buckets {
+		if d := b.take(n); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-t.clock.After(delay):
+		return nil
+	case <-t.req.Context().Done():
+		return t.req.Context().Err()
+	}
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	t *throttle
+}
+
+func (tw *throttledWriter) Write(buf []byte) (int, error) {
+	written := 0
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > tw.t.chunk {
+			n = tw.t.chunk
+		}
+		if err := tw.t.wait(int64(n)); err != nil {
+			return written, err
+		}
+		w, err := tw.ResponseWriter.Write(buf[:n])
+		written += w
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+func (tw *throttledWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// throttledReader delays the reads of the request body, the chunk is read first
+// and the caller waits before it gets it
+type throttledReader struct {
+	io.ReadCloser
+	t *throttle
+}
+
+func (tr *throttledReader) Read(buf []byte) (int, error) {
+	if len(buf) > tr.t.chunk {
+		buf = buf[:tr.t.chunk]
+	}
+	n, err := tr.ReadCloser.Read(buf)
+	if n > 0 {
+		if werr := tr.t.wait(int64(n)); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
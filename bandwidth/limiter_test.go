@@ -0,0 +1,216 @@
+package bandwidth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type LimiterSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&LimiterSuite{})
+
+func (s *LimiterSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func headerExtractor() utils.SourceExtractor {
+	return utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+		return req.Header.Get("Source"), 1, nil
+	})
+}
+
+func bodyHandler(size int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(strings.Repeat("a", size)))
+	})
+}
+
+// serve runs the request through the limiter and returns the time it took according to the clock
+func (s *LimiterSuite) serve(c *C, l *Limiter, source string) time.Duration {
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Source", source)
+	w := httptest.NewRecorder()
+	start := s.clock.UtcNow()
+	l.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	return s.clock.UtcNow().Sub(start)
+}
+
+func (s *LimiterSuite) TestPerKey(c *C) {
+	l, err := New(bodyHandler(300), headerExtractor(), DownloadPerKey(100, 100), Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.serve(c, l, "a"), Equals, 2*time.Second)
+	// the other source has own bucket
+	c.Assert(s.serve(c, l, "b"), Equals, 2*time.Second)
+	// bucket of a has been refilled by the time spent on b
+	c.Assert(s.serve(c, l, "a"), Equals, 2*time.Second)
+	c.Assert(s.serve(c, l, "a"), Equals, 3*time.Second)
+}
+
+func (s *LimiterSuite) TestGlobal(c *C) {
+	l, err := New(bodyHandler(300), nil, DownloadGlobal(100, 300), Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.serve(c, l, "a"), Equals, time.Duration(0))
+	c.Assert(s.serve(c, l, "b"), Equals, 3*time.Second)
+}
+
+func (s *LimiterSuite) TestPerKeyAndGlobal(c *C) {
+	l, err := New(bodyHandler(200), headerExtractor(), DownloadPerKey(100, 200), DownloadGlobal(50, 200), Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.serve(c, l, "a"), Equals, time.Duration(0))
+	// the global limit is the slowest one
+	c.Assert(s.serve(c, l, "b"), Equals, 4*time.Second)
+}
+
+// upload sends the body through the limiter and returns the time it took according to the clock
+func (s *LimiterSuite) upload(c *C, l *Limiter, source string, size int) time.Duration {
+	req := httptest.NewRequest("POST", "http://localhost", strings.NewReader(strings.Repeat("a", size)))
+	req.Header.Set("Source", source)
+	w := httptest.NewRecorder()
+	start := s.clock.UtcNow()
+	l.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.Len(), Equals, size)
+	return s.clock.UtcNow().Sub(start)
+}
+
+func echoHandler(c *C) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		c.Assert(err, IsNil)
+		w.Write(body)
+	})
+}
+
+func (s *LimiterSuite) TestUploadPerKey(c *C) {
+	l, err := New(echoHandler(c), headerExtractor(), UploadPerKey(100, 100), Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.upload(c, l, "a", 300), Equals, 2*time.Second)
+	c.Assert(s.upload(c, l, "b", 100), Equals, time.Duration(0))
+	// downloads are not limited
+	c.Assert(s.serve(c, l, "a"), Equals, time.Duration(0))
+}
+
+func (s *LimiterSuite) TestUploadGlobal(c *C) {
+	l, err := New(echoHandler(c), nil, UploadGlobal(100, 200), Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.upload(c, l, "a", 200), Equals, time.Duration(0))
+	c.Assert(s.upload(c, l, "b", 200), Equals, 2*time.Second)
+}
+
+func (s *LimiterSuite) TestUploadAndDownload(c *C) {
+	l, err := New(echoHandler(c), headerExtractor(), UploadPerKey(100, 100), DownloadPerKey(50, 100), Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	// 2 seconds to receive and 4 seconds to send the body back
+	c.Assert(s.upload(c, l, "a", 300), Equals, 6*time.Second)
+}
+
+func (s *LimiterSuite) TestCanceled(c *C) {
+	l, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := w.Write([]byte(strings.Repeat("a", 300)))
+		c.Assert(err, Equals, context.Canceled)
+	}), nil, DownloadGlobal(1, 100))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+	c.Assert(w.Body.Len(), Equals, 100)
+}
+
+func (s *LimiterSuite) TestExtractorError(c *C) {
+	extract := utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+		return "", 0, context.DeadlineExceeded
+	})
+	l, err := New(bodyHandler(10), extract, DownloadPerKey(100, 100))
+	c.Assert(err, IsNil)
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+	c.Assert(w.Code, Not(Equals), http.StatusOK)
+}
+
+func (s *LimiterSuite) TestResponseController(c *C) {
+	var deadlineErr error
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deadlineErr = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute))
+		w.Write([]byte("hello"))
+	})
+	l, err := New(handler, nil, DownloadGlobal(1000000, 1000000))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	_, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	// the throttled writer passes the deadlines to the connection
+	c.Assert(deadlineErr, IsNil)
+}
+
+func (s *LimiterSuite) TestBadOptions(c *C) {
+	_, err := New(bodyHandler(10), nil)
+	c.Assert(err, NotNil)
+
+	_, err = New(bodyHandler(10), nil, DownloadPerKey(100, 100))
+	c.Assert(err, NotNil)
+
+	_, err = New(bodyHandler(10), nil, UploadPerKey(100, 100))
+	c.Assert(err, NotNil)
+
+	_, err = New(bodyHandler(10), nil, UploadGlobal(100, 0))
+	c.Assert(err, NotNil)
+
+	_, err = New(bodyHandler(10), nil, DownloadGlobal(0, 100))
+	c.Assert(err, NotNil)
+
+	_, err = New(bodyHandler(10), nil, DownloadGlobal(100, 100), Capacity(-1))
+	c.Assert(err, NotNil)
+}
+
+func (s *LimiterSuite) TestBlocksOnTestClock(c *C) {
+	clock := testutils.NewClock()
+	l, err := New(bodyHandler(200), headerExtractor(), DownloadPerKey(100, 100), Clock(clock))
+	c.Assert(err, IsNil)
+
+	done := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Source", "a")
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		done <- w.Code
+	}()
+
+	// the response waits for the bucket to refill until the time is advanced
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		c.Fatal("response was not throttled")
+	default:
+	}
+	clock.Advance(time.Second)
+	c.Assert(<-done, Equals, http.StatusOK)
+}
@@ -0,0 +1,288 @@
+// Package bench runs the reproducible load scenarios against the forwarder behind the round robin load balancer
+// and reports the machine-readable reports, so the performance of the changes is evaluated consistently.
+//
+//	reports, _ := bench.MeasureAll(ctx, bench.DefaultScenarios())
+//	bench.WriteReports(os.Stdout, reports)
+//
+//	// in CI, against the reports saved from the main branch
+//	baseline, _ := bench.ReadReports(file)
+//	for _, r := range bench.Compare(baseline, reports, 0.1) {
+//		fmt.Println(r)
+//	}
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/memmetrics"
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/testutils"
+)
+
+// Scenario is the load sent through the proxy
+type Scenario struct {
+	Name string `json:"name"`
+	// KeepAlive reuses the client connections to the proxy, every request opens the new one otherwise
+	KeepAlive bool `json:"keep_alive"`
+	// BodyBytes is the size of the response body
+	BodyBytes int64 `json:"body_bytes"`
+	// Streaming sends the body in the flushed chunks of ChunkBytes without the content length
+	Streaming bool `json:"streaming"`
+	// Backends is the number of the backends the load balancer distributes the requests to
+	Backends int `json:"backends"`
+	// Concurrency is the number of the clients sending the requests in parallel
+	Concurrency int `json:"concurrency"`
+	// Requests is the total number of the requests sent
+	Requests int `json:"requests"`
+}
+
+// ChunkBytes is the size of the chunks of the streaming bodies
+const ChunkBytes = 4096
+
+func (s Scenario) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("scenario name can not be empty")
+	}
+	if s.BodyBytes < 0 || s.Backends <= 0 || s.Concurrency <= 0 || s.Requests <= 0 {
+		return fmt.Errorf("scenario %v should have >= 0 body bytes and > 0 backends, concurrency and requests", s.Name)
+	}
+	return nil
+}
+
+// DefaultScenarios are the combinations of the keep-alive and the new connections, the small and the streaming
+// bodies, one and 100 backends
+func DefaultScenarios() []Scenario {
+	var out []Scenario
+	for _, keepAlive := range []bool{true, false} {
+		for _, streaming := range []bool{false, true} {
+			for _, backends := range []int{1, 100} {
+				s := Scenario{KeepAlive: keepAlive, Streaming: streaming, Backends: backends, Concurrency: 16, Requests: 5000, BodyBytes: 512}
+				conns, body := "keepalive", "small"
+				if !keepAlive {
+					conns = "newconn"
+				}
+				if streaming {
+					body, s.BodyBytes = "streaming", 256<<10
+				}
+				s.Name = fmt.Sprintf("%s-%s-%dbackends", conns, body, backends)
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// Report is the outcome of the scenario measured
+type Report struct {
+	Scenario Scenario      `json:"scenario"`
+	Requests int64         `json:"requests"`
+	Errors   int64         `json:"errors"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	RPS      float64       `json:"rps"`
+	// LatencyMs maps the quantiles, e.g. "99.9", to the latencies in milliseconds, see memmetrics.SnapshotQuantiles
+	LatencyMs map[string]float64 `json:"latency_ms"`
+}
+
+// Middleware wraps the load balancer into the middlewares of the chain benchmarked
+type Middleware func(next http.Handler) (http.Handler, error)
+
+// MeasureAll runs the scenarios one by one, stopping at the first failure
+func MeasureAll(ctx context.Context, scenarios []Scenario, middlewares ...Middleware) ([]Report, error) {
+	out := make([]Report, 0, len(scenarios))
+	for _, s := range scenarios {
+		r, err := Measure(ctx, s, middlewares...)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Measure sends the requests of the scenario through the middlewares, the load balancer and the forwarder
+// to the local backends. The failed requests are counted as errors, the error is returned if the scenario
+// can't be run or the context is done.
+func Measure(ctx context.Context, s Scenario, middlewares ...Middleware) (Report, error) {
+	if err := s.validate(); err != nil {
+		return Report{}, err
+	}
+	backends := make([]*httptest.Server, s.Backends)
+	for i := range backends {
+		backends[i] = httptest.NewServer(backend(s))
+		defer backends[i].Close()
+	}
+	// the proxy keeps the connections to all the backends, only the client connections vary
+	transport := &http.Transport{MaxIdleConnsPerHost: s.Concurrency}
+	defer transport.CloseIdleConnections()
+	fwd, err := forward.New(forward.RoundTripper(transport))
+	if err != nil {
+		return Report{}, err
+	}
+	lb, err := roundrobin.New(fwd)
+	if err != nil {
+		return Report{}, err
+	}
+	for _, b := range backends {
+		if err := lb.UpsertServer(testutils.ParseURI(b.URL)); err != nil {
+			return Report{}, err
+		}
+	}
+	var handler http.Handler = lb
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if handler, err = middlewares[i](handler); err != nil {
+			return Report{}, err
+		}
+	}
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: !s.KeepAlive, MaxIdleConnsPerHost: s.Concurrency}}
+	defer client.Transport.(*http.Transport).CloseIdleConnections()
+	return load(ctx, s, client, proxy.URL)
+}
+
+// load sends the requests from the concurrent clients, every client keeps its own histogram
+func load(ctx context.Context, s Scenario, client *http.Client, url string) (Report, error) {
+	out := Report{Scenario: s, LatencyMs: make(map[string]float64, len(memmetrics.SnapshotQuantiles))}
+	merged, err := newHistogram()
+	if err != nil {
+		return out, err
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	left := int64(s.Requests)
+	start := time.Now()
+	for i := 0; i < s.Concurrency; i++ {
+		h, err := newHistogram()
+		if err != nil {
+			return out, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var requests, errors, bytes int64
+			for atomic.AddInt64(&left, -1) >= 0 && ctx.Err() == nil {
+				d, n, err := get(ctx, client, url)
+				requests++
+				bytes += n
+				if err != nil {
+					errors++
+					continue
+				}
+				h.RecordLatencies(d, 1)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			out.Requests += requests
+			out.Errors += errors
+			out.Bytes += bytes
+			merged.Merge(h)
+		}()
+	}
+	wg.Wait()
+	out.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return out, err
+	}
+	if out.Duration > 0 {
+		out.RPS = float64(out.Requests) / out.Duration.Seconds()
+	}
+	for _, q := range memmetrics.SnapshotQuantiles {
+		out.LatencyMs[strconv.FormatFloat(q, 'f', -1, 64)] = float64(merged.LatencyAtQuantile(q)) / float64(time.Millisecond)
+	}
+	return out, nil
+}
+
+// newHistogram returns the histogram of the latencies up to a minute in microseconds
+func newHistogram() (*memmetrics.HDRHistogram, error) {
+	return memmetrics.NewHDRHistogram(1, int64(time.Minute/time.Microsecond), 2)
+}
+
+func get(ctx context.Context, client *http.Client, url string) (time.Duration, int64, error) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	re, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer re.Body.Close()
+	n, err := io.Copy(ioutil.Discard, re.Body)
+	if err != nil {
+		return 0, n, err
+	}
+	if re.StatusCode != http.StatusOK {
+		return 0, n, fmt.Errorf("unexpected status code %d", re.StatusCode)
+	}
+	return time.Since(start), n, nil
+}
+
+// backend serves the body of the scenario
+func backend(s Scenario) http.Handler {
+	body := bytes.Repeat([]byte("a"), int(s.BodyBytes))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !s.Streaming {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+			return
+		}
+		f, _ := w.(http.Flusher)
+		for rest := body; len(rest) > 0; {
+			n := ChunkBytes
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				return
+			}
+			if f != nil {
+				f.Flush()
+			}
+			rest = rest[n:]
+		}
+	})
+}
+
+// WriteReports writes the reports as JSON lines, see ReadReports
+func WriteReports(w io.Writer, reports []Report) error {
+	enc := json.NewEncoder(w)
+	for _, r := range reports {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadReports reads the reports written by WriteReports
+func ReadReports(r io.Reader) ([]Report, error) {
+	var out []Report
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var res Report
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			return nil, fmt.Errorf("failed to parse report %d: %v", len(out)+1, err)
+		}
+		out = append(out, res)
+	}
+	return out, scanner.Err()
+}
@@ -0,0 +1,97 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestBench(t *testing.T) { TestingT(t) }
+
+type BenchSuite struct{}
+
+var _ = Suite(&BenchSuite{})
+
+func (s *BenchSuite) TestMeasure(c *C) {
+	var seen int64
+	counting := func(next http.Handler) (http.Handler, error) {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt64(&seen, 1)
+			next.ServeHTTP(w, req)
+		}), nil
+	}
+	for _, sc := range []Scenario{
+		{Name: "small", KeepAlive: true, BodyBytes: 100, Backends: 3, Concurrency: 4, Requests: 40},
+		{Name: "streaming", Streaming: true, BodyBytes: 3*ChunkBytes + 1, Backends: 1, Concurrency: 2, Requests: 10},
+	} {
+		atomic.StoreInt64(&seen, 0)
+		r, err := Measure(context.Background(), sc, counting)
+		c.Assert(err, IsNil)
+		c.Assert(r.Scenario, Equals, sc)
+		c.Assert(r.Requests, Equals, int64(sc.Requests))
+		c.Assert(r.Errors, Equals, int64(0))
+		c.Assert(r.Bytes, Equals, int64(sc.Requests)*sc.BodyBytes)
+		c.Assert(r.RPS > 0, Equals, true)
+		c.Assert(r.LatencyMs["99"] > 0, Equals, true)
+		c.Assert(atomic.LoadInt64(&seen), Equals, int64(sc.Requests))
+	}
+}
+
+func (s *BenchSuite) TestInvalid(c *C) {
+	_, err := Measure(context.Background(), Scenario{Name: "none", Backends: 1, Concurrency: 1})
+	c.Assert(err, NotNil)
+	_, err = Measure(context.Background(), Scenario{Backends: 1, Concurrency: 1, Requests: 1})
+	c.Assert(err, NotNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Measure(ctx, Scenario{Name: "canceled", Backends: 1, Concurrency: 1, Requests: 1})
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *BenchSuite) TestDefaultScenarios(c *C) {
+	names := make(map[string]bool)
+	for _, sc := range DefaultScenarios() {
+		c.Assert(sc.validate(), IsNil)
+		names[sc.Name] = true
+	}
+	c.Assert(len(names), Equals, 8)
+	c.Assert(names["newconn-streaming-100backends"], Equals, true)
+}
+
+func (s *BenchSuite) TestReports(c *C) {
+	results := []Report{
+		{Scenario: Scenario{Name: "a", Backends: 1}, Requests: 10, RPS: 100, LatencyMs: map[string]float64{"99": 1.5}},
+		{Scenario: Scenario{Name: "b", Streaming: true}, Requests: 5, Errors: 1},
+	}
+	var buf bytes.Buffer
+	c.Assert(WriteReports(&buf, results), IsNil)
+	read, err := ReadReports(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, results)
+
+	_, err = ReadReports(bytes.NewBufferString("{"))
+	c.Assert(err, NotNil)
+}
+
+// BenchmarkScenarios runs the default scenarios with the requests of the benchmark, e.g.
+//
+//	go test ./bench -run XXX -bench Scenarios -benchtime 10000x
+func BenchmarkScenarios(b *testing.B) {
+	for _, sc := range DefaultScenarios() {
+		sc := sc
+		b.Run(sc.Name, func(b *testing.B) {
+			sc.Requests = b.N
+			r, err := Measure(context.Background(), sc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(r.RPS, "rps")
+			b.ReportMetric(r.LatencyMs["99"], "p99-ms")
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package bench
+
+import "fmt"
+
+// Regression is the metric of the scenario worse than the baseline by more than the tolerance
+type Regression struct {
+	Scenario string  `json:"scenario"`
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+}
+
+// Change is the relative change of the metric, e.g. 0.2 for 20% worse latency, -0.2 for 20% lower RPS
+func (r Regression) Change() float64 {
+	if r.Baseline == 0 {
+		return 0
+	}
+	return (r.Current - r.Baseline) / r.Baseline
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%v: %v %.2f -> %.2f (%+.1f%%)", r.Scenario, r.Metric, r.Baseline, r.Current, r.Change()*100)
+}
+
+// ComparedQuantile is the latency quantile compared by Compare
+const ComparedQuantile = "99"
+
+// Compare returns the regressions of the current reports against the baseline ones of the same scenarios:
+// the RPS lower, the latency at ComparedQuantile or the error ratio higher by more than the tolerance,
+// e.g. 0.1 for 10%. The scenarios missing from either reports are skipped.
+func Compare(baseline, current []Report, tolerance float64) []Regression {
+	base := make(map[string]Report, len(baseline))
+	for _, r := range baseline {
+		base[r.Scenario.Name] = r
+	}
+	var out []Regression
+	for _, c := range current {
+		b, ok := base[c.Scenario.Name]
+		if !ok {
+			continue
+		}
+		name := c.Scenario.Name
+		if c.RPS < b.RPS*(1-tolerance) {
+			out = append(out, Regression{Scenario: name, Metric: "rps", Baseline: b.RPS, Current: c.RPS})
+		}
+		metric := "latency_ms_p" + ComparedQuantile
+		if bl, cl := b.LatencyMs[ComparedQuantile], c.LatencyMs[ComparedQuantile]; cl > bl*(1+tolerance) {
+			out = append(out, Regression{Scenario: name, Metric: metric, Baseline: bl, Current: cl})
+		}
+		if be, ce := errorRatio(b), errorRatio(c); ce > be*(1+tolerance) {
+			out = append(out, Regression{Scenario: name, Metric: "error_ratio", Baseline: be, Current: ce})
+		}
+	}
+	return out
+}
+
+func errorRatio(r Report) float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
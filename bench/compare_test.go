@@ -0,0 +1,27 @@
+package bench
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type CompareSuite struct{}
+
+var _ = Suite(&CompareSuite{})
+
+func (s *CompareSuite) TestCompare(c *C) {
+	result := func(name string, rps, p99 float64, errors int64) Report {
+		return Report{Scenario: Scenario{Name: name}, Requests: 100, Errors: errors, RPS: rps, LatencyMs: map[string]float64{"99": p99}}
+	}
+	baseline := []Report{result("a", 1000, 10, 0), result("b", 1000, 10, 0), result("gone", 1, 1, 0)}
+	current := []Report{result("a", 950, 10.5, 0), result("b", 800, 20, 5), result("new", 1, 100, 50)}
+
+	regressions := Compare(baseline, current, 0.1)
+	c.Assert(regressions, DeepEquals, []Regression{
+		{Scenario: "b", Metric: "rps", Baseline: 1000, Current: 800},
+		{Scenario: "b", Metric: "latency_ms_p99", Baseline: 10, Current: 20},
+		{Scenario: "b", Metric: "error_ratio", Baseline: 0, Current: 0.05},
+	})
+	c.Assert(regressions[0].Change(), Equals, -0.2)
+	c.Assert(regressions[1].String(), Equals, "b: latency_ms_p99 10.00 -> 20.00 (+100.0%)")
+	c.Assert(regressions[2].Change(), Equals, 0.0)
+}
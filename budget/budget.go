@@ -0,0 +1,156 @@
+// Package budget provides middleware propagating the latency budget of the request down the chain.
+//
+// The budget is taken from the incoming header set by the caller, e.g. X-Request-Budget-Ms, or from the
+// server configured default, and is attached to the request context as the deadline, so every downstream
+// middleware and the forwarder observe the same remaining time.
+//
+//	// give the requests 2 seconds unless the caller has less time to spend
+//	b, _ := budget.New(fwd, budget.Header(budget.XRequestBudgetMs), budget.Default(2*time.Second))
+package budget
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// XRequestBudgetMs is the conventional header carrying the remaining budget of the request in milliseconds
+const XRequestBudgetMs = "X-Request-Budget-Ms"
+
+// Budget attaches the deadline computed from the latency budget to the requests
+type Budget struct {
+	next       http.Handler
+	header     string
+	budget     time.Duration
+	max        time.Duration
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+// BudgetOption is a functional option setter for Budget
+type BudgetOption func(b *Budget) error
+
+// Header sets the request header carrying the remaining budget in milliseconds. The header
+// is consumed by the middleware and not passed downstream.
+func Header(name string) BudgetOption {
+	return func(b *Budget) error {
+		if name == "" {
+			return fmt.Errorf("header name can not be empty")
+		}
+		b.header = http.CanonicalHeaderKey(name)
+		return nil
+	}
+}
+
+// Default sets the budget of the requests coming without the header
+func Default(d time.Duration) BudgetOption {
+	return func(b *Budget) error {
+		if d <= 0 {
+			return fmt.Errorf("budget should be > 0, got %v", d)
+		}
+		b.budget = d
+		return nil
+	}
+}
+
+// Max clamps the budgets requested by the callers
+func Max(d time.Duration) BudgetOption {
+	return func(b *Budget) error {
+		if d <= 0 {
+			return fmt.Errorf("max budget should be > 0, got %v", d)
+		}
+		b.max = d
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler of the requests arriving with the budget exhausted, 504 by default
+func ErrorHandler(h utils.ErrorHandler) BudgetOption {
+	return func(b *Budget) error {
+		b.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger used by this middleware
+func Logger(l utils.Logger) BudgetOption {
+	return func(b *Budget) error {
+		b.log = l
+		return nil
+	}
+}
+
+// New returns the middleware attaching the budget deadline to the requests, one of
+// the Header or Default options is required
+func New(next http.Handler, opts ...BudgetOption) (*Budget, error) {
+	b := &Budget{next: next}
+	for _, o := range opts {
+		if err := o(b); err != nil {
+			return nil, err
+		}
+	}
+	if b.header == "" && b.budget == 0 {
+		return nil, fmt.Errorf("either the budget header or the default budget should be set")
+	}
+	if b.errHandler == nil {
+		b.errHandler = utils.DefaultHandler
+	}
+	if b.log == nil {
+		b.log = utils.NullLogger
+	}
+	return b, nil
+}
+
+func (b *Budget) Wrap(next http.Handler) {
+	b.next = next
+}
+
+func (b *Budget) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d, ok := b.requestBudget(req)
+	if !ok {
+		b.next.ServeHTTP(w, req)
+		return
+	}
+	if d <= 0 {
+		b.log.Infof("rejecting %v %v with the budget exhausted", req.Method, req.URL)
+		b.errHandler.ServeHTTP(w, req, context.DeadlineExceeded)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	defer cancel()
+	b.next.ServeHTTP(w, req.WithContext(ctx))
+}
+
+// requestBudget returns the budget of the request, the header is removed as the forwarder
+// propagates the remaining budget on its own
+func (b *Budget) requestBudget(req *http.Request) (time.Duration, bool) {
+	d, ok := b.budget, b.budget > 0
+	if b.header != "" {
+		if val := req.Header.Get(b.header); val != "" {
+			req.Header.Del(b.header)
+			ms, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				b.log.Warningf("ignoring invalid %v: '%v'", b.header, val)
+			} else {
+				d, ok = time.Duration(ms)*time.Millisecond, true
+			}
+		}
+	}
+	if ok && b.max > 0 && d > b.max {
+		d = b.max
+	}
+	return d, ok
+}
+
+// Remaining returns the time left until the deadline of the context, false if there is no deadline
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
@@ -0,0 +1,104 @@
+package budget
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestBudget(t *testing.T) { TestingT(t) }
+
+type BudgetSuite struct{}
+
+var _ = Suite(&BudgetSuite{})
+
+// serve returns the status code and the remaining budget seen by the next handler
+func serve(b *Budget, header string) (int, time.Duration, bool) {
+	var remaining time.Duration
+	var ok bool
+	b.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(XRequestBudgetMs) != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		remaining, ok = Remaining(req.Context())
+	}))
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	if header != "" {
+		req.Header.Set(XRequestBudgetMs, header)
+	}
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	return w.Code, remaining, ok
+}
+
+func (s *BudgetSuite) TestHeader(c *C) {
+	b, err := New(nil, Header(XRequestBudgetMs))
+	c.Assert(err, IsNil)
+
+	code, remaining, ok := serve(b, "500")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(ok, Equals, true)
+	c.Assert(remaining > 400*time.Millisecond && remaining <= 500*time.Millisecond, Equals, true)
+
+	// no budget, no deadline
+	code, _, ok = serve(b, "")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(ok, Equals, false)
+
+	// invalid values are ignored
+	code, _, ok = serve(b, "soon")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(ok, Equals, false)
+
+	// the exhausted budget is rejected
+	code, _, _ = serve(b, "0")
+	c.Assert(code, Equals, http.StatusGatewayTimeout)
+	code, _, _ = serve(b, "-10")
+	c.Assert(code, Equals, http.StatusGatewayTimeout)
+}
+
+func (s *BudgetSuite) TestDefaultAndMax(c *C) {
+	b, err := New(nil, Header(XRequestBudgetMs), Default(time.Second), Max(2*time.Second))
+	c.Assert(err, IsNil)
+
+	_, remaining, ok := serve(b, "")
+	c.Assert(ok, Equals, true)
+	c.Assert(remaining > 900*time.Millisecond && remaining <= time.Second, Equals, true)
+
+	// the caller can ask for more, up to the max
+	_, remaining, _ = serve(b, "1500")
+	c.Assert(remaining > time.Second && remaining <= 1500*time.Millisecond, Equals, true)
+	_, remaining, _ = serve(b, "60000")
+	c.Assert(remaining > time.Second && remaining <= 2*time.Second, Equals, true)
+}
+
+func (s *BudgetSuite) TestIncomingDeadline(c *C) {
+	b, err := New(nil, Default(time.Minute))
+	c.Assert(err, IsNil)
+
+	// the budget can not extend the deadline already set
+	var remaining time.Duration
+	b.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		remaining, _ = Remaining(req.Context())
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx))
+	c.Assert(remaining <= time.Second, Equals, true)
+}
+
+func (s *BudgetSuite) TestBadOptions(c *C) {
+	_, err := New(nil)
+	c.Assert(err, NotNil)
+	_, err = New(nil, Header(""))
+	c.Assert(err, NotNil)
+	_, err = New(nil, Default(0))
+	c.Assert(err, NotNil)
+	_, err = New(nil, Default(time.Second), Max(-1))
+	c.Assert(err, NotNil)
+}
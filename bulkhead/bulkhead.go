@@ -0,0 +1,295 @@
+// package bulkhead isolates the concurrency of the routes or tenants in the named pools, so a slow pool
+// can not take up all the handler goroutines and the backend connections
+package bulkhead
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Limits of the pool
+type Limits struct {
+	// MaxInFlight is the maximum of the requests of the pool served concurrently
+	MaxInFlight int
+	// MaxQueued is the maximum of the requests waiting for the pool, the requests are rejected
+	// right away once the pool is full if it's 0
+	MaxQueued int
+	// QueueTimeout is the longest time the request waits in the queue, unlimited if 0
+	QueueTimeout time.Duration
+}
+
+// Stats of the pool
+type Stats struct {
+	InFlight int
+	Queued   int
+	// Rejected is the amount of the requests rejected since the pool was created
+	Rejected int64
+}
+
+// Bulkhead partitions the requests into the pools by the tokens of the extractor, e.g. the route or the tenant,
+// each pool has its own limits of the requests served and queued. The requests of the pools
+// without the limits pass unlimited.
+type Bulkhead struct {
+	mutex    sync.Mutex
+	extract  utils.SourceExtractor
+	limits   map[string]Limits
+	defaults *Limits
+	pools    map[string]*pool
+	next     http.Handler
+
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+type pool struct {
+	limits   Limits
+	inFlight int
+	// queue holds the waiting requests in the order of arrival, the slot is handed over by closing the channel
+	queue    []chan struct{}
+	rejected int64
+	// configured pools are kept when idle, the ones made from the defaults are dropped
+	configured bool
+}
+
+// New returns the bulkhead extracting the pools of the requests with extract
+func New(next http.Handler, extract utils.SourceExtractor, options ...BulkheadOption) (*Bulkhead, error) {
+	if extract == nil {
+		return nil, fmt.Errorf("extract function can not be nil")
+	}
+	b := &Bulkhead{
+		extract: extract,
+		limits:  make(map[string]Limits),
+		pools:   make(map[string]*pool),
+		next:    next,
+	}
+	for _, o := range options {
+		if err := o(b); err != nil {
+			return nil, err
+		}
+	}
+	if b.log == nil {
+		b.log = utils.NullLogger
+	}
+	if b.errHandler == nil {
+		b.errHandler = defaultErrHandler
+	}
+	for name, l := range b.limits {
+		b.pools[name] = &pool{limits: l, configured: true}
+	}
+	return b, nil
+}
+
+func (b *Bulkhead) Wrap(next http.Handler) {
+	b.next = next
+}
+
+// Stats returns a snapshot of the pools with the requests in flight or queued and the configured ones
+func (b *Bulkhead) Stats() map[string]Stats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	out := make(map[string]Stats, len(b.pools))
+	for name, p := range b.pools {
+		out[name] = Stats{InFlight: p.inFlight, Queued: len(p.queue), Rejected: p.rejected}
+	}
+	return out
+}
+
+func (b *Bulkhead) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name, _, err := b.extract.Extract(req)
+	if err != nil {
+		b.log.Errorf("failed to extract pool of the request: %v", err)
+		b.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	p, err := b.acquire(req, name)
+	if err != nil {
+		b.log.Infof("rejecting request of pool %s: %v", name, err)
+		b.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	if p != nil {
+		defer b.release(name, p)
+	}
+	b.next.ServeHTTP(w, req)
+}
+
+// acquire takes the slot of the pool, waiting in the queue if the pool is full,
+// it returns nil pool for the requests passing unlimited
+func (b *Bulkhead) acquire(req *http.Request, name string) (*pool, error) {
+	b.mutex.Lock()
+	p := b.pools[name]
+	if p == nil {
+		if b.defaults == nil {
+			b.mutex.Unlock()
+			return nil, nil
+		}
+		p = &pool{limits: *b.defaults}
+		b.pools[name] = p
+	}
+	if p.inFlight < p.limits.MaxInFlight {
+		p.inFlight++
+		b.mutex.Unlock()
+		return p, nil
+	}
+	if len(p.queue) >= p.limits.MaxQueued {
+		p.rejected++
+		b.mutex.Unlock()
+		return nil, &PoolFullError{Pool: name, limits: p.limits}
+	}
+	ready := make(chan struct{})
+	p.queue = append(p.queue, ready)
+	b.mutex.Unlock()
+
+	start := time.Now()
+	defer func() {
+		utils.AddTiming(req, utils.TimingQueue, time.Since(start))
+	}()
+	var timeout <-chan time.Time
+	if p.limits.QueueTimeout > 0 {
+		t := time.NewTimer(p.limits.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ready:
+		return p, nil
+	case <-timeout:
+		err := &QueueTimeoutError{Pool: name, timeout: p.limits.QueueTimeout}
+		return b.leaveQueue(name, p, ready, err)
+	case <-req.Context().Done():
+		return b.leaveQueue(name, p, ready, req.Context().Err())
+	}
+}
+
+// leaveQueue removes the request from the queue, unless the slot has been handed over to it just now
+func (b *Bulkhead) leaveQueue(name string, p *pool, ready chan struct{}, err error) (*pool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	select {
+	case <-ready:
+		return p, nil
+	default:
+	}
+	for i, q := range p.queue {
+		if q == ready {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+	p.rejected++
+	b.drop(name, p)
+	return nil, err
+}
+
+// release hands the slot over to the first queued request or frees it
+func (b *Bulkhead) release(name string, p *pool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(p.queue) > 0 {
+		close(p.queue[0])
+		p.queue = p.queue[1:]
+		return
+	}
+	p.inFlight--
+	b.drop(name, p)
+}
+
+// drop forgets the idle pool made from the defaults, otherwise the pools would grow forever
+func (b *Bulkhead) drop(name string, p *pool) {
+	if !p.configured && p.inFlight == 0 && len(p.queue) == 0 {
+		delete(b.pools, name)
+	}
+}
+
+// PoolFullError is reported for the requests rejected by the pool with all the slots and the queue taken
+type PoolFullError struct {
+	Pool   string
+	limits Limits
+}
+
+func (e *PoolFullError) Error() string {
+	return fmt.Sprintf("pool %s is full: %d requests in flight, %d queued", e.Pool, e.limits.MaxInFlight, e.limits.MaxQueued)
+}
+
+// QueueTimeoutError is reported for the requests that have waited in the queue for too long
+type QueueTimeoutError struct {
+	Pool    string
+	timeout time.Duration
+}
+
+func (e *QueueTimeoutError) Error() string {
+	return fmt.Sprintf("pool %s has not served the request in %v", e.Pool, e.timeout)
+}
+
+// BulkheadErrHandler serves the rejected requests with 503 status code
+type BulkheadErrHandler struct {
+}
+
+func (e *BulkheadErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	switch err.(type) {
+	case *PoolFullError, *QueueTimeoutError:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+type BulkheadOption func(b *Bulkhead) error
+
+// Pool sets the limits of the named pool
+func Pool(name string, l Limits) BulkheadOption {
+	return func(b *Bulkhead) error {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("pool %s: %v", name, err)
+		}
+		b.limits[name] = l
+		return nil
+	}
+}
+
+// DefaultPool sets the limits of every pool not set with Pool, each one gets its own slots and queue
+func DefaultPool(l Limits) BulkheadOption {
+	return func(b *Bulkhead) error {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("default pool: %v", err)
+		}
+		b.defaults = &l
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) BulkheadOption {
+	return func(b *Bulkhead) error {
+		b.log = l
+		return nil
+	}
+}
+
+// ErrorHandler sets error handler of the server
+func ErrorHandler(h utils.ErrorHandler) BulkheadOption {
+	return func(b *Bulkhead) error {
+		b.errHandler = h
+		return nil
+	}
+}
+
+func (l Limits) validate() error {
+	if l.MaxInFlight <= 0 {
+		return fmt.Errorf("max in flight should be > 0, got %d", l.MaxInFlight)
+	}
+	if l.MaxQueued < 0 || l.QueueTimeout < 0 {
+		return fmt.Errorf("max queued and queue timeout should be >= 0, got %d, %v", l.MaxQueued, l.QueueTimeout)
+	}
+	return nil
+}
+
+var defaultErrHandler = &BulkheadErrHandler{}
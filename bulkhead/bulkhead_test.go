@@ -0,0 +1,225 @@
+package bulkhead
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestBulkhead(t *testing.T) { TestingT(t) }
+
+type BulkheadSuite struct {
+}
+
+var _ = Suite(&BulkheadSuite{})
+
+var poolHeader = utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+	return req.Header.Get("Pool"), 1, nil
+})
+
+// blocking returns the handler holding the requests with the wait header until released
+func blocking() (http.Handler, chan bool, chan bool) {
+	entered, release := make(chan bool, 10), make(chan bool)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Wait") != "" {
+			entered <- true
+			<-release
+		}
+		w.Write([]byte("hello"))
+	}), entered, release
+}
+
+// waitIdle waits for the pool to release the slots of the requests, the clients get the responses first
+func waitIdle(b *Bulkhead, name string) {
+	for b.Stats()[name].InFlight != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *BulkheadSuite) TestIsolation(c *C) {
+	handler, entered, release := blocking()
+	b, err := New(handler, poolHeader, Pool("slow", Limits{MaxInFlight: 1}), Pool("fast", Limits{MaxInFlight: 1}))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	done := make(chan bool)
+	go func() {
+		re, _, err := testutils.Get(srv.URL, testutils.Header("Pool", "slow"), testutils.Header("Wait", "yes"))
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusOK)
+		close(done)
+	}()
+	<-entered
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Pool", "slow"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+
+	// the other pools are not affected
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Pool", "fast"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Pool", "other"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	c.Assert(b.Stats(), DeepEquals, map[string]Stats{
+		"slow": {InFlight: 1, Rejected: 1},
+		"fast": {},
+	})
+	close(release)
+	<-done
+	waitIdle(b, "slow")
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Pool", "slow"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *BulkheadSuite) TestQueue(c *C) {
+	handler, entered, release := blocking()
+	b, err := New(handler, poolHeader, Pool("a", Limits{MaxInFlight: 1, MaxQueued: 1}))
+	c.Assert(err, IsNil)
+
+	codes, queued := make(chan int, 2), make(chan time.Duration, 2)
+	serve := func() {
+		req, timings := utils.WithTimings(httptest.NewRequest("GET", "http://localhost", nil))
+		req.Header.Set("Pool", "a")
+		req.Header.Set("Wait", "yes")
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		queued <- timings.Breakdown().Queue
+		codes <- w.Code
+	}
+	go serve()
+	<-entered
+	go serve()
+	for b.Stats()["a"].Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Pool", "a")
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+
+	// the queued request takes the slot of the first one
+	release <- true
+	c.Assert(<-codes, Equals, http.StatusOK)
+	<-entered
+	c.Assert(b.Stats()["a"], Equals, Stats{InFlight: 1, Rejected: 1})
+	release <- true
+	c.Assert(<-codes, Equals, http.StatusOK)
+	c.Assert(b.Stats()["a"], Equals, Stats{Rejected: 1})
+
+	// the time in the queue is recorded in the timings of the requests
+	c.Assert(<-queued, Equals, time.Duration(0))
+	c.Assert(<-queued > 0, Equals, true)
+}
+
+func (s *BulkheadSuite) TestQueueTimeout(c *C) {
+	handler, entered, release := blocking()
+	defer close(release)
+	b, err := New(handler, poolHeader, Pool("a", Limits{MaxInFlight: 1, MaxQueued: 1, QueueTimeout: 10 * time.Millisecond}))
+	c.Assert(err, IsNil)
+
+	go func() {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Pool", "a")
+		req.Header.Set("Wait", "yes")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-entered
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Pool", "a")
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+	c.Assert(b.Stats()["a"], Equals, Stats{InFlight: 1, Rejected: 1})
+}
+
+func (s *BulkheadSuite) TestDefaultPool(c *C) {
+	handler, entered, release := blocking()
+	b, err := New(handler, poolHeader, DefaultPool(Limits{MaxInFlight: 1}))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	done := make(chan bool)
+	go func() {
+		testutils.Get(srv.URL, testutils.Header("Pool", "a"), testutils.Header("Wait", "yes"))
+		close(done)
+	}()
+	<-entered
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Pool", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+
+	// every tenant gets its own pool
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Pool", "b"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(b.Stats(), DeepEquals, map[string]Stats{"a": {InFlight: 1, Rejected: 1}})
+
+	// the idle pools are dropped
+	close(release)
+	<-done
+	waitIdle(b, "a")
+	c.Assert(b.Stats(), HasLen, 0)
+}
+
+func (s *BulkheadSuite) TestClientGone(c *C) {
+	handler, entered, release := blocking()
+	defer close(release)
+	b, err := New(handler, poolHeader, Pool("a", Limits{MaxInFlight: 1, MaxQueued: 1}))
+	c.Assert(err, IsNil)
+
+	go func() {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Pool", "a")
+		req.Header.Set("Wait", "yes")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-entered
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx)
+	req.Header.Set("Pool", "a")
+	w := httptest.NewRecorder()
+	go func() {
+		for b.Stats()["a"].Queued != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	b.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, utils.StatusClientClosedRequest)
+	c.Assert(b.Stats()["a"], Equals, Stats{InFlight: 1, Rejected: 1})
+}
+
+func (s *BulkheadSuite) TestBadOptions(c *C) {
+	for _, o := range []BulkheadOption{
+		Pool("a", Limits{}),
+		Pool("a", Limits{MaxInFlight: 1, MaxQueued: -1}),
+		DefaultPool(Limits{MaxInFlight: 1, QueueTimeout: -time.Second}),
+	} {
+		_, err := New(nil, poolHeader, o)
+		c.Assert(err, NotNil)
+	}
+	_, err := New(nil, nil)
+	c.Assert(err, NotNil)
+}
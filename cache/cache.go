@@ -0,0 +1,697 @@
+// Package cache implements in memory HTTP cache middleware honoring a subset of RFC 7234.
+//
+// Only GET and HEAD requests are served from the cache and only GET responses are stored. Freshness is computed
+// from the Cache-Control (s-maxage, max-age, no-cache, no-store, private) and Expires response headers,
+// stale entries with ETag or Last-Modified validators are revalidated with conditional requests.
+// Responses with Vary header are stored as separate variants per value of the listed request headers.
+// Responses with Set-Cookie header are stored only if they are explicitly public. The headers set by the middlewares
+// in front of the cache, e.g. CORS, are not stored, so they are set for every client anew.
+//
+// Concurrent misses for the same key are coalesced, so only one request is sent to the next handler and the rest
+// are served from the cache once it completes. Stale entries are served while being revalidated in the background
+// if the response has stale-while-revalidate directive and instead of the upstream error if it has stale-if-error.
+//
+//	// cache responses in memory up to 128MB, cache responses without explicit freshness for 10 seconds
+//	cache.New(handler, cache.MaxBytes(128*1024*1024), cache.DefaultTTL(10*time.Second))
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+const (
+	CacheControl    = "Cache-Control"
+	ETag            = "Etag"
+	LastModified    = "Last-Modified"
+	IfNoneMatch     = "If-None-Match"
+	IfModifiedSince = "If-Modified-Since"
+	Expires         = "Expires"
+	Vary            = "Vary"
+	Age             = "Age"
+	Date            = "Date"
+	Authorization   = "Authorization"
+	ContentLength   = "Content-Length"
+	// XCache tells whether the response was served from cache: HIT, MISS, REVALIDATED or STALE
+	XCache = "X-Cache"
+)
+
+const (
+	// DefaultMaxBytes is the default size of the in memory storage, 64MB
+	DefaultMaxBytes = 64 * 1024 * 1024
+	// DefaultMaxEntryBytes is the default maximum size of the response body to cache, 1MB
+	DefaultMaxEntryBytes = 1024 * 1024
+)
+
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheRevalidated = "REVALIDATED"
+	cacheStale       = "STALE"
+)
+
+// Cache is http.Handler that serves responses from the cache and stores cacheable responses of the next handler
+type Cache struct {
+	next          http.Handler
+	storage       Storage
+	maxBytes      int64
+	maxEntryBytes int64
+	defaultTTL    time.Duration
+	maxTTL        time.Duration
+	clock         timetools.TimeProvider
+	log           utils.Logger
+
+	mtx      sync.Mutex
+	inflight map[string]chan struct{}
+
+	// drain tracks the requests being served and the background revalidations
+	drain utils.Drainer
+}
+
+// Option is a functional option setter for Cache
+type Option func(*Cache) error
+
+// Store sets the storage for the cached entries, by default in memory LRU storage is used
+func Store(s Storage) Option {
+	return func(c *Cache) error {
+		c.storage = s
+		return nil
+	}
+}
+
+// MaxBytes sets the size of the default in memory storage
+func MaxBytes(m int64) Option {
+	return func(c *Cache) error {
+		if m <= 0 {
+			return fmt.Errorf("max bytes should be > 0, got %d", m)
+		}
+		c.maxBytes = m
+		return nil
+	}
+}
+
+// MaxEntryBytes sets the maximum size of the response body that will be cached
+func MaxEntryBytes(m int64) Option {
+	return func(c *Cache) error {
+		if m <= 0 {
+			return fmt.Errorf("max entry bytes should be > 0, got %d", m)
+		}
+		c.maxEntryBytes = m
+		return nil
+	}
+}
+
+// DefaultTTL sets the time to live of cacheable responses that have no explicit freshness information
+func DefaultTTL(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return fmt.Errorf("ttl should be >= 0, got %v", d)
+		}
+		c.defaultTTL = d
+		return nil
+	}
+}
+
+// MaxTTL caps the time to live of cached responses regardless of the freshness set by the upstream
+func MaxTTL(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return fmt.Errorf("ttl should be >= 0, got %v", d)
+		}
+		c.maxTTL = d
+		return nil
+	}
+}
+
+// Clock sets the time provider, intended for tests
+func Clock(clock timetools.TimeProvider) Option {
+	return func(c *Cache) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) Option {
+	return func(c *Cache) error {
+		c.log = l
+		return nil
+	}
+}
+
+// New returns a new cache middleware
+func New(next http.Handler, opts ...Option) (*Cache, error) {
+	c := &Cache{
+		next:          next,
+		maxBytes:      DefaultMaxBytes,
+		maxEntryBytes: DefaultMaxEntryBytes,
+		inflight:      make(map[string]chan struct{}),
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.storage == nil {
+		s, err := NewMemoryStorage(c.maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.storage = s
+	}
+	if c.clock == nil {
+		c.clock = &timetools.RealTime{}
+	}
+	if c.log == nil {
+		c.log = utils.NullLogger
+	}
+	return c, nil
+}
+
+// Wrap sets the next handler to be called by the cache
+func (c *Cache) Wrap(next http.Handler) {
+	c.next = next
+}
+
+// Close stops accepting new requests and waits for the requests in flight and the background revalidations
+// until the context is done
+func (c *Cache) Close(ctx context.Context) error {
+	return c.drain.Close(ctx)
+}
+
+func (c *Cache) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !c.drain.Enter() {
+		utils.DefaultHandler.ServeHTTP(w, req, utils.ErrShuttingDown)
+		return
+	}
+	defer c.drain.Leave()
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+
+	key := primaryKey(req)
+	e, flight := c.lookup(req, key)
+	now := c.clock.UtcNow()
+	if e != nil && !reqCC.has("no-cache") {
+		if isFresh(e, reqCC, now) {
+			c.serveEntry(w, req, e, now, cacheHit)
+			return
+		}
+		if withinStale(e, "stale-while-revalidate", now) {
+			c.revalidateAsync(req, key, flight, e)
+			c.serveEntry(w, req, e, now, cacheStale)
+			return
+		}
+	}
+	// HEAD responses carry no body, so they are never used to populate the cache
+	if req.Method == "HEAD" {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+
+	if !reqCC.has("no-cache") {
+		done, leader := c.join(flight)
+		if leader {
+			defer c.leave(flight, done)
+		} else {
+			select {
+			case <-done:
+			case <-req.Context().Done():
+				return
+			}
+			// the response fetched by the leader may turn out to be not cacheable, fetch it then
+			if fresh, _ := c.lookup(req, key); fresh != nil {
+				now = c.clock.UtcNow()
+				if isFresh(fresh, reqCC, now) {
+					c.serveEntry(w, req, fresh, now, cacheHit)
+					return
+				}
+				e = fresh
+			}
+		}
+	}
+
+	if e != nil && (hasValidators(e.Header) || withinStale(e, "stale-if-error", c.clock.UtcNow())) {
+		c.revalidate(w, req, key, e)
+		return
+	}
+	c.fetch(w, req, key)
+}
+
+// lookup returns the entry for the request taking Vary markers into account and the key of the variant
+func (c *Cache) lookup(req *http.Request, key string) (*Entry, string) {
+	e, ok := c.storage.Get(key)
+	if !ok {
+		return nil, key
+	}
+	if len(e.Vary) == 0 {
+		return e, key
+	}
+	vkey := variantKey(key, e.Vary, req.Header)
+	v, ok := c.storage.Get(vkey)
+	if !ok {
+		return nil, vkey
+	}
+	return v, vkey
+}
+
+// join registers the in flight fetch of the key, it returns true if the caller is the first one to fetch it,
+// otherwise the caller should wait for the returned channel to be closed
+func (c *Cache) join(key string) (chan struct{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if done, ok := c.inflight[key]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	c.inflight[key] = done
+	return done, true
+}
+
+func (c *Cache) leave(key string, done chan struct{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.inflight, key)
+	close(done)
+}
+
+func (c *Cache) store(req *http.Request, key string, e *Entry) {
+	vary := varyHeaders(e.Header)
+	if len(vary) != 0 {
+		marker := &Entry{Vary: vary, Stored: e.Stored, Expires: e.Expires}
+		if err := c.storage.Set(key, marker); err != nil {
+			c.log.Warningf("failed to store %v: %v", key, err)
+			return
+		}
+		key = variantKey(key, vary, req.Header)
+	}
+	if err := c.storage.Set(key, e); err != nil {
+		c.log.Warningf("failed to store %v: %v", key, err)
+	}
+}
+
+// fetch passes the request to the next handler streaming the response to the client and stores it if it's cacheable
+func (c *Cache) fetch(w http.ResponseWriter, req *http.Request, key string) {
+	w.Header().Set(XCache, cacheMiss)
+	rec := newRecorder(w, c.maxEntryBytes)
+	c.next.ServeHTTP(rec, req)
+	rec.commit()
+	if rec.overflow {
+		return
+	}
+
+	now := c.clock.UtcNow()
+	code := rec.statusCode()
+	header := rec.stored
+	expires, ok := c.expiration(req, code, header, now)
+	if !ok {
+		return
+	}
+	c.store(req, key, &Entry{
+		StatusCode: code,
+		Header:     header,
+		Body:       rec.body.Bytes(),
+		Stored:     now,
+		Expires:    expires,
+	})
+}
+
+// revalidate refreshes the stale entry and serves the result
+func (c *Cache) revalidate(w http.ResponseWriter, req *http.Request, key string, e *Entry) {
+	resp, status := c.refresh(req, key, e)
+	if resp == nil {
+		// the new response is too large to be buffered, it is fetched again and streamed to the client
+		c.fetch(w, req, key)
+		return
+	}
+	c.serveEntry(w, req, resp, c.clock.UtcNow(), status)
+}
+
+// revalidateAsync refreshes the stale entry in the background unless it's being fetched already
+func (c *Cache) revalidateAsync(req *http.Request, key, flight string, e *Entry) {
+	// the drainer is entered before the request leaves it, so Close can't miss the revalidation
+	if !c.drain.Enter() {
+		return
+	}
+	done, leader := c.join(flight)
+	if !leader {
+		c.drain.Leave()
+		return
+	}
+	bgReq := req.WithContext(context.Background())
+	go func() {
+		defer c.drain.Leave()
+		defer c.leave(flight, done)
+		c.refresh(bgReq, key, e)
+	}()
+}
+
+// refresh fetches the response for the stale entry sending conditional request if the entry has validators.
+// It returns the entry to serve along with the cache status, or nil if the response exceeds the max entry bytes.
+func (c *Cache) refresh(req *http.Request, key string, e *Entry) (*Entry, string) {
+	outReq := *req
+	outReq.Header = cloneHeader(req.Header)
+	outReq.Header.Del(IfNoneMatch)
+	outReq.Header.Del(IfModifiedSince)
+	if etag := e.Header.Get(ETag); etag != "" {
+		outReq.Header.Set(IfNoneMatch, etag)
+	}
+	if lm := e.Header.Get(LastModified); lm != "" {
+		outReq.Header.Set(IfModifiedSince, lm)
+	}
+
+	// the body is kept by the recorder up to the max entry bytes only
+	bw := utils.NewBufferWriter(utils.NopWriteCloser(ioutil.Discard))
+	rec := newRecorder(bw, c.maxEntryBytes)
+	c.next.ServeHTTP(rec, &outReq)
+	rec.commit()
+
+	now := c.clock.UtcNow()
+	if rec.code == http.StatusNotModified && hasValidators(e.Header) {
+		updated := &Entry{
+			StatusCode: e.StatusCode,
+			Header:     cloneHeader(e.Header),
+			Body:       e.Body,
+			Stored:     now,
+		}
+		for k, vals := range rec.stored {
+			if k == ContentLength {
+				continue
+			}
+			updated.Header[k] = append([]string(nil), vals...)
+		}
+		if expires, ok := c.expiration(req, updated.StatusCode, updated.Header, now); ok {
+			updated.Expires = expires
+			c.store(req, key, updated)
+		}
+		return updated, cacheRevalidated
+	}
+
+	code := rec.statusCode()
+	if code >= http.StatusInternalServerError && withinStale(e, "stale-if-error", now) {
+		c.log.Warningf("serving stale %v, upstream replied with %d", key, code)
+		return e, cacheStale
+	}
+	if rec.overflow {
+		return nil, cacheMiss
+	}
+	resp := &Entry{
+		StatusCode: code,
+		Header:     rec.stored,
+		Body:       rec.body.Bytes(),
+		Stored:     now,
+	}
+	if expires, ok := c.expiration(req, code, resp.Header, now); ok {
+		resp.Expires = expires
+		c.store(req, key, resp)
+	}
+	return resp, cacheMiss
+}
+
+func (c *Cache) serveEntry(w http.ResponseWriter, req *http.Request, e *Entry, now time.Time, status string) {
+	h := w.Header()
+	for k, vals := range e.Header {
+		h[k] = append([]string(nil), vals...)
+	}
+	h.Set(Age, strconv.FormatInt(int64(now.Sub(e.Stored)/time.Second), 10))
+	h.Set(XCache, status)
+	if notModified(req, e) {
+		h.Del(ContentLength)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set(ContentLength, strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.StatusCode)
+	if req.Method != "HEAD" {
+		w.Write(e.Body)
+	}
+}
+
+// expiration returns the time the response stays fresh until and false if the response can not be stored
+func (c *Cache) expiration(req *http.Request, code int, header http.Header, now time.Time) (time.Time, bool) {
+	if !cacheableStatus[code] {
+		return time.Time{}, false
+	}
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || cc.has("private") {
+		return time.Time{}, false
+	}
+	for _, v := range varyHeaders(header) {
+		if v == "*" {
+			return time.Time{}, false
+		}
+	}
+	// The cookies of one client must not be replayed to the others
+	if header.Get("Set-Cookie") != "" && !cc.has("public") {
+		return time.Time{}, false
+	}
+	// Shared caches must not store responses to authorized requests unless explicitly allowed
+	if req.Header.Get(Authorization) != "" && !cc.has("public") && !cc.has("s-maxage") {
+		return time.Time{}, false
+	}
+
+	var ttl time.Duration
+	if d, ok := cc.duration("s-maxage"); ok {
+		ttl = d
+	} else if d, ok := cc.duration("max-age"); ok {
+		ttl = d
+	} else if exp := header.Get(Expires); exp != "" {
+		if t, err := http.ParseTime(exp); err == nil {
+			ttl = t.Sub(responseDate(header, now))
+		}
+	} else {
+		ttl = c.defaultTTL
+	}
+	if cc.has("no-cache") || ttl < 0 {
+		ttl = 0
+	}
+	if c.maxTTL > 0 && ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+	// There's no point in storing the response that has to be fetched again anyway
+	if ttl == 0 && !hasValidators(header) {
+		return time.Time{}, false
+	}
+	return now.Add(ttl), true
+}
+
+func isFresh(e *Entry, reqCC cacheControl, now time.Time) bool {
+	if !now.Before(e.Expires) {
+		return false
+	}
+	if maxAge, ok := reqCC.duration("max-age"); ok && now.Sub(e.Stored) > maxAge {
+		return false
+	}
+	return true
+}
+
+// withinStale tells whether the stale entry can still be served according to the delta-seconds directive
+func withinStale(e *Entry, directive string, now time.Time) bool {
+	d, ok := parseCacheControl(e.Header).duration(directive)
+	return ok && now.Before(e.Expires.Add(d))
+}
+
+func hasValidators(h http.Header) bool {
+	return h.Get(ETag) != "" || h.Get(LastModified) != ""
+}
+
+// notModified tells whether the client's conditional request matches the cached entry
+func notModified(req *http.Request, e *Entry) bool {
+	if e.StatusCode != http.StatusOK {
+		return false
+	}
+	if inm := req.Header.Get(IfNoneMatch); inm != "" {
+		etag := e.Header.Get(ETag)
+		return etag != "" && matchETag(inm, etag)
+	}
+	ims, lm := req.Header.Get(IfModifiedSince), e.Header.Get(LastModified)
+	if ims == "" || lm == "" {
+		return false
+	}
+	imsTime, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	lmTime, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+	return !lmTime.After(imsTime)
+}
+
+// matchETag performs weak comparison of the If-None-Match value against the entity tag
+func matchETag(inm, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(inm, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func responseDate(h http.Header, now time.Time) time.Time {
+	if d := h.Get(Date); d != "" {
+		if t, err := http.ParseTime(d); err == nil {
+			return t
+		}
+	}
+	return now
+}
+
+func primaryKey(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.RequestURI()
+}
+
+func variantKey(key string, vary []string, h http.Header) string {
+	parts := make([]string, 0, len(vary)+1)
+	parts = append(parts, key)
+	for _, name := range vary {
+		parts = append(parts, name+":"+strings.Join(h[name], ","))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// varyHeaders returns sorted canonical names of the headers listed in the Vary header
+func varyHeaders(h http.Header) []string {
+	var out []string
+	for _, line := range h[Vary] {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				out = append(out, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func cloneHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vals := range h {
+		out[k] = append([]string(nil), vals...)
+	}
+	return out
+}
+
+// Status codes that are cacheable by default, see RFC 7231 section 6.1
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// recorder streams the response to the client while keeping a copy of the body up to max bytes
+// recorder streams the response to the client keeping the body up to max bytes and the headers set by
+// the next handler apart from the ones set by the middlewares in front of the cache, e.g. CORS or X-Request-Id
+type recorder struct {
+	w        http.ResponseWriter
+	header   http.Header
+	code     int
+	body     bytes.Buffer
+	max      int64
+	overflow bool
+	// stored are the headers of the next handler once the response is started
+	stored http.Header
+}
+
+func newRecorder(w http.ResponseWriter, max int64) *recorder {
+	return &recorder{w: w, header: make(http.Header), max: max}
+}
+
+func (r *recorder) Header() http.Header {
+	if r.stored != nil {
+		return r.w.Header()
+	}
+	return r.header
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.stored != nil {
+		return
+	}
+	r.code = code
+	r.commit()
+	r.w.WriteHeader(code)
+}
+
+// commit copies the headers of the next handler to the response
+func (r *recorder) commit() {
+	if r.stored != nil {
+		return
+	}
+	h := r.w.Header()
+	for k, vals := range r.header {
+		h[k] = vals
+	}
+	r.stored = cloneHeader(r.header)
+}
+
+func (r *recorder) Write(buf []byte) (int, error) {
+	if r.stored == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if int64(r.body.Len()+len(buf)) > r.max {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(buf)
+		}
+	}
+	return r.w.Write(buf)
+}
+
+func (r *recorder) Flush() {
+	if r.stored == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+func (r *recorder) statusCode() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+	return r.code
+}
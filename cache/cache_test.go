@@ -0,0 +1,550 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestCache(t *testing.T) { TestingT(t) }
+
+type CacheSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&CacheSuite{})
+
+func (s *CacheSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *CacheSuite) newCache(c *C, handler http.Handler, opts ...Option) *httptest.Server {
+	cache, err := New(handler, append([]Option{Clock(s.clock)}, opts...)...)
+	c.Assert(err, IsNil)
+	return httptest.NewServer(cache)
+}
+
+func (s *CacheSuite) TestHitAndExpire(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Write([]byte(fmt.Sprintf("hello %d", calls)))
+	}))
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(string(body), Equals, "hello 1")
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(5 * time.Second)
+	re, body, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(re.Header.Get(Age), Equals, "5")
+	c.Assert(string(body), Equals, "hello 1")
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(5 * time.Second)
+	re, body, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(string(body), Equals, "hello 2")
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *CacheSuite) TestHead(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	// HEAD does not populate the cache
+	re, _, err := testutils.MakeRequest(srv.URL, testutils.Method("HEAD"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	_, _, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+
+	re, body, err := testutils.MakeRequest(srv.URL, testutils.Method("HEAD"))
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(re.ContentLength, Equals, int64(5))
+	c.Assert(len(body), Equals, 0)
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *CacheSuite) TestNotCacheable(c *C) {
+	testCases := []struct {
+		method string
+		code   int
+		header http.Header
+		req    http.Header
+	}{
+		{method: "POST", code: 200, header: http.Header{CacheControl: {"max-age=10"}}},
+		{method: "GET", code: 500, header: http.Header{CacheControl: {"max-age=10"}}},
+		{method: "GET", code: 200},
+		{method: "GET", code: 200, header: http.Header{CacheControl: {"no-store, max-age=10"}}},
+		{method: "GET", code: 200, header: http.Header{CacheControl: {"private, max-age=10"}}},
+		{method: "GET", code: 200, header: http.Header{CacheControl: {"max-age=10"}, Vary: {"*"}}},
+		{method: "GET", code: 200, header: http.Header{CacheControl: {"max-age=10"}}, req: http.Header{CacheControl: {"no-store"}}},
+		{method: "GET", code: 200, header: http.Header{CacheControl: {"max-age=10"}}, req: http.Header{Authorization: {"Basic YTpi"}}},
+	}
+	for i, tc := range testCases {
+		calls := 0
+		srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls++
+			for k, v := range tc.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(tc.code)
+			w.Write([]byte("hello"))
+		}))
+		for j := 0; j < 2; j++ {
+			_, _, err := testutils.MakeRequest(srv.URL, testutils.Method(tc.method), testutils.Headers(tc.req))
+			c.Assert(err, IsNil)
+		}
+		srv.Close()
+		c.Assert(calls, Equals, 2, Commentf("test case %d", i))
+	}
+}
+
+func (s *CacheSuite) TestAuthorizedPublic(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "public, max-age=10")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		_, _, err := testutils.Get(srv.URL, testutils.BasicAuth("a", "b"))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *CacheSuite) TestExpiresHeader(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(Date, s.clock.UtcNow().Format(http.TimeFormat))
+		w.Header().Set(Expires, s.clock.UtcNow().Add(time.Minute).Format(http.TimeFormat))
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(59 * time.Second)
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *CacheSuite) TestTTLOverrides(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.URL.Path == "/long" {
+			w.Header().Set(CacheControl, "max-age=3600")
+		}
+		w.Write([]byte("hello"))
+	}), DefaultTTL(time.Second), MaxTTL(10*time.Second))
+	defer srv.Close()
+
+	// default TTL applies to responses without explicit freshness
+	testutils.Get(srv.URL + "/default")
+	re, _, err := testutils.Get(srv.URL + "/default")
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+
+	// max TTL caps the freshness set by the upstream
+	testutils.Get(srv.URL + "/long")
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(11 * time.Second)
+	re, _, err = testutils.Get(srv.URL + "/long")
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(calls, Equals, 3)
+}
+
+func (s *CacheSuite) TestRevalidateETag(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Header().Set(ETag, `"v1"`)
+		if req.Header.Get(IfNoneMatch) == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Minute)
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(re.Header.Get(XCache), Equals, cacheRevalidated)
+	c.Assert(string(body), Equals, "hello")
+
+	// revalidated entry is fresh again
+	re, _, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *CacheSuite) TestRevalidateModified(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "no-cache")
+		w.Header().Set(ETag, fmt.Sprintf(`"v%d"`, calls))
+		w.Write([]byte(fmt.Sprintf("hello %d", calls)))
+	}))
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(string(body), Equals, "hello 2")
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *CacheSuite) TestClientConditional(c *C) {
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Header().Set(ETag, `W/"v1"`)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header(IfNoneMatch, `"v0", "v1"`))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotModified)
+	c.Assert(len(body), Equals, 0)
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header(IfNoneMatch, `"v2"`))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *CacheSuite) TestRequestNoCache(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	testutils.Get(srv.URL)
+	re, _, err := testutils.Get(srv.URL, testutils.Header(CacheControl, "no-cache"))
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(5 * time.Second)
+	re, _, err = testutils.Get(srv.URL, testutils.Header(CacheControl, "max-age=2"))
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(calls, Equals, 3)
+}
+
+func (s *CacheSuite) TestVary(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Header().Set(Vary, "Accept-Language")
+		w.Write([]byte("hello " + req.Header.Get("Accept-Language")))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		_, body, err := testutils.Get(srv.URL, testutils.Header("Accept-Language", "en"))
+		c.Assert(err, IsNil)
+		c.Assert(string(body), Equals, "hello en")
+
+		_, body, err = testutils.Get(srv.URL, testutils.Header("Accept-Language", "fr"))
+		c.Assert(err, IsNil)
+		c.Assert(string(body), Equals, "hello fr")
+	}
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *CacheSuite) TestMaxEntryBytes(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Write([]byte("hello, world"))
+	}), MaxEntryBytes(5))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		_, body, err := testutils.Get(srv.URL)
+		c.Assert(err, IsNil)
+		c.Assert(string(body), Equals, "hello, world")
+	}
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *CacheSuite) TestRevalidateTooLarge(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Header().Set(ETag, fmt.Sprintf(`"v%d"`, calls))
+		if calls == 1 {
+			w.Write([]byte("hello"))
+			return
+		}
+		w.Write([]byte(fmt.Sprintf("hello, world %d", calls)))
+	}), MaxEntryBytes(5))
+	defer srv.Close()
+
+	_, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+
+	// the revalidated response is too large to be buffered, it is fetched and streamed again
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Minute)
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(string(body), Equals, "hello, world 3")
+	c.Assert(calls, Equals, 3)
+}
+
+func (s *CacheSuite) TestFrontHeadersNotStored(c *C) {
+	cache, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Write([]byte("hello"))
+	}), Clock(s.clock))
+	c.Assert(err, IsNil)
+	// the middleware in front of the cache sets the headers of the client
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", req.Header.Get("Origin"))
+		cache.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Origin", "http://a.com"))
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(re.Header.Get("Access-Control-Allow-Origin"), Equals, "http://a.com")
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Origin", "http://b.com"))
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(re.Header.Get("Access-Control-Allow-Origin"), Equals, "http://b.com")
+}
+
+func (s *CacheSuite) TestSetCookie(c *C) {
+	calls := 0
+	cacheControl := "max-age=10"
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, cacheControl)
+		w.Header().Set("Set-Cookie", fmt.Sprintf("session=%d", calls))
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	for i := 1; i <= 2; i++ {
+		re, _, err := testutils.Get(srv.URL)
+		c.Assert(err, IsNil)
+		c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+		c.Assert(re.Header.Get("Set-Cookie"), Equals, fmt.Sprintf("session=%d", i))
+	}
+
+	// the explicitly public responses are stored with the cookies
+	cacheControl = "public, max-age=10"
+	testutils.Get(srv.URL)
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(calls, Equals, 3)
+}
+
+func (s *CacheSuite) TestBadOptions(c *C) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	for _, o := range []Option{MaxBytes(0), MaxEntryBytes(-1), DefaultTTL(-time.Second), MaxTTL(-time.Second)} {
+		_, err := New(h, o)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *CacheSuite) TestCoalesceMisses(c *C) {
+	var mtx sync.Mutex
+	calls := 0
+	release := make(chan bool)
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mtx.Lock()
+		calls++
+		mtx.Unlock()
+		<-release
+		w.Header().Set(CacheControl, "max-age=10")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			re, body, err := testutils.Get(srv.URL)
+			c.Assert(err, IsNil)
+			c.Assert(re.StatusCode, Equals, http.StatusOK)
+			c.Assert(string(body), Equals, "hello")
+		}()
+	}
+	// let all the requests reach the cache before the backend replies
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *CacheSuite) TestCoalesceNotCacheable(c *C) {
+	var mtx sync.Mutex
+	calls := 0
+	release := make(chan bool)
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mtx.Lock()
+		calls++
+		mtx.Unlock()
+		<-release
+		w.Header().Set(CacheControl, "private, max-age=10")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, body, err := testutils.Get(srv.URL)
+			c.Assert(err, IsNil)
+			c.Assert(string(body), Equals, "hello")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// private responses are never shared, so every request reaches the backend
+	mtx.Lock()
+	defer mtx.Unlock()
+	c.Assert(calls, Equals, 3)
+}
+
+func (s *CacheSuite) TestStaleWhileRevalidate(c *C) {
+	calls := make(chan int, 2)
+	count := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		count++
+		w.Header().Set(CacheControl, "max-age=10, stale-while-revalidate=30")
+		w.Write([]byte(fmt.Sprintf("hello %d", count)))
+		calls <- count
+	}))
+	defer srv.Close()
+
+	testutils.Get(srv.URL)
+	<-calls
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(20 * time.Second)
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheStale)
+	c.Assert(string(body), Equals, "hello 1")
+
+	// wait for the background revalidation to complete
+	c.Assert(<-calls, Equals, 2)
+	for i := 0; i < 100; i++ {
+		if re, body, _ = testutils.Get(srv.URL); re.Header.Get(XCache) == cacheHit {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(re.Header.Get(XCache), Equals, cacheHit)
+	c.Assert(string(body), Equals, "hello 2")
+}
+
+func (s *CacheSuite) TestStaleWhileRevalidateExpired(c *C) {
+	calls := 0
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set(CacheControl, "max-age=10, stale-while-revalidate=30")
+		w.Write([]byte(fmt.Sprintf("hello %d", calls)))
+	}))
+	defer srv.Close()
+
+	testutils.Get(srv.URL)
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(41 * time.Second)
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(XCache), Equals, cacheMiss)
+	c.Assert(string(body), Equals, "hello 2")
+}
+
+func (s *CacheSuite) TestStaleIfError(c *C) {
+	fail := false
+	srv := s.newCache(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set(CacheControl, "max-age=10, stale-if-error=60")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	testutils.Get(srv.URL)
+	fail = true
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(30 * time.Second)
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(re.Header.Get(XCache), Equals, cacheStale)
+	c.Assert(string(body), Equals, "hello")
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Minute)
+	re, _, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+}
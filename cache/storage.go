@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is a cached response
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Stored is the time the response was received from the upstream or revalidated
+	Stored time.Time
+	// Expires is the time after which the entry has to be revalidated before serving it
+	Expires time.Time
+	// Vary lists the request headers the response varies on, entries with non empty Vary
+	// are markers pointing to the variants stored under the secondary keys
+	Vary []string
+}
+
+// Size returns approximate amount of memory consumed by the entry in bytes
+func (e *Entry) Size() int64 {
+	size := int64(len(e.Body))
+	for k, vals := range e.Header {
+		for _, v := range vals {
+			size += int64(len(k) + len(v))
+		}
+	}
+	for _, v := range e.Vary {
+		size += int64(len(v))
+	}
+	return size
+}
+
+// Storage stores the cached entries, e.g. in memory, on disk or in Redis. Implementations
+// are responsible for their own eviction policy and have to be safe for concurrent use.
+type Storage interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry) error
+	Delete(key string)
+}
+
+// MemoryStorage is an in memory LRU storage limited by the total size of the entries
+type MemoryStorage struct {
+	mtx      sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	entries  map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewMemoryStorage returns LRU storage that evicts the least recently used entries
+// once the total size of the entries exceeds maxBytes
+func NewMemoryStorage(maxBytes int64) (*MemoryStorage, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("max bytes should be > 0, got %d", maxBytes)
+	}
+	return &MemoryStorage{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}, nil
+}
+
+func (m *MemoryStorage) Get(key string) (*Entry, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.ll.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, true
+}
+
+func (m *MemoryStorage) Set(key string, e *Entry) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	size := e.Size()
+	if size > m.maxBytes {
+		return fmt.Errorf("entry size %d exceeds storage size %d", size, m.maxBytes)
+	}
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	m.entries[key] = m.ll.PushFront(&memoryItem{key: key, entry: e})
+	m.size += size
+	for m.size > m.maxBytes {
+		m.remove(m.ll.Back())
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Delete(key string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+}
+
+// Len returns the number of stored entries
+func (m *MemoryStorage) Len() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.ll.Len()
+}
+
+// Bytes returns the total size of stored entries
+func (m *MemoryStorage) Bytes() int64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.size
+}
+
+func (m *MemoryStorage) remove(el *list.Element) {
+	item := el.Value.(*memoryItem)
+	m.ll.Remove(el)
+	delete(m.entries, item.key)
+	m.size -= item.entry.Size()
+}
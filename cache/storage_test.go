@@ -0,0 +1,52 @@
+package cache
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type StorageSuite struct{}
+
+var _ = Suite(&StorageSuite{})
+
+func (s *StorageSuite) TestEvictsLeastRecentlyUsed(c *C) {
+	m, err := NewMemoryStorage(10)
+	c.Assert(err, IsNil)
+
+	c.Assert(m.Set("a", &Entry{Body: []byte("aaaa")}), IsNil)
+	c.Assert(m.Set("b", &Entry{Body: []byte("bbbb")}), IsNil)
+
+	// touch a so b becomes the oldest
+	_, ok := m.Get("a")
+	c.Assert(ok, Equals, true)
+
+	c.Assert(m.Set("c", &Entry{Body: []byte("cccc")}), IsNil)
+	_, ok = m.Get("b")
+	c.Assert(ok, Equals, false)
+	_, ok = m.Get("a")
+	c.Assert(ok, Equals, true)
+	c.Assert(m.Len(), Equals, 2)
+	c.Assert(m.Bytes(), Equals, int64(8))
+}
+
+func (s *StorageSuite) TestReplaceAndDelete(c *C) {
+	m, err := NewMemoryStorage(100)
+	c.Assert(err, IsNil)
+
+	c.Assert(m.Set("a", &Entry{Body: []byte("aaaa")}), IsNil)
+	c.Assert(m.Set("a", &Entry{Body: []byte("aa")}), IsNil)
+	c.Assert(m.Len(), Equals, 1)
+	c.Assert(m.Bytes(), Equals, int64(2))
+
+	m.Delete("a")
+	c.Assert(m.Len(), Equals, 0)
+	c.Assert(m.Bytes(), Equals, int64(0))
+}
+
+func (s *StorageSuite) TestTooLarge(c *C) {
+	m, err := NewMemoryStorage(2)
+	c.Assert(err, IsNil)
+	c.Assert(m.Set("a", &Entry{Body: []byte("aaaa")}), NotNil)
+
+	_, err = NewMemoryStorage(0)
+	c.Assert(err, NotNil)
+}
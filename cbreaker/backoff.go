@@ -0,0 +1,45 @@
+package cbreaker
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// FallbackBackoff doubles the FallbackDuration every time the CircuitBreaker trips again while recovering
+// or within the window after it has recovered, up to max, so the persistently failing backend is not probed
+// on the fixed schedule. The durations are shortened by up to 20% at random, so the breakers of many proxies
+// don't probe the backend at once. The duration is back to FallbackDuration once the breaker stays
+// in standby for the window.
+func FallbackBackoff(max, window time.Duration) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		if max <= 0 || window <= 0 {
+			return fmt.Errorf("fallback backoff max and window should be > 0, got %v, %v", max, window)
+		}
+		c.backoffMax = max
+		c.backoffWindow = window
+		return nil
+	}
+}
+
+// nextFallbackDuration returns the duration of the trip and counts the repeated trips, called with the lock held
+func (c *CircuitBreaker) nextFallbackDuration() time.Duration {
+	if c.backoffMax == 0 {
+		return c.fallbackDuration
+	}
+	repeated := c.state == stateRecovering ||
+		(!c.recoveredAt.IsZero() && c.clock.UtcNow().Sub(c.recoveredAt) < c.backoffWindow)
+	if !repeated {
+		c.retrips = 0
+		return c.fallbackDuration
+	}
+	c.retrips++
+	d := c.fallbackDuration
+	for i := 0; i < c.retrips && d < c.backoffMax; i++ {
+		d *= 2
+	}
+	if d > c.backoffMax {
+		d = c.backoffMax
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/5+1))
+}
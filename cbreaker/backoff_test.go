@@ -0,0 +1,78 @@
+package cbreaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *CBSuite) TestFallbackBackoff(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	cb, err := New(handler, triggerNetRatio, Clock(s.clock), FallbackBackoff(time.Minute, 30*time.Second))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+
+	trip := func() time.Duration {
+		cb.metrics = statsNetErrors(0.6)
+		s.advanceTime(defaultCheckPeriod + time.Millisecond)
+		// the recovering breaker passes only some of the requests
+		for i := 0; i < 100 && cb.state != stateTripped; i++ {
+			testutils.Get(srv.URL)
+		}
+		c.Assert(cb.state, Equals, cbState(stateTripped))
+		return cb.until.Sub(s.clock.UtcNow())
+	}
+	recover := func() {
+		s.advanceTime(cb.until.Sub(s.clock.UtcNow()) + time.Millisecond)
+		testutils.Get(srv.URL)
+		c.Assert(cb.state, Equals, cbState(stateRecovering))
+		// half way through the recovery some requests pass
+		s.advanceTime(defaultRecoveryDuration / 2)
+	}
+	assertBetween := func(d, min, max time.Duration) {
+		c.Assert(d >= min && d <= max, Equals, true, Commentf("%v is not in [%v, %v]", d, min, max))
+	}
+
+	c.Assert(trip(), Equals, defaultFallbackDuration)
+
+	// tripping again while recovering doubles the duration up to the max
+	recover()
+	assertBetween(trip(), 16*time.Second, 20*time.Second)
+	recover()
+	assertBetween(trip(), 32*time.Second, 40*time.Second)
+	recover()
+	assertBetween(trip(), 48*time.Second, time.Minute)
+
+	// tripping shortly after the recovery keeps backing off
+	recover()
+	s.advanceTime(defaultRecoveryDuration + time.Millisecond)
+	testutils.Get(srv.URL)
+	c.Assert(cb.state, Equals, cbState(stateStandby))
+	s.advanceTime(10 * time.Second)
+	assertBetween(trip(), 48*time.Second, time.Minute)
+
+	// the duration is reset once the breaker stays in standby for the window
+	recover()
+	s.advanceTime(defaultRecoveryDuration + time.Millisecond)
+	testutils.Get(srv.URL)
+	c.Assert(cb.state, Equals, cbState(stateStandby))
+	s.advanceTime(30 * time.Second)
+	c.Assert(trip(), Equals, defaultFallbackDuration)
+}
+
+func (s *CBSuite) TestBadFallbackBackoff(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	_, err := New(handler, triggerNetRatio, FallbackBackoff(0, time.Second))
+	c.Assert(err, NotNil)
+	_, err = New(handler, triggerNetRatio, FallbackBackoff(time.Minute, 0))
+	c.Assert(err, NotNil)
+	_, err = New(handler, triggerNetRatio, FallbackBackoff(time.Second, time.Minute))
+	c.Assert(err, NotNil)
+}
@@ -15,7 +15,7 @@
 //    allowedRequestsRatio = 0.5 * (Now() - StartRecovery())/RecoveryDuration
 //
 // Two scenarios are possible in the "Recovering" state:
-// 1. Condition matches again, this will reset the state to "Tripped" and reset the timer.
+// 1. Condition matches again, this will reset the state to "Tripped" and reset the timer, see FallbackBackoff.
 // 2. Condition does not match, circuit breaker enters "Standby" state
 //
 // It is possible to define actions (e.g. webhooks) of transitions between states:
@@ -23,15 +23,18 @@
 // * OnTripped action is called on transition (Standby -> Tripped)
 // * OnStandby action is called on transition (Recovering -> Standby)
 //
+// With the Persist option the state is saved to the Store (FileStore, RedisStore) on every transition,
+// so the breaker tripped before the restart of the proxy remains tripped after it.
+//
 package cbreaker
 
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/mailgun/oxy/memmetrics"
 	"github.com/mailgun/oxy/utils"
 	"github.com/mailgun/timetools"
 )
@@ -39,7 +42,9 @@ import (
 // CircuitBreaker is http.Handler that implements circuit breaker pattern
 type CircuitBreaker struct {
 	m       *sync.RWMutex
-	metrics *memmetrics.RTMetrics
+	metrics Metrics
+	// newMetrics is set by MetricsProvider
+	newMetrics NewMetricsFn
 
 	condition hpredicate
 	duration  time.Duration
@@ -47,22 +52,42 @@ type CircuitBreaker struct {
 	fallbackDuration time.Duration
 	recoveryDuration time.Duration
 
+	// backoffMax and backoffWindow are set by FallbackBackoff, retrips counts the trips
+	// since the breaker recovered for good at recoveredAt
+	backoffMax    time.Duration
+	backoffWindow time.Duration
+	retrips       int
+	recoveredAt   time.Time
+
 	onTripped SideEffect
 	onStandby SideEffect
+	// onTransition is called with the new state under the lock, set by PerServer
+	onTransition func(state cbState, until time.Time)
 
 	state cbState
 	until time.Time
 
 	rc *ratioController
+	// maxProbes bounds the requests in flight while recovering, probes are counted under m
+	maxProbes int
+	probes    int
 
 	checkPeriod time.Duration
 	lastCheck   time.Time
 
 	fallback http.Handler
 	next     http.Handler
+	classify func(*http.Request) bool
 
 	log   utils.Logger
 	clock timetools.TimeProvider
+
+	store    Store
+	storeKey string
+	// saves are serialized and the stale ones are skipped, persistSeq is guarded by m
+	persistMtx   sync.Mutex
+	persistSeq   uint64
+	persistedSeq uint64
 }
 
 // New creates a new CircuitBreaker middleware
@@ -90,33 +115,69 @@ func New(next http.Handler, expression string, options ...CircuitBreakerOption)
 		return nil, err
 	}
 	cb.condition = condition
+	if cb.backoffMax != 0 && cb.backoffMax < cb.fallbackDuration {
+		return nil, fmt.Errorf("fallback backoff max %v should be >= fallback duration %v", cb.backoffMax, cb.fallbackDuration)
+	}
 
-	mt, err := memmetrics.NewRTMetrics()
+	if cb.newMetrics == nil {
+		cb.newMetrics = cb.defaultMetrics
+	}
+	mt, err := cb.newMetrics()
 	if err != nil {
 		return nil, err
 	}
 	cb.metrics = mt
+	cb.restore()
 
 	return cb, nil
 }
 
 func (c *CircuitBreaker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if c.activateFallback(w, req) {
+	if c.classify != nil && !c.classify(req) {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+	fallback, probe := c.activateFallback(w, req)
+	if fallback {
 		c.fallback.ServeHTTP(w, req)
 		return
 	}
+	if probe {
+		defer c.releaseProbe()
+	}
 	c.serve(w, req)
 }
 
+// State returns the current state of the circuit breaker: "standby", "tripped" or "recovering"
+func (c *CircuitBreaker) State() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.state.String()
+}
+
+// Reset forces the circuit breaker into the standby state and resets the collected metrics
+func (c *CircuitBreaker) Reset() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.log.Infof("%v reset", c)
+	c.metrics.Reset()
+	c.retrips, c.recoveredAt = 0, time.Time{}
+	if c.state != stateStandby {
+		c.setState(stateStandby, c.clock.UtcNow())
+	}
+}
+
 func (c *CircuitBreaker) Wrap(next http.Handler) {
 	c.next = next
 }
 
-// updateState updates internal state and returns true if fallback should be used and false otherwise
-func (c *CircuitBreaker) activateFallback(w http.ResponseWriter, req *http.Request) bool {
+// updateState updates internal state and returns true if fallback should be used and false otherwise,
+// probe is set for the requests passed while recovering and counted against MaxRecoveryProbes
+func (c *CircuitBreaker) activateFallback(w http.ResponseWriter, req *http.Request) (fallback, probe bool) {
 	// Quick check with read locks optimized for normal operation use-case
 	if c.isStandby() {
-		return false
+		return false, false
 	}
 	// Circuit breaker is in tripped or recovering state
 	c.m.Lock()
@@ -127,10 +188,10 @@ func (c *CircuitBreaker) activateFallback(w http.ResponseWriter, req *http.Reque
 	switch c.state {
 	case stateStandby:
 		// someone else has set it to standby just now
-		return false
+		return false, false
 	case stateTripped:
 		if c.clock.UtcNow().Before(c.until) {
-			return true
+			return true, false
 		}
 		// We have been in active state enough, enter recovering state
 		c.setRecovering()
@@ -138,16 +199,30 @@ func (c *CircuitBreaker) activateFallback(w http.ResponseWriter, req *http.Reque
 	case stateRecovering:
 		// We have been in recovering state enough, enter standby and allow request
 		if c.clock.UtcNow().After(c.until) {
+			c.recoveredAt = c.clock.UtcNow()
 			c.setState(stateStandby, c.clock.UtcNow())
-			return false
+			return false, false
+		}
+		if c.maxProbes > 0 && c.probes >= c.maxProbes {
+			return true, false
 		}
 		// ratio controller allows this request
 		if c.rc.allowRequest() {
-			return false
+			if c.maxProbes > 0 {
+				c.probes++
+				return false, true
+			}
+			return false, false
 		}
-		return true
+		return true, false
 	}
-	return false
+	return false, false
+}
+
+func (c *CircuitBreaker) releaseProbe() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.probes--
 }
 
 func (c *CircuitBreaker) serve(w http.ResponseWriter, req *http.Request) {
@@ -157,7 +232,11 @@ func (c *CircuitBreaker) serve(w http.ResponseWriter, req *http.Request) {
 	c.next.ServeHTTP(p, req)
 
 	latency := c.clock.UtcNow().Sub(start)
-	c.metrics.Record(p.Code, latency)
+	// the metrics are read by the condition with the lock held
+	c.m.Lock()
+	c.metrics.RecordCode(p.StatusCode())
+	c.metrics.RecordLatency(latency)
+	c.m.Unlock()
 
 	// Note that this call is less expensive than it looks -- checkCondition only performs the real check
 	// periodically. Because of that we can afford to call it here on every single response.
@@ -196,6 +275,10 @@ func (c *CircuitBreaker) setState(new cbState, until time.Time) {
 	c.log.Infof("%v setting state to %v, until %v", c, new, until)
 	c.state = new
 	c.until = until
+	c.persist()
+	if c.onTransition != nil {
+		c.onTransition(new, until)
+	}
 	switch new {
 	case stateTripped:
 		c.exec(c.onTripped)
@@ -204,6 +287,56 @@ func (c *CircuitBreaker) setState(new cbState, until time.Time) {
 	}
 }
 
+// persist saves the current state in background, it is called with the lock held
+func (c *CircuitBreaker) persist() {
+	if c.store == nil {
+		return
+	}
+	c.persistSeq++
+	seq, st := c.persistSeq, PersistedState{State: c.state.String(), Until: c.until}
+	go func() {
+		c.persistMtx.Lock()
+		defer c.persistMtx.Unlock()
+		if seq < c.persistedSeq {
+			return
+		}
+		c.persistedSeq = seq
+		if err := c.store.Save(c.storeKey, st); err != nil {
+			c.log.Errorf("%v failed to save state: %v", c, err)
+		}
+	}()
+}
+
+// restore loads the state saved by the previous process, the side effects are not executed again
+func (c *CircuitBreaker) restore() {
+	if c.store == nil {
+		return
+	}
+	saved, err := c.store.Load(c.storeKey)
+	if err != nil {
+		c.log.Errorf("%v failed to load state: %v", c, err)
+		return
+	}
+	if saved == nil {
+		return
+	}
+	state, err := parseState(saved.State)
+	if err != nil {
+		c.log.Errorf("%v failed to load state: %v", c, err)
+		return
+	}
+	now := c.clock.UtcNow()
+	if state == stateStandby || !now.Before(saved.Until) {
+		return
+	}
+	c.state, c.until = state, saved.Until
+	if state == stateRecovering {
+		// ramp up again over the rest of the recovery period
+		c.rc = newRatioController(c.clock, saved.Until.Sub(now))
+	}
+	c.log.Infof("%v restored", c)
+}
+
 func (c *CircuitBreaker) timeToCheck() bool {
 	c.m.RLock()
 	defer c.m.RUnlock()
@@ -234,7 +367,7 @@ func (c *CircuitBreaker) checkAndSet() {
 		return
 	}
 
-	c.setState(stateTripped, c.clock.UtcNow().Add(c.fallbackDuration))
+	c.setState(stateTripped, c.clock.UtcNow().Add(c.nextFallbackDuration()))
 	c.metrics.Reset()
 }
 
@@ -283,6 +416,19 @@ func CheckPeriod(d time.Duration) CircuitBreakerOption {
 	}
 }
 
+// MaxRecoveryProbes bounds the requests in flight passed to the backend while the CircuitBreaker is recovering,
+// the requests above the limit get the fallback, so many concurrent requests don't probe the weak backend at once.
+// The breakers of PerServer have their own limits, one per server.
+func MaxRecoveryProbes(n int) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		if n <= 0 {
+			return fmt.Errorf("max recovery probes should be > 0, got %d", n)
+		}
+		c.maxProbes = n
+		return nil
+	}
+}
+
 // OnTripped sets a SideEffect to run when entering the Tripped state.
 // Only one SideEffect can be set for this hook.
 func OnTripped(s SideEffect) CircuitBreakerOption {
@@ -310,6 +456,40 @@ func Fallback(h http.Handler) CircuitBreakerOption {
 	}
 }
 
+// Classifier scopes the CircuitBreaker to the requests the function returns true for: only they feed the
+// statistics and get the fallback when the breaker is tripped, the others, e.g. health checks or static
+// assets, pass through untouched. See Methods and PathPrefixes for the common classifiers.
+func Classifier(fn func(*http.Request) bool) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		c.classify = fn
+		return nil
+	}
+}
+
+// Methods returns the classifier matching the request methods, e.g. the writes
+func Methods(methods ...string) func(*http.Request) bool {
+	return func(req *http.Request) bool {
+		for _, m := range methods {
+			if strings.EqualFold(req.Method, m) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathPrefixes returns the classifier matching the request paths starting with any of the prefixes
+func PathPrefixes(prefixes ...string) func(*http.Request) bool {
+	return func(req *http.Request) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(req.URL.Path, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 // Logger adds logging for the CircuitBreaker.
 func Logger(l utils.Logger) CircuitBreakerOption {
 	return func(c *CircuitBreaker) error {
@@ -266,15 +266,15 @@ func (s *CBSuite) TestSideEffects(c *C) {
 	}
 }
 
-func statsOK() *memmetrics.RTMetrics {
+func statsOK() Metrics {
 	m, err := memmetrics.NewRTMetrics()
 	if err != nil {
 		panic(err)
 	}
-	return m
+	return rtMetrics{m}
 }
 
-func statsNetErrors(threshold float64) *memmetrics.RTMetrics {
+func statsNetErrors(threshold float64) Metrics {
 	m, err := memmetrics.NewRTMetrics()
 	if err != nil {
 		panic(err)
@@ -286,19 +286,19 @@ func statsNetErrors(threshold float64) *memmetrics.RTMetrics {
 			m.Record(http.StatusOK, 0)
 		}
 	}
-	return m
+	return rtMetrics{m}
 }
 
-func statsLatencyAtQuantile(quantile float64, value time.Duration) *memmetrics.RTMetrics {
+func statsLatencyAtQuantile(quantile float64, value time.Duration) Metrics {
 	m, err := memmetrics.NewRTMetrics()
 	if err != nil {
 		panic(err)
 	}
 	m.Record(http.StatusOK, value)
-	return m
+	return rtMetrics{m}
 }
 
-func statsResponseCodes(codes ...statusCode) *memmetrics.RTMetrics {
+func statsResponseCodes(codes ...statusCode) Metrics {
 	m, err := memmetrics.NewRTMetrics()
 	if err != nil {
 		panic(err)
@@ -308,10 +308,127 @@ func statsResponseCodes(codes ...statusCode) *memmetrics.RTMetrics {
 			m.Record(c.Code, 0)
 		}
 	}
-	return m
+	return rtMetrics{m}
 }
 
 type statusCode struct {
 	Code  int
 	Count int64
 }
+
+func (s *CBSuite) TestMetricsUseClock(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	cb, err := New(handler, triggerNetRatio, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(cb.metrics.(rtMetrics).TotalCount(), Equals, int64(1))
+
+	// metrics window is driven by the breaker's clock, so the counters evaporate without sleeping
+	s.advanceTime(time.Minute)
+	c.Assert(cb.metrics.(rtMetrics).TotalCount(), Equals, int64(0))
+}
+
+func (s *CBSuite) TestReset(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	cb, err := New(handler, triggerNetRatio, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+
+	cb.metrics = statsNetErrors(0.6)
+	s.advanceTime(defaultCheckPeriod + time.Millisecond)
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(cb.State(), Equals, "tripped")
+
+	cb.Reset()
+	c.Assert(cb.State(), Equals, "standby")
+	c.Assert(cb.metrics.(rtMetrics).TotalCount(), Equals, int64(0))
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+}
+
+func (s *CBSuite) TestRecoveryWithTestClock(c *C) {
+	clock := testutils.NewClock()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	cb, err := New(handler, triggerNetRatio, Clock(clock))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+
+	cb.metrics = statsNetErrors(0.6)
+	clock.Advance(defaultCheckPeriod + time.Millisecond)
+	testutils.Get(srv.URL)
+	c.Assert(cb.State(), Equals, "tripped")
+
+	// every request is checking the state, so the breaker recovers within fallback and recovery durations
+	elapsed, ok := clock.AdvanceUntil(time.Second, time.Minute, func() bool {
+		testutils.Get(srv.URL)
+		return cb.State() == "standby"
+	})
+	c.Assert(ok, Equals, true)
+	c.Assert(elapsed > defaultFallbackDuration, Equals, true)
+	c.Assert(elapsed <= defaultFallbackDuration+defaultRecoveryDuration+time.Second, Equals, true)
+}
+
+func (s *CBSuite) TestClassifier(c *C) {
+	hits := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+		w.Write([]byte("hello"))
+	})
+
+	cb, err := New(handler, triggerNetRatio, Clock(s.clock), Classifier(PathPrefixes("/api/")))
+	c.Assert(err, IsNil)
+
+	cb.metrics = statsNetErrors(0.6)
+	s.advanceTime(defaultCheckPeriod + time.Millisecond)
+	w := httptest.NewRecorder()
+	cb.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/api/a", nil))
+	c.Assert(cb.State(), Equals, "tripped")
+
+	// the requests of the class get the fallback
+	w = httptest.NewRecorder()
+	cb.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/api/b", nil))
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+
+	// the others pass through and are not recorded
+	total := cb.metrics.(rtMetrics).TotalCount()
+	w = httptest.NewRecorder()
+	cb.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/health", nil))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(cb.metrics.(rtMetrics).TotalCount(), Equals, total)
+	c.Assert(hits, Equals, 2)
+}
+
+func (s *CBSuite) TestMethodsClassifier(c *C) {
+	writes := Methods("POST", "put")
+	c.Assert(writes(httptest.NewRequest("PUT", "http://localhost", nil)), Equals, true)
+	c.Assert(writes(httptest.NewRequest("GET", "http://localhost", nil)), Equals, false)
+}
+
+func (s *CBSuite) TestBadRecoveryProbes(c *C) {
+	_, err := New(nil, triggerNetRatio, MaxRecoveryProbes(0))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,62 @@
+package cbreaker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mailgun/oxy/memmetrics"
+)
+
+// Metrics are the stats of the requests the breaker expression is evaluated against. The breaker records
+// the requests it passes and reads the metrics with its lock held, so the implementations need no locking
+// of their own unless they are shared. The deployments aggregating the stats elsewhere, e.g. in the shared
+// memory of the sidecar, can ignore the recorded requests and answer the queries from their own data.
+type Metrics interface {
+	// RecordCode counts the request with the response code
+	RecordCode(code int)
+	// RecordLatency records the latency of the request
+	RecordLatency(d time.Duration)
+	// LatencyAtQuantile returns the latency at the quantile in percents, e.g. 99.9
+	LatencyAtQuantile(quantile float64) (time.Duration, error)
+	// NetworkErrorRatio returns the ratio of the network errors, e.g. 502 and 504, to the requests
+	NetworkErrorRatio() float64
+	// ResponseCodeRatio returns the ratio of the responses with the codes in [startA, endA) to the ones in [startB, endB)
+	ResponseCodeRatio(startA, endA, startB, endB int) float64
+	// Reset clears the metrics when the breaker trips or is reset
+	Reset()
+}
+
+// NewMetricsFn creates the metrics of the breaker, PerServer calls it for the breaker of every server
+type NewMetricsFn func() (Metrics, error)
+
+// MetricsProvider replaces the rolling window memmetrics.RTMetrics of the breaker with the custom metrics
+func MetricsProvider(fn NewMetricsFn) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		if fn == nil {
+			return fmt.Errorf("metrics provider can not be nil")
+		}
+		c.newMetrics = fn
+		return nil
+	}
+}
+
+// rtMetrics are the default metrics of the breaker
+type rtMetrics struct {
+	*memmetrics.RTMetrics
+}
+
+func (m rtMetrics) LatencyAtQuantile(quantile float64) (time.Duration, error) {
+	h, err := m.LatencyHistogram()
+	if err != nil {
+		return 0, err
+	}
+	return h.LatencyAtQuantile(quantile), nil
+}
+
+func (c *CircuitBreaker) defaultMetrics() (Metrics, error) {
+	m, err := memmetrics.NewRTMetrics(memmetrics.RTClock(c.clock))
+	if err != nil {
+		return nil, err
+	}
+	return rtMetrics{m}, nil
+}
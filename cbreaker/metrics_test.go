@@ -0,0 +1,95 @@
+package cbreaker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type MetricsSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&MetricsSuite{
+	clock: &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	},
+})
+
+// sharedMetrics ignores the recorded requests and answers from the stats set by the test
+type sharedMetrics struct {
+	codes     []int
+	latencies []time.Duration
+	netRatio  float64
+	latency   time.Duration
+	resets    int
+}
+
+func (m *sharedMetrics) RecordCode(code int)           { m.codes = append(m.codes, code) }
+func (m *sharedMetrics) RecordLatency(d time.Duration) { m.latencies = append(m.latencies, d) }
+func (m *sharedMetrics) NetworkErrorRatio() float64    { return m.netRatio }
+func (m *sharedMetrics) Reset()                        { m.resets++ }
+
+func (m *sharedMetrics) LatencyAtQuantile(quantile float64) (time.Duration, error) {
+	return m.latency, nil
+}
+
+func (m *sharedMetrics) ResponseCodeRatio(startA, endA, startB, endB int) float64 {
+	return 0
+}
+
+func (s *MetricsSuite) TestProviderTrips(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	m := &sharedMetrics{}
+	cb, err := New(handler, triggerNetRatio, Clock(s.clock), Fallback(fallbackResponse),
+		MetricsProvider(func() (Metrics, error) { return m, nil }))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(m.codes, DeepEquals, []int{http.StatusOK})
+	c.Assert(len(m.latencies), Equals, 1)
+
+	// the breaker trips on the stats aggregated elsewhere
+	m.netRatio = 0.6
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Minute)
+	testutils.Get(srv.URL)
+	c.Assert(cb.state, Equals, cbState(stateTripped))
+	c.Assert(m.resets, Equals, 1)
+
+	re, _, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *MetricsSuite) TestProviderLatency(c *C) {
+	m := &sharedMetrics{latency: 150 * time.Millisecond}
+	cb, err := New(http.NotFoundHandler(), `LatencyAtQuantileMS(50.0) > 100`, Clock(s.clock),
+		MetricsProvider(func() (Metrics, error) { return m, nil }))
+	c.Assert(err, IsNil)
+	c.Assert(cb.condition(cb), Equals, true)
+
+	m.latency = 50 * time.Millisecond
+	c.Assert(cb.condition(cb), Equals, false)
+}
+
+func (s *MetricsSuite) TestProviderErrors(c *C) {
+	_, err := New(http.NotFoundHandler(), triggerNetRatio, MetricsProvider(nil))
+	c.Assert(err, NotNil)
+
+	_, err = New(http.NotFoundHandler(), triggerNetRatio,
+		MetricsProvider(func() (Metrics, error) { return nil, fmt.Errorf("no shared memory") }))
+	c.Assert(err, ErrorMatches, "no shared memory")
+}
@@ -0,0 +1,134 @@
+package cbreaker
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Ejector stops routing the requests to the server for the duration, it is implemented by roundrobin.RoundRobin
+type Ejector interface {
+	EjectServer(u *url.URL, d time.Duration) error
+}
+
+// PerServer keeps a circuit breaker per backend server. It is placed after the load balancer, so the requests
+// are already routed to the servers, and ejects the server from the load balancer for the fallback duration
+// once its breaker trips. After the ejection the breaker recovers as usual, tripping and ejecting the server
+// again if the condition still matches.
+//
+//	perServer, _ := cbreaker.NewPerServer(fwd, `NetworkErrorRatio() > 0.5`, lb)
+//	lb.Wrap(perServer)
+//	lb.SetCircuitStates(perServer)
+type PerServer struct {
+	mtx        sync.Mutex
+	next       http.Handler
+	expression string
+	options    []CircuitBreakerOption
+	ejector    Ejector
+	breakers   map[string]*CircuitBreaker
+	log        utils.Logger
+}
+
+// NewPerServer returns the per server circuit breakers with the expression and options, ejecting
+// the servers with the tripped breakers from the ejector
+func NewPerServer(next http.Handler, expression string, ejector Ejector, options ...CircuitBreakerOption) (*PerServer, error) {
+	// the options are validated once upfront, the breakers of the servers are created on demand
+	cb, err := New(next, expression, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &PerServer{
+		next:       next,
+		expression: expression,
+		options:    options,
+		ejector:    ejector,
+		breakers:   make(map[string]*CircuitBreaker),
+		log:        cb.log,
+	}, nil
+}
+
+func (p *PerServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	cb, err := p.breaker(serverURL(req))
+	if err != nil {
+		p.log.Errorf("failed to create circuit breaker: %v", err)
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+		return
+	}
+	cb.ServeHTTP(w, req)
+}
+
+// Wrap sets the next handler of all the breakers
+func (p *PerServer) Wrap(next http.Handler) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.next = next
+	for _, cb := range p.breakers {
+		cb.Wrap(next)
+	}
+}
+
+// States returns the states of the breakers keyed by the server URL
+func (p *PerServer) States() map[string]string {
+	p.mtx.Lock()
+	breakers := make(map[string]*CircuitBreaker, len(p.breakers))
+	for key, cb := range p.breakers {
+		breakers[key] = cb
+	}
+	p.mtx.Unlock()
+
+	out := make(map[string]string, len(breakers))
+	for key, cb := range breakers {
+		out[key] = cb.State()
+	}
+	return out
+}
+
+// CircuitState returns the state of the breaker of the server, roundrobin.RoundRobin reports it in ServerStats
+func (p *PerServer) CircuitState(u *url.URL) (string, bool) {
+	p.mtx.Lock()
+	cb, ok := p.breakers[u.String()]
+	p.mtx.Unlock()
+	if !ok {
+		return "", false
+	}
+	return cb.State(), true
+}
+
+func (p *PerServer) breaker(u *url.URL) (*CircuitBreaker, error) {
+	key := u.String()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if cb, ok := p.breakers[key]; ok {
+		return cb, nil
+	}
+	cb, err := New(p.next, p.expression, p.options...)
+	if err != nil {
+		return nil, err
+	}
+	cb.onTransition = func(state cbState, until time.Time) {
+		if state != stateTripped {
+			return
+		}
+		if err := p.ejector.EjectServer(u, until.Sub(cb.clock.UtcNow())); err != nil {
+			p.log.Warningf("failed to eject server %v: %v", u, err)
+		}
+	}
+	p.breakers[key] = cb
+	return cb, nil
+}
+
+// serverURL returns the server selected by the load balancer for the request
+func serverURL(req *http.Request) *url.URL {
+	if bag := utils.BagFromRequest(req); bag != nil {
+		if u, ok := bag.Get(utils.BagBackend); ok {
+			if u, ok := u.(*url.URL); ok {
+				return u
+			}
+		}
+	}
+	return &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host}
+}
@@ -0,0 +1,149 @@
+package cbreaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type PerServerSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&PerServerSuite{})
+
+func (s *PerServerSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *PerServerSuite) TestEjectsTrippedServer(c *C) {
+	failing := "a"
+	served := map[string]int{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served[req.URL.Host]++
+		if req.URL.Host == failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+
+	lb, err := roundrobin.New(nil, roundrobin.Clock(s.clock))
+	c.Assert(err, IsNil)
+	a, b := testutils.ParseURI("http://a"), testutils.ParseURI("http://b")
+	lb.UpsertServer(a)
+	lb.UpsertServer(b)
+
+	perServer, err := NewPerServer(handler, triggerNetRatio, lb,
+		Clock(s.clock), CheckPeriod(time.Microsecond), FallbackDuration(10*time.Second), RecoveryDuration(10*time.Second))
+	c.Assert(err, IsNil)
+	lb.Wrap(perServer)
+	lb.SetCircuitStates(perServer)
+
+	serve := func(n int) {
+		for i := 0; i < n; i++ {
+			lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+			s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Millisecond)
+		}
+	}
+
+	// the first failure trips the breaker of a and ejects it
+	serve(2)
+	c.Assert(perServer.States(), DeepEquals, map[string]string{"http://a": "tripped", "http://b": "standby"})
+	c.Assert(lb.Ejected(a), Equals, true)
+	c.Assert(lb.Ejected(b), Equals, false)
+	st, err := lb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.Circuit, Equals, "tripped")
+	c.Assert(st.Ejected, Equals, true)
+	c.Assert(st.ReadmitIn, Equals, 10*time.Second-2*time.Millisecond)
+	st, err = lb.ServerStats(b)
+	c.Assert(err, IsNil)
+	c.Assert(st.Circuit, Equals, "standby")
+	c.Assert(st.Ejected, Equals, false)
+	c.Assert(st.ReadmitIn, Equals, time.Duration(0))
+
+	served = map[string]int{}
+	serve(10)
+	c.Assert(served, DeepEquals, map[string]int{"b": 10})
+
+	// the server is back after the fallback duration and recovers
+	failing = ""
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(10 * time.Second)
+	c.Assert(lb.Ejected(a), Equals, false)
+	serve(10)
+	c.Assert(perServer.States()["http://a"], Equals, "recovering")
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(11 * time.Second)
+	served = map[string]int{}
+	serve(10)
+	c.Assert(perServer.States()["http://a"], Equals, "standby")
+	c.Assert(served, DeepEquals, map[string]int{"a": 5, "b": 5})
+}
+
+func (s *PerServerSuite) TestRecoveryProbes(c *C) {
+	entered, release := make(chan string, 100), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- req.URL.Host
+		<-release
+		w.Write([]byte("hello"))
+	})
+	perServer, err := NewPerServer(handler, triggerNetRatio, nil,
+		Clock(s.clock), FallbackDuration(10*time.Second), RecoveryDuration(10*time.Second), MaxRecoveryProbes(2))
+	c.Assert(err, IsNil)
+
+	// both servers are recovering and most of the requests would pass without the limit
+	for _, host := range []string{"a", "b"} {
+		cb, err := perServer.breaker(testutils.ParseURI("http://" + host))
+		c.Assert(err, IsNil)
+		cb.m.Lock()
+		cb.setRecovering()
+		cb.m.Unlock()
+	}
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(9 * time.Second)
+
+	var wg sync.WaitGroup
+	probing := map[string]int{}
+	for i := 0; i < 20; i++ {
+		for _, host := range []string{"a", "b"} {
+			done := make(chan struct{})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				perServer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://"+host, nil))
+				close(done)
+			}()
+			select {
+			case h := <-entered:
+				probing[h]++
+			case <-done:
+			}
+		}
+	}
+	// every server has its own limit
+	c.Assert(probing, DeepEquals, map[string]int{"a": 2, "b": 2})
+	close(release)
+	wg.Wait()
+
+	// the probes are released
+	res := httptest.NewRecorder()
+	for i := 0; i < 10 && res.Code != http.StatusOK; i++ {
+		res = httptest.NewRecorder()
+		perServer.ServeHTTP(res, httptest.NewRequest("GET", "http://a", nil))
+	}
+	c.Assert(res.Code, Equals, http.StatusOK)
+}
+
+func (s *PerServerSuite) TestBadExpression(c *C) {
+	_, err := NewPerServer(nil, "Nope(", nil)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,138 @@
+package cbreaker
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/mailgun/oxy/tenancy"
+	"github.com/mailgun/oxy/utils"
+)
+
+// PerTenant keeps a circuit breaker per tenant, so the failures of the requests of one tenant do not trip
+// the breaker of the others. The breaker of the tenant trips on the condition of its config, the expression
+// of PerTenant by default, and is recreated once the condition of the config changes. Only the tenants in the store
+// get their own breakers, the others share one, so the made up tenants of the clients can not pile the breakers up.
+//
+//	perTenant, _ := cbreaker.NewPerTenant(lb, `NetworkErrorRatio() > 0.5`, tenants)
+type PerTenant struct {
+	mtx        sync.Mutex
+	next       http.Handler
+	expression string
+	options    []CircuitBreakerOption
+	tenants    *tenancy.Tenancy
+	breakers   map[string]*tenantBreaker
+	// shared is the breaker of the tenants missing from the store
+	shared *tenantBreaker
+	log    utils.Logger
+}
+
+type tenantBreaker struct {
+	cb *CircuitBreaker
+	// expression is the one of the config the breaker is created for
+	expression string
+}
+
+// NewPerTenant returns the per tenant circuit breakers with the default expression and the options
+func NewPerTenant(next http.Handler, expression string, tenants *tenancy.Tenancy, options ...CircuitBreakerOption) (*PerTenant, error) {
+	// the options are validated once upfront, the breakers of the tenants are created on demand
+	cb, err := New(next, expression, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &PerTenant{
+		next:       next,
+		expression: expression,
+		options:    options,
+		tenants:    tenants,
+		breakers:   make(map[string]*tenantBreaker),
+		log:        cb.log,
+	}, nil
+}
+
+func (p *PerTenant) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	tenant, err := p.tenants.Tenant(req)
+	if err != nil {
+		p.log.Errorf("failed to extract tenant: %v", err)
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+		return
+	}
+	c, known := p.tenants.Lookup(tenant)
+	cb, err := p.breaker(tenant, known, c.Breaker)
+	if err != nil {
+		p.log.Errorf("failed to create circuit breaker: %v", err)
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+		return
+	}
+	cb.ServeHTTP(w, req)
+}
+
+// Wrap sets the next handler of all the breakers
+func (p *PerTenant) Wrap(next http.Handler) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.next = next
+	for _, b := range p.breakers {
+		b.cb.Wrap(next)
+	}
+	if p.shared != nil {
+		p.shared.cb.Wrap(next)
+	}
+}
+
+// SharedTenants is the key of the shared breaker of the tenants missing from the store in States
+const SharedTenants = "*"
+
+// States returns the states of the breakers keyed by the tenant, the shared breaker is keyed by SharedTenants
+func (p *PerTenant) States() map[string]string {
+	p.mtx.Lock()
+	breakers := make(map[string]*CircuitBreaker, len(p.breakers)+1)
+	for tenant, b := range p.breakers {
+		breakers[tenant] = b.cb
+	}
+	if p.shared != nil {
+		breakers[SharedTenants] = p.shared.cb
+	}
+	p.mtx.Unlock()
+
+	out := make(map[string]string, len(breakers))
+	for tenant, cb := range breakers {
+		out[tenant] = cb.State()
+	}
+	return out
+}
+
+// breaker returns the breaker of the tenant with the expression of its config, the invalid expressions
+// fall back to the default one. The tenants missing from the store get the shared breaker.
+func (p *PerTenant) breaker(tenant string, known bool, expression string) (*CircuitBreaker, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	b := p.shared
+	if known {
+		b = p.breakers[tenant]
+	} else {
+		// the tenant removed from the store
+		delete(p.breakers, tenant)
+	}
+	if b != nil && b.expression == expression {
+		return b.cb, nil
+	}
+	var cb *CircuitBreaker
+	var err error
+	if expression != "" {
+		if cb, err = New(p.next, expression, p.options...); err != nil {
+			p.log.Warningf("invalid circuit breaker condition of %v, using the default: %v", tenant, err)
+		}
+	}
+	if cb == nil {
+		if cb, err = New(p.next, p.expression, p.options...); err != nil {
+			return nil, err
+		}
+	}
+	b = &tenantBreaker{cb: cb, expression: expression}
+	if known {
+		p.breakers[tenant] = b
+	} else {
+		p.shared = b
+	}
+	return cb, nil
+}
@@ -0,0 +1,114 @@
+package cbreaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/tenancy"
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type PerTenantSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&PerTenantSuite{})
+
+func (s *PerTenantSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *PerTenantSuite) TestIsolatedBreakers(c *C) {
+	failing := map[string]bool{"acme": true}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing[req.Header.Get("X-Tenant-Id")] {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+	extract, err := utils.NewExtractor("request.header.X-Tenant-Id")
+	c.Assert(err, IsNil)
+	store := tenancy.NewMemoryStore()
+	store.Set("acme", tenancy.Config{})
+	store.Set("initech", tenancy.Config{})
+	tenants, err := tenancy.New(extract, store)
+	c.Assert(err, IsNil)
+
+	perTenant, err := NewPerTenant(handler, triggerNetRatio, tenants,
+		Clock(s.clock), CheckPeriod(time.Microsecond), Fallback(fallbackResponse))
+	c.Assert(err, IsNil)
+
+	serve := func(tenant string, n int) int {
+		code := 0
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			req.Header.Set("X-Tenant-Id", tenant)
+			w := httptest.NewRecorder()
+			perTenant.ServeHTTP(w, req)
+			code = w.Code
+			s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Millisecond)
+		}
+		return code
+	}
+
+	// the failures of acme trip its breaker only
+	serve("acme", 2)
+	c.Assert(serve("initech", 2), Equals, http.StatusOK)
+	c.Assert(perTenant.States(), DeepEquals, map[string]string{"acme": "tripped", "initech": "standby"})
+	c.Assert(serve("acme", 1), Equals, http.StatusBadRequest)
+
+	// the breaker of the tenant follows its condition, the invalid conditions fall back to the default
+	failing["initech"] = true
+	store.Set("initech", tenancy.Config{Breaker: "LatencyAtQuantileMS(50.0) > 1000"})
+	serve("initech", 10)
+	c.Assert(perTenant.States()["initech"], Equals, "standby")
+
+	store.Set("initech", tenancy.Config{Breaker: "Invalid("})
+	serve("initech", 2)
+	c.Assert(perTenant.States()["initech"], Equals, "tripped")
+}
+
+func (s *PerTenantSuite) TestUnknownTenantsShared(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	extract, err := utils.NewExtractor("request.header.X-Tenant-Id")
+	c.Assert(err, IsNil)
+	store := tenancy.NewMemoryStore()
+	store.Set("acme", tenancy.Config{})
+	tenants, err := tenancy.New(extract, store)
+	c.Assert(err, IsNil)
+
+	perTenant, err := NewPerTenant(handler, triggerNetRatio, tenants,
+		Clock(s.clock), CheckPeriod(time.Microsecond), Fallback(fallbackResponse))
+	c.Assert(err, IsNil)
+
+	serve := func(tenant string) {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Tenant-Id", tenant)
+		perTenant.ServeHTTP(httptest.NewRecorder(), req)
+		s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Millisecond)
+	}
+
+	// the made up tenants share one breaker
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		serve(tenant)
+	}
+	c.Assert(perTenant.States(), DeepEquals, map[string]string{SharedTenants: "tripped"})
+
+	serve("acme")
+	_, ok := perTenant.States()["acme"]
+	c.Assert(ok, Equals, true)
+
+	// the breaker of the tenant removed from the store is dropped
+	store.Remove("acme")
+	serve("acme")
+	c.Assert(perTenant.States(), DeepEquals, map[string]string{SharedTenants: "tripped"})
+}
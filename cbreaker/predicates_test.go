@@ -1,7 +1,6 @@
 package cbreaker
 
 import (
-	"github.com/mailgun/oxy/memmetrics"
 	"time"
 
 	. "gopkg.in/check.v1"
@@ -15,7 +14,7 @@ var _ = Suite(&PredicatesSuite{})
 func (s *PredicatesSuite) TestTripped(c *C) {
 	predicates := []struct {
 		Expression string
-		M          *memmetrics.RTMetrics
+		M          Metrics
 		V          bool
 	}{
 		{
@@ -61,7 +60,7 @@ func (s *PredicatesSuite) TestTripped(c *C) {
 func (s *PredicatesSuite) TestErrors(c *C) {
 	predicates := []struct {
 		Expression string
-		M          *memmetrics.RTMetrics
+		M          Metrics
 	}{
 		{
 			Expression: "LatencyAtQuantileMS(40.0) > 50", // quantile not defined
@@ -0,0 +1,165 @@
+package cbreaker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+// RedisStore keeps the states in Redis, the expiring states are saved with the TTL, so they disappear
+// from Redis when they end. The connection is made per operation, the states change rarely.
+type RedisStore struct {
+	addr     string
+	password string
+	prefix   string
+	timeout  time.Duration
+	clock    timetools.TimeProvider
+}
+
+// RedisOption is a functional option setter for RedisStore
+type RedisOption func(*RedisStore) error
+
+// RedisPassword sets the password sent with AUTH command
+func RedisPassword(p string) RedisOption {
+	return func(r *RedisStore) error {
+		r.password = p
+		return nil
+	}
+}
+
+// RedisPrefix sets the prefix of the keys, "cbreaker:" by default
+func RedisPrefix(p string) RedisOption {
+	return func(r *RedisStore) error {
+		r.prefix = p
+		return nil
+	}
+}
+
+// RedisTimeout sets the timeout of the operations, 1 second by default
+func RedisTimeout(d time.Duration) RedisOption {
+	return func(r *RedisStore) error {
+		if d <= 0 {
+			return fmt.Errorf("timeout should be > 0")
+		}
+		r.timeout = d
+		return nil
+	}
+}
+
+// RedisClock sets the clock used to calculate the TTL of the states
+func RedisClock(clock timetools.TimeProvider) RedisOption {
+	return func(r *RedisStore) error {
+		r.clock = clock
+		return nil
+	}
+}
+
+// NewRedisStore returns the store keeping the states in Redis at host:port address
+func NewRedisStore(addr string, options ...RedisOption) (*RedisStore, error) {
+	r := &RedisStore{
+		addr:    addr,
+		prefix:  "cbreaker:",
+		timeout: time.Second,
+		clock:   &timetools.RealTime{},
+	}
+	for _, o := range options {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *RedisStore) Load(key string) (*PersistedState, error) {
+	reply, err := r.do("GET", r.prefix+key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	var s PersistedState
+	if err := json.Unmarshal([]byte(*reply), &s); err != nil {
+		return nil, fmt.Errorf("failed to parse state of %v: %v", key, err)
+	}
+	return &s, nil
+}
+
+func (r *RedisStore) Save(key string, s PersistedState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", r.prefix + key, string(data)}
+	if ttl := s.Until.Sub(r.clock.UtcNow()); s.State != cbState(stateStandby).String() && ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond)+1, 10))
+	}
+	_, err = r.do(args...)
+	return err
+}
+
+// do sends the command and returns the bulk or simple string reply, nil for the nil reply
+func (r *RedisStore) do(args ...string) (*string, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	rd := bufio.NewReader(conn)
+	if r.password != "" {
+		if _, err := command(conn, rd, "AUTH", r.password); err != nil {
+			return nil, err
+		}
+	}
+	return command(conn, rd, args...)
+}
+
+func command(w io.Writer, rd *bufio.Reader, args ...string) (*string, error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(rd)
+}
+
+func readReply(rd *bufio.Reader) (*string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+', ':':
+		s := line[1:]
+		return &s, nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis reply: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		s := string(buf[:n])
+		return &s, nil
+	}
+	return nil, fmt.Errorf("unsupported redis reply: %q", line)
+}
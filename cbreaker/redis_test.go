@@ -0,0 +1,145 @@
+package cbreaker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type RedisSuite struct{}
+
+var _ = Suite(&RedisSuite{})
+
+// fakeRedis serves GET, SET and AUTH commands from the map, recording the commands received
+type fakeRedis struct {
+	l        net.Listener
+	mtx      sync.Mutex
+	data     map[string]string
+	commands [][]string
+}
+
+func newFakeRedis(c *C) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	f := &fakeRedis{l: l, data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ = r.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		f.mtx.Lock()
+		f.commands = append(f.commands, args)
+		switch args[0] {
+		case "AUTH":
+			if args[1] == "secret" {
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "SET":
+			f.data[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		case "GET":
+			if v, ok := f.data[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		}
+		f.mtx.Unlock()
+	}
+}
+
+func (s *RedisSuite) TestSaveLoad(c *C) {
+	f := newFakeRedis(c)
+	defer f.l.Close()
+
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	store, err := NewRedisStore(f.l.Addr().String(), RedisPassword("secret"), RedisClock(clock))
+	c.Assert(err, IsNil)
+
+	st, err := store.Load("a")
+	c.Assert(err, IsNil)
+	c.Assert(st, IsNil)
+
+	until := clock.UtcNow().Add(10 * time.Second)
+	c.Assert(store.Save("a", PersistedState{State: "tripped", Until: until}), IsNil)
+	st, err = store.Load("a")
+	c.Assert(err, IsNil)
+	c.Assert(st.State, Equals, "tripped")
+	c.Assert(st.Until.Equal(until), Equals, true)
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	// AUTH, GET, AUTH, SET, AUTH, GET
+	c.Assert(len(f.commands), Equals, 6)
+	set := f.commands[3]
+	c.Assert(set[1], Equals, "cbreaker:a")
+	c.Assert(set[3:], DeepEquals, []string{"PX", "10001"})
+}
+
+func (s *RedisSuite) TestStandbyHasNoTTL(c *C) {
+	f := newFakeRedis(c)
+	defer f.l.Close()
+
+	store, err := NewRedisStore(f.l.Addr().String(), RedisPrefix("p:"))
+	c.Assert(err, IsNil)
+	c.Assert(store.Save("a", PersistedState{State: "standby"}), IsNil)
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	c.Assert(f.commands, HasLen, 1)
+	c.Assert(f.commands[0][1], Equals, "p:a")
+	c.Assert(f.commands[0], HasLen, 3)
+}
+
+func (s *RedisSuite) TestErrors(c *C) {
+	f := newFakeRedis(c)
+	store, err := NewRedisStore(f.l.Addr().String(), RedisPassword("wrong"))
+	c.Assert(err, IsNil)
+
+	_, err = store.Load("a")
+	c.Assert(err, ErrorMatches, ".*WRONGPASS.*")
+
+	f.l.Close()
+	_, err = store.Load("a")
+	c.Assert(err, NotNil)
+
+	_, err = NewRedisStore("localhost:6379", RedisTimeout(0))
+	c.Assert(err, NotNil)
+}
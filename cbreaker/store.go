@@ -0,0 +1,104 @@
+package cbreaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PersistedState is the state of the circuit breaker kept in the Store
+type PersistedState struct {
+	// State is "standby", "tripped" or "recovering"
+	State string `json:"state"`
+	// Until is the time the state ends, unused for the standby state
+	Until time.Time `json:"until"`
+}
+
+// Store keeps the state of the circuit breaker across restarts of the proxy
+type Store interface {
+	// Load returns the saved state, nil if there is none
+	Load(key string) (*PersistedState, error)
+	Save(key string, s PersistedState) error
+}
+
+// Persist makes the circuit breaker save its state transitions to the store under the key and
+// restore the tripped or recovering state on start, so a known-bad backend is not hammered after a restart
+func Persist(store Store, key string) CircuitBreakerOption {
+	return func(c *CircuitBreaker) error {
+		if store == nil {
+			return fmt.Errorf("store can not be nil")
+		}
+		if key == "" {
+			return fmt.Errorf("key can not be empty")
+		}
+		c.store, c.storeKey = store, key
+		return nil
+	}
+}
+
+func parseState(s string) (cbState, error) {
+	for _, st := range []cbState{stateStandby, stateTripped, stateRecovering} {
+		if st.String() == s {
+			return st, nil
+		}
+	}
+	return stateStandby, fmt.Errorf("unknown state: %q", s)
+}
+
+// FileStore keeps the states in JSON files, one per key, in the directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns the store keeping the states in the directory, the directory is created if it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(key string) string {
+	return filepath.Join(f.dir, url.PathEscape(key)+".json")
+}
+
+func (f *FileStore) Load(key string) (*PersistedState, error) {
+	data, err := ioutil.ReadFile(f.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var s PersistedState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", f.path(key), err)
+	}
+	return &s, nil
+}
+
+// Save writes the state to the temporary file and renames it, so the readers never see partial writes
+func (f *FileStore) Save(key string, s PersistedState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.dir, ".cbreaker")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
@@ -0,0 +1,121 @@
+package cbreaker
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type StoreSuite struct {
+	dir string
+}
+
+var _ = Suite(&StoreSuite{})
+
+func (s *StoreSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+// memStore signals the saves, so the tests can wait for the background persistence
+type memStore struct {
+	state *PersistedState
+	saved chan PersistedState
+}
+
+func (m *memStore) Load(key string) (*PersistedState, error) {
+	return m.state, nil
+}
+
+func (m *memStore) Save(key string, st PersistedState) error {
+	m.saved <- st
+	return nil
+}
+
+func (s *StoreSuite) TestFileStore(c *C) {
+	store, err := NewFileStore(filepath.Join(s.dir, "states"))
+	c.Assert(err, IsNil)
+
+	st, err := store.Load("backend/a")
+	c.Assert(err, IsNil)
+	c.Assert(st, IsNil)
+
+	until := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	c.Assert(store.Save("backend/a", PersistedState{State: "tripped", Until: until}), IsNil)
+	c.Assert(store.Save("backend/a", PersistedState{State: "recovering", Until: until}), IsNil)
+
+	st, err = store.Load("backend/a")
+	c.Assert(err, IsNil)
+	c.Assert(st.State, Equals, "recovering")
+	c.Assert(st.Until.Equal(until), Equals, true)
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, "states"))
+	c.Assert(err, IsNil)
+	c.Assert(len(files), Equals, 1)
+}
+
+func (s *StoreSuite) TestFileStoreCorrupted(c *C) {
+	store, err := NewFileStore(s.dir)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(store.path("a"), []byte("{"), 0644), IsNil)
+
+	_, err = store.Load("a")
+	c.Assert(err, NotNil)
+	c.Assert(os.Remove(store.path("a")), IsNil)
+}
+
+func (s *StoreSuite) TestPersistAndRestore(c *C) {
+	clock := testutils.NewClock()
+	store := &memStore{saved: make(chan PersistedState, 10)}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	cb, err := New(handler, triggerNetRatio, Clock(clock), Persist(store, "a"))
+	c.Assert(err, IsNil)
+
+	cb.metrics = statsNetErrors(0.6)
+	clock.Advance(defaultCheckPeriod + time.Millisecond)
+	cb.checkAndSet()
+	saved := <-store.saved
+	c.Assert(saved.State, Equals, "tripped")
+	c.Assert(saved.Until, Equals, clock.UtcNow().Add(defaultFallbackDuration))
+
+	// the restarted breaker stays tripped without running the side effects again
+	store.state = &saved
+	clock.Advance(time.Second)
+	cb, err = New(handler, triggerNetRatio, Clock(clock), Persist(store, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(cb.State(), Equals, "tripped")
+	c.Assert(len(store.saved), Equals, 0)
+
+	// the state saved before the fallback duration is over is restored as recovering
+	store.state = &PersistedState{State: "recovering", Until: clock.UtcNow().Add(time.Second)}
+	cb, err = New(handler, triggerNetRatio, Clock(clock), Persist(store, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(cb.State(), Equals, "recovering")
+
+	// the expired states are ignored
+	clock.Advance(time.Minute)
+	cb, err = New(handler, triggerNetRatio, Clock(clock), Persist(store, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(cb.State(), Equals, "standby")
+
+	// unknown states are ignored
+	store.state = &PersistedState{State: "open", Until: clock.UtcNow().Add(time.Minute)}
+	cb, err = New(handler, triggerNetRatio, Clock(clock), Persist(store, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(cb.State(), Equals, "standby")
+}
+
+func (s *StoreSuite) TestPersistBadOptions(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	_, err := New(handler, triggerNetRatio, Persist(nil, "a"))
+	c.Assert(err, NotNil)
+	_, err = New(handler, triggerNetRatio, Persist(&memStore{}, ""))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,102 @@
+// Package oxy provides helpers to compose oxy middlewares into a single http handler.
+//
+//	fwd, _ := forward.New()
+//	lb, _ := roundrobin.New(fwd)
+//	limiter, _ := connlimit.New(nil, extractor, 10)
+//	cb, _ := cbreaker.New(nil, `NetworkErrorRatio() > 0.5`)
+//
+//	// requests flow through the breaker, the limiter and the load balancer to the forwarder
+//	handler, _ := oxy.Chain(cb, limiter, lb)
+package oxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// RequestIDHeader is the request header used as the request ID if present
+const RequestIDHeader = "X-Request-Id"
+
+// Wrapper is implemented by middlewares that pass the request to the next handler, e.g. cbreaker.CircuitBreaker
+type Wrapper interface {
+	Wrap(next http.Handler)
+}
+
+// ErrWrapper is implemented by middlewares that can fail to wrap the next handler, e.g. stream.Streamer
+type ErrWrapper interface {
+	Wrap(next http.Handler) error
+}
+
+// Chain wires the handlers in order, so every handler passes the request to the next one in the list.
+// The last handler terminates the chain and is kept as is, all other handlers have to implement Wrapper or ErrWrapper.
+// The resulting handler attaches a utils.Bag to every request, so the middlewares can share per-request state,
+// the bag is pre-populated with the request ID taken from the X-Request-Id header or generated.
+func Chain(handlers ...http.Handler) (http.Handler, error) {
+	if len(handlers) == 0 {
+		return nil, fmt.Errorf("provide at least one handler")
+	}
+	next := handlers[len(handlers)-1]
+	for i := len(handlers) - 2; i >= 0; i-- {
+		switch h := handlers[i].(type) {
+		case Wrapper:
+			h.Wrap(next)
+		case ErrWrapper:
+			if err := h.Wrap(next); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%T at position %d can't wrap the next handler", handlers[i], i)
+		}
+		next = handlers[i]
+	}
+	return &chain{next: next}, nil
+}
+
+// Closer is implemented by middlewares supporting graceful shutdown, e.g. forward.Forwarder
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// Close shuts the handlers down in order, so the handlers in front of the chain stop accepting requests first
+// and the ones behind only wait for the requests already passed to them. Handlers not implementing Closer are skipped.
+// All handlers are closed even if some fail, the first error is returned.
+func Close(ctx context.Context, handlers ...http.Handler) error {
+	var firstErr error
+	for _, h := range handlers {
+		if c, ok := h.(Closer); ok {
+			if err := c.Close(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+type chain struct {
+	next http.Handler
+}
+
+func (c *chain) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if utils.BagFromRequest(req) == nil {
+		bag := utils.NewBag()
+		bag.Set(utils.BagRequestID, requestID(req))
+		req = utils.WithBag(req, bag)
+	}
+	c.next.ServeHTTP(w, req)
+}
+
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
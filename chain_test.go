@@ -0,0 +1,177 @@
+package oxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/connlimit"
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/stream"
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestChain(t *testing.T) { TestingT(t) }
+
+type ChainSuite struct{}
+
+var _ = Suite(&ChainSuite{})
+
+func (s *ChainSuite) TestChain(c *C) {
+	srv := testutils.NewResponder("hello")
+	defer srv.Close()
+
+	var bag *utils.Bag
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+	observe := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		bag = utils.BagFromRequest(req)
+		fwd.ServeHTTP(w, req)
+	})
+
+	cl, err := connlimit.New(nil, utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+		return "a", 1, nil
+	}), 10)
+	c.Assert(err, IsNil)
+
+	st, err := stream.New(nil)
+	c.Assert(err, IsNil)
+
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	c.Assert(lb.UpsertServer(testutils.ParseURI(srv.URL)), IsNil)
+
+	h, err := Chain(cl, st, lb, observe)
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(h.ServeHTTP)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL, testutils.Header(RequestIDHeader, "req-1"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+
+	c.Assert(bag, NotNil)
+	id, _ := bag.Get(utils.BagRequestID)
+	c.Assert(id, Equals, "req-1")
+	backend, _ := bag.Get(utils.BagBackend)
+	c.Assert(backend.(*url.URL).String(), Equals, srv.URL)
+	attempt, _ := bag.Get(utils.BagAttempt)
+	c.Assert(attempt, Equals, 1)
+}
+
+func (s *ChainSuite) TestClose(c *C) {
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+
+	cl, err := connlimit.New(nil, utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+		return "a", 1, nil
+	}), 10)
+	c.Assert(err, IsNil)
+
+	st, err := stream.New(nil)
+	c.Assert(err, IsNil)
+
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	c.Assert(lb.UpsertServer(testutils.ParseURI(srv.URL)), IsNil)
+
+	h, err := Chain(cl, st, lb, fwd)
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(h.ServeHTTP)
+	defer proxy.Close()
+
+	served := make(chan string, 1)
+	go func() {
+		_, body, _ := testutils.Get(proxy.URL)
+		served <- string(body)
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- Close(context.Background(), cl, st, lb, fwd)
+	}()
+
+	// the request in flight keeps the chain open
+	select {
+	case <-closed:
+		c.Fatalf("closed with request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Assert(cl.TotalConnections(), Equals, int64(1))
+
+	close(release)
+	c.Assert(<-served, Equals, "hello")
+	c.Assert(<-closed, IsNil)
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *ChainSuite) TestCloseTimeout(c *C) {
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})
+	defer srv.Close()
+
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		fwd.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+	// deferred last, so the backend is released before the servers are closed
+	defer close(release)
+
+	go testutils.Get(proxy.URL)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(Close(ctx, fwd), Equals, context.DeadlineExceeded)
+}
+
+func (s *ChainSuite) TestGeneratedRequestID(c *C) {
+	var id interface{}
+	h, err := Chain(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, _ = utils.BagFromRequest(req).Get(utils.BagRequestID)
+	}))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(h.ServeHTTP)
+	defer proxy.Close()
+
+	_, _, err = testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(len(id.(string)), Equals, 32)
+}
+
+func (s *ChainSuite) TestBadChain(c *C) {
+	_, err := Chain()
+	c.Assert(err, NotNil)
+
+	plain := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	_, err = Chain(plain, plain)
+	c.Assert(err, NotNil)
+}
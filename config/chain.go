@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mailgun/oxy/cbreaker"
+	"github.com/mailgun/oxy/connlimit"
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/ratelimit"
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/utils"
+)
+
+// Chain is the http handler built from the Config. The load balancer lives as long as the chain,
+// so updates of the servers keep its state, while the middlewares in front of it are rebuilt
+// when their configuration changes. The requests in flight finish on the previous middlewares.
+type Chain struct {
+	mtx sync.Mutex
+	cfg Config
+	fwd http.Handler
+	lb  *roundrobin.RoundRobin
+	// handler holds the current handlerBox
+	handler atomic.Value
+	log     utils.Logger
+}
+
+// handlerBox keeps the type stored in atomic.Value the same regardless of the front middleware
+type handlerBox struct {
+	http.Handler
+}
+
+// Option is a functional option setter for Chain
+type Option func(c *Chain) error
+
+// Forwarder sets the handler the load balancer passes the requests to, forward.Forwarder by default
+func Forwarder(fwd http.Handler) Option {
+	return func(c *Chain) error {
+		c.fwd = fwd
+		return nil
+	}
+}
+
+// Logger sets the logger used by the chain
+func Logger(l utils.Logger) Option {
+	return func(c *Chain) error {
+		c.log = l
+		return nil
+	}
+}
+
+// New builds the chain from the configuration
+func New(cfg Config, options ...Option) (*Chain, error) {
+	c := &Chain{}
+	for _, o := range options {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.log == nil {
+		c.log = utils.NullLogger
+	}
+	if c.fwd == nil {
+		fwd, err := forward.New(forward.Logger(c.log))
+		if err != nil {
+			return nil, err
+		}
+		c.fwd = fwd
+	}
+	lb, err := roundrobin.New(c.fwd)
+	if err != nil {
+		return nil, err
+	}
+	c.lb = lb
+	if err := c.Update(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Config returns the configuration currently applied
+func (c *Chain) Config() Config {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.cfg.clone()
+}
+
+// Update applies the configuration. It is atomic: the new chain is built and validated first
+// and nothing is changed if it fails.
+func (c *Chain) Update(cfg Config) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	servers, err := cfg.serverURLs()
+	if err != nil {
+		return err
+	}
+
+	var handler http.Handler
+	if c.handler.Load() == nil || !sameMiddlewares(c.cfg, cfg) {
+		if handler, err = buildMiddlewares(cfg, c.lb, c.log); err != nil {
+			return err
+		}
+	}
+
+	c.updateServers(servers)
+	if handler != nil {
+		c.handler.Store(handlerBox{handler})
+	}
+	c.cfg = cfg.clone()
+	return nil
+}
+
+func (c *Chain) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.handler.Load().(handlerBox).ServeHTTP(w, req)
+}
+
+// updateServers applies the difference between the current and the new servers to the load balancer,
+// the URLs are validated already, so the load balancer can't fail
+func (c *Chain) updateServers(servers []weightedURL) {
+	keep := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		keep[s.u.String()] = true
+		if weight, ok := c.lb.ServerWeight(s.u); ok && weight == s.weight {
+			continue
+		}
+		c.log.Infof("upsert server %v, weight %v", s.u, s.weight)
+		c.lb.UpsertServer(s.u, roundrobin.Weight(s.weight))
+	}
+	for _, u := range c.lb.Servers() {
+		if !keep[u.String()] {
+			c.log.Infof("remove server %v", u)
+			c.lb.RemoveServer(u)
+		}
+	}
+}
+
+func sameMiddlewares(a, b Config) bool {
+	return reflect.DeepEqual(a.Breaker, b.Breaker) &&
+		reflect.DeepEqual(a.ConnLimit, b.ConnLimit) &&
+		reflect.DeepEqual(a.RateLimit, b.RateLimit)
+}
+
+// buildMiddlewares builds the middlewares in front of the load balancer
+func buildMiddlewares(cfg Config, lb http.Handler, log utils.Logger) (http.Handler, error) {
+	next := lb
+	if rl := cfg.RateLimit; rl != nil {
+		extract, err := utils.NewExtractor(rl.Source)
+		if err != nil {
+			return nil, fieldError("rateLimit.source", "%v", err)
+		}
+		rates := ratelimit.NewRateSet()
+		for i, r := range rl.Rates {
+			if err := rates.Add(r.Period, r.Average, r.Burst); err != nil {
+				return nil, fieldError(fmt.Sprintf("rateLimit.rates[%d]", i), "%v", err)
+			}
+		}
+		if next, err = ratelimit.New(next, extract, rates, ratelimit.Logger(log)); err != nil {
+			return nil, fieldError("rateLimit", "%v", err)
+		}
+	}
+	if cl := cfg.ConnLimit; cl != nil {
+		extract, err := utils.NewExtractor(cl.Source)
+		if err != nil {
+			return nil, fieldError("connLimit.source", "%v", err)
+		}
+		if next, err = connlimit.New(next, extract, cl.Max, connlimit.Logger(log)); err != nil {
+			return nil, fieldError("connLimit", "%v", err)
+		}
+	}
+	if b := cfg.Breaker; b != nil {
+		options := []cbreaker.CircuitBreakerOption{cbreaker.Logger(log)}
+		if b.FallbackDuration > 0 {
+			options = append(options, cbreaker.FallbackDuration(b.FallbackDuration))
+		}
+		if b.RecoveryDuration > 0 {
+			options = append(options, cbreaker.RecoveryDuration(b.RecoveryDuration))
+		}
+		if b.CheckPeriod > 0 {
+			options = append(options, cbreaker.CheckPeriod(b.CheckPeriod))
+		}
+		cb, err := cbreaker.New(next, b.Expression, options...)
+		if err != nil {
+			return nil, fieldError("breaker.expression", "%v", err)
+		}
+		next = cb
+	}
+	return next, nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ChainSuite struct{}
+
+var _ = Suite(&ChainSuite{})
+
+func (s *ChainSuite) TestServe(c *C) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+
+	ch, err := New(Config{Servers: []Server{{URL: a.URL}}})
+	c.Assert(err, IsNil)
+	proxy := httptest.NewServer(ch)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "a")
+}
+
+func (s *ChainSuite) TestUpdateServers(c *C) {
+	a, b := testutils.NewResponder("a"), testutils.NewResponder("b")
+	defer a.Close()
+	defer b.Close()
+
+	ch, err := New(Config{Servers: []Server{{URL: a.URL}}})
+	c.Assert(err, IsNil)
+	proxy := httptest.NewServer(ch)
+	defer proxy.Close()
+
+	c.Assert(ch.Update(Config{Servers: []Server{{URL: a.URL}, {URL: b.URL, Weight: 2}}}), IsNil)
+	c.Assert(len(ch.lb.Servers()), Equals, 2)
+	weight, ok := ch.lb.ServerWeight(testutils.ParseURI(b.URL))
+	c.Assert(ok, Equals, true)
+	c.Assert(weight, Equals, 2)
+
+	c.Assert(ch.Update(Config{Servers: []Server{{URL: b.URL}}}), IsNil)
+	for i := 0; i < 3; i++ {
+		_, body, err := testutils.Get(proxy.URL)
+		c.Assert(err, IsNil)
+		c.Assert(string(body), Equals, "b")
+	}
+	c.Assert(ch.Config().Servers, DeepEquals, []Server{{URL: b.URL}})
+}
+
+func (s *ChainSuite) TestUpdateMiddlewares(c *C) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	ch, err := New(Config{Servers: []Server{{URL: srv.URL}}})
+	c.Assert(err, IsNil)
+	before := ch.handler.Load()
+
+	// unchanged middlewares are kept
+	c.Assert(ch.Update(Config{Servers: []Server{{URL: srv.URL}}}), IsNil)
+	c.Assert(ch.handler.Load(), Equals, before)
+
+	c.Assert(ch.Update(Config{
+		Servers:   []Server{{URL: srv.URL}},
+		ConnLimit: &ConnLimit{Source: "request.header.X-Client", Max: 1},
+	}), IsNil)
+	c.Assert(ch.handler.Load(), Not(Equals), before)
+
+	proxy := httptest.NewServer(ch)
+	defer proxy.Close()
+	// deferred last, so the backend is released before the servers are closed
+	defer close(release)
+
+	go testutils.Get(proxy.URL, testutils.Header("X-Client", "a"))
+	<-started
+
+	re, _, err := testutils.Get(proxy.URL, testutils.Header("X-Client", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, 429)
+}
+
+func (s *ChainSuite) TestUpdateAtomic(c *C) {
+	a := testutils.NewResponder("a")
+	defer a.Close()
+
+	ch, err := New(Config{Servers: []Server{{URL: a.URL}}})
+	c.Assert(err, IsNil)
+	before := ch.handler.Load()
+
+	// the rate limit source is invalid, so the servers are not changed either
+	err = ch.Update(Config{
+		Servers:   []Server{{URL: "http://localhost:5000"}},
+		RateLimit: &RateLimit{Source: "request.unknown", Rates: []Rate{{Period: time.Second, Average: 1, Burst: 1}}},
+	})
+	c.Assert(err, NotNil)
+	c.Assert(ch.handler.Load(), Equals, before)
+	c.Assert(ch.lb.Servers()[0].String(), Equals, a.URL)
+	c.Assert(ch.Config().Servers, DeepEquals, []Server{{URL: a.URL}})
+}
+
+func (s *ChainSuite) TestBadConfig(c *C) {
+	_, err := New(Config{Servers: []Server{{URL: "localhost"}}})
+	c.Assert(err, NotNil)
+}
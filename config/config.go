@@ -0,0 +1,193 @@
+// Package config builds oxy middleware chains from a declarative configuration and updates them at runtime.
+//
+//	c, _ := config.New(config.Config{
+//		Servers:   []config.Server{{URL: "http://localhost:5000", Weight: 1}},
+//		ConnLimit: &config.ConnLimit{Source: "client.ip", Max: 10},
+//	})
+//	http.ListenAndServe(":8080", c)
+//
+//	// later, e.g. after the config file has changed
+//	c.Update(newConfig)
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/cbreaker"
+	"github.com/mailgun/oxy/utils"
+)
+
+// Config describes the chain: the requests pass the circuit breaker, the connection limiter
+// and the rate limiter, all optional, and are load balanced between the servers
+type Config struct {
+	Servers   []Server   `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Breaker   *Breaker   `json:"breaker,omitempty" yaml:"breaker,omitempty"`
+	ConnLimit *ConnLimit `json:"connLimit,omitempty" yaml:"connLimit,omitempty"`
+	RateLimit *RateLimit `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+}
+
+// Server is a backend the requests are load balanced to
+type Server struct {
+	URL string `json:"url" yaml:"url"`
+	// Weight of the server, 1 if not set
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// Breaker configures the circuit breaker, see cbreaker.New, zero durations mean defaults.
+// The durations are written as strings like 1m30s, see MarshalJSON.
+type Breaker struct {
+	Expression       string        `yaml:"expression"`
+	FallbackDuration time.Duration `yaml:"fallbackDuration,omitempty"`
+	RecoveryDuration time.Duration `yaml:"recoveryDuration,omitempty"`
+	CheckPeriod      time.Duration `yaml:"checkPeriod,omitempty"`
+}
+
+// ConnLimit limits the concurrent connections per source, see connlimit.New
+type ConnLimit struct {
+	// Source is the variable the connections are counted by, e.g. client.ip, see utils.NewExtractor
+	Source string `json:"source" yaml:"source"`
+	Max    int64  `json:"max" yaml:"max"`
+}
+
+// RateLimit limits the request rate per source, see ratelimit.New
+type RateLimit struct {
+	// Source is the variable the requests are counted by, e.g. client.ip, see utils.NewExtractor
+	Source string `json:"source" yaml:"source"`
+	Rates  []Rate `json:"rates" yaml:"rates"`
+}
+
+// Rate allows Average requests per Period with bursts up to Burst requests,
+// the period is written as string like 1m, see MarshalJSON
+type Rate struct {
+	Period  time.Duration `yaml:"period"`
+	Average int64         `yaml:"average"`
+	Burst   int64         `yaml:"burst"`
+}
+
+// FieldError is the validation error of the configuration field, the field is the path
+// in the configuration file, e.g. servers[1].url
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Field, e.Reason)
+}
+
+func fieldError(field, format string, args ...interface{}) *FieldError {
+	return &FieldError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks the configuration without building the chain, the errors are *FieldError
+func (c *Config) Validate() error {
+	_, err := c.serverURLs()
+	if err != nil {
+		return err
+	}
+	if b := c.Breaker; b != nil {
+		if b.Expression == "" {
+			return fieldError("breaker.expression", "can not be empty")
+		}
+		durations := []struct {
+			field string
+			d     time.Duration
+		}{
+			{"breaker.fallbackDuration", b.FallbackDuration},
+			{"breaker.recoveryDuration", b.RecoveryDuration},
+			{"breaker.checkPeriod", b.CheckPeriod},
+		}
+		for _, d := range durations {
+			if d.d < 0 {
+				return fieldError(d.field, "can not be negative, got %v", d.d)
+			}
+		}
+		if _, err := cbreaker.New(http.NotFoundHandler(), b.Expression); err != nil {
+			return fieldError("breaker.expression", "%v", err)
+		}
+	}
+	if cl := c.ConnLimit; cl != nil {
+		if _, err := utils.NewExtractor(cl.Source); err != nil {
+			return fieldError("connLimit.source", "%v", err)
+		}
+		if cl.Max <= 0 {
+			return fieldError("connLimit.max", "should be > 0, got %d", cl.Max)
+		}
+	}
+	if rl := c.RateLimit; rl != nil {
+		if _, err := utils.NewExtractor(rl.Source); err != nil {
+			return fieldError("rateLimit.source", "%v", err)
+		}
+		if len(rl.Rates) == 0 {
+			return fieldError("rateLimit.rates", "at least one rate is required")
+		}
+		for i, r := range rl.Rates {
+			if r.Period <= 0 {
+				return fieldError(fmt.Sprintf("rateLimit.rates[%d].period", i), "should be > 0, got %v", r.Period)
+			}
+			if r.Average <= 0 {
+				return fieldError(fmt.Sprintf("rateLimit.rates[%d].average", i), "should be > 0, got %d", r.Average)
+			}
+			if r.Burst <= 0 {
+				return fieldError(fmt.Sprintf("rateLimit.rates[%d].burst", i), "should be > 0, got %d", r.Burst)
+			}
+		}
+	}
+	return nil
+}
+
+// clone returns a deep copy, so changes of the caller's values don't affect the applied configuration
+func (c Config) clone() Config {
+	out := Config{Servers: append([]Server(nil), c.Servers...)}
+	if c.Breaker != nil {
+		b := *c.Breaker
+		out.Breaker = &b
+	}
+	if c.ConnLimit != nil {
+		cl := *c.ConnLimit
+		out.ConnLimit = &cl
+	}
+	if c.RateLimit != nil {
+		rl := *c.RateLimit
+		rl.Rates = append([]Rate(nil), rl.Rates...)
+		out.RateLimit = &rl
+	}
+	return out
+}
+
+// serverURLs parses the server URLs and returns them along with the weights
+func (c *Config) serverURLs() ([]weightedURL, error) {
+	out := make([]weightedURL, 0, len(c.Servers))
+	seen := make(map[string]bool, len(c.Servers))
+	for i, s := range c.Servers {
+		field := fmt.Sprintf("servers[%d]", i)
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return nil, fieldError(field+".url", "%v", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fieldError(field+".url", "'%v' should have scheme and host", s.URL)
+		}
+		if seen[u.String()] {
+			return nil, fieldError(field+".url", "duplicate url '%v'", s.URL)
+		}
+		seen[u.String()] = true
+		if s.Weight < 0 {
+			return nil, fieldError(field+".weight", "should be >= 0, got %d", s.Weight)
+		}
+		weight := s.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		out = append(out, weightedURL{u: u, weight: weight})
+	}
+	return out, nil
+}
+
+type weightedURL struct {
+	u      *url.URL
+	weight int
+}
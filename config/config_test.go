@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestConfig(t *testing.T) { TestingT(t) }
+
+type ConfigSuite struct{}
+
+var _ = Suite(&ConfigSuite{})
+
+func (s *ConfigSuite) TestValidate(c *C) {
+	cfg := Config{
+		Servers:   []Server{{URL: "http://localhost:5000"}, {URL: "http://localhost:5001", Weight: 2}},
+		Breaker:   &Breaker{Expression: "NetworkErrorRatio() > 0.5"},
+		ConnLimit: &ConnLimit{Source: "client.ip", Max: 10},
+		RateLimit: &RateLimit{Source: "request.host", Rates: []Rate{{Period: time.Second, Average: 10, Burst: 20}}},
+	}
+	c.Assert(cfg.Validate(), IsNil)
+
+	servers, err := cfg.serverURLs()
+	c.Assert(err, IsNil)
+	c.Assert(servers[0].weight, Equals, 1)
+	c.Assert(servers[1].weight, Equals, 2)
+}
+
+func (s *ConfigSuite) TestValidateErrors(c *C) {
+	configs := []Config{
+		{Servers: []Server{{URL: "localhost:5000"}}},
+		{Servers: []Server{{URL: "http://localhost:5000"}, {URL: "http://localhost:5000"}}},
+		{Servers: []Server{{URL: "http://localhost:5000", Weight: -1}}},
+		{Breaker: &Breaker{}},
+		{Breaker: &Breaker{Expression: "LatencyAtQuantileMS(50.0) > 50", CheckPeriod: -time.Second}},
+		{ConnLimit: &ConnLimit{Max: 1}},
+		{ConnLimit: &ConnLimit{Source: "client.ip"}},
+		{RateLimit: &RateLimit{Source: "client.ip"}},
+		{RateLimit: &RateLimit{Source: "client.ip", Rates: []Rate{{Period: time.Second, Average: 1}}}},
+	}
+	for i, cfg := range configs {
+		c.Assert(cfg.Validate(), NotNil, Commentf("config %d", i))
+	}
+}
+
+func (s *ConfigSuite) TestClone(c *C) {
+	cfg := Config{
+		ConnLimit: &ConnLimit{Source: "client.ip", Max: 10},
+		RateLimit: &RateLimit{Source: "client.ip", Rates: []Rate{{Period: time.Second, Average: 1, Burst: 1}}},
+	}
+	out := cfg.clone()
+	cfg.ConnLimit.Max = 1
+	cfg.RateLimit.Rates[0].Average = 2
+	c.Assert(out.ConnLimit.Max, Equals, int64(10))
+	c.Assert(out.RateLimit.Rates[0].Average, Equals, int64(1))
+}
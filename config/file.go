@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Format is the format of the configuration file
+type Format int
+
+const (
+	YAML Format = iota
+	JSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case YAML:
+		return "yaml"
+	case JSON:
+		return "json"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// FormatOf returns the format by the file extension: .yaml, .yml or .json
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML, nil
+	case ".json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("unsupported config file extension '%v'", filepath.Ext(path))
+}
+
+// Unmarshal decodes and validates the configuration. Unknown fields are rejected, decoding errors
+// point at the line of the offending field and validation errors are *FieldError.
+func Unmarshal(data []byte, format Format) (*Config, error) {
+	var cfg Config
+	switch format {
+	case YAML:
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, err
+		}
+	case JSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, jsonError(data, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %v", format)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Marshal encodes the configuration, the durations are written as strings like 1m30s
+func Marshal(cfg Config, format Format) ([]byte, error) {
+	switch format {
+	case YAML:
+		return yaml.Marshal(cfg)
+	case JSON:
+		return json.MarshalIndent(cfg, "", "  ")
+	}
+	return nil, fmt.Errorf("unsupported format %v", format)
+}
+
+// LoadFile reads the configuration file, the format is chosen by the extension, see FormatOf
+func LoadFile(path string) (*Config, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Unmarshal(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// jsonError adds the line number to the JSON decoding errors
+func jsonError(data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+		if e.Field != "" {
+			return fmt.Errorf("line %d: %v: cannot decode %v into %v", lineOf(data, offset), e.Field, e.Value, e.Type)
+		}
+	default:
+		return err
+	}
+	return fmt.Errorf("line %d: %v", lineOf(data, offset), err)
+}
+
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// jsonDuration is written to JSON as string like 1m30s, numbers are read as nanoseconds
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	if len(data) != 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(v)
+		return nil
+	}
+	var ns int64
+	if err := json.Unmarshal(data, &ns); err != nil {
+		return fmt.Errorf("duration should be a string like 1m30s, got %s", data)
+	}
+	*d = jsonDuration(ns)
+	return nil
+}
+
+// decodeStrict decodes the object rejecting unknown fields, as the custom unmarshalers
+// don't inherit the settings of the outer decoder
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+type breakerJSON struct {
+	Expression       string       `json:"expression"`
+	FallbackDuration jsonDuration `json:"fallbackDuration,omitempty"`
+	RecoveryDuration jsonDuration `json:"recoveryDuration,omitempty"`
+	CheckPeriod      jsonDuration `json:"checkPeriod,omitempty"`
+}
+
+func (b Breaker) MarshalJSON() ([]byte, error) {
+	return json.Marshal(breakerJSON{
+		Expression:       b.Expression,
+		FallbackDuration: jsonDuration(b.FallbackDuration),
+		RecoveryDuration: jsonDuration(b.RecoveryDuration),
+		CheckPeriod:      jsonDuration(b.CheckPeriod),
+	})
+}
+
+func (b *Breaker) UnmarshalJSON(data []byte) error {
+	var v breakerJSON
+	if err := decodeStrict(data, &v); err != nil {
+		return fmt.Errorf("breaker: %v", err)
+	}
+	*b = Breaker{
+		Expression:       v.Expression,
+		FallbackDuration: time.Duration(v.FallbackDuration),
+		RecoveryDuration: time.Duration(v.RecoveryDuration),
+		CheckPeriod:      time.Duration(v.CheckPeriod),
+	}
+	return nil
+}
+
+type rateJSON struct {
+	Period  jsonDuration `json:"period"`
+	Average int64        `json:"average"`
+	Burst   int64        `json:"burst"`
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rateJSON{Period: jsonDuration(r.Period), Average: r.Average, Burst: r.Burst})
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var v rateJSON
+	if err := decodeStrict(data, &v); err != nil {
+		return fmt.Errorf("rate: %v", err)
+	}
+	*r = Rate{Period: time.Duration(v.Period), Average: v.Average, Burst: v.Burst}
+	return nil
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type FileSuite struct{}
+
+var _ = Suite(&FileSuite{})
+
+var fullConfig = Config{
+	Servers:   []Server{{URL: "http://localhost:5000"}, {URL: "http://localhost:5001", Weight: 2}},
+	Breaker:   &Breaker{Expression: "NetworkErrorRatio() > 0.5", FallbackDuration: 10 * time.Second},
+	ConnLimit: &ConnLimit{Source: "client.ip", Max: 10},
+	RateLimit: &RateLimit{Source: "request.host", Rates: []Rate{{Period: time.Minute, Average: 10, Burst: 20}}},
+}
+
+func (s *FileSuite) TestYAML(c *C) {
+	data := `
+servers:
+  - url: http://localhost:5000
+  - url: http://localhost:5001
+    weight: 2
+breaker:
+  expression: NetworkErrorRatio() > 0.5
+  fallbackDuration: 10s
+connLimit:
+  source: client.ip
+  max: 10
+rateLimit:
+  source: request.host
+  rates:
+    - period: 1m
+      average: 10
+      burst: 20
+`
+	cfg, err := Unmarshal([]byte(data), YAML)
+	c.Assert(err, IsNil)
+	c.Assert(*cfg, DeepEquals, fullConfig)
+}
+
+func (s *FileSuite) TestJSON(c *C) {
+	data := `{
+  "servers": [{"url": "http://localhost:5000"}, {"url": "http://localhost:5001", "weight": 2}],
+  "breaker": {"expression": "NetworkErrorRatio() > 0.5", "fallbackDuration": "10s"},
+  "connLimit": {"source": "client.ip", "max": 10},
+  "rateLimit": {"source": "request.host", "rates": [{"period": "1m", "average": 10, "burst": 20}]}
+}`
+	cfg, err := Unmarshal([]byte(data), JSON)
+	c.Assert(err, IsNil)
+	c.Assert(*cfg, DeepEquals, fullConfig)
+}
+
+func (s *FileSuite) TestRoundTrip(c *C) {
+	for _, format := range []Format{YAML, JSON} {
+		data, err := Marshal(fullConfig, format)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Matches, `(?s).*1m0s.*`)
+		cfg, err := Unmarshal(data, format)
+		c.Assert(err, IsNil, Commentf("%v: %s", format, data))
+		c.Assert(*cfg, DeepEquals, fullConfig)
+	}
+}
+
+func (s *FileSuite) TestUnknownField(c *C) {
+	_, err := Unmarshal([]byte("servers:\n  - url: http://localhost\n    wieght: 2\n"), YAML)
+	c.Assert(err, ErrorMatches, "(?s).*line 3.*wieght.*")
+
+	_, err = Unmarshal([]byte(`{"servers": [{"url": "http://localhost", "wieght": 2}]}`), JSON)
+	c.Assert(err, ErrorMatches, ".*wieght.*")
+
+	_, err = Unmarshal([]byte(`{"breaker": {"expresion": "NetworkErrorRatio() > 0.5"}}`), JSON)
+	c.Assert(err, ErrorMatches, ".*expresion.*")
+}
+
+func (s *FileSuite) TestDecodeErrors(c *C) {
+	_, err := Unmarshal([]byte("{\n  \"servers\": [\n    {\"url\": \"http://localhost\", \"weight\": \"two\"}\n  ]\n}"), JSON)
+	c.Assert(err, ErrorMatches, "line 3: .*weight.*")
+
+	_, err = Unmarshal([]byte("{\n  \"servers\": [,]\n}"), JSON)
+	c.Assert(err, ErrorMatches, "line 2: .*")
+
+	_, err = Unmarshal([]byte(`{"rateLimit": {"source": "client.ip", "rates": [{"period": "soon", "average": 1, "burst": 1}]}}`), JSON)
+	c.Assert(err, NotNil)
+}
+
+func (s *FileSuite) TestFieldErrors(c *C) {
+	_, err := Unmarshal([]byte("servers:\n  - url: http://localhost\n  - url: localhost\n"), YAML)
+	c.Assert(err, FitsTypeOf, &FieldError{})
+	c.Assert(err.(*FieldError).Field, Equals, "servers[1].url")
+
+	_, err = Unmarshal([]byte("rateLimit:\n  source: client.ip\n  rates:\n    - period: 1s\n      average: 1\n"), YAML)
+	c.Assert(err, FitsTypeOf, &FieldError{})
+	c.Assert(err.(*FieldError).Field, Equals, "rateLimit.rates[0].burst")
+
+	_, err = Unmarshal([]byte("connLimit:\n  source: request.cookie\n  max: 1\n"), YAML)
+	c.Assert(err, FitsTypeOf, &FieldError{})
+	c.Assert(err.(*FieldError).Field, Equals, "connLimit.source")
+
+	_, err = Unmarshal([]byte("breaker:\n  expression: Unknown() > 1\n"), YAML)
+	c.Assert(err, FitsTypeOf, &FieldError{})
+	c.Assert(err.(*FieldError).Field, Equals, "breaker.expression")
+}
+
+func (s *FileSuite) TestLoadFile(c *C) {
+	dir, err := ioutil.TempDir("", "oxy-config")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "oxy.yml")
+	c.Assert(ioutil.WriteFile(path, []byte("servers:\n  - url: http://localhost:5000\n"), 0600), IsNil)
+	cfg, err := LoadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Servers, DeepEquals, []Server{{URL: "http://localhost:5000"}})
+
+	c.Assert(ioutil.WriteFile(path, []byte("servers: [{url: localhost}]\n"), 0600), IsNil)
+	_, err = LoadFile(path)
+	c.Assert(err, ErrorMatches, ".*oxy.yml: servers\\[0\\].url.*")
+
+	_, err = LoadFile(filepath.Join(dir, "oxy.toml"))
+	c.Assert(err, NotNil)
+}
@@ -2,10 +2,12 @@
 package connlimit
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync"
 
+	"github.com/mailgun/oxy/tenancy"
 	"github.com/mailgun/oxy/utils"
 )
 
@@ -19,8 +21,18 @@ type ConnLimiter struct {
 	totalConnections int64
 	next             http.Handler
 
+	// mode selects what is counted, streams tracks the in-flight requests per client connection
+	// of every source in the CountConnections mode
+	mode    CountMode
+	streams map[string]map[string]int64
+
+	// tenants override the maximum of the sources with the limits of the tenants, see TenantLimits
+	tenants *tenancy.Tenancy
+
 	errHandler utils.ErrorHandler
 	log        utils.Logger
+
+	drain utils.Drainer
 }
 
 func New(next http.Handler, extract utils.SourceExtractor, maxConnections int64, options ...ConnLimitOption) (*ConnLimiter, error) {
@@ -32,6 +44,7 @@ func New(next http.Handler, extract utils.SourceExtractor, maxConnections int64,
 		extract:        extract,
 		maxConnections: maxConnections,
 		connections:    make(map[string]int64),
+		streams:        make(map[string]map[string]int64),
 		next:           next,
 	}
 
@@ -49,46 +62,112 @@ func New(next http.Handler, extract utils.SourceExtractor, maxConnections int64,
 	return cl, nil
 }
 
+// Connections returns a snapshot of the current connection counts per source
+func (cl *ConnLimiter) Connections() map[string]int64 {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	out := make(map[string]int64, len(cl.connections))
+	for token, count := range cl.connections {
+		out[token] = count
+	}
+	return out
+}
+
+// TotalConnections returns the amount of connections currently served across all sources
+func (cl *ConnLimiter) TotalConnections() int64 {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.totalConnections
+}
+
 func (cl *ConnLimiter) Wrap(h http.Handler) {
 	cl.next = h
 }
 
+// Close stops accepting new connections and waits for the ones being served until the context is done
+func (cl *ConnLimiter) Close(ctx context.Context) error {
+	return cl.drain.Close(ctx)
+}
+
 func (cl *ConnLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !cl.drain.Enter() {
+		cl.errHandler.ServeHTTP(w, r, utils.ErrShuttingDown)
+		return
+	}
+	defer cl.drain.Leave()
+
 	token, amount, err := cl.extract.Extract(r)
 	if err != nil {
 		cl.log.Errorf("failed to extract source of the connection: %v", err)
 		cl.errHandler.ServeHTTP(w, r, err)
 		return
 	}
-	if err := cl.acquire(token, amount); err != nil {
+	if err := cl.acquire(token, r.RemoteAddr, amount, cl.max(token)); err != nil {
 		cl.log.Infof("limiting request source %s: %v", token, err)
 		cl.errHandler.ServeHTTP(w, r, err)
 		return
 	}
 
-	defer cl.release(token, amount)
+	defer cl.release(token, r.RemoteAddr, amount)
 
 	cl.next.ServeHTTP(w, r)
 }
 
-func (cl *ConnLimiter) acquire(token string, amount int64) error {
+// max returns the maximum of the source
+func (cl *ConnLimiter) max(token string) int64 {
+	if cl.tenants != nil {
+		if max := cl.tenants.Config(token).MaxConnections; max > 0 {
+			return max
+		}
+	}
+	return cl.maxConnections
+}
+
+func (cl *ConnLimiter) acquire(token, conn string, amount, max int64) error {
 	cl.mutex.Lock()
 	defer cl.mutex.Unlock()
 
+	if cl.mode == CountConnections {
+		// the streams multiplexed over the connection already counted are let through
+		if cl.streams[token][conn] > 0 {
+			cl.streams[token][conn]++
+			return nil
+		}
+	}
+
 	connections := cl.connections[token]
-	if connections >= cl.maxConnections {
-		return &MaxConnError{max: cl.maxConnections}
+	if connections >= max {
+		return &MaxConnError{max: max}
 	}
 
 	cl.connections[token] += amount
 	cl.totalConnections += int64(amount)
+	if cl.mode == CountConnections {
+		if cl.streams[token] == nil {
+			cl.streams[token] = make(map[string]int64)
+		}
+		cl.streams[token][conn] = 1
+	}
 	return nil
 }
 
-func (cl *ConnLimiter) release(token string, amount int64) {
+func (cl *ConnLimiter) release(token, conn string, amount int64) {
 	cl.mutex.Lock()
 	defer cl.mutex.Unlock()
 
+	if cl.mode == CountConnections {
+		// the connection is counted until its last stream completes
+		cl.streams[token][conn]--
+		if cl.streams[token][conn] > 0 {
+			return
+		}
+		delete(cl.streams[token], conn)
+		if len(cl.streams[token]) == 0 {
+			delete(cl.streams, token)
+		}
+	}
+
 	cl.connections[token] -= amount
 	cl.totalConnections -= int64(amount)
 
@@ -120,6 +199,41 @@ func (e *ConnErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err
 
 type ConnLimitOption func(l *ConnLimiter) error
 
+// CountMode selects what the limiter counts against the maximum
+type CountMode int
+
+const (
+	// CountStreams counts the concurrent requests of the source, every HTTP/2 stream counts
+	// on its own, so a single multiplexed connection can not bypass the limit. This is the default.
+	CountStreams CountMode = iota
+	// CountConnections counts the client connections with requests in flight, the streams
+	// multiplexed over the connection already counted are not limited.
+	CountConnections
+)
+
+// Mode sets what is counted against the maximum, CountStreams by default
+func Mode(m CountMode) ConnLimitOption {
+	return func(cl *ConnLimiter) error {
+		if m != CountStreams && m != CountConnections {
+			return fmt.Errorf("unknown count mode: %d", m)
+		}
+		cl.mode = m
+		return nil
+	}
+}
+
+// TenantLimits takes the maximum of the tenants from their configs, the tenants without it get the maximum
+// of the limiter. The tenancy should be the source extractor of the limiter, so the sources are the tenants.
+func TenantLimits(t *tenancy.Tenancy) ConnLimitOption {
+	return func(cl *ConnLimiter) error {
+		if t == nil {
+			return fmt.Errorf("tenancy can not be nil")
+		}
+		cl.tenants = t
+		return nil
+	}
+}
+
 // Logger sets the logger that will be used by this middleware.
 func Logger(l utils.Logger) ConnLimitOption {
 	return func(cl *ConnLimiter) error {
@@ -2,11 +2,14 @@ package connlimit
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/mailgun/oxy/tenancy"
 	"github.com/mailgun/oxy/testutils"
 	"github.com/mailgun/oxy/utils"
 
@@ -120,3 +123,173 @@ func faultyExtractor(req *http.Request) (string, int64, error) {
 
 var headerLimit = utils.ExtractorFunc(headerLimiter)
 var faultyExtract = utils.ExtractorFunc(faultyExtractor)
+
+func (s *ConnLimiterSuite) TestConnections(c *C) {
+	wait := make(chan bool)
+	served := make(chan bool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served <- true
+		<-wait
+		w.Write([]byte("hello"))
+	})
+
+	l, err := New(handler, headerLimit, 2)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	done := make(chan bool)
+	go func() {
+		testutils.Get(srv.URL, testutils.Header("Limit", "a"))
+		done <- true
+	}()
+	<-served
+
+	c.Assert(l.TotalConnections(), Equals, int64(1))
+	c.Assert(l.Connections(), DeepEquals, map[string]int64{"a": 1})
+
+	close(wait)
+	<-done
+	c.Assert(l.TotalConnections(), Equals, int64(0))
+	c.Assert(l.Connections(), DeepEquals, map[string]int64{})
+}
+
+func (s *ConnLimiterSuite) TestCountModes(c *C) {
+	wait := make(chan bool)
+	served := make(chan bool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("wait") != "" {
+			served <- true
+			<-wait
+		}
+		w.Write([]byte("hello"))
+	})
+
+	serve := func(l *ConnLimiter, remoteAddr string, wait bool) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Limit", "a")
+		if wait {
+			req.Header.Set("wait", "yes")
+		}
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// two streams of the same connection are counted once in the connection mode
+	l, err := New(handler, headerLimit, 1, Mode(CountConnections))
+	c.Assert(err, IsNil)
+
+	done := make(chan bool)
+	go func() {
+		serve(l, "10.0.0.1:1000", true)
+		done <- true
+	}()
+	<-served
+	go func() {
+		serve(l, "10.0.0.1:1000", true)
+		done <- true
+	}()
+	<-served
+
+	c.Assert(l.Connections(), DeepEquals, map[string]int64{"a": 1})
+	c.Assert(serve(l, "10.0.0.1:1001", false), Equals, 429)
+
+	wait <- true
+	<-done
+	c.Assert(serve(l, "10.0.0.1:1001", false), Equals, 429)
+	wait <- true
+	<-done
+	c.Assert(l.Connections(), DeepEquals, map[string]int64{})
+	c.Assert(serve(l, "10.0.0.1:1001", false), Equals, http.StatusOK)
+
+	// every stream counts in the default mode
+	l, err = New(handler, headerLimit, 1)
+	c.Assert(err, IsNil)
+
+	go func() {
+		serve(l, "10.0.0.1:1000", true)
+		done <- true
+	}()
+	<-served
+	c.Assert(serve(l, "10.0.0.1:1000", false), Equals, 429)
+	wait <- true
+	<-done
+
+	_, err = New(handler, headerLimit, 1, Mode(CountMode(5)))
+	c.Assert(err, NotNil)
+}
+
+// the clients behind the same NAT are told apart by their certificates
+func (s *ConnLimiterSuite) TestClientCertificate(c *C) {
+	entered, release := make(chan bool), make(chan bool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- true
+		<-release
+	})
+	extract, err := utils.NewExtractor("tls.client.fingerprint")
+	c.Assert(err, IsNil)
+	l, err := New(handler, extract, 1)
+	c.Assert(err, IsNil)
+
+	request := func(cert string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "https://localhost", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte(cert)}}}
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		return w
+	}
+	done := make(chan int, 2)
+	for _, cert := range []string{"a", "b"} {
+		go func(cert string) { done <- request(cert).Code }(cert)
+		<-entered
+	}
+	c.Assert(request("a").Code, Equals, 429)
+
+	close(release)
+	c.Assert(<-done, Equals, http.StatusOK)
+	c.Assert(<-done, Equals, http.StatusOK)
+}
+
+func (s *ConnLimiterSuite) TestTenantLimits(c *C) {
+	entered, release := make(chan bool), make(chan bool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- true
+		<-release
+	})
+	extract, err := utils.NewExtractor("request.header.X-Tenant-Id")
+	c.Assert(err, IsNil)
+	store := tenancy.NewMemoryStore()
+	store.Set("acme", tenancy.Config{MaxConnections: 2})
+	tenants, err := tenancy.New(extract, store)
+	c.Assert(err, IsNil)
+	l, err := New(handler, tenants, 1, TenantLimits(tenants))
+	c.Assert(err, IsNil)
+
+	request := func(tenant string) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Tenant-Id", tenant)
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		return w.Code
+	}
+	done := make(chan int, 3)
+	// acme has its own limit, the rest of the tenants the limiter's one
+	for _, tenant := range []string{"acme", "acme", "initech"} {
+		go func(tenant string) { done <- request(tenant) }(tenant)
+		<-entered
+	}
+	c.Assert(request("acme"), Equals, 429)
+	c.Assert(request("initech"), Equals, 429)
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		c.Assert(<-done, Equals, http.StatusOK)
+	}
+
+	_, err = New(handler, tenants, 1, TenantLimits(nil))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,269 @@
+// Package cors implements Cross-Origin Resource Sharing, it answers preflight requests
+// and adds Access-Control-* headers to the responses for the allowed origins.
+//
+//	c, _ := cors.New(next,
+//		cors.AllowedOrigins("https://example.com", "https://*.example.com"),
+//		cors.AllowedMethods("GET", "POST", "DELETE"),
+//		cors.MaxAge(time.Hour))
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+const (
+	Origin                        = "Origin"
+	Vary                          = "Vary"
+	AccessControlRequestMethod    = "Access-Control-Request-Method"
+	AccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	AccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	AccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	AccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	AccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	AccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	AccessControlMaxAge           = "Access-Control-Max-Age"
+)
+
+// CORS answers preflight requests and decorates the responses to the cross origin requests
+type CORS struct {
+	next             http.Handler
+	anyOrigin        bool
+	origins          []string
+	methods          []string
+	headers          []string
+	anyHeader        bool
+	exposed          []string
+	allowCredentials bool
+	maxAge           time.Duration
+	log              utils.Logger
+}
+
+// CORSOption is a functional option setter for CORS
+type CORSOption func(c *CORS) error
+
+// AllowedOrigins sets the origins allowed to make cross origin requests. Pattern can be * matching any origin
+// or contain a single * wildcard, e.g. https://*.example.com. Any origin is allowed by default.
+func AllowedOrigins(patterns ...string) CORSOption {
+	return func(c *CORS) error {
+		for _, p := range patterns {
+			if strings.Count(p, "*") > 1 {
+				return fmt.Errorf("origin pattern '%v' can contain one wildcard at most", p)
+			}
+			if p == "*" {
+				c.anyOrigin = true
+				continue
+			}
+			c.origins = append(c.origins, strings.ToLower(p))
+		}
+		return nil
+	}
+}
+
+// AllowedMethods sets the methods allowed for the cross origin requests, GET, HEAD and POST by default
+func AllowedMethods(methods ...string) CORSOption {
+	return func(c *CORS) error {
+		c.methods = nil
+		for _, m := range methods {
+			c.methods = append(c.methods, strings.ToUpper(m))
+		}
+		return nil
+	}
+}
+
+// AllowedHeaders sets the request headers the clients can use, * allows any header
+func AllowedHeaders(headers ...string) CORSOption {
+	return func(c *CORS) error {
+		c.headers = nil
+		for _, h := range headers {
+			if h == "*" {
+				c.anyHeader = true
+				continue
+			}
+			c.headers = append(c.headers, http.CanonicalHeaderKey(h))
+		}
+		return nil
+	}
+}
+
+// ExposedHeaders sets the response headers the clients can access
+func ExposedHeaders(headers ...string) CORSOption {
+	return func(c *CORS) error {
+		c.exposed = append(c.exposed, headers...)
+		return nil
+	}
+}
+
+// AllowCredentials lets the clients send cookies and authorization headers with the cross origin requests,
+// it requires the explicit list of allowed origins, as any website could make credentialed requests otherwise
+func AllowCredentials(allow bool) CORSOption {
+	return func(c *CORS) error {
+		c.allowCredentials = allow
+		return nil
+	}
+}
+
+// MaxAge sets how long the clients can cache the preflight results
+func MaxAge(d time.Duration) CORSOption {
+	return func(c *CORS) error {
+		if d < 0 {
+			return fmt.Errorf("max age should be >= 0, got %v", d)
+		}
+		c.maxAge = d
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) CORSOption {
+	return func(c *CORS) error {
+		c.log = l
+		return nil
+	}
+}
+
+// New returns a new CORS middleware
+func New(next http.Handler, options ...CORSOption) (*CORS, error) {
+	c := &CORS{
+		next:    next,
+		methods: []string{"GET", "HEAD", "POST"},
+		headers: []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "X-Requested-With"},
+	}
+	for _, o := range options {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.origins) == 0 {
+		c.anyOrigin = true
+	}
+	if c.anyOrigin && c.allowCredentials {
+		return nil, fmt.Errorf("allowed credentials require explicit list of allowed origins")
+	}
+	if c.log == nil {
+		c.log = utils.NullLogger
+	}
+	return c, nil
+}
+
+func (c *CORS) Wrap(next http.Handler) {
+	c.next = next
+}
+
+func (c *CORS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get(Origin)
+	if req.Method == "OPTIONS" && origin != "" && req.Header.Get(AccessControlRequestMethod) != "" {
+		c.preflight(w, req, origin)
+		return
+	}
+	w.Header().Add(Vary, Origin)
+	if origin != "" && c.originAllowed(origin) {
+		c.setAllowOrigin(w.Header(), origin)
+		if len(c.exposed) != 0 {
+			w.Header().Set(AccessControlExposeHeaders, strings.Join(c.exposed, ", "))
+		}
+	}
+	c.next.ServeHTTP(w, req)
+}
+
+func (c *CORS) preflight(w http.ResponseWriter, req *http.Request, origin string) {
+	h := w.Header()
+	h.Add(Vary, Origin)
+	h.Add(Vary, AccessControlRequestMethod)
+	h.Add(Vary, AccessControlRequestHeaders)
+
+	method := strings.ToUpper(req.Header.Get(AccessControlRequestMethod))
+	headers := parseList(req.Header.Get(AccessControlRequestHeaders))
+	if !c.originAllowed(origin) || !c.methodAllowed(method) || !c.headersAllowed(headers) {
+		c.log.Infof("rejecting preflight from %v for %v %v", origin, method, headers)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	c.setAllowOrigin(h, origin)
+	h.Set(AccessControlAllowMethods, strings.Join(c.methods, ", "))
+	if len(headers) != 0 {
+		h.Set(AccessControlAllowHeaders, strings.Join(headers, ", "))
+	}
+	if c.maxAge > 0 {
+		h.Set(AccessControlMaxAge, strconv.Itoa(int(c.maxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) setAllowOrigin(h http.Header, origin string) {
+	if c.anyOrigin {
+		h.Set(AccessControlAllowOrigin, "*")
+	} else {
+		h.Set(AccessControlAllowOrigin, origin)
+	}
+	if c.allowCredentials {
+		h.Set(AccessControlAllowCredentials, "true")
+	}
+}
+
+func (c *CORS) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, p := range c.origins {
+		if i := strings.Index(p, "*"); i != -1 {
+			prefix, suffix := p[:i], p[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		} else if p == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) methodAllowed(method string) bool {
+	// preflight for OPTIONS itself is always allowed
+	if method == "OPTIONS" {
+		return true
+	}
+	for _, m := range c.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) headersAllowed(headers []string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, h := range headers {
+		allowed := false
+		for _, a := range c.headers {
+			if a == h {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// parseList parses comma separated list of header names
+func parseList(val string) []string {
+	var out []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, http.CanonicalHeaderKey(v))
+		}
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestCORS(t *testing.T) { TestingT(t) }
+
+type CORSSuite struct{}
+
+var _ = Suite(&CORSSuite{})
+
+var backend = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("hello"))
+})
+
+func (s *CORSSuite) newServer(c *C, options ...CORSOption) *httptest.Server {
+	h, err := New(backend, options...)
+	c.Assert(err, IsNil)
+	return httptest.NewServer(h)
+}
+
+func preflight(url, origin, method, headers string) (*http.Response, error) {
+	opts := []testutils.ReqOption{
+		testutils.Method("OPTIONS"),
+		testutils.Header(Origin, origin),
+		testutils.Header(AccessControlRequestMethod, method),
+	}
+	if headers != "" {
+		opts = append(opts, testutils.Header(AccessControlRequestHeaders, headers))
+	}
+	re, _, err := testutils.MakeRequest(url, opts...)
+	return re, err
+}
+
+func (s *CORSSuite) TestPreflight(c *C) {
+	srv := s.newServer(c,
+		AllowedOrigins("https://example.com", "https://*.example.org"),
+		AllowedMethods("GET", "PUT"),
+		AllowedHeaders("Content-Type", "X-Token"),
+		MaxAge(time.Hour))
+	defer srv.Close()
+
+	re, err := preflight(srv.URL, "https://api.example.org", "PUT", "x-token, content-type")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNoContent)
+	c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "https://api.example.org")
+	c.Assert(re.Header.Get(AccessControlAllowMethods), Equals, "GET, PUT")
+	c.Assert(re.Header.Get(AccessControlAllowHeaders), Equals, "X-Token, Content-Type")
+	c.Assert(re.Header.Get(AccessControlMaxAge), Equals, "3600")
+
+	testCases := []struct {
+		origin  string
+		method  string
+		headers string
+	}{
+		{origin: "https://evil.com", method: "GET"},
+		{origin: "https://example.org", method: "GET"},
+		{origin: "https://example.com", method: "DELETE"},
+		{origin: "https://example.com", method: "GET", headers: "X-Other"},
+	}
+	for _, tc := range testCases {
+		re, err := preflight(srv.URL, tc.origin, tc.method, tc.headers)
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusForbidden, Commentf("%v", tc))
+		c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "")
+	}
+}
+
+func (s *CORSSuite) TestSimpleRequest(c *C) {
+	srv := s.newServer(c, AllowedOrigins("https://example.com"), ExposedHeaders("X-Request-Id"))
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL, testutils.Header(Origin, "https://example.com"))
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "https://example.com")
+	c.Assert(re.Header.Get(AccessControlExposeHeaders), Equals, "X-Request-Id")
+	c.Assert(re.Header.Get(Vary), Equals, Origin)
+
+	// requests from other origins are served, but the browser won't let the page read the response
+	re, body, err = testutils.Get(srv.URL, testutils.Header(Origin, "https://evil.com"))
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "")
+}
+
+func (s *CORSSuite) TestAnyOrigin(c *C) {
+	srv := s.newServer(c)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header(Origin, "https://example.com"))
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "*")
+	c.Assert(re.Header.Get(AccessControlAllowCredentials), Equals, "")
+}
+
+func (s *CORSSuite) TestCredentials(c *C) {
+	srv := s.newServer(c, AllowedOrigins("https://*.example.com"), AllowCredentials(true), AllowedHeaders("*"))
+	defer srv.Close()
+
+	re, err := preflight(srv.URL, "https://app.example.com", "POST", "X-Anything")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNoContent)
+	c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "https://app.example.com")
+	c.Assert(re.Header.Get(AccessControlAllowCredentials), Equals, "true")
+	c.Assert(re.Header.Get(AccessControlAllowHeaders), Equals, "X-Anything")
+
+	// other origins are never echoed back with credentials
+	re, err = preflight(srv.URL, "https://evil.com", "POST", "")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusForbidden)
+	c.Assert(re.Header.Get(AccessControlAllowOrigin), Equals, "")
+	c.Assert(re.Header.Get(AccessControlAllowCredentials), Equals, "")
+}
+
+func (s *CORSSuite) TestCredentialsRequireOrigins(c *C) {
+	_, err := New(backend, AllowCredentials(true))
+	c.Assert(err, NotNil)
+
+	_, err = New(backend, AllowedOrigins("*"), AllowCredentials(true))
+	c.Assert(err, NotNil)
+}
+
+func (s *CORSSuite) TestBadOptions(c *C) {
+	_, err := New(backend, AllowedOrigins("https://*.*.example.com"))
+	c.Assert(err, NotNil)
+
+	_, err = New(backend, MaxAge(-time.Second))
+	c.Assert(err, NotNil)
+}
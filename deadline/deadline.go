@@ -0,0 +1,274 @@
+// Package deadline attaches the deadline of the route class to the requests, so every downstream middleware
+// and the forwarder observe the same time limit, and replaces the responses not started in time with 504
+// and the structured body. Use budget to take the remaining time from the callers instead.
+//
+//	d, _ := deadline.New(fwd,
+//		deadline.Default(10*time.Second),
+//		deadline.Route("search", 2*time.Second, router.PathPrefix("/search")),
+//		deadline.Route("upload", time.Minute, router.Method("POST", "PUT")))
+package deadline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/router"
+	"github.com/mailgun/oxy/utils"
+)
+
+// DefaultRoute is the route class of the requests matching none of the routes
+const DefaultRoute = "default"
+
+type class struct {
+	name     string
+	timeout  time.Duration
+	matchers []router.Matcher
+}
+
+func (c *class) match(req *http.Request) bool {
+	for _, m := range c.matchers {
+		if !m(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// Deadline attaches the deadlines of the route classes to the requests
+type Deadline struct {
+	next    http.Handler
+	classes []class
+	// timeout is the global deadline of the requests matching none of the classes
+	timeout time.Duration
+
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+// DeadlineOption is a functional option setter for Deadline
+type DeadlineOption func(d *Deadline) error
+
+// New returns the middleware attaching the deadlines, at least one of the Default or Route options is required
+func New(next http.Handler, opts ...DeadlineOption) (*Deadline, error) {
+	d := &Deadline{next: next}
+	for _, o := range opts {
+		if err := o(d); err != nil {
+			return nil, err
+		}
+	}
+	if d.timeout == 0 && len(d.classes) == 0 {
+		return nil, fmt.Errorf("either the default deadline or the route deadlines should be set")
+	}
+	if d.errHandler == nil {
+		d.errHandler = defaultErrHandler
+	}
+	if d.log == nil {
+		d.log = utils.NullLogger
+	}
+	return d, nil
+}
+
+func (d *Deadline) Wrap(next http.Handler) {
+	d.next = next
+}
+
+func (d *Deadline) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	route, timeout := d.pick(req)
+	if timeout == 0 {
+		d.next.ServeHTTP(w, req)
+		return
+	}
+	// the earlier deadline of the incoming context, e.g. set by budget, is kept
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	dw := &deadlineWriter{ResponseWriter: w, ctx: ctx, header: w.Header().Clone()}
+	d.next.ServeHTTP(dw, req.WithContext(ctx))
+	// the client is gone, the expired deadline of the incoming context is served as the own one
+	if dw.written || req.Context().Err() == context.Canceled {
+		return
+	}
+	if dw.expired || ctx.Err() == context.DeadlineExceeded {
+		d.log.Infof("%v %v exceeded the %v deadline of %v", req.Method, req.URL, timeout, route)
+		d.errHandler.ServeHTTP(w, req, &DeadlineError{Route: route, Limit: timeout})
+		return
+	}
+	// the next handler wrote nothing
+	dw.commit()
+}
+
+// pick returns the class of the first matching route or the default one
+func (d *Deadline) pick(req *http.Request) (string, time.Duration) {
+	for i := range d.classes {
+		if c := &d.classes[i]; c.match(req) {
+			return c.name, c.timeout
+		}
+	}
+	return DefaultRoute, d.timeout
+}
+
+// Default sets the global deadline of the requests matching none of the routes
+func Default(timeout time.Duration) DeadlineOption {
+	return func(d *Deadline) error {
+		if timeout <= 0 {
+			return fmt.Errorf("deadline should be > 0, got %v", timeout)
+		}
+		d.timeout = timeout
+		return nil
+	}
+}
+
+// Route sets the deadline of the requests matching all of the matchers, the routes are matched in the order
+// they are added. The 0 timeout exempts the class from the default deadline.
+func Route(name string, timeout time.Duration, matchers ...router.Matcher) DeadlineOption {
+	return func(d *Deadline) error {
+		if name == "" {
+			return fmt.Errorf("route name can not be empty")
+		}
+		if timeout < 0 {
+			return fmt.Errorf("deadline of %v should be >= 0, got %v", name, timeout)
+		}
+		if len(matchers) == 0 {
+			return fmt.Errorf("route %v should have at least one matcher", name)
+		}
+		d.classes = append(d.classes, class{name: name, timeout: timeout, matchers: matchers})
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler of the requests exceeding the deadline, DeadlineErrHandler by default
+func ErrorHandler(h utils.ErrorHandler) DeadlineOption {
+	return func(d *Deadline) error {
+		d.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger used by this middleware
+func Logger(l utils.Logger) DeadlineOption {
+	return func(d *Deadline) error {
+		d.log = l
+		return nil
+	}
+}
+
+// DeadlineError is reported for the requests not answered before the deadline of their route
+type DeadlineError struct {
+	Route string
+	// Limit is the deadline of the route
+	Limit time.Duration
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("deadline of %v exceeded after %v", e.Route, e.Limit)
+}
+
+// Unwrap returns context.DeadlineExceeded
+func (e *DeadlineError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Timeout implements net.Error, so the error handlers serve the error as the timeout
+func (e *DeadlineError) Timeout() bool { return true }
+
+func (e *DeadlineError) Temporary() bool { return true }
+
+// DeadlineErrHandler serves the requests exceeding the deadline with 504 status code and the JSON body:
+//
+//	{"error": "deadline exceeded", "route": "search", "timeout_ms": 2000}
+type DeadlineErrHandler struct {
+}
+
+func (e *DeadlineErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	de, ok := err.(*DeadlineError)
+	if !ok {
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "deadline exceeded",
+		"route":      de.Route,
+		"timeout_ms": de.Limit.Milliseconds(),
+	})
+}
+
+var defaultErrHandler = &DeadlineErrHandler{}
+
+// deadlineWriter passes the response started before the deadline and drops the one started after it,
+// e.g. the plain 504 of the forwarder, so the middleware replaces it. The headers are kept apart
+// until the response starts.
+type deadlineWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	header  http.Header
+	written bool
+	expired bool
+}
+
+func (w *deadlineWriter) Header() http.Header {
+	if w.written {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *deadlineWriter) WriteHeader(code int) {
+	if w.written || w.expired {
+		return
+	}
+	if w.ctx.Err() == context.DeadlineExceeded {
+		w.expired = true
+		return
+	}
+	w.commit()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.expired {
+		return 0, context.DeadlineExceeded
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.expired {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// commit copies the headers set by the next handler to the response
+func (w *deadlineWriter) commit() {
+	if w.written {
+		return
+	}
+	h := w.ResponseWriter.Header()
+	for k := range h {
+		if _, ok := w.header[k]; !ok {
+			delete(h, k)
+		}
+	}
+	for k, v := range w.header {
+		h[k] = v
+	}
+	w.written = true
+}
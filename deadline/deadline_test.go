@@ -0,0 +1,176 @@
+package deadline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/router"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestDeadline(t *testing.T) { TestingT(t) }
+
+type DeadlineSuite struct{}
+
+var _ = Suite(&DeadlineSuite{})
+
+// remaining reports the time left until the deadline seen by the handler in the header
+var remaining = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		w.Write([]byte("none"))
+		return
+	}
+	w.Write([]byte(time.Until(deadline).Round(time.Second).String()))
+})
+
+// timeout waits for the deadline and answers the way the forwarder does
+var timeout = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	<-req.Context().Done()
+	w.Header().Set("X-Backend", "timeout")
+	utils.DefaultHandler.ServeHTTP(w, req, req.Context().Err())
+})
+
+func get(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, "http://localhost"+path, nil))
+	return w
+}
+
+func (s *DeadlineSuite) TestRoutes(c *C) {
+	d, err := New(remaining,
+		Default(10*time.Second),
+		Route("search", 2*time.Second, router.PathPrefix("/search")),
+		Route("upload", time.Minute, router.Method("POST")),
+		Route("stream", 0, router.PathPrefix("/stream")))
+	c.Assert(err, IsNil)
+
+	c.Assert(get(d, "GET", "/").Body.String(), Equals, "10s")
+	c.Assert(get(d, "GET", "/search").Body.String(), Equals, "2s")
+	// the first matching route wins
+	c.Assert(get(d, "POST", "/search").Body.String(), Equals, "2s")
+	c.Assert(get(d, "POST", "/").Body.String(), Equals, "1m0s")
+	c.Assert(get(d, "GET", "/stream").Body.String(), Equals, "none")
+}
+
+func (s *DeadlineSuite) TestRoutesOnly(c *C) {
+	d, err := New(remaining, Route("search", 2*time.Second, router.PathPrefix("/search")))
+	c.Assert(err, IsNil)
+
+	c.Assert(get(d, "GET", "/").Body.String(), Equals, "none")
+	c.Assert(get(d, "GET", "/search").Body.String(), Equals, "2s")
+}
+
+func (s *DeadlineSuite) TestEarlierDeadlineKept(c *C) {
+	d, err := New(remaining, Default(10*time.Second))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx))
+	c.Assert(w.Body.String(), Equals, "3s")
+}
+
+func (s *DeadlineSuite) TestExceeded(c *C) {
+	d, err := New(timeout, Route("search", 10*time.Millisecond, router.PathPrefix("/search")))
+	c.Assert(err, IsNil)
+
+	w := get(d, "GET", "/search")
+	c.Assert(w.Code, Equals, http.StatusGatewayTimeout)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	// the response of the next handler is replaced
+	c.Assert(w.Header().Get("X-Backend"), Equals, "")
+
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &body), IsNil)
+	c.Assert(body, DeepEquals, map[string]interface{}{
+		"error":      "deadline exceeded",
+		"route":      "search",
+		"timeout_ms": float64(10),
+	})
+}
+
+func (s *DeadlineSuite) TestEarlierDeadlineExceeded(c *C) {
+	d, err := New(timeout, Default(time.Second))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx))
+	c.Assert(w.Code, Equals, http.StatusGatewayTimeout)
+	c.Assert(w.Header().Get("X-Backend"), Equals, "")
+
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &body), IsNil)
+	c.Assert(body["route"], Equals, DefaultRoute)
+}
+
+func (s *DeadlineSuite) TestCanceledClient(c *C) {
+	d, err := New(timeout, Default(time.Second))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx))
+	// the cancellation is not taken for the exceeded deadline
+	c.Assert(w.Code, Not(Equals), http.StatusGatewayTimeout)
+}
+
+func (s *DeadlineSuite) TestStartedResponseKept(c *C) {
+	d, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Backend", "slow")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+		<-req.Context().Done()
+		w.Write([]byte(", world"))
+	}), Default(10*time.Millisecond))
+	c.Assert(err, IsNil)
+
+	w := get(d, "GET", "/")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("X-Backend"), Equals, "slow")
+	c.Assert(w.Body.String(), Equals, "hello, world")
+}
+
+func (s *DeadlineSuite) TestEmptyResponse(c *C) {
+	d, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Backend", "empty")
+	}), Default(time.Second))
+	c.Assert(err, IsNil)
+
+	w := get(d, "GET", "/")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("X-Backend"), Equals, "empty")
+}
+
+func (s *DeadlineSuite) TestErrorHandler(c *C) {
+	d, err := New(timeout, Default(10*time.Millisecond), ErrorHandler(utils.DefaultHandler))
+	c.Assert(err, IsNil)
+
+	w := get(d, "GET", "/")
+	c.Assert(w.Code, Equals, http.StatusGatewayTimeout)
+	c.Assert(w.Body.String(), Equals, http.StatusText(http.StatusGatewayTimeout))
+}
+
+func (s *DeadlineSuite) TestErrors(c *C) {
+	_, err := New(remaining)
+	c.Assert(err, NotNil)
+
+	_, err = New(remaining, Default(0))
+	c.Assert(err, NotNil)
+
+	_, err = New(remaining, Route("", time.Second, router.PathPrefix("/")))
+	c.Assert(err, NotNil)
+
+	_, err = New(remaining, Route("all", time.Second))
+	c.Assert(err, NotNil)
+}
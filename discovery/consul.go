@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mailgun/oxy/roundrobin"
+)
+
+// Consul polls the instances of the service passing their Consul health checks and keeps them in the server set.
+// The passing weights of the instances become the server weights.
+//
+//	d, _ := discovery.NewConsul(lb, "api", discovery.Tag("v2"))
+//	go d.Run(ctx)
+type Consul struct {
+	*options
+	set     roundrobin.ServerSet
+	service string
+}
+
+// NewConsul returns the source of the healthy instances of the service, the local agent
+// on http://127.0.0.1:8500 is queried unless the Address option is set
+func NewConsul(set roundrobin.ServerSet, service string, opts ...Option) (*Consul, error) {
+	if service == "" {
+		return nil, fmt.Errorf("service can not be empty")
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.address == "" {
+		o.address = "http://127.0.0.1:8500"
+	}
+	return &Consul{options: o, set: set, service: service}, nil
+}
+
+// Run refreshes the servers every jittered interval until the context is done
+func (d *Consul) Run(ctx context.Context) {
+	d.poll(ctx, d.service, d.Refresh)
+}
+
+// Refresh fetches the healthy instances and syncs the servers, they are kept as they are
+// if the query fails or there are no healthy instances
+func (d *Consul) Refresh(ctx context.Context) error {
+	endpoints, err := d.Endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	return syncServers(d.set, endpoints)
+}
+
+// Endpoints returns the instances of the service passing the health checks
+func (d *Consul) Endpoints(ctx context.Context) ([]roundrobin.Endpoint, error) {
+	query := url.Values{"passing": {"1"}}
+	if d.tag != "" {
+		query.Set("tag", d.tag)
+	}
+	if d.datacenter != "" {
+		query.Set("dc", d.datacenter)
+	}
+	u := fmt.Sprintf("%v/v1/health/service/%v?%v", d.address, url.PathEscape(d.service), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.token != "" {
+		req.Header.Set("X-Consul-Token", d.token)
+	}
+	re, err := d.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer re.Body.Close()
+
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+			Weights *struct {
+				Passing int
+			}
+		}
+	}
+	if err := json.NewDecoder(re.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	out := make([]roundrobin.Endpoint, 0, len(entries))
+	for _, e := range entries {
+		// the service address falls back to the node address when not set
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		if host == "" || e.Service.Port == 0 {
+			continue
+		}
+		ep := roundrobin.Endpoint{URL: &url.URL{Scheme: d.scheme, Host: net.JoinHostPort(host, strconv.Itoa(e.Service.Port))}}
+		if e.Service.Weights != nil {
+			ep.Weight = e.Service.Weights.Passing
+		}
+		out = append(out, ep)
+	}
+	return out, nil
+}
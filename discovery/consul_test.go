@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ConsulSuite struct{}
+
+var _ = Suite(&ConsulSuite{})
+
+const instances = `[
+	{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080, "Weights": {"Passing": 3}}},
+	{"Node": {"Address": "10.0.0.9"}, "Service": {"Address": "10.0.0.2", "Port": 8080}},
+	{"Node": {"Address": "10.0.0.3"}, "Service": {"Port": 0}}
+]`
+
+func (s *ConsulSuite) TestRefresh(c *C) {
+	reply := instances
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Check(req.URL.Path, Equals, "/v1/health/service/api")
+		c.Check(req.URL.Query().Get("passing"), Equals, "1")
+		c.Check(req.URL.Query().Get("tag"), Equals, "v2")
+		c.Check(req.URL.Query().Get("dc"), Equals, "eu")
+		c.Check(req.Header.Get("X-Consul-Token"), Equals, "secret")
+		fmt.Fprint(w, reply)
+	}))
+	defer api.Close()
+
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	d, err := NewConsul(lb, "api", Address(api.URL), Tag("v2"), Datacenter("eu"), Token("secret"), Scheme("https"))
+	c.Assert(err, IsNil)
+
+	c.Assert(d.Refresh(context.Background()), IsNil)
+	c.Assert(hosts(lb), DeepEquals, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+	w, _ := lb.ServerWeight(testutils.ParseURI("https://10.0.0.1:8080"))
+	c.Assert(w, Equals, 3)
+
+	// no healthy instances keep the servers
+	reply = `[]`
+	c.Assert(d.Refresh(context.Background()), NotNil)
+	c.Assert(hosts(lb), DeepEquals, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+}
+
+func (s *ConsulSuite) TestRun(c *C) {
+	var calls int64
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, instances)
+	}))
+	defer api.Close()
+
+	clock := testutils.NewClock()
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	d, err := NewConsul(lb, "api", Address(api.URL), Clock(clock), Interval(time.Minute), Jitter(0), Retry(time.Second))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	// the failure is retried sooner than the interval
+	clock.BlockUntil(1)
+	c.Assert(atomic.LoadInt64(&calls), Equals, int64(1))
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	c.Assert(atomic.LoadInt64(&calls), Equals, int64(2))
+	c.Assert(hosts(lb), DeepEquals, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+
+	clock.Advance(59 * time.Second)
+	c.Assert(atomic.LoadInt64(&calls), Equals, int64(2))
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	c.Assert(atomic.LoadInt64(&calls), Equals, int64(3))
+
+	cancel()
+	<-done
+}
+
+func (s *ConsulSuite) TestJitter(c *C) {
+	o, err := newOptions([]Option{Interval(10 * time.Second), Jitter(0.5)})
+	c.Assert(err, IsNil)
+	for i := 0; i < 100; i++ {
+		d := o.jittered()
+		c.Assert(d >= 5*time.Second && d <= 15*time.Second, Equals, true, Commentf("%v", d))
+	}
+
+	_, err = newOptions([]Option{Jitter(1)})
+	c.Assert(err, NotNil)
+	_, err = newOptions([]Option{Interval(0)})
+	c.Assert(err, NotNil)
+	_, err = NewConsul(nil, "")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,233 @@
+// package discovery keeps the servers of the load balancer in sync with the service registries:
+// Kubernetes EndpointSlices, Consul health checked services and etcd key prefixes
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// Option is a functional option setter shared by the discovery sources
+type Option func(o *options) error
+
+type options struct {
+	address string
+	client  *http.Client
+	token   string
+	scheme  string
+	port    string
+	retry   time.Duration
+	clock   timetools.TimeProvider
+	log     utils.Logger
+
+	// set for the polling sources
+	interval   time.Duration
+	jitter     float64
+	tag        string
+	datacenter string
+}
+
+// Address sets the base URL of the registry API, e.g. https://10.0.0.1:443 for the Kubernetes API server
+func Address(addr string) Option {
+	return func(o *options) error {
+		if addr == "" {
+			return fmt.Errorf("address can not be empty")
+		}
+		o.address = addr
+		return nil
+	}
+}
+
+// Client sets the HTTP client calling the registry API
+func Client(c *http.Client) Option {
+	return func(o *options) error {
+		o.client = c
+		return nil
+	}
+}
+
+// Token sets the token authenticating the calls to the registry API
+func Token(token string) Option {
+	return func(o *options) error {
+		o.token = token
+		return nil
+	}
+}
+
+// Scheme sets the scheme of the server URLs, http by default
+func Scheme(scheme string) Option {
+	return func(o *options) error {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("unsupported scheme: '%v'", scheme)
+		}
+		o.scheme = scheme
+		return nil
+	}
+}
+
+// Port selects the named port of the endpoints, the first port is used by default
+func Port(name string) Option {
+	return func(o *options) error {
+		o.port = name
+		return nil
+	}
+}
+
+// Retry sets the delay before reconnecting to the registry after a failure, 5 seconds by default
+func Retry(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return fmt.Errorf("retry delay should be > 0, got %v", d)
+		}
+		o.retry = d
+		return nil
+	}
+}
+
+// Interval sets the refresh interval of the polling sources (Consul and etcd), 30 seconds by default
+func Interval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return fmt.Errorf("interval should be > 0, got %v", d)
+		}
+		o.interval = d
+		return nil
+	}
+}
+
+// Jitter randomizes the refresh interval by up to the fraction of it in both directions, 0.1 by default,
+// so many proxies do not poll the registry in lockstep
+func Jitter(fraction float64) Option {
+	return func(o *options) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("jitter should be in [0, 1), got %v", fraction)
+		}
+		o.jitter = fraction
+		return nil
+	}
+}
+
+// Tag only keeps the Consul service instances having the tag
+func Tag(tag string) Option {
+	return func(o *options) error {
+		o.tag = tag
+		return nil
+	}
+}
+
+// Datacenter sets the Consul datacenter to query, the datacenter of the agent by default
+func Datacenter(dc string) Option {
+	return func(o *options) error {
+		o.datacenter = dc
+		return nil
+	}
+}
+
+// Clock sets the clock used to wait between the refreshes and the retries, intended for tests
+func Clock(clock timetools.TimeProvider) Option {
+	return func(o *options) error {
+		o.clock = clock
+		return nil
+	}
+}
+
+// Logger sets the logger reporting the registry failures
+func Logger(l utils.Logger) Option {
+	return func(o *options) error {
+		o.log = l
+		return nil
+	}
+}
+
+func newOptions(opts []Option) (*options, error) {
+	o := &options{scheme: "http", retry: 5 * time.Second, interval: 30 * time.Second, jitter: 0.1}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.client == nil {
+		o.client = http.DefaultClient
+	}
+	if o.clock == nil {
+		o.clock = &timetools.RealTime{}
+	}
+	if o.log == nil {
+		o.log = utils.NullLogger
+	}
+	return o, nil
+}
+
+// do calls the registry API, the response body is closed by the caller
+func (o *options) do(req *http.Request) (*http.Response, error) {
+	re, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if re.StatusCode != http.StatusOK {
+		re.Body.Close()
+		return nil, &StatusError{Code: re.StatusCode}
+	}
+	return re, nil
+}
+
+// StatusError is returned when the registry API replies with an unexpected status code
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("registry replied with %v %v", e.Code, http.StatusText(e.Code))
+}
+
+// wait waits for the delay and reports whether the context is still alive
+func (o *options) wait(done <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-done:
+		return false
+	case <-o.clock.After(d):
+		return true
+	}
+}
+
+// poll calls refresh every jittered interval until the context is done, retrying the failures sooner
+func (o *options) poll(ctx context.Context, name string, refresh func(ctx context.Context) error) {
+	for {
+		delay := o.jittered()
+		if err := refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			o.log.Warningf("failed to refresh servers of %v: %v", name, err)
+			if o.retry < delay {
+				delay = o.retry
+			}
+		}
+		if !o.wait(ctx.Done(), delay) {
+			return
+		}
+	}
+}
+
+func (o *options) jittered() time.Duration {
+	if o.jitter == 0 {
+		return o.interval
+	}
+	return time.Duration(float64(o.interval) * (1 + o.jitter*(2*rand.Float64()-1)))
+}
+
+// syncServers syncs the servers of the set with the endpoints, the servers are kept as they are when there are
+// no endpoints, so a registry outage does not empty the load balancer
+func syncServers(set roundrobin.ServerSet, endpoints []roundrobin.Endpoint) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no endpoints, keeping the servers")
+	}
+	return roundrobin.SyncServers(set, endpoints)
+}
@@ -0,0 +1,140 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mailgun/oxy/roundrobin"
+)
+
+// Etcd polls the keys under the prefix through the etcd v3 JSON gateway and keeps the servers they describe
+// in the server set. The value of every key is either the server URL or the JSON object
+// with the URL, the weight and the priority:
+//
+//	/services/api/1 -> http://10.0.0.1:8080
+//	/services/api/2 -> {"url": "http://10.0.0.2:8080", "weight": 2, "priority": 1}
+type Etcd struct {
+	*options
+	set    roundrobin.ServerSet
+	prefix string
+}
+
+// NewEtcd returns the source of the servers stored under the key prefix, the etcd on http://127.0.0.1:2379
+// is queried unless the Address option is set. The Token option sets the token returned by /v3/auth/authenticate.
+func NewEtcd(set roundrobin.ServerSet, prefix string, opts ...Option) (*Etcd, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("prefix can not be empty")
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.address == "" {
+		o.address = "http://127.0.0.1:2379"
+	}
+	return &Etcd{options: o, set: set, prefix: prefix}, nil
+}
+
+// Run refreshes the servers every jittered interval until the context is done
+func (d *Etcd) Run(ctx context.Context) {
+	d.poll(ctx, d.prefix, d.Refresh)
+}
+
+// Refresh fetches the keys and syncs the servers, they are kept as they are if the query fails
+// or the prefix has no keys
+func (d *Etcd) Refresh(ctx context.Context) error {
+	endpoints, err := d.Endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	return syncServers(d.set, endpoints)
+}
+
+// Endpoints returns the servers stored under the prefix, the keys with invalid values are skipped
+func (d *Etcd) Endpoints(ctx context.Context) ([]roundrobin.Endpoint, error) {
+	body, err := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(d.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd(d.prefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.address+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.token != "" {
+		req.Header.Set("Authorization", d.token)
+	}
+	re, err := d.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer re.Body.Close()
+
+	var reply struct {
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(re.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	out := make([]roundrobin.Endpoint, 0, len(reply.Kvs))
+	for _, kv := range reply.Kvs {
+		ep, err := d.endpoint(kv.Value)
+		if err != nil {
+			d.log.Warningf("skipping %v: %v", string(kv.Key), err)
+			continue
+		}
+		out = append(out, ep)
+	}
+	return out, nil
+}
+
+func (d *Etcd) endpoint(value []byte) (roundrobin.Endpoint, error) {
+	var v struct {
+		URL      string `json:"url"`
+		Weight   int    `json:"weight"`
+		Priority int    `json:"priority"`
+	}
+	if s := strings.TrimSpace(string(value)); strings.HasPrefix(s, "{") {
+		if err := json.Unmarshal(value, &v); err != nil {
+			return roundrobin.Endpoint{}, err
+		}
+	} else {
+		v.URL = s
+	}
+	u, err := url.Parse(v.URL)
+	if err != nil {
+		return roundrobin.Endpoint{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return roundrobin.Endpoint{}, fmt.Errorf("invalid server URL: '%v'", v.URL)
+	}
+	if v.Weight < 0 || v.Priority < 0 {
+		return roundrobin.Endpoint{}, fmt.Errorf("weight and priority should be >= 0")
+	}
+	return roundrobin.Endpoint{URL: u, Weight: v.Weight, Priority: v.Priority}, nil
+}
+
+// prefixEnd returns the end of the key range covering all keys with the prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all bytes are 0xff, the range is open ended
+	return []byte{0}
+}
@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type EtcdSuite struct{}
+
+var _ = Suite(&EtcdSuite{})
+
+func kv(key, value string) string {
+	return fmt.Sprintf(`{"key": "%v", "value": "%v"}`,
+		base64.StdEncoding.EncodeToString([]byte(key)), base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+func (s *EtcdSuite) TestRefresh(c *C) {
+	reply := fmt.Sprintf(`{"header": {"revision": "7"}, "kvs": [%v, %v, %v]}`,
+		kv("/services/api/1", "http://10.0.0.1:8080"),
+		kv("/services/api/2", `{"url": "http://10.0.0.2:8080", "weight": 2, "priority": 1}`),
+		kv("/services/api/3", "not a url"))
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Check(req.Method, Equals, http.MethodPost)
+		c.Check(req.URL.Path, Equals, "/v3/kv/range")
+		c.Check(req.Header.Get("Authorization"), Equals, "token")
+		var r struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		c.Check(json.NewDecoder(req.Body).Decode(&r), IsNil)
+		c.Check(string(r.Key), Equals, "/services/api/")
+		c.Check(string(r.RangeEnd), Equals, "/services/api0")
+		fmt.Fprint(w, reply)
+	}))
+	defer api.Close()
+
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	d, err := NewEtcd(lb, "/services/api/", Address(api.URL), Token("token"))
+	c.Assert(err, IsNil)
+
+	c.Assert(d.Refresh(context.Background()), IsNil)
+	c.Assert(hosts(lb), DeepEquals, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+	w, _ := lb.ServerWeight(testutils.ParseURI("http://10.0.0.2:8080"))
+	c.Assert(w, Equals, 2)
+
+	// the empty prefix keeps the servers
+	reply = `{"header": {"revision": "8"}}`
+	c.Assert(d.Refresh(context.Background()), NotNil)
+	c.Assert(len(lb.Servers()), Equals, 2)
+}
+
+func (s *EtcdSuite) TestPrefixEnd(c *C) {
+	c.Assert(string(prefixEnd("a/b")), Equals, "a/c")
+	c.Assert(prefixEnd("a\xff"), DeepEquals, []byte("b"))
+	c.Assert(prefixEnd("\xff"), DeepEquals, []byte{0})
+
+	_, err := NewEtcd(nil, "")
+	c.Assert(err, NotNil)
+}
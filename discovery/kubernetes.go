@@ -0,0 +1,320 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mailgun/oxy/roundrobin"
+)
+
+// In cluster service account files used when the API address is not set
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Kubernetes watches the EndpointSlices of the service and keeps the ready addresses in the server set.
+// The zones of the endpoints and their topology hints are passed on with roundrobin.Zone.
+// When the service has no ready endpoints, the serving (terminating) ones are used, and when there are none
+// of them either, the servers are kept as they are.
+//
+//	k, _ := discovery.NewKubernetes(lb, "default", "api", discovery.Port("http"))
+//	go k.Run(ctx)
+type Kubernetes struct {
+	*options
+	set       roundrobin.ServerSet
+	namespace string
+	service   string
+
+	mutex   sync.Mutex
+	slices  map[string]*endpointSlice
+	version string
+}
+
+// NewKubernetes returns the watcher of the EndpointSlices of the service. Without the Address option
+// it connects to the API server of the cluster it runs in using the service account of the pod.
+func NewKubernetes(set roundrobin.ServerSet, namespace, service string, opts ...Option) (*Kubernetes, error) {
+	if namespace == "" || service == "" {
+		return nil, fmt.Errorf("namespace and service can not be empty")
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.address == "" {
+		if err := inCluster(o); err != nil {
+			return nil, err
+		}
+	}
+	return &Kubernetes{
+		options:   o,
+		set:       set,
+		namespace: namespace,
+		service:   service,
+		slices:    make(map[string]*endpointSlice),
+	}, nil
+}
+
+// inCluster sets the address, the token and the CA of the API server from the pod environment
+func inCluster(o *options) error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("not running in a cluster, set the API server address")
+	}
+	o.address = "https://" + net.JoinHostPort(host, port)
+	if o.token == "" {
+		token, err := os.ReadFile(serviceAccountToken)
+		if err != nil {
+			return err
+		}
+		o.token = string(token)
+	}
+	if o.client == http.DefaultClient {
+		ca, err := os.ReadFile(serviceAccountCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates in %v", serviceAccountCA)
+		}
+		o.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	return nil
+}
+
+// Run lists and watches the EndpointSlices until the context is done, relisting them after the failures
+func (k *Kubernetes) Run(ctx context.Context) {
+	for {
+		err := k.List(ctx)
+		for err == nil {
+			err = k.watch(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		k.log.Warningf("failed to watch EndpointSlices of %v/%v: %v", k.namespace, k.service, err)
+		if !k.wait(ctx.Done(), k.retry) {
+			return
+		}
+	}
+}
+
+// List fetches the EndpointSlices of the service and syncs the servers, the sync failures are logged
+func (k *Kubernetes) List(ctx context.Context) error {
+	re, err := k.request(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer re.Body.Close()
+	var list endpointSliceList
+	if err := json.NewDecoder(re.Body).Decode(&list); err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.slices = make(map[string]*endpointSlice, len(list.Items))
+	for i := range list.Items {
+		k.slices[list.Items[i].Metadata.Name] = &list.Items[i]
+	}
+	k.version = list.Metadata.ResourceVersion
+	k.sync()
+	return nil
+}
+
+// watch applies the watch events to the slices until the stream ends, it returns nil when the watch
+// can be resumed from the last seen version
+func (k *Kubernetes) watch(ctx context.Context) error {
+	k.mutex.Lock()
+	version := k.version
+	k.mutex.Unlock()
+	re, err := k.request(ctx, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {version},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return err
+	}
+	defer re.Body.Close()
+	scanner := bufio.NewScanner(re.Body)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var e watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if err := k.apply(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (k *Kubernetes) apply(e watchEvent) error {
+	if e.Type == "ERROR" {
+		// e.g. 410 Gone once the version is too old, the slices have to be relisted
+		var status struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(e.Object, &status)
+		return fmt.Errorf("watch error %v: %v", status.Code, status.Message)
+	}
+	var slice endpointSlice
+	if err := json.Unmarshal(e.Object, &slice); err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.version = slice.Metadata.ResourceVersion
+	switch e.Type {
+	case "ADDED", "MODIFIED":
+		k.slices[slice.Metadata.Name] = &slice
+	case "DELETED":
+		delete(k.slices, slice.Metadata.Name)
+	default:
+		// BOOKMARK only moves the version
+		return nil
+	}
+	k.sync()
+	return nil
+}
+
+func (k *Kubernetes) request(ctx context.Context, query url.Values) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("labelSelector", "kubernetes.io/service-name="+k.service)
+	u := fmt.Sprintf("%v/apis/discovery.k8s.io/v1/namespaces/%v/endpointslices?%v",
+		k.address, url.PathEscape(k.namespace), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	return k.do(req)
+}
+
+// Endpoints returns the ready endpoints of the listed slices, or the serving ones if none are ready
+func (k *Kubernetes) Endpoints() []roundrobin.Endpoint {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.endpoints()
+}
+
+// endpoints is called with the lock held
+func (k *Kubernetes) endpoints() []roundrobin.Endpoint {
+	names := make([]string, 0, len(k.slices))
+	for name := range k.slices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ready, serving []roundrobin.Endpoint
+	seen := make(map[string]bool)
+	for _, name := range names {
+		slice := k.slices[name]
+		port, ok := slice.port(k.port)
+		if !ok {
+			continue
+		}
+		for _, e := range slice.Endpoints {
+			if len(e.Addresses) == 0 {
+				continue
+			}
+			// all addresses of the endpoint are fungible, the first one is used
+			u := &url.URL{Scheme: k.scheme, Host: net.JoinHostPort(e.Addresses[0], strconv.Itoa(port))}
+			if seen[u.Host] {
+				continue
+			}
+			seen[u.Host] = true
+			ep := roundrobin.Endpoint{URL: u, Zone: e.Zone}
+			if e.Hints != nil {
+				for _, z := range e.Hints.ForZones {
+					ep.ZoneHints = append(ep.ZoneHints, z.Name)
+				}
+			}
+			// the absent condition stands for true
+			switch {
+			case e.Conditions.Ready == nil || *e.Conditions.Ready:
+				ready = append(ready, ep)
+			case e.Conditions.Serving != nil && *e.Conditions.Serving:
+				serving = append(serving, ep)
+			}
+		}
+	}
+	if len(ready) == 0 {
+		return serving
+	}
+	return ready
+}
+
+// sync is called with the lock held
+func (k *Kubernetes) sync() {
+	if err := syncServers(k.set, k.endpoints()); err != nil {
+		k.log.Warningf("failed to sync servers of %v/%v: %v", k.namespace, k.service, err)
+	}
+}
+
+type endpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready   *bool `json:"ready"`
+			Serving *bool `json:"serving"`
+		} `json:"conditions"`
+		Zone  string `json:"zone"`
+		Hints *struct {
+			ForZones []struct {
+				Name string `json:"name"`
+			} `json:"forZones"`
+		} `json:"hints"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int    `json:"port"`
+	} `json:"ports"`
+}
+
+// port returns the named port of the slice, or the first one if the name is empty
+func (s *endpointSlice) port(name string) (int, bool) {
+	for _, p := range s.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if name == "" || (p.Name != nil && *p.Name == name) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
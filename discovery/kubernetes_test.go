@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/roundrobin"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestDiscovery(t *testing.T) { TestingT(t) }
+
+type KubernetesSuite struct{}
+
+var _ = Suite(&KubernetesSuite{})
+
+const slices = `{"metadata": {"resourceVersion": "10"}, "items": [
+	{"metadata": {"name": "api-a", "resourceVersion": "9"},
+	 "ports": [{"name": "metrics", "port": 9100}, {"name": "http", "port": 8080}],
+	 "endpoints": [
+		{"addresses": ["10.0.0.1"], "conditions": {"ready": true}, "zone": "eu-1a",
+		 "hints": {"forZones": [{"name": "eu-1a"}, {"name": "eu-1b"}]}},
+		{"addresses": ["10.0.0.2"], "conditions": {"ready": false, "serving": true}},
+		{"addresses": ["10.0.0.3"]}
+	 ]},
+	{"metadata": {"name": "api-b", "resourceVersion": "10"},
+	 "ports": [{"name": "http", "port": 8080}],
+	 "endpoints": [{"addresses": ["fd00::1"], "conditions": {"ready": true}, "zone": "eu-1b"}]}
+]}`
+
+// newAPI returns the API server listing the slices and streaming the events to the watchers
+func newAPI(c *C, events chan string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Check(req.URL.Path, Equals, "/apis/discovery.k8s.io/v1/namespaces/default/endpointslices")
+		c.Check(req.URL.Query().Get("labelSelector"), Equals, "kubernetes.io/service-name=api")
+		c.Check(req.Header.Get("Authorization"), Equals, "Bearer secret")
+		if req.URL.Query().Get("watch") == "" {
+			fmt.Fprint(w, slices)
+			return
+		}
+		c.Check(req.URL.Query().Get("resourceVersion"), Not(Equals), "")
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				// the events are streamed one per line
+				fmt.Fprintln(w, strings.Join(strings.Fields(e), " "))
+				w.(http.Flusher).Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}))
+}
+
+func hosts(set roundrobin.ServerSet) []string {
+	var out []string
+	for _, u := range set.Servers() {
+		out = append(out, u.Host)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// eventually waits for the servers of the set to match
+func eventually(c *C, set roundrobin.ServerSet, expected ...string) {
+	for i := 0; i < 200; i++ {
+		if fmt.Sprint(hosts(set)) == fmt.Sprint(expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("servers %v, expected %v", hosts(set), expected)
+}
+
+func (s *KubernetesSuite) TestList(c *C) {
+	api := newAPI(c, nil)
+	defer api.Close()
+
+	var decisions []roundrobin.Decision
+	lb, err := roundrobin.New(nil, roundrobin.OnSelect(func(d roundrobin.Decision) { decisions = append(decisions, d) }))
+	c.Assert(err, IsNil)
+	k, err := NewKubernetes(lb, "default", "api", Address(api.URL), Token("secret"), Port("http"))
+	c.Assert(err, IsNil)
+
+	c.Assert(k.List(context.Background()), IsNil)
+	c.Assert(hosts(lb), DeepEquals, []string{"10.0.0.1:8080", "10.0.0.3:8080", "[fd00::1]:8080"})
+
+	// the zones are passed on to the selectors
+	_, err = lb.NextServer()
+	c.Assert(err, IsNil)
+	c.Assert(decisions[0].Candidates[0].Zone, Equals, "eu-1a")
+	c.Assert(decisions[0].Candidates[0].ZoneHints, DeepEquals, []string{"eu-1a", "eu-1b"})
+	c.Assert(decisions[0].Candidates[2].Zone, Equals, "eu-1b")
+}
+
+func (s *KubernetesSuite) TestWatch(c *C) {
+	events := make(chan string)
+	api := newAPI(c, events)
+	defer api.Close()
+
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	k, err := NewKubernetes(lb, "default", "api", Address(api.URL), Token("secret"), Port("http"), Retry(time.Millisecond))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		k.Run(ctx)
+		close(done)
+	}()
+	eventually(c, lb, "10.0.0.1:8080", "10.0.0.3:8080", "[fd00::1]:8080")
+
+	events <- `{"type": "DELETED", "object": {"metadata": {"name": "api-b", "resourceVersion": "11"}}}`
+	eventually(c, lb, "10.0.0.1:8080", "10.0.0.3:8080")
+
+	events <- `{"type": "MODIFIED", "object": {"metadata": {"name": "api-a", "resourceVersion": "12"},
+		"ports": [{"name": "http", "port": 8080}],
+		"endpoints": [{"addresses": ["10.0.0.4"], "conditions": {"ready": true}}]}}`
+	eventually(c, lb, "10.0.0.4:8080")
+
+	// with no ready endpoints the terminating ones still serving are used
+	events <- `{"type": "MODIFIED", "object": {"metadata": {"name": "api-a", "resourceVersion": "13"},
+		"ports": [{"name": "http", "port": 8080}],
+		"endpoints": [{"addresses": ["10.0.0.4"], "conditions": {"ready": false, "serving": true}}]}}`
+	events <- `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "14"}}}`
+	eventually(c, lb, "10.0.0.4:8080")
+
+	// the expired watch relists the slices
+	events <- `{"type": "ERROR", "object": {"code": 410, "message": "too old resource version"}}`
+	eventually(c, lb, "10.0.0.1:8080", "10.0.0.3:8080", "[fd00::1]:8080")
+
+	cancel()
+	<-done
+}
+
+func (s *KubernetesSuite) TestKeepServers(c *C) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"metadata": {"resourceVersion": "1"}, "items": []}`)
+	}))
+	defer api.Close()
+
+	lb, err := roundrobin.New(nil)
+	c.Assert(err, IsNil)
+	lb.UpsertServer(&url.URL{Scheme: "http", Host: "10.0.0.1:8080"})
+	k, err := NewKubernetes(lb, "default", "api", Address(api.URL))
+	c.Assert(err, IsNil)
+	c.Assert(k.List(context.Background()), IsNil)
+	c.Assert(hosts(lb), DeepEquals, []string{"10.0.0.1:8080"})
+}
+
+func (s *KubernetesSuite) TestErrors(c *C) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer api.Close()
+
+	k, err := NewKubernetes(nil, "default", "api", Address(api.URL))
+	c.Assert(err, IsNil)
+	err = k.List(context.Background())
+	c.Assert(err, FitsTypeOf, &StatusError{})
+	c.Assert(err.(*StatusError).Code, Equals, http.StatusForbidden)
+
+	_, err = NewKubernetes(nil, "", "api", Address(api.URL))
+	c.Assert(err, NotNil)
+	_, err = NewKubernetes(nil, "default", "api", Address(api.URL), Scheme("ftp"))
+	c.Assert(err, NotNil)
+	_, err = NewKubernetes(nil, "default", "api", Address(api.URL), Retry(0))
+	c.Assert(err, NotNil)
+}
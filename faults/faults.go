@@ -0,0 +1,213 @@
+// Package faults injects the artificial latency, the aborts, the connection resets and the truncated responses
+// into the matching requests, for testing the resilience of the clients behind the proxy.
+//
+//	// delay 10% of the requests to /api by a second and reset the connections of 1% of them
+//	api := func(req *http.Request) bool { return strings.HasPrefix(req.URL.Path, "/api") }
+//	i, _ := faults.New(handler,
+//		faults.Inject(faults.Fault{Percent: 10, Match: api, Delay: time.Second}),
+//		faults.Inject(faults.Fault{Percent: 1, Match: api, Reset: true}))
+package faults
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// Fault is injected into the percentage of the matching requests. The delay comes first,
+// then the request is aborted, reset or its response is truncated, if set.
+type Fault struct {
+	// Percent of the matching requests the fault is injected into, from 0 to 100
+	Percent float64
+	// Match selects the requests, all of them if nil
+	Match func(req *http.Request) bool
+	// Delay is the latency added before the request is served or aborted
+	Delay time.Duration
+	// Abort answers the request with the status code instead of serving it
+	Abort int
+	// Reset closes the client connection with TCP RST instead of serving the request
+	Reset bool
+	// Truncate cuts the connection once that many bytes of the response body are written
+	Truncate int64
+}
+
+// Injector injects the first fault hitting the request
+type Injector struct {
+	next   http.Handler
+	faults []Fault
+	clock  timetools.TimeProvider
+	log    utils.Logger
+}
+
+// InjectorOption is a functional option setter for Injector
+type InjectorOption func(i *Injector) error
+
+// New returns the injector of the faults, at least one is required
+func New(next http.Handler, options ...InjectorOption) (*Injector, error) {
+	i := &Injector{next: next}
+	for _, o := range options {
+		if err := o(i); err != nil {
+			return nil, err
+		}
+	}
+	if len(i.faults) == 0 {
+		return nil, fmt.Errorf("at least one fault is required")
+	}
+	if i.clock == nil {
+		i.clock = &timetools.RealTime{}
+	}
+	if i.log == nil {
+		i.log = utils.NullLogger
+	}
+	return i, nil
+}
+
+func (i *Injector) Wrap(next http.Handler) {
+	i.next = next
+}
+
+func (i *Injector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f := i.pick(req)
+	if f == nil {
+		i.next.ServeHTTP(w, req)
+		return
+	}
+	if f.Delay > 0 {
+		select {
+		case <-i.clock.After(f.Delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	switch {
+	case f.Reset:
+		i.log.Infof("resetting connection of %v", req.URL)
+		i.reset(w)
+	case f.Abort != 0:
+		i.log.Infof("aborting %v with %d", req.URL, f.Abort)
+		w.WriteHeader(f.Abort)
+		w.Write([]byte(http.StatusText(f.Abort)))
+	case f.Truncate > 0:
+		i.log.Infof("truncating response of %v after %d bytes", req.URL, f.Truncate)
+		tw := &truncatingWriter{ResponseWriter: w, left: f.Truncate}
+		i.next.ServeHTTP(tw, req)
+		if tw.truncated {
+			// the bytes written so far reach the client, then the server closes the connection
+			// without completing the response
+			tw.Flush()
+			panic(http.ErrAbortHandler)
+		}
+	default:
+		i.next.ServeHTTP(w, req)
+	}
+}
+
+func (i *Injector) pick(req *http.Request) *Fault {
+	for k := range i.faults {
+		f := &i.faults[k]
+		if f.Match != nil && !f.Match(req) {
+			continue
+		}
+		if rand.Float64()*100 < f.Percent {
+			return f
+		}
+	}
+	return nil
+}
+
+// reset hijacks the connection and closes it with TCP RST, the connections that can't be hijacked,
+// e.g. HTTP/2 streams, are aborted
+func (i *Injector) reset(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		i.log.Warningf("failed to hijack connection: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// truncatingWriter stops writing the response body once the limit is reached
+type truncatingWriter struct {
+	http.ResponseWriter
+	left      int64
+	truncated bool
+}
+
+func (t *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= t.left {
+		n, err := t.ResponseWriter.Write(p)
+		t.left -= int64(n)
+		return n, err
+	}
+	n, err := t.ResponseWriter.Write(p[:t.left])
+	t.left -= int64(n)
+	t.truncated = true
+	if err != nil {
+		return n, err
+	}
+	return n, fmt.Errorf("response truncated by the fault injection")
+}
+
+func (t *truncatingWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (t *truncatingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// Inject adds the fault, the faults are tried in the order they are added
+func Inject(f Fault) InjectorOption {
+	return func(i *Injector) error {
+		if f.Percent < 0 || f.Percent > 100 {
+			return fmt.Errorf("fault percent should be in [0, 100], got %v", f.Percent)
+		}
+		if f.Delay < 0 || f.Truncate < 0 {
+			return fmt.Errorf("fault delay and truncate should be >= 0, got %v, %d", f.Delay, f.Truncate)
+		}
+		if f.Abort != 0 && (f.Abort < 100 || f.Abort > 999) {
+			return fmt.Errorf("fault abort should be a status code, got %d", f.Abort)
+		}
+		actions := 0
+		for _, set := range []bool{f.Abort != 0, f.Reset, f.Truncate > 0} {
+			if set {
+				actions++
+			}
+		}
+		if actions > 1 {
+			return fmt.Errorf("fault can either abort, reset or truncate")
+		}
+		if actions == 0 && f.Delay == 0 {
+			return fmt.Errorf("fault should delay, abort, reset or truncate")
+		}
+		i.faults = append(i.faults, f)
+		return nil
+	}
+}
+
+// Clock sets the clock of the delays
+func Clock(clock timetools.TimeProvider) InjectorOption {
+	return func(i *Injector) error {
+		i.clock = clock
+		return nil
+	}
+}
+
+// Logger sets the logger used by this middleware
+func Logger(l utils.Logger) InjectorOption {
+	return func(i *Injector) error {
+		i.log = l
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package faults
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestFaults(t *testing.T) { TestingT(t) }
+
+type FaultsSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&FaultsSuite{})
+
+func (s *FaultsSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *FaultsSuite) serve(c *C, faults ...Fault) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello "))
+		w.Write([]byte("world"))
+	})
+	options := []InjectorOption{Clock(s.clock)}
+	for _, f := range faults {
+		options = append(options, Inject(f))
+	}
+	i, err := New(handler, options...)
+	c.Assert(err, IsNil)
+	return httptest.NewServer(i)
+}
+
+func api(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/api")
+}
+
+func (s *FaultsSuite) TestAbort(c *C) {
+	srv := s.serve(c, Fault{Percent: 100, Match: api, Abort: http.StatusServiceUnavailable})
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL + "/api")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+
+	// the requests not matching are served
+	re, body, err := testutils.Get(srv.URL + "/static")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello world")
+}
+
+func (s *FaultsSuite) TestDelay(c *C) {
+	srv := s.serve(c, Fault{Percent: 100, Delay: 3 * time.Second})
+	defer srv.Close()
+
+	start := s.clock.UtcNow()
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello world")
+	c.Assert(s.clock.UtcNow().Sub(start), Equals, 3*time.Second)
+}
+
+func (s *FaultsSuite) TestPercent(c *C) {
+	srv := s.serve(c, Fault{Percent: 0, Abort: http.StatusInternalServerError}, Fault{Percent: 100, Abort: http.StatusBadGateway})
+	defer srv.Close()
+
+	for i := 0; i < 10; i++ {
+		re, _, err := testutils.Get(srv.URL)
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+	}
+}
+
+func (s *FaultsSuite) TestReset(c *C) {
+	srv := s.serve(c, Fault{Percent: 100, Reset: true})
+	defer srv.Close()
+
+	_, _, err := testutils.Get(srv.URL)
+	c.Assert(err, NotNil)
+}
+
+func (s *FaultsSuite) TestTruncate(c *C) {
+	srv := s.serve(c, Fault{Percent: 100, Truncate: 8})
+	defer srv.Close()
+
+	_, body, err := testutils.Get(srv.URL)
+	c.Assert(err, NotNil)
+	c.Assert(string(body), Equals, "hello wo")
+}
+
+func (s *FaultsSuite) TestBadFaults(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	for _, f := range []Fault{
+		{Percent: 101, Abort: 500},
+		{Percent: -1, Abort: 500},
+		{Percent: 10, Delay: -time.Second},
+		{Percent: 10, Abort: 5000},
+		{Percent: 10, Abort: 500, Reset: true},
+		{Percent: 10},
+	} {
+		_, err := New(handler, Inject(f))
+		c.Assert(err, NotNil, Commentf("%+v", f))
+	}
+	_, err := New(handler)
+	c.Assert(err, NotNil)
+}
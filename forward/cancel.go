@@ -0,0 +1,67 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Failure tells the requests given up on because of the clients from the ones failed by the backends
+type Failure int
+
+const (
+	// UpstreamFailed is the network error, the timeout or the rejected response of the backend
+	UpstreamFailed Failure = iota
+	// ClientGone is the request canceled by the client disconnecting, or stalled by the client past the body timeouts
+	ClientGone
+)
+
+func (f Failure) String() string {
+	if f == ClientGone {
+		return "client-gone"
+	}
+	return "upstream-failed"
+}
+
+// FailureObserver may be implemented by ReqObserver to get the failures of the round trips and of the copies
+// of the response bodies. OnFailure is called before OnResponse for the round trips.
+type FailureObserver interface {
+	OnFailure(r *http.Request, failure Failure, err error)
+}
+
+// ClientGoneError replaces the error of the round trip or the body copy aborted by the client disconnecting,
+// it matches context.Canceled, so the error handlers serve it with 499 status code
+type ClientGoneError struct {
+	Err error
+}
+
+func (e *ClientGoneError) Error() string {
+	return fmt.Sprintf("client went away: %v", e.Err)
+}
+
+func (e *ClientGoneError) Unwrap() []error {
+	return []error{context.Canceled, e.Err}
+}
+
+// failed logs and observes the error of forwarding the request, the round trip and the body copy use the context
+// of the incoming request, so they are aborted once the client goes away
+func (f *Forwarder) failed(req *http.Request, err error, stage string) error {
+	failure := UpstreamFailed
+	var timeoutErr *BodyTimeoutError
+	if errors.As(err, &timeoutErr) && timeoutErr.Client {
+		// the timed out connection cancels the request too, the timeout is served as such
+		failure = ClientGone
+	} else if req.Context().Err() == context.Canceled {
+		failure, err = ClientGone, &ClientGoneError{Err: err}
+	}
+	if failure == ClientGone {
+		f.log.Infof("Client gone %v %v, err: %v", stage, req.URL, err)
+	} else {
+		f.log.Errorf("Error %v %v, err: %v", stage, req.URL, err)
+	}
+	if o, ok := f.observer.(FailureObserver); ok {
+		o.OnFailure(req, failure, err)
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type CancelSuite struct{}
+
+var _ = Suite(&CancelSuite{})
+
+type failure struct {
+	failure Failure
+	err     error
+}
+
+type failureObserver struct {
+	mtx       sync.Mutex
+	failures  []failure
+	responses int
+}
+
+func (o *failureObserver) OnRequest(r *http.Request) {
+}
+
+func (o *failureObserver) OnResponse(r *http.Request, resp *http.Response, d time.Duration) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.responses++
+}
+
+func (o *failureObserver) OnFailure(r *http.Request, f Failure, err error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.failures = append(o.failures, failure{f, err})
+}
+
+// backend blocks until its request is canceled, after writing the headers if flush is set
+func backend(flush bool, canceled chan struct{}) *httptest.Server {
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if flush {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		<-req.Context().Done()
+		close(canceled)
+	})
+}
+
+// forwardCanceled forwards the request canceled once the delay passes
+func forwardCanceled(f *Forwarder, u string, delay time.Duration) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(delay, cancel)
+	req := httptest.NewRequest("GET", u, nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	return w
+}
+
+func (s *CancelSuite) TestRoundTripCanceled(c *C) {
+	canceled := make(chan struct{})
+	srv := backend(false, canceled)
+	defer srv.Close()
+
+	o := &failureObserver{}
+	f, err := New(Observer(o))
+	c.Assert(err, IsNil)
+
+	w := forwardCanceled(f, srv.URL, 50*time.Millisecond)
+	c.Assert(w.Code, Equals, utils.StatusClientClosedRequest)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		c.Fatal("upstream request was not canceled")
+	}
+	c.Assert(len(o.failures), Equals, 1)
+	c.Assert(o.failures[0].failure, Equals, ClientGone)
+	var gone *ClientGoneError
+	c.Assert(errors.As(o.failures[0].err, &gone), Equals, true)
+	c.Assert(errors.Is(o.failures[0].err, context.Canceled), Equals, true)
+	c.Assert(o.responses, Equals, 1)
+}
+
+func (s *CancelSuite) TestBodyCopyCanceled(c *C) {
+	canceled := make(chan struct{})
+	srv := backend(true, canceled)
+	defer srv.Close()
+
+	o := &failureObserver{}
+	f, err := New(Observer(o))
+	c.Assert(err, IsNil)
+
+	w := forwardCanceled(f, srv.URL, 50*time.Millisecond)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		c.Fatal("upstream request was not canceled")
+	}
+	c.Assert(len(o.failures), Equals, 1)
+	c.Assert(o.failures[0].failure, Equals, ClientGone)
+	c.Assert(o.failures[0].failure.String(), Equals, "client-gone")
+}
+
+func (s *CancelSuite) TestUpstreamFailed(c *C) {
+	srv := testutils.NewResponder("hello")
+	u := srv.URL
+	srv.Close()
+
+	o := &failureObserver{}
+	f, err := New(Observer(o))
+	c.Assert(err, IsNil)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", u, nil))
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+	c.Assert(len(o.failures), Equals, 1)
+	c.Assert(o.failures[0].failure, Equals, UpstreamFailed)
+	c.Assert(o.failures[0].failure.String(), Equals, "upstream-failed")
+}
@@ -0,0 +1,175 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Capture is the exchange with the backend recorded by CaptureTraffic. The headers are the ones sent to
+// and received from the backend, the bodies are cut to the first bytes of the buffer.
+type Capture struct {
+	Time           time.Time   `json:"time"`
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    []byte      `json:"request_body,omitempty"`
+	StatusCode     int         `json:"status_code,omitempty"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   []byte      `json:"response_body,omitempty"`
+	// Duration is the time from sending the request until the response body is copied
+	Duration time.Duration `json:"duration"`
+	// Error is set if the round trip fails
+	Error string `json:"error,omitempty"`
+}
+
+// CaptureBuffer is the ring buffer of the last captures, it can be shared by the forwarders
+// and is read by the admin API, see oxyadmin.WithCaptures
+type CaptureBuffer struct {
+	mutex     sync.Mutex
+	captures  []Capture
+	next      int
+	full      bool
+	bodyBytes int
+}
+
+// NewCaptureBuffer returns the buffer keeping the last size captures with the first bodyBytes of the bodies
+func NewCaptureBuffer(size, bodyBytes int) (*CaptureBuffer, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("capture buffer size should be > 0, got %d", size)
+	}
+	if bodyBytes < 0 {
+		return nil, fmt.Errorf("captured body bytes should be >= 0, got %d", bodyBytes)
+	}
+	return &CaptureBuffer{captures: make([]Capture, size), bodyBytes: bodyBytes}, nil
+}
+
+// Captures returns the captures from the oldest to the newest
+func (b *CaptureBuffer) Captures() []Capture {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.full {
+		return append([]Capture(nil), b.captures[:b.next]...)
+	}
+	return append(append([]Capture(nil), b.captures[b.next:]...), b.captures[:b.next]...)
+}
+
+func (b *CaptureBuffer) add(c Capture) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.captures[b.next] = c
+	b.next = (b.next + 1) % len(b.captures)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// CaptureTraffic records the fraction of the exchanges, from 0 to 1, into the buffer for debugging
+// the misbehaving backends. The headers are recorded as is, including the credentials, so the buffer
+// should be exposed to the operators only.
+func CaptureTraffic(b *CaptureBuffer, fraction float64) optSetter {
+	return func(f *Forwarder) error {
+		if b == nil {
+			return fmt.Errorf("capture buffer can not be nil")
+		}
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("captured fraction should be in (0, 1], got %v", fraction)
+		}
+		f.captures = b
+		f.captureFraction = fraction
+		return nil
+	}
+}
+
+// capture is the exchange being recorded, the nil capture records nothing
+type capture struct {
+	f        *Forwarder
+	c        Capture
+	start    time.Time
+	reqBody  *captureBody
+	respBody *captureBody
+}
+
+// startCapture samples the request and starts recording the body sent to the backend
+func (f *Forwarder) startCapture(outReq *http.Request) *capture {
+	if f.captures == nil || rand.Float64() >= f.captureFraction {
+		return nil
+	}
+	now := f.clock.UtcNow()
+	c := &capture{
+		f:     f,
+		start: now,
+		c: Capture{
+			Time:          now,
+			Method:        outReq.Method,
+			URL:           outReq.URL.String(),
+			RequestHeader: outReq.Header.Clone(),
+		},
+	}
+	if outReq.Body != nil && outReq.Body != http.NoBody {
+		c.reqBody = &captureBody{ReadCloser: outReq.Body, limit: f.captures.bodyBytes}
+		outReq.Body = c.reqBody
+	}
+	return c
+}
+
+// response starts recording the body of the response
+func (c *capture) response(re *http.Response) {
+	if c == nil {
+		return
+	}
+	c.c.StatusCode = re.StatusCode
+	c.c.ResponseHeader = re.Header.Clone()
+	c.respBody = &captureBody{ReadCloser: re.Body, limit: c.f.captures.bodyBytes}
+	re.Body = c.respBody
+}
+
+// finish adds the capture to the buffer, err is the error of the round trip if any
+func (c *capture) finish(err error) {
+	if c == nil {
+		return
+	}
+	if err != nil {
+		c.c.Error = err.Error()
+	}
+	if c.reqBody != nil {
+		c.c.RequestBody = c.reqBody.captured()
+	}
+	if c.respBody != nil {
+		c.c.ResponseBody = c.respBody.captured()
+	}
+	c.c.Duration = c.f.clock.UtcNow().Sub(c.start)
+	c.f.captures.add(c.c)
+}
+
+// captureBody keeps the first bytes read from the body, the round trip may read the request body concurrently
+type captureBody struct {
+	io.ReadCloser
+	mutex sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mutex.Lock()
+	if left := b.limit - len(b.buf); left > 0 && n > 0 {
+		if n < left {
+			left = n
+		}
+		b.buf = append(b.buf, p[:left]...)
+	}
+	b.mutex.Unlock()
+	return n, err
+}
+
+func (b *captureBody) captured() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte(nil), b.buf...)
+}
@@ -0,0 +1,94 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type CaptureSuite struct{}
+
+var _ = Suite(&CaptureSuite{})
+
+func (s *CaptureSuite) TestCapture(c *C) {
+	backend := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("X-Backend", "a")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created " + string(body)))
+	})
+	defer backend.Close()
+
+	b, err := NewCaptureBuffer(2, 5)
+	c.Assert(err, IsNil)
+	f, err := New(CaptureTraffic(b, 1))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, backend.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.MakeRequest(proxy.URL, testutils.Method("POST"), testutils.Body("hello world"), testutils.Header("X-Client", "c"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+	c.Assert(string(body), Equals, "created hello world")
+
+	captures := b.Captures()
+	c.Assert(len(captures), Equals, 1)
+	capture := captures[0]
+	c.Assert(capture.Method, Equals, "POST")
+	c.Assert(capture.URL, Equals, backend.URL)
+	c.Assert(capture.RequestHeader.Get("X-Client"), Equals, "c")
+	c.Assert(string(capture.RequestBody), Equals, "hello")
+	c.Assert(capture.StatusCode, Equals, http.StatusCreated)
+	c.Assert(capture.ResponseHeader.Get("X-Backend"), Equals, "a")
+	c.Assert(string(capture.ResponseBody), Equals, "creat")
+	c.Assert(capture.Error, Equals, "")
+
+	// the buffer keeps the last captures
+	testutils.Get(proxy.URL, testutils.Header("X-Client", "1"))
+	testutils.Get(proxy.URL, testutils.Header("X-Client", "2"))
+	captures = b.Captures()
+	c.Assert(len(captures), Equals, 2)
+	c.Assert(captures[0].RequestHeader.Get("X-Client"), Equals, "1")
+	c.Assert(captures[1].RequestHeader.Get("X-Client"), Equals, "2")
+}
+
+func (s *CaptureSuite) TestCaptureError(c *C) {
+	backend := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {})
+	backendURL := backend.URL
+	backend.Close()
+
+	b, err := NewCaptureBuffer(2, 5)
+	c.Assert(err, IsNil)
+	f, err := New(CaptureTraffic(b, 1))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, backendURL)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+
+	captures := b.Captures()
+	c.Assert(len(captures), Equals, 1)
+	c.Assert(captures[0].StatusCode, Equals, 0)
+	c.Assert(captures[0].Error, Not(Equals), "")
+}
+
+func (s *CaptureSuite) TestBadOptions(c *C) {
+	_, err := NewCaptureBuffer(0, 5)
+	c.Assert(err, NotNil)
+	_, err = NewCaptureBuffer(1, -1)
+	c.Assert(err, NotNil)
+
+	b, err := NewCaptureBuffer(1, 5)
+	c.Assert(err, IsNil)
+	_, err = New(CaptureTraffic(b, 0))
+	c.Assert(err, NotNil)
+	_, err = New(CaptureTraffic(b, 1.5))
+	c.Assert(err, NotNil)
+	_, err = New(CaptureTraffic(nil, 1))
+	c.Assert(err, NotNil)
+}
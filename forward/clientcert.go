@@ -0,0 +1,93 @@
+package forward
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// XForwardedClientCert is the default header of ClientCertRewriter
+const XForwardedClientCert = "X-Forwarded-Client-Cert"
+
+// CertField selects the fields of the client certificate forwarded by ClientCertRewriter
+type CertField int
+
+const (
+	// CertHash is the hex encoded SHA-256 fingerprint of the certificate
+	CertHash CertField = 1 << iota
+	// CertPEM is the URL encoded PEM of the certificate
+	CertPEM
+	// CertSubject is the subject distinguished name
+	CertSubject
+	// CertSAN are the DNS, URI, email and IP subject alternative names
+	CertSAN
+)
+
+// ClientCertRewriter passes the client certificate verified by the proxy to the backend in the header, in the format
+// of Envoy's X-Forwarded-Client-Cert: Hash=...;Cert="...";Subject="...";DNS=...;URI=...
+// The header sent by the client is always removed, so it can't be spoofed.
+type ClientCertRewriter struct {
+	// Header is the header set, X-Forwarded-Client-Cert by default
+	Header string
+	// Fields are the fields of the certificate forwarded, CertHash|CertSubject|CertSAN by default
+	Fields CertField
+	// Next is the rewriter called first, e.g. HeaderRewriter
+	Next ReqRewriter
+}
+
+func (rw *ClientCertRewriter) Rewrite(req *http.Request) {
+	if rw.Next != nil {
+		rw.Next.Rewrite(req)
+	}
+	header := rw.Header
+	if header == "" {
+		header = XForwardedClientCert
+	}
+	req.Header.Del(header)
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return
+	}
+	fields := rw.Fields
+	if fields == 0 {
+		fields = CertHash | CertSubject | CertSAN
+	}
+	req.Header.Set(header, formatClientCert(req.TLS.PeerCertificates[0], fields))
+}
+
+func formatClientCert(cert *x509.Certificate, fields CertField) string {
+	var out []string
+	if fields&CertHash != 0 {
+		h := sha256.Sum256(cert.Raw)
+		out = append(out, "Hash="+hex.EncodeToString(h[:]))
+	}
+	if fields&CertPEM != 0 {
+		p := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		out = append(out, `Cert="`+url.QueryEscape(string(p))+`"`)
+	}
+	if fields&CertSubject != 0 {
+		out = append(out, `Subject="`+escapeQuoted(cert.Subject.String())+`"`)
+	}
+	if fields&CertSAN != 0 {
+		for _, n := range cert.DNSNames {
+			out = append(out, "DNS="+n)
+		}
+		for _, u := range cert.URIs {
+			out = append(out, "URI="+u.String())
+		}
+		for _, e := range cert.EmailAddresses {
+			out = append(out, "Email="+e)
+		}
+		for _, ip := range cert.IPAddresses {
+			out = append(out, "IP="+ip.String())
+		}
+	}
+	return strings.Join(out, ";")
+}
+
+func escapeQuoted(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
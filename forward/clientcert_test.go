@@ -0,0 +1,86 @@
+package forward
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ClientCertSuite struct {
+	cert *x509.Certificate
+}
+
+var _ = Suite(&ClientCertSuite{})
+
+func (s *ClientCertSuite) SetUpSuite(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"Acme"}},
+		DNSNames:       []string{"client.example.com"},
+		URIs:           []*url.URL{testutils.ParseURI("spiffe://example.com/client")},
+		EmailAddresses: []string{"client@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	s.cert, err = x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+}
+
+func (s *ClientCertSuite) request() *http.Request {
+	req := httptest.NewRequest("GET", "https://localhost", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{s.cert}}
+	return req
+}
+
+func (s *ClientCertSuite) TestDefaultFields(c *C) {
+	req := s.request()
+	(&ClientCertRewriter{}).Rewrite(req)
+
+	h := sha256.Sum256(s.cert.Raw)
+	c.Assert(req.Header.Get(XForwardedClientCert), Equals,
+		"Hash="+hex.EncodeToString(h[:])+`;Subject="CN=client,O=Acme"`+
+			";DNS=client.example.com;URI=spiffe://example.com/client;Email=client@example.com")
+}
+
+func (s *ClientCertSuite) TestPEM(c *C) {
+	req := s.request()
+	(&ClientCertRewriter{Header: "X-Client-Cert", Fields: CertPEM}).Rewrite(req)
+
+	v := req.Header.Get("X-Client-Cert")
+	c.Assert(strings.HasPrefix(v, `Cert="`), Equals, true)
+	p, err := url.QueryUnescape(strings.TrimSuffix(strings.TrimPrefix(v, `Cert="`), `"`))
+	c.Assert(err, IsNil)
+	block, _ := pem.Decode([]byte(p))
+	c.Assert(block, NotNil)
+	c.Assert(block.Bytes, DeepEquals, s.cert.Raw)
+}
+
+func (s *ClientCertSuite) TestSpoofedHeaderRemoved(c *C) {
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set(XForwardedClientCert, "Hash=forged")
+	next := &HeaderRewriter{Hostname: "proxy"}
+	(&ClientCertRewriter{Next: next}).Rewrite(req)
+
+	c.Assert(req.Header.Get(XForwardedClientCert), Equals, "")
+	c.Assert(req.Header.Get(XForwardedServer), Equals, "proxy")
+}
@@ -0,0 +1,108 @@
+package forward
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+// ConnInfo describes the connection used for the round trip to the backend
+type ConnInfo struct {
+	// Reused tells whether the connection was taken from the idle pool
+	Reused bool
+	// WasIdle tells whether the reused connection was idle and for how long
+	WasIdle  bool
+	IdleTime time.Duration
+	// GetConn is the time it took to get the connection from the pool or to establish a new one
+	GetConn time.Duration
+	// DNS, Connect and TLSHandshake are the durations of the phases of establishing a new connection
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	RemoteAddr   string
+}
+
+// ConnObserver may be implemented by ReqObserver to get the info about the connection used for every round trip,
+// e.g. to diagnose the pool exhaustion and the cold connection latency. OnConnInfo is called before OnResponse.
+type ConnObserver interface {
+	OnConnInfo(r *http.Request, info ConnInfo)
+}
+
+// connTracer collects ConnInfo from httptrace hooks, the hooks can be called from different goroutines
+type connTracer struct {
+	clock timetools.TimeProvider
+	mtx   sync.Mutex
+	info  ConnInfo
+
+	getConnStart, dnsStart, connectStart, tlsStart time.Time
+}
+
+func newConnTracer(clock timetools.TimeProvider) *connTracer {
+	return &connTracer{clock: clock}
+}
+
+func (t *connTracer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			t.getConnStart = t.clock.UtcNow()
+		},
+		GotConn: func(i httptrace.GotConnInfo) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			t.info.Reused, t.info.WasIdle, t.info.IdleTime = i.Reused, i.WasIdle, i.IdleTime
+			if i.Conn != nil {
+				t.info.RemoteAddr = i.Conn.RemoteAddr().String()
+			}
+			if !t.getConnStart.IsZero() {
+				t.info.GetConn = t.clock.UtcNow().Sub(t.getConnStart)
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			t.dnsStart = t.clock.UtcNow()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			t.info.DNS = t.clock.UtcNow().Sub(t.dnsStart)
+		},
+		// connections to multiple addresses can be attempted, the first attempt starts the phase
+		ConnectStart: func(string, string) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			if t.connectStart.IsZero() {
+				t.connectStart = t.clock.UtcNow()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			if err == nil {
+				t.info.Connect = t.clock.UtcNow().Sub(t.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			t.tlsStart = t.clock.UtcNow()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mtx.Lock()
+			defer t.mtx.Unlock()
+			t.info.TLSHandshake = t.clock.UtcNow().Sub(t.tlsStart)
+		},
+	}
+}
+
+func (t *connTracer) connInfo() ConnInfo {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.info
+}
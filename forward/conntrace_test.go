@@ -0,0 +1,67 @@
+package forward
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ConnTraceSuite struct{}
+
+var _ = Suite(&ConnTraceSuite{})
+
+type connObserver struct {
+	infos []ConnInfo
+}
+
+func (o *connObserver) OnRequest(r *http.Request) {
+}
+
+func (o *connObserver) OnResponse(r *http.Request, resp *http.Response, d time.Duration) {
+}
+
+func (o *connObserver) OnConnInfo(r *http.Request, info ConnInfo) {
+	o.infos = append(o.infos, info)
+}
+
+func (s *ConnTraceSuite) TestConnReuse(c *C) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	o := &connObserver{}
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	f, err := New(Observer(o), RoundTripper(transport))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	for i := 0; i < 2; i++ {
+		re, body, err := testutils.Get(proxy.URL)
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusOK)
+		c.Assert(string(body), Equals, "hello")
+	}
+
+	c.Assert(len(o.infos), Equals, 2)
+	cold, warm := o.infos[0], o.infos[1]
+	c.Assert(cold.Reused, Equals, false)
+	c.Assert(cold.Connect > 0, Equals, true)
+	c.Assert(cold.TLSHandshake > 0, Equals, true)
+	c.Assert(cold.RemoteAddr, Equals, testutils.ParseURI(srv.URL).Host)
+
+	c.Assert(warm.Reused, Equals, true)
+	c.Assert(warm.WasIdle, Equals, true)
+	c.Assert(warm.Connect, Equals, time.Duration(0))
+	c.Assert(warm.TLSHandshake, Equals, time.Duration(0))
+}
@@ -0,0 +1,116 @@
+package forward
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CookieRewriter adjusts the attributes of the Set-Cookie headers of the backends to the host and the scheme
+// the client has requested, e.g. when the backends set the cookies for their internal hostnames.
+// The external scheme comes from X-Forwarded-Proto of the forwarded request, see HeaderRewriter.
+// The other attributes and the cookie values are kept as they are.
+//
+//	forward.ResponseRewriter(&forward.CookieRewriter{Domains: map[string]string{"*": ""}, Secure: true})
+type CookieRewriter struct {
+	// Domains maps the Domain attributes of the backends to the external ones, "*" matches any domain.
+	// The empty value removes the attribute, so the cookie is bound to the host the client has requested.
+	Domains map[string]string
+	// PathPrefix is prepended to the Path attributes, e.g. for the backends mounted under the prefix
+	PathPrefix string
+	// Secure sets the Secure attribute for the clients connected with https and removes it for the http ones,
+	// the browsers reject the secure cookies sent over http
+	Secure bool
+	// SameSite replaces the SameSite attribute unless it's 0
+	SameSite http.SameSite
+	// Next is the rewriter called first
+	Next RespRewriter
+}
+
+func (rw *CookieRewriter) Rewrite(resp *http.Response) error {
+	if rw.Next != nil {
+		if err := rw.Next.Rewrite(resp); err != nil {
+			return err
+		}
+	}
+	cookies := resp.Header["Set-Cookie"]
+	if len(cookies) == 0 {
+		return nil
+	}
+	scheme := ""
+	if resp.Request != nil {
+		scheme = strings.ToLower(strings.TrimSpace(resp.Request.Header.Get(XForwardedProto)))
+	}
+	out := make([]string, len(cookies))
+	for i, c := range cookies {
+		out[i] = rw.rewriteCookie(c, scheme)
+	}
+	resp.Header["Set-Cookie"] = out
+	return nil
+}
+
+func (rw *CookieRewriter) rewriteCookie(cookie, scheme string) string {
+	parts := strings.Split(cookie, ";")
+	out := []string{parts[0]}
+	for _, p := range parts[1:] {
+		name, value := strings.TrimSpace(p), ""
+		if i := strings.IndexByte(name, '='); i != -1 {
+			name, value = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+		}
+		switch strings.ToLower(name) {
+		case "domain":
+			d, ok := rw.domain(value)
+			if !ok {
+				break
+			}
+			if d != "" {
+				out = append(out, " Domain="+d)
+			}
+			continue
+		case "path":
+			if rw.PathPrefix != "" {
+				out = append(out, " Path="+strings.TrimSuffix(rw.PathPrefix, "/")+value)
+				continue
+			}
+		case "secure":
+			if rw.Secure && scheme != "" {
+				continue
+			}
+		case "samesite":
+			if rw.SameSite != 0 {
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	if rw.Secure && scheme == "https" {
+		out = append(out, " Secure")
+	}
+	if s := sameSite(rw.SameSite); s != "" {
+		out = append(out, " SameSite="+s)
+	}
+	return strings.Join(out, ";")
+}
+
+// domain returns the external domain of the backend one
+func (rw *CookieRewriter) domain(d string) (string, bool) {
+	backend := strings.ToLower(strings.TrimPrefix(d, "."))
+	for k, v := range rw.Domains {
+		if strings.ToLower(strings.TrimPrefix(k, ".")) == backend {
+			return v, true
+		}
+	}
+	v, ok := rw.Domains["*"]
+	return v, ok
+}
+
+func sameSite(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package forward
+
+import (
+	"net/http"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type CookiesSuite struct{}
+
+var _ = Suite(&CookiesSuite{})
+
+func rewriteCookies(c *C, rw *CookieRewriter, proto string, cookies ...string) []string {
+	req, err := http.NewRequest(http.MethodGet, "http://backend/", nil)
+	c.Assert(err, IsNil)
+	if proto != "" {
+		req.Header.Set(XForwardedProto, proto)
+	}
+	resp := &http.Response{Header: http.Header{"Set-Cookie": cookies}, Request: req}
+	c.Assert(rw.Rewrite(resp), IsNil)
+	return resp.Header["Set-Cookie"]
+}
+
+func (s *CookiesSuite) TestDomains(c *C) {
+	rw := &CookieRewriter{Domains: map[string]string{"backend.internal": "example.com", "*": ""}}
+	out := rewriteCookies(c, rw, "", "a=1; Domain=.Backend.internal; Path=/", "b=2; domain=other.internal; HttpOnly", "c=3")
+	c.Assert(out, DeepEquals, []string{"a=1; Domain=example.com; Path=/", "b=2; HttpOnly", "c=3"})
+
+	// the unmapped domains are kept
+	rw = &CookieRewriter{Domains: map[string]string{"backend.internal": "example.com"}}
+	out = rewriteCookies(c, rw, "", "b=2; Domain=other.internal")
+	c.Assert(out, DeepEquals, []string{"b=2; Domain=other.internal"})
+}
+
+func (s *CookiesSuite) TestPathPrefix(c *C) {
+	rw := &CookieRewriter{PathPrefix: "/app/"}
+	out := rewriteCookies(c, rw, "", "a=1; Path=/", "b=2; Path=/api; Max-Age=60")
+	c.Assert(out, DeepEquals, []string{"a=1; Path=/app/", "b=2; Path=/app/api; Max-Age=60"})
+}
+
+func (s *CookiesSuite) TestSecureAndSameSite(c *C) {
+	rw := &CookieRewriter{Secure: true, SameSite: http.SameSiteStrictMode}
+	c.Assert(rewriteCookies(c, rw, "https", "a=1; Path=/; SameSite=Lax"), DeepEquals, []string{"a=1; Path=/; Secure; SameSite=Strict"})
+	c.Assert(rewriteCookies(c, rw, "http", "a=1; Secure; Path=/"), DeepEquals, []string{"a=1; Path=/; SameSite=Strict"})
+	// the scheme is unknown without X-Forwarded-Proto
+	c.Assert(rewriteCookies(c, rw, "", "a=1; Secure"), DeepEquals, []string{"a=1; Secure; SameSite=Strict"})
+}
+
+func (s *CookiesSuite) TestForwarder(c *C) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "42", Domain: "backend.internal", Path: "/"})
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(ResponseRewriter(&CookieRewriter{Domains: map[string]string{"*": ""}, Secure: true}))
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	// the client has connected with http
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header["Set-Cookie"], DeepEquals, []string{"session=42; Path=/"})
+}
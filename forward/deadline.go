@@ -0,0 +1,162 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// BodyTimeouts limits the time spent on every chunk of the bodies independently of the overall request timeout,
+// so a backend trickling the response or a client sending or reading too slowly can't hold the proxy forever.
+// The read timeout applies to the next chunk of the request body from the client and of the response body
+// from the backend, the write timeout to the next chunk of the response body sent to the client, 0 disables
+// either of them. The client side deadlines are set with http.ResponseController and are skipped if the response
+// writer does not support them. The responses timed out after the headers have been sent are aborted.
+func BodyTimeouts(read, write time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		if read < 0 || write < 0 {
+			return fmt.Errorf("body timeouts should be >= 0, got %v and %v", read, write)
+		}
+		f.bodyReadTimeout = read
+		f.bodyWriteTimeout = write
+		return nil
+	}
+}
+
+// BodyTimeoutError reports the body chunk that has not been transferred in time
+type BodyTimeoutError struct {
+	// Client is set for the client side of the transfer
+	Client  bool
+	Write   bool
+	Timeout time.Duration
+}
+
+func (e *BodyTimeoutError) Error() string {
+	switch {
+	case e.Client && e.Write:
+		return fmt.Sprintf("client has not read the response body chunk in %v", e.Timeout)
+	case e.Client:
+		return fmt.Sprintf("client has not sent the request body chunk in %v", e.Timeout)
+	}
+	return fmt.Sprintf("backend has not sent the response body chunk in %v", e.Timeout)
+}
+
+// Unwrap makes the slow requests served with 408 by utils.StdHandler
+func (e *BodyTimeoutError) Unwrap() error {
+	if e.Client && !e.Write {
+		return utils.ErrClientTimeout
+	}
+	return nil
+}
+
+// clientBody sets the read deadline of the client connection before every read of the request body
+type clientBody struct {
+	io.ReadCloser
+	rc       *http.ResponseController
+	timeout  time.Duration
+	timedOut int32
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	if b.rc.SetReadDeadline(time.Now().Add(b.timeout)) != nil {
+		return b.ReadCloser.Read(p)
+	}
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		atomic.StoreInt32(&b.timedOut, 1)
+		return n, b.err()
+	}
+	if err != nil {
+		// the server reads the connection in the background once the body is consumed
+		b.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// err returns the timeout error if the client has not sent the body in time, the server cancels
+// the request context then, so the round trip may fail with the context error instead
+func (b *clientBody) err() error {
+	if atomic.LoadInt32(&b.timedOut) == 1 {
+		return &BodyTimeoutError{Client: true, Timeout: b.timeout}
+	}
+	return nil
+}
+
+// backendBody cancels the round trip once the next chunk of the response body is not received in time
+type backendBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func (b *backendBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.timeout, b.expire)
+	} else {
+		b.timer.Reset(b.timeout)
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && atomic.LoadInt32(&b.expired) == 1 {
+		return n, &BodyTimeoutError{Timeout: b.timeout}
+	}
+	return n, err
+}
+
+func (b *backendBody) expire() {
+	atomic.StoreInt32(&b.expired, 1)
+	b.cancel()
+}
+
+// copyBody copies the response body to the client, setting the write deadline before every chunk
+func (f *Forwarder) copyBody(w http.ResponseWriter, body io.Reader) (int64, error) {
+	if f.bodyWriteTimeout == 0 {
+		if fileBody(body) {
+			// io.Copy hides the file from the writer behind os.File.WriteTo
+			return readFrom(w, body)
+		}
+		return copyBuffer(w, body)
+	}
+	rc := http.NewResponseController(w)
+	deadlines := true
+	defer func() {
+		if deadlines {
+			rc.SetWriteDeadline(time.Time{})
+		}
+	}()
+	var written int64
+	pbuf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(pbuf)
+	buf := *pbuf
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if deadlines {
+				deadlines = rc.SetWriteDeadline(time.Now().Add(f.bodyWriteTimeout)) == nil
+			}
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if errors.Is(werr, os.ErrDeadlineExceeded) {
+				return written, &BodyTimeoutError{Client: true, Write: true, Timeout: f.bodyWriteTimeout}
+			}
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
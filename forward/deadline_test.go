@@ -0,0 +1,148 @@
+package forward
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type DeadlineSuite struct{}
+
+var _ = Suite(&DeadlineSuite{})
+
+// trickle returns the backend sending the chunks with the delay between them
+func trickle(delay time.Duration, chunks ...string) *httptest.Server {
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for i, chunk := range chunks {
+			if i != 0 {
+				time.Sleep(delay)
+			}
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+		}
+	})
+}
+
+func newDeadlineProxy(c *C, backend string, opts ...optSetter) *httptest.Server {
+	f, err := New(opts...)
+	c.Assert(err, IsNil)
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend)
+		f.ServeHTTP(w, req)
+	})
+}
+
+func (s *DeadlineSuite) TestBackendTrickle(c *C) {
+	srv := trickle(300*time.Millisecond, "a", "b")
+	defer srv.Close()
+	proxy := newDeadlineProxy(c, srv.URL, BodyTimeouts(50*time.Millisecond, 0))
+	defer proxy.Close()
+
+	// the response is aborted, before or after the buffered headers are sent,
+	// so the client does not take it for complete
+	re, err := http.Get(proxy.URL)
+	if err == nil {
+		_, err = io.ReadAll(re.Body)
+		re.Body.Close()
+	}
+	c.Assert(err, NotNil)
+}
+
+func (s *DeadlineSuite) TestSteadyStream(c *C) {
+	srv := trickle(20*time.Millisecond, "a", "b", "c", "d", "e", "f", "g", "h")
+	defer srv.Close()
+	proxy := newDeadlineProxy(c, srv.URL, BodyTimeouts(100*time.Millisecond, time.Second))
+	defer proxy.Close()
+
+	// the whole stream takes longer than the chunk timeout
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "abcdefgh")
+}
+
+func (s *DeadlineSuite) TestSlowClient(c *C) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+		w.Write([]byte("done"))
+	})
+	defer srv.Close()
+	proxy := newDeadlineProxy(c, srv.URL, BodyTimeouts(50*time.Millisecond, 0))
+	defer proxy.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("a"))
+		time.Sleep(300 * time.Millisecond)
+		pw.Write([]byte("b"))
+		pw.Close()
+	}()
+	re, err := http.Post(proxy.URL, "text/plain", pr)
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(re.StatusCode, Equals, http.StatusRequestTimeout)
+
+	// the fast clients are served
+	re, body, err := testutils.MakeRequest(proxy.URL, testutils.Method("POST"), testutils.Body("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "done")
+}
+
+// deadlineWriter fails the writes past the write deadline
+type deadlineWriter struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+	resets   int
+}
+
+func (w *deadlineWriter) SetWriteDeadline(t time.Time) error {
+	if t.IsZero() {
+		w.resets++
+	}
+	w.deadline = t
+	return nil
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	if w.ResponseRecorder.Body.Len() > 0 {
+		// the client stops reading after the first chunk
+		time.Sleep(time.Until(w.deadline))
+		return 0, os.ErrDeadlineExceeded
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func (s *DeadlineSuite) TestSlowReader(c *C) {
+	f, err := New(BodyTimeouts(0, 10*time.Millisecond))
+	c.Assert(err, IsNil)
+	w := &deadlineWriter{ResponseRecorder: httptest.NewRecorder()}
+	body := io.MultiReader(strings.NewReader("a"), strings.NewReader("b"))
+
+	written, err := f.copyBody(w, body)
+	c.Assert(written, Equals, int64(1))
+	c.Assert(err, FitsTypeOf, &BodyTimeoutError{})
+	c.Assert(err.(*BodyTimeoutError).Write, Equals, true)
+	c.Assert(w.resets, Equals, 1)
+
+	// writers without deadlines are copied to as usual
+	rec := httptest.NewRecorder()
+	written, err = f.copyBody(rec, bytes.NewReader([]byte("abc")))
+	c.Assert(err, IsNil)
+	c.Assert(written, Equals, int64(3))
+	c.Assert(rec.Body.String(), Equals, "abc")
+}
+
+func (s *DeadlineSuite) TestBadOptions(c *C) {
+	_, err := New(BodyTimeouts(-1, 0))
+	c.Assert(err, NotNil)
+}
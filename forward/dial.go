@@ -0,0 +1,125 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultFallbackDelay is the default delay before racing the next address, see RFC 8305 section 5
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialHappyEyeballs races connections to the addresses RFC 8305 style: the addresses are interleaved by family,
+// the next attempt starts once the previous one fails or the fallback delay passes, the first connection wins.
+// Negative delay disables racing, so the addresses are dialed one by one.
+func dialHappyEyeballs(ctx context.Context, dial dialFunc, network string, addrs []string, port string, delay time.Duration) (net.Conn, error) {
+	addrs = interleaveFamilies(addrs)
+	if delay < 0 {
+		var lastErr error
+		for _, a := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(a, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(addrs[next], port)
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, network, addr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	start()
+	var lastErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLosers(results, pending)
+				return r.conn, nil
+			}
+			lastErr = r.err
+			// failed attempt starts the next one right away
+			if next < len(addrs) {
+				start()
+				resetTimer(timer, delay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// closeLosers closes the connections established by the attempts that lost the race
+func closeLosers(results chan dialResult, pending int) {
+	for i := 0; i < pending; i++ {
+		if r := <-results; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// interleaveFamilies alternates IPv6 and IPv4 addresses starting with the family of the first address
+func interleaveFamilies(addrs []string) []string {
+	var first, second []string
+	firstIsV4 := len(addrs) != 0 && isIPv4(addrs[0])
+	for _, a := range addrs {
+		if isIPv4(a) == firstIsV4 {
+			first = append(first, a)
+		} else {
+			second = append(second, a)
+		}
+	}
+	out := make([]string, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+func isIPv4(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() != nil
+}
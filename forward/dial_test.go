@@ -0,0 +1,76 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type DialSuite struct{}
+
+var _ = Suite(&DialSuite{})
+
+func (s *DialSuite) TestInterleave(c *C) {
+	addrs := []string{"2001:db8::1", "2001:db8::2", "2001:db8::3", "10.0.0.1"}
+	c.Assert(interleaveFamilies(addrs), DeepEquals, []string{"2001:db8::1", "10.0.0.1", "2001:db8::2", "2001:db8::3"})
+
+	addrs = []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"}
+	c.Assert(interleaveFamilies(addrs), DeepEquals, []string{"10.0.0.1", "2001:db8::1", "10.0.0.2"})
+}
+
+// fakeDialer hangs on IPv6 addresses until canceled and connects to IPv4 addresses
+type fakeDialer struct {
+	mtx      sync.Mutex
+	dialed   []string
+	canceled chan string
+	fail     bool
+}
+
+func (d *fakeDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mtx.Lock()
+	d.dialed = append(d.dialed, addr)
+	d.mtx.Unlock()
+
+	host, _, _ := net.SplitHostPort(addr)
+	if isIPv4(host) {
+		if d.fail {
+			return nil, fmt.Errorf("connection refused")
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}
+	<-ctx.Done()
+	d.canceled <- addr
+	return nil, ctx.Err()
+}
+
+func (s *DialSuite) TestBrokenIPv6(c *C) {
+	d := &fakeDialer{canceled: make(chan string, 1)}
+	start := time.Now()
+	conn, err := dialHappyEyeballs(context.Background(), d.dial, "tcp", []string{"2001:db8::1", "10.0.0.1"}, "80", 10*time.Millisecond)
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+
+	// the hanging attempt is canceled once the race is won
+	c.Assert(<-d.canceled, Equals, "[2001:db8::1]:80")
+}
+
+func (s *DialSuite) TestAllFail(c *C) {
+	d := &fakeDialer{fail: true}
+	_, err := dialHappyEyeballs(context.Background(), d.dial, "tcp", []string{"10.0.0.1", "10.0.0.2"}, "80", time.Hour)
+	c.Assert(err, NotNil)
+	// failed attempt starts the next one without waiting for the delay
+	c.Assert(d.dialed, DeepEquals, []string{"10.0.0.1:80", "10.0.0.2:80"})
+}
+
+func (s *DialSuite) TestSequential(c *C) {
+	d := &fakeDialer{fail: true}
+	_, err := dialHappyEyeballs(context.Background(), d.dial, "tcp", []string{"10.0.0.1", "10.0.0.2"}, "80", -1)
+	c.Assert(err, NotNil)
+	c.Assert(d.dialed, DeepEquals, []string{"10.0.0.1:80", "10.0.0.2:80"})
+}
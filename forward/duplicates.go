@@ -0,0 +1,95 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// DuplicatePolicy tells how the multiple values of the response header are merged. The values set
+// on the response writer by the middlewares before the forwarder come first, followed by the values
+// of the backend in their order.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesAppend keeps all values, the default
+	DuplicatesAppend DuplicatePolicy = iota
+	// DuplicatesFirst keeps the first value, e.g. the value set by the middleware wins over the backend
+	DuplicatesFirst
+	// DuplicatesLast keeps the last value, e.g. the value of the backend wins over the middleware
+	DuplicatesLast
+	// DuplicatesJoin joins the values with the comma, Set-Cookie values are always kept as they are
+	DuplicatesJoin
+	// DuplicatesDrop removes the header having more than one value
+	DuplicatesDrop
+)
+
+// DuplicateHeaders sets the policy of the response headers with more than one value, e.g. Set-Cookie or
+// Access-Control-Allow-Origin sent by the backend and set by the CORS middleware. Without the headers
+// the policy applies to all headers not configured otherwise.
+//
+//	forward.New(forward.DuplicateHeaders(forward.DuplicatesLast), forward.DuplicateHeaders(forward.DuplicatesAppend, "Set-Cookie"))
+func DuplicateHeaders(p DuplicatePolicy, headers ...string) optSetter {
+	return func(f *Forwarder) error {
+		if p < DuplicatesAppend || p > DuplicatesDrop {
+			return fmt.Errorf("unsupported duplicate policy %d", p)
+		}
+		if len(headers) == 0 {
+			f.duplicatePolicy = p
+			return nil
+		}
+		if f.duplicateHeaders == nil {
+			f.duplicateHeaders = make(map[string]DuplicatePolicy)
+		}
+		for _, h := range headers {
+			h = http.CanonicalHeaderKey(h)
+			if p == DuplicatesJoin && h == "Set-Cookie" {
+				return fmt.Errorf("Set-Cookie values can not be joined")
+			}
+			f.duplicateHeaders[h] = p
+		}
+		return nil
+	}
+}
+
+// copyResponseHeaders adds the headers of the backend to the response writer, merging the duplicates
+func (f *Forwarder) copyResponseHeaders(dst, src http.Header) {
+	if f.duplicatePolicy == DuplicatesAppend && len(f.duplicateHeaders) == 0 {
+		utils.CopyHeaders(dst, src)
+		return
+	}
+	for k, vv := range src {
+		values := append(append([]string{}, dst[k]...), vv...)
+		if merged := f.mergeValues(k, values); len(merged) != 0 {
+			dst[k] = merged
+		} else {
+			delete(dst, k)
+		}
+	}
+}
+
+func (f *Forwarder) mergeValues(k string, values []string) []string {
+	if len(values) < 2 {
+		return values
+	}
+	p, ok := f.duplicateHeaders[http.CanonicalHeaderKey(k)]
+	if !ok {
+		p = f.duplicatePolicy
+	}
+	switch p {
+	case DuplicatesFirst:
+		return values[:1]
+	case DuplicatesLast:
+		return values[len(values)-1:]
+	case DuplicatesJoin:
+		if http.CanonicalHeaderKey(k) == "Set-Cookie" {
+			return values
+		}
+		return []string{strings.Join(values, ", ")}
+	case DuplicatesDrop:
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,82 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type DuplicatesSuite struct{}
+
+var _ = Suite(&DuplicatesSuite{})
+
+// duplicatesProxy sets the CORS header before the forwarder, the backend sends its own and two cookies
+func duplicatesProxy(c *C, opts ...optSetter) (*httptest.Server, func()) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "http://backend")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Origin")
+		w.Write([]byte("hello"))
+	})
+	f, err := New(opts...)
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	return proxy, func() {
+		proxy.Close()
+		srv.Close()
+	}
+}
+
+func (s *DuplicatesSuite) TestAppendByDefault(c *C) {
+	proxy, done := duplicatesProxy(c)
+	defer done()
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header["Access-Control-Allow-Origin"], DeepEquals, []string{"*", "http://backend"})
+	c.Assert(re.Header["Set-Cookie"], DeepEquals, []string{"a=1", "b=2"})
+}
+
+func (s *DuplicatesSuite) TestPolicies(c *C) {
+	proxy, done := duplicatesProxy(c,
+		DuplicateHeaders(DuplicatesJoin),
+		DuplicateHeaders(DuplicatesFirst, "access-control-allow-origin"))
+	defer done()
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header["Access-Control-Allow-Origin"], DeepEquals, []string{"*"})
+	c.Assert(re.Header["Vary"], DeepEquals, []string{"Accept, Origin"})
+	// cookies can't be joined
+	c.Assert(re.Header["Set-Cookie"], DeepEquals, []string{"a=1", "b=2"})
+}
+
+func (s *DuplicatesSuite) TestLastAndDrop(c *C) {
+	proxy, done := duplicatesProxy(c,
+		DuplicateHeaders(DuplicatesLast, "Access-Control-Allow-Origin", "Set-Cookie"),
+		DuplicateHeaders(DuplicatesDrop, "Vary"))
+	defer done()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(re.Header["Access-Control-Allow-Origin"], DeepEquals, []string{"http://backend"})
+	c.Assert(re.Header["Set-Cookie"], DeepEquals, []string{"b=2"})
+	c.Assert(re.Header["Vary"], IsNil)
+}
+
+func (s *DuplicatesSuite) TestBadOptions(c *C) {
+	_, err := New(DuplicateHeaders(DuplicatePolicy(42)))
+	c.Assert(err, NotNil)
+	_, err = New(DuplicateHeaders(DuplicatesJoin, "set-cookie"))
+	c.Assert(err, NotNil)
+}
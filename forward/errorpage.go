@@ -0,0 +1,140 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// ErrorPageData is passed to the error page templates
+type ErrorPageData struct {
+	// Code is the status code of the response
+	Code int
+	// Status is the text of the status code, e.g. "Bad Gateway"
+	Status string
+	// RequestID is taken from the request bag or the X-Request-Id header
+	RequestID string
+	// Time is the time the error page is rendered at
+	Time time.Time
+	// Method and Path of the request
+	Method string
+	Path   string
+}
+
+type errorPage struct {
+	contentType string
+	tmpl        interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+}
+
+// ErrorPage replaces the bodies of the responses with the status codes, returned by the backends
+// or generated by the forwarder, with the template rendered with ErrorPageData. The templates
+// of the text/html content type are parsed with html/template, the others with text/template. The text templates
+// have the json function encoding the value as JSON, the values sent by the client, e.g. RequestID and Path,
+// have to be encoded to keep the client from injecting into the body:
+//
+//	forward.ErrorPage("application/json", `{"error": {{json .Status}}, "request_id": {{json .RequestID}}}`, 502, 503, 504)
+func ErrorPage(contentType, tmpl string, codes ...int) optSetter {
+	return func(f *Forwarder) error {
+		if len(codes) == 0 {
+			return fmt.Errorf("error page should have at least one status code")
+		}
+		page := &errorPage{contentType: contentType}
+		var err error
+		if strings.HasPrefix(contentType, "text/html") {
+			page.tmpl, err = htmltemplate.New("error").Parse(tmpl)
+		} else {
+			page.tmpl, err = texttemplate.New("error").Funcs(errorPageFuncs).Parse(tmpl)
+		}
+		if err != nil {
+			return err
+		}
+		if f.errorPages == nil {
+			f.errorPages = make(map[int]*errorPage)
+		}
+		for _, code := range codes {
+			if code < 400 || code > 599 {
+				return fmt.Errorf("invalid status code: %d", code)
+			}
+			f.errorPages[code] = page
+		}
+		return nil
+	}
+}
+
+var errorPageFuncs = texttemplate.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// errorPageWriter renders the error page instead of the body for the configured status codes
+type errorPageWriter struct {
+	http.ResponseWriter
+	f       *Forwarder
+	req     *http.Request
+	replace bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	page, ok := w.f.errorPages[code]
+	if !ok {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.replace = true
+	body := &bytes.Buffer{}
+	if err := page.tmpl.Execute(body, w.f.errorPageData(w.req, code)); err != nil {
+		w.f.log.Errorf("Failed to render error page %v: %v", code, err)
+		body.Reset()
+		body.WriteString(http.StatusText(code))
+	}
+	h := w.Header()
+	utils.RemoveHeaders(h, ContentEncoding, "Content-Range", "Etag", "Last-Modified")
+	h.Set("Content-Type", page.contentType)
+	h.Set(ContentLength, strconv.Itoa(body.Len()))
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.Write(body.Bytes())
+}
+
+// Unwrap lets http.ResponseController reach the connection, see BodyTimeouts
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if w.replace {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (f *Forwarder) errorPageData(req *http.Request, code int) *ErrorPageData {
+	d := &ErrorPageData{
+		Code:   code,
+		Status: http.StatusText(code),
+		Time:   f.clock.UtcNow(),
+		Method: req.Method,
+		Path:   req.URL.Path,
+	}
+	if bag := utils.BagFromRequest(req); bag != nil {
+		if id, ok := bag.Get(utils.BagRequestID); ok {
+			d.RequestID, _ = id.(string)
+		}
+	}
+	if d.RequestID == "" {
+		d.RequestID = req.Header.Get("X-Request-Id")
+	}
+	return d
+}
@@ -0,0 +1,91 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type ErrorPageSuite struct{}
+
+var _ = Suite(&ErrorPageSuite{})
+
+func (s *ErrorPageSuite) TestUpstreamErrors(c *C) {
+	code := http.StatusServiceUnavailable
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Backend", "yes")
+		w.WriteHeader(code)
+		w.Write([]byte("stack trace of the backend"))
+	})
+	defer srv.Close()
+
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	f, err := New(Clock(clock),
+		ErrorPage("application/json", `{"error": {{json .Status}}, "id": {{json .RequestID}}, "time": "{{.Time.Unix}}", "path": {{json .Path}}}`, 502, 503),
+		ErrorPage("text/html; charset=utf-8", `<p>{{.Code}} {{.Path}}</p>`, 500))
+	c.Assert(err, IsNil)
+
+	id := "req-1"
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.URL = testutils.ParseURI(srv.URL + path)
+		req.Header.Set("X-Request-Id", id)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/a")
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	c.Assert(w.Header().Get("X-Backend"), Equals, "yes")
+	c.Assert(w.Body.String(), Equals, `{"error": "Service Unavailable", "id": "req-1", "time": "1330837567", "path": "/a"}`)
+
+	// the json function keeps the client from injecting into the body
+	id = `x", "admin": "1`
+	w = serve("/a")
+	c.Assert(w.Body.String(), Equals, `{"error": "Service Unavailable", "id": "x\", \"admin\": \"1", "time": "1330837567", "path": "/a"}`)
+	id = "req-1"
+
+	// html templates escape the variables
+	code = http.StatusInternalServerError
+	w = serve("/<b>")
+	c.Assert(w.Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	c.Assert(w.Body.String(), Equals, `<p>500 /&lt;b&gt;</p>`)
+
+	// other codes are passed through
+	code = http.StatusNotFound
+	w = serve("/a")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(w.Body.String(), Equals, "stack trace of the backend")
+}
+
+func (s *ErrorPageSuite) TestProxyErrors(c *C) {
+	f, err := New(ErrorPage("text/plain", `{{.Code}} for {{.RequestID}}`, 502))
+	c.Assert(err, IsNil)
+
+	req := httptest.NewRequest("GET", "http://localhost:63450", nil)
+	req = utils.WithBag(req, utils.NewBag())
+	utils.SetBagValue(req, utils.BagRequestID, "bag-id")
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+	c.Assert(w.Body.String(), Equals, "502 for bag-id")
+}
+
+func (s *ErrorPageSuite) TestBadOptions(c *C) {
+	_, err := New(ErrorPage("text/plain", "hello"))
+	c.Assert(err, NotNil)
+	_, err = New(ErrorPage("text/plain", "{{.Code", 502))
+	c.Assert(err, NotNil)
+	_, err = New(ErrorPage("text/plain", "hello", 200))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,54 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Fallback serves the idempotent requests without body from the targets in order when the request URL fails
+// with the network error or the 5xx status code, e.g. the static copy of the site in the S3 bucket behind
+// the primary backend. Only the scheme and the host of the targets are used, the path of the request is kept.
+// The response of the last target is returned if all of them fail.
+func Fallback(targets ...*url.URL) optSetter {
+	return func(f *Forwarder) error {
+		if len(targets) == 0 {
+			return fmt.Errorf("fallback targets can not be empty")
+		}
+		for _, t := range targets {
+			if t == nil || t.Host == "" {
+				return fmt.Errorf("fallback target should have the host, got %v", t)
+			}
+		}
+		f.fallbacks = targets
+		return nil
+	}
+}
+
+// fallbackRoundTrip sends the request to the fallback targets one by one while it fails
+func (f *Forwarder) fallbackRoundTrip(req, outReq *http.Request) (*http.Response, error) {
+	response, err := f.retriedRoundTrip(req, outReq)
+	if len(f.fallbacks) == 0 || !hedgeable(req) {
+		return response, err
+	}
+	for _, u := range f.fallbacks {
+		if !failed(response, err) || req.Context().Err() != nil {
+			return response, err
+		}
+		if err != nil {
+			f.log.Infof("falling back to %v after %v", u, err)
+		} else {
+			f.log.Infof("falling back to %v after %v", u, response.StatusCode)
+			discardResponse(response)
+		}
+		response, err = f.roundTripper.RoundTrip(hedgeRequest(outReq, u))
+		utils.SetBagValue(req, utils.BagBackend, u)
+	}
+	return response, err
+}
+
+func failed(re *http.Response, err error) bool {
+	return err != nil || re.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,80 @@
+package forward
+
+import (
+	"net/http"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type FallbackSuite struct{}
+
+var _ = Suite(&FallbackSuite{})
+
+func (s *FallbackSuite) TestFallbackOrder(c *C) {
+	primary := statusBackend(http.StatusBadGateway, "primary")
+	defer primary.Close()
+	second := statusBackend(http.StatusServiceUnavailable, "second")
+	defer second.Close()
+	bucket := statusBackend(http.StatusOK, "bucket")
+	defer bucket.Close()
+
+	f, err := New(Fallback(testutils.ParseURI(second.URL), testutils.ParseURI(bucket.URL)))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, primary.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "bucket")
+
+	// the requests with body are not sent to the fallbacks
+	re, body, err = testutils.MakeRequest(proxy.URL, testutils.Method("POST"), testutils.Body("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+	c.Assert(string(body), Equals, "primary")
+}
+
+func (s *FallbackSuite) TestPrimaryDown(c *C) {
+	primary := statusBackend(http.StatusOK, "primary")
+	primaryURL := primary.URL
+	primary.Close()
+	bucket := statusBackend(http.StatusOK, "bucket")
+	defer bucket.Close()
+
+	f, err := New(Fallback(testutils.ParseURI(bucket.URL)))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, primaryURL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "bucket")
+}
+
+func (s *FallbackSuite) TestPrimaryServes(c *C) {
+	primary := statusBackend(http.StatusNotFound, "primary")
+	defer primary.Close()
+	bucket := statusBackend(http.StatusOK, "bucket")
+	defer bucket.Close()
+
+	f, err := New(Fallback(testutils.ParseURI(bucket.URL)))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, primary.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+	c.Assert(string(body), Equals, "primary")
+}
+
+func (s *FallbackSuite) TestBadTargets(c *C) {
+	_, err := New(Fallback())
+	c.Assert(err, NotNil)
+	_, err = New(Fallback(testutils.ParseURI("/path")))
+	c.Assert(err, NotNil)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/mailgun/oxy/testutils"
 	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
 
 	. "gopkg.in/check.v1"
 )
@@ -55,6 +56,44 @@ func (s *FwdSuite) TestForwardHopHeaders(c *C) {
 	c.Assert(outHeaders.Get(KeepAlive), Equals, "")
 }
 
+type nopRewriter struct{}
+
+func (nopRewriter) Rewrite(*http.Request) {}
+
+// the hop-by-hop headers are skipped when copied, even if the rewriter keeps them
+func (s *FwdSuite) TestSkipHeaders(c *C) {
+	var outHeaders http.Header
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outHeaders = req.Header
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(Rewriter(nopRewriter{}), SkipHeaders("X-Internal-Token"))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	headers := http.Header{
+		KeepAlive:          []string{"timeout=600"},
+		"X-Internal-Token": []string{"secret"},
+		"X-Kept":           []string{"a", "b"},
+	}
+	re, _, err := testutils.Get(proxy.URL, testutils.Headers(headers))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(outHeaders.Get(KeepAlive), Equals, "")
+	c.Assert(outHeaders.Get("X-Internal-Token"), Equals, "")
+	c.Assert(outHeaders["X-Kept"], DeepEquals, []string{"a", "b"})
+
+	_, err = New(SkipHeaders("X Bad"))
+	c.Assert(err, NotNil)
+}
+
 func (s *FwdSuite) TestDefaultErrHandler(c *C) {
 	f, err := New()
 	c.Assert(err, IsNil)
@@ -279,3 +318,40 @@ func (s *FwdSuite) TestChunkedResponseConversion(c *C) {
 	c.Assert(re.StatusCode, Equals, http.StatusOK)
 	c.Assert(re.Header.Get("Content-Length"), Equals, fmt.Sprintf("%d", len("testtest1test2")))
 }
+
+func (s *FwdSuite) TestClock(c *C) {
+	clock := &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		clock.Sleep(time.Second)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	o := &recordingObserver{}
+	f, err := New(Clock(clock), Observer(o))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(o.durations, DeepEquals, []time.Duration{time.Second})
+}
+
+type recordingObserver struct {
+	durations []time.Duration
+}
+
+func (o *recordingObserver) OnRequest(r *http.Request) {
+}
+
+func (o *recordingObserver) OnResponse(r *http.Request, resp *http.Response, d time.Duration) {
+	o.durations = append(o.durations, d)
+}
@@ -1,10 +1,17 @@
 package forward
 
+import (
+	"fmt"
+
+	"github.com/mailgun/oxy/utils"
+)
+
 const (
 	XForwardedProto    = "X-Forwarded-Proto"
 	XForwardedFor      = "X-Forwarded-For"
 	XForwardedHost     = "X-Forwarded-Host"
 	XForwardedServer   = "X-Forwarded-Server"
+	XForwardedPort     = "X-Forwarded-Port"
 	Connection         = "Connection"
 	KeepAlive          = "Keep-Alive"
 	ProxyAuthenticate  = "Proxy-Authenticate"
@@ -14,6 +21,7 @@ const (
 	TransferEncoding   = "Transfer-Encoding"
 	Upgrade            = "Upgrade"
 	ContentLength      = "Content-Length"
+	ContentEncoding    = "Content-Encoding"
 )
 
 // Hop-by-hop headers. These are removed when sent to the backend.
@@ -29,3 +37,20 @@ var HopHeaders = []string{
 	TransferEncoding,
 	Upgrade,
 }
+
+// hopHeaders skips the hop-by-hop headers when the request headers are copied
+var hopHeaders = utils.NewHeaderFilter(HopHeaders...)
+
+// SkipHeaders drops the headers from the requests sent to the backends in addition to the hop-by-hop ones,
+// e.g. the internal headers set by the middlewares in front of the forwarder
+func SkipHeaders(names ...string) optSetter {
+	return func(f *Forwarder) error {
+		for _, n := range names {
+			if !utils.ValidHeaderName(n) {
+				return fmt.Errorf("invalid header name %q", n)
+			}
+		}
+		f.skipHeaders = hopHeaders.With(names...)
+		return nil
+	}
+}
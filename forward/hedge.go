@@ -0,0 +1,155 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// ServerPicker picks the backend for the hedged attempt, e.g. roundrobin.RoundRobin
+type ServerPicker interface {
+	NextServer() (*url.URL, error)
+}
+
+// Hedge enables hedging of idempotent requests without body: if the backend has not replied after the delay,
+// e.g. p95 latency, a duplicate request is sent to another backend picked by the picker. The first response wins,
+// the other attempt is canceled.
+func Hedge(delay time.Duration, picker ServerPicker) optSetter {
+	return func(f *Forwarder) error {
+		if delay <= 0 {
+			return fmt.Errorf("hedge delay should be > 0, got %v", delay)
+		}
+		if picker == nil {
+			return fmt.Errorf("hedge server picker can not be nil")
+		}
+		f.hedgeDelay = delay
+		f.hedgePicker = picker
+		return nil
+	}
+}
+
+// hedgePicks limits the attempts to pick the server different from the one serving the original request
+const hedgePicks = 3
+
+type hedgeAttempt struct {
+	index int
+	u     *url.URL
+	re    *http.Response
+	err   error
+}
+
+func (f *Forwarder) roundTrip(req, outReq *http.Request) (*http.Response, error) {
+	if f.hedgePicker == nil || !hedgeable(req) {
+		return f.roundTripper.RoundTrip(outReq)
+	}
+	return f.hedgedRoundTrip(req, outReq)
+}
+
+func (f *Forwarder) hedgedRoundTrip(req, outReq *http.Request) (*http.Response, error) {
+	results := make(chan hedgeAttempt, 2)
+	var cancels []context.CancelFunc
+	launch := func(r *http.Request, u *url.URL) {
+		ctx, cancel := context.WithCancel(r.Context())
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			re, err := f.roundTripper.RoundTrip(r.WithContext(ctx))
+			results <- hedgeAttempt{index: index, u: u, re: re, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(f.hedgeDelay)
+	defer timer.Stop()
+
+	launch(outReq, req.URL)
+	pending := 1
+	for {
+		select {
+		case a := <-results:
+			pending--
+			if a.err != nil && pending > 0 {
+				// the other attempt may still succeed
+				continue
+			}
+			for i, cancel := range cancels {
+				if i != a.index {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go discardAttempts(results, pending)
+			}
+			if a.err != nil {
+				cancels[a.index]()
+				return nil, a.err
+			}
+			if a.index != 0 {
+				f.log.Infof("hedged request to %v won over %v", a.u, req.URL)
+				utils.SetBagValue(req, utils.BagBackend, a.u)
+			}
+			a.re.Body = &cancelOnClose{ReadCloser: a.re.Body, cancel: cancels[a.index]}
+			return a.re, nil
+		case <-timer.C:
+			if len(cancels) != 1 {
+				continue
+			}
+			if u := f.pickHedgeServer(req.URL); u != nil {
+				launch(hedgeRequest(outReq, u), u)
+				pending++
+			}
+		}
+	}
+}
+
+func (f *Forwarder) pickHedgeServer(current *url.URL) *url.URL {
+	return f.pickServer(f.hedgePicker, []*url.URL{current})
+}
+
+func hedgeRequest(outReq *http.Request, u *url.URL) *http.Request {
+	r := new(http.Request)
+	*r = *outReq
+	r.URL = utils.CopyURL(outReq.URL)
+	r.URL.Scheme = u.Scheme
+	r.URL.Host = u.Host
+	// point the Host header at the hedge target like roundrobin does for the primary attempt
+	r.Host = u.Host
+	r.Header = make(http.Header)
+	utils.CopyHeaders(r.Header, outReq.Header)
+	return r
+}
+
+// hedgeable tells whether the request can be safely sent twice
+func hedgeable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0
+}
+
+// discardAttempts releases the responses of the canceled attempts
+func discardAttempts(results chan hedgeAttempt, pending int) {
+	for i := 0; i < pending; i++ {
+		if a := <-results; a.re != nil {
+			a.re.Body.Close()
+		}
+	}
+}
+
+// cancelOnClose releases the context of the winning attempt once the response body is consumed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,160 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type HedgeSuite struct{}
+
+var _ = Suite(&HedgeSuite{})
+
+type staticPicker struct {
+	u *url.URL
+}
+
+func (p *staticPicker) NextServer() (*url.URL, error) {
+	return p.u, nil
+}
+
+// newHedgeBackends returns the slow primary backend reporting canceled requests and the fast backend
+// reporting the Host header of the requests it gets
+func newHedgeBackends() (slow, fast *httptest.Server, canceled chan struct{}, hosts chan string) {
+	canceled = make(chan struct{}, 1)
+	hosts = make(chan string, 1)
+	slow = testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte("slow"))
+		case <-req.Context().Done():
+			canceled <- struct{}{}
+		}
+	})
+	fast = testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case hosts <- req.Host:
+		default:
+		}
+		w.Write([]byte("fast"))
+	})
+	return slow, fast, canceled, hosts
+}
+
+// newHedgeProxy sends the requests to the backend like roundrobin does
+func newHedgeProxy(f *Forwarder, backend string) *httptest.Server {
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend)
+		req.Host = req.URL.Host
+		f.ServeHTTP(w, req)
+	})
+}
+
+func (s *HedgeSuite) TestHedgeWins(c *C) {
+	slow, fast, canceled, hosts := newHedgeBackends()
+	defer slow.Close()
+	defer fast.Close()
+
+	f, err := New(Hedge(20*time.Millisecond, &staticPicker{u: testutils.ParseURI(fast.URL)}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, slow.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "fast")
+	// the hedged attempt carries the Host of its own backend
+	c.Assert(<-hosts, Equals, testutils.ParseURI(fast.URL).Host)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		c.Fatalf("primary attempt was not canceled")
+	}
+}
+
+func (s *HedgeSuite) TestPrimaryWins(c *C) {
+	fast := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	defer fast.Close()
+
+	var hits int32
+	other := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	})
+	defer other.Close()
+
+	f, err := New(Hedge(200*time.Millisecond, &staticPicker{u: testutils.ParseURI(other.URL)}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, fast.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "fast")
+	c.Assert(atomic.LoadInt32(&hits), Equals, int32(0))
+}
+
+func (s *HedgeSuite) TestNonIdempotentNotHedged(c *C) {
+	slow, fast, _, _ := newHedgeBackends()
+	defer slow.Close()
+	defer fast.Close()
+
+	f, err := New(Hedge(20*time.Millisecond, &staticPicker{u: testutils.ParseURI(fast.URL)}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, slow.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.MakeRequest(proxy.URL, testutils.Method("POST"), testutils.Body("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "slow")
+}
+
+func (s *HedgeSuite) TestSameServerNotHedged(c *C) {
+	slow, fast, _, _ := newHedgeBackends()
+	defer slow.Close()
+	defer fast.Close()
+
+	f, err := New(Hedge(20*time.Millisecond, &staticPicker{u: testutils.ParseURI(slow.URL)}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, slow.URL)
+	defer proxy.Close()
+
+	_, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "slow")
+}
+
+func (s *HedgeSuite) TestHedgeFailedPrimary(c *C) {
+	fast := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	defer fast.Close()
+
+	f, err := New(Hedge(20*time.Millisecond, &staticPicker{u: testutils.ParseURI(fast.URL)}))
+	c.Assert(err, IsNil)
+	// the primary fails before the hedge delay, so the error is returned right away
+	proxy := newHedgeProxy(f, "http://localhost:63450")
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+}
+
+func (s *HedgeSuite) TestBadOptions(c *C) {
+	_, err := New(Hedge(0, &staticPicker{}))
+	c.Assert(err, NotNil)
+	_, err = New(Hedge(time.Millisecond, nil))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,60 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// InterimResponses sets whether the interim 1xx responses of the backend, e.g. 103 Early Hints, are passed
+// to the client, they are passed by default. 101 Switching Protocols is not an interim response and is not affected.
+func InterimResponses(enabled bool) optSetter {
+	return func(f *Forwarder) error {
+		f.dropInterim = !enabled
+		return nil
+	}
+}
+
+// interimWriter writes the interim responses of the backend to the client until the final response arrives.
+// The transport calls the hook from its own goroutine, while the handler waits for the round trip to finish.
+type interimWriter struct {
+	mtx  sync.Mutex
+	w    http.ResponseWriter
+	done bool
+}
+
+func (i *interimWriter) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusSwitchingProtocols {
+				return nil
+			}
+			i.mtx.Lock()
+			defer i.mtx.Unlock()
+			// late responses of the hedged attempts that lost the race
+			if i.done {
+				return nil
+			}
+			h := i.w.Header()
+			// the headers of the interim response are not sent with the final one
+			saved := h.Clone()
+			utils.CopyHeaders(h, http.Header(header))
+			i.w.WriteHeader(code)
+			for k := range h {
+				delete(h, k)
+			}
+			utils.CopyHeaders(h, saved)
+			return nil
+		},
+	}
+}
+
+// finish stops writing the interim responses, it is called before the final response is written
+func (i *interimWriter) finish() {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.done = true
+}
@@ -0,0 +1,94 @@
+package forward
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type InterimSuite struct{}
+
+var _ = Suite(&InterimSuite{})
+
+func earlyHintsBackend() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Write([]byte("hello"))
+	}
+}
+
+type interimResponse struct {
+	code   int
+	header textproto.MIMEHeader
+}
+
+// getInterim makes the request and returns the interim responses received
+func getInterim(c *C, url string) ([]interimResponse, *http.Response) {
+	var got []interimResponse
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			got = append(got, interimResponse{code: code, header: header})
+			return nil
+		},
+	})
+	req, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	re, err := http.DefaultClient.Do(req.WithContext(ctx))
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(re.Body)
+	re.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	return got, re
+}
+
+func (s *InterimSuite) TestEarlyHints(c *C) {
+	srv := testutils.NewHandler(earlyHintsBackend())
+	defer srv.Close()
+
+	f, err := New()
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Proxy", "oxy")
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	got, re := getInterim(c, proxy.URL)
+	c.Assert(got, HasLen, 1)
+	c.Assert(got[0].code, Equals, http.StatusEarlyHints)
+	c.Assert(got[0].header.Get("Link"), Equals, "</style.css>; rel=preload; as=style")
+
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(re.Header.Get("Link"), Equals, "")
+	// the headers set before the interim response are kept
+	c.Assert(re.Header.Get("X-Proxy"), Equals, "oxy")
+}
+
+func (s *InterimSuite) TestDisabled(c *C) {
+	srv := testutils.NewHandler(earlyHintsBackend())
+	defer srv.Close()
+
+	f, err := New(InterimResponses(false))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	got, re := getInterim(c, proxy.URL)
+	c.Assert(got, HasLen, 0)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
@@ -0,0 +1,77 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// ResponseHeaderLimits caps the total size in bytes and the number of the backend response headers,
+// 0 stands for no limit. The responses exceeding the limits are rejected with 502 status code.
+// The size limit is also set on the *http.Transport, so the oversized headers are not read into memory.
+func ResponseHeaderLimits(maxBytes int64, maxCount int) optSetter {
+	return func(f *Forwarder) error {
+		if maxBytes < 0 || maxCount < 0 {
+			return fmt.Errorf("header limits can not be negative, got %d bytes and %d headers", maxBytes, maxCount)
+		}
+		f.maxHeaderBytes, f.maxHeaderCount = maxBytes, maxCount
+		return nil
+	}
+}
+
+// HeaderLimitError reports the backend response exceeding the header limits
+type HeaderLimitError struct {
+	msg string
+}
+
+func (e *HeaderLimitError) Error() string {
+	return e.msg
+}
+
+// Unwrap makes the error served with 502 status code by utils.DefaultHandler
+func (e *HeaderLimitError) Unwrap() error {
+	return utils.ErrInvalidResponse
+}
+
+// checkResponse returns the error if the response is rejected by the header limits or the validation
+func (f *Forwarder) checkResponse(resp *http.Response, err error) error {
+	if err := f.checkHeaderLimits(resp, err); err != nil {
+		return err
+	}
+	if f.validateResponses {
+		return validateResponse(resp)
+	}
+	return nil
+}
+
+// checkHeaderLimits returns the error if the response headers exceed the limits, the transport
+// error over the limit is converted to HeaderLimitError as well
+func (f *Forwarder) checkHeaderLimits(resp *http.Response, err error) error {
+	if err != nil {
+		if f.maxHeaderBytes > 0 && strings.Contains(err.Error(), "server response headers exceeded") {
+			return &HeaderLimitError{msg: fmt.Sprintf("response headers exceed %d bytes", f.maxHeaderBytes)}
+		}
+		return err
+	}
+	if f.maxHeaderBytes == 0 && f.maxHeaderCount == 0 {
+		return nil
+	}
+	var size int64
+	count := 0
+	for name, values := range resp.Header {
+		for _, v := range values {
+			// name: value\r\n
+			size += int64(len(name) + len(v) + 4)
+			count++
+		}
+	}
+	if f.maxHeaderCount > 0 && count > f.maxHeaderCount {
+		return &HeaderLimitError{msg: fmt.Sprintf("response has %d headers, over the limit of %d", count, f.maxHeaderCount)}
+	}
+	if f.maxHeaderBytes > 0 && size > f.maxHeaderBytes {
+		return &HeaderLimitError{msg: fmt.Sprintf("response headers of %d bytes exceed %d bytes", size, f.maxHeaderBytes)}
+	}
+	return nil
+}
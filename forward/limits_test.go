@@ -0,0 +1,82 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type LimitsSuite struct{}
+
+var _ = Suite(&LimitsSuite{})
+
+func serveHeaders(c *C, f *Forwarder, count, size int) int {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		for i := 0; i < count; i++ {
+			w.Header().Set(fmt.Sprintf("X-Header-%d", i), strings.Repeat("v", size))
+		}
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	req := httptest.NewRequest("GET", srv.URL, nil)
+	req.URL = testutils.ParseURI(srv.URL)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (s *LimitsSuite) TestHeaderCount(c *C) {
+	f, err := New(ResponseHeaderLimits(0, 10))
+	c.Assert(err, IsNil)
+
+	// the server adds Date, Content-Length and Content-Type
+	c.Assert(serveHeaders(c, f, 5, 10), Equals, http.StatusOK)
+	c.Assert(serveHeaders(c, f, 10, 10), Equals, http.StatusBadGateway)
+}
+
+func (s *LimitsSuite) TestHeaderBytes(c *C) {
+	f, err := New(ResponseHeaderLimits(4096, 0))
+	c.Assert(err, IsNil)
+	c.Assert(f.roundTripper.(*http.Transport).MaxResponseHeaderBytes, Equals, int64(4096))
+	c.Assert(http.DefaultTransport.(*http.Transport).MaxResponseHeaderBytes, Equals, int64(0))
+
+	c.Assert(serveHeaders(c, f, 2, 100), Equals, http.StatusOK)
+	// rejected by the transport
+	c.Assert(serveHeaders(c, f, 2, 8192), Equals, http.StatusBadGateway)
+	// the headers just over the limit
+	c.Assert(serveHeaders(c, f, 1, 4090), Equals, http.StatusBadGateway)
+}
+
+func (s *LimitsSuite) TestCustomRoundTripper(c *C) {
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		h := make(http.Header)
+		h.Set("X-Big", strings.Repeat("v", 200))
+		return &http.Response{StatusCode: http.StatusOK, Header: h, Body: http.NoBody}, nil
+	})
+	f, err := New(RoundTripper(rt), ResponseHeaderLimits(100, 0))
+	c.Assert(err, IsNil)
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+}
+
+func (s *LimitsSuite) TestBadOptions(c *C) {
+	_, err := New(ResponseHeaderLimits(-1, 0))
+	c.Assert(err, NotNil)
+	_, err = New(ResponseHeaderLimits(0, -1))
+	c.Assert(err, NotNil)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
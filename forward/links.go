@@ -0,0 +1,176 @@
+package forward
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultLinkContentTypes are the media types of the bodies rewritten by LinkRewriter by default
+var DefaultLinkContentTypes = []string{"text/html", "application/json"}
+
+// LinkRewriter rewrites the absolute backend URLs in the HTML attributes and the JSON string values of the response
+// bodies to the external ones, for the apps rendering the absolute links. The bodies are filtered as they stream,
+// the URLs split between the chunks are matched too. Only the URLs starting the quoted values, or the unquoted
+// HTML attribute values, are rewritten, including the JSON strings with the escaped slashes. The encoded bodies
+// are passed as they are, see DecodeResponses.
+//
+//	forward.ResponseRewriter(&forward.LinkRewriter{Rules: []forward.LocationRule{{From: "http://10.0.0.1:8080", To: "https://example.com"}}})
+type LinkRewriter struct {
+	// Rules replace the URL prefixes, the first matching rule wins
+	Rules []LocationRule
+	// Backend adds the rule replacing the scheme and the host of the backend of the request with the ones
+	// the client has requested, taken from X-Forwarded-Proto and X-Forwarded-Host, see HeaderRewriter
+	Backend bool
+	// ContentTypes are the media types of the rewritten bodies, DefaultLinkContentTypes if empty,
+	// application/json covers the types with the +json suffix too
+	ContentTypes []string
+	// Next is the rewriter called first
+	Next RespRewriter
+}
+
+func (rw *LinkRewriter) Rewrite(resp *http.Response) error {
+	if rw.Next != nil {
+		if err := rw.Next.Rewrite(resp); err != nil {
+			return err
+		}
+	}
+	if resp.Body == nil || resp.Header.Get(ContentEncoding) != "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !rw.rewritten(mediaType) {
+		return nil
+	}
+	rules := rw.Rules
+	if rw.Backend && resp.Request != nil {
+		scheme, host := resp.Request.Header.Get(XForwardedProto), resp.Request.Header.Get(XForwardedHost)
+		if scheme != "" && host != "" {
+			backend := LocationRule{From: resp.Request.URL.Scheme + "://" + resp.Request.URL.Host, To: scheme + "://" + host}
+			rules = append(append([]LocationRule{}, rules...), backend)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	json := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	resp.Body = newLinkReader(resp.Body, linkPatterns(rules, json))
+	resp.Header.Del(ContentLength)
+	resp.ContentLength = -1
+	return nil
+}
+
+func (rw *LinkRewriter) rewritten(mediaType string) bool {
+	types := rw.ContentTypes
+	if len(types) == 0 {
+		types = DefaultLinkContentTypes
+	}
+	for _, t := range types {
+		if strings.EqualFold(t, mediaType) || (strings.EqualFold(t, "application/json") && strings.HasSuffix(mediaType, "+json")) {
+			return true
+		}
+	}
+	return false
+}
+
+type linkPattern struct {
+	old, new []byte
+}
+
+// linkPatterns returns the patterns of the rules prefixed with the characters starting the values
+func linkPatterns(rules []LocationRule, json bool) []linkPattern {
+	var out []linkPattern
+	for _, r := range rules {
+		if json {
+			out = append(out,
+				linkPattern{old: []byte(`"` + r.From), new: []byte(`"` + r.To)},
+				linkPattern{old: []byte(`"` + strings.ReplaceAll(r.From, "/", `\/`)), new: []byte(`"` + strings.ReplaceAll(r.To, "/", `\/`))})
+			continue
+		}
+		for _, q := range []string{`"`, `'`, `=`} {
+			out = append(out, linkPattern{old: []byte(q + r.From), new: []byte(q + r.To)})
+		}
+	}
+	return out
+}
+
+// linkReader replaces the patterns in the stream, holding back the tail of the chunk that may be
+// the beginning of the pattern continued in the next chunk
+type linkReader struct {
+	src      io.ReadCloser
+	patterns []linkPattern
+	maxLen   int
+
+	buf []byte
+	in  []byte
+	out []byte
+	err error
+}
+
+func newLinkReader(src io.ReadCloser, patterns []linkPattern) *linkReader {
+	r := &linkReader{src: src, patterns: patterns, buf: make([]byte, 32*1024)}
+	for _, p := range patterns {
+		if len(p.old) > r.maxLen {
+			r.maxLen = len(p.old)
+		}
+	}
+	return r
+}
+
+func (r *linkReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 && r.err == nil {
+		n, err := r.src.Read(r.buf)
+		r.in = append(r.in, r.buf[:n]...)
+		r.err = err
+		r.filter(err != nil)
+	}
+	if len(r.out) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *linkReader) Close() error {
+	return r.src.Close()
+}
+
+// filter moves the input to the output replacing the patterns, the tail is kept unless it's the last chunk
+func (r *linkReader) filter(last bool) {
+	i := 0
+	for {
+		j, p := r.next(r.in[i:])
+		if p == nil {
+			break
+		}
+		r.out = append(r.out, r.in[i:i+j]...)
+		r.out = append(r.out, p.new...)
+		i += j + len(p.old)
+	}
+	end := len(r.in)
+	if !last {
+		// the pattern can't start before the tail without being found
+		if tail := len(r.in) - (r.maxLen - 1); tail < end {
+			end = tail
+		}
+		if end < i {
+			end = i
+		}
+	}
+	r.out = append(r.out, r.in[i:end]...)
+	r.in = append(r.in[:0], r.in[end:]...)
+}
+
+// next returns the earliest pattern found in b and its index
+func (r *linkReader) next(b []byte) (int, *linkPattern) {
+	index, found := -1, (*linkPattern)(nil)
+	for k := range r.patterns {
+		if j := bytes.Index(b, r.patterns[k].old); j != -1 && (index == -1 || j < index) {
+			index, found = j, &r.patterns[k]
+		}
+	}
+	return index, found
+}
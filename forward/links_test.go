@@ -0,0 +1,87 @@
+package forward
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing/iotest"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type LinksSuite struct{}
+
+var _ = Suite(&LinksSuite{})
+
+var linkRules = []LocationRule{{From: "http://10.0.0.1:8080", To: "https://example.com"}}
+
+func rewriteLinks(c *C, rw *LinkRewriter, contentType string, body io.Reader) (string, *http.Response) {
+	resp := &http.Response{
+		Header:        http.Header{"Content-Type": {contentType}, "Content-Length": {"42"}},
+		ContentLength: 42,
+		Body:          ioutil.NopCloser(body),
+	}
+	c.Assert(rw.Rewrite(resp), IsNil)
+	out, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	return string(out), resp
+}
+
+func (s *LinksSuite) TestHTML(c *C) {
+	html := `<a href="http://10.0.0.1:8080/a">a</a><img src='http://10.0.0.1:8080/b.png'><a href=http://10.0.0.1:8080/c>` +
+		`<p>see http://10.0.0.1:8080/text</p><a href="http://10.0.0.2:8080/other">`
+	expected := `<a href="https://example.com/a">a</a><img src='https://example.com/b.png'><a href=https://example.com/c>` +
+		`<p>see http://10.0.0.1:8080/text</p><a href="http://10.0.0.2:8080/other">`
+
+	rw := &LinkRewriter{Rules: linkRules}
+	out, resp := rewriteLinks(c, rw, "text/html; charset=utf-8", strings.NewReader(html))
+	c.Assert(out, Equals, expected)
+	c.Assert(resp.ContentLength, Equals, int64(-1))
+	c.Assert(resp.Header.Get(ContentLength), Equals, "")
+
+	// the links split between the chunks
+	out, _ = rewriteLinks(c, rw, "text/html", iotest.OneByteReader(strings.NewReader(html)))
+	c.Assert(out, Equals, expected)
+	out, _ = rewriteLinks(c, rw, "text/html", iotest.HalfReader(strings.NewReader(html)))
+	c.Assert(out, Equals, expected)
+}
+
+func (s *LinksSuite) TestJSON(c *C) {
+	json := `{"self": "http://10.0.0.1:8080/items/1", "escaped": "http:\/\/10.0.0.1:8080\/items\/2", "note": "a=http://10.0.0.1:8080"}`
+	expected := `{"self": "https://example.com/items/1", "escaped": "https:\/\/example.com\/items\/2", "note": "a=http://10.0.0.1:8080"}`
+
+	out, _ := rewriteLinks(c, &LinkRewriter{Rules: linkRules}, "application/hal+json", iotest.OneByteReader(strings.NewReader(json)))
+	c.Assert(out, Equals, expected)
+}
+
+func (s *LinksSuite) TestSkipped(c *C) {
+	body := `"http://10.0.0.1:8080/a"`
+	out, resp := rewriteLinks(c, &LinkRewriter{Rules: linkRules}, "text/plain", strings.NewReader(body))
+	c.Assert(out, Equals, body)
+	c.Assert(resp.ContentLength, Equals, int64(42))
+}
+
+func (s *LinksSuite) TestForwarder(c *C) {
+	var backend string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="` + backend + `/login">login</a>`))
+	})
+	defer srv.Close()
+	backend = srv.URL
+
+	f, err := New(ResponseRewriter(&LinkRewriter{Backend: true}))
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	_, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, `<a href="`+proxy.URL+`/login">login</a>`)
+}
@@ -0,0 +1,89 @@
+package forward
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LocationHeaders are rewritten by LocationRewriter
+var LocationHeaders = []string{"Location", "Content-Location"}
+
+// LocationRule replaces the prefix From of the location with To, e.g. http://10.0.0.1:8080/ with https://example.com/app/
+type LocationRule struct {
+	From string
+	To   string
+}
+
+// LocationRewriter rewrites the Location and Content-Location headers pointing to the internal addresses
+// of the backends to the externally visible ones, so the redirects don't leak them. The rules are tried first,
+// in order, then the locations of the backend the request was forwarded to are rewritten if Backend is set.
+//
+//	forward.ResponseRewriter(&forward.LocationRewriter{Backend: true, Prefix: "/app"})
+type LocationRewriter struct {
+	Rules []LocationRule
+	// Backend rewrites the locations pointing to the backend of the request to the scheme and the host
+	// the client has requested, taken from X-Forwarded-Proto and X-Forwarded-Host, see HeaderRewriter
+	Backend bool
+	// Prefix is prepended to the paths of the backend locations, the relative ones included,
+	// e.g. for the backends mounted under the prefix
+	Prefix string
+	// Next is the rewriter called first
+	Next RespRewriter
+}
+
+func (rw *LocationRewriter) Rewrite(resp *http.Response) error {
+	if rw.Next != nil {
+		if err := rw.Next.Rewrite(resp); err != nil {
+			return err
+		}
+	}
+	for _, h := range LocationHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			resp.Header.Set(h, rw.rewriteLocation(v, resp.Request))
+		}
+	}
+	return nil
+}
+
+func (rw *LocationRewriter) rewriteLocation(location string, req *http.Request) string {
+	for _, r := range rw.Rules {
+		if strings.HasPrefix(location, r.From) {
+			return r.To + location[len(r.From):]
+		}
+	}
+	if !rw.Backend || req == nil {
+		return location
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.Host == "" && u.Scheme == "" {
+		// the relative locations stay relative, only the prefix is added to the absolute paths
+		if strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(location, "//") {
+			return rw.prefixed(location)
+		}
+		return location
+	}
+	if !strings.EqualFold(u.Host, req.URL.Host) {
+		return location
+	}
+	scheme, host := req.Header.Get(XForwardedProto), req.Header.Get(XForwardedHost)
+	if scheme == "" || host == "" {
+		return location
+	}
+	u.Scheme, u.Host = scheme, host
+	u.Path = rw.prefixed(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = rw.prefixed(u.RawPath)
+	}
+	return u.String()
+}
+
+func (rw *LocationRewriter) prefixed(p string) string {
+	if rw.Prefix == "" {
+		return p
+	}
+	return strings.TrimSuffix(rw.Prefix, "/") + p
+}
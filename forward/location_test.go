@@ -0,0 +1,68 @@
+package forward
+
+import (
+	"net/http"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type LocationSuite struct{}
+
+var _ = Suite(&LocationSuite{})
+
+func rewriteLocation(c *C, rw *LocationRewriter, location string) string {
+	req, err := http.NewRequest(http.MethodGet, "http://10.0.0.1:8080/", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set(XForwardedProto, "https")
+	req.Header.Set(XForwardedHost, "example.com")
+	resp := &http.Response{Header: http.Header{"Location": {location}, "Content-Location": {location}}, Request: req}
+	c.Assert(rw.Rewrite(resp), IsNil)
+	c.Assert(resp.Header.Get("Content-Location"), Equals, resp.Header.Get("Location"))
+	return resp.Header.Get("Location")
+}
+
+func (s *LocationSuite) TestRules(c *C) {
+	rw := &LocationRewriter{Rules: []LocationRule{
+		{From: "http://internal:9000/", To: "https://api.example.com/"},
+		{From: "http://internal", To: "https://www.example.com"},
+	}}
+	c.Assert(rewriteLocation(c, rw, "http://internal:9000/v1/items?a=b"), Equals, "https://api.example.com/v1/items?a=b")
+	c.Assert(rewriteLocation(c, rw, "http://internal/login"), Equals, "https://www.example.com/login")
+	c.Assert(rewriteLocation(c, rw, "http://10.0.0.1:8080/login"), Equals, "http://10.0.0.1:8080/login")
+}
+
+func (s *LocationSuite) TestBackend(c *C) {
+	rw := &LocationRewriter{Backend: true, Prefix: "/app/"}
+	c.Assert(rewriteLocation(c, rw, "http://10.0.0.1:8080/login?next=%2F"), Equals, "https://example.com/app/login?next=%2F")
+	c.Assert(rewriteLocation(c, rw, "/login"), Equals, "/app/login")
+	c.Assert(rewriteLocation(c, rw, "login"), Equals, "login")
+	// other hosts are left alone
+	c.Assert(rewriteLocation(c, rw, "https://accounts.example.org/auth"), Equals, "https://accounts.example.org/auth")
+	c.Assert(rewriteLocation(c, rw, "//cdn.example.org/a.js"), Equals, "//cdn.example.org/a.js")
+}
+
+func (s *LocationSuite) TestForwarder(c *C) {
+	var backend string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, backend+"/login", http.StatusFound)
+	})
+	defer srv.Close()
+	backend = srv.URL
+
+	f, err := New(ResponseRewriter(&LocationRewriter{Backend: true}))
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	re, err := client.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(re.StatusCode, Equals, http.StatusFound)
+	c.Assert(re.Header.Get("Location"), Equals, proxy.URL+"/login")
+}
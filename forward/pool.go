@@ -0,0 +1,72 @@
+package forward
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers copying the bodies
+const copyBufferSize = 32 * 1024
+
+// copyBuffers are reused by the body copies of all the forwarders
+var copyBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, copyBufferSize)
+	return &b
+}}
+
+// copyBuffer copies the body using the pooled buffer
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// outRequests are the outgoing requests reused with PoolRequests, their header maps are kept
+var outRequests = sync.Pool{New: func() interface{} {
+	return &http.Request{Header: make(http.Header)}
+}}
+
+// PoolRequests reuses the outgoing requests and their header maps once the responses are copied to the clients.
+// The round tripper, the rewriters and the observers must not keep the outgoing request or the response
+// referencing it afterwards, so it is off by default. The requests are not reused with hedging, which may leave
+// the losing attempt in flight, or if the round trip fails.
+func PoolRequests() optSetter {
+	return func(f *Forwarder) error {
+		f.poolRequests = true
+		return nil
+	}
+}
+
+// newOutRequest returns the shallow copy of the request with the empty header map
+func (f *Forwarder) newOutRequest(req *http.Request) *http.Request {
+	if !f.pooled() {
+		outReq := new(http.Request)
+		*outReq = *req
+		outReq.Header = make(http.Header, len(req.Header))
+		return outReq
+	}
+	outReq := outRequests.Get().(*http.Request)
+	h := outReq.Header
+	*outReq = *req
+	outReq.Header = h
+	return outReq
+}
+
+// releaseOutRequest returns the request created by newOutRequest to the pool
+func (f *Forwarder) releaseOutRequest(outReq *http.Request) {
+	if !f.pooled() {
+		return
+	}
+	h := outReq.Header
+	for k := range h {
+		delete(h, k)
+	}
+	// the references to the incoming request are dropped, so it is collected
+	*outReq = http.Request{Header: h}
+	outRequests.Put(outReq)
+}
+
+func (f *Forwarder) pooled() bool {
+	return f.poolRequests && f.hedgePicker == nil
+}
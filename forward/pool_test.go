@@ -0,0 +1,105 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type PoolSuite struct{}
+
+var _ = Suite(&PoolSuite{})
+
+// the reused requests do not leak the headers of the previous ones
+func (s *PoolSuite) TestPoolRequests(c *C) {
+	var seen []string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		seen = append(seen, req.Header.Get("X-First")+","+req.Header.Get("X-Second"))
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(PoolRequests())
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	for _, h := range []string{"X-First", "X-Second"} {
+		re, body, err := testutils.Get(proxy.URL, testutils.Header(h, "1"))
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusOK)
+		c.Assert(string(body), Equals, "hello")
+	}
+	c.Assert(seen, DeepEquals, []string{"1,", ",1"})
+}
+
+func (s *PoolSuite) TestCopyBuffer(c *C) {
+	var out strings.Builder
+	n, err := copyBuffer(&out, strings.NewReader(strings.Repeat("a", 3*copyBufferSize)))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(3*copyBufferSize))
+	c.Assert(out.Len(), Equals, 3*copyBufferSize)
+}
+
+// staticTransport replies with the same body without the network
+type staticTransport struct {
+	body string
+}
+
+func (t *staticTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       ioutil.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+// discardWriter is the response writer reusing its header map
+type discardWriter struct {
+	h http.Header
+}
+
+func (w *discardWriter) Header() http.Header { return w.h }
+
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (w *discardWriter) WriteHeader(int) {}
+
+func benchmarkForward(b *testing.B, opts ...optSetter) {
+	f, err := New(append(opts, RoundTripper(&staticTransport{body: strings.Repeat("a", 4096)}))...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/path", nil)
+		for _, name := range []string{"Accept", "Accept-Encoding", "User-Agent", "Cookie", "X-Request-Id"} {
+			req.Header.Set(name, "value")
+		}
+		w := &discardWriter{h: make(http.Header)}
+		for pb.Next() {
+			for k := range w.h {
+				delete(w.h, k)
+			}
+			f.ServeHTTP(w, req)
+		}
+	})
+}
+
+func BenchmarkForward(b *testing.B) {
+	benchmarkForward(b)
+}
+
+func BenchmarkForwardPooled(b *testing.B) {
+	benchmarkForward(b, PoolRequests())
+}
@@ -0,0 +1,237 @@
+package forward
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Trace context headers propagated by TraceRewriter
+const (
+	Traceparent     = "Traceparent"
+	Tracestate      = "Tracestate"
+	B3              = "B3"
+	XB3TraceID      = "X-B3-TraceId"
+	XB3SpanID       = "X-B3-SpanId"
+	XB3ParentSpanID = "X-B3-ParentSpanId"
+	XB3Sampled      = "X-B3-Sampled"
+	XB3Flags        = "X-B3-Flags"
+)
+
+const (
+	// zeroTraceIDHalf pads the 64 bit B3 trace IDs
+	zeroTraceIDHalf = "0000000000000000"
+	// traceparentLen is the length of the version 00 traceparent
+	traceparentLen = 55
+)
+
+// TraceFormat selects the trace context headers propagated by TraceRewriter
+type TraceFormat int
+
+const (
+	// TraceW3C is the W3C trace context, traceparent and tracestate
+	TraceW3C TraceFormat = 1 << iota
+	// TraceB3 are the multiple X-B3-* headers of Zipkin
+	TraceB3
+	// TraceB3Single is the single b3 header of Zipkin
+	TraceB3Single
+)
+
+// traceIDs identify the hop of the request from the proxy to the backend
+type traceIDs struct {
+	// TraceID is the 32 hex characters ID of the trace
+	TraceID string
+	// SpanID is the ID of the proxy span, the parent of the backend span
+	SpanID string
+	// ParentID is the ID of the incoming span, empty if the proxy has started the trace
+	ParentID string
+	Sampled  bool
+}
+
+// TraceRewriter propagates the trace context of the incoming requests to the backends without
+// a tracing SDK: it continues the trace found in the enabled formats, W3C first, or starts a new one,
+// and sends the new span of the proxy in all enabled formats. The IDs are stored in the request bag
+// under utils.BagTraceID and utils.BagSpanID, so the tracer and the observers can log them.
+type TraceRewriter struct {
+	// Formats are the propagated formats, TraceW3C|TraceB3 by default
+	Formats TraceFormat
+	// Sampled is the sampling decision of the traces started by the proxy
+	Sampled bool
+	// Next is the rewriter called first, e.g. HeaderRewriter
+	Next ReqRewriter
+}
+
+func (rw *TraceRewriter) Rewrite(req *http.Request) {
+	if rw.Next != nil {
+		rw.Next.Rewrite(req)
+	}
+	formats := rw.Formats
+	if formats == 0 {
+		formats = TraceW3C | TraceB3
+	}
+
+	// the sampling decision may come alone, without the IDs
+	ids, state, ok := traceIDs{Sampled: rw.Sampled}, "", false
+	if formats&TraceW3C != 0 {
+		if found, valid := parseTraceparent(req.Header.Get(Traceparent)); valid {
+			// tracestate is meaningless without the valid traceparent
+			ids, state, ok = found, req.Header.Get(Tracestate), true
+		}
+	}
+	if !ok && formats&TraceB3Single != 0 {
+		ids, ok = parseB3Single(req.Header.Get(B3), ids.Sampled)
+	}
+	if !ok && formats&TraceB3 != 0 {
+		ids, _ = parseB3(req.Header, ids.Sampled)
+	}
+	if ids.TraceID == "" {
+		ids.TraceID = newID(16)
+	}
+	ids.SpanID = newID(8)
+
+	if formats&TraceW3C != 0 {
+		req.Header.Set(Traceparent, formatTraceparent(ids))
+		req.Header.Del(Tracestate)
+		if state != "" {
+			req.Header.Set(Tracestate, state)
+		}
+	}
+	if formats&TraceB3 != 0 {
+		for _, h := range []string{XB3TraceID, XB3SpanID, XB3ParentSpanID, XB3Sampled, XB3Flags} {
+			req.Header.Del(h)
+		}
+		req.Header.Set(XB3TraceID, b3TraceID(ids.TraceID))
+		req.Header.Set(XB3SpanID, ids.SpanID)
+		if ids.ParentID != "" {
+			req.Header.Set(XB3ParentSpanID, ids.ParentID)
+		}
+		req.Header.Set(XB3Sampled, sampledFlag(ids.Sampled, "1", "0"))
+	}
+	if formats&TraceB3Single != 0 {
+		b3 := b3TraceID(ids.TraceID) + "-" + ids.SpanID + "-" + sampledFlag(ids.Sampled, "1", "0")
+		if ids.ParentID != "" {
+			b3 += "-" + ids.ParentID
+		}
+		req.Header.Set(B3, b3)
+	}
+
+	utils.SetBagValue(req, utils.BagTraceID, ids.TraceID)
+	utils.SetBagValue(req, utils.BagSpanID, ids.SpanID)
+}
+
+// parseTraceparent parses version-traceid-parentid-flags, the versions above 00 may have more fields
+func parseTraceparent(v string) (traceIDs, bool) {
+	v = strings.TrimSpace(v)
+	if len(v) < traceparentLen || (len(v) > traceparentLen && (v[:2] == "00" || v[traceparentLen] != '-')) {
+		return traceIDs{}, false
+	}
+	parts := strings.SplitN(v[:traceparentLen], "-", 4)
+	if len(parts) != 4 || !isHex(parts[0], 2) || parts[0] == "ff" || !isHex(parts[3], 2) ||
+		!isID(parts[1], 32) || !isID(parts[2], 16) {
+		return traceIDs{}, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return traceIDs{TraceID: parts[1], ParentID: parts[2], Sampled: flags[0]&1 != 0}, true
+}
+
+// parseB3Single parses traceid-spanid-sampled-parentid or the sampling decision alone,
+// the decision alone is reported as not found, so the other formats are tried
+func parseB3Single(v string, sampled bool) (traceIDs, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 2 {
+		return traceIDs{Sampled: parseSampled(v, sampled)}, false
+	}
+	traceID, ok := normalizeB3TraceID(parts[0])
+	spanID := strings.ToLower(parts[1])
+	if !ok || !isID(spanID, 16) {
+		return traceIDs{Sampled: sampled}, false
+	}
+	ids := traceIDs{TraceID: traceID, ParentID: spanID, Sampled: sampled}
+	if len(parts) > 2 {
+		ids.Sampled = parseSampled(parts[2], sampled)
+	}
+	return ids, true
+}
+
+func parseB3(h http.Header, sampled bool) (traceIDs, bool) {
+	ids := traceIDs{Sampled: parseSampled(h.Get(XB3Sampled), sampled)}
+	if h.Get(XB3Flags) == "1" {
+		ids.Sampled = true
+	}
+	traceID, ok := normalizeB3TraceID(h.Get(XB3TraceID))
+	spanID := strings.ToLower(h.Get(XB3SpanID))
+	if !ok || !isID(spanID, 16) {
+		return ids, false
+	}
+	ids.TraceID, ids.ParentID = traceID, spanID
+	return ids, true
+}
+
+func parseSampled(v string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "d", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	return def
+}
+
+// normalizeB3TraceID pads the 64 bit B3 trace IDs to 128 bits
+func normalizeB3TraceID(id string) (string, bool) {
+	id = strings.ToLower(id)
+	if isID(id, 16) {
+		return zeroTraceIDHalf + id, true
+	}
+	return id, isID(id, 32)
+}
+
+// b3TraceID sends the padded 64 bit trace IDs as they were received
+func b3TraceID(id string) string {
+	if strings.HasPrefix(id, zeroTraceIDHalf) {
+		return id[len(zeroTraceIDHalf):]
+	}
+	return id
+}
+
+func formatTraceparent(ids traceIDs) string {
+	return "00-" + ids.TraceID + "-" + ids.SpanID + "-" + sampledFlag(ids.Sampled, "01", "00")
+}
+
+func sampledFlag(sampled bool, yes, no string) string {
+	if sampled {
+		return yes
+	}
+	return no
+}
+
+// isID reports whether the value is the lowercase hex ID of the length, not all zeros
+func isID(v string, n int) bool {
+	return isHex(v, n) && strings.Trim(v, "0") != ""
+}
+
+func isHex(v string, n int) bool {
+	if len(v) != n {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if !(v[i] >= '0' && v[i] <= '9' || v[i] >= 'a' && v[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newID returns the random non zero ID of n bytes in hex
+func newID(n int) string {
+	b := make([]byte, n)
+	for {
+		rand.Read(b)
+		if id := hex.EncodeToString(b); strings.Trim(id, "0") != "" {
+			return id
+		}
+	}
+}
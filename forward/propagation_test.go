@@ -0,0 +1,129 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type PropagationSuite struct{}
+
+var _ = Suite(&PropagationSuite{})
+
+const (
+	traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentID = "00f067aa0ba902b7"
+)
+
+func rewrite(rw *TraceRewriter, headers map[string]string) (*http.Request, *utils.Bag) {
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	bag := utils.NewBag()
+	req = utils.WithBag(req, bag)
+	rw.Rewrite(req)
+	return req, bag
+}
+
+func (s *PropagationSuite) TestContinueW3C(c *C) {
+	req, bag := rewrite(&TraceRewriter{}, map[string]string{
+		Traceparent: "00-" + traceID + "-" + parentID + "-01",
+		Tracestate:  "vendor=value",
+	})
+	parts := strings.Split(req.Header.Get(Traceparent), "-")
+	c.Assert(parts[0], Equals, "00")
+	c.Assert(parts[1], Equals, traceID)
+	c.Assert(parts[2], Not(Equals), parentID)
+	c.Assert(isID(parts[2], 16), Equals, true)
+	c.Assert(parts[3], Equals, "01")
+	c.Assert(req.Header.Get(Tracestate), Equals, "vendor=value")
+
+	// the same span is sent in B3
+	c.Assert(req.Header.Get(XB3TraceID), Equals, traceID)
+	c.Assert(req.Header.Get(XB3SpanID), Equals, parts[2])
+	c.Assert(req.Header.Get(XB3ParentSpanID), Equals, parentID)
+	c.Assert(req.Header.Get(XB3Sampled), Equals, "1")
+
+	id, _ := bag.Get(utils.BagTraceID)
+	c.Assert(id, Equals, traceID)
+	span, _ := bag.Get(utils.BagSpanID)
+	c.Assert(span, Equals, parts[2])
+}
+
+func (s *PropagationSuite) TestContinueB3(c *C) {
+	req, _ := rewrite(&TraceRewriter{Formats: TraceW3C | TraceB3}, map[string]string{
+		XB3TraceID: "A3CE929D0E0E4736",
+		XB3SpanID:  parentID,
+		XB3Sampled: "0",
+	})
+	// the 64 bit trace ID is padded for W3C and sent as is in B3
+	c.Assert(strings.Split(req.Header.Get(Traceparent), "-")[1], Equals, zeroTraceIDHalf+"a3ce929d0e0e4736")
+	c.Assert(strings.HasSuffix(req.Header.Get(Traceparent), "-00"), Equals, true)
+	c.Assert(req.Header.Get(XB3TraceID), Equals, "a3ce929d0e0e4736")
+	c.Assert(req.Header.Get(XB3ParentSpanID), Equals, parentID)
+	c.Assert(req.Header.Get(XB3Sampled), Equals, "0")
+
+	req, _ = rewrite(&TraceRewriter{Formats: TraceB3Single}, map[string]string{
+		B3:          traceID + "-" + parentID + "-1",
+		Traceparent: "00-" + strings.Repeat("1", 32) + "-" + parentID + "-01",
+	})
+	parts := strings.Split(req.Header.Get(B3), "-")
+	c.Assert(parts[0], Equals, traceID)
+	c.Assert(parts[2], Equals, "1")
+	c.Assert(parts[3], Equals, parentID)
+	// disabled formats are left untouched
+	c.Assert(req.Header.Get(Traceparent), Equals, "00-"+strings.Repeat("1", 32)+"-"+parentID+"-01")
+	c.Assert(req.Header.Get(XB3TraceID), Equals, "")
+}
+
+func (s *PropagationSuite) TestStart(c *C) {
+	req, bag := rewrite(&TraceRewriter{Sampled: true}, map[string]string{
+		Traceparent: "00-" + strings.Repeat("0", 32) + "-" + parentID + "-01",
+		Tracestate:  "vendor=value",
+	})
+	parts := strings.Split(req.Header.Get(Traceparent), "-")
+	c.Assert(isID(parts[1], 32), Equals, true)
+	c.Assert(parts[3], Equals, "01")
+	// the state of the invalid parent is dropped
+	c.Assert(req.Header.Get(Tracestate), Equals, "")
+	c.Assert(req.Header.Get(XB3ParentSpanID), Equals, "")
+	id, _ := bag.Get(utils.BagTraceID)
+	c.Assert(id, Equals, parts[1])
+
+	// the sampling decision alone is honored
+	req, _ = rewrite(&TraceRewriter{Sampled: true, Formats: TraceW3C | TraceB3Single}, map[string]string{B3: "0"})
+	c.Assert(strings.HasSuffix(req.Header.Get(Traceparent), "-00"), Equals, true)
+	c.Assert(strings.HasSuffix(req.Header.Get(B3), "-0"), Equals, true)
+}
+
+func (s *PropagationSuite) TestParseTraceparent(c *C) {
+	valid := "00-" + traceID + "-" + parentID + "-01"
+	_, ok := parseTraceparent(valid)
+	c.Assert(ok, Equals, true)
+	// the future versions may have more fields
+	_, ok = parseTraceparent("01" + valid[2:] + "-extra")
+	c.Assert(ok, Equals, true)
+
+	for _, v := range []string{
+		"",
+		valid + "-extra",
+		"ff" + valid[2:],
+		strings.ToUpper(valid),
+		"00-" + traceID + "-" + strings.Repeat("0", 16) + "-01",
+		"00-" + traceID + "-" + parentID + "-x1",
+	} {
+		_, ok := parseTraceparent(v)
+		c.Assert(ok, Equals, false, Commentf("%v", v))
+	}
+}
+
+func (s *PropagationSuite) TestNext(c *C) {
+	req, _ := rewrite(&TraceRewriter{Next: &HeaderRewriter{Hostname: "proxy"}}, nil)
+	c.Assert(req.Header.Get(XForwardedServer), Equals, "proxy")
+	c.Assert(req.Header.Get(Traceparent), Not(Equals), "")
+}
@@ -0,0 +1,199 @@
+package forward
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ProxySuite struct{}
+
+var _ = Suite(&ProxySuite{})
+
+func (s *ProxySuite) TestHTTPProxy(c *C) {
+	var proxyAuth, proxiedPath string
+	proxySrv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		proxyAuth, proxiedPath = req.Header.Get("Proxy-Authorization"), req.URL.Path
+		w.Write([]byte("via proxy"))
+	})
+	defer proxySrv.Close()
+
+	backend := testutils.NewResponder("direct")
+	defer backend.Close()
+
+	proxyURL := testutils.ParseURI(proxySrv.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+	f, err := New(Proxy(func(req *http.Request) (*url.URL, error) {
+		if req.Header.Get("X-Use-Proxy") == "" {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Use-Proxy") != "" {
+			req.URL = testutils.ParseURI("http://backend.local/path")
+		} else {
+			req.URL = testutils.ParseURI(backend.URL)
+		}
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	_, body, err := testutils.Get(proxy.URL, testutils.Header("X-Use-Proxy", "yes"))
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "via proxy")
+	c.Assert(proxiedPath, Equals, "/path")
+	c.Assert(proxyAuth, Equals, (&utils.BasicAuth{Username: "user", Password: "secret"}).String())
+
+	_, body, err = testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "direct")
+}
+
+func (s *ProxySuite) TestSOCKS5Proxy(c *C) {
+	socks, err := newSOCKS5Server("user", "secret")
+	c.Assert(err, IsNil)
+	defer socks.Close()
+
+	backend := testutils.NewResponder("hello")
+	defer backend.Close()
+
+	f, err := New(ProxyURL(testutils.ParseURI("socks5://user:secret@" + socks.Addr().String())))
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(<-socks.connects, Equals, testutils.ParseURI(backend.URL).Host)
+
+	// wrong credentials are rejected by the proxy
+	f, err = New(ProxyURL(testutils.ParseURI("socks5://user:wrong@" + socks.Addr().String())))
+	c.Assert(err, IsNil)
+	re, _, err = testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+}
+
+func (s *ProxySuite) TestBadProxyScheme(c *C) {
+	_, err := New(ProxyURL(testutils.ParseURI("ftp://proxy.local")))
+	c.Assert(err, NotNil)
+}
+
+func (s *ProxySuite) TestTransportNotModified(c *C) {
+	t := &http.Transport{}
+	_, err := New(RoundTripper(t), ProxyURL(testutils.ParseURI("http://proxy.local:3128")))
+	c.Assert(err, IsNil)
+	c.Assert(t.Proxy, IsNil)
+
+	_, err = New(RoundTripper(http.DefaultTransport), ProxyURL(testutils.ParseURI("http://proxy.local:3128")))
+	c.Assert(err, IsNil)
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	u, err := http.DefaultTransport.(*http.Transport).Proxy(req)
+	c.Assert(err, IsNil)
+	c.Assert(u == nil || u.Host != "proxy.local:3128", Equals, true)
+}
+
+// socks5Server is a minimal SOCKS5 proxy with username/password authentication, see RFC 1928 and RFC 1929
+type socks5Server struct {
+	net.Listener
+	username, password string
+	connects           chan string
+}
+
+func newSOCKS5Server(username, password string) (*socks5Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &socks5Server{Listener: l, username: username, password: password, connects: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 256)
+
+	// greeting: version, methods
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	// username/password authentication
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	pass := make([]byte, buf[0])
+	io.ReadFull(conn, pass)
+	if string(user) != s.username || string(pass) != s.password {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	// connect request: version, command, reserved, address type, address, port
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	case 4:
+		io.ReadFull(conn, buf[:16])
+		host = net.IP(buf[:16]).String()
+	}
+	io.ReadFull(conn, buf[:2])
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+	s.connects <- addr
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
@@ -0,0 +1,181 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+const (
+	// DefaultDNSTTL is the default time the resolved addresses are cached for
+	DefaultDNSTTL = 30 * time.Second
+	// DefaultNegativeTTL is the default time the failed lookups are cached for
+	DefaultNegativeTTL = 5 * time.Second
+)
+
+// LookupHostFunc resolves the host to the list of addresses, see net.Resolver.LookupHost
+type LookupHostFunc func(ctx context.Context, host string) ([]string, error)
+
+// CachingResolver caches DNS lookups of the backend hosts. Failed lookups are cached for the negative TTL,
+// when refreshing an expired entry fails the previously resolved addresses keep being used, so resolver
+// hiccups don't fail the requests.
+//
+// When the host resolves to both IPv6 and IPv4 addresses the connections are raced, see FallbackDelay.
+type CachingResolver struct {
+	ttl           time.Duration
+	negativeTTL   time.Duration
+	fallbackDelay time.Duration
+	lookup        LookupHostFunc
+	clock         timetools.TimeProvider
+
+	mtx     sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// ResolverOption is a functional option setter for CachingResolver
+type ResolverOption func(r *CachingResolver) error
+
+// DNSTTL sets the time the resolved addresses are cached for
+func DNSTTL(d time.Duration) ResolverOption {
+	return func(r *CachingResolver) error {
+		if d <= 0 {
+			return fmt.Errorf("ttl should be > 0, got %v", d)
+		}
+		r.ttl = d
+		return nil
+	}
+}
+
+// NegativeTTL sets the time the failed lookups are cached for, 0 disables negative caching
+func NegativeTTL(d time.Duration) ResolverOption {
+	return func(r *CachingResolver) error {
+		if d < 0 {
+			return fmt.Errorf("ttl should be >= 0, got %v", d)
+		}
+		r.negativeTTL = d
+		return nil
+	}
+}
+
+// FallbackDelay sets the delay before racing the connection to the next address when the current attempt
+// is taking long, e.g. because of the broken IPv6 path. Negative delay disables racing.
+func FallbackDelay(d time.Duration) ResolverOption {
+	return func(r *CachingResolver) error {
+		r.fallbackDelay = d
+		return nil
+	}
+}
+
+// LookupHost sets the function resolving the hosts, net.DefaultResolver is used by default
+func LookupHost(fn LookupHostFunc) ResolverOption {
+	return func(r *CachingResolver) error {
+		r.lookup = fn
+		return nil
+	}
+}
+
+// ResolverClock sets the time provider, intended for tests
+func ResolverClock(clock timetools.TimeProvider) ResolverOption {
+	return func(r *CachingResolver) error {
+		r.clock = clock
+		return nil
+	}
+}
+
+// NewCachingResolver returns a new resolver
+func NewCachingResolver(options ...ResolverOption) (*CachingResolver, error) {
+	r := &CachingResolver{
+		ttl:           DefaultDNSTTL,
+		negativeTTL:   DefaultNegativeTTL,
+		fallbackDelay: DefaultFallbackDelay,
+		entries:       make(map[string]*dnsEntry),
+	}
+	for _, o := range options {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.lookup == nil {
+		r.lookup = net.DefaultResolver.LookupHost
+	}
+	if r.clock == nil {
+		r.clock = &timetools.RealTime{}
+	}
+	return r, nil
+}
+
+// LookupHost returns the cached addresses of the host resolving it if the cache entry has expired
+func (r *CachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	now := r.clock.UtcNow()
+
+	r.mtx.Lock()
+	e, ok := r.entries[host]
+	r.mtx.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.addrs, e.err
+	}
+
+	addrs, err := r.lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses found for %v", host)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	switch {
+	case err == nil:
+		r.entries[host] = &dnsEntry{addrs: addrs, expires: now.Add(r.ttl)}
+	case ok && e.err == nil:
+		// keep using the stale addresses while the resolver is failing
+		r.entries[host] = &dnsEntry{addrs: e.addrs, expires: now.Add(r.negativeTTL)}
+		return e.addrs, nil
+	case r.negativeTTL > 0:
+		r.entries[host] = &dnsEntry{err: err, expires: now.Add(r.negativeTTL)}
+	default:
+		delete(r.entries, host)
+	}
+	return addrs, err
+}
+
+// Refresh drops the cached addresses of the host, so the next lookup hits DNS
+func (r *CachingResolver) Refresh(host string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.entries, host)
+}
+
+// Flush drops all cached addresses
+func (r *CachingResolver) Flush() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.entries = make(map[string]*dnsEntry)
+}
+
+// DialContext returns the dial function for http.Transport that resolves the hosts using the cache
+// and races the connections to the resolved addresses
+func (r *CachingResolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return dialHappyEyeballs(ctx, dialer.DialContext, network, addrs, port, r.fallbackDelay)
+	}
+}
@@ -0,0 +1,136 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type ResolverSuite struct {
+	clock   *timetools.FreezedTime
+	lookups int
+	addrs   []string
+	err     error
+}
+
+var _ = Suite(&ResolverSuite{})
+
+func (s *ResolverSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	s.lookups, s.addrs, s.err = 0, []string{"127.0.0.1"}, nil
+}
+
+func (s *ResolverSuite) newResolver(c *C) *CachingResolver {
+	r, err := NewCachingResolver(DNSTTL(time.Minute), NegativeTTL(time.Second), ResolverClock(s.clock),
+		LookupHost(func(ctx context.Context, host string) ([]string, error) {
+			s.lookups++
+			return s.addrs, s.err
+		}))
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *ResolverSuite) TestCache(c *C) {
+	r := s.newResolver(c)
+
+	addrs, err := r.LookupHost(context.Background(), "backend.local")
+	c.Assert(err, IsNil)
+	c.Assert(addrs, DeepEquals, []string{"127.0.0.1"})
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(30 * time.Second)
+	r.LookupHost(context.Background(), "backend.local")
+	c.Assert(s.lookups, Equals, 1)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Minute)
+	s.addrs = []string{"127.0.0.2"}
+	addrs, err = r.LookupHost(context.Background(), "backend.local")
+	c.Assert(err, IsNil)
+	c.Assert(addrs, DeepEquals, []string{"127.0.0.2"})
+	c.Assert(s.lookups, Equals, 2)
+
+	// IP addresses are not resolved
+	addrs, err = r.LookupHost(context.Background(), "10.0.0.1")
+	c.Assert(err, IsNil)
+	c.Assert(addrs, DeepEquals, []string{"10.0.0.1"})
+	c.Assert(s.lookups, Equals, 2)
+}
+
+func (s *ResolverSuite) TestRefresh(c *C) {
+	r := s.newResolver(c)
+
+	r.LookupHost(context.Background(), "backend.local")
+	r.Refresh("backend.local")
+	r.LookupHost(context.Background(), "backend.local")
+	c.Assert(s.lookups, Equals, 2)
+
+	r.Flush()
+	r.LookupHost(context.Background(), "backend.local")
+	c.Assert(s.lookups, Equals, 3)
+}
+
+func (s *ResolverSuite) TestNegativeCache(c *C) {
+	r := s.newResolver(c)
+	s.err = fmt.Errorf("no such host")
+
+	_, err := r.LookupHost(context.Background(), "missing.local")
+	c.Assert(err, NotNil)
+	_, err = r.LookupHost(context.Background(), "missing.local")
+	c.Assert(err, NotNil)
+	c.Assert(s.lookups, Equals, 1)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * time.Second)
+	s.err = nil
+	_, err = r.LookupHost(context.Background(), "missing.local")
+	c.Assert(err, IsNil)
+	c.Assert(s.lookups, Equals, 2)
+}
+
+func (s *ResolverSuite) TestStaleOnFailure(c *C) {
+	r := s.newResolver(c)
+	r.LookupHost(context.Background(), "backend.local")
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * time.Minute)
+	s.err = fmt.Errorf("resolver timeout")
+	addrs, err := r.LookupHost(context.Background(), "backend.local")
+	c.Assert(err, IsNil)
+	c.Assert(addrs, DeepEquals, []string{"127.0.0.1"})
+}
+
+func (s *ResolverSuite) TestForwarder(c *C) {
+	srv := testutils.NewResponder("hello")
+	defer srv.Close()
+
+	f, err := New(Resolver(s.newResolver(c)))
+	c.Assert(err, IsNil)
+
+	u := testutils.ParseURI(srv.URL)
+	u.Host = "backend.local:" + u.Port()
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = u
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(s.lookups, Equals, 1)
+
+	_, err = New(Resolver(s.newResolver(c)), RoundTripper(&failingTransport{}))
+	c.Assert(err, NotNil)
+}
+
+type failingTransport struct{}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
@@ -0,0 +1,147 @@
+package forward
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RespRewriter modifies the response of the backend before it is written to the client
+type RespRewriter interface {
+	Rewrite(resp *http.Response) error
+}
+
+// RespRewriterFunc is an adapter to use ordinary functions as RespRewriter
+type RespRewriterFunc func(resp *http.Response) error
+
+func (f RespRewriterFunc) Rewrite(resp *http.Response) error {
+	return f(resp)
+}
+
+// ResponseRewriter sets the rewriter of the backend responses, e.g. to modify the headers or to transform the body.
+// The error of the rewriter is passed to the error handler.
+func ResponseRewriter(r RespRewriter) optSetter {
+	return func(f *Forwarder) error {
+		f.respRewriter = r
+		return nil
+	}
+}
+
+// Coding decodes and encodes the bodies of the content coding, Encode is optional
+type Coding struct {
+	Decode func(r io.Reader) (io.ReadCloser, error)
+	Encode func(w io.Writer) (io.WriteCloser, error)
+}
+
+// DecodeResponses makes the forwarder decode the bodies of the encoded responses before they are passed to
+// the response rewriter, so the rewriter sees the plain text. With reencode the body is encoded back after
+// the rewrite, otherwise Content-Encoding is removed and the client gets the plain body. gzip and deflate
+// are supported out of the box, other codings, e.g. br, can be added with ContentCoding. The responses
+// with unsupported or multiple codings are passed to the rewriter as they are.
+func DecodeResponses(reencode bool) optSetter {
+	return func(f *Forwarder) error {
+		f.decodeResponses, f.reencodeResponses = true, reencode
+		return nil
+	}
+}
+
+// ContentCoding adds or replaces the coding used by DecodeResponses
+func ContentCoding(name string, c Coding) optSetter {
+	return func(f *Forwarder) error {
+		if c.Decode == nil {
+			return fmt.Errorf("coding %v has no decoder", name)
+		}
+		if f.codings == nil {
+			f.codings = defaultCodings()
+		}
+		f.codings[strings.ToLower(name)] = c
+		return nil
+	}
+}
+
+func defaultCodings() map[string]Coding {
+	return map[string]Coding{
+		"gzip": {
+			Decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+			Encode: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		},
+		"deflate": {
+			Decode: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+			Encode: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) },
+		},
+	}
+}
+
+// rewriteResponse decodes the body if configured, calls the rewriter and encodes the body back
+func (f *Forwarder) rewriteResponse(resp *http.Response) error {
+	if !f.decodeResponses {
+		return f.respRewriter.Rewrite(resp)
+	}
+	name := strings.ToLower(strings.TrimSpace(resp.Header.Get(ContentEncoding)))
+	coding, ok := f.codings[name]
+	if name == "" || !ok || len(resp.Header[ContentEncoding]) != 1 {
+		return f.respRewriter.Rewrite(resp)
+	}
+
+	decoded, err := coding.Decode(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decode %v response: %v", name, err)
+	}
+	encoded := resp.Body
+	resp.Body = &readCloser{Reader: decoded, close: func() error {
+		decoded.Close()
+		return encoded.Close()
+	}}
+	resp.Header.Del(ContentEncoding)
+	resp.Header.Del(ContentLength)
+	resp.ContentLength = -1
+
+	if err := f.respRewriter.Rewrite(resp); err != nil {
+		return err
+	}
+	// the rewriter has set the coding on its own
+	if !f.reencodeResponses || coding.Encode == nil || resp.Header.Get(ContentEncoding) != "" {
+		return nil
+	}
+	resp.Body = encodeBody(resp.Body, encoded, coding)
+	resp.Header.Set(ContentEncoding, name)
+	resp.Header.Del(ContentLength)
+	resp.ContentLength = -1
+	return nil
+}
+
+// encodeBody encodes the body on the fly, closing the returned body stops the encoding. The body is closed
+// by the encoding goroutine, the closing only closes the raw backend body, which is safe to do concurrently
+// with the reads and unblocks them.
+func encodeBody(body, raw io.ReadCloser, coding Coding) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		enc, err := coding.Encode(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := copyBuffer(enc, body); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(enc.Close())
+	}()
+	return &readCloser{Reader: pr, close: func() error {
+		pr.Close()
+		return raw.Close()
+	}}
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
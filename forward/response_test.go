@@ -0,0 +1,149 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ResponseSuite struct{}
+
+var _ = Suite(&ResponseSuite{})
+
+func gzipped(s string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func gunzip(c *C, data []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	return string(out)
+}
+
+// encodedBackend responds with the gzipped body labeled with the coding
+func encodedBackend(coding string) *httptest.Server {
+	body := gzipped("hello")
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(ContentEncoding, coding)
+		w.Header().Set(ContentLength, fmt.Sprint(len(body)))
+		w.Write(body)
+	})
+}
+
+// upper is the rewriter that upper cases the body
+var upper = RespRewriterFunc(func(resp *http.Response) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(bytes.ToUpper(data)))
+	resp.Header.Del(ContentLength)
+	return nil
+})
+
+// get requests the encoded response explicitly, so the client does not decode it
+func getEncoded(c *C, f *Forwarder, backend string) (*http.Response, []byte) {
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL, testutils.Header("Accept-Encoding", "gzip"))
+	c.Assert(err, IsNil)
+	return re, body
+}
+
+func (s *ResponseSuite) TestReencode(c *C) {
+	srv := encodedBackend("gzip")
+	defer srv.Close()
+
+	f, err := New(ResponseRewriter(upper), DecodeResponses(true))
+	c.Assert(err, IsNil)
+
+	re, body := getEncoded(c, f, srv.URL)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(re.Header.Get(ContentEncoding), Equals, "gzip")
+	c.Assert(gunzip(c, body), Equals, "HELLO")
+}
+
+func (s *ResponseSuite) TestStripEncoding(c *C) {
+	srv := encodedBackend("gzip")
+	defer srv.Close()
+
+	f, err := New(ResponseRewriter(upper), DecodeResponses(false))
+	c.Assert(err, IsNil)
+
+	re, body := getEncoded(c, f, srv.URL)
+	c.Assert(re.Header.Get(ContentEncoding), Equals, "")
+	c.Assert(string(body), Equals, "HELLO")
+}
+
+func (s *ResponseSuite) TestNoDecoding(c *C) {
+	srv := encodedBackend("gzip")
+	defer srv.Close()
+
+	seen := ""
+	f, err := New(ResponseRewriter(RespRewriterFunc(func(resp *http.Response) error {
+		seen = resp.Header.Get(ContentEncoding)
+		return nil
+	})))
+	c.Assert(err, IsNil)
+
+	re, body := getEncoded(c, f, srv.URL)
+	c.Assert(seen, Equals, "gzip")
+	c.Assert(re.Header.Get(ContentEncoding), Equals, "gzip")
+	c.Assert(gunzip(c, body), Equals, "hello")
+}
+
+func (s *ResponseSuite) TestCustomCoding(c *C) {
+	srv := encodedBackend("br")
+	defer srv.Close()
+
+	// br is not supported out of the box, the body is passed as it is
+	f, err := New(ResponseRewriter(upper), DecodeResponses(false))
+	c.Assert(err, IsNil)
+	re, body := getEncoded(c, f, srv.URL)
+	c.Assert(re.Header.Get(ContentEncoding), Equals, "br")
+	c.Assert(body, DeepEquals, bytes.ToUpper(gzipped("hello")))
+
+	// the fake br coding reusing gzip
+	f, err = New(ResponseRewriter(upper), DecodeResponses(false), ContentCoding("br", Coding{
+		Decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	}))
+	c.Assert(err, IsNil)
+	re, body = getEncoded(c, f, srv.URL)
+	c.Assert(re.Header.Get(ContentEncoding), Equals, "")
+	c.Assert(string(body), Equals, "HELLO")
+
+	_, err = New(ContentCoding("br", Coding{}))
+	c.Assert(err, NotNil)
+}
+
+func (s *ResponseSuite) TestRewriterError(c *C) {
+	srv := testutils.NewResponder("hello")
+	defer srv.Close()
+
+	f, err := New(ResponseRewriter(RespRewriterFunc(func(resp *http.Response) error {
+		return fmt.Errorf("oops")
+	})))
+	c.Assert(err, IsNil)
+
+	re, _ := getEncoded(c, f, srv.URL)
+	c.Assert(re.StatusCode, Equals, http.StatusInternalServerError)
+}
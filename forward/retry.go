@@ -0,0 +1,131 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// ExcludingPicker is implemented by the pickers able to skip the backends already tried, e.g. roundrobin.RoundRobin,
+// the other pickers are asked for the next server a few times until it's not one of them
+type ExcludingPicker interface {
+	NextServerExcluding(excluded ...*url.URL) (*url.URL, error)
+}
+
+// StatusRetry retries the idempotent requests without body answered with one of the codes, e.g. 502 or 503,
+// on another backend picked by the picker, once by default. The response of the last attempt is returned
+// if there are no more attempts left or no other backend to retry on.
+func StatusRetry(picker ServerPicker, codes ...int) optSetter {
+	return func(f *Forwarder) error {
+		if picker == nil {
+			return fmt.Errorf("retry server picker can not be nil")
+		}
+		if len(codes) == 0 {
+			return fmt.Errorf("retry status codes can not be empty")
+		}
+		f.retryPicker = picker
+		f.retryCodes = make(map[int]bool, len(codes))
+		for _, c := range codes {
+			f.retryCodes[c] = true
+		}
+		if f.retryAttempts == 0 {
+			f.retryAttempts = 1
+		}
+		return nil
+	}
+}
+
+// RetryAttempts sets the maximum of the retries of StatusRetry
+func RetryAttempts(n int) optSetter {
+	return func(f *Forwarder) error {
+		if n <= 0 {
+			return fmt.Errorf("retry attempts should be > 0, got %d", n)
+		}
+		f.retryAttempts = n
+		return nil
+	}
+}
+
+// RetryWithin stops the retries of StatusRetry once the request has been forwarded for d,
+// so the retries don't make the slow responses even slower
+func RetryWithin(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		if d <= 0 {
+			return fmt.Errorf("retry latency bound should be > 0, got %v", d)
+		}
+		f.retryWithin = d
+		return nil
+	}
+}
+
+// retriedRoundTrip sends the request to another backend while the response has the retried status code
+func (f *Forwarder) retriedRoundTrip(req, outReq *http.Request) (*http.Response, error) {
+	start := f.clock.UtcNow()
+	response, err := f.roundTrip(req, outReq)
+	if f.retryPicker == nil || !hedgeable(req) {
+		return response, err
+	}
+	tried := []*url.URL{req.URL}
+	for attempt := 0; attempt < f.retryAttempts; attempt++ {
+		if err != nil || !f.retryCodes[response.StatusCode] || req.Context().Err() != nil {
+			return response, err
+		}
+		if f.retryWithin > 0 && f.clock.UtcNow().Sub(start) >= f.retryWithin {
+			f.log.Infof("not retrying %v after %v", req.URL, f.clock.UtcNow().Sub(start))
+			return response, err
+		}
+		u := f.pickServer(f.retryPicker, tried)
+		if u == nil {
+			return response, err
+		}
+		f.log.Infof("retrying %v answered with %v on %v", tried[len(tried)-1], response.StatusCode, u)
+		discardResponse(response)
+		tried = append(tried, u)
+		response, err = f.roundTripper.RoundTrip(hedgeRequest(outReq, u))
+		utils.SetBagValue(req, utils.BagBackend, u)
+	}
+	return response, err
+}
+
+// pickServer picks the server with the host different from the tried ones
+func (f *Forwarder) pickServer(picker ServerPicker, tried []*url.URL) *url.URL {
+	if p, ok := picker.(ExcludingPicker); ok {
+		u, err := p.NextServerExcluding(tried...)
+		if err != nil {
+			f.log.Warningf("failed to pick server: %v", err)
+			return nil
+		}
+		return u
+	}
+	for i := 0; i < hedgePicks; i++ {
+		u, err := picker.NextServer()
+		if err != nil {
+			f.log.Warningf("failed to pick server: %v", err)
+			return nil
+		}
+		if !triedHost(u, tried) {
+			return u
+		}
+	}
+	return nil
+}
+
+func triedHost(u *url.URL, tried []*url.URL) bool {
+	for _, t := range tried {
+		if t.Host == u.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// discardResponse drains the small bodies of the responses being retried, so the connections can be reused
+func discardResponse(re *http.Response) {
+	io.CopyN(ioutil.Discard, re.Body, 4096)
+	re.Body.Close()
+}
@@ -0,0 +1,159 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type RetrySuite struct{}
+
+var _ = Suite(&RetrySuite{})
+
+// listPicker picks the first server not excluded like the load balancers do
+type listPicker struct {
+	servers []*url.URL
+}
+
+func (p *listPicker) NextServer() (*url.URL, error) {
+	return p.servers[0], nil
+}
+
+func (p *listPicker) NextServerExcluding(excluded ...*url.URL) (*url.URL, error) {
+	for _, s := range p.servers {
+		if !triedHost(s, excluded) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no servers left")
+}
+
+func statusBackend(code int, body string) *httptest.Server {
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(code)
+		w.Write([]byte(body))
+	})
+}
+
+func (s *RetrySuite) TestRetryOnStatus(c *C) {
+	failing := statusBackend(http.StatusServiceUnavailable, "failing")
+	defer failing.Close()
+	ok := statusBackend(http.StatusOK, "ok")
+	defer ok.Close()
+
+	f, err := New(StatusRetry(&staticPicker{u: testutils.ParseURI(ok.URL)}, http.StatusBadGateway, http.StatusServiceUnavailable))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, failing.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "ok")
+
+	// the requests with body are not retried
+	re, body, err = testutils.MakeRequest(proxy.URL, testutils.Method("POST"), testutils.Body("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(string(body), Equals, "failing")
+}
+
+func (s *RetrySuite) TestOtherStatus(c *C) {
+	failing := statusBackend(http.StatusInternalServerError, "failing")
+	defer failing.Close()
+	ok := statusBackend(http.StatusOK, "ok")
+	defer ok.Close()
+
+	f, err := New(StatusRetry(&staticPicker{u: testutils.ParseURI(ok.URL)}, http.StatusServiceUnavailable))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, failing.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusInternalServerError)
+	c.Assert(string(body), Equals, "failing")
+}
+
+func (s *RetrySuite) TestAttempts(c *C) {
+	a := statusBackend(http.StatusServiceUnavailable, "a")
+	defer a.Close()
+	b := statusBackend(http.StatusBadGateway, "b")
+	defer b.Close()
+	ok := statusBackend(http.StatusOK, "ok")
+	defer ok.Close()
+	picker := &listPicker{servers: []*url.URL{testutils.ParseURI(a.URL), testutils.ParseURI(b.URL), testutils.ParseURI(ok.URL)}}
+
+	// the last response is returned once the attempts are used up
+	f, err := New(StatusRetry(picker, http.StatusBadGateway, http.StatusServiceUnavailable))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, a.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+	c.Assert(string(body), Equals, "b")
+
+	f, err = New(StatusRetry(picker, http.StatusBadGateway, http.StatusServiceUnavailable), RetryAttempts(2))
+	c.Assert(err, IsNil)
+	proxy = newHedgeProxy(f, a.URL)
+	defer proxy.Close()
+
+	re, body, err = testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "ok")
+}
+
+func (s *RetrySuite) TestNoOtherBackend(c *C) {
+	failing := statusBackend(http.StatusServiceUnavailable, "failing")
+	defer failing.Close()
+
+	f, err := New(StatusRetry(&staticPicker{u: testutils.ParseURI(failing.URL)}, http.StatusServiceUnavailable))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, failing.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(string(body), Equals, "failing")
+}
+
+func (s *RetrySuite) TestWithin(c *C) {
+	slow := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer slow.Close()
+	ok := statusBackend(http.StatusOK, "ok")
+	defer ok.Close()
+
+	f, err := New(StatusRetry(&staticPicker{u: testutils.ParseURI(ok.URL)}, http.StatusServiceUnavailable), RetryWithin(10*time.Millisecond))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, slow.URL)
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *RetrySuite) TestBadOptions(c *C) {
+	for _, o := range []optSetter{
+		StatusRetry(nil, http.StatusServiceUnavailable),
+		StatusRetry(&staticPicker{}),
+		RetryAttempts(0),
+		RetryWithin(0),
+	} {
+		_, err := New(o)
+		c.Assert(err, NotNil)
+	}
+}
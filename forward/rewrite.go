@@ -11,11 +11,14 @@ import (
 // Rewriter is responsible for removing hop-by-hop headers and setting forwarding headers
 type HeaderRewriter struct {
 	TrustForwardHeader bool
-	Hostname           string
+	// TrustForwardHost keeps X-Forwarded-Host of the client instead of overwriting it with the request host,
+	// set it only behind the proxy setting the header, as the host is trusted by the backends and the rewriters
+	TrustForwardHost bool
+	Hostname         string
 }
 
 func (rw *HeaderRewriter) Rewrite(req *http.Request) {
-	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+	if clientIP := clientAddr(req.RemoteAddr); clientIP != "" {
 		if rw.TrustForwardHeader {
 			if prior, ok := req.Header[XForwardedFor]; ok {
 				clientIP = strings.Join(prior, ", ") + ", " + clientIP
@@ -32,12 +35,50 @@ func (rw *HeaderRewriter) Rewrite(req *http.Request) {
 		req.Header.Set(XForwardedProto, "http")
 	}
 
-	if req.Host != "" {
+	if xfh := req.Header.Get(XForwardedHost); xfh != "" && rw.TrustForwardHost {
+		req.Header.Set(XForwardedHost, xfh)
+	} else if req.Host != "" {
 		req.Header.Set(XForwardedHost, req.Host)
 	}
+
+	if xfport := req.Header.Get(XForwardedPort); xfport != "" && rw.TrustForwardHeader {
+		req.Header.Set(XForwardedPort, xfport)
+	} else {
+		req.Header.Set(XForwardedPort, forwardedPort(req.Header.Get(XForwardedHost), req.Header.Get(XForwardedProto)))
+	}
 	req.Header.Set(XForwardedServer, rw.Hostname)
 
 	// Remove hop-by-hop headers to the backend.  Especially important is "Connection" because we want a persistent
 	// connection, regardless of what the client sent to us.
 	utils.RemoveHeaders(req.Header, HopHeaders...)
 }
+
+// clientAddr returns the client IP of the remote address without the port, brackets and IPv6 zone,
+// or an empty string if the address is not an IP
+func clientAddr(remoteAddr string) string {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.IndexByte(host, '%'); i != -1 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// forwardedPort returns the port the client has requested, the explicit port of the host
+// or the default port of the protocol
+func forwardedPort(host, proto string) string {
+	if _, port, err := net.SplitHostPort(host); err == nil && port != "" {
+		return port
+	}
+	if proto == "https" || proto == "wss" {
+		return "443"
+	}
+	return "80"
+}
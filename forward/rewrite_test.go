@@ -0,0 +1,93 @@
+package forward
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type RewriteSuite struct{}
+
+var _ = Suite(&RewriteSuite{})
+
+func (s *RewriteSuite) TestClientAddr(c *C) {
+	for _, t := range []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"10.0.0.1:5000", "10.0.0.1"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"[::1]:5000", "::1"},
+		{"[2001:DB8::1]:443", "2001:db8::1"},
+		{"[fe80::1%eth0]:5000", "fe80::1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"@", ""},
+		{"", ""},
+	} {
+		c.Assert(clientAddr(t.remoteAddr), Equals, t.expected, Commentf("%v", t.remoteAddr))
+	}
+}
+
+func (s *RewriteSuite) TestForwardedHeaders(c *C) {
+	req := httptest.NewRequest("GET", "http://[2001:db8::1]:8080/", nil)
+	req.RemoteAddr = "[2001:db8::2]:5000"
+	(&HeaderRewriter{Hostname: "proxy"}).Rewrite(req)
+
+	c.Assert(req.Header.Get(XForwardedFor), Equals, "2001:db8::2")
+	c.Assert(req.Header.Get(XForwardedHost), Equals, "[2001:db8::1]:8080")
+	c.Assert(req.Header.Get(XForwardedPort), Equals, "8080")
+	c.Assert(req.Header.Get(XForwardedProto), Equals, "http")
+
+	// the default port of the protocol
+	req = httptest.NewRequest("GET", "https://[2001:db8::1]/", nil)
+	req.TLS = &tls.ConnectionState{}
+	(&HeaderRewriter{}).Rewrite(req)
+	c.Assert(req.Header.Get(XForwardedPort), Equals, "443")
+
+	req = httptest.NewRequest("GET", "http://example.com/", nil)
+	(&HeaderRewriter{}).Rewrite(req)
+	c.Assert(req.Header.Get(XForwardedPort), Equals, "80")
+}
+
+func (s *RewriteSuite) TestBehindProxy(c *C) {
+	newReq := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://internal:8080/", nil)
+		req.RemoteAddr = "10.0.0.2:5000"
+		req.Header.Set(XForwardedFor, "2001:db8::2")
+		req.Header.Set(XForwardedProto, "https")
+		req.Header.Set(XForwardedHost, "example.com:8443")
+		req.Header.Set(XForwardedPort, "8443")
+		return req
+	}
+
+	req := newReq()
+	(&HeaderRewriter{TrustForwardHeader: true, TrustForwardHost: true}).Rewrite(req)
+	c.Assert(req.Header.Get(XForwardedFor), Equals, "2001:db8::2, 10.0.0.2")
+	c.Assert(req.Header.Get(XForwardedProto), Equals, "https")
+	c.Assert(req.Header.Get(XForwardedHost), Equals, "example.com:8443")
+	c.Assert(req.Header.Get(XForwardedPort), Equals, "8443")
+
+	// the host is trusted only explicitly
+	req = newReq()
+	(&HeaderRewriter{TrustForwardHeader: true}).Rewrite(req)
+	c.Assert(req.Header.Get(XForwardedFor), Equals, "2001:db8::2, 10.0.0.2")
+	c.Assert(req.Header.Get(XForwardedHost), Equals, "internal:8080")
+
+	// the proxy reporting the protocol only, the port defaults to the one of the protocol
+	req = newReq()
+	req.Header.Set(XForwardedHost, "example.com")
+	req.Header.Del(XForwardedPort)
+	(&HeaderRewriter{TrustForwardHeader: true, TrustForwardHost: true}).Rewrite(req)
+	c.Assert(req.Header.Get(XForwardedPort), Equals, "443")
+
+	// untrusted headers are overwritten
+	req = newReq()
+	(&HeaderRewriter{}).Rewrite(req)
+	c.Assert(req.Header.Get(XForwardedFor), Equals, "10.0.0.2")
+	c.Assert(req.Header.Get(XForwardedProto), Equals, "http")
+	c.Assert(req.Header.Get(XForwardedHost), Equals, "internal:8080")
+	c.Assert(req.Header.Get(XForwardedPort), Equals, "8080")
+}
@@ -0,0 +1,75 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// ValidateResponses makes the forwarder reject the backend responses that could be interpreted differently
+// by the proxy and the clients, e.g. to smuggle a second response: responses with both Content-Length
+// and Transfer-Encoding, multiple Content-Length values and header names or values with invalid characters.
+// The rejected responses are served with 502 status code. The standard transport already fails on some
+// of the malformed responses, the validation covers the rest and the responses of custom round trippers.
+func ValidateResponses() optSetter {
+	return func(f *Forwarder) error {
+		f.validateResponses = true
+		return nil
+	}
+}
+
+// InvalidResponseError reports the backend response rejected by ValidateResponses
+type InvalidResponseError struct {
+	msg string
+}
+
+func (e *InvalidResponseError) Error() string {
+	return e.msg
+}
+
+// Unwrap makes the error served with 502 status code by utils.DefaultHandler
+func (e *InvalidResponseError) Unwrap() error {
+	return utils.ErrInvalidResponse
+}
+
+func invalidResponse(format string, args ...interface{}) error {
+	return &InvalidResponseError{msg: fmt.Sprintf(format, args...)}
+}
+
+// validateResponse returns the error describing why the response is ambiguous
+func validateResponse(resp *http.Response) error {
+	lengths := resp.Header[ContentLength]
+	if len(lengths) > 1 {
+		return invalidResponse("response has %d Content-Length values", len(lengths))
+	}
+	if len(lengths) == 1 && strings.Contains(lengths[0], ",") {
+		return invalidResponse("response has multiple Content-Length values: %q", lengths[0])
+	}
+	chunked := len(resp.TransferEncoding) != 0 || len(resp.Header[TransferEncoding]) != 0
+	if chunked && len(lengths) != 0 {
+		return invalidResponse("response has both Content-Length and Transfer-Encoding")
+	}
+	for name, values := range resp.Header {
+		if !utils.ValidHeaderName(name) {
+			return invalidResponse("response has invalid header name %q", name)
+		}
+		for _, v := range values {
+			if !utils.ValidHeaderValue(v) {
+				return invalidResponse("response header %v has invalid value %q", name, v)
+			}
+		}
+	}
+	for name, values := range resp.Trailer {
+		if !utils.ValidHeaderName(name) {
+			return invalidResponse("response has invalid trailer name %q", name)
+		}
+		for _, v := range values {
+			if !utils.ValidHeaderValue(v) {
+				return invalidResponse("response trailer %v has invalid value %q", name, v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type SanitizeSuite struct{}
+
+var _ = Suite(&SanitizeSuite{})
+
+func (s *SanitizeSuite) TestValidateResponse(c *C) {
+	for _, t := range []struct {
+		header   http.Header
+		te       []string
+		expected string
+	}{
+		{header: http.Header{"Content-Length": {"5"}}},
+		{header: http.Header{"Content-Length": {"5", "5"}}, expected: ".*2 Content-Length values"},
+		{header: http.Header{"Content-Length": {"5, 6"}}, expected: ".*multiple Content-Length.*"},
+		{header: http.Header{"Content-Length": {"5"}}, te: []string{"chunked"}, expected: ".*both Content-Length and Transfer-Encoding"},
+		{header: http.Header{"Content-Length": {"5"}, "Transfer-Encoding": {"chunked"}}, expected: ".*both Content-Length and Transfer-Encoding"},
+		{header: http.Header{"X Bad": {"1"}}, expected: ".*invalid header name.*"},
+		{header: http.Header{"X-Bad": {"1\r\nSet-Cookie: a=b"}}, expected: ".*invalid value.*"},
+	} {
+		err := validateResponse(&http.Response{Header: t.header, TransferEncoding: t.te})
+		if t.expected == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, t.expected)
+		}
+	}
+}
+
+func (s *SanitizeSuite) TestRejects(c *C) {
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		h := http.Header{"Content-Length": {"5", "50"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: h, Body: http.NoBody}, nil
+	})
+	f, err := New(RoundTripper(rt), ValidateResponses())
+	c.Assert(err, IsNil)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+
+	// the validation is opt-in
+	f, err = New(RoundTripper(rt))
+	c.Assert(err, IsNil)
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+	c.Assert(w.Code, Equals, http.StatusOK)
+}
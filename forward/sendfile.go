@@ -0,0 +1,57 @@
+package forward
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// fileBody tells whether the response body is the file, e.g. the local fallback content served by the custom
+// round tripper. The file is passed to the io.ReaderFrom of the client connection as is, so the net/http server
+// sends it with sendfile without copying it through the user space. The bodies wrapped by CaptureTraffic,
+// BodyTimeouts, DecodeResponses or TeeResponses are copied as usual.
+func fileBody(body io.Reader) bool {
+	_, ok := body.(*os.File)
+	return ok
+}
+
+// setFileLength sets the content length of the file body, the server sends the chunked bodies without sendfile
+func setFileLength(h http.Header, re *http.Response) {
+	if fileBody(re.Body) && re.ContentLength >= 0 && h.Get(ContentLength) == "" {
+		h.Set(ContentLength, strconv.FormatInt(re.ContentLength, 10))
+	}
+}
+
+// readFrom copies the body with the io.ReaderFrom of the writer if it has one
+func readFrom(w io.Writer, r io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return copyBuffer(writerOnly{w}, r)
+}
+
+// writerOnly hides the io.ReaderFrom of the writer, so the copy falls back to the writes
+type writerOnly struct {
+	io.Writer
+}
+
+// ReadFrom sends the file bodies with the io.ReaderFrom of the wrapped writer, the time spent is counted
+// as the time of the writes. The other bodies are copied by the writes, so the time of reading them is not.
+func (t *timedWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !fileBody(r) {
+		return copyBuffer(writerOnly{t}, r)
+	}
+	start := t.clock.UtcNow()
+	n, err := readFrom(t.ResponseWriter, r)
+	t.spent += t.clock.UtcNow().Sub(start)
+	return n, err
+}
+
+// ReadFrom discards the body replaced by the error page, the others are copied by the wrapped writer
+func (w *errorPageWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.replace {
+		return io.Copy(io.Discard, r)
+	}
+	return readFrom(w.ResponseWriter, r)
+}
@@ -0,0 +1,94 @@
+package forward
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type SendfileSuite struct{}
+
+var _ = Suite(&SendfileSuite{})
+
+// fileTransport serves the file as the response body
+type fileTransport struct {
+	path string
+}
+
+func (t *fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: f, ContentLength: st.Size(), Request: req}, nil
+}
+
+// readFromRecorder records the sources of ReadFrom
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	sources []io.Reader
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.sources = append(r.sources, src)
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func (s *SendfileSuite) file(c *C, body string) string {
+	path := filepath.Join(c.MkDir(), "body")
+	c.Assert(ioutil.WriteFile(path, []byte(body), 0600), IsNil)
+	return path
+}
+
+func (s *SendfileSuite) TestReadFrom(c *C) {
+	body := strings.Repeat("a", 64<<10)
+	f, err := New(RoundTripper(&fileTransport{path: s.file(c, body)}), ErrorPage("text/plain", "error", 500))
+	c.Assert(err, IsNil)
+
+	for _, timed := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/file", nil)
+		if timed {
+			req, _ = utils.WithTimings(req)
+		}
+		w := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		pw := &utils.ProxyWriter{W: w}
+		f.ServeHTTP(pw, req)
+
+		c.Assert(w.Code, Equals, http.StatusOK)
+		c.Assert(w.Body.String(), Equals, body)
+		c.Assert(w.Header().Get(ContentLength), Equals, "65536")
+		c.Assert(pw.Length(), Equals, int64(len(body)))
+		// the file reaches the writer of the connection through the wrappers
+		c.Assert(len(w.sources), Equals, 1)
+		_, isFile := w.sources[0].(*os.File)
+		c.Assert(isFile, Equals, true)
+	}
+}
+
+func (s *SendfileSuite) TestServe(c *C) {
+	body := strings.Repeat("b", 1<<20)
+	f, err := New(RoundTripper(&fileTransport{path: s.file(c, body)}))
+	c.Assert(err, IsNil)
+	proxy := httptest.NewServer(f)
+	defer proxy.Close()
+
+	re, got, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(re.ContentLength, Equals, int64(len(body)))
+	c.Assert(string(got), Equals, body)
+}
@@ -0,0 +1,218 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/mailgun/oxy/router"
+)
+
+// TeePolicy tells what the tee does when its sink falls behind the client
+type TeePolicy int
+
+const (
+	// TeeBlock streams the response to the client no faster than the sink takes the copy, the default
+	TeeBlock TeePolicy = iota
+	// TeeDrop queues the copy for the sink and abandons it once the queue is full, the client is never slowed down
+	TeeDrop
+)
+
+// ErrTeeDropped is reported to the sink when the copy is abandoned by TeeDrop
+var ErrTeeDropped = errors.New("response copy dropped, the sink is too slow")
+
+const defaultTeeBuffer = 1 << 20
+
+// TeeSink stores the copies of the response bodies, e.g. in the compliance archive. The writers implementing
+// CloseWithError(error) error, like io.PipeWriter, are closed with the error if the copy is incomplete:
+// ErrTeeDropped, io.ErrUnexpectedEOF if the body is not read to the end, or the error of the writer.
+type TeeSink interface {
+	// Open returns the writer of the copy of the response, nil skips the response
+	Open(req *http.Request, re *http.Response) (io.WriteCloser, error)
+}
+
+// Tee sets the responses copied to the sink
+type Tee struct {
+	Sink   TeeSink
+	Policy TeePolicy
+	// Buffer caps the bytes of the copy queued for the sink with TeeDrop, 1MB by default
+	Buffer int
+	// Matchers select the requests teed, all of them if empty
+	Matchers []router.Matcher
+}
+
+// TeeResponses copies the bodies of the selected responses to the sink while streaming them to the client.
+// The copy is the body sent to the client, i.e. after the response rewriting and decoding. The failures
+// of the sink are logged and never fail the response. The option can be repeated for multiple sinks.
+//
+//	forward.New(forward.TeeResponses(forward.Tee{Sink: archive, Policy: forward.TeeDrop, Matchers: []router.Matcher{router.PathPrefix("/payments")}}))
+func TeeResponses(t Tee) optSetter {
+	return func(f *Forwarder) error {
+		if t.Sink == nil {
+			return fmt.Errorf("tee sink can not be nil")
+		}
+		if t.Policy < TeeBlock || t.Policy > TeeDrop {
+			return fmt.Errorf("unsupported tee policy %d", t.Policy)
+		}
+		if t.Buffer < 0 {
+			return fmt.Errorf("tee buffer should be >= 0, got %d", t.Buffer)
+		}
+		if t.Buffer == 0 {
+			t.Buffer = defaultTeeBuffer
+		}
+		f.tees = append(f.tees, t)
+		return nil
+	}
+}
+
+func (t Tee) matches(req *http.Request) bool {
+	for _, m := range t.Matchers {
+		if !m(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// startTees wraps the body of the response, so it is copied to the sinks of the matching tees as it is read
+func (f *Forwarder) startTees(req *http.Request, re *http.Response) {
+	for _, t := range f.tees {
+		if !t.matches(req) {
+			continue
+		}
+		w, err := t.Sink.Open(req, re)
+		if err != nil {
+			f.log.Errorf("Error opening tee of %v, err: %v", req.URL, err)
+			continue
+		}
+		if w == nil {
+			continue
+		}
+		b := &teeBody{ReadCloser: re.Body, f: f, req: req, w: w}
+		if t.Policy == TeeDrop {
+			b.queue = make(chan []byte, 64)
+			b.limit = t.Buffer
+			go b.drain()
+		}
+		re.Body = b
+	}
+}
+
+// teeBody copies the body read to the sink, the body can be closed concurrently with the read, e.g. on timeout
+type teeBody struct {
+	io.ReadCloser
+	f   *Forwarder
+	req *http.Request
+	w   io.WriteCloser
+
+	mutex  sync.Mutex
+	eof    bool
+	closed bool
+	// err abandons the copy
+	err error
+
+	// queue and limit are set with TeeDrop, the copy is written to the sink by drain
+	queue  chan []byte
+	limit  int
+	queued int
+	final  error
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed || b.err != nil {
+		return n, err
+	}
+	if n > 0 {
+		b.copy(p[:n])
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *teeBody) copy(p []byte) {
+	if b.queue == nil {
+		if _, err := b.w.Write(p); err != nil {
+			b.f.log.Errorf("Error writing tee of %v, err: %v", b.req.URL, err)
+			b.err = err
+		}
+		return
+	}
+	if b.queued+len(p) > b.limit {
+		b.f.log.Warningf("Dropping tee of %v: more than %d bytes queued", b.req.URL, b.limit)
+		b.err = ErrTeeDropped
+		return
+	}
+	select {
+	case b.queue <- append([]byte(nil), p...):
+		b.queued += len(p)
+	default:
+		b.f.log.Warningf("Dropping tee of %v: too many writes queued", b.req.URL)
+		b.err = ErrTeeDropped
+	}
+}
+
+func (b *teeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+	final := b.err
+	if final == nil && !b.eof {
+		final = io.ErrUnexpectedEOF
+	}
+	if b.queue == nil {
+		b.finish(final)
+	} else {
+		b.final = final
+		close(b.queue)
+	}
+	return err
+}
+
+// drain writes the queued copy to the sink, the closed queue finishes the copy
+func (b *teeBody) drain() {
+	var err error
+	for p := range b.queue {
+		if err == nil {
+			if _, err = b.w.Write(p); err != nil {
+				b.f.log.Errorf("Error writing tee of %v, err: %v", b.req.URL, err)
+			}
+		}
+		b.mutex.Lock()
+		b.queued -= len(p)
+		b.mutex.Unlock()
+	}
+	b.mutex.Lock()
+	final := b.final
+	b.mutex.Unlock()
+	if err != nil {
+		final = err
+	}
+	b.finish(final)
+}
+
+// finish closes the writer of the sink, with the error if the copy is incomplete
+func (b *teeBody) finish(err error) {
+	if err != nil {
+		if c, ok := b.w.(interface{ CloseWithError(error) error }); ok {
+			err = c.CloseWithError(err)
+		} else {
+			err = b.w.Close()
+		}
+	} else {
+		err = b.w.Close()
+	}
+	if err != nil {
+		b.f.log.Errorf("Error closing tee of %v, err: %v", b.req.URL, err)
+	}
+}
@@ -0,0 +1,174 @@
+package forward
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/router"
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type TeeSuite struct{}
+
+var _ = Suite(&TeeSuite{})
+
+// teeSink keeps the copies, the writes wait for the release if it is set
+type teeSink struct {
+	release chan struct{}
+	copies  chan *teeCopy
+}
+
+type teeCopy struct {
+	sink   *teeSink
+	status int
+	buf    bytes.Buffer
+	err    error
+}
+
+func newTeeSink() *teeSink {
+	return &teeSink{copies: make(chan *teeCopy, 10)}
+}
+
+func (s *teeSink) Open(req *http.Request, re *http.Response) (io.WriteCloser, error) {
+	if req.Header.Get("X-Skip") != "" {
+		return nil, nil
+	}
+	return &teeCopy{sink: s, status: re.StatusCode}, nil
+}
+
+func (s *teeSink) next(c *C) *teeCopy {
+	select {
+	case cp := <-s.copies:
+		return cp
+	case <-time.After(time.Second):
+		c.Fatalf("timeout waiting for the copy")
+		return nil
+	}
+}
+
+func (s *teeSink) empty(c *C) {
+	select {
+	case cp := <-s.copies:
+		c.Fatalf("unexpected copy: %q", cp.buf.String())
+	default:
+	}
+}
+
+func (t *teeCopy) Write(p []byte) (int, error) {
+	if t.sink.release != nil {
+		<-t.sink.release
+	}
+	return t.buf.Write(p)
+}
+
+func (t *teeCopy) Close() error {
+	return t.CloseWithError(nil)
+}
+
+func (t *teeCopy) CloseWithError(err error) error {
+	t.err = err
+	t.sink.copies <- t
+	return nil
+}
+
+func teeBackend(body string) *httptest.Server {
+	return testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		for i := 0; i < len(body); i += 10 {
+			end := i + 10
+			if end > len(body) {
+				end = len(body)
+			}
+			w.Write([]byte(body[i:end]))
+			w.(http.Flusher).Flush()
+		}
+	})
+}
+
+func (s *TeeSuite) TestTee(c *C) {
+	backend := teeBackend("hello world")
+	defer backend.Close()
+
+	sink := newTeeSink()
+	f, err := New(TeeResponses(Tee{Sink: sink, Matchers: []router.Matcher{router.Method("GET")}}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, backend.URL)
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+	c.Assert(string(body), Equals, "hello world")
+
+	cp := sink.next(c)
+	c.Assert(cp.status, Equals, http.StatusCreated)
+	c.Assert(cp.buf.String(), Equals, "hello world")
+	c.Assert(cp.err, IsNil)
+
+	// the requests not matching and the ones skipped by the sink are not teed
+	re, _, err = testutils.MakeRequest(proxy.URL, testutils.Method("POST"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+	re, _, err = testutils.Get(proxy.URL, testutils.Header("X-Skip", "1"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+	sink.empty(c)
+}
+
+func (s *TeeSuite) TestTeeDrop(c *C) {
+	body := strings.Repeat("a", 100)
+	backend := teeBackend(body)
+	defer backend.Close()
+
+	sink := newTeeSink()
+	sink.release = make(chan struct{})
+	f, err := New(TeeResponses(Tee{Sink: sink, Policy: TeeDrop, Buffer: 20}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, backend.URL)
+	defer proxy.Close()
+
+	// the client gets the response while the sink is stuck
+	re, out, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+	c.Assert(string(out), Equals, body)
+
+	close(sink.release)
+	cp := sink.next(c)
+	c.Assert(cp.err, Equals, ErrTeeDropped)
+	c.Assert(cp.buf.Len() <= 20, Equals, true)
+}
+
+func (s *TeeSuite) TestTeeDropComplete(c *C) {
+	backend := teeBackend("hello world")
+	defer backend.Close()
+
+	sink := newTeeSink()
+	f, err := New(TeeResponses(Tee{Sink: sink, Policy: TeeDrop}))
+	c.Assert(err, IsNil)
+	proxy := newHedgeProxy(f, backend.URL)
+	defer proxy.Close()
+
+	_, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello world")
+
+	cp := sink.next(c)
+	c.Assert(cp.buf.String(), Equals, "hello world")
+	c.Assert(cp.err, IsNil)
+}
+
+func (s *TeeSuite) TestTeeOptions(c *C) {
+	_, err := New(TeeResponses(Tee{}))
+	c.Assert(err, NotNil)
+	_, err = New(TeeResponses(Tee{Sink: newTeeSink(), Policy: TeePolicy(5)}))
+	c.Assert(err, NotNil)
+	_, err = New(TeeResponses(Tee{Sink: newTeeSink(), Buffer: -1}))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,91 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// XRequestTimeoutMs is the conventional header carrying the upstream timeout in milliseconds, see TimeoutHeader
+const XRequestTimeoutMs = "X-Request-Timeout-Ms"
+
+type timeoutKey struct{}
+
+// WithTimeout returns a shallow copy of the request carrying the upstream timeout for the forwarder.
+// Middlewares in front of the forwarder can use it to give different deadlines to different kinds of traffic.
+func WithTimeout(req *http.Request, d time.Duration) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), timeoutKey{}, d))
+}
+
+// TimeoutHeader makes the forwarder take the upstream timeout in milliseconds from the request header,
+// e.g. X-Request-Timeout-Ms. The header has to be set by a trusted party and is not passed to the backends,
+// the timeout set with WithTimeout takes precedence.
+func TimeoutHeader(name string) optSetter {
+	return func(f *Forwarder) error {
+		if name == "" {
+			return fmt.Errorf("timeout header name can not be empty")
+		}
+		f.timeoutHeader = http.CanonicalHeaderKey(name)
+		return nil
+	}
+}
+
+// MaxTimeout clamps the per request upstream timeouts
+func MaxTimeout(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		if d <= 0 {
+			return fmt.Errorf("max timeout should be > 0, got %v", d)
+		}
+		f.maxTimeout = d
+		return nil
+	}
+}
+
+// PropagateBudget makes the forwarder pass the time left until the deadline of the request context
+// in milliseconds to the backends in the header, e.g. X-Request-Budget-Ms, so the backends can stop working
+// on the requests nobody waits for. The requests arriving past the deadline are not forwarded.
+func PropagateBudget(name string) optSetter {
+	return func(f *Forwarder) error {
+		if name == "" {
+			return fmt.Errorf("budget header name can not be empty")
+		}
+		f.budgetHeader = http.CanonicalHeaderKey(name)
+		return nil
+	}
+}
+
+// setBudget sets the budget header of the outgoing request, returning the error if the deadline has passed
+func (f *Forwarder) setBudget(outReq *http.Request) error {
+	deadline, ok := outReq.Context().Deadline()
+	if !ok {
+		outReq.Header.Del(f.budgetHeader)
+		return nil
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms <= 0 {
+		return context.DeadlineExceeded
+	}
+	outReq.Header.Set(f.budgetHeader, strconv.FormatInt(ms, 10))
+	return nil
+}
+
+// requestTimeout returns the upstream timeout for the request, 0 means no timeout
+func (f *Forwarder) requestTimeout(req *http.Request) time.Duration {
+	d, ok := req.Context().Value(timeoutKey{}).(time.Duration)
+	if !ok && f.timeoutHeader != "" {
+		if val := req.Header.Get(f.timeoutHeader); val != "" {
+			ms, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || ms <= 0 {
+				f.log.Warningf("ignoring invalid %v: '%v'", f.timeoutHeader, val)
+			} else {
+				d = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if f.maxTimeout > 0 && (d <= 0 || d > f.maxTimeout) {
+		return f.maxTimeout
+	}
+	return d
+}
@@ -0,0 +1,144 @@
+package forward
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type TimeoutSuite struct{}
+
+var _ = Suite(&TimeoutSuite{})
+
+// newSlowProxy returns a proxy to the backend replying after 100ms, the proxy handler can alter the request
+func newSlowProxy(f *Forwarder, prepare func(req *http.Request) *http.Request) (func(), string, *string) {
+	var backendHeader string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		backendHeader = req.Header.Get(XRequestTimeoutMs)
+		select {
+		case <-time.After(100 * time.Millisecond):
+			w.Write([]byte("done"))
+		case <-req.Context().Done():
+		}
+	})
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		if prepare != nil {
+			req = prepare(req)
+		}
+		f.ServeHTTP(w, req)
+	})
+	return func() { proxy.Close(); srv.Close() }, proxy.URL, &backendHeader
+}
+
+func (s *TimeoutSuite) TestHeader(c *C) {
+	f, err := New(TimeoutHeader(XRequestTimeoutMs))
+	c.Assert(err, IsNil)
+	closeFn, url, backendHeader := newSlowProxy(f, nil)
+	defer closeFn()
+
+	re, _, err := testutils.Get(url, testutils.Header(XRequestTimeoutMs, "10"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusGatewayTimeout)
+
+	re, body, err := testutils.Get(url, testutils.Header(XRequestTimeoutMs, "5000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "done")
+	c.Assert(*backendHeader, Equals, "")
+
+	// invalid values are ignored
+	re, _, err = testutils.Get(url, testutils.Header(XRequestTimeoutMs, "soon"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *TimeoutSuite) TestMaxTimeout(c *C) {
+	f, err := New(TimeoutHeader(XRequestTimeoutMs), MaxTimeout(10*time.Millisecond))
+	c.Assert(err, IsNil)
+	closeFn, url, _ := newSlowProxy(f, nil)
+	defer closeFn()
+
+	re, _, err := testutils.Get(url)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusGatewayTimeout)
+
+	re, _, err = testutils.Get(url, testutils.Header(XRequestTimeoutMs, "5000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusGatewayTimeout)
+}
+
+func (s *TimeoutSuite) TestContext(c *C) {
+	f, err := New(TimeoutHeader(XRequestTimeoutMs))
+	c.Assert(err, IsNil)
+	closeFn, url, _ := newSlowProxy(f, func(req *http.Request) *http.Request {
+		return WithTimeout(req, 10*time.Millisecond)
+	})
+	defer closeFn()
+
+	// context takes precedence over the header
+	re, _, err := testutils.Get(url, testutils.Header(XRequestTimeoutMs, "5000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusGatewayTimeout)
+}
+
+func (s *TimeoutSuite) TestPropagateBudget(c *C) {
+	var budget string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		budget = req.Header.Get("X-Request-Budget-Ms")
+		w.Write([]byte("done"))
+	})
+	defer srv.Close()
+
+	f, err := New(PropagateBudget("X-Request-Budget-Ms"))
+	c.Assert(err, IsNil)
+	serve := func(ctx context.Context) int {
+		req := httptest.NewRequest("GET", srv.URL, nil).WithContext(ctx)
+		req.URL = testutils.ParseURI(srv.URL)
+		req.Header.Set("X-Request-Budget-Ms", "100000")
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Assert(serve(ctx), Equals, http.StatusOK)
+	ms, err := strconv.Atoi(budget)
+	c.Assert(err, IsNil)
+	c.Assert(ms > 4000 && ms <= 5000, Equals, true, Commentf("%v", ms))
+
+	// the header without the deadline is not passed on
+	c.Assert(serve(context.Background()), Equals, http.StatusOK)
+	c.Assert(budget, Equals, "")
+
+	// the upstream timeout shortens the budget
+	f, err = New(PropagateBudget("X-Request-Budget-Ms"), MaxTimeout(time.Second))
+	c.Assert(err, IsNil)
+	c.Assert(serve(ctx), Equals, http.StatusOK)
+	ms, err = strconv.Atoi(budget)
+	c.Assert(err, IsNil)
+	c.Assert(ms > 0 && ms <= 1000, Equals, true, Commentf("%v", ms))
+
+	// the request past the deadline is not forwarded
+	budget = "untouched"
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	c.Assert(serve(expired), Equals, http.StatusGatewayTimeout)
+	c.Assert(budget, Equals, "untouched")
+}
+
+func (s *TimeoutSuite) TestBadOptions(c *C) {
+	_, err := New(PropagateBudget(""))
+	c.Assert(err, NotNil)
+	_, err = New(TimeoutHeader(""))
+	c.Assert(err, NotNil)
+	_, err = New(MaxTimeout(0))
+	c.Assert(err, NotNil)
+}
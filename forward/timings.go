@@ -0,0 +1,35 @@
+package forward
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+// timedWriter accumulates the time spent writing the response body to the client, see utils.Timings
+type timedWriter struct {
+	http.ResponseWriter
+	clock timetools.TimeProvider
+	spent time.Duration
+}
+
+func (t *timedWriter) Write(b []byte) (int, error) {
+	start := t.clock.UtcNow()
+	n, err := t.ResponseWriter.Write(b)
+	t.spent += t.clock.UtcNow().Sub(start)
+	return n, err
+}
+
+func (t *timedWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		start := t.clock.UtcNow()
+		f.Flush()
+		t.spent += t.clock.UtcNow().Sub(start)
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, see BodyTimeouts
+func (t *timedWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
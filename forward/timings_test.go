@@ -0,0 +1,61 @@
+package forward
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type TimingsSuite struct{}
+
+var _ = Suite(&TimingsSuite{})
+
+type timingsObserver struct {
+	ttfb chan time.Duration
+}
+
+func (o *timingsObserver) OnRequest(r *http.Request) {}
+
+func (o *timingsObserver) OnResponse(r *http.Request, resp *http.Response, d time.Duration) {
+	o.ttfb <- utils.TimingsFromRequest(r).Breakdown().UpstreamTTFB
+}
+
+func (s *TimingsSuite) TestBreakdown(c *C) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	observer := &timingsObserver{ttfb: make(chan time.Duration, 1)}
+	f, err := New(Observer(observer))
+	c.Assert(err, IsNil)
+
+	timings := make(chan utils.TimingBreakdown, 1)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req, t := utils.WithTimings(req)
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+		timings <- t.Breakdown()
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+
+	b := <-timings
+	c.Assert(b.UpstreamTTFB >= 20*time.Millisecond, Equals, true, Commentf("%v", b))
+	c.Assert(b.Upstream >= 40*time.Millisecond, Equals, true, Commentf("%v", b))
+	c.Assert(b.Write > 0, Equals, true, Commentf("%v", b))
+	// the observer sees the time to the first byte
+	c.Assert(<-observer.ttfb, Equals, b.UpstreamTTFB)
+}
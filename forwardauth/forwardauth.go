@@ -0,0 +1,190 @@
+// Package forwardauth delegates authentication and authorization of the requests to an external service.
+//
+// Every request is mirrored to the auth service as GET with the original headers and X-Forwarded-Method,
+// X-Forwarded-Proto, X-Forwarded-Host, X-Forwarded-Uri and X-Forwarded-For describing the original request.
+// 2xx reply lets the request through, any other reply is returned to the client as is, e.g. redirect to the login page.
+//
+//	// pass the user authenticated by the auth service to the backends
+//	fa, _ := forwardauth.New(next, "http://auth.local/verify", forwardauth.ResponseHeaders("X-Auth-User"))
+package forwardauth
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/utils"
+)
+
+const (
+	XForwardedMethod = "X-Forwarded-Method"
+	XForwardedURI    = "X-Forwarded-Uri"
+)
+
+// maxBodyBytes limits the body of the auth service reply relayed to the client
+const maxBodyBytes = 1024 * 1024
+
+// ForwardAuth lets through only the requests approved by the auth service
+type ForwardAuth struct {
+	next            http.Handler
+	address         string
+	client          *http.Client
+	requestHeaders  []string
+	responseHeaders []string
+	errHandler      utils.ErrorHandler
+	log             utils.Logger
+}
+
+// ForwardAuthOption is a functional option setter for ForwardAuth
+type ForwardAuthOption func(f *ForwardAuth) error
+
+// Client sets the HTTP client used to call the auth service
+func Client(c *http.Client) ForwardAuthOption {
+	return func(f *ForwardAuth) error {
+		f.client = c
+		return nil
+	}
+}
+
+// RequestHeaders limits the headers of the original request sent to the auth service, all headers are sent by default
+func RequestHeaders(names ...string) ForwardAuthOption {
+	return func(f *ForwardAuth) error {
+		f.requestHeaders = append(f.requestHeaders, names...)
+		return nil
+	}
+}
+
+// ResponseHeaders sets the headers copied from the approving auth service reply to the upstream request
+func ResponseHeaders(names ...string) ForwardAuthOption {
+	return func(f *ForwardAuth) error {
+		f.responseHeaders = append(f.responseHeaders, names...)
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler for the failures to reach the auth service
+func ErrorHandler(h utils.ErrorHandler) ForwardAuthOption {
+	return func(f *ForwardAuth) error {
+		f.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) ForwardAuthOption {
+	return func(f *ForwardAuth) error {
+		f.log = l
+		return nil
+	}
+}
+
+// New returns a middleware verifying the requests with the auth service at the address
+func New(next http.Handler, address string, options ...ForwardAuthOption) (*ForwardAuth, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported auth service address: '%v'", address)
+	}
+	f := &ForwardAuth{next: next, address: address}
+	for _, o := range options {
+		if err := o(f); err != nil {
+			return nil, err
+		}
+	}
+	if f.client == nil {
+		f.client = &http.Client{
+			Timeout: 30 * time.Second,
+			// redirects are for the client to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	if f.errHandler == nil {
+		f.errHandler = utils.DefaultHandler
+	}
+	if f.log == nil {
+		f.log = utils.NullLogger
+	}
+	return f, nil
+}
+
+func (f *ForwardAuth) Wrap(next http.Handler) {
+	f.next = next
+}
+
+func (f *ForwardAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	authReq, err := f.authRequest(req)
+	if err != nil {
+		f.log.Errorf("failed to create auth request: %v", err)
+		f.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	re, err := f.client.Do(authReq)
+	if err != nil {
+		f.log.Errorf("auth service %v failed: %v", f.address, err)
+		f.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	defer re.Body.Close()
+
+	if re.StatusCode < 200 || re.StatusCode >= 300 {
+		f.log.Infof("auth service rejected %v %v with %d", req.Method, req.URL, re.StatusCode)
+		utils.CopyHeaders(w.Header(), re.Header)
+		utils.RemoveHeaders(w.Header(), forward.HopHeaders...)
+		w.Header().Del(forward.ContentLength)
+		w.WriteHeader(re.StatusCode)
+		io.Copy(w, io.LimitReader(re.Body, maxBodyBytes))
+		return
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(re.Body, maxBodyBytes))
+
+	for _, name := range f.responseHeaders {
+		if vals, ok := re.Header[http.CanonicalHeaderKey(name)]; ok {
+			req.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), vals...)
+		} else {
+			// don't let the clients set the trusted headers themselves
+			req.Header.Del(name)
+		}
+	}
+	f.next.ServeHTTP(w, req)
+}
+
+func (f *ForwardAuth) authRequest(req *http.Request) (*http.Request, error) {
+	authReq, err := http.NewRequest("GET", f.address, nil)
+	if err != nil {
+		return nil, err
+	}
+	authReq = authReq.WithContext(req.Context())
+	if len(f.requestHeaders) == 0 {
+		utils.CopyHeaders(authReq.Header, req.Header)
+	} else {
+		for _, name := range f.requestHeaders {
+			if vals, ok := req.Header[http.CanonicalHeaderKey(name)]; ok {
+				authReq.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), vals...)
+			}
+		}
+	}
+	utils.RemoveHeaders(authReq.Header, forward.HopHeaders...)
+	authReq.Header.Del(forward.ContentLength)
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	authReq.Header.Set(XForwardedMethod, req.Method)
+	authReq.Header.Set(forward.XForwardedProto, proto)
+	authReq.Header.Set(forward.XForwardedHost, req.Host)
+	authReq.Header.Set(XForwardedURI, req.URL.RequestURI())
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		authReq.Header.Set(forward.XForwardedFor, clientIP)
+	}
+	return authReq, nil
+}
@@ -0,0 +1,136 @@
+package forwardauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestForwardAuth(t *testing.T) { TestingT(t) }
+
+type ForwardAuthSuite struct{}
+
+var _ = Suite(&ForwardAuthSuite{})
+
+func (s *ForwardAuthSuite) TestAuthorized(c *C) {
+	var authHeader http.Header
+	authSrv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		authHeader = req.Header
+		if req.Header.Get("Authorization") != "Bearer good" {
+			w.Header().Set("Www-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("go away"))
+			return
+		}
+		w.Header().Set("X-Auth-User", "alice")
+	})
+	defer authSrv.Close()
+
+	var user string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user = req.Header.Get("X-Auth-User")
+		w.Write([]byte("hello"))
+	})
+	fa, err := New(backend, authSrv.URL, ResponseHeaders("X-Auth-User"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(fa)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL+"/path?q=1", testutils.Header("Authorization", "Bearer good"),
+		testutils.Header("X-Auth-User", "mallory"), testutils.Host("example.com"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(user, Equals, "alice")
+
+	c.Assert(authHeader.Get(XForwardedMethod), Equals, "GET")
+	c.Assert(authHeader.Get(XForwardedURI), Equals, "/path?q=1")
+	c.Assert(authHeader.Get("X-Forwarded-Host"), Equals, "example.com")
+	c.Assert(authHeader.Get("X-Forwarded-Proto"), Equals, "http")
+	c.Assert(authHeader.Get("X-Forwarded-For"), Equals, "127.0.0.1")
+
+	re, body, err = testutils.Get(srv.URL, testutils.Header("Authorization", "Bearer bad"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(re.Header.Get("Www-Authenticate"), Equals, "Bearer")
+	c.Assert(string(body), Equals, "go away")
+}
+
+func (s *ForwardAuthSuite) TestStripsUnapprovedHeaders(c *C) {
+	authSrv := testutils.NewResponder("ok")
+	defer authSrv.Close()
+
+	var user []string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user = req.Header["X-Auth-User"]
+	})
+	fa, err := New(backend, authSrv.URL, ResponseHeaders("X-Auth-User"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(fa)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("X-Auth-User", "mallory"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(len(user), Equals, 0)
+}
+
+func (s *ForwardAuthSuite) TestRedirect(c *C) {
+	authSrv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "http://login.local/", http.StatusFound)
+	})
+	defer authSrv.Close()
+
+	fa, err := New(nil, authSrv.URL)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(fa)
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	re, err := client.Get(srv.URL)
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(re.StatusCode, Equals, http.StatusFound)
+	c.Assert(re.Header.Get("Location"), Equals, "http://login.local/")
+}
+
+func (s *ForwardAuthSuite) TestRequestHeaders(c *C) {
+	var authHeader http.Header
+	authSrv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		authHeader = req.Header
+	})
+	defer authSrv.Close()
+
+	fa, err := New(http.NotFoundHandler(), authSrv.URL, RequestHeaders("Cookie"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(fa)
+	defer srv.Close()
+
+	_, _, err = testutils.Get(srv.URL, testutils.Header("Cookie", "session=1"), testutils.Header("X-Other", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(authHeader.Get("Cookie"), Equals, "session=1")
+	c.Assert(authHeader.Get("X-Other"), Equals, "")
+}
+
+func (s *ForwardAuthSuite) TestAuthServiceDown(c *C) {
+	fa, err := New(http.NotFoundHandler(), "http://localhost:63450")
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(fa)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadGateway)
+
+	_, err = New(nil, "ftp://auth.local")
+	c.Assert(err, NotNil)
+}
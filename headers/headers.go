@@ -0,0 +1,265 @@
+// Package headers provides middleware that adds, sets and removes request and response headers
+// according to declarative rules. Header values are text/template templates evaluated against the request:
+//
+//	// pass client IP and TLS cipher to the backends, hide the backend software from the clients
+//	h, _ := headers.New(fwd,
+//		headers.RequestSet("X-Real-Ip", "{{.ClientIP}}"),
+//		headers.RequestSet("X-Tls-Cipher", "{{.TLSCipher}}"),
+//		headers.ResponseRemove("Server"),
+//	)
+//
+// See Request for the attributes available to the templates.
+package headers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"text/template"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Request is the data the header templates are evaluated against
+type Request struct {
+	req *http.Request
+}
+
+// ClientIP returns IP address of the client that sent the request
+func (r Request) ClientIP() string {
+	ip, _, err := net.SplitHostPort(r.req.RemoteAddr)
+	if err != nil {
+		return r.req.RemoteAddr
+	}
+	return ip
+}
+
+// Host returns the requested host
+func (r Request) Host() string {
+	return r.req.Host
+}
+
+// Path returns the request path
+func (r Request) Path() string {
+	return r.req.URL.Path
+}
+
+// Method returns the request method
+func (r Request) Method() string {
+	return r.req.Method
+}
+
+// Scheme returns https for TLS requests and http otherwise
+func (r Request) Scheme() string {
+	if r.req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// TLSVersion returns the TLS version negotiated with the client or empty string for plain text requests
+func (r Request) TLSVersion() string {
+	if r.req.TLS == nil {
+		return ""
+	}
+	switch r.req.TLS.Version {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	}
+	return fmt.Sprintf("0x%04x", r.req.TLS.Version)
+}
+
+// TLSCipher returns the name of the cipher suite negotiated with the client or empty string for plain text requests
+func (r Request) TLSCipher() string {
+	if r.req.TLS == nil {
+		return ""
+	}
+	return tls.CipherSuiteName(r.req.TLS.CipherSuite)
+}
+
+// Header returns the value of the request header
+func (r Request) Header(name string) string {
+	return r.req.Header.Get(name)
+}
+
+type op int
+
+const (
+	opAdd op = iota
+	opSet
+	opRemove
+)
+
+type rule struct {
+	op    op
+	name  string
+	value *template.Template
+}
+
+// Headers applies the header rules to the requests and responses passing through
+type Headers struct {
+	next     http.Handler
+	request  []rule
+	response []rule
+	log      utils.Logger
+}
+
+// HeadersOption is a functional option setter for Headers
+type HeadersOption func(h *Headers) error
+
+// RequestAdd adds the value to the request header
+func RequestAdd(name, value string) HeadersOption {
+	return addRule(opAdd, name, value, false)
+}
+
+// RequestSet replaces the request header with the value
+func RequestSet(name, value string) HeadersOption {
+	return addRule(opSet, name, value, false)
+}
+
+// RequestRemove removes the request header
+func RequestRemove(name string) HeadersOption {
+	return addRule(opRemove, name, "", false)
+}
+
+// ResponseAdd adds the value to the response header
+func ResponseAdd(name, value string) HeadersOption {
+	return addRule(opAdd, name, value, true)
+}
+
+// ResponseSet replaces the response header with the value
+func ResponseSet(name, value string) HeadersOption {
+	return addRule(opSet, name, value, true)
+}
+
+// ResponseRemove removes the response header
+func ResponseRemove(name string) HeadersOption {
+	return addRule(opRemove, name, "", true)
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) HeadersOption {
+	return func(h *Headers) error {
+		h.log = l
+		return nil
+	}
+}
+
+func addRule(o op, name, value string, response bool) HeadersOption {
+	return func(h *Headers) error {
+		if name == "" {
+			return fmt.Errorf("header name can not be empty")
+		}
+		r := rule{op: o, name: http.CanonicalHeaderKey(name)}
+		if o != opRemove {
+			t, err := template.New(name).Parse(value)
+			if err != nil {
+				return fmt.Errorf("bad template for header %v: %v", name, err)
+			}
+			r.value = t
+		}
+		if response {
+			h.response = append(h.response, r)
+		} else {
+			h.request = append(h.request, r)
+		}
+		return nil
+	}
+}
+
+// New returns a new headers middleware applying the rules in the order they are given
+func New(next http.Handler, options ...HeadersOption) (*Headers, error) {
+	h := &Headers{next: next}
+	for _, o := range options {
+		if err := o(h); err != nil {
+			return nil, err
+		}
+	}
+	if h.log == nil {
+		h.log = utils.NullLogger
+	}
+	return h, nil
+}
+
+func (h *Headers) Wrap(next http.Handler) {
+	h.next = next
+}
+
+// Rewrite applies the request rules, so Headers can be used as forward.ReqRewriter as well
+func (h *Headers) Rewrite(req *http.Request) {
+	h.apply(h.request, req.Header, req)
+}
+
+func (h *Headers) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.Rewrite(req)
+	if len(h.response) == 0 {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	h.next.ServeHTTP(&responseWriter{ResponseWriter: w, h: h, req: req}, req)
+}
+
+func (h *Headers) apply(rules []rule, header http.Header, req *http.Request) {
+	data := Request{req: req}
+	for _, r := range rules {
+		if r.op == opRemove {
+			header.Del(r.name)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := r.value.Execute(buf, data); err != nil {
+			h.log.Errorf("failed to evaluate header %v: %v", r.name, err)
+			continue
+		}
+		if r.op == opAdd {
+			header.Add(r.name, buf.String())
+		} else {
+			header.Set(r.name, buf.String())
+		}
+	}
+}
+
+// responseWriter applies the response rules right before the headers are written
+type responseWriter struct {
+	http.ResponseWriter
+	h           *Headers
+	req         *http.Request
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.h.apply(rw.h.response, rw.ResponseWriter.Header(), rw.req)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(buf []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(buf)
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,127 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestHeaders(t *testing.T) { TestingT(t) }
+
+type HeadersSuite struct{}
+
+var _ = Suite(&HeadersSuite{})
+
+func (s *HeadersSuite) TestRequestRules(c *C) {
+	var got http.Header
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header
+		w.Write([]byte("hello"))
+	})
+	h, err := New(backend,
+		RequestSet("X-Real-Ip", "{{.ClientIP}}"),
+		RequestSet("X-Route", "{{.Method}} {{.Scheme}}://{{.Host}}{{.Path}}"),
+		RequestAdd("X-Tag", "b"),
+		RequestAdd("X-Tag", `{{.Header "X-Source"}}`),
+		RequestRemove("X-Secret"),
+	)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL+"/path",
+		testutils.Host("example.com"), testutils.Header("X-Secret", "s"),
+		testutils.Header("X-Tag", "a"), testutils.Header("X-Source", "c"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	c.Assert(got.Get("X-Real-Ip"), Equals, "127.0.0.1")
+	c.Assert(got.Get("X-Route"), Equals, "GET http://example.com/path")
+	c.Assert(got["X-Tag"], DeepEquals, []string{"a", "b", "c"})
+	c.Assert(got.Get("X-Secret"), Equals, "")
+}
+
+func (s *HeadersSuite) TestResponseRules(c *C) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Powered-By", "go")
+		w.Write([]byte("hello"))
+	})
+	h, err := New(backend,
+		ResponseRemove("Server"),
+		ResponseSet("X-Powered-By", "oxy"),
+		ResponseAdd("X-Served-Host", "{{.Host}}"),
+	)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL, testutils.Host("example.com"))
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(re.Header.Get("Server"), Equals, "")
+	c.Assert(re.Header.Get("X-Powered-By"), Equals, "oxy")
+	c.Assert(re.Header.Get("X-Served-Host"), Equals, "example.com")
+}
+
+func (s *HeadersSuite) TestResponseController(c *C) {
+	var deadlineErr error
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deadlineErr = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute))
+		w.Write([]byte("hello"))
+	})
+	h, err := New(backend, ResponseSet("X-Powered-By", "oxy"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	_, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello")
+	// the writer of the rules passes the deadlines to the connection
+	c.Assert(deadlineErr, IsNil)
+}
+
+func (s *HeadersSuite) TestTLS(c *C) {
+	var cipher, version string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cipher, version = req.Header.Get("X-Tls-Cipher"), req.Header.Get("X-Tls-Version")
+	})
+	h, err := New(backend, RequestSet("X-Tls-Cipher", "{{.TLSCipher}}"), RequestSet("X-Tls-Version", "{{.TLSVersion}}"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewTLSServer(h)
+	defer srv.Close()
+
+	re, err := srv.Client().Get(srv.URL)
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(cipher, Not(Equals), "")
+	c.Assert(version, Matches, "TLS1.*")
+}
+
+func (s *HeadersSuite) TestRewriter(c *C) {
+	h, err := New(nil, RequestSet("X-Gateway", "oxy"))
+	c.Assert(err, IsNil)
+
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	c.Assert(err, IsNil)
+	h.Rewrite(req)
+	c.Assert(req.Header.Get("X-Gateway"), Equals, "oxy")
+}
+
+func (s *HeadersSuite) TestBadRules(c *C) {
+	_, err := New(nil, RequestSet("X-Broken", "{{.ClientIP"))
+	c.Assert(err, NotNil)
+
+	_, err = New(nil, ResponseRemove(""))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,208 @@
+// Package health provides the readiness endpoint of the proxy for the orchestrators, so they stop routing to
+// the instance that can not serve. The instance is ready while every registered load balancer has at least
+// one healthy server and the shutdown has not begun. The states of the registered circuit breakers are reported,
+// the tripped ones mark the instance not ready only with TrippedUnready, as the breakers of the backends shared
+// by the fleet trip on all the instances at once:
+//
+//	h, _ := health.New(health.WithLoadBalancer("main", lb), health.WithBreaker("main", cb))
+//	mux.Handle("/ready", h)
+//
+//	// on SIGTERM the instance reports 503 first, then stops accepting the requests
+//	oxy.Close(ctx, h, cb, lb, fwd)
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/utils"
+)
+
+// LoadBalancer is implemented by roundrobin.RoundRobin and roundrobin.Rebalancer
+type LoadBalancer interface {
+	Servers() []*url.URL
+	ServerWeight(u *url.URL) (int, bool)
+	ServerStats(u *url.URL) (roundrobin.ServerStats, error)
+}
+
+// Breaker is implemented by cbreaker.CircuitBreaker
+type Breaker interface {
+	State() string
+}
+
+// breakerTripped is the state of the breaker falling back on all the requests
+const breakerTripped = "tripped"
+
+// Status is the body of the health endpoint
+type Status struct {
+	Status string `json:"status"`
+	// Reasons tell why the instance is not ready
+	Reasons []string `json:"reasons,omitempty"`
+	// Breakers are the states of the registered breakers
+	Breakers map[string]string `json:"breakers,omitempty"`
+}
+
+const (
+	statusReady       = "ready"
+	statusUnavailable = "unavailable"
+)
+
+// Health is http.Handler answering 200 while the instance is ready and 503 otherwise
+type Health struct {
+	lbs      map[string]LoadBalancer
+	breakers map[string]Breaker
+	// trippedUnready marks the instance not ready while any of the breakers is tripped
+	trippedUnready bool
+	shutdown       int32
+	log            utils.Logger
+}
+
+// Option is a functional option setter for Health
+type Option func(*Health) error
+
+// WithLoadBalancer registers the load balancer under the given name, the instance is not ready while
+// none of its servers is healthy, i.e. all of them are drained, ejected or behind the tripped breakers
+func WithLoadBalancer(name string, lb LoadBalancer) Option {
+	return func(h *Health) error {
+		if lb == nil {
+			return fmt.Errorf("load balancer %v can not be nil", name)
+		}
+		h.lbs[name] = lb
+		return nil
+	}
+}
+
+// WithBreaker registers the circuit breaker under the given name, its state is reported in the status
+func WithBreaker(name string, b Breaker) Option {
+	return func(h *Health) error {
+		if b == nil {
+			return fmt.Errorf("breaker %v can not be nil", name)
+		}
+		h.breakers[name] = b
+		return nil
+	}
+}
+
+// TrippedUnready marks the instance not ready while any of the registered breakers is tripped. Use it for
+// the breakers guarding the failures of the instance itself, e.g. of its local dependencies, the breakers
+// of the shared backends would pull the whole fleet at once.
+func TrippedUnready() Option {
+	return func(h *Health) error {
+		h.trippedUnready = true
+		return nil
+	}
+}
+
+// Logger sets the logger used to report the beginning of the shutdown
+func Logger(l utils.Logger) Option {
+	return func(h *Health) error {
+		h.log = l
+		return nil
+	}
+}
+
+// New returns the health endpoint checking the components registered via options
+func New(opts ...Option) (*Health, error) {
+	h := &Health{
+		lbs:      make(map[string]LoadBalancer),
+		breakers: make(map[string]Breaker),
+	}
+	for _, o := range opts {
+		if err := o(h); err != nil {
+			return nil, err
+		}
+	}
+	if h.log == nil {
+		h.log = utils.NullLogger
+	}
+	return h, nil
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	st := h.Check()
+	code := http.StatusOK
+	if st.Status != statusReady {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if req.Method != "HEAD" {
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+// Check returns the readiness of the instance
+func (h *Health) Check() Status {
+	var reasons []string
+	if h.ShuttingDown() {
+		reasons = append(reasons, "shutting down")
+	}
+	names := make([]string, 0, len(h.breakers))
+	for name := range h.breakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var breakers map[string]string
+	if len(names) != 0 {
+		breakers = make(map[string]string, len(names))
+	}
+	for _, name := range names {
+		state := h.breakers[name].State()
+		breakers[name] = state
+		if h.trippedUnready && state == breakerTripped {
+			reasons = append(reasons, fmt.Sprintf("breaker %v is tripped", name))
+		}
+	}
+	names = names[:0]
+	for name := range h.lbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !healthy(h.lbs[name]) {
+			reasons = append(reasons, fmt.Sprintf("load balancer %v has no healthy servers", name))
+		}
+	}
+	if len(reasons) != 0 {
+		return Status{Status: statusUnavailable, Reasons: reasons, Breakers: breakers}
+	}
+	return Status{Status: statusReady, Breakers: breakers}
+}
+
+// Close marks the beginning of the shutdown, the instance is not ready from then on. It returns at once,
+// so it is passed to oxy.Close ahead of the middlewares draining the requests.
+func (h *Health) Close(ctx context.Context) error {
+	if atomic.CompareAndSwapInt32(&h.shutdown, 0, 1) {
+		h.log.Infof("shutdown has begun, reporting not ready")
+	}
+	return nil
+}
+
+// ShuttingDown tells whether Close has been called
+func (h *Health) ShuttingDown() bool {
+	return atomic.LoadInt32(&h.shutdown) == 1
+}
+
+// healthy tells whether the load balancer has a server getting the requests
+func healthy(lb LoadBalancer) bool {
+	for _, u := range lb.Servers() {
+		if w, ok := lb.ServerWeight(u); !ok || w == 0 {
+			continue
+		}
+		st, err := lb.ServerStats(u)
+		if err != nil {
+			continue
+		}
+		if !st.Ejected && st.Circuit != breakerTripped {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestHealth(t *testing.T) { TestingT(t) }
+
+type HealthSuite struct{}
+
+var _ = Suite(&HealthSuite{})
+
+type breaker string
+
+func (b breaker) State() string { return string(b) }
+
+func (s *HealthSuite) TestReady(c *C) {
+	h, err := New(WithLoadBalancer("main", newLB(c, "http://localhost:5000")), WithBreaker("main", breaker("standby")))
+	c.Assert(err, IsNil)
+
+	code, st := check(c, h, "GET")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(st, DeepEquals, Status{Status: "ready", Breakers: map[string]string{"main": "standby"}})
+}
+
+func (s *HealthSuite) TestNoHealthyServers(c *C) {
+	empty := newLB(c)
+	drained := newLB(c, "http://localhost:5000")
+	c.Assert(drained.UpsertServer(testutils.ParseURI("http://localhost:5000"), roundrobin.Weight(0)), IsNil)
+	ejected := newLB(c, "http://localhost:5000")
+	c.Assert(ejected.EjectServer(testutils.ParseURI("http://localhost:5000"), time.Minute), IsNil)
+
+	h, err := New(WithLoadBalancer("empty", empty), WithLoadBalancer("drained", drained), WithLoadBalancer("ejected", ejected))
+	c.Assert(err, IsNil)
+
+	code, st := check(c, h, "GET")
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+	c.Assert(st.Status, Equals, "unavailable")
+	c.Assert(st.Reasons, DeepEquals, []string{
+		"load balancer drained has no healthy servers",
+		"load balancer ejected has no healthy servers",
+		"load balancer empty has no healthy servers",
+	})
+
+	// one healthy server is enough
+	c.Assert(ejected.UpsertServer(testutils.ParseURI("http://localhost:5001")), IsNil)
+	h, err = New(WithLoadBalancer("ejected", ejected))
+	c.Assert(err, IsNil)
+	code, _ = check(c, h, "GET")
+	c.Assert(code, Equals, http.StatusOK)
+}
+
+func (s *HealthSuite) TestTrippedBreaker(c *C) {
+	// the tripped breakers are reported only by default
+	h, err := New(WithBreaker("a", breaker("tripped")), WithBreaker("b", breaker("recovering")))
+	c.Assert(err, IsNil)
+
+	code, st := check(c, h, "GET")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(st.Breakers, DeepEquals, map[string]string{"a": "tripped", "b": "recovering"})
+
+	h, err = New(WithBreaker("a", breaker("tripped")), WithBreaker("b", breaker("recovering")), TrippedUnready())
+	c.Assert(err, IsNil)
+
+	code, st = check(c, h, "GET")
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+	c.Assert(st.Reasons, DeepEquals, []string{"breaker a is tripped"})
+}
+
+func (s *HealthSuite) TestShutdown(c *C) {
+	h, err := New(WithLoadBalancer("main", newLB(c, "http://localhost:5000")))
+	c.Assert(err, IsNil)
+	c.Assert(h.ShuttingDown(), Equals, false)
+
+	c.Assert(h.Close(context.Background()), IsNil)
+	c.Assert(h.ShuttingDown(), Equals, true)
+
+	code, st := check(c, h, "GET")
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+	c.Assert(st.Reasons, DeepEquals, []string{"shutting down"})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("HEAD", "/ready", nil))
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+	c.Assert(w.Body.Len(), Equals, 0)
+}
+
+func (s *HealthSuite) TestNilComponents(c *C) {
+	_, err := New(WithLoadBalancer("main", nil))
+	c.Assert(err, NotNil)
+	_, err = New(WithBreaker("main", nil))
+	c.Assert(err, NotNil)
+}
+
+func check(c *C, h *Health, method string) (int, Status) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, "/ready", nil))
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	var st Status
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &st), IsNil)
+	return w.Code, st
+}
+
+func newLB(c *C, urls ...string) *roundrobin.RoundRobin {
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+	lb, err := roundrobin.New(fwd)
+	c.Assert(err, IsNil)
+	for _, u := range urls {
+		c.Assert(lb.UpsertServer(testutils.ParseURI(u)), IsNil)
+	}
+	return lb
+}
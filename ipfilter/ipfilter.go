@@ -0,0 +1,176 @@
+// Package ipfilter allows or denies requests based on the client IP address matched against lists of CIDRs.
+//
+//	// allow the office network except the guest wifi, the load balancer in front of oxy is trusted to set X-Forwarded-For
+//	f, _ := ipfilter.New(next, ipfilter.Allow("10.0.0.0/8"), ipfilter.Deny("10.10.0.0/16"), ipfilter.TrustedProxies("192.168.0.1"))
+//
+// Lists can be replaced at runtime with Update, e.g. when the configuration file changes.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// IPFilter rejects requests from the denied addresses and, when the allow list is not empty,
+// from the addresses not on the allow list. Deny list takes precedence.
+type IPFilter struct {
+	next http.Handler
+
+	mtx     sync.RWMutex
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	trusted []*net.IPNet
+
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+// IPFilterOption is a functional option setter for IPFilter
+type IPFilterOption func(f *IPFilter) error
+
+// Allow adds the CIDRs or IP addresses to the allow list
+func Allow(cidrs ...string) IPFilterOption {
+	return func(f *IPFilter) error {
+		nets, err := utils.ParseCIDRs(cidrs)
+		if err != nil {
+			return err
+		}
+		f.allow = append(f.allow, nets...)
+		return nil
+	}
+}
+
+// Deny adds the CIDRs or IP addresses to the deny list
+func Deny(cidrs ...string) IPFilterOption {
+	return func(f *IPFilter) error {
+		nets, err := utils.ParseCIDRs(cidrs)
+		if err != nil {
+			return err
+		}
+		f.deny = append(f.deny, nets...)
+		return nil
+	}
+}
+
+// TrustedProxies sets the proxies trusted to report the client address in X-Forwarded-For header
+func TrustedProxies(cidrs ...string) IPFilterOption {
+	return func(f *IPFilter) error {
+		nets, err := utils.ParseCIDRs(cidrs)
+		if err != nil {
+			return err
+		}
+		f.trusted = append(f.trusted, nets...)
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler rejecting the requests, it receives *AccessDeniedError
+func ErrorHandler(h utils.ErrorHandler) IPFilterOption {
+	return func(f *IPFilter) error {
+		f.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) IPFilterOption {
+	return func(f *IPFilter) error {
+		f.log = l
+		return nil
+	}
+}
+
+// New returns a new IP filter, the filter with empty lists allows everything
+func New(next http.Handler, options ...IPFilterOption) (*IPFilter, error) {
+	f := &IPFilter{next: next}
+	for _, o := range options {
+		if err := o(f); err != nil {
+			return nil, err
+		}
+	}
+	if f.errHandler == nil {
+		f.errHandler = defaultErrHandler
+	}
+	if f.log == nil {
+		f.log = utils.NullLogger
+	}
+	return f, nil
+}
+
+// Update atomically replaces the allow and deny lists, the lists stay intact if any of the CIDRs is invalid
+func (f *IPFilter) Update(allow, deny []string) error {
+	allowNets, err := utils.ParseCIDRs(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := utils.ParseCIDRs(deny)
+	if err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.allow, f.deny = allowNets, denyNets
+	return nil
+}
+
+// Allowed tells whether the requests from the IP are allowed
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	if utils.ContainsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || utils.ContainsIP(f.allow, ip)
+}
+
+func (f *IPFilter) Wrap(next http.Handler) {
+	f.next = next
+}
+
+func (f *IPFilter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ip, err := utils.ClientIP(req, f.trusted)
+	if err != nil {
+		f.log.Errorf("failed to resolve client IP: %v", err)
+		f.errHandler.ServeHTTP(w, req, &AccessDeniedError{})
+		return
+	}
+	if !f.Allowed(ip) {
+		f.log.Infof("denying request from %v", ip)
+		f.errHandler.ServeHTTP(w, req, &AccessDeniedError{IP: ip})
+		return
+	}
+	f.next.ServeHTTP(w, req)
+}
+
+// AccessDeniedError is passed to the error handler when the request is rejected
+type AccessDeniedError struct {
+	// IP is the client address, nil if it could not be resolved
+	IP net.IP
+}
+
+func (e *AccessDeniedError) Error() string {
+	if e.IP == nil {
+		return "access denied: unknown client address"
+	}
+	return fmt.Sprintf("access denied for %v", e.IP)
+}
+
+type IPFilterErrHandler struct {
+}
+
+func (e *IPFilterErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	if _, ok := err.(*AccessDeniedError); ok {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+var defaultErrHandler = &IPFilterErrHandler{}
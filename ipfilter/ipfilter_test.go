@@ -0,0 +1,109 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestIPFilter(t *testing.T) { TestingT(t) }
+
+type IPFilterSuite struct{}
+
+var _ = Suite(&IPFilterSuite{})
+
+var backend = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("hello"))
+})
+
+func (s *IPFilterSuite) TestAllowed(c *C) {
+	f, err := New(backend, Allow("10.0.0.0/8", "192.168.1.1"), Deny("10.10.0.0/16"))
+	c.Assert(err, IsNil)
+
+	testCases := []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "10.1.2.3", allowed: true},
+		{ip: "192.168.1.1", allowed: true},
+		{ip: "192.168.1.2", allowed: false},
+		{ip: "10.10.1.1", allowed: false},
+	}
+	for _, tc := range testCases {
+		c.Assert(f.Allowed(net.ParseIP(tc.ip)), Equals, tc.allowed, Commentf("%v", tc.ip))
+	}
+}
+
+func (s *IPFilterSuite) TestEmptyAllowsEverything(c *C) {
+	f, err := New(backend)
+	c.Assert(err, IsNil)
+	c.Assert(f.Allowed(net.ParseIP("8.8.8.8")), Equals, true)
+}
+
+func (s *IPFilterSuite) TestServeHTTP(c *C) {
+	f, err := New(backend, Deny("127.0.0.1"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusForbidden)
+
+	c.Assert(f.Update([]string{"127.0.0.0/8"}, nil), IsNil)
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+}
+
+func (s *IPFilterSuite) TestTrustedProxies(c *C) {
+	f, err := New(backend, Deny("1.2.3.4"), TrustedProxies("127.0.0.1"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("X-Forwarded-For", "1.2.3.4"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusForbidden)
+
+	re, _, err = testutils.Get(srv.URL, testutils.Header("X-Forwarded-For", "5.6.7.8"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *IPFilterSuite) TestUpdateInvalid(c *C) {
+	f, err := New(backend, Deny("127.0.0.1"))
+	c.Assert(err, IsNil)
+
+	c.Assert(f.Update(nil, []string{"bad"}), NotNil)
+	c.Assert(f.Allowed(net.ParseIP("127.0.0.1")), Equals, false)
+
+	_, err = New(backend, Allow("10.0.0.0/99"))
+	c.Assert(err, NotNil)
+}
+
+func (s *IPFilterSuite) TestCustomErrHandler(c *C) {
+	errHandler := utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte(err.Error()))
+	})
+	f, err := New(backend, Deny("127.0.0.1"), ErrorHandler(errHandler))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusTeapot)
+	c.Assert(string(body), Equals, "access denied for 127.0.0.1")
+}
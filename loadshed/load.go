@@ -0,0 +1,67 @@
+package loadshed
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Load of the process
+type Load struct {
+	// CPU is the CPU time used by the process since the previous sample to the time available on all CPUs, 0 to 1
+	CPU float64
+	// Goroutines is the number of the goroutines running
+	Goroutines int
+	// RSS is the resident set size of the process in bytes, it's 0 where it's not available
+	RSS uint64
+}
+
+// Sampler measures the load of the process
+type Sampler interface {
+	Sample() (Load, error)
+}
+
+// SamplerFunc is an adapter for the functions measuring the load
+type SamplerFunc func() (Load, error)
+
+func (f SamplerFunc) Sample() (Load, error) {
+	return f()
+}
+
+// ProcessSampler measures the load of the current process, the CPU time is taken with getrusage and the RSS
+// from /proc/self/statm, so both are 0 on the platforms without them
+type ProcessSampler struct {
+	mutex   sync.Mutex
+	last    time.Time
+	lastCPU time.Duration
+}
+
+func (p *ProcessSampler) Sample() (Load, error) {
+	rss, err := residentSize()
+	if err != nil {
+		return Load{}, err
+	}
+	load := Load{Goroutines: runtime.NumGoroutine(), RSS: rss}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// the wall clock and the CPU time are taken together, so the ratio covers the same interval
+	now := time.Now()
+	cpu, err := cpuTime()
+	if err != nil {
+		return Load{}, err
+	}
+	if !p.last.IsZero() {
+		if wall := now.Sub(p.last); wall > 0 {
+			load.CPU = float64(cpu-p.lastCPU) / float64(wall) / float64(runtime.NumCPU())
+		}
+	}
+	// the CPU time is accounted in ticks, so the ratio of the short intervals can be off the range
+	if load.CPU > 1 {
+		load.CPU = 1
+	} else if load.CPU < 0 {
+		load.CPU = 0
+	}
+	p.last, p.lastCPU = now, cpu
+	return load, nil
+}
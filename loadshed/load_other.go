@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package loadshed
+
+import "time"
+
+func cpuTime() (time.Duration, error) {
+	return 0, nil
+}
+
+func residentSize() (uint64, error) {
+	return 0, nil
+}
@@ -0,0 +1,31 @@
+package loadshed
+
+import (
+	"runtime"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SamplerSuite struct{}
+
+var _ = Suite(&SamplerSuite{})
+
+func (s *SamplerSuite) TestProcessSampler(c *C) {
+	p := &ProcessSampler{}
+	load, err := p.Sample()
+	c.Assert(err, IsNil)
+	// there is no CPU usage until the second sample
+	c.Assert(load.CPU, Equals, 0.0)
+	c.Assert(load.Goroutines > 0, Equals, true)
+	if runtime.GOOS == "linux" {
+		c.Assert(load.RSS > 0, Equals, true)
+	}
+
+	// burn some CPU
+	for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+	}
+	load, err = p.Sample()
+	c.Assert(err, IsNil)
+	c.Assert(load.CPU > 0 && load.CPU <= 1, Equals, true, Commentf("cpu %v", load.CPU))
+}
@@ -0,0 +1,40 @@
+//go:build !windows && !plan9
+
+package loadshed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func cpuTime() (time.Duration, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), nil
+}
+
+func residentSize() (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// the second field is the resident set size in pages
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0, nil
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
@@ -0,0 +1,267 @@
+// Package loadshed provides middleware protecting the proxy host itself: the requests are rejected with 503 status
+// code and Retry-After while the CPU usage, the number of goroutines or the RSS of the process are above
+// the high watermarks, until they drop below the low ones again.
+//
+//	// shed above 90% of CPU until it's back under 70%, or above 100k goroutines until they are under 80k
+//	s, _ := loadshed.New(handler, loadshed.MaxCPU(0.9, 0.7), loadshed.MaxGoroutines(100000, 80000))
+package loadshed
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// Shedder rejects the requests while the process is overloaded
+type Shedder struct {
+	mutex      sync.Mutex
+	next       http.Handler
+	watermarks []watermark
+	sampler    Sampler
+	interval   time.Duration
+	retryAfter time.Duration
+
+	lastSample time.Time
+	load       Load
+	// shedding is set once a watermark is crossed until all the values are below the low watermarks
+	shedding bool
+
+	clock      timetools.TimeProvider
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+}
+
+type watermark struct {
+	name      string
+	high, low float64
+	value     func(Load) float64
+}
+
+// ShedderOption is a functional option setter for Shedder
+type ShedderOption func(s *Shedder) error
+
+// New returns the shedder of the requests of the process with the load above the watermarks, at least one is required
+func New(next http.Handler, options ...ShedderOption) (*Shedder, error) {
+	s := &Shedder{
+		next:       next,
+		interval:   time.Second,
+		retryAfter: time.Second,
+	}
+	for _, o := range options {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.watermarks) == 0 {
+		return nil, fmt.Errorf("at least one watermark is required")
+	}
+	if s.sampler == nil {
+		s.sampler = &ProcessSampler{}
+	}
+	if s.clock == nil {
+		s.clock = &timetools.RealTime{}
+	}
+	if s.errHandler == nil {
+		s.errHandler = defaultErrHandler
+	}
+	if s.log == nil {
+		s.log = utils.NullLogger
+	}
+	return s, nil
+}
+
+func (s *Shedder) Wrap(next http.Handler) {
+	s.next = next
+}
+
+// Load returns the last load sampled
+func (s *Shedder) Load() Load {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load
+}
+
+// Shedding tells whether the requests are being rejected
+func (s *Shedder) Shedding() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.shedding
+}
+
+func (s *Shedder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := s.admit(); err != nil {
+		s.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	s.next.ServeHTTP(w, req)
+}
+
+// admit samples the load once per interval and returns the error while shedding
+func (s *Shedder) admit() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now := s.clock.UtcNow(); s.lastSample.IsZero() || now.Sub(s.lastSample) >= s.interval {
+		s.lastSample = now
+		load, err := s.sampler.Sample()
+		if err != nil {
+			// the state is kept as it was, the failing sampler should not take the proxy down
+			s.log.Warningf("failed to sample load: %v", err)
+		} else {
+			s.load = load
+			s.update()
+		}
+	}
+	if !s.shedding {
+		return nil
+	}
+	return &OverloadError{Reasons: s.exceeded(), retryAfter: s.retryAfter}
+}
+
+// update switches the shedding on and off with the load
+func (s *Shedder) update() {
+	if !s.shedding {
+		if reasons := s.exceeded(); len(reasons) > 0 {
+			s.log.Warningf("shedding load: %v", strings.Join(reasons, ", "))
+			s.shedding = true
+		}
+		return
+	}
+	if len(s.exceeded()) > 0 {
+		return
+	}
+	s.log.Infof("stopped shedding load")
+	s.shedding = false
+}
+
+// exceeded returns the watermarks crossed, the high ones to start shedding and the low ones to keep it on
+func (s *Shedder) exceeded() []string {
+	var out []string
+	for _, m := range s.watermarks {
+		v := m.value(s.load)
+		switch {
+		case !s.shedding && v >= m.high:
+			out = append(out, fmt.Sprintf("%v %v reached %v", m.name, formatValue(v), formatValue(m.high)))
+		case s.shedding && v > m.low:
+			out = append(out, fmt.Sprintf("%v %v above %v", m.name, formatValue(v), formatValue(m.low)))
+		}
+	}
+	return out
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// OverloadError is reported for the requests shed while the process is overloaded
+type OverloadError struct {
+	// Reasons are the watermarks crossed
+	Reasons    []string
+	retryAfter time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("overloaded: %v", strings.Join(e.Reasons, ", "))
+}
+
+// ShedErrHandler serves the shed requests with 503 status code and Retry-After
+type ShedErrHandler struct {
+}
+
+func (e *ShedErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	if oerr, ok := err.(*OverloadError); ok {
+		seconds := int64((oerr.retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(http.StatusText(http.StatusServiceUnavailable)))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+// MaxCPU sheds the load once the CPU usage, from 0 to 1 of all the CPUs, reaches high until it's below low
+func MaxCPU(high, low float64) ShedderOption {
+	return addWatermark("cpu", high, low, func(l Load) float64 { return l.CPU })
+}
+
+// MaxGoroutines sheds the load once the number of the goroutines reaches high until it's below low
+func MaxGoroutines(high, low int) ShedderOption {
+	return addWatermark("goroutines", float64(high), float64(low), func(l Load) float64 { return float64(l.Goroutines) })
+}
+
+// MaxRSS sheds the load once the resident set size reaches high bytes until it's below low
+func MaxRSS(high, low uint64) ShedderOption {
+	return addWatermark("rss", float64(high), float64(low), func(l Load) float64 { return float64(l.RSS) })
+}
+
+func addWatermark(name string, high, low float64, value func(Load) float64) ShedderOption {
+	return func(s *Shedder) error {
+		if high <= 0 || low < 0 || low > high {
+			return fmt.Errorf("%v watermarks should be 0 <= low <= high and high > 0, got %v, %v", name, high, low)
+		}
+		s.watermarks = append(s.watermarks, watermark{name: name, high: high, low: low, value: value})
+		return nil
+	}
+}
+
+// Interval sets how often the load is sampled, every second by default
+func Interval(d time.Duration) ShedderOption {
+	return func(s *Shedder) error {
+		if d <= 0 {
+			return fmt.Errorf("interval should be > 0, got %v", d)
+		}
+		s.interval = d
+		return nil
+	}
+}
+
+// RetryAfter sets the delay suggested to the clients in Retry-After, rounded up to seconds, 1 second by default
+func RetryAfter(d time.Duration) ShedderOption {
+	return func(s *Shedder) error {
+		if d <= 0 {
+			return fmt.Errorf("retry after should be > 0, got %v", d)
+		}
+		s.retryAfter = d
+		return nil
+	}
+}
+
+// WithSampler sets the sampler of the load, ProcessSampler by default
+func WithSampler(sampler Sampler) ShedderOption {
+	return func(s *Shedder) error {
+		s.sampler = sampler
+		return nil
+	}
+}
+
+// Clock sets the clock of the sampling interval
+func Clock(clock timetools.TimeProvider) ShedderOption {
+	return func(s *Shedder) error {
+		s.clock = clock
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler of the shed requests, 503 by default
+func ErrorHandler(h utils.ErrorHandler) ShedderOption {
+	return func(s *Shedder) error {
+		s.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger used by this middleware
+func Logger(l utils.Logger) ShedderOption {
+	return func(s *Shedder) error {
+		s.log = l
+		return nil
+	}
+}
+
+var defaultErrHandler = &ShedErrHandler{}
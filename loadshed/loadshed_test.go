@@ -0,0 +1,112 @@
+package loadshed
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestLoadshed(t *testing.T) { TestingT(t) }
+
+type ShedderSuite struct {
+	clock *timetools.FreezedTime
+	load  Load
+	err   error
+}
+
+var _ = Suite(&ShedderSuite{})
+
+func (s *ShedderSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	s.load, s.err = Load{}, nil
+}
+
+func (s *ShedderSuite) newShedder(c *C, options ...ShedderOption) *Shedder {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	sampler := SamplerFunc(func() (Load, error) { return s.load, s.err })
+	sh, err := New(handler, append([]ShedderOption{WithSampler(sampler), Clock(s.clock)}, options...)...)
+	c.Assert(err, IsNil)
+	return sh
+}
+
+func serve(sh *Shedder) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+	return w
+}
+
+func (s *ShedderSuite) TestHysteresis(c *C) {
+	sh := s.newShedder(c, MaxCPU(0.9, 0.7), MaxGoroutines(1000, 800), RetryAfter(1500*time.Millisecond))
+
+	s.load = Load{CPU: 0.5, Goroutines: 100}
+	c.Assert(serve(sh).Code, Equals, http.StatusOK)
+
+	s.load = Load{CPU: 0.95, Goroutines: 100}
+	// the load is sampled once per interval
+	c.Assert(serve(sh).Code, Equals, http.StatusOK)
+	s.clock.Sleep(time.Second)
+	w := serve(sh)
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+	c.Assert(w.Header().Get("Retry-After"), Equals, "2")
+	c.Assert(sh.Shedding(), Equals, true)
+
+	// the shedding goes on until all values are below the low watermarks
+	s.load = Load{CPU: 0.8, Goroutines: 100}
+	s.clock.Sleep(time.Second)
+	c.Assert(serve(sh).Code, Equals, http.StatusServiceUnavailable)
+
+	s.load = Load{CPU: 0.6, Goroutines: 900}
+	s.clock.Sleep(time.Second)
+	c.Assert(serve(sh).Code, Equals, http.StatusServiceUnavailable)
+
+	s.load = Load{CPU: 0.6, Goroutines: 700}
+	s.clock.Sleep(time.Second)
+	c.Assert(serve(sh).Code, Equals, http.StatusOK)
+	c.Assert(sh.Shedding(), Equals, false)
+	c.Assert(sh.Load(), Equals, s.load)
+}
+
+func (s *ShedderSuite) TestRSS(c *C) {
+	sh := s.newShedder(c, MaxRSS(1<<30, 1<<29))
+	s.load = Load{RSS: 1 << 30}
+	c.Assert(serve(sh).Code, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *ShedderSuite) TestSamplerError(c *C) {
+	sh := s.newShedder(c, MaxCPU(0.9, 0.7))
+	s.err = fmt.Errorf("boom")
+	c.Assert(serve(sh).Code, Equals, http.StatusOK)
+
+	s.load, s.err = Load{CPU: 1}, nil
+	s.clock.Sleep(time.Second)
+	c.Assert(serve(sh).Code, Equals, http.StatusServiceUnavailable)
+
+	// the failures keep the shedding on
+	s.load, s.err = Load{}, fmt.Errorf("boom")
+	s.clock.Sleep(time.Second)
+	c.Assert(serve(sh).Code, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *ShedderSuite) TestBadOptions(c *C) {
+	for _, options := range [][]ShedderOption{
+		{},
+		{MaxCPU(0, 0)},
+		{MaxCPU(0.5, 0.7)},
+		{MaxGoroutines(100, -1)},
+		{MaxCPU(0.9, 0.7), Interval(0)},
+		{MaxCPU(0.9, 0.7), RetryAfter(0)},
+	} {
+		_, err := New(nil, options...)
+		c.Assert(err, NotNil)
+	}
+}
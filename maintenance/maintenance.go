@@ -0,0 +1,191 @@
+// Package maintenance provides middleware that can be toggled at runtime to serve a static response,
+// e.g. a maintenance page, instead of passing requests to the backends.
+//
+//	// serve maintenance page for everything except the health checks
+//	m, _ := maintenance.New(lb, maintenance.File("/etc/oxy/maintenance.html"), maintenance.PassThrough("/health"))
+//	m.Enable()
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Maintenance serves the configured static response for the matching paths while enabled
+type Maintenance struct {
+	next        http.Handler
+	enabled     int32
+	code        int
+	header      http.Header
+	body        []byte
+	paths       []string
+	passThrough []string
+	log         utils.Logger
+}
+
+// MaintenanceOption is a functional option setter for Maintenance
+type MaintenanceOption func(m *Maintenance) error
+
+// Enabled sets the initial state of the middleware, disabled by default
+func Enabled(enabled bool) MaintenanceOption {
+	return func(m *Maintenance) error {
+		m.setEnabled(enabled)
+		return nil
+	}
+}
+
+// StatusCode sets the status code of the static response, 503 by default
+func StatusCode(code int) MaintenanceOption {
+	return func(m *Maintenance) error {
+		if code < 100 || code > 999 {
+			return fmt.Errorf("invalid status code: %d", code)
+		}
+		m.code = code
+		return nil
+	}
+}
+
+// Header adds the header to the static response, e.g. Retry-After
+func Header(name, value string) MaintenanceOption {
+	return func(m *Maintenance) error {
+		m.header.Add(name, value)
+		return nil
+	}
+}
+
+// Body sets the body of the static response
+func Body(contentType string, body []byte) MaintenanceOption {
+	return func(m *Maintenance) error {
+		m.header.Set("Content-Type", contentType)
+		m.body = body
+		return nil
+	}
+}
+
+// File sets the body of the static response to the contents of the file, the content type
+// is guessed from the file extension
+func File(path string) MaintenanceOption {
+	return func(m *Maintenance) error {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+			m.header.Set("Content-Type", ct)
+		}
+		m.body = body
+		return nil
+	}
+}
+
+// Paths limits the static response to the requests with matching path prefixes, all paths match by default
+func Paths(prefixes ...string) MaintenanceOption {
+	return func(m *Maintenance) error {
+		m.paths = append(m.paths, prefixes...)
+		return nil
+	}
+}
+
+// PassThrough sets the path prefixes that are always passed to the next handler, e.g. health checks
+func PassThrough(prefixes ...string) MaintenanceOption {
+	return func(m *Maintenance) error {
+		m.passThrough = append(m.passThrough, prefixes...)
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) MaintenanceOption {
+	return func(m *Maintenance) error {
+		m.log = l
+		return nil
+	}
+}
+
+// New returns a new disabled maintenance middleware unless Enabled option is set
+func New(next http.Handler, options ...MaintenanceOption) (*Maintenance, error) {
+	m := &Maintenance{
+		next:   next,
+		code:   http.StatusServiceUnavailable,
+		header: make(http.Header),
+	}
+	for _, o := range options {
+		if err := o(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.body == nil {
+		m.header.Set("Content-Type", "text/plain; charset=utf-8")
+		m.body = []byte(http.StatusText(m.code))
+	}
+	if m.log == nil {
+		m.log = utils.NullLogger
+	}
+	return m, nil
+}
+
+// Enable starts serving the static response for the matching requests
+func (m *Maintenance) Enable() {
+	m.setEnabled(true)
+	m.log.Infof("maintenance mode enabled")
+}
+
+// Disable resumes passing all requests to the next handler
+func (m *Maintenance) Disable() {
+	m.setEnabled(false)
+	m.log.Infof("maintenance mode disabled")
+}
+
+// Enabled tells whether the static response is being served
+func (m *Maintenance) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) == 1
+}
+
+func (m *Maintenance) Wrap(next http.Handler) {
+	m.next = next
+}
+
+func (m *Maintenance) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !m.Enabled() || !m.matches(req.URL.Path) {
+		m.next.ServeHTTP(w, req)
+		return
+	}
+	utils.CopyHeaders(w.Header(), m.header)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+	w.WriteHeader(m.code)
+	if req.Method != "HEAD" {
+		w.Write(m.body)
+	}
+}
+
+func (m *Maintenance) matches(path string) bool {
+	if hasPrefix(path, m.passThrough) {
+		return false
+	}
+	return len(m.paths) == 0 || hasPrefix(path, m.paths)
+}
+
+func (m *Maintenance) setEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.enabled, v)
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestMaintenance(t *testing.T) { TestingT(t) }
+
+type MaintenanceSuite struct{}
+
+var _ = Suite(&MaintenanceSuite{})
+
+var backend = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("backend"))
+})
+
+func (s *MaintenanceSuite) TestToggle(c *C) {
+	m, err := New(backend, Header("Retry-After", "120"))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "backend")
+
+	m.Enable()
+	c.Assert(m.Enabled(), Equals, true)
+	re, body, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(re.Header.Get("Retry-After"), Equals, "120")
+	c.Assert(string(body), Equals, "Service Unavailable")
+
+	m.Disable()
+	re, body, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "backend")
+}
+
+func (s *MaintenanceSuite) TestPaths(c *C) {
+	m, err := New(backend, Enabled(true), Paths("/api"), PassThrough("/api/health"),
+		StatusCode(http.StatusTeapot), Body("application/json", []byte(`{"error": "maintenance"}`)))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL + "/api/users")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusTeapot)
+	c.Assert(re.Header.Get("Content-Type"), Equals, "application/json")
+	c.Assert(string(body), Equals, `{"error": "maintenance"}`)
+
+	for _, path := range []string{"/api/health", "/static/app.js"} {
+		re, body, err = testutils.Get(srv.URL + path)
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusOK)
+		c.Assert(string(body), Equals, "backend")
+	}
+}
+
+func (s *MaintenanceSuite) TestFile(c *C) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "maintenance.html")
+	c.Assert(ioutil.WriteFile(path, []byte("<h1>Be right back</h1>"), 0644), IsNil)
+
+	m, err := New(backend, Enabled(true), File(path))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	c.Assert(string(body), Equals, "<h1>Be right back</h1>")
+
+	_, err = New(backend, File(filepath.Join(dir, "missing.html")))
+	c.Assert(err, NotNil)
+}
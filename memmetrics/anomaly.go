@@ -11,6 +11,10 @@ import (
 // If all values are not far from the median, it will return all values in 'good' set.
 // Precision is the smallest value to consider, e.g. if set to millisecond, microseconds will be ignored.
 func SplitLatencies(values []time.Duration, precision time.Duration) (good map[time.Duration]bool, bad map[time.Duration]bool) {
+	good, bad = make(map[time.Duration]bool), make(map[time.Duration]bool)
+	if len(values) == 0 {
+		return good, bad
+	}
 	// Find the max latency M and then map each latency L to the ratio L/M and then call SplitFloat64
 	v2r := map[float64]time.Duration{}
 	ratios := make([]float64, len(values))
@@ -20,7 +24,6 @@ func SplitLatencies(values []time.Duration, precision time.Duration) (good map[t
 		v2r[ratio] = v
 		ratios[i] = ratio
 	}
-	good, bad = make(map[time.Duration]bool), make(map[time.Duration]bool)
 	// Note that multiplier makes this function way less sensitive than ratios detector, this is to avoid noise.
 	vgood, vbad := SplitFloat64(2, 0, ratios)
 	for r, _ := range vgood {
@@ -56,8 +59,8 @@ func SplitFloat64(threshold, sentinel float64, values []float64) (good map[float
 		newValues = values
 	}
 
-	m := median(newValues)
-	mAbs := medianAbsoluteDeviation(newValues)
+	m := Median(newValues)
+	mAbs := MedianAbsoluteDeviation(newValues)
 	for _, v := range values {
 		if v > (m+mAbs)*threshold {
 			bad[v] = true
@@ -68,7 +71,65 @@ func SplitFloat64(threshold, sentinel float64, values []float64) (good map[float
 	return good, bad
 }
 
-func median(values []float64) float64 {
+// SplitLatenciesByKey splits servers (or any other keyed metrics sources) into good and bad sets
+// based on the latency observed at the given quantile, see SplitLatencies for details.
+func SplitLatenciesByKey(metrics map[string]*RTMetrics, quantile float64, precision time.Duration) (good map[string]bool, bad map[string]bool, err error) {
+	latencies := make(map[string]time.Duration, len(metrics))
+	values := make([]time.Duration, 0, len(metrics))
+	for k, m := range metrics {
+		h, err := m.LatencyHistogram()
+		if err != nil {
+			return nil, nil, err
+		}
+		l := h.LatencyAtQuantile(quantile)
+		latencies[k] = l
+		values = append(values, l)
+	}
+	g, b := SplitLatencies(values, precision)
+	good, bad = make(map[string]bool), make(map[string]bool)
+	for k, l := range latencies {
+		if b[l] {
+			bad[k] = true
+		} else if g[l] {
+			good[k] = true
+		}
+	}
+	return good, bad, nil
+}
+
+// SplitNetworkErrorRatiosByKey splits servers (or any other keyed metrics sources) into good and bad sets
+// based on their network error ratios, see SplitRatios for details.
+func SplitNetworkErrorRatiosByKey(metrics map[string]*RTMetrics) (good map[string]bool, bad map[string]bool) {
+	ratios := make(map[string]float64, len(metrics))
+	for k, m := range metrics {
+		ratios[k] = m.NetworkErrorRatio()
+	}
+	return SplitRatiosByKey(ratios)
+}
+
+// SplitRatiosByKey is a version of SplitRatios that preserves the keys the ratios belong to, e.g. server URLs.
+func SplitRatiosByKey(ratios map[string]float64) (good map[string]bool, bad map[string]bool) {
+	values := make([]float64, 0, len(ratios))
+	for _, r := range ratios {
+		values = append(values, r)
+	}
+	g, b := SplitRatios(values)
+	good, bad = make(map[string]bool), make(map[string]bool)
+	for k, r := range ratios {
+		if b[r] {
+			bad[k] = true
+		} else if g[r] {
+			good[k] = true
+		}
+	}
+	return good, bad
+}
+
+// Median returns the median of the values, the values are not modified.
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
 	vals := make([]float64, len(values))
 	copy(vals, values)
 	sort.Float64s(vals)
@@ -79,13 +140,15 @@ func median(values []float64) float64 {
 	return (vals[l/2-1] + vals[l/2]) / 2.0
 }
 
-func medianAbsoluteDeviation(values []float64) float64 {
-	m := median(values)
+// MedianAbsoluteDeviation returns median of absolute deviations of the values from their median,
+// a measure of variability that is robust to outliers.
+func MedianAbsoluteDeviation(values []float64) float64 {
+	m := Median(values)
 	distances := make([]float64, len(values))
 	for i, v := range values {
 		distances[i] = math.Abs(v - m)
 	}
-	return median(distances)
+	return Median(distances)
 }
 
 func maxTime(vals []time.Duration) time.Duration {
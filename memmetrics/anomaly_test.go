@@ -12,8 +12,8 @@ type AnomalySuite struct {
 var _ = Suite(&AnomalySuite{})
 
 func (s *AnomalySuite) TestMedian(c *C) {
-	c.Assert(median([]float64{0.1, 0.2}), Equals, (float64(0.1)+float64(0.2))/2.0)
-	c.Assert(median([]float64{0.3, 0.2, 0.5}), Equals, 0.3)
+	c.Assert(Median([]float64{0.1, 0.2}), Equals, (float64(0.1)+float64(0.2))/2.0)
+	c.Assert(Median([]float64{0.3, 0.2, 0.5}), Equals, 0.3)
 }
 
 func (s *AnomalySuite) TestSplitRatios(c *C) {
@@ -156,3 +156,43 @@ func (s *AnomalySuite) TestSplitLatencies(c *C) {
 		c.Assert(bad, DeepEquals, vbad)
 	}
 }
+
+func (s *AnomalySuite) TestMedianAbsoluteDeviation(c *C) {
+	c.Assert(MedianAbsoluteDeviation([]float64{1, 1, 2, 2, 4, 6, 9}), Equals, 1.0)
+	c.Assert(Median(nil), Equals, 0.0)
+}
+
+func (s *AnomalySuite) TestSplitLatenciesEmpty(c *C) {
+	good, bad := SplitLatencies(nil, time.Millisecond)
+	c.Assert(good, DeepEquals, map[time.Duration]bool{})
+	c.Assert(bad, DeepEquals, map[time.Duration]bool{})
+}
+
+func (s *AnomalySuite) TestSplitRatiosByKey(c *C) {
+	good, bad := SplitRatiosByKey(map[string]float64{"a": 0, "b": 0.01, "c": 0.02, "d": 1})
+	c.Assert(good, DeepEquals, map[string]bool{"a": true, "b": true, "c": true})
+	c.Assert(bad, DeepEquals, map[string]bool{"d": true})
+}
+
+func (s *AnomalySuite) TestSplitMetricsByKey(c *C) {
+	metrics := map[string]*RTMetrics{}
+	for _, k := range []string{"a", "b", "c"} {
+		m, err := NewRTMetrics()
+		c.Assert(err, IsNil)
+		metrics[k] = m
+	}
+	for i := 0; i < 10; i++ {
+		metrics["a"].Record(200, 40*time.Millisecond)
+		metrics["b"].Record(200, 60*time.Millisecond)
+		metrics["c"].Record(502, time.Second)
+	}
+
+	good, bad := SplitNetworkErrorRatiosByKey(metrics)
+	c.Assert(good, DeepEquals, map[string]bool{"a": true, "b": true})
+	c.Assert(bad, DeepEquals, map[string]bool{"c": true})
+
+	good, bad, err := SplitLatenciesByKey(metrics, 50, time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Assert(good, DeepEquals, map[string]bool{"a": true, "b": true})
+	c.Assert(bad, DeepEquals, map[string]bool{"c": true})
+}
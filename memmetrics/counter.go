@@ -2,6 +2,7 @@ package memmetrics
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/mailgun/timetools"
@@ -16,14 +17,25 @@ func CounterClock(c timetools.TimeProvider) rcOptSetter {
 	}
 }
 
-// Calculates in memory failure rate of an endpoint using rolling window of a predefined size
+// CounterAlign aligns the bucket boundaries of the counter to the origin, so the buckets start at
+// origin + N * resolution. The counters and histograms aligned to the same origin roll over at the same time,
+// what keeps the ratios of their values exact. The boundaries are aligned to the Unix epoch by default.
+func CounterAlign(origin time.Time) rcOptSetter {
+	return func(r *RollingCounter) error {
+		r.origin = origin
+		return nil
+	}
+}
+
+// Calculates in memory failure rate of an endpoint using rolling window of a predefined size.
+// The counter is safe for the concurrent use, the buckets are updated and read with atomics.
 type RollingCounter struct {
-	clock          timetools.TimeProvider
-	resolution     time.Duration
-	values         []int
-	countedBuckets int // how many samples in different buckets have we collected so far
-	lastBucket     int // last recorded bucket
-	lastUpdated    time.Time
+	clock      timetools.TimeProvider
+	resolution time.Duration
+	// values are the buckets packed by packBucket
+	values         []uint64
+	countedBuckets int32 // how many samples in different buckets have we collected so far
+	origin         time.Time
 }
 
 // NewCounter creates a counter with fixed amount of buckets that are rotated every resolution period.
@@ -38,10 +50,10 @@ func NewCounter(buckets int, resolution time.Duration, options ...rcOptSetter) (
 	}
 
 	rc := &RollingCounter{
-		lastBucket: -1,
 		resolution: resolution,
+		origin:     time.Unix(0, 0).UTC(),
 
-		values: make([]int, buckets),
+		values: make([]uint64, buckets),
 	}
 
 	for _, o := range options {
@@ -57,42 +69,65 @@ func NewCounter(buckets int, resolution time.Duration, options ...rcOptSetter) (
 	return rc, nil
 }
 
+// Append merges the values of the other counter into this counter. If both counters have the same
+// resolution, amount of buckets and alignment the values are merged bucket by bucket, so the resulting rolling window
+// is as precise as the source ones, otherwise the total count of the other counter is added to the current bucket.
 func (c *RollingCounter) Append(o *RollingCounter) error {
-	c.Inc(int(o.Count()))
+	if o == nil {
+		return fmt.Errorf("other is nil")
+	}
+	if c.resolution != o.resolution || len(c.values) != len(o.values) || !c.origin.Equal(o.origin) {
+		c.Inc(int(o.Count()))
+		return nil
+	}
+	now := c.period(c.clock.UtcNow())
+	for i := range o.values {
+		if age, v, ok := c.bucketAge(atomic.LoadUint64(&o.values[i]), now); ok {
+			c.add(now-age, int(v))
+		}
+	}
+	if counted := o.CountedBuckets(); counted > c.CountedBuckets() {
+		atomic.StoreInt32(&c.countedBuckets, int32(counted))
+	}
 	return nil
 }
 
 func (c *RollingCounter) Clone() *RollingCounter {
-	c.cleanup()
 	other := &RollingCounter{
-		resolution:  c.resolution,
-		values:      make([]int, len(c.values)),
-		clock:       c.clock,
-		lastBucket:  c.lastBucket,
-		lastUpdated: c.lastUpdated,
+		resolution:     c.resolution,
+		values:         make([]uint64, len(c.values)),
+		clock:          c.clock,
+		countedBuckets: atomic.LoadInt32(&c.countedBuckets),
+		origin:         c.origin,
 	}
-	for i, v := range c.values {
-		other.values[i] = v
+	for i := range c.values {
+		other.values[i] = atomic.LoadUint64(&c.values[i])
 	}
 	return other
 }
 
+// Reset clears the values of the counter, the alignment of the buckets is kept
 func (c *RollingCounter) Reset() {
-	c.lastBucket = -1
-	c.countedBuckets = 0
-	c.lastUpdated = time.Time{}
 	for i := range c.values {
-		c.values[i] = 0
+		atomic.StoreUint64(&c.values[i], 0)
 	}
+	atomic.StoreInt32(&c.countedBuckets, 0)
 }
 
 func (c *RollingCounter) CountedBuckets() int {
-	return c.countedBuckets
+	return int(atomic.LoadInt32(&c.countedBuckets))
 }
 
+// Count returns the sum of the buckets of the rolling window, it never blocks the writers
 func (c *RollingCounter) Count() int64 {
-	c.cleanup()
-	return c.sum()
+	now := c.period(c.clock.UtcNow())
+	out := int64(0)
+	for i := range c.values {
+		if _, v, ok := c.bucketAge(atomic.LoadUint64(&c.values[i]), now); ok {
+			out += int64(v)
+		}
+	}
+	return out
 }
 
 func (c *RollingCounter) Resolution() time.Duration {
@@ -107,49 +142,85 @@ func (c *RollingCounter) WindowSize() time.Duration {
 	return time.Duration(len(c.values)) * c.resolution
 }
 
+// BucketStart returns the start of the bucket the time falls into
+func (c *RollingCounter) BucketStart(t time.Time) time.Time {
+	return alignTime(t, c.origin, c.resolution)
+}
+
 func (c *RollingCounter) Inc(v int) {
-	c.cleanup()
-	c.incBucketValue(v)
-}
-
-func (c *RollingCounter) incBucketValue(v int) {
-	now := c.clock.UtcNow()
-	bucket := c.getBucket(now)
-	c.values[bucket] += v
-	c.lastUpdated = now
-	// Update usage stats if we haven't collected enough data
-	if c.countedBuckets < len(c.values) {
-		// Only update if we have advanced to the next bucket and not incremented the value
-		// in the current bucket.
-		if c.lastBucket != bucket {
-			c.lastBucket = bucket
-			c.countedBuckets++
+	c.add(c.period(c.clock.UtcNow()), v)
+}
+
+// add adds the value to the bucket of the period, the bucket left from the previous rounds is started over
+func (c *RollingCounter) add(period int64, v int) {
+	n := int64(len(c.values))
+	slot := &c.values[(period%n+n)%n]
+	for {
+		old := atomic.LoadUint64(slot)
+		fresh := old&bucketUsed == 0 || (old>>32)&bucketPeriodMask != uint64(period)&bucketPeriodMask
+		next := packBucket(period, int32(v))
+		if !fresh {
+			next = old&^bucketValueMask | uint64(uint32(int32(uint32(old))+int32(v)))
+		}
+		if atomic.CompareAndSwapUint64(slot, old, next) {
+			if fresh {
+				c.countBucket()
+			}
+			return
 		}
 	}
 }
 
-// Returns the number in the moving window bucket that this slot occupies
-func (c *RollingCounter) getBucket(t time.Time) int {
-	return int(t.Truncate(c.resolution).Unix() % int64(len(c.values)))
+// countBucket updates the usage stats until the counter has collected enough data
+func (c *RollingCounter) countBucket() {
+	for {
+		counted := atomic.LoadInt32(&c.countedBuckets)
+		if int(counted) >= len(c.values) || atomic.CompareAndSwapInt32(&c.countedBuckets, counted, counted+1) {
+			return
+		}
+	}
 }
 
-// Reset buckets that were not updated
-func (c *RollingCounter) cleanup() {
-	now := c.clock.UtcNow()
-	for i := 0; i < len(c.values); i++ {
-		now = now.Add(time.Duration(-1*i) * c.resolution)
-		if now.Truncate(c.resolution).After(c.lastUpdated.Truncate(c.resolution)) {
-			c.values[c.getBucket(now)] = 0
-		} else {
-			break
-		}
+func (c *RollingCounter) period(t time.Time) int64 {
+	return periods(t, c.origin, c.resolution)
+}
+
+// bucketAge returns how many periods ago the bucket was started and its value, false if the bucket
+// is unused or out of the rolling window
+func (c *RollingCounter) bucketAge(bucket uint64, now int64) (int64, int32, bool) {
+	if bucket&bucketUsed == 0 {
+		return 0, 0, false
+	}
+	age := int64((uint64(now) - (bucket>>32)&bucketPeriodMask) & bucketPeriodMask)
+	if age >= int64(len(c.values)) {
+		return 0, 0, false
 	}
+	return age, int32(uint32(bucket)), true
 }
 
-func (c *RollingCounter) sum() int64 {
-	out := int64(0)
-	for _, v := range c.values {
-		out += int64(v)
+// The bucket is packed into the single word, so it is started over and incremented atomically: the top bit
+// marks the used bucket, the next 31 bits keep the low bits of its period and the low 32 bits its value.
+const (
+	bucketUsed       = uint64(1) << 63
+	bucketPeriodMask = uint64(1)<<31 - 1
+	bucketValueMask  = uint64(1)<<32 - 1
+)
+
+func packBucket(period int64, v int32) uint64 {
+	return bucketUsed | (uint64(period)&bucketPeriodMask)<<32 | uint64(uint32(v))
+}
+
+// periods returns the number of whole periods from the origin to the time, negative before the origin
+func periods(t, origin time.Time, period time.Duration) int64 {
+	d := t.Sub(origin)
+	n := int64(d / period)
+	if d < 0 && d%period != 0 {
+		n--
 	}
-	return out
+	return n
+}
+
+// alignTime returns the start of the period the time falls into
+func alignTime(t, origin time.Time, period time.Duration) time.Time {
+	return origin.Add(time.Duration(periods(t, origin, period)) * period)
 }
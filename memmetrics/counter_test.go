@@ -1,6 +1,8 @@
 package memmetrics
 
 import (
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/mailgun/timetools"
@@ -32,3 +34,112 @@ func (s *CounterSuite) TestCloneExpired(c *C) {
 	out := cnt.Clone()
 	c.Assert(out.Count(), Equals, int64(2))
 }
+
+func (s *CounterSuite) TestAppendPreservesBuckets(c *C) {
+	a, err := NewCounter(3, time.Second, CounterClock(s.clock))
+	c.Assert(err, IsNil)
+	b, err := NewCounter(3, time.Second, CounterClock(s.clock))
+	c.Assert(err, IsNil)
+
+	a.Inc(1)
+	b.Inc(2)
+	s.clock.Sleep(time.Second)
+	b.Inc(3)
+
+	c.Assert(a.Append(b), IsNil)
+	c.Assert(a.Count(), Equals, int64(6))
+
+	// values recorded in the oldest bucket evaporate from the merged counter
+	s.clock.Sleep(2 * time.Second)
+	c.Assert(a.Count(), Equals, int64(3))
+}
+
+func (s *CounterSuite) TestAppendDifferentResolution(c *C) {
+	a, err := NewCounter(3, time.Second, CounterClock(s.clock))
+	c.Assert(err, IsNil)
+	b, err := NewCounter(5, 2*time.Second, CounterClock(s.clock))
+	c.Assert(err, IsNil)
+
+	a.Inc(1)
+	b.Inc(2)
+
+	c.Assert(a.Append(b), IsNil)
+	c.Assert(a.Count(), Equals, int64(3))
+	c.Assert(a.Append(nil), NotNil)
+}
+
+func (s *CounterSuite) TestAlign(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 400*int(time.Millisecond), time.UTC)}
+	origin := time.Date(2012, 3, 4, 5, 6, 0, 500*int(time.Millisecond), time.UTC)
+	cnt, err := NewCounter(2, time.Second, CounterClock(clock), CounterAlign(origin))
+	c.Assert(err, IsNil)
+	c.Assert(cnt.BucketStart(clock.UtcNow()), Equals, origin.Add(6*time.Second))
+
+	cnt.Inc(1)
+	// the bucket boundary is crossed 100ms later
+	clock.CurrentTime = clock.CurrentTime.Add(200 * time.Millisecond)
+	cnt.Inc(1)
+	c.Assert(cnt.CountedBuckets(), Equals, 2)
+	clock.CurrentTime = clock.CurrentTime.Add(time.Second)
+	c.Assert(cnt.Count(), Equals, int64(1))
+
+	// the counters of different alignment are not merged bucket by bucket
+	other, err := NewCounter(2, time.Second, CounterClock(clock))
+	c.Assert(err, IsNil)
+	other.Inc(3)
+	c.Assert(other.BucketStart(clock.UtcNow()), Equals, time.Date(2012, 3, 4, 5, 6, 8, 0, time.UTC))
+	c.Assert(cnt.Append(other), IsNil)
+	c.Assert(cnt.Count(), Equals, int64(4))
+
+	cnt.Reset()
+	c.Assert(cnt.Count(), Equals, int64(0))
+	c.Assert(cnt.CountedBuckets(), Equals, 0)
+	c.Assert(cnt.BucketStart(clock.UtcNow()), Equals, origin.Add(8*time.Second))
+}
+
+func (s *CounterSuite) TestConcurrentInc(c *C) {
+	cnt, err := NewCounter(3, time.Second, CounterClock(s.clock))
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cnt.Inc(1)
+				cnt.Count()
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(cnt.Count(), Equals, int64(8000))
+	c.Assert(cnt.CountedBuckets(), Equals, 1)
+}
+
+// the bucket left from the previous round starts over
+func (s *CounterSuite) TestBucketStartsOver(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: s.clock.CurrentTime}
+	cnt, err := NewCounter(2, time.Second, CounterClock(clock))
+	c.Assert(err, IsNil)
+
+	cnt.Inc(5)
+	clock.Sleep(2 * time.Second)
+	c.Assert(cnt.Count(), Equals, int64(0))
+	cnt.Inc(1)
+	c.Assert(cnt.Count(), Equals, int64(1))
+	c.Assert(cnt.CountedBuckets(), Equals, 2)
+}
+
+func BenchmarkCounterInc(b *testing.B) {
+	cnt, err := NewCounter(10, time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cnt.Inc(1)
+		}
+	})
+}
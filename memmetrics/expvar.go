@@ -0,0 +1,78 @@
+package memmetrics
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SnapshotQuantiles are the latency quantiles in percents reported in Snapshot
+var SnapshotQuantiles = []float64{50, 90, 99, 99.9}
+
+// Snapshot is the JSON friendly summary of the metrics over their rolling window
+type Snapshot struct {
+	Requests          int64   `json:"requests"`
+	RPS               float64 `json:"rps"`
+	ErrorRatio        float64 `json:"error_ratio"`
+	NetworkErrorRatio float64 `json:"network_error_ratio"`
+	// LatencyMs maps the quantiles, e.g. "99.9", to the latencies in milliseconds
+	LatencyMs map[string]float64 `json:"latency_ms"`
+	// RequestBytes and ResponseBytes map the quantiles to the body sizes in bytes
+	RequestBytes  map[string]int64 `json:"request_bytes"`
+	ResponseBytes map[string]int64 `json:"response_bytes"`
+}
+
+// Snapshot summarizes the metrics, the counters are read without blocking Record, the histograms are merged
+// with their lock held
+func (m *RTMetrics) Snapshot() (Snapshot, error) {
+	s := Snapshot{
+		Requests:          m.TotalCount(),
+		ErrorRatio:        m.ResponseCodeRatio(500, 600, 0, 600),
+		NetworkErrorRatio: m.NetworkErrorRatio(),
+		LatencyMs:         make(map[string]float64, len(SnapshotQuantiles)),
+		RequestBytes:      make(map[string]int64, len(SnapshotQuantiles)),
+		ResponseBytes:     make(map[string]int64, len(SnapshotQuantiles)),
+	}
+	if window := m.CounterWindowSize(); window > 0 {
+		s.RPS = float64(s.Requests) / window.Seconds()
+	}
+	h, err := m.LatencyHistogram()
+	if err != nil {
+		return s, err
+	}
+	for _, q := range SnapshotQuantiles {
+		s.LatencyMs[strconv.FormatFloat(q, 'f', -1, 64)] = float64(h.LatencyAtQuantile(q)) / float64(time.Millisecond)
+	}
+	if err := sizeQuantiles(s.RequestBytes, m.RequestSizeHistogram); err != nil {
+		return s, err
+	}
+	return s, sizeQuantiles(s.ResponseBytes, m.ResponseSizeHistogram)
+}
+
+func sizeQuantiles(out map[string]int64, histogram func() (*HDRHistogram, error)) error {
+	h, err := histogram()
+	if err != nil {
+		return err
+	}
+	for _, q := range SnapshotQuantiles {
+		out[strconv.FormatFloat(q, 'f', -1, 64)] = h.ValueAtQuantile(q)
+	}
+	return nil
+}
+
+// PublishExpvar publishes the snapshots returned by the function, e.g. per backend, as the expvar variable
+// with the given name, so they show up in /debug/vars. The function is called on every read of the variable
+// and is responsible for the synchronization with the code recording the metrics.
+//
+//	memmetrics.PublishExpvar("oxy.backends", lb.Snapshots)
+func PublishExpvar(name string, fn func() map[string]Snapshot) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("name and function are required")
+	}
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %v is already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} { return fn() }))
+	return nil
+}
@@ -0,0 +1,55 @@
+package memmetrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"time"
+
+	"github.com/mailgun/timetools"
+	. "gopkg.in/check.v1"
+)
+
+type ExpvarSuite struct{}
+
+var _ = Suite(&ExpvarSuite{})
+
+func (s *ExpvarSuite) TestSnapshot(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	m, err := NewRTMetrics(RTClock(clock))
+	c.Assert(err, IsNil)
+	m.Record(200, 10*time.Millisecond)
+	m.Record(200, 10*time.Millisecond)
+	m.Record(500, 20*time.Millisecond)
+	m.Record(502, 100*time.Millisecond)
+	m.RecordSizes(10, 2000)
+
+	snapshot, err := m.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Requests, Equals, int64(4))
+	c.Assert(snapshot.RPS, Equals, 0.4)
+	c.Assert(snapshot.ErrorRatio, Equals, 0.5)
+	c.Assert(snapshot.NetworkErrorRatio, Equals, 0.25)
+	c.Assert(int(snapshot.LatencyMs["50"]), Equals, 10)
+	c.Assert(int(snapshot.LatencyMs["99.9"]), Equals, 100)
+	c.Assert(snapshot.RequestBytes["50"], Equals, int64(10))
+	c.Assert(snapshot.ResponseBytes["99"]/10, Equals, int64(200))
+}
+
+func (s *ExpvarSuite) TestPublish(c *C) {
+	calls := 0
+	err := PublishExpvar("memmetrics.test", func() map[string]Snapshot {
+		calls++
+		return map[string]Snapshot{"http://a": {Requests: 3, LatencyMs: map[string]float64{"50": 1.5}}}
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 0)
+
+	var out map[string]Snapshot
+	c.Assert(json.Unmarshal([]byte(expvar.Get("memmetrics.test").String()), &out), IsNil)
+	c.Assert(out["http://a"].Requests, Equals, int64(3))
+	c.Assert(out["http://a"].LatencyMs["50"], Equals, 1.5)
+	c.Assert(calls, Equals, 1)
+
+	c.Assert(PublishExpvar("memmetrics.test", func() map[string]Snapshot { return nil }), NotNil)
+	c.Assert(PublishExpvar("", nil), NotNil)
+}
@@ -73,6 +73,16 @@ func RollingClock(clock timetools.TimeProvider) rhOptSetter {
 	}
 }
 
+// RollingAlign aligns the rotation of the histogram to the origin, so the sub-histograms cover
+// origin + N * period and roll over at the same time as the counters aligned to the same origin,
+// see CounterAlign. The histogram rotates a period after the first recorded value by default.
+func RollingAlign(origin time.Time) rhOptSetter {
+	return func(r *RollingHDRHistogram) error {
+		r.origin = &origin
+		return nil
+	}
+}
+
 // RollingHistogram holds multiple histograms and rotates every period.
 // It provides resulting histogram as a result of a call of 'Merged' function.
 type RollingHDRHistogram struct {
@@ -85,6 +95,8 @@ type RollingHDRHistogram struct {
 	sigfigs     int
 	buckets     []*HDRHistogram
 	clock       timetools.TimeProvider
+	// origin is set by RollingAlign
+	origin *time.Time
 }
 
 func NewRollingHDRHistogram(low, high int64, sigfigs int, period time.Duration, bucketCount int, options ...rhOptSetter) (*RollingHDRHistogram, error) {
@@ -118,22 +130,38 @@ func NewRollingHDRHistogram(low, high int64, sigfigs int, period time.Duration,
 	return rh, nil
 }
 
+// Append merges the other rolling histogram into this one. Sub-histograms are merged according to their age,
+// so the most recent sub-histogram of the other is merged into the most recent one of this histogram and so on,
+// this way values evaporate from the merged histogram at the same time as they would do from the original.
 func (r *RollingHDRHistogram) Append(o *RollingHDRHistogram) error {
+	if o == nil {
+		return fmt.Errorf("other is nil")
+	}
 	if r.bucketCount != o.bucketCount || r.period != o.period || r.low != o.low || r.high != o.high || r.sigfigs != o.sigfigs {
 		return fmt.Errorf("can't merge")
 	}
-
-	for i := range r.buckets {
-		if err := r.buckets[i].Merge(o.buckets[i]); err != nil {
+	if r.origin != nil {
+		r.getHist()
+	}
+	// the sub-histograms the other would have rotated out by now are skipped
+	pending := o.pending()
+	for i := pending; i < r.bucketCount; i++ {
+		dst := (r.idx - i + r.bucketCount) % r.bucketCount
+		src := (o.idx + pending - i + o.bucketCount) % o.bucketCount
+		if err := r.buckets[dst].Merge(o.buckets[src]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Reset clears all sub-histograms, the aligned histogram keeps its alignment
 func (r *RollingHDRHistogram) Reset() {
 	r.idx = 0
 	r.lastRoll = r.clock.UtcNow()
+	if r.origin != nil {
+		r.lastRoll = alignTime(r.lastRoll, *r.origin, r.period)
+	}
 	for _, b := range r.buckets {
 		b.Reset()
 	}
@@ -145,19 +173,32 @@ func (r *RollingHDRHistogram) rotate() {
 }
 
 func (r *RollingHDRHistogram) Merged() (*HDRHistogram, error) {
+	if r.origin != nil {
+		// drop the sub-histograms that have expired since the last record
+		r.getHist()
+	}
 	m, err := NewHDRHistogram(r.low, r.high, r.sigfigs)
 	if err != nil {
 		return m, err
 	}
 	for _, h := range r.buckets {
-		if m.Merge(h); err != nil {
+		if err := m.Merge(h); err != nil {
 			return nil, err
 		}
 	}
 	return m, nil
 }
 
+// BucketStart returns the time the current sub-histogram started to collect the values
+func (r *RollingHDRHistogram) BucketStart() time.Time {
+	r.getHist()
+	return r.lastRoll
+}
+
 func (r *RollingHDRHistogram) getHist() *HDRHistogram {
+	if r.origin != nil {
+		return r.getAlignedHist()
+	}
 	if r.clock.UtcNow().Sub(r.lastRoll) >= r.period {
 		r.rotate()
 		r.lastRoll = r.clock.UtcNow()
@@ -165,6 +206,37 @@ func (r *RollingHDRHistogram) getHist() *HDRHistogram {
 	return r.buckets[r.idx]
 }
 
+// pending returns the number of rotations of the aligned histogram due since the last roll
+func (r *RollingHDRHistogram) pending() int {
+	if r.origin == nil || r.lastRoll.IsZero() {
+		return 0
+	}
+	n := periods(r.clock.UtcNow(), r.lastRoll, r.period)
+	if n < 0 {
+		return 0
+	}
+	if n > int64(r.bucketCount) {
+		return r.bucketCount
+	}
+	return int(n)
+}
+
+// getAlignedHist rotates the sub-histograms once per every period boundary passed since the last roll
+func (r *RollingHDRHistogram) getAlignedHist() *HDRHistogram {
+	start := alignTime(r.clock.UtcNow(), *r.origin, r.period)
+	if r.lastRoll.IsZero() {
+		r.lastRoll = start
+	}
+	for i := 0; i < r.bucketCount && r.lastRoll.Before(start); i++ {
+		r.rotate()
+		r.lastRoll = r.lastRoll.Add(r.period)
+	}
+	if r.lastRoll.Before(start) {
+		r.lastRoll = start
+	}
+	return r.buckets[r.idx]
+}
+
 func (r *RollingHDRHistogram) RecordLatencies(v time.Duration, n int64) error {
 	return r.getHist().RecordLatencies(v, n)
 }
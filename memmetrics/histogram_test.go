@@ -125,3 +125,95 @@ func (s *HistogramSuite) TestReset(c *C) {
 	c.Assert(m.ValueAtQuantile(100), Equals, int64(5))
 
 }
+
+func (s *HistogramSuite) TestAppendAlignsBuckets(c *C) {
+	a, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 2, RollingClock(s.tm))
+	c.Assert(err, IsNil)
+	b, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 2, RollingClock(s.tm))
+	c.Assert(err, IsNil)
+
+	// rotate b, so its current sub-histogram index differs from a
+	b.RecordValues(7, 1)
+	s.tm.CurrentTime = s.tm.CurrentTime.Add(time.Second)
+	b.RecordValues(3, 1)
+	a.Reset()
+	a.RecordValues(1, 1)
+
+	c.Assert(a.Append(b), IsNil)
+
+	m, err := a.Merged()
+	c.Assert(err, IsNil)
+	c.Assert(m.ValueAtQuantile(100), Equals, int64(7))
+
+	// the oldest values of b evaporate together with the oldest values of a
+	s.tm.CurrentTime = s.tm.CurrentTime.Add(time.Second)
+	a.RecordValues(1, 1)
+	m, err = a.Merged()
+	c.Assert(err, IsNil)
+	c.Assert(m.ValueAtQuantile(100), Equals, int64(3))
+}
+
+func (s *HistogramSuite) TestAppendIncompatible(c *C) {
+	a, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 2, RollingClock(s.tm))
+	c.Assert(err, IsNil)
+	b, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 3, RollingClock(s.tm))
+	c.Assert(err, IsNil)
+
+	c.Assert(a.Append(b), NotNil)
+	c.Assert(a.Append(nil), NotNil)
+}
+
+func (s *HistogramSuite) TestAlign(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 900*int(time.Millisecond), time.UTC)}
+	origin := time.Date(2012, 3, 4, 0, 0, 0, 0, time.UTC)
+	h, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 3, RollingClock(clock), RollingAlign(origin))
+	c.Assert(err, IsNil)
+
+	max := func() int64 {
+		m, err := h.Merged()
+		c.Assert(err, IsNil)
+		return m.ValueAtQuantile(100)
+	}
+
+	h.RecordValues(9, 1)
+	c.Assert(h.BucketStart(), Equals, time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC))
+	// the rotation happens on the boundary, not a period after the first value
+	clock.CurrentTime = clock.CurrentTime.Add(200 * time.Millisecond)
+	h.RecordValues(5, 1)
+	c.Assert(h.BucketStart(), Equals, time.Date(2012, 3, 4, 5, 6, 8, 0, time.UTC))
+	c.Assert(max(), Equals, int64(9))
+
+	clock.CurrentTime = clock.CurrentTime.Add(2 * time.Second)
+	c.Assert(max(), Equals, int64(5))
+
+	// the idle histogram is emptied once the whole window has passed
+	clock.CurrentTime = clock.CurrentTime.Add(time.Hour)
+	c.Assert(max(), Equals, int64(0))
+
+	h.RecordValues(5, 1)
+	h.Reset()
+	c.Assert(max(), Equals, int64(0))
+	c.Assert(h.BucketStart(), Equals, time.Date(2012, 3, 4, 6, 6, 10, 0, time.UTC))
+}
+
+func (s *HistogramSuite) TestAppendAligned(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	origin := time.Date(2012, 3, 4, 0, 0, 0, 0, time.UTC)
+	a, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 2, RollingClock(clock), RollingAlign(origin))
+	c.Assert(err, IsNil)
+	b, err := NewRollingHDRHistogram(1, 3600000, 3, time.Second, 2, RollingClock(clock), RollingAlign(origin))
+	c.Assert(err, IsNil)
+
+	b.RecordValues(7, 1)
+	clock.CurrentTime = clock.CurrentTime.Add(time.Second)
+	b.RecordValues(3, 1)
+	clock.CurrentTime = clock.CurrentTime.Add(time.Second)
+	a.RecordValues(1, 1)
+
+	// b has not rotated the expired value out yet, it is skipped without modifying b
+	c.Assert(a.Append(b), IsNil)
+	m, err := a.Merged()
+	c.Assert(err, IsNil)
+	c.Assert(m.ValueAtQuantile(100), Equals, int64(3))
+	c.Assert(b.lastRoll, Equals, time.Date(2012, 3, 4, 5, 6, 8, 0, time.UTC))
+}
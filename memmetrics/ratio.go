@@ -1,6 +1,7 @@
 package memmetrics
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/mailgun/timetools"
@@ -55,8 +56,19 @@ func (r *RatioCounter) Reset() {
 	r.b.Reset()
 }
 
+// Append merges counters of the other ratio counter into this one
+func (r *RatioCounter) Append(o *RatioCounter) error {
+	if o == nil {
+		return fmt.Errorf("other is nil")
+	}
+	if err := r.a.Append(o.a); err != nil {
+		return err
+	}
+	return r.b.Append(o.b)
+}
+
 func (r *RatioCounter) IsReady() bool {
-	return r.a.countedBuckets+r.b.countedBuckets >= len(r.a.values)
+	return r.a.CountedBuckets()+r.b.CountedBuckets() >= len(r.a.values)
 }
 
 func (r *RatioCounter) CountA() int64 {
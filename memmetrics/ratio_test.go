@@ -168,3 +168,19 @@ func (s *FailRateSuite) TestReset(c *C) {
 	c.Assert(fr.IsReady(), Equals, true)
 	c.Assert(fr.Ratio(), Equals, 1.0)
 }
+
+func (s *FailRateSuite) TestAppend(c *C) {
+	a, err := NewRatioCounter(1, time.Second, RatioClock(s.tm))
+	c.Assert(err, IsNil)
+	a.IncA(1)
+
+	b, err := NewRatioCounter(1, time.Second, RatioClock(s.tm))
+	c.Assert(err, IsNil)
+	b.IncB(3)
+
+	c.Assert(a.Append(b), IsNil)
+	c.Assert(a.CountA(), Equals, int64(1))
+	c.Assert(a.CountB(), Equals, int64(3))
+	c.Assert(a.Ratio(), Equals, 0.25)
+	c.Assert(a.Append(nil), NotNil)
+}
@@ -1,7 +1,10 @@
 package memmetrics
 
 import (
+	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mailgun/timetools"
@@ -12,15 +15,26 @@ import (
 // all counters are collected as rolling window counters with defined precision, histograms
 // are a rolling window histograms with defined precision as well.
 // See RTOptions for more detail on parameters.
+// The metrics are safe for the concurrent use: the counters are atomic, the histograms have their own lock.
 type RTMetrics struct {
-	total       *RollingCounter
-	netErrors   *RollingCounter
-	statusCodes map[int]*RollingCounter
-	histogram   *RollingHDRHistogram
+	total     *RollingCounter
+	netErrors *RollingCounter
+	// statusCodes is map[int]*RollingCounter copied on the first response with the new code,
+	// so the counters are found without the lock
+	statusCodes atomic.Value
+	codesMutex  sync.Mutex
+	// histMutex guards the histograms
+	histMutex sync.Mutex
+	histogram *RollingHDRHistogram
+	// requestSizes and responseSizes are the body sizes in bytes, see RecordSizes
+	requestSizes  *RollingHDRHistogram
+	responseSizes *RollingHDRHistogram
 
 	newCounter NewCounterFn
 	newHist    NewRollingHistogramFn
 	clock      timetools.TimeProvider
+	// origin is set by RTAlign
+	origin *time.Time
 }
 
 type rrOptSetter func(r *RTMetrics) error
@@ -50,11 +64,19 @@ func RTClock(clock timetools.TimeProvider) rrOptSetter {
 	}
 }
 
+// RTAlign aligns the default counters and the histogram of the metrics to the origin, so all of them
+// roll over at the same time, see CounterAlign and RollingAlign
+func RTAlign(origin time.Time) rrOptSetter {
+	return func(r *RTMetrics) error {
+		r.origin = &origin
+		return nil
+	}
+}
+
 // NewRTMetrics returns new instance of metrics collector.
 func NewRTMetrics(settings ...rrOptSetter) (*RTMetrics, error) {
-	m := &RTMetrics{
-		statusCodes: make(map[int]*RollingCounter),
-	}
+	m := &RTMetrics{}
+	m.statusCodes.Store(make(map[int]*RollingCounter))
 	for _, s := range settings {
 		if err := s(m); err != nil {
 			return nil, err
@@ -67,12 +89,18 @@ func NewRTMetrics(settings ...rrOptSetter) (*RTMetrics, error) {
 
 	if m.newCounter == nil {
 		m.newCounter = func() (*RollingCounter, error) {
+			if m.origin != nil {
+				return NewCounter(counterBuckets, counterResolution, CounterClock(m.clock), CounterAlign(*m.origin))
+			}
 			return NewCounter(counterBuckets, counterResolution, CounterClock(m.clock))
 		}
 	}
 
 	if m.newHist == nil {
 		m.newHist = func() (*RollingHDRHistogram, error) {
+			if m.origin != nil {
+				return NewRollingHDRHistogram(histMin, histMax, histSignificantFigures, histPeriod, histBuckets, RollingClock(m.clock), RollingAlign(*m.origin))
+			}
 			return NewRollingHDRHistogram(histMin, histMax, histSignificantFigures, histPeriod, histBuckets, RollingClock(m.clock))
 		}
 	}
@@ -92,7 +120,19 @@ func NewRTMetrics(settings ...rrOptSetter) (*RTMetrics, error) {
 		return nil, err
 	}
 
+	requestSizes, err := m.newSizeHist()
+	if err != nil {
+		return nil, err
+	}
+
+	responseSizes, err := m.newSizeHist()
+	if err != nil {
+		return nil, err
+	}
+
 	m.histogram = h
+	m.requestSizes = requestSizes
+	m.responseSizes = responseSizes
 	m.netErrors = netErrors
 	m.total = total
 	return m, nil
@@ -115,7 +155,7 @@ func (m *RTMetrics) NetworkErrorRatio() float64 {
 func (m *RTMetrics) ResponseCodeRatio(startA, endA, startB, endB int) float64 {
 	a := int64(0)
 	b := int64(0)
-	for code, v := range m.statusCodes {
+	for code, v := range m.codes() {
 		if code < endA && code >= startA {
 			a += v.Count()
 		}
@@ -129,7 +169,34 @@ func (m *RTMetrics) ResponseCodeRatio(startA, endA, startB, endB int) float64 {
 	return 0
 }
 
+// AggregateRTMetrics merges metrics collected by multiple instances, e.g. by different backends
+// or forwarders, into a new fleet-wide metrics collector. Source metrics are not modified.
+func AggregateRTMetrics(metrics ...*RTMetrics) (*RTMetrics, error) {
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("provide at least one metrics instance")
+	}
+	first := metrics[0]
+	out, err := NewRTMetrics(RTClock(first.clock), RTCounter(first.newCounter), RTHistogram(first.newHist))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range metrics {
+		if err := out.Append(m); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Append merges the other metrics into these metrics. Latency histograms are merged
+// without losing the quantile accuracy.
 func (m *RTMetrics) Append(other *RTMetrics) error {
+	if other == nil {
+		return fmt.Errorf("other is nil")
+	}
+	if other == m {
+		return fmt.Errorf("metrics can not be appended to themselves")
+	}
 	if err := m.total.Append(other.total); err != nil {
 		return err
 	}
@@ -138,27 +205,72 @@ func (m *RTMetrics) Append(other *RTMetrics) error {
 		return err
 	}
 
-	for code, c := range other.statusCodes {
-		o, ok := m.statusCodes[code]
+	m.codesMutex.Lock()
+	codes := m.codes()
+	var added map[int]*RollingCounter
+	for code, c := range other.codes() {
+		o, ok := codes[code]
 		if ok {
 			if err := o.Append(c); err != nil {
+				m.codesMutex.Unlock()
 				return err
 			}
-		} else {
-			m.statusCodes[code] = c.Clone()
+			continue
 		}
+		if added == nil {
+			added = copyCodes(codes, len(codes)+1)
+		}
+		added[code] = c.Clone()
+	}
+	if added != nil {
+		m.statusCodes.Store(added)
+	}
+	m.codesMutex.Unlock()
+
+	// the histograms of the other metrics are locked second, so the metrics should not be appended to each other
+	// concurrently
+	m.histMutex.Lock()
+	defer m.histMutex.Unlock()
+	other.histMutex.Lock()
+	defer other.histMutex.Unlock()
+
+	if err := m.requestSizes.Append(other.requestSizes); err != nil {
+		return err
+	}
+
+	if err := m.responseSizes.Append(other.responseSizes); err != nil {
+		return err
 	}
 
 	return m.histogram.Append(other.histogram)
 }
 
 func (m *RTMetrics) Record(code int, duration time.Duration) {
+	m.RecordCode(code)
+	m.RecordLatency(duration)
+}
+
+// RecordCode counts the request with the response code, the 502 and 504 codes are counted as network errors
+func (m *RTMetrics) RecordCode(code int) {
 	m.total.Inc(1)
 	if code == http.StatusGatewayTimeout || code == http.StatusBadGateway {
 		m.netErrors.Inc(1)
 	}
 	m.recordStatusCode(code)
-	m.recordLatency(duration)
+}
+
+// RecordLatency records the latency of the request in the histogram
+func (m *RTMetrics) RecordLatency(d time.Duration) {
+	m.recordLatency(d)
+}
+
+// RecordSizes records the sizes of the request and response bodies in bytes, the negative sizes,
+// e.g. the unknown length of the chunked request, are skipped
+func (m *RTMetrics) RecordSizes(request, response int64) {
+	m.histMutex.Lock()
+	defer m.histMutex.Unlock()
+	m.recordSize(m.requestSizes, request)
+	m.recordSize(m.responseSizes, response)
 }
 
 // GetTotalCount returns total count of processed requests collected.
@@ -174,9 +286,9 @@ func (m *RTMetrics) NetworkErrorCount() int64 {
 // GetStatusCodesCounts returns map with counts of the response codes
 func (m *RTMetrics) StatusCodesCounts() map[int]int64 {
 	sc := make(map[int]int64)
-	for k, v := range m.statusCodes {
-		if v.Count() != 0 {
-			sc[k] = v.Count()
+	for k, v := range m.codes() {
+		if count := v.Count(); count != 0 {
+			sc[k] = count
 		}
 	}
 	return sc
@@ -184,14 +296,49 @@ func (m *RTMetrics) StatusCodesCounts() map[int]int64 {
 
 // GetLatencyHistogram computes and returns resulting histogram with latencies observed.
 func (m *RTMetrics) LatencyHistogram() (*HDRHistogram, error) {
-	return m.histogram.Merged()
+	return m.merged(m.histogram)
+}
+
+// RequestSizeHistogram returns the histogram of the request body sizes in bytes
+func (m *RTMetrics) RequestSizeHistogram() (*HDRHistogram, error) {
+	return m.merged(m.requestSizes)
+}
+
+// ResponseSizeHistogram returns the histogram of the response body sizes in bytes
+func (m *RTMetrics) ResponseSizeHistogram() (*HDRHistogram, error) {
+	return m.merged(m.responseSizes)
 }
 
+// Reset clears all counters and the histograms, e.g. for the admin actions and the tests
 func (m *RTMetrics) Reset() {
+	m.histMutex.Lock()
 	m.histogram.Reset()
+	m.requestSizes.Reset()
+	m.responseSizes.Reset()
+	m.histMutex.Unlock()
 	m.total.Reset()
 	m.netErrors.Reset()
-	m.statusCodes = make(map[int]*RollingCounter)
+	m.codesMutex.Lock()
+	m.statusCodes.Store(make(map[int]*RollingCounter))
+	m.codesMutex.Unlock()
+}
+
+func (m *RTMetrics) merged(h *RollingHDRHistogram) (*HDRHistogram, error) {
+	m.histMutex.Lock()
+	defer m.histMutex.Unlock()
+	return h.Merged()
+}
+
+func (m *RTMetrics) codes() map[int]*RollingCounter {
+	return m.statusCodes.Load().(map[int]*RollingCounter)
+}
+
+func copyCodes(codes map[int]*RollingCounter, size int) map[int]*RollingCounter {
+	out := make(map[int]*RollingCounter, size)
+	for code, c := range codes {
+		out[code] = c
+	}
+	return out
 }
 
 func (m *RTMetrics) recordNetError() error {
@@ -200,11 +347,39 @@ func (m *RTMetrics) recordNetError() error {
 }
 
 func (m *RTMetrics) recordLatency(d time.Duration) error {
+	m.histMutex.Lock()
+	defer m.histMutex.Unlock()
 	return m.histogram.RecordLatencies(d, 1)
 }
 
+func (m *RTMetrics) recordSize(h *RollingHDRHistogram, size int64) error {
+	if size < 0 {
+		return nil
+	}
+	if size >= sizeHistMax {
+		size = sizeHistMax - 1
+	}
+	return h.RecordValues(size, 1)
+}
+
+// newSizeHist returns the histogram of the body sizes rolling like the default latency histogram
+func (m *RTMetrics) newSizeHist() (*RollingHDRHistogram, error) {
+	if m.origin != nil {
+		return NewRollingHDRHistogram(histMin, sizeHistMax, histSignificantFigures, histPeriod, histBuckets, RollingClock(m.clock), RollingAlign(*m.origin))
+	}
+	return NewRollingHDRHistogram(histMin, sizeHistMax, histSignificantFigures, histPeriod, histBuckets, RollingClock(m.clock))
+}
+
 func (m *RTMetrics) recordStatusCode(statusCode int) error {
-	if c, ok := m.statusCodes[statusCode]; ok {
+	if c, ok := m.codes()[statusCode]; ok {
+		c.Inc(1)
+		return nil
+	}
+	m.codesMutex.Lock()
+	defer m.codesMutex.Unlock()
+
+	codes := m.codes()
+	if c, ok := codes[statusCode]; ok {
 		c.Inc(1)
 		return nil
 	}
@@ -213,7 +388,9 @@ func (m *RTMetrics) recordStatusCode(statusCode int) error {
 		return err
 	}
 	c.Inc(1)
-	m.statusCodes[statusCode] = c
+	codes = copyCodes(codes, len(codes)+1)
+	codes[statusCode] = c
+	m.statusCodes.Store(codes)
 	return nil
 }
 
@@ -225,4 +402,5 @@ const (
 	histSignificantFigures = 2                // signigicant figures (1% precision)
 	histBuckets            = 6                // number of sub-histograms in a rolling histogram
 	histPeriod             = 10 * time.Second // roll time
+	sizeHistMax            = 1 << 40          // 1 TiB, the larger bodies are recorded as the largest value
 )
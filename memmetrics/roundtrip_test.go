@@ -1,6 +1,8 @@
 package memmetrics
 
 import (
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/mailgun/timetools"
@@ -52,6 +54,38 @@ func (s *RRSuite) TestDefaults(c *C) {
 
 }
 
+func (s *RRSuite) TestSizes(c *C) {
+	rr, err := NewRTMetrics(RTClock(s.tm))
+	c.Assert(err, IsNil)
+
+	rr.RecordSizes(100, 1000)
+	rr.RecordSizes(-1, 3000)
+	rr.RecordSizes(300, 1<<50)
+
+	h, err := rr.RequestSizeHistogram()
+	c.Assert(err, IsNil)
+	c.Assert(h.ValueAtQuantile(50), Equals, int64(100))
+	c.Assert(h.ValueAtQuantile(100)/100, Equals, int64(3))
+
+	h, err = rr.ResponseSizeHistogram()
+	c.Assert(err, IsNil)
+	c.Assert(h.ValueAtQuantile(50)/100, Equals, int64(30))
+	c.Assert(h.ValueAtQuantile(100), Equals, int64(sizeHistMax-1))
+
+	other, err := NewRTMetrics(RTClock(s.tm))
+	c.Assert(err, IsNil)
+	other.RecordSizes(5000, 0)
+	c.Assert(rr.Append(other), IsNil)
+	h, err = rr.RequestSizeHistogram()
+	c.Assert(err, IsNil)
+	c.Assert(h.ValueAtQuantile(100)/100, Equals, int64(50))
+
+	rr.Reset()
+	h, err = rr.ResponseSizeHistogram()
+	c.Assert(err, IsNil)
+	c.Assert(h.ValueAtQuantile(100), Equals, int64(0))
+}
+
 func (s *RRSuite) TestAppend(c *C) {
 	rr, err := NewRTMetrics(RTClock(s.tm))
 	c.Assert(err, IsNil)
@@ -79,3 +113,108 @@ func (s *RRSuite) TestAppend(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(int(h.LatencyAtQuantile(100)/time.Second), Equals, 3)
 }
+
+func (s *RRSuite) TestAggregate(c *C) {
+	a, err := NewRTMetrics(RTClock(s.tm))
+	c.Assert(err, IsNil)
+	a.Record(200, time.Second)
+	a.Record(502, time.Second)
+
+	b, err := NewRTMetrics(RTClock(s.tm))
+	c.Assert(err, IsNil)
+	b.Record(200, 4*time.Second)
+
+	out, err := AggregateRTMetrics(a, b)
+	c.Assert(err, IsNil)
+	c.Assert(out.TotalCount(), Equals, int64(3))
+	c.Assert(out.NetworkErrorCount(), Equals, int64(1))
+	c.Assert(out.StatusCodesCounts(), DeepEquals, map[int]int64{200: 2, 502: 1})
+
+	h, err := out.LatencyHistogram()
+	c.Assert(err, IsNil)
+	c.Assert(int(h.LatencyAtQuantile(100)/time.Second), Equals, 4)
+
+	// source metrics are left intact
+	c.Assert(a.TotalCount(), Equals, int64(2))
+	c.Assert(b.TotalCount(), Equals, int64(1))
+
+	_, err = AggregateRTMetrics()
+	c.Assert(err, NotNil)
+}
+
+func (s *RRSuite) TestAlign(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	rr, err := NewRTMetrics(RTClock(clock), RTAlign(time.Date(2012, 3, 4, 0, 0, 0, 0, time.UTC)))
+	c.Assert(err, IsNil)
+
+	rr.Record(200, time.Second)
+	rr.Record(502, 2*time.Second)
+	// all counters and the histogram expire at the same time
+	clock.CurrentTime = time.Date(2012, 3, 4, 5, 7, 10, 0, time.UTC)
+	c.Assert(rr.TotalCount(), Equals, int64(0))
+	c.Assert(rr.NetworkErrorRatio(), Equals, 0.0)
+	h, err := rr.LatencyHistogram()
+	c.Assert(err, IsNil)
+	c.Assert(h.LatencyAtQuantile(100), Equals, time.Duration(0))
+
+	rr.Record(200, time.Second)
+	rr.Reset()
+	c.Assert(rr.TotalCount(), Equals, int64(0))
+	c.Assert(rr.StatusCodesCounts(), DeepEquals, map[int]int64{})
+}
+
+func (s *RRSuite) TestConcurrentRecord(c *C) {
+	rr, err := NewRTMetrics(RTClock(s.tm))
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(code int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				rr.Record(code, time.Millisecond)
+				rr.RecordSizes(10, 100)
+			}
+		}(200 + i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := rr.Snapshot()
+				c.Check(err, IsNil)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(rr.TotalCount(), Equals, int64(2000))
+	c.Assert(rr.StatusCodesCounts(), DeepEquals, map[int]int64{200: 500, 201: 500, 202: 500, 203: 500})
+	c.Assert(rr.Append(rr), NotNil)
+}
+
+func BenchmarkRecord(b *testing.B) {
+	rr, err := NewRTMetrics()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rr.Record(200, time.Millisecond)
+		}
+	})
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	rr, err := NewRTMetrics()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		rr.Record(200+i%5, time.Duration(i)*time.Millisecond)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr.Snapshot()
+	}
+}
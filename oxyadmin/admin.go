@@ -0,0 +1,390 @@
+// Package oxyadmin provides http handler exposing the runtime state of the oxy middlewares
+// as JSON, e.g. load balancer servers and weights, circuit breaker states, rate limits and connection counts.
+//
+// Read only endpoints:
+//
+//	GET /v1/status                            - state of all registered components
+//	GET /v1/lbs/<name>/servers                - servers and weights of the load balancer
+//	GET /v1/breakers/<name>                   - state of the circuit breaker
+//	GET /v1/ratelimits/<name>?source=<source> - token buckets of the rate limiter for the given source
+//	GET /v1/connlimits/<name>                 - connection counts of the connection limiter
+//
+// Mutating endpoints and the ones exposing the captured traffic require the token to be passed in the Authorization header (Authorization: Bearer <token>)
+// and are disabled unless the token is set:
+//
+//	POST /v1/lbs/<name>/servers/drain url=<server url> - sets the weight of the server to 0, so it stops getting new requests
+//	POST /v1/breakers/<name>/reset                     - forces the circuit breaker into the standby state
+//	GET  /v1/captures/<name>                           - exchanges captured by the forwarder, see forward.CaptureTraffic
+package oxyadmin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/ratelimit"
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/utils"
+)
+
+// LoadBalancer is implemented by roundrobin.RoundRobin and roundrobin.Rebalancer
+type LoadBalancer interface {
+	Servers() []*url.URL
+	ServerWeight(u *url.URL) (int, bool)
+	UpsertServer(u *url.URL, options ...roundrobin.ServerOption) error
+}
+
+// Breaker is implemented by cbreaker.CircuitBreaker
+type Breaker interface {
+	State() string
+	Reset()
+}
+
+// RateLimiter is implemented by ratelimit.TokenLimiter
+type RateLimiter interface {
+	Stats(source string) ([]ratelimit.BucketStats, bool)
+}
+
+// ConnLimiter is implemented by connlimit.ConnLimiter
+type ConnLimiter interface {
+	Connections() map[string]int64
+	TotalConnections() int64
+}
+
+// Captures is implemented by forward.CaptureBuffer
+type Captures interface {
+	Captures() []forward.Capture
+}
+
+// Option is a functional option setter for Admin
+type Option func(*Admin) error
+
+// Token sets the token guarding mutating endpoints
+func Token(token string) Option {
+	return func(a *Admin) error {
+		a.token = token
+		return nil
+	}
+}
+
+// WithLoadBalancer registers the load balancer under the given name
+func WithLoadBalancer(name string, lb LoadBalancer) Option {
+	return func(a *Admin) error {
+		if _, ok := a.lbs[name]; ok {
+			return fmt.Errorf("load balancer %v is already registered", name)
+		}
+		a.lbs[name] = lb
+		return nil
+	}
+}
+
+// WithBreaker registers the circuit breaker under the given name
+func WithBreaker(name string, b Breaker) Option {
+	return func(a *Admin) error {
+		if _, ok := a.breakers[name]; ok {
+			return fmt.Errorf("breaker %v is already registered", name)
+		}
+		a.breakers[name] = b
+		return nil
+	}
+}
+
+// WithRateLimiter registers the rate limiter under the given name
+func WithRateLimiter(name string, l RateLimiter) Option {
+	return func(a *Admin) error {
+		if _, ok := a.rateLimiters[name]; ok {
+			return fmt.Errorf("rate limiter %v is already registered", name)
+		}
+		a.rateLimiters[name] = l
+		return nil
+	}
+}
+
+// WithConnLimiter registers the connection limiter under the given name
+func WithConnLimiter(name string, l ConnLimiter) Option {
+	return func(a *Admin) error {
+		if _, ok := a.connLimiters[name]; ok {
+			return fmt.Errorf("connection limiter %v is already registered", name)
+		}
+		a.connLimiters[name] = l
+		return nil
+	}
+}
+
+// WithCaptures registers the buffer of the captured traffic under the given name
+func WithCaptures(name string, c Captures) Option {
+	return func(a *Admin) error {
+		if _, ok := a.captures[name]; ok {
+			return fmt.Errorf("captures %v are already registered", name)
+		}
+		a.captures[name] = c
+		return nil
+	}
+}
+
+// Logger sets the logger used to report admin actions
+func Logger(l utils.Logger) Option {
+	return func(a *Admin) error {
+		a.log = l
+		return nil
+	}
+}
+
+// Admin is http.Handler serving the admin API
+type Admin struct {
+	token        string
+	lbs          map[string]LoadBalancer
+	breakers     map[string]Breaker
+	rateLimiters map[string]RateLimiter
+	connLimiters map[string]ConnLimiter
+	captures     map[string]Captures
+	log          utils.Logger
+}
+
+// New returns a new admin handler with the components registered via options
+func New(opts ...Option) (*Admin, error) {
+	a := &Admin{
+		lbs:          make(map[string]LoadBalancer),
+		breakers:     make(map[string]Breaker),
+		rateLimiters: make(map[string]RateLimiter),
+		connLimiters: make(map[string]ConnLimiter),
+		captures:     make(map[string]Captures),
+	}
+	for _, o := range opts {
+		if err := o(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.log == nil {
+		a.log = utils.NullLogger
+	}
+	return a, nil
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		replyError(w, http.StatusNotFound, fmt.Errorf("not found: %v", req.URL.Path))
+		return
+	}
+	parts = parts[1:]
+
+	switch {
+	case req.Method == "GET" && len(parts) == 1 && parts[0] == "status":
+		a.getStatus(w, req)
+	case req.Method == "GET" && len(parts) == 3 && parts[0] == "lbs" && parts[2] == "servers":
+		a.getServers(w, req, parts[1])
+	case req.Method == "POST" && len(parts) == 4 && parts[0] == "lbs" && parts[2] == "servers" && parts[3] == "drain":
+		a.withToken(w, req, func() { a.drainServer(w, req, parts[1]) })
+	case req.Method == "GET" && len(parts) == 2 && parts[0] == "breakers":
+		a.getBreaker(w, req, parts[1])
+	case req.Method == "POST" && len(parts) == 3 && parts[0] == "breakers" && parts[2] == "reset":
+		a.withToken(w, req, func() { a.resetBreaker(w, req, parts[1]) })
+	case req.Method == "GET" && len(parts) == 2 && parts[0] == "ratelimits":
+		a.getRateLimit(w, req, parts[1])
+	case req.Method == "GET" && len(parts) == 2 && parts[0] == "connlimits":
+		a.getConnLimit(w, req, parts[1])
+	case req.Method == "GET" && len(parts) == 2 && parts[0] == "captures":
+		a.withToken(w, req, func() { a.getCaptures(w, req, parts[1]) })
+	default:
+		replyError(w, http.StatusNotFound, fmt.Errorf("not found: %v %v", req.Method, req.URL.Path))
+	}
+}
+
+// Status contains the state of all registered components
+type Status struct {
+	LoadBalancers map[string][]Server   `json:"load_balancers"`
+	Breakers      map[string]string     `json:"breakers"`
+	ConnLimits    map[string]ConnCounts `json:"conn_limits"`
+}
+
+// Server is a load balancer server and its current weight
+type Server struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// ConnCounts contains connection counts of the connection limiter
+type ConnCounts struct {
+	Total   int64            `json:"total"`
+	Sources map[string]int64 `json:"sources"`
+}
+
+// Bucket represents the state of the rate limiter token bucket
+type Bucket struct {
+	Period    string `json:"period"`
+	Average   int64  `json:"average"`
+	Burst     int64  `json:"burst"`
+	Available int64  `json:"available"`
+}
+
+func (a *Admin) getStatus(w http.ResponseWriter, req *http.Request) {
+	st := Status{
+		LoadBalancers: make(map[string][]Server, len(a.lbs)),
+		Breakers:      make(map[string]string, len(a.breakers)),
+		ConnLimits:    make(map[string]ConnCounts, len(a.connLimiters)),
+	}
+	for name, lb := range a.lbs {
+		st.LoadBalancers[name] = servers(lb)
+	}
+	for name, b := range a.breakers {
+		st.Breakers[name] = b.State()
+	}
+	for name, l := range a.connLimiters {
+		st.ConnLimits[name] = connCounts(l)
+	}
+	reply(w, http.StatusOK, st)
+}
+
+func (a *Admin) getServers(w http.ResponseWriter, req *http.Request, name string) {
+	lb, ok := a.lbs[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("load balancer %v not found", name))
+		return
+	}
+	reply(w, http.StatusOK, servers(lb))
+}
+
+func (a *Admin) drainServer(w http.ResponseWriter, req *http.Request, name string) {
+	lb, ok := a.lbs[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("load balancer %v not found", name))
+		return
+	}
+	u, err := url.Parse(req.FormValue("url"))
+	if err != nil || u.Host == "" {
+		replyError(w, http.StatusBadRequest, fmt.Errorf("provide valid server url, got %q", req.FormValue("url")))
+		return
+	}
+	if _, ok := lb.ServerWeight(u); !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("server %v not found", u))
+		return
+	}
+	if err := lb.UpsertServer(u, roundrobin.Weight(0)); err != nil {
+		replyError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.log.Infof("drained server %v of load balancer %v", u, name)
+	reply(w, http.StatusOK, servers(lb))
+}
+
+func (a *Admin) getBreaker(w http.ResponseWriter, req *http.Request, name string) {
+	b, ok := a.breakers[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("breaker %v not found", name))
+		return
+	}
+	reply(w, http.StatusOK, map[string]string{"state": b.State()})
+}
+
+func (a *Admin) resetBreaker(w http.ResponseWriter, req *http.Request, name string) {
+	b, ok := a.breakers[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("breaker %v not found", name))
+		return
+	}
+	b.Reset()
+	a.log.Infof("reset breaker %v", name)
+	reply(w, http.StatusOK, map[string]string{"state": b.State()})
+}
+
+func (a *Admin) getRateLimit(w http.ResponseWriter, req *http.Request, name string) {
+	l, ok := a.rateLimiters[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("rate limiter %v not found", name))
+		return
+	}
+	source := req.FormValue("source")
+	if source == "" {
+		replyError(w, http.StatusBadRequest, fmt.Errorf("provide source"))
+		return
+	}
+	stats, ok := l.Stats(source)
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("source %v not found", source))
+		return
+	}
+	out := make([]Bucket, len(stats))
+	for i, s := range stats {
+		out[i] = Bucket{
+			Period:    s.Period.String(),
+			Average:   s.Average,
+			Burst:     s.Burst,
+			Available: s.Available,
+		}
+	}
+	reply(w, http.StatusOK, out)
+}
+
+func (a *Admin) getConnLimit(w http.ResponseWriter, req *http.Request, name string) {
+	l, ok := a.connLimiters[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("connection limiter %v not found", name))
+		return
+	}
+	reply(w, http.StatusOK, connCounts(l))
+}
+
+func (a *Admin) getCaptures(w http.ResponseWriter, req *http.Request, name string) {
+	c, ok := a.captures[name]
+	if !ok {
+		replyError(w, http.StatusNotFound, fmt.Errorf("captures %v not found", name))
+		return
+	}
+	reply(w, http.StatusOK, c.Captures())
+}
+
+// withToken calls fn only if the request carries the valid admin token
+func (a *Admin) withToken(w http.ResponseWriter, req *http.Request, fn func()) {
+	if a.token == "" {
+		replyError(w, http.StatusForbidden, fmt.Errorf("endpoints requiring the token are disabled"))
+		return
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		replyError(w, http.StatusUnauthorized, fmt.Errorf("bad token"))
+		return
+	}
+	fn()
+}
+
+func servers(lb LoadBalancer) []Server {
+	urls := lb.Servers()
+	out := make([]Server, 0, len(urls))
+	for _, u := range urls {
+		weight, ok := lb.ServerWeight(u)
+		if !ok {
+			// server has been removed in the meantime
+			continue
+		}
+		out = append(out, Server{URL: u.String(), Weight: weight})
+	}
+	sort.Sort(serversByURL(out))
+	return out
+}
+
+func connCounts(l ConnLimiter) ConnCounts {
+	return ConnCounts{Total: l.TotalConnections(), Sources: l.Connections()}
+}
+
+type serversByURL []Server
+
+func (s serversByURL) Len() int           { return len(s) }
+func (s serversByURL) Less(i, j int) bool { return s[i].URL < s[j].URL }
+func (s serversByURL) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func reply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func replyError(w http.ResponseWriter, code int, err error) {
+	reply(w, code, map[string]string{"error": err.Error()})
+}
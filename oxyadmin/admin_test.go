@@ -0,0 +1,243 @@
+package oxyadmin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/cbreaker"
+	"github.com/mailgun/oxy/connlimit"
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/ratelimit"
+	"github.com/mailgun/oxy/roundrobin"
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestAdmin(t *testing.T) { TestingT(t) }
+
+type AdminSuite struct{}
+
+var _ = Suite(&AdminSuite{})
+
+func (s *AdminSuite) TestServers(c *C) {
+	lb := newLB(c, "http://localhost:5000", "http://localhost:5001")
+
+	a, err := New(WithLoadBalancer("main", lb), Token("secret"))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL + "/v1/lbs/main/servers")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	var servers []Server
+	c.Assert(json.Unmarshal(body, &servers), IsNil)
+	c.Assert(servers, DeepEquals, []Server{{URL: "http://localhost:5000", Weight: 1}, {URL: "http://localhost:5001", Weight: 1}})
+
+	re, _, err = testutils.Get(srv.URL + "/v1/lbs/missing/servers")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *AdminSuite) TestDrain(c *C) {
+	lb := newLB(c, "http://localhost:5000", "http://localhost:5001")
+
+	a, err := New(WithLoadBalancer("main", lb), Token("secret"))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	drain := srv.URL + "/v1/lbs/main/servers/drain"
+
+	// no token
+	re, _, err := testutils.MakeRequest(drain, testutils.Method("POST"), formBody("url=http://localhost:5000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnauthorized)
+
+	// unknown server
+	re, _, err = testutils.MakeRequest(drain, testutils.Method("POST"), bearer("secret"), formBody("url=http://localhost:6000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+
+	re, _, err = testutils.MakeRequest(drain, testutils.Method("POST"), bearer("secret"), formBody("url=http://localhost:5000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	w, ok := lb.ServerWeight(testutils.ParseURI("http://localhost:5000"))
+	c.Assert(ok, Equals, true)
+	c.Assert(w, Equals, 0)
+
+	for i := 0; i < 3; i++ {
+		u, err := lb.NextServer()
+		c.Assert(err, IsNil)
+		c.Assert(u.String(), Equals, "http://localhost:5001")
+	}
+}
+
+func (s *AdminSuite) TestMutatingDisabledWithoutToken(c *C) {
+	lb := newLB(c, "http://localhost:5000")
+
+	a, err := New(WithLoadBalancer("main", lb))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	re, _, err := testutils.MakeRequest(srv.URL+"/v1/lbs/main/servers/drain", testutils.Method("POST"), bearer(""), formBody("url=http://localhost:5000"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusForbidden)
+}
+
+func (s *AdminSuite) TestBreaker(c *C) {
+	cb, err := cbreaker.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), `NetworkErrorRatio() > 0.5`)
+	c.Assert(err, IsNil)
+
+	a, err := New(WithBreaker("cb", cb), Token("secret"))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL + "/v1/breakers/cb")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, `{"state":"standby"}`+"\n")
+
+	re, body, err = testutils.MakeRequest(srv.URL+"/v1/breakers/cb/reset", testutils.Method("POST"), bearer("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, `{"state":"standby"}`+"\n")
+}
+
+func (s *AdminSuite) TestRateLimit(c *C) {
+	rates := ratelimit.NewRateSet()
+	rates.Add(time.Second, 10, 20)
+	tl, err := ratelimit.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), headerSource, rates)
+	c.Assert(err, IsNil)
+
+	limited := httptest.NewServer(tl)
+	defer limited.Close()
+	re, _, err := testutils.Get(limited.URL, testutils.Header("Source", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	a, err := New(WithRateLimiter("rl", tl))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL + "/v1/ratelimits/rl?source=a")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	var buckets []Bucket
+	c.Assert(json.Unmarshal(body, &buckets), IsNil)
+	c.Assert(len(buckets), Equals, 1)
+	c.Assert(buckets[0].Period, Equals, "1s")
+	c.Assert(buckets[0].Average, Equals, int64(10))
+	c.Assert(buckets[0].Burst, Equals, int64(20))
+
+	re, _, err = testutils.Get(srv.URL + "/v1/ratelimits/rl?source=b")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *AdminSuite) TestStatus(c *C) {
+	lb := newLB(c, "http://localhost:5000")
+	cl, err := connlimit.New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), headerSource, 10)
+	c.Assert(err, IsNil)
+
+	a, err := New(WithLoadBalancer("main", lb), WithConnLimiter("cl", cl))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL + "/v1/status")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	var st Status
+	c.Assert(json.Unmarshal(body, &st), IsNil)
+	c.Assert(st.LoadBalancers["main"], DeepEquals, []Server{{URL: "http://localhost:5000", Weight: 1}})
+	c.Assert(st.ConnLimits["cl"].Total, Equals, int64(0))
+}
+
+func (s *AdminSuite) TestCaptures(c *C) {
+	backend := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer backend.Close()
+	b, err := forward.NewCaptureBuffer(10, 100)
+	c.Assert(err, IsNil)
+	fwd, err := forward.New(forward.CaptureTraffic(b, 1))
+	c.Assert(err, IsNil)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend.URL)
+		fwd.ServeHTTP(w, req)
+	}))
+	defer proxy.Close()
+	_, _, err = testutils.Get(proxy.URL)
+	c.Assert(err, IsNil)
+
+	a, err := New(WithCaptures("main", b), Token("secret"))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	// the captured headers may carry the credentials
+	re, _, err := testutils.Get(srv.URL + "/v1/captures/main")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnauthorized)
+
+	re, body, err := testutils.Get(srv.URL+"/v1/captures/main", bearer("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	var captures []forward.Capture
+	c.Assert(json.Unmarshal(body, &captures), IsNil)
+	c.Assert(len(captures), Equals, 1)
+	c.Assert(string(captures[0].ResponseBody), Equals, "hello")
+
+	re, _, err = testutils.Get(srv.URL+"/v1/captures/missing", bearer("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *AdminSuite) TestDuplicateName(c *C) {
+	lb := newLB(c, "http://localhost:5000")
+	_, err := New(WithLoadBalancer("main", lb), WithLoadBalancer("main", lb))
+	c.Assert(err, NotNil)
+}
+
+func newLB(c *C, urls ...string) *roundrobin.RoundRobin {
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+	lb, err := roundrobin.New(fwd)
+	c.Assert(err, IsNil)
+	for _, u := range urls {
+		c.Assert(lb.UpsertServer(testutils.ParseURI(u)), IsNil)
+	}
+	return lb
+}
+
+func bearer(token string) testutils.ReqOption {
+	return testutils.Header("Authorization", "Bearer "+token)
+}
+
+func formBody(body string) testutils.ReqOption {
+	return func(o *testutils.ReqOpts) error {
+		o.Body = body
+		if o.Headers == nil {
+			o.Headers = make(http.Header)
+		}
+		o.Headers.Set("Content-Type", "application/x-www-form-urlencoded")
+		return nil
+	}
+}
+
+var headerSource = utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+	return req.Header.Get("Source"), 1, nil
+})
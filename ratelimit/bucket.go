@@ -28,6 +28,8 @@ type tokenBucket struct {
 	// number of available tokens. It effectively caches the value that could
 	// have been otherwise deduced from refillRate.
 	timePerToken time.Duration
+	// The number of tokens added to the bucket every period.
+	average int64
 	// The maximum number of tokens that can be accumulate in the bucket.
 	burst int64
 	// The number of tokens available for consumption at the moment. It can
@@ -46,6 +48,7 @@ func newTokenBucket(rate *rate, clock timetools.TimeProvider) *tokenBucket {
 	return &tokenBucket{
 		period:          rate.period,
 		timePerToken:    time.Duration(int64(rate.period) / rate.average),
+		average:         rate.average,
 		burst:           rate.burst,
 		clock:           clock,
 		lastRefresh:     clock.UtcNow(),
@@ -83,6 +86,15 @@ func (tb *tokenBucket) rollback() {
 	tb.lastConsumed = 0
 }
 
+// refund returns the tokens consumed earlier, the bucket never holds more than burst tokens
+func (tb *tokenBucket) refund(tokens int64) {
+	tb.updateAvailableTokens()
+	tb.availableTokens += tokens
+	if tb.availableTokens > tb.burst {
+		tb.availableTokens = tb.burst
+	}
+}
+
 // Update modifies `average` and `burst` fields of the token bucket according
 // to the provided `Rate`
 func (tb *tokenBucket) update(rate *rate) error {
@@ -90,6 +102,7 @@ func (tb *tokenBucket) update(rate *rate) error {
 		return fmt.Errorf("Period mismatch: %v != %v", tb.period, rate.period)
 	}
 	tb.timePerToken = time.Duration(int64(tb.period) / rate.average)
+	tb.average = rate.average
 	tb.burst = rate.burst
 	if tb.availableTokens > rate.burst {
 		tb.availableTokens = rate.burst
@@ -99,9 +112,10 @@ func (tb *tokenBucket) update(rate *rate) error {
 
 // timeTillAvailable returns the number of nanoseconds that we need to
 // wait until the specified number of tokens becomes available for consumption.
+// The tokens are refilled counting from the last refresh, so the time passed since then is taken off.
 func (tb *tokenBucket) timeTillAvailable(tokens int64) time.Duration {
 	missingTokens := tokens - tb.availableTokens
-	return time.Duration(missingTokens) * tb.timePerToken
+	return time.Duration(missingTokens)*tb.timePerToken - tb.clock.UtcNow().Sub(tb.lastRefresh)
 }
 
 // updateAvailableTokens updates the number of tokens available for consumption.
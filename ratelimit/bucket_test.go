@@ -62,17 +62,17 @@ func (s *BucketSuite) TestFastConsumption(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(delay, Equals, time.Duration(0))
 
-	// Try 200 ms later
+	// Try 200 ms later, the token is refilled in the rest of the second
 	s.clock.Sleep(time.Millisecond * 200)
 	delay, err = tb.consume(1)
 	c.Assert(err, IsNil)
-	c.Assert(delay, Equals, time.Second)
+	c.Assert(delay, Equals, time.Millisecond*800)
 
 	// Try 700 ms later
 	s.clock.Sleep(time.Millisecond * 700)
 	delay, err = tb.consume(1)
 	c.Assert(err, IsNil)
-	c.Assert(delay, Equals, time.Second)
+	c.Assert(delay, Equals, time.Millisecond*100)
 
 	// Try 100 ms later, success!
 	s.clock.Sleep(time.Millisecond * 100)
@@ -80,6 +80,54 @@ func (tbs *tokenBucketSet) consume(tokens int64) (time.Duration, error) {
 	return maxDelay, firstErr
 }
 
+// rollback reverts the most recent consumption of all buckets
+func (tbs *tokenBucketSet) rollback() {
+	for _, tokenBucket := range tbs.buckets {
+		tokenBucket.rollback()
+	}
+}
+
+// available returns the minimum of the tokens available in the buckets
+func (tbs *tokenBucketSet) available() int64 {
+	min := int64(-1)
+	for _, bucket := range tbs.buckets {
+		bucket.updateAvailableTokens()
+		if min == -1 || bucket.availableTokens < min {
+			min = bucket.availableTokens
+		}
+	}
+	return min
+}
+
+// refund returns the tokens consumed earlier to all buckets
+func (tbs *tokenBucketSet) refund(tokens int64) {
+	for _, tokenBucket := range tbs.buckets {
+		tokenBucket.refund(tokens)
+	}
+}
+
+// stats returns the current state of the buckets sorted by period
+func (tbs *tokenBucketSet) stats() []BucketStats {
+	out := make([]BucketStats, 0, len(tbs.buckets))
+	for _, bucket := range tbs.buckets {
+		bucket.updateAvailableTokens()
+		out = append(out, BucketStats{
+			Period:    bucket.period,
+			Average:   bucket.average,
+			Burst:     bucket.burst,
+			Available: bucket.availableTokens,
+		})
+	}
+	sort.Sort(statsByPeriod(out))
+	return out
+}
+
+type statsByPeriod []BucketStats
+
+func (s statsByPeriod) Len() int           { return len(s) }
+func (s statsByPeriod) Less(i, j int) bool { return s[i].Period < s[j].Period }
+func (s statsByPeriod) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // debugState returns string that reflects the current state of all buckets in
 // this set. It is intended to be used for debugging and testing only.
 func (tbs *tokenBucketSet) debugState() string {
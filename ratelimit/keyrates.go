@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/ttlmap"
+)
+
+// KeyRateExtractor returns the rates of the source key, e.g. the higher rates of the premium API keys.
+// The request is nil for the keys limited with Allow.
+type KeyRateExtractor interface {
+	ExtractKey(key string, r *http.Request) (*RateSet, error)
+}
+
+type KeyRateExtractorFunc func(key string, r *http.Request) (*RateSet, error)
+
+func (e KeyRateExtractorFunc) ExtractKey(key string, r *http.Request) (*RateSet, error) {
+	return e(key, r)
+}
+
+// ExtractKeyRates sets the extractor of the rates of the source keys, the rates are cached per key for the ttl,
+// at least a second, so the extractor is not asked on every request, e.g. when it looks the plan up in the database.
+// The failed extractions are not cached and the default rates are applied, as with ExtractRates.
+func ExtractKeyRates(e KeyRateExtractor, ttl time.Duration) TokenLimiterOption {
+	return func(cl *TokenLimiter) error {
+		if e == nil {
+			return fmt.Errorf("key rate extractor can not be nil")
+		}
+		if ttl < time.Second {
+			return fmt.Errorf("key rates ttl should be >= 1s, got %v", ttl)
+		}
+		cl.extractKeyRates = e
+		cl.keyRatesTTL = int(ttl / time.Second)
+		return nil
+	}
+}
+
+// keyRates returns the rates of the key, cached or extracted, or nil for the default rates. It's called
+// without the lock held, as the extractor may take time.
+func (tl *TokenLimiter) keyRates(key string, req *http.Request) *RateSet {
+	tl.mutex.Lock()
+	cached, ok := tl.keyRatesCache.Get(key)
+	tl.mutex.Unlock()
+	if ok {
+		return nonEmpty(cached.(*RateSet))
+	}
+
+	rates, err := tl.extractKeyRates.ExtractKey(key, req)
+	if err != nil {
+		tl.log.Errorf("Failed to retrieve rates of %v: %v", key, err)
+		return nil
+	}
+	if rates == nil {
+		// the defaults are cached as the empty set, so they may still change with SetDefaultRates
+		rates = NewRateSet()
+	}
+	tl.mutex.Lock()
+	tl.keyRatesCache.Set(key, rates, tl.keyRatesTTL)
+	tl.mutex.Unlock()
+	return nonEmpty(rates)
+}
+
+func nonEmpty(rates *RateSet) *RateSet {
+	if len(rates.m) == 0 {
+		return nil
+	}
+	return rates
+}
+
+func (tl *TokenLimiter) newKeyRatesCache() error {
+	if tl.extractKeyRates == nil {
+		return nil
+	}
+	if tl.extractRates != nil {
+		return fmt.Errorf("ExtractRates and ExtractKeyRates can not be used together")
+	}
+	cache, err := ttlmap.NewMapWithProvider(tl.capacity, tl.clock)
+	if err != nil {
+		return err
+	}
+	tl.keyRatesCache = cache
+	return nil
+}
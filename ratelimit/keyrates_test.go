@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type KeyRatesSuite struct{}
+
+var _ = Suite(&KeyRatesSuite{})
+
+// plans returns the extractor giving the premium key 3 requests per second and counting the calls per key
+func plans(calls map[string]int) KeyRateExtractor {
+	return KeyRateExtractorFunc(func(key string, req *http.Request) (*RateSet, error) {
+		calls[key]++
+		switch key {
+		case "premium":
+			return rates(3), nil
+		case "broken":
+			return nil, fmt.Errorf("boom")
+		}
+		return nil, nil
+	})
+}
+
+func allowed(l *TokenLimiter, key string) int {
+	n := 0
+	for i := 0; i < 10; i++ {
+		if d, _ := l.Allow(key, 1); d.Allowed {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *KeyRatesSuite) TestKeyRates(c *C) {
+	clock := testutils.NewClock()
+	calls := map[string]int{}
+	l, err := NewLimiter(rates(1), Clock(clock), ExtractKeyRates(plans(calls), time.Minute))
+	c.Assert(err, IsNil)
+
+	c.Assert(allowed(l, "premium"), Equals, 3)
+	c.Assert(allowed(l, "free"), Equals, 1)
+	c.Assert(allowed(l, "broken"), Equals, 1)
+
+	// the extracted rates are cached, the failures are not
+	c.Assert(calls, DeepEquals, map[string]int{"premium": 1, "free": 1, "broken": 10})
+
+	// the cached defaults follow the changes of the default rates
+	c.Assert(l.SetDefaultRates(rates(2)), IsNil)
+	clock.Advance(time.Second)
+	c.Assert(allowed(l, "free"), Equals, 2)
+	c.Assert(calls["free"], Equals, 1)
+
+	// the rates are extracted again once the cache expires
+	clock.Advance(2 * time.Minute)
+	c.Assert(allowed(l, "premium"), Equals, 3)
+	c.Assert(calls["premium"], Equals, 2)
+}
+
+func (s *KeyRatesSuite) TestRequests(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	calls := map[string]int{}
+	l, err := New(handler, headerLimit, rates(1), Clock(testutils.NewClock()), ExtractKeyRates(plans(calls), time.Minute))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	for _, key := range []string{"premium", "free"} {
+		codes := []int{}
+		for i := 0; i < 4; i++ {
+			re, _, err := testutils.Get(srv.URL, testutils.Header("Source", key))
+			c.Assert(err, IsNil)
+			codes = append(codes, re.StatusCode)
+		}
+		expected := map[string][]int{"premium": {200, 200, 200, 429}, "free": {200, 429, 429, 429}}
+		c.Assert(codes, DeepEquals, expected[key], Commentf(key))
+	}
+}
+
+func (s *KeyRatesSuite) TestBadOptions(c *C) {
+	calls := map[string]int{}
+	for _, opts := range [][]TokenLimiterOption{
+		{ExtractKeyRates(nil, time.Minute)},
+		{ExtractKeyRates(plans(calls), time.Millisecond)},
+		{ExtractKeyRates(plans(calls), time.Minute), ExtractRates(RateExtractorFunc(func(*http.Request) (*RateSet, error) { return nil, nil }))},
+	} {
+		_, err := NewLimiter(rates(1), opts...)
+		c.Assert(err, NotNil)
+	}
+	_, err := NewNested(nil, []Level{{Name: "a", Rates: rates(1)}}, ExtractKeyRates(plans(calls), time.Minute))
+	c.Assert(err, NotNil)
+}
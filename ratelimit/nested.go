@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+const (
+	// RemainingHeader is set by NestedLimiter to the tokens remaining at every level, e.g. "global=99, user=4"
+	RemainingHeader = "X-RateLimit-Remaining"
+	// LevelHeader names the exhausted level of the rejected request
+	LevelHeader = "X-RateLimit-Level"
+)
+
+// Level is one level of NestedLimiter, e.g. the tenant
+type Level struct {
+	// Name identifies the level in the headers and the errors, e.g. "global", "tenant" or "user"
+	Name string
+	// Extract returns the key of the level and the tokens the request consumes, nil stands for a single
+	// key for all requests consuming one token, i.e. the global limit
+	Extract utils.SourceExtractor
+	Rates   *RateSet
+}
+
+// NestedLimiter enforces the chain of limits, e.g. global → tenant → user, with a single decision:
+// the request is rejected if any level is exhausted, and then no level is charged for it.
+type NestedLimiter struct {
+	levels []Level
+	tl     *TokenLimiter
+}
+
+// NewNested returns the limiter of the levels evaluated in order, the options are the ones
+// of TokenLimiter except ExtractRates, which is not supported
+func NewNested(next http.Handler, levels []Level, opts ...TokenLimiterOption) (*NestedLimiter, error) {
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("Provide levels")
+	}
+	names := make(map[string]bool)
+	for _, l := range levels {
+		if l.Name == "" || strings.ContainsAny(l.Name, "=, ") {
+			return nil, fmt.Errorf("Invalid level name: %q", l.Name)
+		}
+		if names[l.Name] {
+			return nil, fmt.Errorf("Duplicate level: %v", l.Name)
+		}
+		names[l.Name] = true
+		if l.Rates == nil || len(l.Rates.m) == 0 {
+			return nil, fmt.Errorf("Provide rates of level %v", l.Name)
+		}
+	}
+	tl := &TokenLimiter{next: next}
+	for _, o := range opts {
+		if err := o(tl); err != nil {
+			return nil, err
+		}
+	}
+	if tl.extractRates != nil || tl.extractKeyRates != nil {
+		return nil, fmt.Errorf("ExtractRates is not supported by nested limiter")
+	}
+	setDefaults(tl)
+	buckets, err := newBucketShards(tl.shards, tl.capacity, tl.clock)
+	if err != nil {
+		return nil, err
+	}
+	tl.buckets = buckets
+	return &NestedLimiter{levels: levels, tl: tl}, nil
+}
+
+func (n *NestedLimiter) Wrap(next http.Handler) {
+	n.tl.next = next
+}
+
+func (n *NestedLimiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	keys := make([]string, len(n.levels))
+	amounts := make([]int64, len(n.levels))
+	for i, l := range n.levels {
+		if l.Extract == nil {
+			amounts[i] = 1
+			continue
+		}
+		key, amount, err := l.Extract.Extract(req)
+		if err != nil {
+			n.tl.errHandler.ServeHTTP(w, req, err)
+			return
+		}
+		keys[i], amounts[i] = key, amount
+	}
+
+	remaining, err := n.consume(keys, amounts)
+	if err != nil {
+		n.tl.log.Infof("limiting request %v %v, limit: %v", req.Method, req.URL, err)
+		n.tl.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	w.Header().Set(RemainingHeader, remaining)
+	n.tl.next.ServeHTTP(w, req)
+}
+
+// consume charges all levels or none of them, it returns the remaining tokens formatted for the header
+func (n *NestedLimiter) consume(keys []string, amounts []int64) (string, error) {
+	// the level name is part of the key, so the same key at different levels has different buckets
+	sources := make([]string, len(n.levels))
+	for i, l := range n.levels {
+		sources[i] = l.Name + "\x00" + keys[i]
+	}
+	defer n.tl.buckets.lock(sources)()
+
+	sets := make([]*tokenBucketSet, 0, len(n.levels))
+	for i, l := range n.levels {
+		set := n.tl.buckets.shard(sources[i]).bucketSet(sources[i], l.Rates)
+		delay, err := set.consume(amounts[i])
+		if err == nil && delay > 0 {
+			err = &MaxRateError{delay: delay, level: l.Name}
+		}
+		if err != nil {
+			// the failed set has rolled back on its own, the locks guarantee nothing else has consumed since
+			for _, s := range sets {
+				s.rollback()
+			}
+			return "", err
+		}
+		sets = append(sets, set)
+	}
+	out := make([]string, len(sets))
+	for i, s := range sets {
+		out[i] = n.levels[i].Name + "=" + strconv.FormatInt(s.available(), 10)
+	}
+	return strings.Join(out, ", "), nil
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type NestedSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&NestedSuite{})
+
+func (s *NestedSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func rates(average int64) *RateSet {
+	rs := NewRateSet()
+	rs.Add(time.Second, average, average)
+	return rs
+}
+
+func headerKey(name string) utils.SourceExtractor {
+	return utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+		return req.Header.Get(name), 1, nil
+	})
+}
+
+func (s *NestedSuite) newLimiter(c *C) *NestedLimiter {
+	l, err := NewNested(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), []Level{
+		{Name: "global", Rates: rates(5)},
+		{Name: "tenant", Extract: headerKey("Tenant"), Rates: rates(3)},
+		{Name: "user", Extract: headerKey("User"), Rates: rates(2)},
+	}, Clock(s.clock))
+	c.Assert(err, IsNil)
+	return l
+}
+
+func serveNested(l *NestedLimiter, tenant, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Tenant", tenant)
+	req.Header.Set("User", user)
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+	return w
+}
+
+func (s *NestedSuite) TestLevels(c *C) {
+	l := s.newLimiter(c)
+
+	w := serveNested(l, "t1", "u1")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get(RemainingHeader), Equals, "global=4, tenant=2, user=1")
+
+	serveNested(l, "t1", "u1")
+	// the user level is exhausted
+	w = serveNested(l, "t1", "u1")
+	c.Assert(w.Code, Equals, 429)
+	c.Assert(w.Header().Get(LevelHeader), Equals, "user")
+
+	// the rejected request has not been charged on the other levels
+	w = serveNested(l, "t1", "u2")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get(RemainingHeader), Equals, "global=2, tenant=0, user=1")
+
+	// the tenant level is exhausted for all its users
+	w = serveNested(l, "t1", "u3")
+	c.Assert(w.Code, Equals, 429)
+	c.Assert(w.Header().Get(LevelHeader), Equals, "tenant")
+
+	c.Assert(serveNested(l, "t2", "u4").Code, Equals, http.StatusOK)
+	c.Assert(serveNested(l, "t3", "u5").Code, Equals, http.StatusOK)
+	// the global level is exhausted for everybody
+	w = serveNested(l, "t4", "u6")
+	c.Assert(w.Code, Equals, 429)
+	c.Assert(w.Header().Get(LevelHeader), Equals, "global")
+
+	s.clock.Sleep(time.Second)
+	c.Assert(serveNested(l, "t1", "u1").Code, Equals, http.StatusOK)
+}
+
+func (s *NestedSuite) TestBadLevels(c *C) {
+	_, err := NewNested(nil, nil)
+	c.Assert(err, NotNil)
+	_, err = NewNested(nil, []Level{{Name: "a", Rates: rates(1)}, {Name: "a", Rates: rates(1)}})
+	c.Assert(err, NotNil)
+	_, err = NewNested(nil, []Level{{Name: "a=b", Rates: rates(1)}})
+	c.Assert(err, NotNil)
+	_, err = NewNested(nil, []Level{{Name: "a"}})
+	c.Assert(err, NotNil)
+	_, err = NewNested(nil, []Level{{Name: "a", Rates: rates(1)}}, ExtractRates(RateExtractorFunc(func(*http.Request) (*RateSet, error) {
+		return nil, nil
+	})))
+	c.Assert(err, NotNil)
+}
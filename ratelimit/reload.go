@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RatesEvent is emitted by the watchers when the default rates of the limiter are swapped
+// or the new rates are rejected with Err
+type RatesEvent struct {
+	Old *RateSet
+	New *RateSet
+	Err error
+}
+
+// DefaultRates returns a copy of the rates applied to the sources without rates of their own
+func (tl *TokenLimiter) DefaultRates() *RateSet {
+	return tl.defaults().clone()
+}
+
+// defaults returns the default rates, the returned set is never changed
+func (tl *TokenLimiter) defaults() *RateSet {
+	tl.mutex.RLock()
+	defer tl.mutex.RUnlock()
+	return tl.defaultRates
+}
+
+// SetDefaultRates atomically swaps the default rates. The buckets of the sources are brought in accordance
+// with the new rates on their next request, keeping the available tokens within the new bursts.
+func (tl *TokenLimiter) SetDefaultRates(rates *RateSet) error {
+	if rates == nil || len(rates.m) == 0 {
+		return fmt.Errorf("Provide default rates")
+	}
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	tl.defaultRates = rates.clone()
+	return nil
+}
+
+// WatchRates applies the rates received from the channel as the default ones until the context is done
+// or the channel is closed, calling onEvent, if set, on every change.
+func (tl *TokenLimiter) WatchRates(ctx context.Context, updates <-chan *RateSet, onEvent func(RatesEvent)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rates, ok := <-updates:
+			if !ok {
+				return
+			}
+			tl.reload(rates, nil, onEvent)
+		}
+	}
+}
+
+// PollRates calls fetch every interval, e.g. to read the rates from a central policy service, and applies
+// the returned rates as the default ones until the context is done, calling onEvent, if set, on every change
+// and fetch error. The first fetch happens right away.
+func (tl *TokenLimiter) PollRates(ctx context.Context, fetch func() (*RateSet, error), interval time.Duration, onEvent func(RatesEvent)) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval should be > 0, got %v", interval)
+	}
+	for {
+		rates, err := fetch()
+		tl.reload(rates, err, onEvent)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tl.clock.After(interval):
+		}
+	}
+}
+
+func (tl *TokenLimiter) reload(rates *RateSet, err error, onEvent func(RatesEvent)) {
+	old := tl.DefaultRates()
+	if err == nil {
+		if rates.equal(old) {
+			return
+		}
+		err = tl.SetDefaultRates(rates)
+	}
+	if err != nil {
+		tl.log.Errorf("Failed to reload rates: %v", err)
+	} else {
+		tl.log.Infof("Reloaded rates: %v", rates)
+	}
+	if onEvent != nil {
+		onEvent(RatesEvent{Old: old, New: rates, Err: err})
+	}
+}
+
+func (rs *RateSet) clone() *RateSet {
+	out := NewRateSet()
+	for period, r := range rs.m {
+		out.m[period] = &rate{r.period, r.average, r.burst}
+	}
+	return out
+}
+
+func (rs *RateSet) equal(other *RateSet) bool {
+	if rs == nil || other == nil {
+		return rs == other
+	}
+	if len(rs.m) != len(other.m) {
+		return false
+	}
+	for period, r := range rs.m {
+		o, ok := other.m[period]
+		if !ok || *o != *r {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReloadSuite struct{}
+
+var _ = Suite(&ReloadSuite{})
+
+func (s *ReloadSuite) TestSetDefaultRates(c *C) {
+	clock := testutils.NewClock()
+	l, err := NewLimiter(rates(1), Clock(clock))
+	c.Assert(err, IsNil)
+
+	d, _ := l.Allow("a", 1)
+	c.Assert(d.Allowed, Equals, true)
+	d, _ = l.Allow("a", 1)
+	c.Assert(d.Allowed, Equals, false)
+
+	c.Assert(l.SetDefaultRates(rates(10)), IsNil)
+	clock.Advance(time.Second)
+	for i := 0; i < 10; i++ {
+		d, _ = l.Allow("a", 1)
+		c.Assert(d.Allowed, Equals, true, Commentf("%v", i))
+	}
+	c.Assert(l.DefaultRates().equal(rates(10)), Equals, true)
+
+	c.Assert(l.SetDefaultRates(nil), NotNil)
+	c.Assert(l.SetDefaultRates(NewRateSet()), NotNil)
+}
+
+func (s *ReloadSuite) TestWatchRates(c *C) {
+	l, err := NewLimiter(rates(1))
+	c.Assert(err, IsNil)
+
+	updates := make(chan *RateSet)
+	events := make(chan RatesEvent, 10)
+	done := make(chan struct{})
+	go func() {
+		l.WatchRates(context.Background(), updates, func(e RatesEvent) { events <- e })
+		close(done)
+	}()
+
+	updates <- rates(5)
+	e := <-events
+	c.Assert(e.Err, IsNil)
+	c.Assert(e.Old.equal(rates(1)), Equals, true)
+	c.Assert(e.New.equal(rates(5)), Equals, true)
+
+	// the same rates are not an event, the invalid ones are reported
+	updates <- rates(5)
+	updates <- NewRateSet()
+	e = <-events
+	c.Assert(e.Err, NotNil)
+	c.Assert(l.DefaultRates().equal(rates(5)), Equals, true)
+
+	close(updates)
+	<-done
+}
+
+func (s *ReloadSuite) TestPollRates(c *C) {
+	clock := testutils.NewClock()
+	l, err := NewLimiter(rates(1), Clock(clock))
+	c.Assert(err, IsNil)
+
+	fetches := make(chan struct{}, 10)
+	var next *RateSet
+	var fetchErr error
+	fetch := func() (*RateSet, error) {
+		defer func() { fetches <- struct{}{} }()
+		return next, fetchErr
+	}
+
+	events := make(chan RatesEvent, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	next = rates(2)
+	go func() {
+		done <- l.PollRates(ctx, fetch, time.Minute, func(e RatesEvent) { events <- e })
+	}()
+
+	// the first fetch happens right away
+	<-fetches
+	e := <-events
+	c.Assert(e.New.equal(rates(2)), Equals, true)
+
+	clock.BlockUntil(1)
+	fetchErr = fmt.Errorf("policy service is down")
+	clock.Advance(time.Minute)
+	<-fetches
+	e = <-events
+	c.Assert(e.Err, ErrorMatches, "policy service is down")
+	c.Assert(l.DefaultRates().equal(rates(2)), Equals, true)
+
+	clock.BlockUntil(1)
+	next, fetchErr = rates(3), nil
+	clock.Advance(time.Minute)
+	<-fetches
+	e = <-events
+	c.Assert(e.Old.equal(rates(2)), Equals, true)
+	c.Assert(e.New.equal(rates(3)), Equals, true)
+
+	clock.BlockUntil(1)
+	cancel()
+	c.Assert(<-done, IsNil)
+
+	c.Assert(l.PollRates(ctx, fetch, 0, nil), NotNil)
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/timetools"
+	"github.com/mailgun/ttlmap"
+)
+
+// DefaultShards is the number of the independently locked parts of the bucket registry
+const DefaultShards = 16
+
+// bucketShard keeps the bucket sets of the sources hashed to it, the buckets are changed with its lock held
+type bucketShard struct {
+	mutex sync.Mutex
+	sets  *ttlmap.TtlMap
+	clock timetools.TimeProvider
+}
+
+// bucketShards is the bucket registry sharded by the hash of the source, so the requests of the different
+// sources rarely wait for each other
+type bucketShards []*bucketShard
+
+// newBucketShards splits the capacity between the shards, there are no more shards than the capacity
+func newBucketShards(shards, capacity int, clock timetools.TimeProvider) (bucketShards, error) {
+	if shards > capacity {
+		shards = capacity
+	}
+	out := make(bucketShards, shards)
+	for i := range out {
+		sets, err := ttlmap.NewMapWithProvider((capacity+shards-1)/shards, clock)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = &bucketShard{sets: sets, clock: clock}
+	}
+	return out, nil
+}
+
+// index returns the shard of the source, FNV-1a hash of it
+func (s bucketShards) index(source string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(source); i++ {
+		h ^= uint32(source[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(s)))
+}
+
+func (s bucketShards) shard(source string) *bucketShard {
+	return s[s.index(source)]
+}
+
+// lock locks the shards of the sources in the order of their indexes, so the concurrent callers never deadlock,
+// the returned function unlocks them
+func (s bucketShards) lock(sources []string) func() {
+	indexes := make([]int, 0, len(sources))
+	seen := make(map[int]bool, len(sources))
+	for _, source := range sources {
+		if i := s.index(source); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s[i].mutex.Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			s[i].mutex.Unlock()
+		}
+	}
+}
+
+// bucketSet returns the buckets of the source brought in accordance with the rates, called with the lock held
+func (s *bucketShard) bucketSet(source string, rates *RateSet) *tokenBucketSet {
+	bucketSetI, exists := s.sets.Get(source)
+	if exists {
+		bucketSet := bucketSetI.(*tokenBucketSet)
+		bucketSet.update(rates)
+		return bucketSet
+	}
+	bucketSet := newTokenBucketSet(rates, s.clock)
+	// We set ttl as 10 times rate period. E.g. if rate is 100 requests/second per client ip
+	// the counters for this ip will expire after 10 seconds of inactivity
+	s.sets.Set(source, bucketSet, int(bucketSet.maxPeriod/time.Second)*10+1)
+	return bucketSet
+}
+
+// Shards sets the number of the independently locked parts of the bucket registry, DefaultShards by default.
+// The capacity is split between them evenly.
+func Shards(n int) TokenLimiterOption {
+	return func(cl *TokenLimiter) error {
+		if n <= 0 {
+			return fmt.Errorf("bad shards: %v", n)
+		}
+		cl.shards = n
+		return nil
+	}
+}
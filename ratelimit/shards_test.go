@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type ShardsSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&ShardsSuite{})
+
+func (s *ShardsSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *ShardsSuite) TestShards(c *C) {
+	rates := NewRateSet()
+	rates.Add(time.Second, 1, 1)
+
+	_, err := NewLimiter(rates, Shards(0))
+	c.Assert(err, NotNil)
+
+	l, err := NewLimiter(rates, Shards(4), Clock(s.clock))
+	c.Assert(err, IsNil)
+	c.Assert(len(l.buckets), Equals, 4)
+
+	// the sources of the different shards keep their own buckets
+	used := make(map[int]bool)
+	for i := 0; len(used) < 4; i++ {
+		key := fmt.Sprintf("key%d", i)
+		used[l.buckets.index(key)] = true
+		d, err := l.Allow(key, 1)
+		c.Assert(err, IsNil)
+		c.Assert(d.Allowed, Equals, true)
+		d, err = l.Allow(key, 1)
+		c.Assert(err, IsNil)
+		c.Assert(d.Allowed, Equals, false)
+		_, ok := l.Stats(key)
+		c.Assert(ok, Equals, true)
+	}
+
+	// there are no more shards than the capacity
+	l, err = NewLimiter(rates, Shards(4), Capacity(2))
+	c.Assert(err, IsNil)
+	c.Assert(len(l.buckets), Equals, 2)
+}
+
+func (s *ShardsSuite) TestLockOrder(c *C) {
+	shards, err := newBucketShards(4, 16, s.clock)
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		sources := []string{"a", "b", "c", "d"}
+		if i%2 == 0 {
+			sources = []string{"d", "c", "b", "a"}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				shards.lock(sources)()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkAllow(b *testing.B) {
+	rates := NewRateSet()
+	rates.Add(time.Second, 1000000, 1000000)
+	l, err := NewLimiter(rates)
+	if err != nil {
+		b.Fatal(err)
+	}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			l.Allow(keys[i%len(keys)], 1)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"github.com/mailgun/oxy/tenancy"
+)
+
+// TenantRates returns the extractor of the rates of the tenants from their configs, the tenants without
+// the rates get the default ones. The tenancy should be the source extractor of the limiter, so the source keys
+// are the tenants:
+//
+//	rl, _ := ratelimit.New(next, tenants, defaultRates, ratelimit.ExtractKeyRates(ratelimit.TenantRates(tenants), time.Minute))
+func TenantRates(t *tenancy.Tenancy) KeyRateExtractor {
+	return KeyRateExtractorFunc(func(tenant string, r *http.Request) (*RateSet, error) {
+		c := t.Config(tenant)
+		if len(c.Rates) == 0 {
+			return nil, nil
+		}
+		rates := NewRateSet()
+		for _, rate := range c.Rates {
+			if err := rates.Add(rate.Period, rate.Average, rate.Burst); err != nil {
+				return nil, err
+			}
+		}
+		return rates, nil
+	})
+}
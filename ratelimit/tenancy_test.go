@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/mailgun/oxy/tenancy"
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type TenancySuite struct{}
+
+var _ = Suite(&TenancySuite{})
+
+func (s *TenancySuite) TestTenantRates(c *C) {
+	extract, err := utils.NewExtractor("request.header.X-Tenant-Id")
+	c.Assert(err, IsNil)
+	store := tenancy.NewMemoryStore()
+	store.Set("acme", tenancy.Config{Rates: []tenancy.Rate{{Period: time.Second, Average: 3, Burst: 3}}})
+	store.Set("broken", tenancy.Config{Rates: []tenancy.Rate{{Period: 0, Average: 3, Burst: 3}}})
+	tenants, err := tenancy.New(extract, store)
+	c.Assert(err, IsNil)
+
+	l, err := NewLimiter(rates(1), Clock(testutils.NewClock()), ExtractKeyRates(TenantRates(tenants), time.Minute))
+	c.Assert(err, IsNil)
+
+	c.Assert(allowed(l, "acme"), Equals, 3)
+	// the tenants without the rates and with the invalid ones get the defaults
+	c.Assert(allowed(l, "initech"), Equals, 1)
+	c.Assert(allowed(l, "broken"), Equals, 1)
+}
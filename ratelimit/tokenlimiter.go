@@ -4,6 +4,7 @@ package ratelimit
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,6 +15,9 @@ import (
 
 const DefaultCapacity = 65536
 
+// ResetHeader is set on the rejected requests to the seconds until the tokens are available, like Retry-After
+const ResetHeader = "RateLimit-Reset"
+
 // RateSet maintains a set of rates. It can contain only one rate per period at a time.
 type RateSet struct {
 	m map[time.Duration]*rate
@@ -62,12 +66,20 @@ type TokenLimiter struct {
 	extract      utils.SourceExtractor
 	extractRates RateExtractor
 	clock        timetools.TimeProvider
-	mutex        sync.Mutex
-	bucketSets   *ttlmap.TtlMap
-	errHandler   utils.ErrorHandler
-	log          utils.Logger
-	capacity     int
-	next         http.Handler
+	// mutex guards the default rates and the key rates cache, the buckets are guarded by their shards
+	mutex      sync.RWMutex
+	buckets    bucketShards
+	shards     int
+	errHandler utils.ErrorHandler
+	log        utils.Logger
+	capacity   int
+	refund     bool
+	next       http.Handler
+
+	// extractKeyRates is set by ExtractKeyRates, the rates are cached per key
+	extractKeyRates KeyRateExtractor
+	keyRatesTTL     int
+	keyRatesCache   *ttlmap.TtlMap
 }
 
 // New constructs a `TokenLimiter` middleware instance.
@@ -90,14 +102,85 @@ func New(next http.Handler, extract utils.SourceExtractor, defaultRates *RateSet
 		}
 	}
 	setDefaults(tl)
-	bucketSets, err := ttlmap.NewMapWithProvider(tl.capacity, tl.clock)
+	buckets, err := newBucketShards(tl.shards, tl.capacity, tl.clock)
 	if err != nil {
 		return nil, err
 	}
-	tl.bucketSets = bucketSets
+	tl.buckets = buckets
+	if err := tl.newKeyRatesCache(); err != nil {
+		return nil, err
+	}
 	return tl, nil
 }
 
+// NewLimiter constructs a `TokenLimiter` for the callers rate limiting something other than HTTP requests,
+// e.g. gRPC calls or background jobs, with Allow. The limiter can still be used as a middleware after Wrap,
+// its requests are rejected unless the source extractor is configured with New.
+func NewLimiter(defaultRates *RateSet, opts ...TokenLimiterOption) (*TokenLimiter, error) {
+	return New(nil, utils.ExtractorFunc(noSource), defaultRates, opts...)
+}
+
+func noSource(req *http.Request) (string, int64, error) {
+	return "", 0, fmt.Errorf("Source extractor is not configured")
+}
+
+// Decision is the outcome of Allow
+type Decision struct {
+	// Allowed tells whether the cost has been consumed
+	Allowed bool
+	// RetryIn is the time until the cost can be consumed, set when not allowed
+	RetryIn time.Duration
+	// Remaining is the minimum of the tokens left in the buckets of the key
+	Remaining int64
+}
+
+// Allow consumes the cost from the buckets of the key with the default rates, or the ones of ExtractKeyRates,
+// sharing the buckets with the HTTP requests of the same source. The error is returned if the cost exceeds the burst.
+func (tl *TokenLimiter) Allow(key string, cost int64) (Decision, error) {
+	var rates *RateSet
+	if tl.extractKeyRates != nil {
+		rates = tl.keyRates(key, nil)
+	}
+	if rates == nil {
+		rates = tl.defaults()
+	}
+	shard := tl.buckets.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	bucketSet := shard.bucketSet(key, rates)
+	delay, err := bucketSet.consume(cost)
+	if err != nil {
+		return Decision{}, err
+	}
+	if delay > 0 {
+		return Decision{RetryIn: delay, Remaining: bucketSet.available()}, nil
+	}
+	return Decision{Allowed: true, Remaining: bucketSet.available()}, nil
+}
+
+// BucketStats describes the state of a single token bucket of the source
+type BucketStats struct {
+	Period    time.Duration // Period - time period controlled by the bucket
+	Average   int64         // Average - number of tokens refilled per period
+	Burst     int64         // Burst - the maximum number of tokens the bucket can hold
+	Available int64         // Available - the number of tokens available for consumption at the moment
+}
+
+// Stats returns the state of the buckets tracked for the source sorted by period,
+// the second value is false if the source is not tracked by the limiter.
+func (tl *TokenLimiter) Stats(source string) ([]BucketStats, bool) {
+	shard := tl.buckets.shard(source)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	bucketSetI, exists := shard.sets.Get(source)
+	if !exists {
+		return nil, false
+	}
+	return bucketSetI.(*tokenBucketSet).stats(), true
+}
+
 func (tl *TokenLimiter) Wrap(next http.Handler) {
 	tl.next = next
 }
@@ -109,40 +192,57 @@ func (tl *TokenLimiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := tl.consumeRates(req, source, amount); err != nil {
+	bucketSet, err := tl.consumeRates(req, source, amount)
+	if err != nil {
 		tl.log.Infof("limiting request %v %v, limit: %v", req.Method, req.URL, err)
 		tl.errHandler.ServeHTTP(w, req, err)
 		return
 	}
 
-	tl.next.ServeHTTP(w, req)
+	if !tl.refund {
+		tl.next.ServeHTTP(w, req)
+		return
+	}
+	// the client is gone before the request is passed on
+	if req.Context().Err() != nil {
+		tl.refundTokens(source, bucketSet, amount)
+		return
+	}
+	pw := &utils.ProxyWriter{W: w}
+	tl.next.ServeHTTP(pw, req)
+	if pw.Code == utils.StatusClientClosedRequest {
+		tl.refundTokens(source, bucketSet, amount)
+	}
 }
 
-func (tl *TokenLimiter) consumeRates(req *http.Request, source string, amount int64) error {
-	tl.mutex.Lock()
-	defer tl.mutex.Unlock()
-
-	effectiveRates := tl.resolveRates(req)
-	bucketSetI, exists := tl.bucketSets.Get(source)
-	var bucketSet *tokenBucketSet
+func (tl *TokenLimiter) refundTokens(source string, bucketSet *tokenBucketSet, amount int64) {
+	shard := tl.buckets.shard(source)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	bucketSet.refund(amount)
+}
 
-	if exists {
-		bucketSet = bucketSetI.(*tokenBucketSet)
-		bucketSet.update(effectiveRates)
-	} else {
-		bucketSet = newTokenBucketSet(effectiveRates, tl.clock)
-		// We set ttl as 10 times rate period. E.g. if rate is 100 requests/second per client ip
-		// the counters for this ip will expire after 10 seconds of inactivity
-		tl.bucketSets.Set(source, bucketSet, int(bucketSet.maxPeriod/time.Second)*10+1)
+func (tl *TokenLimiter) consumeRates(req *http.Request, source string, amount int64) (*tokenBucketSet, error) {
+	var rates *RateSet
+	if tl.extractKeyRates != nil {
+		rates = tl.keyRates(source, req)
+	}
+	if rates == nil {
+		rates = tl.resolveRates(req)
 	}
+	shard := tl.buckets.shard(source)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	bucketSet := shard.bucketSet(source, rates)
 	delay, err := bucketSet.consume(amount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if delay > 0 {
-		return &MaxRateError{delay: delay}
+		return nil, &MaxRateError{delay: delay}
 	}
-	return nil
+	return bucketSet, nil
 }
 
 // effectiveRates retrieves rates to be applied to the request.
@@ -150,18 +250,18 @@ func (tl *TokenLimiter) resolveRates(req *http.Request) *RateSet {
 	// If configuration mapper is not specified for this instance, then return
 	// the default bucket specs.
 	if tl.extractRates == nil {
-		return tl.defaultRates
+		return tl.defaults()
 	}
 
 	rates, err := tl.extractRates.Extract(req)
 	if err != nil {
 		tl.log.Errorf("Failed to retrieve rates: %v", err)
-		return tl.defaultRates
+		return tl.defaults()
 	}
 
 	// If the returned rate set is empty then used the default one.
 	if len(rates.m) == 0 {
-		return tl.defaultRates
+		return tl.defaults()
 	}
 
 	return rates
@@ -169,9 +269,19 @@ func (tl *TokenLimiter) resolveRates(req *http.Request) *RateSet {
 
 type MaxRateError struct {
 	delay time.Duration
+	// level is the exhausted level of NestedLimiter
+	level string
+}
+
+// RetryIn returns the time until the tokens of the request are available
+func (m *MaxRateError) RetryIn() time.Duration {
+	return m.delay
 }
 
 func (m *MaxRateError) Error() string {
+	if m.level != "" {
+		return fmt.Sprintf("max rate of %v reached: retry-in %v", m.level, m.delay)
+	}
 	return fmt.Sprintf("max rate reached: retry-in %v", m.delay)
 }
 
@@ -181,6 +291,12 @@ type RateErrHandler struct {
 func (e *RateErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
 	if rerr, ok := err.(*MaxRateError); ok {
 		w.Header().Set("X-Retry-In", rerr.delay.String())
+		seconds := strconv.FormatInt(retryAfter(rerr.delay), 10)
+		w.Header().Set("Retry-After", seconds)
+		w.Header().Set(ResetHeader, seconds)
+		if rerr.level != "" {
+			w.Header().Set(LevelHeader, rerr.level)
+		}
 		w.WriteHeader(429)
 		w.Write([]byte(err.Error()))
 		return
@@ -188,6 +304,15 @@ func (e *RateErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err
 	utils.DefaultHandler.ServeHTTP(w, req, err)
 }
 
+// retryAfter rounds the delay up to seconds, so the clients retrying after it find the tokens refilled
+func retryAfter(delay time.Duration) int64 {
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
 type TokenLimiterOption func(l *TokenLimiter) error
 
 // Logger sets the logger that will be used by this middleware.
@@ -220,6 +345,17 @@ func Clock(clock timetools.TimeProvider) TokenLimiterOption {
 	}
 }
 
+// RefundCanceled returns the consumed tokens to the buckets when the client disconnects before
+// the request is passed on, or the request ends with 499 status code (utils.StatusClientClosedRequest)
+// the forwarder reports for the requests canceled by the client, so the retries of the clients
+// that timed out are not penalized twice
+func RefundCanceled() TokenLimiterOption {
+	return func(cl *TokenLimiter) error {
+		cl.refund = true
+		return nil
+	}
+}
+
 func Capacity(cap int) TokenLimiterOption {
 	return func(cl *TokenLimiter) error {
 		if cap <= 0 {
@@ -239,6 +375,9 @@ func setDefaults(tl *TokenLimiter) {
 	if tl.capacity <= 0 {
 		tl.capacity = DefaultCapacity
 	}
+	if tl.shards <= 0 {
+		tl.shards = DefaultShards
+	}
 	if tl.clock == nil {
 		tl.clock = &timetools.RealTime{}
 	}
@@ -2,6 +2,7 @@ package ratelimit
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -77,6 +78,46 @@ func (s *LimiterSuite) TestHitLimit(c *C) {
 	c.Assert(re.StatusCode, Equals, http.StatusOK)
 }
 
+func (s *LimiterSuite) TestRetryAfter(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rates := NewRateSet()
+	rates.Add(time.Minute, 2, 1)
+
+	l, err := New(handler, headerLimit, rates, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	// the token is refilled every 30 seconds counting from the last refill
+	s.clock.Sleep(10 * time.Second)
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, 429)
+	c.Assert(re.Header.Get("Retry-After"), Equals, "20")
+	c.Assert(re.Header.Get(ResetHeader), Equals, "20")
+	c.Assert(re.Header.Get("X-Retry-In"), Equals, "20s")
+
+	// the partial seconds are rounded up
+	s.clock.Sleep(19500 * time.Millisecond)
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, 429)
+	c.Assert(re.Header.Get("Retry-After"), Equals, "1")
+
+	s.clock.Sleep(500 * time.Millisecond)
+	re, _, err = testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
 // We've failed to extract client ip
 func (s *LimiterSuite) TestFailure(c *C) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -329,3 +370,143 @@ func faultyExtractor(req *http.Request) (string, int64, error) {
 
 var headerLimit = utils.ExtractorFunc(headerLimiter)
 var faultyExtract = utils.ExtractorFunc(faultyExtractor)
+
+func (s *LimiterSuite) TestStats(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	rates := NewRateSet()
+	rates.Add(time.Second, 2, 4)
+	rates.Add(time.Minute, 10, 20)
+
+	l, err := New(handler, headerLimit, rates, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	_, exists := l.Stats("a")
+	c.Assert(exists, Equals, false)
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL, testutils.Header("Source", "a"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	stats, exists := l.Stats("a")
+	c.Assert(exists, Equals, true)
+	c.Assert(stats, DeepEquals, []BucketStats{
+		{Period: time.Second, Average: 2, Burst: 4, Available: 3},
+		{Period: time.Minute, Average: 10, Burst: 20, Available: 19},
+	})
+}
+
+func (s *LimiterSuite) TestRefundCanceled(c *C) {
+	code := http.StatusOK
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(code)
+	})
+
+	rates := NewRateSet()
+	rates.Add(time.Second, 1, 1)
+
+	l, err := New(handler, headerLimit, rates, Clock(s.clock), RefundCanceled())
+	c.Assert(err, IsNil)
+
+	serve := func(ctx context.Context) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx)
+		req.Header.Set("Source", "refund")
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// the client is gone before the request is passed on
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	serve(ctx)
+	c.Assert(serve(context.Background()), Equals, http.StatusOK)
+
+	// the request canceled by the client on the way to the backend
+	code = utils.StatusClientClosedRequest
+	s.clock.Sleep(time.Second)
+	c.Assert(serve(context.Background()), Equals, utils.StatusClientClosedRequest)
+	code = http.StatusOK
+	c.Assert(serve(context.Background()), Equals, http.StatusOK)
+
+	// completed requests are not refunded
+	c.Assert(serve(context.Background()), Equals, 429)
+}
+
+func (s *LimiterSuite) TestNoRefundByDefault(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(utils.StatusClientClosedRequest)
+	})
+
+	rates := NewRateSet()
+	rates.Add(time.Second, 1, 1)
+
+	l, err := New(handler, headerLimit, rates, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	for _, expected := range []int{utils.StatusClientClosedRequest, 429} {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Source", "norefund")
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, expected)
+	}
+}
+
+func (s *LimiterSuite) TestAllow(c *C) {
+	rates := NewRateSet()
+	rates.Add(time.Second, 2, 3)
+
+	l, err := NewLimiter(rates, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	d, err := l.Allow("job", 2)
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, Decision{Allowed: true, Remaining: 1})
+
+	d, err = l.Allow("job", 2)
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, Decision{RetryIn: 500 * time.Millisecond, Remaining: 1})
+
+	// the other keys have own buckets
+	d, err = l.Allow("other", 1)
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, true)
+
+	s.clock.Sleep(500 * time.Millisecond)
+	d, err = l.Allow("job", 2)
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, true)
+
+	_, err = l.Allow("job", 4)
+	c.Assert(err, NotNil)
+
+	// the limiter without the source extractor rejects the requests
+	l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+}
+
+func (s *LimiterSuite) TestAllowSharesBuckets(c *C) {
+	rates := NewRateSet()
+	rates.Add(time.Second, 1, 1)
+
+	l, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), headerLimit, rates, Clock(s.clock))
+	c.Assert(err, IsNil)
+
+	d, err := l.Allow("shared", 1)
+	c.Assert(err, IsNil)
+	c.Assert(d.Allowed, Equals, true)
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Source", "shared")
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, 429)
+}
@@ -0,0 +1,264 @@
+// Package replay records the live exchanges passing through the middleware chain and replays them
+// from the fake backend, so the integration tests of the chains run against the realistic traffic deterministically.
+//
+//	// record the traffic in staging
+//	rec, _ := replay.NewRecorder(fwd)
+//	...
+//	rec.Save(file)
+//
+//	// serve it in the tests as the backend
+//	exchanges, _ := replay.Load(file)
+//	backend := httptest.NewServer(replay.NewReplayer(exchanges))
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Exchange is the recorded request and response
+type Exchange struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    []byte      `json:"request_body,omitempty"`
+	StatusCode     int         `json:"status_code"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseBody   []byte      `json:"response_body,omitempty"`
+	// Truncated is set if any of the bodies was longer than the recorder limit
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Redacted replaces the values of the sanitized headers
+const Redacted = "[redacted]"
+
+// DefaultSanitized are the headers redacted by default
+var DefaultSanitized = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Recorder passes the requests to the next handler and records the exchanges
+type Recorder struct {
+	mutex     sync.Mutex
+	next      http.Handler
+	exchanges []Exchange
+	max       int
+	bodyBytes int64
+	sanitized []string
+
+	log utils.Logger
+}
+
+// RecorderOption is a functional option setter for Recorder
+type RecorderOption func(r *Recorder) error
+
+// NewRecorder returns the recorder keeping the first 1000 exchanges with the bodies up to 1MB
+func NewRecorder(next http.Handler, options ...RecorderOption) (*Recorder, error) {
+	r := &Recorder{
+		next:      next,
+		max:       1000,
+		bodyBytes: 1 << 20,
+		sanitized: DefaultSanitized,
+	}
+	for _, o := range options {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.log == nil {
+		r.log = utils.NullLogger
+	}
+	return r, nil
+}
+
+func (r *Recorder) Wrap(next http.Handler) {
+	r.next = next
+}
+
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.full() {
+		r.next.ServeHTTP(w, req)
+		return
+	}
+	e := Exchange{
+		Method:        req.Method,
+		URL:           req.URL.RequestURI(),
+		RequestHeader: r.sanitize(req.Header),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, r.bodyBytes+1))
+		if err != nil {
+			r.log.Errorf("failed to read request body of %v: %v", req.URL, err)
+			utils.DefaultHandler.ServeHTTP(w, req, err)
+			return
+		}
+		// the rest of the body is left to the next handler
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		e.RequestBody, e.Truncated = r.cut(body)
+	}
+	rw := &recordingWriter{ResponseWriter: w, limit: r.bodyBytes}
+	r.next.ServeHTTP(rw, req)
+
+	e.StatusCode = rw.code
+	if e.StatusCode == 0 {
+		e.StatusCode = http.StatusOK
+	}
+	e.ResponseHeader = r.sanitize(w.Header())
+	e.ResponseBody = rw.body.Bytes()
+	e.Truncated = e.Truncated || rw.truncated
+	r.add(e)
+}
+
+// Exchanges returns the exchanges recorded so far
+func (r *Recorder) Exchanges() []Exchange {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Save writes the exchanges recorded as JSON lines, see Load
+func (r *Recorder) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, e := range r.Exchanges() {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads the exchanges saved by the recorder
+func Load(r io.Reader) ([]Exchange, error) {
+	var out []Exchange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse exchange %d: %v", len(out)+1, err)
+		}
+		out = append(out, e)
+	}
+	return out, scanner.Err()
+}
+
+func (r *Recorder) full() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.exchanges) >= r.max
+}
+
+func (r *Recorder) add(e Exchange) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.exchanges) < r.max {
+		r.exchanges = append(r.exchanges, e)
+	}
+}
+
+func (r *Recorder) cut(body []byte) ([]byte, bool) {
+	if int64(len(body)) > r.bodyBytes {
+		return body[:r.bodyBytes], true
+	}
+	return body, false
+}
+
+// sanitize copies the headers redacting the sanitized ones
+func (r *Recorder) sanitize(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range r.sanitized {
+		if values, ok := out[http.CanonicalHeaderKey(name)]; ok {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// recordingWriter keeps the status code and the first bytes of the response body
+type recordingWriter struct {
+	http.ResponseWriter
+	code      int
+	body      bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if left := w.limit - int64(w.body.Len()); int64(len(p)) > left {
+		w.body.Write(p[:left])
+		w.truncated = true
+	} else {
+		w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// MaxExchanges sets the number of the exchanges recorded, the later ones pass unrecorded
+func MaxExchanges(n int) RecorderOption {
+	return func(r *Recorder) error {
+		if n <= 0 {
+			return fmt.Errorf("max exchanges should be > 0, got %d", n)
+		}
+		r.max = n
+		return nil
+	}
+}
+
+// MaxBodyBytes sets the number of the body bytes recorded, the exchanges with the longer bodies are marked truncated
+func MaxBodyBytes(n int64) RecorderOption {
+	return func(r *Recorder) error {
+		if n < 0 {
+			return fmt.Errorf("max body bytes should be >= 0, got %d", n)
+		}
+		r.bodyBytes = n
+		return nil
+	}
+}
+
+// Sanitize sets the headers redacted in the recorded exchanges, DefaultSanitized by default
+func Sanitize(headers ...string) RecorderOption {
+	return func(r *Recorder) error {
+		r.sanitized = headers
+		return nil
+	}
+}
+
+// RecorderLogger sets the logger used by the recorder
+func RecorderLogger(l utils.Logger) RecorderOption {
+	return func(r *Recorder) error {
+		r.log = l
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package replay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestReplay(t *testing.T) { TestingT(t) }
+
+type RecorderSuite struct{}
+
+var _ = Suite(&RecorderSuite{})
+
+func echo() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Backend", "a")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("echo " + string(body)))
+	})
+}
+
+func (s *RecorderSuite) TestRecord(c *C) {
+	rec, err := NewRecorder(echo())
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	re, body, err := testutils.MakeRequest(srv.URL+"/items?a=1", testutils.Method("POST"), testutils.Body("hello"),
+		testutils.Header("Authorization", "Bearer secret"), testutils.Header("X-Client", "c"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+	c.Assert(string(body), Equals, "echo hello")
+
+	exchanges := rec.Exchanges()
+	c.Assert(len(exchanges), Equals, 1)
+	e := exchanges[0]
+	c.Assert(e.Method, Equals, "POST")
+	c.Assert(e.URL, Equals, "/items?a=1")
+	c.Assert(e.RequestHeader.Get("Authorization"), Equals, Redacted)
+	c.Assert(e.RequestHeader.Get("X-Client"), Equals, "c")
+	c.Assert(string(e.RequestBody), Equals, "hello")
+	c.Assert(e.StatusCode, Equals, http.StatusCreated)
+	c.Assert(e.ResponseHeader.Get("Set-Cookie"), Equals, Redacted)
+	c.Assert(e.ResponseHeader.Get("X-Backend"), Equals, "a")
+	c.Assert(string(e.ResponseBody), Equals, "echo hello")
+	c.Assert(e.Truncated, Equals, false)
+}
+
+func (s *RecorderSuite) TestLimits(c *C) {
+	rec, err := NewRecorder(echo(), MaxExchanges(2), MaxBodyBytes(3), Sanitize("X-Client"))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		// the next handler gets the whole body
+		_, body, err := testutils.MakeRequest(srv.URL, testutils.Method("POST"), testutils.Body("hello"), testutils.Header("X-Client", "c"))
+		c.Assert(err, IsNil)
+		c.Assert(string(body), Equals, "echo hello")
+	}
+
+	exchanges := rec.Exchanges()
+	c.Assert(len(exchanges), Equals, 2)
+	c.Assert(string(exchanges[0].RequestBody), Equals, "hel")
+	c.Assert(string(exchanges[0].ResponseBody), Equals, "ech")
+	c.Assert(exchanges[0].Truncated, Equals, true)
+	c.Assert(exchanges[0].RequestHeader.Get("X-Client"), Equals, Redacted)
+	c.Assert(exchanges[0].ResponseHeader.Get("Set-Cookie"), Equals, "session=secret")
+}
+
+func (s *RecorderSuite) TestSaveLoad(c *C) {
+	rec, err := NewRecorder(echo())
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	testutils.Get(srv.URL + "/a")
+	testutils.Get(srv.URL + "/b")
+
+	var buf bytes.Buffer
+	c.Assert(rec.Save(&buf), IsNil)
+	exchanges, err := Load(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(exchanges, DeepEquals, rec.Exchanges())
+
+	_, err = Load(bytes.NewBufferString("{bad"))
+	c.Assert(err, NotNil)
+}
+
+func (s *RecorderSuite) TestBadOptions(c *C) {
+	_, err := NewRecorder(echo(), MaxExchanges(0))
+	c.Assert(err, NotNil)
+	_, err = NewRecorder(echo(), MaxBodyBytes(-1))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Replayer is the fake backend serving the recorded responses to the requests with the same method and URL,
+// the responses recorded for the same request are served in the recorded order, the last one is repeated
+type Replayer struct {
+	mutex     sync.Mutex
+	exchanges map[string][]Exchange
+	served    map[string]int
+	matchBody bool
+
+	errHandler utils.ErrorHandler
+}
+
+// ReplayerOption is a functional option setter for Replayer
+type ReplayerOption func(r *Replayer) error
+
+// NewReplayer returns the replayer of the exchanges
+func NewReplayer(exchanges []Exchange, options ...ReplayerOption) (*Replayer, error) {
+	r := &Replayer{
+		exchanges: make(map[string][]Exchange),
+		served:    make(map[string]int),
+	}
+	for _, o := range options {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.errHandler == nil {
+		r.errHandler = defaultErrHandler
+	}
+	for _, e := range exchanges {
+		key := r.key(e.Method, e.URL, e.RequestBody)
+		r.exchanges[key] = append(r.exchanges[key], e)
+	}
+	return r, nil
+}
+
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body []byte
+	if r.matchBody && req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			r.errHandler.ServeHTTP(w, req, err)
+			return
+		}
+	}
+	e, ok := r.next(r.key(req.Method, req.URL.RequestURI(), body))
+	if !ok {
+		r.errHandler.ServeHTTP(w, req, &MissError{Method: req.Method, URL: req.URL.RequestURI()})
+		return
+	}
+	utils.CopyHeaders(w.Header(), e.ResponseHeader)
+	// the recorded length may not match the truncated body
+	w.Header().Del("Content-Length")
+	w.WriteHeader(e.StatusCode)
+	w.Write(e.ResponseBody)
+}
+
+// Reset starts serving the recorded responses from the first ones again
+func (r *Replayer) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.served = make(map[string]int)
+}
+
+func (r *Replayer) next(key string) (Exchange, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	exchanges := r.exchanges[key]
+	if len(exchanges) == 0 {
+		return Exchange{}, false
+	}
+	i := r.served[key]
+	if i < len(exchanges)-1 {
+		r.served[key] = i + 1
+	}
+	return exchanges[i], true
+}
+
+func (r *Replayer) key(method, url string, body []byte) string {
+	if !r.matchBody {
+		return method + " " + url
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\n%s", method, url, body)
+	return b.String()
+}
+
+// MissError is reported for the requests without the recorded exchanges
+type MissError struct {
+	Method string
+	URL    string
+}
+
+func (e *MissError) Error() string {
+	return fmt.Sprintf("no exchange recorded for %s %s", e.Method, e.URL)
+}
+
+// ReplayErrHandler serves the requests without the recorded exchanges with 404 status code
+type ReplayErrHandler struct {
+}
+
+func (e *ReplayErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	if _, ok := err.(*MissError); ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+// MatchBody matches the requests by the body as well as by the method and the URL
+func MatchBody() ReplayerOption {
+	return func(r *Replayer) error {
+		r.matchBody = true
+		return nil
+	}
+}
+
+// ReplayerErrorHandler sets the handler of the requests without the recorded exchanges, 404 by default
+func ReplayerErrorHandler(h utils.ErrorHandler) ReplayerOption {
+	return func(r *Replayer) error {
+		r.errHandler = h
+		return nil
+	}
+}
+
+var defaultErrHandler = &ReplayErrHandler{}
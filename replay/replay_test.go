@@ -0,0 +1,99 @@
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/forward"
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReplayerSuite struct{}
+
+var _ = Suite(&ReplayerSuite{})
+
+func (s *ReplayerSuite) TestReplay(c *C) {
+	r, err := NewReplayer([]Exchange{
+		{Method: "GET", URL: "/a", StatusCode: http.StatusServiceUnavailable, ResponseBody: []byte("down")},
+		{Method: "GET", URL: "/a", StatusCode: http.StatusOK, ResponseHeader: http.Header{"X-Backend": {"a"}}, ResponseBody: []byte("up")},
+		{Method: "POST", URL: "/a", StatusCode: http.StatusCreated},
+	})
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL + "/a")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(string(body), Equals, "down")
+
+	// the last response is repeated
+	for i := 0; i < 2; i++ {
+		re, body, err = testutils.Get(srv.URL + "/a")
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, http.StatusOK)
+		c.Assert(re.Header.Get("X-Backend"), Equals, "a")
+		c.Assert(string(body), Equals, "up")
+	}
+
+	re, _, err = testutils.MakeRequest(srv.URL+"/a", testutils.Method("POST"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusCreated)
+
+	re, _, err = testutils.Get(srv.URL + "/b")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+
+	r.Reset()
+	re, _, err = testutils.Get(srv.URL + "/a")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *ReplayerSuite) TestMatchBody(c *C) {
+	r, err := NewReplayer([]Exchange{
+		{Method: "POST", URL: "/a", RequestBody: []byte("one"), StatusCode: http.StatusOK, ResponseBody: []byte("1")},
+		{Method: "POST", URL: "/a", RequestBody: []byte("two"), StatusCode: http.StatusOK, ResponseBody: []byte("2")},
+	}, MatchBody())
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	_, body, err := testutils.MakeRequest(srv.URL+"/a", testutils.Method("POST"), testutils.Body("two"))
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "2")
+
+	re, _, err := testutils.MakeRequest(srv.URL+"/a", testutils.Method("POST"), testutils.Body("three"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusNotFound)
+}
+
+// the traffic recorded through the forwarder is replayed as its backend
+func (s *ReplayerSuite) TestRecordReplay(c *C) {
+	backend := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("backend " + req.URL.Path))
+	})
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+	rec, err := NewRecorder(fwd)
+	c.Assert(err, IsNil)
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL.Scheme, req.URL.Host = "http", testutils.ParseURI(backend.URL).Host
+		rec.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+	_, _, err = testutils.Get(proxy.URL + "/path")
+	c.Assert(err, IsNil)
+	backend.Close()
+
+	r, err := NewReplayer(rec.Exchanges())
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	re, body, err := testutils.Get(srv.URL + "/path")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "backend /path")
+}
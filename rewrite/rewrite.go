@@ -0,0 +1,212 @@
+// Package rewrite implements middleware that rewrites request paths and hosts and redirects the clients.
+//
+// Rules are applied in the order they are given, redirect rules stop the processing once matched:
+//
+//	// redirect plain text requests to https, serve /api/v1/* from the backend root
+//	rw, _ := rewrite.New(next,
+//		rewrite.HTTPSRedirect(http.StatusMovedPermanently),
+//		rewrite.StripPrefix("/api/v1"),
+//		rewrite.ReplacePath(`^/users/(\d+)$`, "/users?id=$1"))
+package rewrite
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Rewrite applies the rewrite and redirect rules to the requests
+type Rewrite struct {
+	next  http.Handler
+	rules []rule
+	log   utils.Logger
+}
+
+// rule rewrites the request in place or returns the redirect location and status code
+type rule func(req *http.Request) (location string, code int)
+
+// RewriteOption is a functional option setter for Rewrite
+type RewriteOption func(rw *Rewrite) error
+
+// ReplacePath replaces the path matching the regular expression, the replacement can reference
+// the capture groups, e.g. $1, and contain a query string that is merged with the original query
+func ReplacePath(expr, replacement string) RewriteOption {
+	return func(rw *Rewrite) error {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		rw.rules = append(rw.rules, func(req *http.Request) (string, int) {
+			if re.MatchString(req.URL.Path) {
+				setPath(req, re.ReplaceAllString(req.URL.Path, replacement))
+			}
+			return "", 0
+		})
+		return nil
+	}
+}
+
+// StripPrefix removes the prefix from the request path, the prefix matches whole path segments only,
+// e.g. /api/v1 is stripped from /api/v1/users but not from /api/v10/users
+func StripPrefix(prefix string) RewriteOption {
+	return func(rw *Rewrite) error {
+		if prefix == "" {
+			return fmt.Errorf("prefix can not be empty")
+		}
+		prefix = strings.TrimSuffix(prefix, "/")
+		rw.rules = append(rw.rules, func(req *http.Request) (string, int) {
+			path := req.URL.Path
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				path = path[len(prefix):]
+				if path == "" {
+					path = "/"
+				}
+				setPath(req, path)
+			}
+			return "", 0
+		})
+		return nil
+	}
+}
+
+// AddPrefix prepends the prefix to the request path
+func AddPrefix(prefix string) RewriteOption {
+	return func(rw *Rewrite) error {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("prefix should start with /, got '%v'", prefix)
+		}
+		prefix = strings.TrimSuffix(prefix, "/")
+		rw.rules = append(rw.rules, func(req *http.Request) (string, int) {
+			setPath(req, prefix+req.URL.Path)
+			return "", 0
+		})
+		return nil
+	}
+}
+
+// Host sets the host of the request, so the backends see the request for the host
+func Host(host string) RewriteOption {
+	return func(rw *Rewrite) error {
+		if host == "" {
+			return fmt.Errorf("host can not be empty")
+		}
+		rw.rules = append(rw.rules, func(req *http.Request) (string, int) {
+			req.Host = host
+			return "", 0
+		})
+		return nil
+	}
+}
+
+// Redirect redirects the clients if the full request URL, e.g. http://example.com/path?q=1, matches the regular
+// expression. The location is the URL with the regular expression replaced.
+func Redirect(expr, replacement string, code int) RewriteOption {
+	return func(rw *Rewrite) error {
+		if err := checkRedirectCode(code); err != nil {
+			return err
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		rw.rules = append(rw.rules, func(req *http.Request) (string, int) {
+			u := requestURL(req)
+			if !re.MatchString(u) {
+				return "", 0
+			}
+			return re.ReplaceAllString(u, replacement), code
+		})
+		return nil
+	}
+}
+
+// HTTPSRedirect redirects plain text requests to the same URL over https
+func HTTPSRedirect(code int) RewriteOption {
+	return func(rw *Rewrite) error {
+		if err := checkRedirectCode(code); err != nil {
+			return err
+		}
+		rw.rules = append(rw.rules, func(req *http.Request) (string, int) {
+			if req.TLS != nil {
+				return "", 0
+			}
+			return "https://" + req.Host + req.URL.RequestURI(), code
+		})
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) RewriteOption {
+	return func(rw *Rewrite) error {
+		rw.log = l
+		return nil
+	}
+}
+
+// New returns a new rewrite middleware
+func New(next http.Handler, options ...RewriteOption) (*Rewrite, error) {
+	rw := &Rewrite{next: next}
+	for _, o := range options {
+		if err := o(rw); err != nil {
+			return nil, err
+		}
+	}
+	if rw.log == nil {
+		rw.log = utils.NullLogger
+	}
+	return rw, nil
+}
+
+func (rw *Rewrite) Wrap(next http.Handler) {
+	rw.next = next
+}
+
+func (rw *Rewrite) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, r := range rw.rules {
+		if location, code := r(req); code != 0 {
+			rw.log.Infof("redirecting %v to %v", requestURL(req), location)
+			http.Redirect(w, req, location, code)
+			return
+		}
+	}
+	rw.next.ServeHTTP(w, req)
+}
+
+// setPath updates the request path, the query in the path is merged with the request query
+func setPath(req *http.Request, path string) {
+	if i := strings.Index(path, "?"); i != -1 {
+		query := path[i+1:]
+		path = path[:i]
+		if req.URL.RawQuery != "" {
+			query = query + "&" + req.URL.RawQuery
+		}
+		req.URL.RawQuery = query
+	}
+	if path == "" {
+		path = "/"
+	}
+	req.URL.Path = path
+	req.URL.RawPath = ""
+	req.RequestURI = req.URL.RequestURI()
+}
+
+func requestURL(req *http.Request) string {
+	u := url.URL{Scheme: "http", Host: req.Host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+func checkRedirectCode(code int) error {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return fmt.Errorf("unsupported redirect status code: %d", code)
+}
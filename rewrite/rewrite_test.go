@@ -0,0 +1,121 @@
+package rewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestRewrite(t *testing.T) { TestingT(t) }
+
+type RewriteSuite struct{}
+
+var _ = Suite(&RewriteSuite{})
+
+// echo replies with the host and the request URI it has received
+var echo = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte(req.Host + " " + req.URL.RequestURI()))
+})
+
+var noRedirects = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+func (s *RewriteSuite) TestPaths(c *C) {
+	testCases := []struct {
+		options  []RewriteOption
+		path     string
+		expected string
+	}{
+		{options: []RewriteOption{StripPrefix("/api")}, path: "/api/users", expected: "/users"},
+		{options: []RewriteOption{StripPrefix("/api")}, path: "/api", expected: "/"},
+		{options: []RewriteOption{StripPrefix("/api")}, path: "/other", expected: "/other"},
+		{options: []RewriteOption{StripPrefix("/api/v1")}, path: "/api/v10/users", expected: "/api/v10/users"},
+		{options: []RewriteOption{StripPrefix("/api/v1")}, path: "/api/v1extra", expected: "/api/v1extra"},
+		{options: []RewriteOption{StripPrefix("/api/v1/")}, path: "/api/v1/users", expected: "/users"},
+		{options: []RewriteOption{StripPrefix("/api/v1/")}, path: "/api/v1", expected: "/"},
+		{options: []RewriteOption{AddPrefix("/v2/")}, path: "/users", expected: "/v2/users"},
+		{options: []RewriteOption{ReplacePath(`^/users/(\d+)$`, "/user/$1/profile")}, path: "/users/42", expected: "/user/42/profile"},
+		{options: []RewriteOption{ReplacePath(`^/users/(\d+)$`, "/users?id=$1")}, path: "/users/42?x=1", expected: "/users?id=42&x=1"},
+		{options: []RewriteOption{StripPrefix("/api"), AddPrefix("/internal")}, path: "/api/users", expected: "/internal/users"},
+	}
+	for _, tc := range testCases {
+		rw, err := New(echo, tc.options...)
+		c.Assert(err, IsNil)
+		srv := httptest.NewServer(rw)
+		_, body, err := testutils.Get(srv.URL+tc.path, testutils.Host("example.com"))
+		srv.Close()
+		c.Assert(err, IsNil)
+		c.Assert(string(body), Equals, "example.com "+tc.expected, Commentf("%v", tc.path))
+	}
+}
+
+func (s *RewriteSuite) TestHost(c *C) {
+	rw, err := New(echo, Host("backend.local"))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	_, body, err := testutils.Get(srv.URL + "/path")
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "backend.local /path")
+}
+
+func (s *RewriteSuite) TestHTTPSRedirect(c *C) {
+	rw, err := New(echo, HTTPSRedirect(http.StatusPermanentRedirect))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/path?q=1", nil)
+	c.Assert(err, IsNil)
+	req.Host = "example.com"
+	re, err := noRedirects.Do(req)
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(re.StatusCode, Equals, http.StatusPermanentRedirect)
+	c.Assert(re.Header.Get("Location"), Equals, "https://example.com/path?q=1")
+
+	tlsSrv := httptest.NewTLSServer(rw)
+	defer tlsSrv.Close()
+	re, err = tlsSrv.Client().Get(tlsSrv.URL + "/path")
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *RewriteSuite) TestRedirect(c *C) {
+	rw, err := New(echo, Redirect(`^http://old\.example\.com/(.*)$`, "http://new.example.com/$1", http.StatusFound))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(rw)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/docs?page=2", nil)
+	c.Assert(err, IsNil)
+	req.Host = "old.example.com"
+	re, err := noRedirects.Do(req)
+	c.Assert(err, IsNil)
+	re.Body.Close()
+	c.Assert(re.StatusCode, Equals, http.StatusFound)
+	c.Assert(re.Header.Get("Location"), Equals, "http://new.example.com/docs?page=2")
+
+	_, body, err := testutils.Get(srv.URL+"/docs", testutils.Host("example.com"))
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "example.com /docs")
+}
+
+func (s *RewriteSuite) TestBadOptions(c *C) {
+	for _, o := range []RewriteOption{
+		ReplacePath("(", ""),
+		StripPrefix(""),
+		AddPrefix("v2"),
+		Host(""),
+		Redirect(".*", "/", http.StatusOK),
+		HTTPSRedirect(http.StatusNotFound),
+	} {
+		_, err := New(echo, o)
+		c.Assert(err, NotNil)
+	}
+}
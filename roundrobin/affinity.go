@@ -0,0 +1,79 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ReasonConnAffinity is reported in Decision for the requests sent to the server pinned to their connection
+const ReasonConnAffinity = "connection affinity"
+
+// connAffinity remembers the server chosen for the first request of every client connection
+type connAffinity struct {
+	ttl       time.Duration
+	conns     map[string]*pinnedConn
+	lastPrune time.Time
+}
+
+type pinnedConn struct {
+	srv  *server
+	seen time.Time
+}
+
+// ConnAffinity sends all requests of the client connection, keep-alive or HTTP/2, to the server chosen for its
+// first request, for the stateful backends requiring it. The connections are identified by the remote address
+// of the requests. The requests are balanced again once the pinned server is removed, ejected or has 0 weight.
+// Set ConnState as http.Server.ConnState to forget the closed connections right away, otherwise they are
+// forgotten once no request has arrived on them for the ttl.
+func ConnAffinity(ttl time.Duration) LBOption {
+	return func(r *RoundRobin) error {
+		if ttl <= 0 {
+			return fmt.Errorf("affinity ttl should be > 0, got %v", ttl)
+		}
+		r.affinity = &connAffinity{ttl: ttl, conns: make(map[string]*pinnedConn)}
+		return nil
+	}
+}
+
+// ConnState forgets the servers pinned to the closed and hijacked connections, see ConnAffinity
+func (r *RoundRobin) ConnState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.affinity != nil {
+		delete(r.affinity.conns, c.RemoteAddr().String())
+	}
+}
+
+// pinnedConnServer returns the server pinned to the connection of the request if it can still serve it,
+// called with the lock held
+func (r *RoundRobin) pinnedConnServer(req *http.Request, now time.Time) *server {
+	a := r.affinity
+	if now.Sub(a.lastPrune) >= a.ttl {
+		for addr, p := range a.conns {
+			if now.Sub(p.seen) >= a.ttl {
+				delete(a.conns, addr)
+			}
+		}
+		a.lastPrune = now
+	}
+	p, ok := a.conns[req.RemoteAddr]
+	if !ok {
+		return nil
+	}
+	if _, i := r.findServerByURL(p.srv.url); i == -1 || r.servers[i] != p.srv || p.srv.weight == 0 || p.srv.ejected(now) {
+		delete(a.conns, req.RemoteAddr)
+		return nil
+	}
+	p.seen = now
+	return p.srv
+}
+
+// pinConn pins the server to the connection of the request, called with the lock held
+func (r *RoundRobin) pinConn(req *http.Request, srv *server, now time.Time) {
+	r.affinity.conns[req.RemoteAddr] = &pinnedConn{srv: srv, seen: now}
+}
@@ -0,0 +1,126 @@
+package roundrobin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type AffinitySuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&AffinitySuite{})
+
+func (s *AffinitySuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *AffinitySuite) newLB(c *C) *RoundRobin {
+	lb, err := New(hostRecorder(), ConnAffinity(time.Minute), Clock(s.clock))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a:80"))
+	lb.UpsertServer(testutils.ParseURI("http://b:80"))
+	return lb
+}
+
+func (s *AffinitySuite) TestConnections(c *C) {
+	lb := s.newLB(c)
+	for _, conn := range []struct {
+		addr, host string
+	}{
+		{"10.0.0.1:1000", "a:80"},
+		{"10.0.0.1:2000", "b:80"},
+		{"10.0.0.2:1000", "a:80"},
+	} {
+		for i := 0; i < 3; i++ {
+			_, body := serveFrom(lb, conn.addr, nil)
+			c.Assert(body, Equals, conn.host, Commentf("%v", conn.addr))
+		}
+	}
+}
+
+func (s *AffinitySuite) TestPinnedServerGone(c *C) {
+	lb := s.newLB(c)
+	_, body := serveFrom(lb, "10.0.0.1:1000", nil)
+	c.Assert(body, Equals, "a:80")
+
+	c.Assert(lb.EjectServer(testutils.ParseURI("http://a:80"), time.Second), IsNil)
+	_, body = serveFrom(lb, "10.0.0.1:1000", nil)
+	c.Assert(body, Equals, "b:80")
+
+	// the connection stays on the new server after the ejection is over
+	s.clock.Sleep(2 * time.Second)
+	_, body = serveFrom(lb, "10.0.0.1:1000", nil)
+	c.Assert(body, Equals, "b:80")
+
+	c.Assert(lb.RemoveServer(testutils.ParseURI("http://b:80")), IsNil)
+	_, body = serveFrom(lb, "10.0.0.1:1000", nil)
+	c.Assert(body, Equals, "a:80")
+}
+
+func (s *AffinitySuite) TestTTL(c *C) {
+	lb := s.newLB(c)
+	serveFrom(lb, "10.0.0.1:1000", nil)
+	serveFrom(lb, "10.0.0.1:2000", nil)
+	c.Assert(lb.affinity.conns, HasLen, 2)
+
+	s.clock.Sleep(30 * time.Second)
+	serveFrom(lb, "10.0.0.1:1000", nil)
+	s.clock.Sleep(40 * time.Second)
+	serveFrom(lb, "10.0.0.1:1000", nil)
+	c.Assert(lb.affinity.conns, HasLen, 1)
+}
+
+func (s *AffinitySuite) TestConnState(c *C) {
+	lb, err := New(hostRecorder(), ConnAffinity(time.Minute))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a:80"))
+	lb.UpsertServer(testutils.ParseURI("http://b:80"))
+
+	srv := httptest.NewUnstartedServer(lb)
+	srv.Config.ConnState = lb.ConnState
+	srv.Start()
+	defer srv.Close()
+
+	get := func(client *http.Client) string {
+		re, err := client.Get(srv.URL)
+		c.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(re.Body)
+		re.Body.Close()
+		c.Assert(err, IsNil)
+		return string(body)
+	}
+	// every client has its own keep-alive connection
+	first, second := &http.Client{Transport: &http.Transport{}}, &http.Client{Transport: &http.Transport{}}
+	for i := 0; i < 3; i++ {
+		c.Assert(get(first), Equals, "a:80")
+		c.Assert(get(second), Equals, "b:80")
+	}
+
+	first.Transport.(*http.Transport).CloseIdleConnections()
+	second.Transport.(*http.Transport).CloseIdleConnections()
+	for i := 0; i < 100; i++ {
+		lb.mutex.Lock()
+		n := len(lb.affinity.conns)
+		lb.mutex.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("closed connections are still pinned")
+}
+
+func (s *AffinitySuite) TestBadOptions(c *C) {
+	_, err := New(nil, ConnAffinity(0))
+	c.Assert(err, NotNil)
+}
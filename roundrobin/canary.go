@@ -0,0 +1,319 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// CanaryBalancer is the load balancer the canary controller sets the weights of, it is implemented
+// by RoundRobin and Rebalancer
+type CanaryBalancer interface {
+	http.Handler
+	ServerSet
+	ServerStats(u *url.URL) (ServerStats, error)
+}
+
+// CanaryRules decide when the canary share is walked up or down, the zero values stand for the defaults
+type CanaryRules struct {
+	// Interval between the evaluations, it should not be shorter than the stats window of the balancer, 30 seconds by default
+	Interval time.Duration
+	// MinRequests each group should serve in the stats window for the evaluation, 100 by default
+	MinRequests int64
+	// MaxErrorRatioDiff is the error ratio the canary may exceed the stable group by, 0.01 by default
+	MaxErrorRatioDiff float64
+	// LatencyQuantile is one of StatsQuantiles the latencies of the groups are compared at, 99 by default
+	LatencyQuantile float64
+	// MaxLatencyRatio is the canary latency divided by the stable one the canary may reach, 1.2 by default
+	MaxLatencyRatio float64
+	// PromoteStep is the share in percents added when the canary is as good as the stable group, 10 by default
+	PromoteStep int
+	// RollbackStep is the share in percents taken when the canary is worse, the canary is rolled back at once by default
+	RollbackStep int
+}
+
+// CanaryState is the state of the canary rollout
+type CanaryState string
+
+const (
+	// CanaryProgressing is the rollout with the canary share being walked
+	CanaryProgressing CanaryState = "progressing"
+	// CanaryPromoted is the rollout with the canary serving all of the requests
+	CanaryPromoted CanaryState = "promoted"
+	// CanaryRolledBack is the rollout with the stable group serving all of the requests
+	CanaryRolledBack CanaryState = "rolled-back"
+)
+
+// CanaryOption is a functional option setter for Canary
+type CanaryOption func(*Canary) error
+
+// Canary compares the error ratio and the latency of the canary servers with the ones of the rest of the servers,
+// the stable group, and walks the share of the requests served by the canary up while it is as good as the stable
+// group and down once it is worse. The servers of both groups should have been added to the balancer,
+// the rollout ends when the canary serves all of the requests or none of them.
+//
+//	lb, _ := roundrobin.New(fwd)
+//	lb.UpsertServer(stable)
+//	lb.UpsertServer(canary)
+//	c, _ := roundrobin.NewCanary(lb, []*url.URL{canary}, roundrobin.CanaryRules{PromoteStep: 5})
+type Canary struct {
+	mtx      sync.Mutex
+	lb       CanaryBalancer
+	canaries []*url.URL
+	rules    CanaryRules
+	share    int
+	state    CanaryState
+	// timer is the time of the next evaluation
+	timer time.Time
+
+	clock timetools.TimeProvider
+	log   utils.Logger
+}
+
+// NewCanary starts the rollout of the canary servers with the initial share, PromoteStep by default
+func NewCanary(lb CanaryBalancer, canaries []*url.URL, rules CanaryRules, opts ...CanaryOption) (*Canary, error) {
+	if len(canaries) == 0 {
+		return nil, fmt.Errorf("at least one canary server is required")
+	}
+	r, err := rules.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	c := &Canary{lb: lb, canaries: canaries, rules: r, share: -1, state: CanaryProgressing}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.share == -1 {
+		c.share = c.rules.PromoteStep
+	}
+	if c.clock == nil {
+		c.clock = &timetools.RealTime{}
+	}
+	if c.log == nil {
+		c.log = utils.NullLogger
+	}
+	if err := c.applyShare(); err != nil {
+		return nil, err
+	}
+	c.timer = c.clock.UtcNow().Add(c.rules.Interval)
+	return c, nil
+}
+
+func (r CanaryRules) withDefaults() (CanaryRules, error) {
+	if r.Interval < 0 || r.MinRequests < 0 || r.MaxErrorRatioDiff < 0 || r.MaxLatencyRatio < 0 {
+		return r, fmt.Errorf("canary rules can not be negative: %+v", r)
+	}
+	if r.PromoteStep < 0 || r.PromoteStep > 100 || r.RollbackStep < 0 || r.RollbackStep > 100 {
+		return r, fmt.Errorf("canary steps should be in [0, 100], got %d, %d", r.PromoteStep, r.RollbackStep)
+	}
+	if r.Interval == 0 {
+		r.Interval = 30 * time.Second
+	}
+	if r.MinRequests == 0 {
+		r.MinRequests = 100
+	}
+	if r.MaxErrorRatioDiff == 0 {
+		r.MaxErrorRatioDiff = 0.01
+	}
+	if r.LatencyQuantile == 0 {
+		r.LatencyQuantile = 99
+	}
+	if r.MaxLatencyRatio == 0 {
+		r.MaxLatencyRatio = 1.2
+	}
+	if r.PromoteStep == 0 {
+		r.PromoteStep = 10
+	}
+	if r.RollbackStep == 0 {
+		r.RollbackStep = 100
+	}
+	for _, q := range StatsQuantiles {
+		if q == r.LatencyQuantile {
+			return r, nil
+		}
+	}
+	return r, fmt.Errorf("latency quantile should be one of %v, got %v", StatsQuantiles, r.LatencyQuantile)
+}
+
+// CanaryClock sets the clock of the evaluations
+func CanaryClock(clock timetools.TimeProvider) CanaryOption {
+	return func(c *Canary) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// CanaryLogger sets the logger used by the canary controller
+func CanaryLogger(log utils.Logger) CanaryOption {
+	return func(c *Canary) error {
+		c.log = log
+		return nil
+	}
+}
+
+// CanaryShare sets the initial share of the requests in percents served by the canary
+func CanaryShare(share int) CanaryOption {
+	return func(c *Canary) error {
+		if share <= 0 || share >= 100 {
+			return fmt.Errorf("canary share should be in (0, 100), got %d", share)
+		}
+		c.share = share
+		return nil
+	}
+}
+
+func (c *Canary) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.lb.ServeHTTP(w, req)
+	c.evaluate(false)
+}
+
+// Share returns the share of the requests in percents served by the canary
+func (c *Canary) Share() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.share
+}
+
+// State returns the state of the rollout
+func (c *Canary) State() CanaryState {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.state
+}
+
+// Evaluate compares the groups and walks the canary share without waiting for the interval to pass
+func (c *Canary) Evaluate() {
+	c.evaluate(true)
+}
+
+func (c *Canary) evaluate(now bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.state != CanaryProgressing || (!now && c.clock.UtcNow().Before(c.timer)) {
+		return
+	}
+	c.timer = c.clock.UtcNow().Add(c.rules.Interval)
+
+	canary, stable := c.groups()
+	if len(stable) == 0 {
+		return
+	}
+	cs, ss := c.groupStats(canary), c.groupStats(stable)
+	if cs.requests < c.rules.MinRequests || ss.requests < c.rules.MinRequests {
+		return
+	}
+	share := c.share
+	if reason := c.worse(cs, ss); reason != "" {
+		share -= c.rules.RollbackStep
+		c.log.Warningf("canary is worse than stable: %v, share %d%%", reason, c.share)
+	} else {
+		share += c.rules.PromoteStep
+	}
+	c.walk(share)
+}
+
+// worse returns the reason the canary is worse than the stable group, if it is
+func (c *Canary) worse(canary, stable groupStats) string {
+	ce, se := canary.errorRatio(), stable.errorRatio()
+	if ce > se+c.rules.MaxErrorRatioDiff {
+		return fmt.Sprintf("error ratio %.4f, stable %.4f", ce, se)
+	}
+	if stable.latency > 0 && float64(canary.latency) > float64(stable.latency)*c.rules.MaxLatencyRatio {
+		return fmt.Sprintf("latency at %v is %v, stable %v", c.rules.LatencyQuantile, canary.latency, stable.latency)
+	}
+	return ""
+}
+
+func (c *Canary) walk(share int) {
+	switch {
+	case share <= 0:
+		share = 0
+		c.state = CanaryRolledBack
+	case share >= 100:
+		share = 100
+		c.state = CanaryPromoted
+	}
+	c.log.Infof("canary share %d%% -> %d%%, %v", c.share, share, c.state)
+	c.share = share
+	if err := c.applyShare(); err != nil {
+		c.log.Errorf("failed to apply canary share %d%%: %v", share, err)
+	}
+}
+
+// applyShare sets the weights of the servers so the groups serve their shares of the requests
+// regardless of their sizes
+func (c *Canary) applyShare() error {
+	canary, stable := c.groups()
+	for _, u := range canary {
+		if err := c.lb.UpsertServer(u, Weight(c.share*len(stable))); err != nil {
+			return err
+		}
+	}
+	for _, u := range stable {
+		if err := c.lb.UpsertServer(u, Weight((100-c.share)*len(canary))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groups returns the canary and the stable servers of the balancer
+func (c *Canary) groups() (canary, stable []*url.URL) {
+	for _, u := range c.lb.Servers() {
+		if c.isCanary(u) {
+			canary = append(canary, u)
+		} else {
+			stable = append(stable, u)
+		}
+	}
+	return canary, stable
+}
+
+func (c *Canary) isCanary(u *url.URL) bool {
+	for _, cu := range c.canaries {
+		if sameURL(u, cu) {
+			return true
+		}
+	}
+	return false
+}
+
+type groupStats struct {
+	requests int64
+	errors   int64
+	// latency is the average of the latencies of the servers weighted by their requests
+	latency time.Duration
+}
+
+func (g groupStats) errorRatio() float64 {
+	if g.requests == 0 {
+		return 0
+	}
+	return float64(g.errors) / float64(g.requests)
+}
+
+func (c *Canary) groupStats(servers []*url.URL) groupStats {
+	var g groupStats
+	var latency float64
+	for _, u := range servers {
+		st, err := c.lb.ServerStats(u)
+		if err != nil {
+			c.log.Warningf("failed to get stats of %v: %v", u, err)
+		}
+		g.requests += st.Requests
+		g.errors += st.Errors
+		latency += float64(st.Latency[c.rules.LatencyQuantile]) * float64(st.Requests)
+	}
+	if g.requests != 0 {
+		g.latency = time.Duration(latency / float64(g.requests))
+	}
+	return g
+}
@@ -0,0 +1,139 @@
+package roundrobin
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type CanarySuite struct {
+	clock *timetools.FreezedTime
+	a     *url.URL
+	b     *url.URL
+	c     *url.URL
+}
+
+var _ = Suite(&CanarySuite{})
+
+func (s *CanarySuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	s.a, s.b, s.c = testutils.ParseURI("http://a"), testutils.ParseURI("http://b"), testutils.ParseURI("http://c")
+}
+
+// balancer has the stable servers a and b and the canary c served by the handler
+func (s *CanarySuite) balancer(c *C, canary http.HandlerFunc) *RoundRobin {
+	lb, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Host == "c" {
+			canary(w, req)
+			return
+		}
+		s.clock.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}), Clock(s.clock))
+	c.Assert(err, IsNil)
+	c.Assert(lb.UpsertServer(s.a), IsNil)
+	c.Assert(lb.UpsertServer(s.b), IsNil)
+	c.Assert(lb.UpsertServer(s.c), IsNil)
+	return lb
+}
+
+func (s *CanarySuite) weights(lb *RoundRobin) []int {
+	var out []int
+	for _, u := range []*url.URL{s.a, s.b, s.c} {
+		w, _ := lb.ServerWeight(u)
+		out = append(out, w)
+	}
+	return out
+}
+
+func (s *CanarySuite) TestPromote(c *C) {
+	lb := s.balancer(c, func(w http.ResponseWriter, req *http.Request) {
+		s.clock.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	cn, err := NewCanary(lb, []*url.URL{s.c}, CanaryRules{MinRequests: 10, PromoteStep: 50}, CanaryClock(s.clock))
+	c.Assert(err, IsNil)
+	c.Assert(cn.Share(), Equals, 50)
+	// the canary serves half of the requests, the stable servers a quarter each
+	c.Assert(s.weights(lb), DeepEquals, []int{50, 50, 100})
+
+	serve(cn, 40)
+	cn.Evaluate()
+	c.Assert(cn.Share(), Equals, 100)
+	c.Assert(cn.State(), Equals, CanaryPromoted)
+	c.Assert(s.weights(lb), DeepEquals, []int{0, 0, 200})
+
+	// the rollout is over
+	cn.Evaluate()
+	c.Assert(cn.Share(), Equals, 100)
+}
+
+func (s *CanarySuite) TestRollbackErrors(c *C) {
+	lb := s.balancer(c, func(w http.ResponseWriter, req *http.Request) {
+		s.clock.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	cn, err := NewCanary(lb, []*url.URL{s.c}, CanaryRules{MinRequests: 10}, CanaryClock(s.clock), CanaryShare(40))
+	c.Assert(err, IsNil)
+
+	serve(cn, 50)
+	cn.Evaluate()
+	c.Assert(cn.Share(), Equals, 0)
+	c.Assert(cn.State(), Equals, CanaryRolledBack)
+	c.Assert(s.weights(lb), DeepEquals, []int{100, 100, 0})
+}
+
+func (s *CanarySuite) TestRollbackLatency(c *C) {
+	lb := s.balancer(c, func(w http.ResponseWriter, req *http.Request) {
+		s.clock.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	cn, err := NewCanary(lb, []*url.URL{s.c}, CanaryRules{MinRequests: 10, RollbackStep: 10}, CanaryClock(s.clock), CanaryShare(30))
+	c.Assert(err, IsNil)
+
+	serve(cn, 40)
+	cn.Evaluate()
+	c.Assert(cn.Share(), Equals, 20)
+	c.Assert(cn.State(), Equals, CanaryProgressing)
+}
+
+func (s *CanarySuite) TestInterval(c *C) {
+	lb := s.balancer(c, func(w http.ResponseWriter, req *http.Request) {
+		s.clock.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	cn, err := NewCanary(lb, []*url.URL{s.c}, CanaryRules{Interval: time.Second, MinRequests: 10}, CanaryClock(s.clock))
+	c.Assert(err, IsNil)
+	c.Assert(cn.Share(), Equals, 10)
+
+	// not enough requests served by the canary yet
+	serve(cn, 20)
+	c.Assert(cn.Share(), Equals, 10)
+
+	// the evaluation follows the request once the interval passes
+	serve(cn, 200)
+	c.Assert(cn.Share() > 10, Equals, true)
+}
+
+func (s *CanarySuite) TestErrors(c *C) {
+	lb := s.balancer(c, func(w http.ResponseWriter, req *http.Request) {})
+
+	_, err := NewCanary(lb, nil, CanaryRules{})
+	c.Assert(err, NotNil)
+
+	_, err = NewCanary(lb, []*url.URL{s.c}, CanaryRules{LatencyQuantile: 95})
+	c.Assert(err, NotNil)
+
+	_, err = NewCanary(lb, []*url.URL{s.c}, CanaryRules{PromoteStep: 101})
+	c.Assert(err, NotNil)
+
+	_, err = NewCanary(lb, []*url.URL{s.c}, CanaryRules{}, CanaryShare(100))
+	c.Assert(err, NotNil)
+}
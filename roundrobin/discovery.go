@@ -0,0 +1,57 @@
+package roundrobin
+
+import (
+	"net/url"
+)
+
+// ServerSet is the set of servers kept in sync with the discovered endpoints, it is implemented
+// by RoundRobin and Rebalancer
+type ServerSet interface {
+	Servers() []*url.URL
+	UpsertServer(u *url.URL, options ...ServerOption) error
+	RemoveServer(u *url.URL) error
+}
+
+// Endpoint is the server found by the service discovery
+type Endpoint struct {
+	URL *url.URL
+	// Weight of the server, 0 stands for the default weight
+	Weight int
+	// Priority is the failover tier of the server, see Priority
+	Priority int
+	// Zone and ZoneHints are the locality of the server, see Zone
+	Zone      string
+	ZoneHints []string
+}
+
+// SyncServers makes the servers of the set match the endpoints: the endpoints are upserted with their
+// weights, priorities and zones and the servers absent from the endpoints are removed. It returns the first error
+// and keeps syncing the rest of the servers.
+func SyncServers(set ServerSet, endpoints []Endpoint) error {
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, u := range set.Servers() {
+		found := false
+		for _, e := range endpoints {
+			if sameURL(u, e.URL) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keep(set.RemoveServer(u))
+		}
+	}
+	for _, e := range endpoints {
+		weight := e.Weight
+		if weight == 0 {
+			weight = defaultWeight
+		}
+		keep(set.UpsertServer(e.URL, Weight(weight), Priority(e.Priority), Zone(e.Zone, e.ZoneHints...)))
+	}
+	return firstErr
+}
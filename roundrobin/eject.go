@@ -0,0 +1,60 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// EjectServer stops routing the requests to the server for the duration, e.g. while its circuit breaker
+// is tripped. The server keeps its weight and stats and is routed to again once the duration passes.
+// If all the servers with non zero weight are ejected the ejection is ignored, so the load balancer
+// keeps serving the requests instead of failing all of them.
+func (r *RoundRobin) EjectServer(u *url.URL, d time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, _ := r.findServerByURL(u)
+	if s == nil {
+		return fmt.Errorf("server not found")
+	}
+	s.ejectedUntil = r.stats.now().Add(d)
+	r.rebuildSchedule()
+	return nil
+}
+
+// Ejected tells whether the server is currently ejected
+func (r *RoundRobin) Ejected(u *url.URL) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, _ := r.findServerByURL(u)
+	return s != nil && s.ejected(r.stats.now())
+}
+
+func (s *server) ejected(now time.Time) bool {
+	return now.Before(s.ejectedUntil)
+}
+
+// eligible returns the filter of the servers the requests can be routed to: the servers of the most preferred
+// priority tier with servers left after the ejection. If all the servers with non zero weight are ejected
+// the ejection is ignored. Called with the lock held.
+func (r *RoundRobin) eligible(now time.Time) func(*server) bool {
+	tier, found := 0, false
+	for _, honorEjection := range []bool{true, false} {
+		for _, s := range r.servers {
+			if s.weight == 0 || (honorEjection && s.ejected(now)) {
+				continue
+			}
+			if !found || s.priority < tier {
+				tier, found = s.priority, true
+			}
+		}
+		if found {
+			return func(s *server) bool {
+				return s.priority == tier && !(honorEjection && s.ejected(now))
+			}
+		}
+	}
+	return func(*server) bool { return true }
+}
@@ -0,0 +1,59 @@
+package roundrobin
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type EjectSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&EjectSuite{})
+
+func (s *EjectSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *EjectSuite) TestEject(c *C) {
+	for _, opts := range [][]LBOption{
+		{Clock(s.clock)},
+		{Clock(s.clock), WeightedRandom(rand.NewSource(1))},
+	} {
+		lb, err := New(nil, opts...)
+		c.Assert(err, IsNil)
+		a, b := testutils.ParseURI("http://a"), testutils.ParseURI("http://b")
+		lb.UpsertServer(a)
+		lb.UpsertServer(b, Weight(2))
+
+		c.Assert(lb.EjectServer(b, time.Second), IsNil)
+		c.Assert(lb.Ejected(b), Equals, true)
+		c.Assert(picks(c, lb, 10), DeepEquals, map[string]int{"a": 10})
+
+		// all servers are ejected, the ejection is ignored
+		c.Assert(lb.EjectServer(a, 2*time.Second), IsNil)
+		got := picks(c, lb, 30)
+		c.Assert(got["a"] > 0 && got["b"] > 0, Equals, true, Commentf("%v", got))
+
+		s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Second)
+		c.Assert(lb.Ejected(b), Equals, false)
+		c.Assert(picks(c, lb, 10), DeepEquals, map[string]int{"b": 10})
+
+		s.clock.CurrentTime = s.clock.CurrentTime.Add(time.Second)
+		c.Assert(lb.Ejected(a), Equals, false)
+	}
+}
+
+func (s *EjectSuite) TestEjectUnknown(c *C) {
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	c.Assert(lb.EjectServer(testutils.ParseURI("http://a"), time.Second), NotNil)
+	c.Assert(lb.Ejected(testutils.ParseURI("http://a")), Equals, false)
+}
@@ -0,0 +1,129 @@
+package roundrobin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// DefaultOverrideHeader carries the server pinned by the trusted clients, see Override
+const DefaultOverrideHeader = "X-Oxy-Backend"
+
+// ReasonOverride is reported in Decision for the servers pinned with the override header
+const ReasonOverride = "override header"
+
+// override pins the server named in the header of the trusted requests
+type override struct {
+	header      string
+	nets        []*net.IPNet
+	tokenHeader string
+	token       string
+}
+
+// OverrideOption is a functional option setter for Override
+type OverrideOption func(o *override) error
+
+// OverrideHeader sets the header naming the server, DefaultOverrideHeader by default
+func OverrideHeader(h string) OverrideOption {
+	return func(o *override) error {
+		if h == "" {
+			return fmt.Errorf("override header can not be empty")
+		}
+		o.header = h
+		return nil
+	}
+}
+
+// OverrideFrom trusts the requests coming from the networks, the address of the connection is checked,
+// not X-Forwarded-For
+func OverrideFrom(cidrs ...string) OverrideOption {
+	return func(o *override) error {
+		nets, err := utils.ParseCIDRs(cidrs)
+		if err != nil {
+			return err
+		}
+		o.nets = append(o.nets, nets...)
+		return nil
+	}
+}
+
+// OverrideToken trusts the requests carrying the token in the header
+func OverrideToken(header, token string) OverrideOption {
+	return func(o *override) error {
+		if header == "" || token == "" {
+			return fmt.Errorf("token header and token can not be empty")
+		}
+		o.tokenHeader, o.token = header, token
+		return nil
+	}
+}
+
+// Override lets the trusted clients pin the server with the header carrying its URL or host:port, bypassing
+// the balancing, e.g. to reproduce the bug of the specific backend in production. Only the servers of the pool
+// can be pinned, ejected ones included, and the requests naming other servers fail. The requests are trusted
+// when they come from the networks set with OverrideFrom or carry the token set with OverrideToken.
+// The override and the token headers are removed from all requests before they are forwarded.
+//
+//	lb, _ := roundrobin.New(fwd, roundrobin.Override(roundrobin.OverrideFrom("10.0.0.0/8")))
+func Override(opts ...OverrideOption) LBOption {
+	return func(r *RoundRobin) error {
+		o := &override{header: DefaultOverrideHeader}
+		for _, opt := range opts {
+			if err := opt(o); err != nil {
+				return err
+			}
+		}
+		if len(o.nets) == 0 && o.token == "" {
+			return fmt.Errorf("provide trusted networks or token for the override")
+		}
+		r.override = o
+		return nil
+	}
+}
+
+// pinned returns the server named by the trusted request and strips the headers
+func (o *override) pinned(req *http.Request) (string, bool) {
+	v := strings.TrimSpace(req.Header.Get(o.header))
+	trusted := v != "" && o.trusted(req)
+	req.Header.Del(o.header)
+	if o.tokenHeader != "" {
+		req.Header.Del(o.tokenHeader)
+	}
+	return v, trusted
+}
+
+func (o *override) trusted(req *http.Request) bool {
+	if o.token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(o.tokenHeader)), []byte(o.token)) == 1 {
+		return true
+	}
+	if len(o.nets) == 0 {
+		return false
+	}
+	ip, err := utils.ClientIP(req, nil)
+	return err == nil && utils.ContainsIP(o.nets, ip)
+}
+
+// pinnedServer returns the server of the pool matching the URL or host:port, called with the lock held
+func (r *RoundRobin) pinnedServer(v string) (*server, error) {
+	if strings.Contains(v, "://") {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		if srv, i := r.findServerByURL(u); i != -1 {
+			return srv, nil
+		}
+	} else {
+		for _, srv := range r.servers {
+			if strings.EqualFold(srv.url.Host, v) {
+				return srv, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("pinned server %v is not in the pool", v)
+}
@@ -0,0 +1,97 @@
+package roundrobin
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type OverrideSuite struct{}
+
+var _ = Suite(&OverrideSuite{})
+
+// hostRecorder replies with the host of the chosen server and the override header seen by the backend
+func hostRecorder() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.Host + req.Header.Get(DefaultOverrideHeader) + req.Header.Get("X-Pin") + req.Header.Get("X-Debug-Token")))
+	})
+}
+
+func newOverrideLB(c *C, opts ...OverrideOption) *RoundRobin {
+	lb, err := New(hostRecorder(), Override(opts...))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a:80"))
+	lb.UpsertServer(testutils.ParseURI("http://b:80"))
+	return lb
+}
+
+func serveFrom(lb http.Handler, remoteAddr string, headers map[string]string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, "http://proxy/", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func (s *OverrideSuite) TestTrustedNetwork(c *C) {
+	lb := newOverrideLB(c, OverrideFrom("10.0.0.0/8"))
+
+	for i := 0; i < 3; i++ {
+		_, body := serveFrom(lb, "10.0.0.1:1234", map[string]string{DefaultOverrideHeader: "b:80"})
+		c.Assert(body, Equals, "b:80")
+	}
+	_, body := serveFrom(lb, "10.0.0.1:1234", map[string]string{DefaultOverrideHeader: "http://a:80"})
+	c.Assert(body, Equals, "a:80")
+
+	// the untrusted clients are balanced as usual, the pinned requests have not moved the iterator
+	var hosts []string
+	for i := 0; i < 2; i++ {
+		_, body := serveFrom(lb, "192.168.0.1:1234", map[string]string{DefaultOverrideHeader: "b:80"})
+		hosts = append(hosts, body)
+	}
+	c.Assert(hosts, DeepEquals, []string{"a:80", "b:80"})
+}
+
+func (s *OverrideSuite) TestToken(c *C) {
+	lb := newOverrideLB(c, OverrideHeader("X-Pin"), OverrideToken("X-Debug-Token", "secret"))
+
+	for i := 0; i < 2; i++ {
+		_, body := serveFrom(lb, "192.168.0.1:1234", map[string]string{"X-Pin": "a:80", "X-Debug-Token": "secret"})
+		c.Assert(body, Equals, "a:80")
+	}
+	// neither header is forwarded
+	_, body := serveFrom(lb, "192.168.0.1:1234", map[string]string{"X-Pin": "b:80", "X-Debug-Token": "wrong"})
+	c.Assert(body, Equals, "a:80")
+}
+
+func (s *OverrideSuite) TestUnknownServer(c *C) {
+	var decision Decision
+	lb, err := New(hostRecorder(), Override(OverrideFrom("127.0.0.1")), OnSelect(func(d Decision) { decision = d }))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a:80"))
+
+	// only the servers of the pool can be pinned
+	code, _ := serveFrom(lb, "127.0.0.1:1234", map[string]string{DefaultOverrideHeader: "evil:80"})
+	c.Assert(code, Equals, http.StatusInternalServerError)
+	c.Assert(decision.Reason, Equals, ReasonOverride)
+	c.Assert(decision.Err, NotNil)
+
+	_, body := serveFrom(lb, "127.0.0.1:1234", map[string]string{DefaultOverrideHeader: "a:80"})
+	c.Assert(body, Equals, "a:80")
+	c.Assert(decision.Reason, Equals, ReasonOverride)
+}
+
+func (s *OverrideSuite) TestBadOptions(c *C) {
+	_, err := New(nil, Override())
+	c.Assert(err, NotNil)
+	_, err = New(nil, Override(OverrideFrom("not an IP")))
+	c.Assert(err, NotNil)
+	_, err = New(nil, Override(OverrideToken("X-Token", "")))
+	c.Assert(err, NotNil)
+}
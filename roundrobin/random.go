@@ -0,0 +1,46 @@
+package roundrobin
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// WeightedRandom makes the load balancer pick the servers randomly in proportion to their weights instead of
+// iterating over them, so many proxy instances do not hit the servers in the same synchronized order.
+// The source is used under the load balancer's lock, nil stands for the source seeded with the current time.
+func WeightedRandom(src rand.Source) LBOption {
+	return func(r *RoundRobin) error {
+		if src == nil {
+			src = rand.NewSource(time.Now().UnixNano())
+		}
+		r.rnd = rand.New(src)
+		return nil
+	}
+}
+
+// randomServer picks the server with the probability of its weight to the total weight, called with the lock held
+func (r *RoundRobin) randomServer() (*server, error) {
+	eligible := r.eligible(r.stats.now())
+	weight := func(s *server) int {
+		if !eligible(s) {
+			return 0
+		}
+		return s.weight
+	}
+	total := 0
+	for _, s := range r.servers {
+		total += weight(s)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("all servers have 0 weight")
+	}
+	n := r.rnd.Intn(total)
+	for _, s := range r.servers {
+		if n < weight(s) {
+			return s, nil
+		}
+		n -= weight(s)
+	}
+	return nil, fmt.Errorf("no available servers")
+}
@@ -0,0 +1,65 @@
+package roundrobin
+
+import (
+	"math/rand"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type RandomSuite struct{}
+
+var _ = Suite(&RandomSuite{})
+
+func picks(c *C, lb *RoundRobin, n int) map[string]int {
+	out := make(map[string]int)
+	for i := 0; i < n; i++ {
+		u, err := lb.NextServer()
+		c.Assert(err, IsNil)
+		out[u.Host]++
+	}
+	return out
+}
+
+func (s *RandomSuite) TestWeights(c *C) {
+	lb, err := New(nil, WeightedRandom(rand.NewSource(1)))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"), Weight(3))
+	lb.UpsertServer(testutils.ParseURI("http://b"), Weight(1))
+
+	got := picks(c, lb, 4000)
+	c.Assert(got["a"] > 2800 && got["a"] < 3200, Equals, true, Commentf("%v", got))
+	c.Assert(got["a"]+got["b"], Equals, 4000)
+}
+
+func (s *RandomSuite) TestReproducible(c *C) {
+	sequence := func() []string {
+		lb, err := New(nil, WeightedRandom(rand.NewSource(42)))
+		c.Assert(err, IsNil)
+		lb.UpsertServer(testutils.ParseURI("http://a"))
+		lb.UpsertServer(testutils.ParseURI("http://b"))
+		lb.UpsertServer(testutils.ParseURI("http://c"))
+		var out []string
+		for i := 0; i < 20; i++ {
+			u, err := lb.NextServer()
+			c.Assert(err, IsNil)
+			out = append(out, u.Host)
+		}
+		return out
+	}
+	c.Assert(sequence(), DeepEquals, sequence())
+}
+
+func (s *RandomSuite) TestZeroWeights(c *C) {
+	lb, err := New(nil, WeightedRandom(nil))
+	c.Assert(err, IsNil)
+	_, err = lb.NextServer()
+	c.Assert(err, NotNil)
+
+	lb.UpsertServer(testutils.ParseURI("http://a"), Weight(0))
+	lb.UpsertServer(testutils.ParseURI("http://b"), Weight(1))
+	// upsert turns 0 into the default weight for the new servers, set it explicitly
+	lb.UpsertServer(testutils.ParseURI("http://a"), Weight(0))
+	c.Assert(picks(c, lb, 10), DeepEquals, map[string]int{"b": 10})
+}
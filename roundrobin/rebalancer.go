@@ -1,6 +1,7 @@
 package roundrobin
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -48,6 +49,9 @@ type Rebalancer struct {
 
 	// creates new meters
 	newMeter NewMeterFn
+
+	drain utils.Drainer
+	stats statsSet
 }
 
 func RebalancerLogger(log utils.Logger) RebalancerOption {
@@ -99,6 +103,7 @@ func NewRebalancer(handler balancerHandler, opts ...RebalancerOption) (*Rebalanc
 	if rb.clock == nil {
 		rb.clock = &timetools.RealTime{}
 	}
+	rb.stats.clock = rb.clock
 	if rb.backoffDuration == 0 {
 		rb.backoffDuration = 10 * time.Second
 	}
@@ -131,7 +136,52 @@ func (rb *Rebalancer) Servers() []*url.URL {
 	return rb.next.Servers()
 }
 
+func (rb *Rebalancer) ServerWeight(u *url.URL) (int, bool) {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	return rb.next.ServerWeight(u)
+}
+
+// NextServer returns the next server according to the current weights, so the rebalancer can pick servers
+// for hedged requests, see forward.Hedge
+func (rb *Rebalancer) NextServer() (*url.URL, error) {
+	return rb.next.NextServer()
+}
+
+// ServerStats returns the request count, errors, requests in flight and latency quantiles of the server,
+// along with its ejection and breaker state
+func (rb *Rebalancer) ServerStats(u *url.URL) (ServerStats, error) {
+	rb.mtx.Lock()
+	srv, i := rb.findServer(u)
+	rb.mtx.Unlock()
+	if i == -1 {
+		return ServerStats{}, fmt.Errorf("%v not found", u)
+	}
+	st, err := rb.stats.stats(srv.url)
+	if lb, ok := rb.next.(*RoundRobin); ok {
+		lb.addCircuit(&st)
+	}
+	return st, err
+}
+
+// Snapshots returns the metrics snapshots of the servers keyed by their URLs, see memmetrics.PublishExpvar
+func (rb *Rebalancer) Snapshots() map[string]memmetrics.Snapshot {
+	return rb.stats.snapshots()
+}
+
+// Close stops accepting new requests and waits for the requests in flight until the context is done
+func (rb *Rebalancer) Close(ctx context.Context) error {
+	return rb.drain.Close(ctx)
+}
+
 func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !rb.drain.Enter() {
+		rb.errHandler.ServeHTTP(w, req, utils.ErrShuttingDown)
+		return
+	}
+	defer rb.drain.Leave()
+
 	pw := &utils.ProxyWriter{W: w}
 	start := rb.clock.UtcNow()
 	url, err := rb.next.NextServer()
@@ -140,10 +190,12 @@ func (rb *Rebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	utils.SetBagValue(req, utils.BagBackend, url)
+
 	// make shallow copy of request before changing anything to avoid side effects
 	newReq := *req
 	newReq.URL = url
-	rb.next.Next().ServeHTTP(pw, &newReq)
+	rb.stats.serve(url, rb.next.Next(), pw, &newReq)
 
 	rb.recordMetrics(url, pw.Code, rb.clock.UtcNow().Sub(start))
 	rb.adjustWeights()
@@ -206,6 +258,7 @@ func (rb *Rebalancer) removeServer(u *url.URL) error {
 		return err
 	}
 	rb.servers = append(rb.servers[:i], rb.servers[i+1:]...)
+	rb.stats.remove(u)
 	rb.reset()
 	return nil
 }
@@ -213,6 +266,7 @@ func (rb *Rebalancer) removeServer(u *url.URL) error {
 func (rb *Rebalancer) upsertServer(u *url.URL, weight int) error {
 	if s, i := rb.findServer(u); i != -1 {
 		s.origWeight = weight
+		return nil
 	}
 	meter, err := rb.newMeter()
 	if err != nil {
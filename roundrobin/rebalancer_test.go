@@ -52,6 +52,28 @@ func (s *RBSuite) TestRebalancerNormalOperation(c *C) {
 	c.Assert(seq(c, proxy.URL, 3), DeepEquals, []string{"a", "a", "a"})
 }
 
+func (s *RBSuite) TestRebalancerNextServer(c *C) {
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+
+	lb, err := New(fwd)
+	c.Assert(err, IsNil)
+
+	rb, err := NewRebalancer(lb)
+	c.Assert(err, IsNil)
+
+	rb.UpsertServer(testutils.ParseURI("http://a"))
+	rb.UpsertServer(testutils.ParseURI("http://b"))
+
+	var hosts []string
+	for i := 0; i < 3; i++ {
+		u, err := rb.NextServer()
+		c.Assert(err, IsNil)
+		hosts = append(hosts, u.Host)
+	}
+	c.Assert(hosts, DeepEquals, []string{"a", "b", "a"})
+}
+
 func (s *RBSuite) TestRebalancerNoServers(c *C) {
 	fwd, err := forward.New()
 	c.Assert(err, IsNil)
@@ -342,3 +364,33 @@ func (tm *testMeter) Record(int, time.Duration) {
 func (tm *testMeter) IsReady() bool {
 	return !tm.notReady
 }
+
+// Upserting an existing server updates its weight instead of adding a duplicate record
+func (s *RBSuite) TestRebalancerUpsertExisting(c *C) {
+	a, b := testutils.NewResponder("a"), testutils.NewResponder("b")
+	defer a.Close()
+	defer b.Close()
+
+	fwd, err := forward.New()
+	c.Assert(err, IsNil)
+
+	lb, err := New(fwd)
+	c.Assert(err, IsNil)
+
+	rb, err := NewRebalancer(lb)
+	c.Assert(err, IsNil)
+
+	rb.UpsertServer(testutils.ParseURI(a.URL))
+	rb.UpsertServer(testutils.ParseURI(b.URL))
+	rb.UpsertServer(testutils.ParseURI(a.URL), Weight(0))
+
+	c.Assert(len(rb.servers), Equals, 2)
+	w, ok := rb.ServerWeight(testutils.ParseURI(a.URL))
+	c.Assert(ok, Equals, true)
+	c.Assert(w, Equals, 0)
+
+	proxy := httptest.NewServer(rb)
+	defer proxy.Close()
+
+	c.Assert(seq(c, proxy.URL, 3), DeepEquals, []string{"b", "b", "b"})
+}
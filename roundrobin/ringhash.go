@@ -0,0 +1,301 @@
+package roundrobin
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// RingHash is the consistent hashing load balancer: the requests with the same key, e.g. the same path or
+// the same client, go to the same server, and removing a server moves only the keys of that server.
+// With BoundedLoad no server gets more than (1+ε) times the average of the requests in flight,
+// the overflowing requests go to the next server on the ring (consistent hashing with bounded loads).
+type RingHash struct {
+	mtx        sync.Mutex
+	next       http.Handler
+	extract    utils.SourceExtractor
+	errHandler utils.ErrorHandler
+
+	replicas int
+	epsilon  float64
+
+	servers []*server
+	ring    []ringPoint
+	active  map[*server]int64
+	total   int64
+
+	drain utils.Drainer
+}
+
+type ringPoint struct {
+	hash uint64
+	srv  *server
+}
+
+// RingOption is a functional option setter for RingHash
+type RingOption func(*RingHash) error
+
+// RingReplicas sets the number of the points a server of weight 1 has on the ring, 100 by default,
+// the servers with bigger weights get proportionally more points
+func RingReplicas(n int) RingOption {
+	return func(r *RingHash) error {
+		if n <= 0 {
+			return fmt.Errorf("replicas should be > 0")
+		}
+		r.replicas = n
+		return nil
+	}
+}
+
+// BoundedLoad limits the requests in flight of every server to (1+epsilon) times the average,
+// e.g. 0.25 allows servers to be 25% more loaded than the average
+func BoundedLoad(epsilon float64) RingOption {
+	return func(r *RingHash) error {
+		if epsilon <= 0 {
+			return fmt.Errorf("epsilon should be > 0")
+		}
+		r.epsilon = epsilon
+		return nil
+	}
+}
+
+// RingErrorHandler sets the error handler of the balancer
+func RingErrorHandler(h utils.ErrorHandler) RingOption {
+	return func(r *RingHash) error {
+		r.errHandler = h
+		return nil
+	}
+}
+
+// NewRingHash returns the balancer hashing the keys returned by the extractor
+func NewRingHash(next http.Handler, extract utils.SourceExtractor, opts ...RingOption) (*RingHash, error) {
+	if extract == nil {
+		return nil, fmt.Errorf("provide the key extractor")
+	}
+	r := &RingHash{
+		next:     next,
+		extract:  extract,
+		replicas: 100,
+		active:   make(map[*server]int64),
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.errHandler == nil {
+		r.errHandler = utils.DefaultHandler
+	}
+	return r, nil
+}
+
+func (r *RingHash) Next() http.Handler {
+	return r.next
+}
+
+// Wrap sets the next handler the requests are passed to after the server has been selected
+func (r *RingHash) Wrap(next http.Handler) {
+	r.next = next
+}
+
+// Close stops accepting new requests and waits for the requests in flight until the context is done
+func (r *RingHash) Close(ctx context.Context) error {
+	return r.drain.Close(ctx)
+}
+
+func (r *RingHash) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.drain.Enter() {
+		r.errHandler.ServeHTTP(w, req, utils.ErrShuttingDown)
+		return
+	}
+	defer r.drain.Leave()
+
+	key, _, err := r.extract.Extract(req)
+	if err != nil {
+		r.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	srv, err := r.acquire(key)
+	if err != nil {
+		r.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	defer r.release(srv)
+
+	utils.SetBagValue(req, utils.BagBackend, srv.url)
+	req.Host = srv.url.Host
+	req.URL.Host = srv.url.Host
+	req.URL.Scheme = srv.url.Scheme
+	r.next.ServeHTTP(w, req)
+}
+
+// ServerFor returns the server the key maps to at the moment, taking the current loads into account
+func (r *RingHash) ServerFor(key string) (*url.URL, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	srv, err := r.pick(key)
+	if err != nil {
+		return nil, err
+	}
+	return srv.url, nil
+}
+
+func (r *RingHash) acquire(key string) (*server, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	srv, err := r.pick(key)
+	if err != nil {
+		return nil, err
+	}
+	r.active[srv]++
+	r.total++
+	return srv, nil
+}
+
+func (r *RingHash) release(srv *server) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.total--
+	// the server could have been removed while serving the request
+	if _, ok := r.active[srv]; ok {
+		r.active[srv]--
+	}
+}
+
+// pick walks the ring clockwise from the key's hash to the first server with spare capacity
+func (r *RingHash) pick(key string) (*server, error) {
+	if len(r.ring) == 0 {
+		return nil, fmt.Errorf("no servers in the pool")
+	}
+	h := hashKey(key)
+	start := sort.Search(len(r.ring), func(i int) bool { return r.ring[i].hash >= h })
+	if r.epsilon == 0 {
+		return r.ring[start%len(r.ring)].srv, nil
+	}
+	totalWeight := 0
+	for _, s := range r.servers {
+		totalWeight += s.weight
+	}
+	for i := 0; i < len(r.ring); i++ {
+		srv := r.ring[(start+i)%len(r.ring)].srv
+		if float64(r.active[srv]) < r.capacity(srv, totalWeight) {
+			return srv, nil
+		}
+	}
+	// not reachable as the capacities add up to more than the requests in flight
+	return r.ring[start%len(r.ring)].srv, nil
+}
+
+// capacity is the server's share of (1+epsilon) times the requests in flight including the new one
+func (r *RingHash) capacity(srv *server, totalWeight int) float64 {
+	share := float64(r.total+1) * float64(srv.weight) / float64(totalWeight)
+	return math.Ceil(share * (1 + r.epsilon))
+}
+
+func (r *RingHash) Servers() []*url.URL {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	out := make([]*url.URL, len(r.servers))
+	for i, srv := range r.servers {
+		out[i] = srv.url
+	}
+	return out
+}
+
+func (r *RingHash) ServerWeight(u *url.URL) (int, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if s, _ := r.findServer(u); s != nil {
+		return s.weight, true
+	}
+	return -1, false
+}
+
+// UpsertServer adds the server or updates its weight, the servers of 0 weight are kept off the ring
+func (r *RingHash) UpsertServer(u *url.URL, options ...ServerOption) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if u == nil {
+		return fmt.Errorf("server URL can't be nil")
+	}
+	if s, _ := r.findServer(u); s != nil {
+		for _, o := range options {
+			if err := o(s); err != nil {
+				return err
+			}
+		}
+		r.rebuild()
+		return nil
+	}
+	srv := &server{url: utils.CopyURL(u)}
+	for _, o := range options {
+		if err := o(srv); err != nil {
+			return err
+		}
+	}
+	if srv.weight == 0 {
+		srv.weight = defaultWeight
+	}
+	r.servers = append(r.servers, srv)
+	r.active[srv] = 0
+	r.rebuild()
+	return nil
+}
+
+func (r *RingHash) RemoveServer(u *url.URL) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	s, i := r.findServer(u)
+	if s == nil {
+		return fmt.Errorf("server not found")
+	}
+	r.servers = append(r.servers[:i], r.servers[i+1:]...)
+	delete(r.active, s)
+	r.rebuild()
+	return nil
+}
+
+func (r *RingHash) findServer(u *url.URL) (*server, int) {
+	for i, s := range r.servers {
+		if sameURL(u, s.url) {
+			return s, i
+		}
+	}
+	return nil, -1
+}
+
+func (r *RingHash) rebuild() {
+	r.ring = r.ring[:0]
+	for _, s := range r.servers {
+		for i := 0; i < s.weight*r.replicas; i++ {
+			r.ring = append(r.ring, ringPoint{hash: hashKey(s.url.String() + "-" + strconv.Itoa(i)), srv: s})
+		}
+	}
+	sort.Slice(r.ring, func(i, j int) bool { return r.ring[i].hash < r.ring[j].hash })
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// fnv spreads the similar keys poorly over the high bits, mix them
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
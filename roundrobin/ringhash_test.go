@@ -0,0 +1,150 @@
+package roundrobin
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type RingHashSuite struct{}
+
+var _ = Suite(&RingHashSuite{})
+
+var pathKey = utils.ExtractorFunc(func(req *http.Request) (string, int64, error) {
+	return req.URL.Path, 1, nil
+})
+
+func newRing(c *C, opts ...RingOption) *RingHash {
+	r, err := NewRingHash(nil, pathKey, opts...)
+	c.Assert(err, IsNil)
+	for _, host := range []string{"a", "b", "c"} {
+		c.Assert(r.UpsertServer(testutils.ParseURI("http://"+host)), IsNil)
+	}
+	return r
+}
+
+func mapping(c *C, r *RingHash, n int) map[string]string {
+	out := make(map[string]string)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("/key/%d", i)
+		u, err := r.ServerFor(key)
+		c.Assert(err, IsNil)
+		out[key] = u.Host
+	}
+	return out
+}
+
+func (s *RingHashSuite) TestConsistency(c *C) {
+	r := newRing(c)
+	before := mapping(c, r, 1000)
+
+	counts := make(map[string]int)
+	for _, host := range before {
+		counts[host]++
+	}
+	for _, host := range []string{"a", "b", "c"} {
+		c.Assert(counts[host] > 200, Equals, true, Commentf("%v", counts))
+	}
+
+	// only the keys of the removed server move
+	c.Assert(r.RemoveServer(testutils.ParseURI("http://b")), IsNil)
+	after := mapping(c, r, 1000)
+	for key, host := range before {
+		if host != "b" {
+			c.Assert(after[key], Equals, host)
+		} else {
+			c.Assert(after[key], Not(Equals), "b")
+		}
+	}
+}
+
+func (s *RingHashSuite) TestBoundedLoad(c *C) {
+	r := newRing(c, BoundedLoad(0.25))
+	home, err := r.ServerFor("/hot")
+	c.Assert(err, IsNil)
+
+	var acquired []*server
+	for i := 0; i < 30; i++ {
+		srv, err := r.acquire("/hot")
+		c.Assert(err, IsNil)
+		acquired = append(acquired, srv)
+		total := float64(len(acquired))
+		for srv, active := range r.active {
+			c.Assert(float64(active) <= math.Ceil(total/3*1.25), Equals, true, Commentf("%v: %v of %v", srv.url, active, total))
+		}
+	}
+	// the hot key stays on its server as long as it has capacity
+	c.Assert(acquired[0].url.Host, Equals, home.Host)
+
+	for _, srv := range acquired {
+		r.release(srv)
+	}
+	c.Assert(r.total, Equals, int64(0))
+	u, err := r.ServerFor("/hot")
+	c.Assert(err, IsNil)
+	c.Assert(u.Host, Equals, home.Host)
+}
+
+func (s *RingHashSuite) TestUnboundedLoad(c *C) {
+	r := newRing(c)
+	home, err := r.ServerFor("/hot")
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		srv, err := r.acquire("/hot")
+		c.Assert(err, IsNil)
+		c.Assert(srv.url.Host, Equals, home.Host)
+	}
+}
+
+func (s *RingHashSuite) TestServeHTTP(c *C) {
+	var hosts []string
+	r := newRing(c)
+	r.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hosts = append(hosts, req.URL.Host)
+	}))
+	for i := 0; i < 3; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/path", nil))
+	}
+	c.Assert(hosts, HasLen, 3)
+	c.Assert(hosts[1], Equals, hosts[0])
+	c.Assert(hosts[2], Equals, hosts[0])
+	c.Assert(r.total, Equals, int64(0))
+
+	empty, err := NewRingHash(nil, pathKey)
+	c.Assert(err, IsNil)
+	w := httptest.NewRecorder()
+	empty.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/path", nil))
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+}
+
+func (s *RingHashSuite) TestWeights(c *C) {
+	r := newRing(c)
+	c.Assert(r.UpsertServer(testutils.ParseURI("http://a"), Weight(4)), IsNil)
+	w, ok := r.ServerWeight(testutils.ParseURI("http://a"))
+	c.Assert(ok, Equals, true)
+	c.Assert(w, Equals, 4)
+
+	counts := make(map[string]int)
+	for _, host := range mapping(c, r, 1200) {
+		counts[host]++
+	}
+	c.Assert(counts["a"] > counts["b"]*2, Equals, true, Commentf("%v", counts))
+	c.Assert(r.Servers(), HasLen, 3)
+}
+
+func (s *RingHashSuite) TestBadOptions(c *C) {
+	_, err := NewRingHash(nil, nil)
+	c.Assert(err, NotNil)
+	_, err = NewRingHash(nil, pathKey, RingReplicas(0))
+	c.Assert(err, NotNil)
+	_, err = NewRingHash(nil, pathKey, BoundedLoad(0))
+	c.Assert(err, NotNil)
+	r := newRing(c)
+	c.Assert(r.RemoveServer(testutils.ParseURI("http://d")), NotNil)
+}
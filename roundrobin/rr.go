@@ -2,12 +2,19 @@
 package roundrobin
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mailgun/oxy/memmetrics"
 	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
 )
 
 // Weight is an optional functional argument that sets weight of the server
@@ -21,6 +28,30 @@ func Weight(w int) ServerOption {
 	}
 }
 
+// Priority sets the failover tier of the server, e.g. from the SRV record. The requests are routed to the servers
+// of the lowest priority value only, the servers of the next tier are used once all of them are ejected
+// or have 0 weight. All servers have priority 0 by default.
+func Priority(p int) ServerOption {
+	return func(s *server) error {
+		if p < 0 {
+			return fmt.Errorf("Priority should be >= 0")
+		}
+		s.priority = p
+		return nil
+	}
+}
+
+// Zone sets the zone the server runs in and the zones it should serve, e.g. the EndpointSlice topology hints.
+// The load balancer does not use them itself, they are passed to the Selector in the candidates
+// so the locality aware selectors can prefer the servers of the local zone.
+func Zone(zone string, hints ...string) ServerOption {
+	return func(s *server) error {
+		s.zone = zone
+		s.zoneHints = hints
+		return nil
+	}
+}
+
 // ErrorHandler is a functional argument that sets error handler of the server
 func ErrorHandler(h utils.ErrorHandler) LBOption {
 	return func(s *RoundRobin) error {
@@ -29,6 +60,14 @@ func ErrorHandler(h utils.ErrorHandler) LBOption {
 	}
 }
 
+// Clock is a functional argument that sets the clock used to measure the latencies of the servers
+func Clock(clock timetools.TimeProvider) LBOption {
+	return func(s *RoundRobin) error {
+		s.stats.clock = clock
+		return nil
+	}
+}
+
 type RoundRobin struct {
 	mutex      *sync.Mutex
 	next       http.Handler
@@ -37,6 +76,21 @@ type RoundRobin struct {
 	index         int
 	servers       []*server
 	currentWeight int
+
+	drain utils.Drainer
+	stats statsSet
+	// rnd is set for the weighted random selection
+	rnd *rand.Rand
+	// selector replaces the built-in selection, onSelect records the decisions
+	selector Selector
+	onSelect func(Decision)
+	override *override
+	affinity *connAffinity
+	// circuits is set by SetCircuitStates
+	circuits CircuitStates
+	// schedule and cursor pick the servers of the plain round robin without the lock
+	schedule atomic.Value
+	cursor   uint64
 }
 
 func New(next http.Handler, opts ...LBOption) (*RoundRobin, error) {
@@ -61,39 +115,134 @@ func (r *RoundRobin) Next() http.Handler {
 	return r.next
 }
 
+// Wrap sets the next handler the requests are passed to after the server has been selected
+func (r *RoundRobin) Wrap(next http.Handler) {
+	r.next = next
+}
+
+// Close stops accepting new requests and waits for the requests in flight until the context is done
+func (r *RoundRobin) Close(ctx context.Context) error {
+	return r.drain.Close(ctx)
+}
+
 func (r *RoundRobin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	url, err := r.NextServer()
+	if !r.drain.Enter() {
+		r.errHandler.ServeHTTP(w, req, utils.ErrShuttingDown)
+		return
+	}
+	defer r.drain.Leave()
+
+	start := r.stats.now()
+	srv, err := r.selectServer(req)
+	utils.AddTiming(req, utils.TimingBalance, r.stats.now().Sub(start))
 	if err != nil {
 		r.errHandler.ServeHTTP(w, req, err)
 		return
 	}
+	url := srv.url
 
+	utils.SetBagValue(req, utils.BagBackend, url)
 	req.Host = url.Host
 	req.URL.Host = url.Host
 	req.URL.Scheme = url.Scheme
-	r.next.ServeHTTP(w, req)
+	r.stats.serve(url, r.next, w, req)
 }
 
 func (r *RoundRobin) NextServer() (*url.URL, error) {
-	srv, err := r.nextServer()
+	srv, err := r.selectServer(nil)
 	if err != nil {
 		return nil, err
 	}
 	return srv.url, nil
 }
 
-func (r *RoundRobin) nextServer() (*server, error) {
+// NextServerExcluding picks the server like NextServer skipping the servers with the hosts of the excluded URLs,
+// e.g. the ones already tried by the request retried on another backend
+func (r *RoundRobin) NextServerExcluding(excluded ...*url.URL) (*url.URL, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	if len(r.servers) == 0 {
 		return nil, fmt.Errorf("no servers in the pool")
 	}
+	// the full round of the weighted iteration visits every server
+	picks, gcd := 0, r.weightGcd()
+	for _, s := range r.servers {
+		if gcd > 0 {
+			picks += s.weight / gcd
+		}
+	}
+	if picks < len(r.servers) {
+		picks = len(r.servers)
+	}
+	for i := 0; i < picks; i++ {
+		srv, _, err := r.balancedServer(nil)
+		if err != nil {
+			return nil, err
+		}
+		if !excludedHost(srv.url, excluded) {
+			return srv.url, nil
+		}
+	}
+	return nil, fmt.Errorf("no available servers besides the excluded ones")
+}
+
+func excludedHost(u *url.URL, excluded []*url.URL) bool {
+	for _, e := range excluded {
+		if e != nil && strings.EqualFold(e.Host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextServer picks the server with the configured strategy and returns the reason, called with the lock held
+func (r *RoundRobin) nextServer(req *http.Request) (*server, string, error) {
+	if len(r.servers) == 0 {
+		return nil, "", fmt.Errorf("no servers in the pool")
+	}
+	if r.override != nil && req != nil {
+		if v, ok := r.override.pinned(req); ok {
+			srv, err := r.pinnedServer(v)
+			return srv, ReasonOverride, err
+		}
+	}
+	if r.affinity == nil || req == nil {
+		return r.balancedServer(req)
+	}
+	now := r.stats.now()
+	if srv := r.pinnedConnServer(req, now); srv != nil {
+		return srv, ReasonConnAffinity, nil
+	}
+	srv, reason, err := r.balancedServer(req)
+	if err == nil {
+		r.pinConn(req, srv, now)
+	}
+	return srv, reason, err
+}
+
+// balancedServer picks the server with the selector or the built-in strategy, called with the lock held
+func (r *RoundRobin) balancedServer(req *http.Request) (*server, string, error) {
+	if r.selector != nil {
+		return r.selectedServer(req)
+	}
+	if r.rnd != nil {
+		srv, err := r.randomServer()
+		return srv, ReasonWeightedRandom, err
+	}
+	srv, err := r.roundRobinServer()
+	return srv, ReasonRoundRobin, err
+}
+
+// roundRobinServer iterates over the servers in proportion to their weights, called with the lock held
+func (r *RoundRobin) roundRobinServer() (*server, error) {
 
 	// The algo below may look messy, but is actually very simple
 	// it calculates the GCD  and subtracts it on every iteration, what interleaves servers
 	// and allows us not to build an iterator every time we readjust weights
 
+	eligible := r.eligible(r.stats.now())
+
 	// GCD across all enabled servers
 	gcd := r.weightGcd()
 	// Maximum weight across all enabled servers
@@ -111,7 +260,7 @@ func (r *RoundRobin) nextServer() (*server, error) {
 			}
 		}
 		srv := r.servers[r.index]
-		if srv.weight >= r.currentWeight {
+		if srv.weight >= r.currentWeight && eligible(srv) {
 			return srv, nil
 		}
 	}
@@ -128,6 +277,7 @@ func (r *RoundRobin) RemoveServer(u *url.URL) error {
 		return fmt.Errorf("server not found")
 	}
 	r.servers = append(r.servers[:index], r.servers[index+1:]...)
+	r.stats.remove(u)
 	r.resetState()
 	return nil
 }
@@ -153,6 +303,50 @@ func (rr *RoundRobin) ServerWeight(u *url.URL) (int, bool) {
 	return -1, false
 }
 
+// ServerStats returns the request count, errors, requests in flight and latency quantiles of the server,
+// along with its ejection and breaker state
+func (rr *RoundRobin) ServerStats(u *url.URL) (ServerStats, error) {
+	rr.mutex.Lock()
+	s, _ := rr.findServerByURL(u)
+	rr.mutex.Unlock()
+	if s == nil {
+		return ServerStats{}, fmt.Errorf("server not found")
+	}
+	st, err := rr.stats.stats(s.url)
+	rr.addCircuit(&st)
+	return st, err
+}
+
+// SetCircuitStates sets the reporter of the breaker states included in ServerStats, e.g. cbreaker.PerServer
+// placed after the load balancer
+func (rr *RoundRobin) SetCircuitStates(c CircuitStates) {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	rr.circuits = c
+}
+
+// addCircuit adds the ejection and the breaker state of the server to the stats
+func (rr *RoundRobin) addCircuit(st *ServerStats) {
+	rr.mutex.Lock()
+	s, _ := rr.findServerByURL(st.URL)
+	circuits := rr.circuits
+	if s != nil {
+		if now := rr.stats.now(); s.ejected(now) {
+			st.Ejected, st.ReadmitIn = true, s.ejectedUntil.Sub(now)
+		}
+	}
+	rr.mutex.Unlock()
+
+	if circuits != nil {
+		st.Circuit, _ = circuits.CircuitState(st.URL)
+	}
+}
+
+// Snapshots returns the metrics snapshots of the servers keyed by their URLs, see memmetrics.PublishExpvar
+func (rr *RoundRobin) Snapshots() map[string]memmetrics.Snapshot {
+	return rr.stats.snapshots()
+}
+
 // In case if server is already present in the load balancer, returns error
 func (rr *RoundRobin) UpsertServer(u *url.URL, options ...ServerOption) error {
 	rr.mutex.Lock()
@@ -195,6 +389,7 @@ func (r *RoundRobin) resetIterator() {
 
 func (r *RoundRobin) resetState() {
 	r.resetIterator()
+	r.rebuildSchedule()
 }
 
 func (r *RoundRobin) findServerByURL(u *url.URL) (*server, int) {
@@ -249,6 +444,13 @@ type server struct {
 	url *url.URL
 	// Relative weight for the enpoint to other enpoints in the load balancer
 	weight int
+	// ejectedUntil is set by EjectServer
+	ejectedUntil time.Time
+	// priority is the failover tier of the server, the lower the more preferred
+	priority int
+	// zone and zoneHints are set by Zone
+	zone      string
+	zoneHints []string
 }
 
 const defaultWeight = 1
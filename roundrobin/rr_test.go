@@ -214,3 +214,19 @@ func seq(c *C, url string, repeat int) []string {
 	}
 	return out
 }
+
+func (s *RRSuite) TestNextServerExcluding(c *C) {
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a:80"), Weight(3))
+	lb.UpsertServer(testutils.ParseURI("http://b:80"), Weight(2))
+	lb.UpsertServer(testutils.ParseURI("http://c:80"))
+
+	for i := 0; i < 10; i++ {
+		u, err := lb.NextServerExcluding(testutils.ParseURI("http://a:80/path"), testutils.ParseURI("http://b:80"))
+		c.Assert(err, IsNil)
+		c.Assert(u.Host, Equals, "c:80")
+	}
+	_, err = lb.NextServerExcluding(testutils.ParseURI("http://a:80"), testutils.ParseURI("http://b:80"), testutils.ParseURI("http://c:80"))
+	c.Assert(err, NotNil)
+}
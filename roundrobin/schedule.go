@@ -0,0 +1,86 @@
+package roundrobin
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// maxScheduleLen limits the schedule built for the weights far apart, the servers are picked with the lock held then
+const maxScheduleLen = 4096
+
+// schedule is the full cycle of the weighted round robin over the eligible servers. It is rebuilt with the lock held
+// on every change of the servers and replaced atomically, so the plain round robin picks the servers without the lock.
+type schedule struct {
+	servers []*server
+	// until is the time the first ejected server is readmitted, the schedule is stale afterwards
+	until time.Time
+}
+
+// scheduleBox keeps the type stored in atomic.Value the same for the nil schedule
+type scheduleBox struct {
+	s *schedule
+}
+
+// lockFree tells whether the servers are picked from the schedule, the other strategies need the lock
+func (r *RoundRobin) lockFree() bool {
+	return r.selector == nil && r.rnd == nil && r.affinity == nil && r.override == nil && r.onSelect == nil
+}
+
+// scheduledServer picks the next server of the schedule, false if the schedule is missing or stale
+func (r *RoundRobin) scheduledServer() (*server, bool) {
+	b, _ := r.schedule.Load().(scheduleBox)
+	if b.s == nil || b.s.stale(r.stats.now()) {
+		return nil, false
+	}
+	i := atomic.AddUint64(&r.cursor, 1) - 1
+	return b.s.servers[i%uint64(len(b.s.servers))], true
+}
+
+// refreshSchedule rebuilds the stale schedule once the ejected server is readmitted, called with the lock held
+func (r *RoundRobin) refreshSchedule() {
+	if b, _ := r.schedule.Load().(scheduleBox); b.s != nil && b.s.stale(r.stats.now()) {
+		r.rebuildSchedule()
+	}
+}
+
+func (s *schedule) stale(now time.Time) bool {
+	return !s.until.IsZero() && !now.Before(s.until)
+}
+
+// rebuildSchedule replaces the schedule with the cycle of the round robin from its initial state, called with
+// the lock held. The schedule is dropped if there are no available servers or the cycle is too long.
+func (r *RoundRobin) rebuildSchedule() {
+	if !r.lockFree() {
+		return
+	}
+	now := r.stats.now()
+	s := &schedule{}
+	for _, srv := range r.servers {
+		if srv.ejected(now) && (s.until.IsZero() || srv.ejectedUntil.Before(s.until)) {
+			s.until = srv.ejectedUntil
+		}
+	}
+	eligible := r.eligible(now)
+	gcd, max := r.weightGcd(), r.maxWeight()
+	if len(r.servers) == 0 || max <= 0 {
+		r.schedule.Store(scheduleBox{})
+		return
+	}
+	for cw := max; cw > 0; cw -= gcd {
+		for _, srv := range r.servers {
+			if srv.weight >= cw && eligible(srv) {
+				s.servers = append(s.servers, srv)
+			}
+		}
+		if len(s.servers) > maxScheduleLen {
+			r.schedule.Store(scheduleBox{})
+			return
+		}
+	}
+	if len(s.servers) == 0 {
+		r.schedule.Store(scheduleBox{})
+		return
+	}
+	atomic.StoreUint64(&r.cursor, 0)
+	r.schedule.Store(scheduleBox{s: s})
+}
@@ -0,0 +1,95 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ScheduleSuite struct{}
+
+var _ = Suite(&ScheduleSuite{})
+
+func nextHosts(c *C, lb *RoundRobin, n int) []string {
+	var out []string
+	for i := 0; i < n; i++ {
+		u, err := lb.NextServer()
+		c.Assert(err, IsNil)
+		out = append(out, u.Host)
+	}
+	return out
+}
+
+// the schedule picks the servers in the same order as the round robin with the lock
+func (s *ScheduleSuite) TestSameOrder(c *C) {
+	scheduled, err := New(nil)
+	c.Assert(err, IsNil)
+	locked, err := New(nil, OnSelect(func(Decision) {}))
+	c.Assert(err, IsNil)
+	for _, lb := range []*RoundRobin{scheduled, locked} {
+		lb.UpsertServer(testutils.ParseURI("http://a"), Weight(3))
+		lb.UpsertServer(testutils.ParseURI("http://b"), Weight(2))
+		lb.UpsertServer(testutils.ParseURI("http://d"), Weight(1))
+	}
+	c.Assert(nextHosts(c, scheduled, 20), DeepEquals, nextHosts(c, locked, 20))
+	b, _ := scheduled.schedule.Load().(scheduleBox)
+	c.Assert(b.s, NotNil)
+	c.Assert(len(b.s.servers), Equals, 6)
+}
+
+// the weights far apart are picked with the lock
+func (s *ScheduleSuite) TestLongCycle(c *C) {
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"), Weight(maxScheduleLen))
+	lb.UpsertServer(testutils.ParseURI("http://b"), Weight(1))
+
+	b, _ := lb.schedule.Load().(scheduleBox)
+	c.Assert(b.s, IsNil)
+	c.Assert(nextHosts(c, lb, 3), DeepEquals, []string{"a", "a", "a"})
+}
+
+func (s *ScheduleSuite) TestConcurrentUpdates(c *C) {
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, err := lb.NextServer()
+				c.Check(err, IsNil)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		u := testutils.ParseURI(fmt.Sprintf("http://b%d", i))
+		lb.UpsertServer(u, Weight(i%3+1))
+		lb.RemoveServer(u)
+	}
+	wg.Wait()
+}
+
+func BenchmarkNextServer(b *testing.B) {
+	lb, err := New(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		lb.UpsertServer(&url.URL{Scheme: "http", Host: fmt.Sprintf("s%d", i)}, Weight(i%3+1))
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lb.NextServer()
+		}
+	})
+}
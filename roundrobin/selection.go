@@ -0,0 +1,123 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Reasons of the built-in selection strategies reported in Decision
+const (
+	ReasonRoundRobin     = "weighted round robin"
+	ReasonWeightedRandom = "weighted random"
+)
+
+// Candidate is the server the load balancer can choose from
+type Candidate struct {
+	URL    *url.URL
+	Weight int
+	// Ejected is set for the servers ejected with EjectServer
+	Ejected bool
+	// Priority is the failover tier of the server
+	Priority int
+	// Zone and ZoneHints are the locality of the server, see Zone
+	Zone      string
+	ZoneHints []string
+}
+
+// Selector chooses the server for the request from the candidates, returning the index of the chosen one
+// and the human readable reason of the choice. The request is nil when the server is picked with NextServer.
+// It is called under the load balancer's lock and should not call back into the load balancer.
+type Selector interface {
+	Select(req *http.Request, candidates []Candidate) (int, string, error)
+}
+
+// SelectorFunc is an adapter to use ordinary functions as selectors
+type SelectorFunc func(req *http.Request, candidates []Candidate) (int, string, error)
+
+// Select calls f(req, candidates)
+func (f SelectorFunc) Select(req *http.Request, candidates []Candidate) (int, string, error) {
+	return f(req, candidates)
+}
+
+// Decision describes the choice of the server made by the load balancer
+type Decision struct {
+	// Request is nil when the server is picked with NextServer
+	Request    *http.Request
+	Candidates []Candidate
+	// Chosen is nil when the selection has failed with Err
+	Chosen *url.URL
+	Reason string
+	Err    error
+}
+
+// Selection replaces the built-in selection strategy with the selector, e.g. to make the choice
+// deterministic in tests
+func Selection(s Selector) LBOption {
+	return func(r *RoundRobin) error {
+		if s == nil {
+			return fmt.Errorf("selector can not be nil")
+		}
+		r.selector = s
+		return nil
+	}
+}
+
+// OnSelect sets the hook called with every selection decision, so tests and debug tooling can
+// assert why the server was chosen. The hook is called outside of the load balancer's lock.
+func OnSelect(fn func(Decision)) LBOption {
+	return func(r *RoundRobin) error {
+		r.onSelect = fn
+		return nil
+	}
+}
+
+// selectServer picks the server for the request and reports the decision to the hook
+func (r *RoundRobin) selectServer(req *http.Request) (*server, error) {
+	if srv, ok := r.scheduledServer(); ok {
+		return srv, nil
+	}
+	r.mutex.Lock()
+	r.refreshSchedule()
+	if srv, ok := r.scheduledServer(); ok {
+		r.mutex.Unlock()
+		return srv, nil
+	}
+	srv, reason, err := r.nextServer(req)
+	var candidates []Candidate
+	if r.onSelect != nil {
+		candidates = r.candidates()
+	}
+	r.mutex.Unlock()
+
+	if r.onSelect != nil {
+		d := Decision{Request: req, Candidates: candidates, Reason: reason, Err: err}
+		if srv != nil {
+			d.Chosen = srv.url
+		}
+		r.onSelect(d)
+	}
+	return srv, err
+}
+
+// selectedServer asks the selector for the server, called with the lock held
+func (r *RoundRobin) selectedServer(req *http.Request) (*server, string, error) {
+	i, reason, err := r.selector.Select(req, r.candidates())
+	if err != nil {
+		return nil, reason, err
+	}
+	if i < 0 || i >= len(r.servers) {
+		return nil, reason, fmt.Errorf("selector has chosen server %d out of %d", i, len(r.servers))
+	}
+	return r.servers[i], reason, nil
+}
+
+// candidates returns the snapshot of the servers, called with the lock held
+func (r *RoundRobin) candidates() []Candidate {
+	now := r.stats.now()
+	out := make([]Candidate, len(r.servers))
+	for i, s := range r.servers {
+		out[i] = Candidate{URL: s.url, Weight: s.weight, Ejected: s.ejected(now), Priority: s.priority, Zone: s.zone, ZoneHints: s.zoneHints}
+	}
+	return out
+}
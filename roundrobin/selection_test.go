@@ -0,0 +1,102 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type SelectionSuite struct{}
+
+var _ = Suite(&SelectionSuite{})
+
+func (s *SelectionSuite) TestSelector(c *C) {
+	// the server is chosen by the header
+	selector := SelectorFunc(func(req *http.Request, candidates []Candidate) (int, string, error) {
+		if req == nil {
+			return 0, "first", nil
+		}
+		for i, cand := range candidates {
+			if cand.URL.Host == req.Header.Get("Server") {
+				return i, "header", nil
+			}
+		}
+		return 0, "header", fmt.Errorf("unknown server")
+	})
+
+	var decisions []Decision
+	var served string
+	lb, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served = req.URL.Host
+	}), Selection(selector), OnSelect(func(d Decision) { decisions = append(decisions, d) }))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"), Weight(2))
+	lb.UpsertServer(testutils.ParseURI("http://b"))
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Server", "b")
+	lb.ServeHTTP(httptest.NewRecorder(), req)
+	c.Assert(served, Equals, "b")
+
+	c.Assert(decisions, HasLen, 1)
+	d := decisions[0]
+	c.Assert(d.Request, Equals, req)
+	c.Assert(d.Chosen.Host, Equals, "b")
+	c.Assert(d.Reason, Equals, "header")
+	c.Assert(d.Err, IsNil)
+	c.Assert(d.Candidates, HasLen, 2)
+	c.Assert(d.Candidates[0].URL.Host, Equals, "a")
+	c.Assert(d.Candidates[0].Weight, Equals, 2)
+
+	// the failed selection is recorded and served with the error
+	req.Header.Set("Server", "c")
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(decisions, HasLen, 2)
+	c.Assert(decisions[1].Chosen, IsNil)
+	c.Assert(decisions[1].Err, ErrorMatches, "unknown server")
+
+	u, err := lb.NextServer()
+	c.Assert(err, IsNil)
+	c.Assert(u.Host, Equals, "a")
+	c.Assert(decisions[2].Request, IsNil)
+	c.Assert(decisions[2].Reason, Equals, "first")
+}
+
+func (s *SelectionSuite) TestSelectorOutOfRange(c *C) {
+	lb, err := New(nil, Selection(SelectorFunc(func(req *http.Request, candidates []Candidate) (int, string, error) {
+		return len(candidates), "broken", nil
+	})))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"))
+
+	_, err = lb.NextServer()
+	c.Assert(err, NotNil)
+}
+
+func (s *SelectionSuite) TestBuiltinReasons(c *C) {
+	var reasons []string
+	hook := OnSelect(func(d Decision) { reasons = append(reasons, d.Reason) })
+
+	lb, err := New(nil, hook)
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"))
+	_, err = lb.NextServer()
+	c.Assert(err, IsNil)
+
+	lb, err = New(nil, hook, WeightedRandom(nil))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"))
+	_, err = lb.NextServer()
+	c.Assert(err, IsNil)
+
+	c.Assert(reasons, DeepEquals, []string{ReasonRoundRobin, ReasonWeightedRandom})
+
+	_, err = New(nil, Selection(nil))
+	c.Assert(err, NotNil)
+}
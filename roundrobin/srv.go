@@ -0,0 +1,160 @@
+package roundrobin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// LookupSRVFunc resolves the SRV records, see net.Resolver.LookupSRV
+type LookupSRVFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// SRVDiscovery keeps the servers of the set in sync with the DNS SRV records of the service. The SRV priorities
+// become the failover tiers of the servers and the SRV weights their weights, see Priority and Weight.
+//
+//	d, _ := roundrobin.NewSRVDiscovery(lb, "http", "tcp", "api.service.local")
+//	go d.Run(ctx)
+type SRVDiscovery struct {
+	set      ServerSet
+	service  string
+	proto    string
+	name     string
+	scheme   string
+	lookup   LookupSRVFunc
+	interval time.Duration
+	clock    timetools.TimeProvider
+	log      utils.Logger
+}
+
+// SRVOption is a functional option setter for SRVDiscovery
+type SRVOption func(d *SRVDiscovery) error
+
+// SRVScheme sets the scheme of the server URLs, http by default
+func SRVScheme(scheme string) SRVOption {
+	return func(d *SRVDiscovery) error {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("unsupported scheme: '%v'", scheme)
+		}
+		d.scheme = scheme
+		return nil
+	}
+}
+
+// SRVLookup sets the function resolving the SRV records, net.DefaultResolver by default
+func SRVLookup(fn LookupSRVFunc) SRVOption {
+	return func(d *SRVDiscovery) error {
+		d.lookup = fn
+		return nil
+	}
+}
+
+// SRVInterval sets the interval of the record refreshes done by Run, 30 seconds by default
+func SRVInterval(interval time.Duration) SRVOption {
+	return func(d *SRVDiscovery) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval should be > 0, got %v", interval)
+		}
+		d.interval = interval
+		return nil
+	}
+}
+
+// SRVClock sets the clock used by Run to wait between the refreshes, intended for tests
+func SRVClock(clock timetools.TimeProvider) SRVOption {
+	return func(d *SRVDiscovery) error {
+		d.clock = clock
+		return nil
+	}
+}
+
+// SRVLogger sets the logger reporting the failed refreshes
+func SRVLogger(l utils.Logger) SRVOption {
+	return func(d *SRVDiscovery) error {
+		d.log = l
+		return nil
+	}
+}
+
+// NewSRVDiscovery returns the discovery of the servers of the set from the SRV records of _service._proto.name
+func NewSRVDiscovery(set ServerSet, service, proto, name string, opts ...SRVOption) (*SRVDiscovery, error) {
+	if name == "" {
+		return nil, fmt.Errorf("SRV name can not be empty")
+	}
+	d := &SRVDiscovery{
+		set:      set,
+		service:  service,
+		proto:    proto,
+		name:     name,
+		scheme:   "http",
+		lookup:   net.DefaultResolver.LookupSRV,
+		interval: 30 * time.Second,
+	}
+	for _, o := range opts {
+		if err := o(d); err != nil {
+			return nil, err
+		}
+	}
+	if d.clock == nil {
+		d.clock = &timetools.RealTime{}
+	}
+	if d.log == nil {
+		d.log = utils.NullLogger
+	}
+	return d, nil
+}
+
+// Endpoints resolves the SRV records into the endpoints
+func (d *SRVDiscovery) Endpoints(ctx context.Context) ([]Endpoint, error) {
+	_, records, err := d.lookup(ctx, d.service, d.proto, d.name)
+	if err != nil {
+		return nil, err
+	}
+	var out []Endpoint
+	for _, r := range records {
+		// "." stands for the service decidedly not available at the domain, RFC 2782
+		target := strings.TrimSuffix(r.Target, ".")
+		if target == "" {
+			continue
+		}
+		out = append(out, Endpoint{
+			URL:      &url.URL{Scheme: d.scheme, Host: net.JoinHostPort(target, strconv.Itoa(int(r.Port)))},
+			Weight:   int(r.Weight),
+			Priority: int(r.Priority),
+		})
+	}
+	return out, nil
+}
+
+// Refresh resolves the records and syncs the servers. The servers are kept as they are if the lookup
+// fails or returns no records, so a DNS outage does not empty the load balancer.
+func (d *SRVDiscovery) Refresh(ctx context.Context) error {
+	endpoints, err := d.Endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no SRV records for %v", d.name)
+	}
+	return SyncServers(d.set, endpoints)
+}
+
+// Run refreshes the servers every interval until the context is done
+func (d *SRVDiscovery) Run(ctx context.Context) {
+	for {
+		if err := d.Refresh(ctx); err != nil {
+			d.log.Warningf("failed to refresh SRV records of %v: %v", d.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.clock.After(d.interval):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package roundrobin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type SRVSuite struct{}
+
+var _ = Suite(&SRVSuite{})
+
+func (s *SRVSuite) TestPriorityTiers(c *C) {
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	a, b, backup := testutils.ParseURI("http://a"), testutils.ParseURI("http://b"), testutils.ParseURI("http://backup")
+	lb.UpsertServer(a, Weight(3))
+	lb.UpsertServer(b)
+	lb.UpsertServer(backup, Priority(1))
+
+	c.Assert(picks(c, lb, 8), DeepEquals, map[string]int{"a": 6, "b": 2})
+
+	// the next tier takes over once the preferred one is ejected
+	lb.EjectServer(a, time.Minute)
+	c.Assert(picks(c, lb, 4), DeepEquals, map[string]int{"b": 4})
+	lb.EjectServer(b, time.Minute)
+	c.Assert(picks(c, lb, 4), DeepEquals, map[string]int{"backup": 4})
+
+	c.Assert(lb.UpsertServer(a, Priority(-1)), NotNil)
+}
+
+func (s *SRVSuite) TestSyncServers(c *C) {
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://gone"))
+	lb.UpsertServer(testutils.ParseURI("http://a"), Weight(5))
+
+	err = SyncServers(lb, []Endpoint{
+		{URL: testutils.ParseURI("http://a")},
+		{URL: testutils.ParseURI("http://b"), Weight: 2, Priority: 1},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(lb.Servers(), DeepEquals, []*url.URL{testutils.ParseURI("http://a"), testutils.ParseURI("http://b")})
+	w, _ := lb.ServerWeight(testutils.ParseURI("http://a"))
+	c.Assert(w, Equals, 1)
+	w, _ = lb.ServerWeight(testutils.ParseURI("http://b"))
+	c.Assert(w, Equals, 2)
+}
+
+func (s *SRVSuite) TestDiscovery(c *C) {
+	records := []*net.SRV{
+		{Target: "a.local.", Port: 8080, Priority: 10, Weight: 60},
+		{Target: "b.local.", Port: 8080, Priority: 10, Weight: 20},
+		{Target: "backup.local.", Port: 9090, Priority: 20, Weight: 0},
+	}
+	var lookupErr error
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		c.Assert(service, Equals, "http")
+		c.Assert(proto, Equals, "tcp")
+		c.Assert(name, Equals, "api.local")
+		return "_http._tcp.api.local.", records, lookupErr
+	}
+
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	d, err := NewSRVDiscovery(lb, "http", "tcp", "api.local", SRVLookup(lookup), SRVScheme("https"))
+	c.Assert(err, IsNil)
+
+	c.Assert(d.Refresh(context.Background()), IsNil)
+	c.Assert(len(lb.Servers()), Equals, 3)
+	got := picks(c, lb, 80)
+	c.Assert(got, DeepEquals, map[string]int{"a.local:8080": 60, "b.local:8080": 20})
+	w, _ := lb.ServerWeight(testutils.ParseURI("https://backup.local:9090"))
+	c.Assert(w, Equals, 1)
+
+	// lookup failures and empty answers keep the servers
+	lookupErr = fmt.Errorf("timeout")
+	c.Assert(d.Refresh(context.Background()), NotNil)
+	lookupErr, records = nil, []*net.SRV{{Target: ".", Port: 80}}
+	c.Assert(d.Refresh(context.Background()), NotNil)
+	c.Assert(len(lb.Servers()), Equals, 3)
+
+	records = []*net.SRV{{Target: "a.local.", Port: 8080, Weight: 1}}
+	c.Assert(d.Refresh(context.Background()), IsNil)
+	c.Assert(lb.Servers(), DeepEquals, []*url.URL{testutils.ParseURI("https://a.local:8080")})
+}
+
+func (s *SRVSuite) TestRun(c *C) {
+	clock := testutils.NewClock()
+	refreshed := make(chan struct{}, 10)
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		refreshed <- struct{}{}
+		return "", []*net.SRV{{Target: "a.local.", Port: 80}}, nil
+	}
+	lb, err := New(nil)
+	c.Assert(err, IsNil)
+	d, err := NewSRVDiscovery(lb, "http", "tcp", "api.local", SRVLookup(lookup), SRVInterval(time.Minute), SRVClock(clock))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	<-refreshed
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-refreshed
+	clock.BlockUntil(1)
+	cancel()
+	<-done
+}
+
+func (s *SRVSuite) TestBadOptions(c *C) {
+	_, err := NewSRVDiscovery(nil, "http", "tcp", "")
+	c.Assert(err, NotNil)
+	_, err = NewSRVDiscovery(nil, "http", "tcp", "a", SRVScheme("ftp"))
+	c.Assert(err, NotNil)
+	_, err = NewSRVDiscovery(nil, "http", "tcp", "a", SRVInterval(0))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,159 @@
+package roundrobin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/oxy/memmetrics"
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// ServerStats are the statistics of the server collected by the load balancer. Requests, errors
+// and latencies cover the rolling window of memmetrics.RTMetrics, 10 seconds by default
+type ServerStats struct {
+	URL *url.URL
+	// Requests is the number of the completed requests
+	Requests int64
+	// Errors is the number of the 5xx responses, including network errors reported as 502 and 504
+	Errors int64
+	// Active is the number of the requests in flight
+	Active int64
+	// Latency maps the quantiles in percents (50, 90, 99, 99.9) to the latencies
+	Latency map[float64]time.Duration
+	// Ejected is set while the server is ejected with EjectServer, e.g. by its tripped breaker,
+	// until it is routed to again in ReadmitIn
+	Ejected   bool
+	ReadmitIn time.Duration
+	// Circuit is the state of the breaker of the server reported by CircuitStates, empty if unknown
+	Circuit string
+}
+
+// CircuitStates reports the states of the breakers of the servers, it is implemented by cbreaker.PerServer
+type CircuitStates interface {
+	CircuitState(u *url.URL) (string, bool)
+}
+
+// StatsQuantiles are the latency quantiles reported in ServerStats
+var StatsQuantiles = []float64{50, 90, 99, 99.9}
+
+type serverStats struct {
+	active  int64
+	metrics *memmetrics.RTMetrics
+}
+
+// statsSet collects the stats of the servers, it is shared by RoundRobin and Rebalancer
+type statsSet struct {
+	// mtx guards the servers, the metrics are safe for the concurrent use
+	mtx     sync.Mutex
+	clock   timetools.TimeProvider
+	servers map[string]*serverStats
+}
+
+func statsKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+func (s *statsSet) now() time.Time {
+	if s.clock == nil {
+		return time.Now().UTC()
+	}
+	return s.clock.UtcNow()
+}
+
+func (s *statsSet) get(u *url.URL, create bool) (*serverStats, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := statsKey(u)
+	if st, ok := s.servers[key]; ok || !create {
+		return st, nil
+	}
+	m, err := s.newMetrics()
+	if err != nil {
+		return nil, err
+	}
+	if s.servers == nil {
+		s.servers = make(map[string]*serverStats)
+	}
+	st := &serverStats{metrics: m}
+	s.servers[key] = st
+	return st, nil
+}
+
+func (s *statsSet) newMetrics() (*memmetrics.RTMetrics, error) {
+	if s.clock == nil {
+		return memmetrics.NewRTMetrics()
+	}
+	return memmetrics.NewRTMetrics(memmetrics.RTClock(s.clock))
+}
+
+// serve passes the request to the next handler recording the stats of the server
+func (s *statsSet) serve(u *url.URL, next http.Handler, w http.ResponseWriter, req *http.Request) {
+	st, err := s.get(u, true)
+	if err != nil {
+		next.ServeHTTP(w, req)
+		return
+	}
+	atomic.AddInt64(&st.active, 1)
+	defer atomic.AddInt64(&st.active, -1)
+
+	pw := &utils.ProxyWriter{W: w}
+	start := s.now()
+	next.ServeHTTP(pw, req)
+
+	// the metrics are safe for the concurrent use, the servers don't wait for each other
+	st.metrics.Record(pw.StatusCode(), s.now().Sub(start))
+	st.metrics.RecordSizes(req.ContentLength, pw.Length())
+}
+
+func (s *statsSet) remove(u *url.URL) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.servers, statsKey(u))
+}
+
+func (s *statsSet) stats(u *url.URL) (ServerStats, error) {
+	out := ServerStats{URL: utils.CopyURL(u), Latency: make(map[float64]time.Duration)}
+	st, err := s.get(u, false)
+	if err != nil || st == nil {
+		return out, err
+	}
+	out.Active = atomic.LoadInt64(&st.active)
+	out.Requests = st.metrics.TotalCount()
+	for code, count := range st.metrics.StatusCodesCounts() {
+		if code >= http.StatusInternalServerError {
+			out.Errors += count
+		}
+	}
+	h, err := st.metrics.LatencyHistogram()
+	if err != nil {
+		return out, fmt.Errorf("failed to get latency histogram of %v: %v", u, err)
+	}
+	for _, q := range StatsQuantiles {
+		out.Latency[q] = h.LatencyAtQuantile(q)
+	}
+	return out, nil
+}
+
+// snapshots returns the metrics snapshots of the servers keyed by their URLs
+func (s *statsSet) snapshots() map[string]memmetrics.Snapshot {
+	s.mtx.Lock()
+	servers := make(map[string]*serverStats, len(s.servers))
+	for key, st := range s.servers {
+		servers[key] = st
+	}
+	s.mtx.Unlock()
+
+	out := make(map[string]memmetrics.Snapshot, len(servers))
+	for key, st := range servers {
+		// the failed histogram merge leaves the latencies empty, the counters are still reported
+		snapshot, _ := st.metrics.Snapshot()
+		out[key] = snapshot
+	}
+	return out
+}
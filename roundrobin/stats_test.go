@@ -0,0 +1,203 @@
+package roundrobin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type StatsSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&StatsSuite{})
+
+func (s *StatsSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+// backend responds with 500 for the server b and takes the time according to the server
+func (s *StatsSuite) backend() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Host == "b" {
+			s.clock.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.clock.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+}
+
+// assertLatency allows for the precision of the histogram
+func assertLatency(c *C, got, expected time.Duration) {
+	c.Assert(got >= expected && got < expected+expected/100, Equals, true, Commentf("got %v, expected %v", got, expected))
+}
+
+func serve(h http.Handler, n int) {
+	for i := 0; i < n; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+	}
+}
+
+func (s *StatsSuite) TestServerStats(c *C) {
+	lb, err := New(s.backend(), Clock(s.clock))
+	c.Assert(err, IsNil)
+	a, b := testutils.ParseURI("http://a"), testutils.ParseURI("http://b")
+	lb.UpsertServer(a)
+	lb.UpsertServer(b)
+
+	serve(lb, 4)
+
+	st, err := lb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.URL.String(), Equals, "http://a")
+	c.Assert(st.Requests, Equals, int64(2))
+	c.Assert(st.Errors, Equals, int64(0))
+	c.Assert(st.Active, Equals, int64(0))
+	assertLatency(c, st.Latency[50], 10*time.Millisecond)
+
+	st, err = lb.ServerStats(b)
+	c.Assert(err, IsNil)
+	c.Assert(st.Requests, Equals, int64(2))
+	c.Assert(st.Errors, Equals, int64(2))
+	assertLatency(c, st.Latency[99], 100*time.Millisecond)
+
+	// stats are dropped with the server
+	c.Assert(lb.RemoveServer(b), IsNil)
+	_, err = lb.ServerStats(b)
+	c.Assert(err, NotNil)
+	lb.UpsertServer(b)
+	st, err = lb.ServerStats(b)
+	c.Assert(err, IsNil)
+	c.Assert(st.Requests, Equals, int64(0))
+}
+
+func (s *StatsSuite) TestActive(c *C) {
+	started, release := make(chan struct{}), make(chan struct{})
+	lb, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}))
+	c.Assert(err, IsNil)
+	a := testutils.ParseURI("http://a")
+	lb.UpsertServer(a)
+
+	done := make(chan struct{})
+	go func() {
+		serve(lb, 1)
+		close(done)
+	}()
+	<-started
+
+	st, err := lb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.Active, Equals, int64(1))
+
+	close(release)
+	<-done
+	st, err = lb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.Active, Equals, int64(0))
+	c.Assert(st.Requests, Equals, int64(1))
+}
+
+func (s *StatsSuite) TestRebalancerStats(c *C) {
+	lb, err := New(s.backend())
+	c.Assert(err, IsNil)
+	rb, err := NewRebalancer(lb, RebalancerClock(s.clock))
+	c.Assert(err, IsNil)
+	a := testutils.ParseURI("http://a")
+	rb.UpsertServer(a)
+
+	serve(rb, 3)
+
+	st, err := rb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.Requests, Equals, int64(3))
+	assertLatency(c, st.Latency[50], 10*time.Millisecond)
+
+	_, err = rb.ServerStats(testutils.ParseURI("http://b"))
+	c.Assert(err, NotNil)
+}
+
+func (s *StatsSuite) TestSnapshots(c *C) {
+	lb, err := New(s.backend(), Clock(s.clock))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"))
+	lb.UpsertServer(testutils.ParseURI("http://b"))
+
+	serve(lb, 4)
+
+	snapshots := lb.Snapshots()
+	c.Assert(len(snapshots), Equals, 2)
+	c.Assert(snapshots["http://a"].Requests, Equals, int64(2))
+	c.Assert(snapshots["http://a"].ErrorRatio, Equals, 0.0)
+	c.Assert(snapshots["http://b"].ErrorRatio, Equals, 1.0)
+	c.Assert(int(snapshots["http://b"].LatencyMs["99"]), Equals, 100)
+}
+
+func (s *StatsSuite) TestSnapshotSizes(c *C) {
+	lb, err := New(s.backend(), Clock(s.clock))
+	c.Assert(err, IsNil)
+	lb.UpsertServer(testutils.ParseURI("http://a"))
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://localhost", strings.NewReader("hello")))
+
+	snapshot := lb.Snapshots()["http://a"]
+	c.Assert(snapshot.RequestBytes["50"], Equals, int64(5))
+	c.Assert(snapshot.ResponseBytes["50"], Equals, int64(2))
+}
+
+type circuits map[string]string
+
+func (c circuits) CircuitState(u *url.URL) (string, bool) {
+	state, ok := c[u.String()]
+	return state, ok
+}
+
+func (s *StatsSuite) TestCircuit(c *C) {
+	lb, err := New(s.backend(), Clock(s.clock))
+	c.Assert(err, IsNil)
+	a, b := testutils.ParseURI("http://a"), testutils.ParseURI("http://b")
+	lb.UpsertServer(a)
+	lb.UpsertServer(b)
+	c.Assert(lb.EjectServer(a, 5*time.Second), IsNil)
+
+	st, err := lb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.Ejected, Equals, true)
+	c.Assert(st.ReadmitIn, Equals, 5*time.Second)
+	c.Assert(st.Circuit, Equals, "")
+
+	lb.SetCircuitStates(circuits{"http://a": "tripped"})
+	s.clock.Sleep(2 * time.Second)
+	st, err = lb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.ReadmitIn, Equals, 3*time.Second)
+	c.Assert(st.Circuit, Equals, "tripped")
+
+	st, err = lb.ServerStats(b)
+	c.Assert(err, IsNil)
+	c.Assert(st.Ejected, Equals, false)
+	c.Assert(st.Circuit, Equals, "")
+
+	// the rebalancer reports the state of its load balancer
+	rb, err := NewRebalancer(lb, RebalancerClock(s.clock))
+	c.Assert(err, IsNil)
+	rb.UpsertServer(a)
+	c.Assert(lb.EjectServer(a, 5*time.Second), IsNil)
+	st, err = rb.ServerStats(a)
+	c.Assert(err, IsNil)
+	c.Assert(st.Ejected, Equals, true)
+	c.Assert(st.Circuit, Equals, "tripped")
+}
@@ -0,0 +1,86 @@
+package roundrobin
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/tenancy"
+)
+
+// ReasonTenantServers is the reason of the choices of the TenantServers selector
+const ReasonTenantServers = "tenant servers"
+
+// TenantServers returns the selector routing the requests of the tenants to the servers of their configs only,
+// picking the servers of the lowest priority randomly in proportion to their weights. The tenants without
+// the servers, and NextServer, get all of the servers.
+//
+//	lb, _ := roundrobin.New(fwd, roundrobin.Selection(roundrobin.TenantServers(tenants)))
+func TenantServers(t *tenancy.Tenancy) Selector {
+	s := &tenantSelector{tenants: t, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	return SelectorFunc(s.selectServer)
+}
+
+type tenantSelector struct {
+	tenants *tenancy.Tenancy
+	// mtx guards rnd, the selector can be shared by the load balancers
+	mtx sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *tenantSelector) selectServer(req *http.Request, candidates []Candidate) (int, string, error) {
+	var allowed []string
+	var tenant string
+	if req != nil {
+		var c tenancy.Config
+		var err error
+		if tenant, c, err = s.tenants.RequestConfig(req); err != nil {
+			return -1, ReasonTenantServers, err
+		}
+		allowed = c.Servers
+	}
+	eligible := func(c Candidate) bool {
+		if c.Ejected || c.Weight == 0 {
+			return false
+		}
+		if len(allowed) == 0 {
+			return true
+		}
+		for _, host := range allowed {
+			if c.URL.Host == host {
+				return true
+			}
+		}
+		return false
+	}
+	priority, total := -1, 0
+	for _, c := range candidates {
+		if !eligible(c) {
+			continue
+		}
+		if priority == -1 || c.Priority < priority {
+			priority, total = c.Priority, 0
+		}
+		if c.Priority == priority {
+			total += c.Weight
+		}
+	}
+	if total == 0 {
+		return -1, ReasonTenantServers, fmt.Errorf("no servers of tenant '%v' available", tenant)
+	}
+	s.mtx.Lock()
+	n := s.rnd.Intn(total)
+	s.mtx.Unlock()
+	for i, c := range candidates {
+		if !eligible(c) || c.Priority != priority {
+			continue
+		}
+		if n < c.Weight {
+			return i, ReasonTenantServers, nil
+		}
+		n -= c.Weight
+	}
+	return -1, ReasonTenantServers, fmt.Errorf("no servers of tenant '%v' available", tenant)
+}
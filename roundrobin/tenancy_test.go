@@ -0,0 +1,65 @@
+package roundrobin
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mailgun/oxy/tenancy"
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+type TenancySuite struct{}
+
+var _ = Suite(&TenancySuite{})
+
+func (s *TenancySuite) TestTenantServers(c *C) {
+	extract, err := utils.NewExtractor("request.header.X-Tenant-Id")
+	c.Assert(err, IsNil)
+	store := tenancy.NewMemoryStore()
+	store.Set("acme", tenancy.Config{Servers: []string{"a", "b"}})
+	store.Set("globex", tenancy.Config{Servers: []string{"d"}})
+	tenants, err := tenancy.New(extract, store)
+	c.Assert(err, IsNil)
+
+	served := map[string]int{}
+	lb, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served[req.URL.Host]++
+	}), Selection(TenantServers(tenants)))
+	c.Assert(err, IsNil)
+	for _, host := range []string{"a", "b", "c"} {
+		c.Assert(lb.UpsertServer(testutils.ParseURI("http://"+host)), IsNil)
+	}
+
+	serve := func(tenant string, n int) int {
+		code := 0
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			req.Header.Set("X-Tenant-Id", tenant)
+			w := httptest.NewRecorder()
+			lb.ServeHTTP(w, req)
+			code = w.Code
+		}
+		return code
+	}
+
+	serve("acme", 100)
+	c.Assert(served["a"]+served["b"], Equals, 100)
+	c.Assert(served["a"] > 0 && served["b"] > 0, Equals, true)
+
+	// the tenants without the servers get all of them
+	served = map[string]int{}
+	serve("initech", 100)
+	c.Assert(len(served), Equals, 3)
+
+	// the servers of the tenant are gone
+	served = map[string]int{}
+	c.Assert(serve("globex", 1), Equals, http.StatusInternalServerError)
+	c.Assert(served, DeepEquals, map[string]int{})
+
+	u, err := lb.NextServer()
+	c.Assert(err, IsNil)
+	c.Assert(u, NotNil)
+}
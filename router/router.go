@@ -0,0 +1,206 @@
+// Package router dispatches requests to different handler chains by host, path prefix, headers and method,
+// so a single listener can serve multiple services each with its own middlewares and load balancer.
+//
+//	r, _ := router.New()
+//	r.Handle("api", apiChain, router.Host("api.example.com"), router.PathPrefix("/v1"))
+//	r.Handle("static", staticLB, router.Host("*.example.com"), router.Method("GET", "HEAD"))
+//
+// Routes are matched in the order they were added and can be updated at runtime.
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Matcher tells whether the request matches the route
+type Matcher func(req *http.Request) bool
+
+// Host matches the request host ignoring the port, pattern can start with *. to match any subdomain
+func Host(pattern string) Matcher {
+	pattern = strings.ToLower(pattern)
+	return func(req *http.Request) bool {
+		host := strings.ToLower(req.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.HasPrefix(pattern, "*.") {
+			return strings.HasSuffix(host, pattern[1:]) && len(host) > len(pattern)-1
+		}
+		return host == pattern
+	}
+}
+
+// PathPrefix matches the requests with the path starting with the prefix
+func PathPrefix(prefix string) Matcher {
+	return func(req *http.Request) bool {
+		return strings.HasPrefix(req.URL.Path, prefix)
+	}
+}
+
+// Header matches the requests with the header value, empty value matches any request with the header
+func Header(name, value string) Matcher {
+	name = http.CanonicalHeaderKey(name)
+	return func(req *http.Request) bool {
+		vals, ok := req.Header[name]
+		if !ok {
+			return false
+		}
+		if value == "" {
+			return true
+		}
+		for _, v := range vals {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Method matches the requests with any of the methods
+func Method(methods ...string) Matcher {
+	return func(req *http.Request) bool {
+		for _, m := range methods {
+			if strings.EqualFold(m, req.Method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type route struct {
+	id       string
+	handler  http.Handler
+	matchers []Matcher
+}
+
+func (r *route) match(req *http.Request) bool {
+	for _, m := range r.matchers {
+		if !m(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// Router dispatches the requests to the handler of the first matching route
+type Router struct {
+	mtx      sync.RWMutex
+	routes   []*route
+	notFound http.Handler
+	log      utils.Logger
+}
+
+// RouterOption is a functional option setter for Router
+type RouterOption func(r *Router) error
+
+// NotFound sets the handler for the requests not matching any route
+func NotFound(h http.Handler) RouterOption {
+	return func(r *Router) error {
+		r.notFound = h
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this router.
+func Logger(l utils.Logger) RouterOption {
+	return func(r *Router) error {
+		r.log = l
+		return nil
+	}
+}
+
+// New returns a new router without routes
+func New(options ...RouterOption) (*Router, error) {
+	r := &Router{}
+	for _, o := range options {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.notFound == nil {
+		r.notFound = http.NotFoundHandler()
+	}
+	if r.log == nil {
+		r.log = utils.NullLogger
+	}
+	return r, nil
+}
+
+// Handle adds the route or replaces the route with the same id keeping its position.
+// Route without matchers matches all requests.
+func (r *Router) Handle(id string, h http.Handler, matchers ...Matcher) error {
+	if id == "" {
+		return fmt.Errorf("route id can not be empty")
+	}
+	if h == nil {
+		return fmt.Errorf("handler of route %v can not be nil", id)
+	}
+	rt := &route{id: id, handler: h, matchers: matchers}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for i, existing := range r.routes {
+		if existing.id == id {
+			r.routes[i] = rt
+			return nil
+		}
+	}
+	r.routes = append(r.routes, rt)
+	return nil
+}
+
+// Remove removes the route
+func (r *Router) Remove(id string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for i, existing := range r.routes {
+		if existing.id == id {
+			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("route %v not found", id)
+}
+
+// Routes returns the ids of the routes in the matching order
+func (r *Router) Routes() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	out := make([]string, len(r.routes))
+	for i, rt := range r.routes {
+		out[i] = rt.id
+	}
+	return out
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h := r.match(req); h != nil {
+		h.ServeHTTP(w, req)
+		return
+	}
+	r.log.Infof("no route for %v %v%v", req.Method, req.Host, req.URL.Path)
+	r.notFound.ServeHTTP(w, req)
+}
+
+func (r *Router) match(req *http.Request) http.Handler {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, rt := range r.routes {
+		if rt.match(req) {
+			return rt.handler
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestRouter(t *testing.T) { TestingT(t) }
+
+type RouterSuite struct{}
+
+var _ = Suite(&RouterSuite{})
+
+func reply(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	})
+}
+
+func (s *RouterSuite) TestMatch(c *C) {
+	r, err := New()
+	c.Assert(err, IsNil)
+	c.Assert(r.Handle("api", reply("api"), Host("api.example.com"), PathPrefix("/v1")), IsNil)
+	c.Assert(r.Handle("canary", reply("canary"), Header("X-Canary", "")), IsNil)
+	c.Assert(r.Handle("static", reply("static"), Host("*.example.com"), Method("GET", "HEAD")), IsNil)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	testCases := []struct {
+		options  []testutils.ReqOption
+		path     string
+		code     int
+		expected string
+	}{
+		{options: []testutils.ReqOption{testutils.Host("api.example.com")}, path: "/v1/users", code: 200, expected: "api"},
+		{options: []testutils.ReqOption{testutils.Host("API.example.com:8080")}, path: "/v1", code: 200, expected: "api"},
+		{options: []testutils.ReqOption{testutils.Host("api.example.com")}, path: "/v2", code: 200, expected: "static"},
+		{options: []testutils.ReqOption{testutils.Host("cdn.example.com"), testutils.Header("X-Canary", "1")}, path: "/", code: 200, expected: "canary"},
+		{options: []testutils.ReqOption{testutils.Host("cdn.example.com"), testutils.Method("POST")}, path: "/", code: 404},
+		{options: []testutils.ReqOption{testutils.Host("example.com")}, path: "/", code: 404},
+	}
+	for _, tc := range testCases {
+		re, body, err := testutils.MakeRequest(srv.URL+tc.path, tc.options...)
+		c.Assert(err, IsNil)
+		c.Assert(re.StatusCode, Equals, tc.code, Commentf("%v", tc.path))
+		if tc.code == 200 {
+			c.Assert(string(body), Equals, tc.expected)
+		}
+	}
+}
+
+func (s *RouterSuite) TestUpdate(c *C) {
+	r, err := New(NotFound(reply("nothing here")))
+	c.Assert(err, IsNil)
+	c.Assert(r.Handle("a", reply("a"), PathPrefix("/a")), IsNil)
+	c.Assert(r.Handle("all", reply("all")), IsNil)
+	c.Assert(r.Routes(), DeepEquals, []string{"a", "all"})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	_, body, err := testutils.Get(srv.URL + "/a")
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "a")
+
+	// replacing the route keeps its position
+	c.Assert(r.Handle("a", reply("a2"), PathPrefix("/a")), IsNil)
+	c.Assert(r.Routes(), DeepEquals, []string{"a", "all"})
+	_, body, err = testutils.Get(srv.URL + "/a")
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "a2")
+
+	c.Assert(r.Remove("all"), IsNil)
+	c.Assert(r.Remove("all"), NotNil)
+	_, body, err = testutils.Get(srv.URL + "/b")
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "nothing here")
+}
+
+func (s *RouterSuite) TestBadRoutes(c *C) {
+	r, err := New()
+	c.Assert(err, IsNil)
+	c.Assert(r.Handle("", reply("a")), NotNil)
+	c.Assert(r.Handle("a", nil), NotNil)
+}
@@ -0,0 +1,241 @@
+// package server terminates TLS on one or more listeners and passes the requests to the oxy chain:
+// the certificate is selected by SNI, HTTP/2 is negotiated with ALPN and the client certificates
+// can be requested or required. The TLS attributes of the connection are available to the
+// middlewares as usual via http.Request.TLS, see also the "tls.*" variables of utils.NewExtractor.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Server serves the handler on the TLS listeners
+type Server struct {
+	handler http.Handler
+
+	certs      []tls.Certificate
+	getCert    func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+	minVersion uint16
+	noHTTP2    bool
+
+	readHeaderTimeout time.Duration
+	idleTimeout       time.Duration
+
+	log utils.Logger
+
+	mtx     sync.Mutex
+	servers []*http.Server
+	closed  bool
+}
+
+// Option is a functional option setter for Server
+type Option func(s *Server) error
+
+// Certificates adds the certificates, the one matching the server name (SNI) sent by the client is used,
+// the first one is used if none matches
+func Certificates(certs ...tls.Certificate) Option {
+	return func(s *Server) error {
+		s.certs = append(s.certs, certs...)
+		return nil
+	}
+}
+
+// CertificateFiles loads the certificate and the key from the PEM files and adds them
+func CertificateFiles(certFile, keyFile string) Option {
+	return func(s *Server) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		s.certs = append(s.certs, cert)
+		return nil
+	}
+}
+
+// GetCertificate sets the function selecting the certificate for the handshake, e.g. to load
+// the certificates on demand, the certificates set with Certificates are used if it returns nil
+func GetCertificate(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) Option {
+	return func(s *Server) error {
+		s.getCert = fn
+		return nil
+	}
+}
+
+// ClientCerts requests the client certificates and verifies them against the pool,
+// with required set the handshake fails for the clients without the valid certificate
+func ClientCerts(pool *x509.CertPool, required bool) Option {
+	return func(s *Server) error {
+		if pool == nil {
+			return fmt.Errorf("client CA pool can not be nil")
+		}
+		s.clientCAs = pool
+		s.clientAuth = tls.VerifyClientCertIfGiven
+		if required {
+			s.clientAuth = tls.RequireAndVerifyClientCert
+		}
+		return nil
+	}
+}
+
+// MinVersion sets the minimum TLS version, TLS 1.2 by default
+func MinVersion(v uint16) Option {
+	return func(s *Server) error {
+		s.minVersion = v
+		return nil
+	}
+}
+
+// HTTP2 sets whether HTTP/2 is offered with ALPN, it is offered by default
+func HTTP2(enabled bool) Option {
+	return func(s *Server) error {
+		s.noHTTP2 = !enabled
+		return nil
+	}
+}
+
+// Timeouts sets the time allowed to read the request headers and the time the idle keep-alive connections are kept
+func Timeouts(readHeader, idle time.Duration) Option {
+	return func(s *Server) error {
+		if readHeader < 0 || idle < 0 {
+			return fmt.Errorf("timeouts can not be negative")
+		}
+		s.readHeaderTimeout, s.idleTimeout = readHeader, idle
+		return nil
+	}
+}
+
+// Logger sets the logger of the server errors
+func Logger(l utils.Logger) Option {
+	return func(s *Server) error {
+		s.log = l
+		return nil
+	}
+}
+
+// New returns the server passing the requests to the handler, at least one certificate or GetCertificate is required
+func New(handler http.Handler, options ...Option) (*Server, error) {
+	s := &Server{
+		handler:           handler,
+		minVersion:        tls.VersionTLS12,
+		readHeaderTimeout: 10 * time.Second,
+		idleTimeout:       2 * time.Minute,
+		log:               utils.NullLogger,
+	}
+	for _, o := range options {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.certs) == 0 && s.getCert == nil {
+		return nil, fmt.Errorf("provide certificates")
+	}
+	return s, nil
+}
+
+func (s *Server) tlsConfig() *tls.Config {
+	c := &tls.Config{
+		Certificates: s.certs,
+		ClientCAs:    s.clientCAs,
+		ClientAuth:   s.clientAuth,
+		MinVersion:   s.minVersion,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if s.noHTTP2 {
+		c.NextProtos = []string{"http/1.1"}
+	}
+	// tls falls back to the static certificates when GetCertificate returns nil
+	c.GetCertificate = s.getCert
+	return c
+}
+
+func (s *Server) newHTTPServer() *http.Server {
+	srv := &http.Server{
+		Handler:           s.handler,
+		TLSConfig:         s.tlsConfig(),
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		IdleTimeout:       s.idleTimeout,
+	}
+	if s.noHTTP2 {
+		// the non-nil empty map disables the automatic HTTP/2 support
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return srv
+}
+
+// Serve terminates TLS on the connections accepted by the listener, it blocks until the server is closed,
+// returning http.ErrServerClosed then. Serve can be called for any number of listeners.
+func (s *Server) Serve(l net.Listener) error {
+	srv := s.newHTTPServer()
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		l.Close()
+		return http.ErrServerClosed
+	}
+	s.servers = append(s.servers, srv)
+	s.mtx.Unlock()
+
+	s.log.Infof("serving TLS on %v", l.Addr())
+	return srv.ServeTLS(l, "", "")
+}
+
+// ListenAndServe listens on all the addresses and serves them, it blocks until the server is closed
+// or any of the listeners fails, closing the others then
+func (s *Server) ListenAndServe(addrs ...string) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("provide addresses")
+	}
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(l)
+	}
+	err := <-errs
+	if err != http.ErrServerClosed {
+		s.log.Errorf("listener failed: %v", err)
+		s.Close(context.Background())
+	}
+	for i := 1; i < len(listeners); i++ {
+		<-errs
+	}
+	return err
+}
+
+// Close stops the listeners and waits for the active connections to finish until the context is done
+func (s *Server) Close(ctx context.Context) error {
+	s.mtx.Lock()
+	s.closed = true
+	servers := s.servers
+	s.servers = nil
+	s.mtx.Unlock()
+
+	var firstErr error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestServer(t *testing.T) { TestingT(t) }
+
+type ServerSuite struct {
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	pool  *x509.CertPool
+}
+
+var _ = Suite(&ServerSuite{})
+
+func (s *ServerSuite) SetUpSuite(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	s.ca, err = x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	s.caKey = key
+	s.pool = x509.NewCertPool()
+	s.pool.AddCert(s.ca)
+}
+
+// issue returns the certificate signed by the test CA
+func (s *ServerSuite) issue(c *C, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, &key.PublicKey, s.caKey)
+	c.Assert(err, IsNil)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// start serves the server on the local listeners and returns their addresses
+func start(c *C, srv *Server, n int) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assert(err, IsNil)
+		addrs = append(addrs, l.Addr().String())
+		go srv.Serve(l)
+	}
+	return addrs
+}
+
+func (s *ServerSuite) client(serverName string, h2 bool, certs ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: s.pool, ServerName: serverName, Certificates: certs},
+		ForceAttemptHTTP2: h2,
+	}}
+}
+
+// sni answers with the server name and the protocol of the request
+var sni = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	e, _ := utils.NewExtractor("tls.server_name")
+	name, _, err := e.Extract(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(name + " " + req.Proto))
+})
+
+func get(c *C, client *http.Client, url string) (*http.Response, string, error) {
+	re, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer re.Body.Close()
+	body, err := ioutil.ReadAll(re.Body)
+	c.Assert(err, IsNil)
+	return re, string(body), nil
+}
+
+func (s *ServerSuite) TestSNIAndALPN(c *C) {
+	a, b := s.issue(c, "a.example.com", x509.ExtKeyUsageServerAuth), s.issue(c, "b.example.com", x509.ExtKeyUsageServerAuth)
+	srv, err := New(sni, Certificates(a, b))
+	c.Assert(err, IsNil)
+	defer srv.Close(context.Background())
+	addrs := start(c, srv, 2)
+
+	for _, addr := range addrs {
+		for _, name := range []string{"a.example.com", "b.example.com"} {
+			re, body, err := get(c, s.client(name, true), "https://"+addr)
+			c.Assert(err, IsNil)
+			c.Assert(re.TLS.PeerCertificates[0].Subject.CommonName, Equals, name)
+			c.Assert(body, Equals, name+" HTTP/2.0")
+		}
+	}
+}
+
+func (s *ServerSuite) TestNoHTTP2(c *C) {
+	srv, err := New(sni, Certificates(s.issue(c, "a.example.com", x509.ExtKeyUsageServerAuth)), HTTP2(false))
+	c.Assert(err, IsNil)
+	defer srv.Close(context.Background())
+	addrs := start(c, srv, 1)
+
+	_, body, err := get(c, s.client("a.example.com", true), "https://"+addrs[0])
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, "a.example.com HTTP/1.1")
+}
+
+func (s *ServerSuite) TestClientCerts(c *C) {
+	var subject string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		subject = req.TLS.PeerCertificates[0].Subject.CommonName
+	})
+	srv, err := New(handler,
+		Certificates(s.issue(c, "a.example.com", x509.ExtKeyUsageServerAuth)),
+		ClientCerts(s.pool, true))
+	c.Assert(err, IsNil)
+	defer srv.Close(context.Background())
+	addrs := start(c, srv, 1)
+
+	_, _, err = get(c, s.client("a.example.com", false), "https://"+addrs[0])
+	c.Assert(err, NotNil)
+
+	re, _, err := get(c, s.client("a.example.com", false, s.issue(c, "client", x509.ExtKeyUsageClientAuth)), "https://"+addrs[0])
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(subject, Equals, "client")
+}
+
+func (s *ServerSuite) TestGetCertificate(c *C) {
+	dynamic := s.issue(c, "dynamic.example.com", x509.ExtKeyUsageServerAuth)
+	srv, err := New(sni,
+		Certificates(s.issue(c, "a.example.com", x509.ExtKeyUsageServerAuth)),
+		GetCertificate(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "dynamic.example.com" {
+				return &dynamic, nil
+			}
+			return nil, nil
+		}))
+	c.Assert(err, IsNil)
+	defer srv.Close(context.Background())
+	addrs := start(c, srv, 1)
+
+	for _, name := range []string{"dynamic.example.com", "a.example.com"} {
+		_, body, err := get(c, s.client(name, false), "https://"+addrs[0])
+		c.Assert(err, IsNil)
+		c.Assert(body, Equals, name+" HTTP/1.1")
+	}
+}
+
+func (s *ServerSuite) TestListenAndServe(c *C) {
+	srv, err := New(sni, Certificates(s.issue(c, "a.example.com", x509.ExtKeyUsageServerAuth)))
+	c.Assert(err, IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe("127.0.0.1:0", "127.0.0.1:0")
+	}()
+	// the server is serving once it can be closed with both listeners registered
+	for {
+		srv.mtx.Lock()
+		n := len(srv.servers)
+		srv.mtx.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(srv.Close(context.Background()), IsNil)
+	c.Assert(<-done, Equals, http.ErrServerClosed)
+
+	// the failing listener
+	c.Assert(srv.ListenAndServe("256.0.0.1:0"), NotNil)
+}
+
+func (s *ServerSuite) TestBadOptions(c *C) {
+	_, err := New(sni)
+	c.Assert(err, NotNil)
+	_, err = New(sni, ClientCerts(nil, true))
+	c.Assert(err, NotNil)
+	_, err = New(sni, CertificateFiles("/does/not/exist", "/does/not/exist"))
+	c.Assert(err, NotNil)
+	_, err = New(sni, Timeouts(-1, 0))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,184 @@
+package slowclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type connPhase int
+
+const (
+	phaseHeader connPhase = iota
+	phaseBody
+	// phaseIdle waits for the next request on the keep-alive connection, the header phase starts with its first byte
+	phaseIdle
+	// phaseOff stops enforcing the rates, e.g. on HTTP/2 connections
+	phaseOff
+)
+
+// conn sets the read deadline of every read to the time the client is allowed to wait
+// before falling below the minimum rate of the current phase
+type conn struct {
+	net.Conn
+	g          *Guard
+	remoteAddr string
+
+	mtx     sync.Mutex
+	phase   connPhase
+	bytes   int64
+	waited  time.Duration
+	reading bool
+	// deadline is the read deadline set by the server, guarded is set while the earlier limit applies instead
+	deadline time.Time
+	limit    time.Time
+	guarded  bool
+	// err is returned by all reads once the client is rejected
+	err error
+
+	closeOnce sync.Once
+}
+
+func newConn(nc net.Conn, g *Guard) *conn {
+	c := &conn{Conn: nc, g: g, remoteAddr: nc.RemoteAddr().String()}
+	g.add(c)
+	return c
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if err := c.begin(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	n, err := c.Conn.Read(p)
+	return n, c.end(time.Since(start), n, err)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.deadline = t
+	return c.apply()
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() { c.g.remove(c) })
+	return c.Conn.Close()
+}
+
+// rate returns the rate enforced in the current phase
+func (c *conn) rate() (rate, Phase, bool) {
+	switch {
+	case c.phase == phaseHeader && c.g.header.bytes > 0:
+		return c.g.header, PhaseHeader, true
+	case c.phase == phaseBody && c.reading && c.g.body.bytes > 0:
+		return c.g.body, PhaseBody, true
+	}
+	return rate{}, 0, false
+}
+
+// begin sets the limit of the read, the readers may retry the reads after the errors, e.g. bufio.Reader.Peek,
+// so the rejected connections keep failing
+func (c *conn) begin() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	r, _, ok := c.rate()
+	if !ok {
+		c.release()
+		return nil
+	}
+	c.limit = time.Now().Add(r.allowance(c.bytes, c.waited))
+	c.guarded = true
+	return c.apply()
+}
+
+func (c *conn) end(waited time.Duration, n int, err error) error {
+	c.mtx.Lock()
+	r, phase, ok := c.rate()
+	if err != nil && ok && c.guarded && isTimeout(err) && !time.Now().Before(c.limit) {
+		rateErr := &RateError{Phase: phase, Rate: r.bytes}
+		c.phase, c.err = phaseOff, rateErr
+		c.release()
+		c.mtx.Unlock()
+		c.g.reject(phase, c.remoteAddr)
+		return rateErr
+	}
+	defer c.mtx.Unlock()
+	if n > 0 {
+		switch {
+		case c.phase == phaseIdle:
+			c.phase, c.bytes, c.waited = phaseHeader, int64(n), 0
+		case ok:
+			c.bytes += int64(n)
+			c.waited += waited
+		}
+	}
+	return err
+}
+
+func (c *conn) setPhase(p connPhase) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.phase == phaseOff {
+		return
+	}
+	c.phase, c.bytes, c.waited, c.reading = p, 0, 0, false
+	// the server may be reading in the background already, e.g. to detect closed connections
+	c.release()
+}
+
+func (c *conn) setReading(reading bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.reading = reading
+	if !reading {
+		c.release()
+	}
+}
+
+// apply sets the earlier of the server deadline and the limit
+func (c *conn) apply() error {
+	d := c.deadline
+	if c.guarded && (d.IsZero() || c.limit.Before(d)) {
+		d = c.limit
+	}
+	return c.Conn.SetReadDeadline(d)
+}
+
+// release restores the server deadline
+func (c *conn) release() {
+	if c.guarded {
+		c.guarded = false
+		c.Conn.SetReadDeadline(c.deadline)
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// body marks the reads of the request body, so the body rate only counts the time the handler waits for the body
+type body struct {
+	io.ReadCloser
+	c *conn
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.c.setReading(true)
+	n, err := b.ReadCloser.Read(p)
+	b.c.setReading(false)
+	return n, err
+}
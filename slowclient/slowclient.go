@@ -0,0 +1,240 @@
+// package slowclient protects the proxy from the clients sending the requests too slowly, e.g. slowloris attacks:
+// the connections sending the request headers or the request bodies below the minimum rates are closed
+// before the requests reach the backends. The rates are enforced on the connections accepted by the guarded
+// listener, the middleware tells the connections which part of the request is being read.
+//
+//	g, _ := slowclient.New(next, slowclient.HeaderRate(1024, 5*time.Second), slowclient.BodyRate(4096, 10*time.Second))
+//	srv := &http.Server{Handler: g}
+//	srv.Serve(g.Listen(l))
+//
+// HTTP/2 has its own flow control, so the HTTP/2 connections are only held to the header rate until
+// their first request arrives.
+package slowclient
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Phase is the part of the request being read when the client was rejected
+type Phase int
+
+const (
+	// PhaseHeader covers the request line and the headers, TLS handshake included
+	PhaseHeader Phase = iota
+	// PhaseBody covers the request body
+	PhaseBody
+)
+
+func (p Phase) String() string {
+	if p == PhaseBody {
+		return "body"
+	}
+	return "header"
+}
+
+// RateError is returned by the reads of the connections that fell below the minimum rate,
+// utils.StdHandler serves it with 408 status code
+type RateError struct {
+	Phase Phase
+	// Rate is the minimum rate in bytes per second
+	Rate int64
+}
+
+func (e *RateError) Error() string {
+	return fmt.Sprintf("client has sent the request %v below %d bytes/s", e.Phase, e.Rate)
+}
+
+func (e *RateError) Unwrap() error {
+	return utils.ErrClientTimeout
+}
+
+// Timeout reports the error as the timeout, so the server closes the connection quietly
+func (e *RateError) Timeout() bool {
+	return true
+}
+
+func (e *RateError) Temporary() bool {
+	return false
+}
+
+// Stats counts the rejected connections
+type Stats struct {
+	HeaderRejected int64
+	BodyRejected   int64
+}
+
+// Guard enforces the minimum rates on the guarded connections
+type Guard struct {
+	next http.Handler
+
+	header rate
+	body   rate
+
+	onReject func(Phase, string)
+	log      utils.Logger
+
+	rejected [2]int64
+
+	mtx   sync.Mutex
+	conns map[string]*conn
+}
+
+// rate requires the client to send bytes per second once the grace period has passed,
+// the time counted is the time the proxy spent waiting for the client
+type rate struct {
+	bytes int64
+	grace time.Duration
+}
+
+// allowance returns the time left to the client that has sent n bytes after the wait
+func (r rate) allowance(n int64, waited time.Duration) time.Duration {
+	return r.grace + time.Duration(float64(n)/float64(r.bytes)*float64(time.Second)) - waited
+}
+
+// Option is a functional option setter for Guard
+type Option func(g *Guard) error
+
+// HeaderRate sets the minimum rate of the request headers in bytes per second, enforced after the grace period
+// from the accepted connection or from the first byte of the next request on the keep-alive connection
+func HeaderRate(bytes int64, grace time.Duration) Option {
+	return func(g *Guard) error {
+		if bytes <= 0 || grace <= 0 {
+			return fmt.Errorf("header rate and grace period should be > 0, got %v and %v", bytes, grace)
+		}
+		g.header = rate{bytes: bytes, grace: grace}
+		return nil
+	}
+}
+
+// BodyRate sets the minimum rate of the request bodies in bytes per second, enforced after the grace period
+// of the waits for the body, the time the handler does not read the body is not counted
+func BodyRate(bytes int64, grace time.Duration) Option {
+	return func(g *Guard) error {
+		if bytes <= 0 || grace <= 0 {
+			return fmt.Errorf("body rate and grace period should be > 0, got %v and %v", bytes, grace)
+		}
+		g.body = rate{bytes: bytes, grace: grace}
+		return nil
+	}
+}
+
+// OnReject sets the function called for every rejected connection with its remote address,
+// e.g. to count the rejections in the metrics
+func OnReject(fn func(phase Phase, remoteAddr string)) Option {
+	return func(g *Guard) error {
+		g.onReject = fn
+		return nil
+	}
+}
+
+// Logger sets the logger reporting the rejected connections
+func Logger(l utils.Logger) Option {
+	return func(g *Guard) error {
+		g.log = l
+		return nil
+	}
+}
+
+// New returns the guard passing the requests to the next handler, at least one of the rates is required
+func New(next http.Handler, opts ...Option) (*Guard, error) {
+	g := &Guard{next: next, conns: make(map[string]*conn)}
+	for _, o := range opts {
+		if err := o(g); err != nil {
+			return nil, err
+		}
+	}
+	if g.header.bytes == 0 && g.body.bytes == 0 {
+		return nil, fmt.Errorf("provide header or body rate")
+	}
+	if g.log == nil {
+		g.log = utils.NullLogger
+	}
+	return g, nil
+}
+
+// Wrap sets the next handler to be called by the guard
+func (g *Guard) Wrap(next http.Handler) {
+	g.next = next
+}
+
+// Stats returns the number of the connections rejected so far
+func (g *Guard) Stats() Stats {
+	return Stats{
+		HeaderRejected: atomic.LoadInt64(&g.rejected[PhaseHeader]),
+		BodyRejected:   atomic.LoadInt64(&g.rejected[PhaseBody]),
+	}
+}
+
+func (g *Guard) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c := g.conn(req.RemoteAddr)
+	if c == nil {
+		// the connection has not been accepted by the guarded listener
+		g.next.ServeHTTP(w, req)
+		return
+	}
+	if req.ProtoMajor >= 2 {
+		c.setPhase(phaseOff)
+		g.next.ServeHTTP(w, req)
+		return
+	}
+	c.setPhase(phaseBody)
+	defer c.setPhase(phaseIdle)
+	if g.body.bytes > 0 && req.Body != nil && req.Body != http.NoBody {
+		req.Body = &body{ReadCloser: req.Body, c: c}
+	}
+	g.next.ServeHTTP(w, req)
+}
+
+func (g *Guard) reject(phase Phase, remoteAddr string) {
+	atomic.AddInt64(&g.rejected[phase], 1)
+	g.log.Warningf("rejecting %v: request %v below the minimum rate", remoteAddr, phase)
+	if g.onReject != nil {
+		g.onReject(phase, remoteAddr)
+	}
+}
+
+func (g *Guard) conn(remoteAddr string) *conn {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.conns[remoteAddr]
+}
+
+func (g *Guard) add(c *conn) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.conns[c.RemoteAddr().String()] = c
+}
+
+func (g *Guard) remove(c *conn) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.conns[c.RemoteAddr().String()] == c {
+		delete(g.conns, c.RemoteAddr().String())
+	}
+}
+
+// Listen returns the listener accepting the guarded connections, the requests have to be served by the guard
+// for the rates to be enforced
+func (g *Guard) Listen(l net.Listener) net.Listener {
+	return &listener{Listener: l, g: g}
+}
+
+type listener struct {
+	net.Listener
+	g *Guard
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	nc, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(nc, l.g), nil
+}
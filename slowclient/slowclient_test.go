@@ -0,0 +1,169 @@
+package slowclient
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestSlowClient(t *testing.T) { TestingT(t) }
+
+type GuardSuite struct{}
+
+var _ = Suite(&GuardSuite{})
+
+func newServer(c *C, handler http.Handler, opts ...Option) (*Guard, *httptest.Server) {
+	g, err := New(handler, opts...)
+	c.Assert(err, IsNil)
+	srv := httptest.NewUnstartedServer(g)
+	srv.Listener = g.Listen(srv.Listener)
+	srv.Start()
+	return g, srv
+}
+
+func echo() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		w.Write([]byte("hello" + string(body)))
+	})
+}
+
+func dial(c *C, srv *httptest.Server) net.Conn {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func (s *GuardSuite) TestFastClient(c *C) {
+	g, srv := newServer(c, echo(), HeaderRate(100, 100*time.Millisecond), BodyRate(100, 100*time.Millisecond))
+	defer srv.Close()
+
+	re, body, err := testutils.MakeRequest(srv.URL, testutils.Method("POST"), testutils.Body(" world"))
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello world")
+	c.Assert(g.Stats(), Equals, Stats{})
+}
+
+func (s *GuardSuite) TestSlowHeaders(c *C) {
+	rejected := make(chan Phase, 1)
+	g, srv := newServer(c, echo(), HeaderRate(100, 100*time.Millisecond), OnReject(func(p Phase, addr string) {
+		rejected <- p
+	}))
+	defer srv.Close()
+
+	conn := dial(c, srv)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	time.Sleep(400 * time.Millisecond)
+	conn.Write([]byte("Host: localhost\r\n\r\n"))
+
+	// the connection is closed without the response
+	reply, _ := io.ReadAll(conn)
+	c.Assert(string(reply), Equals, "")
+	c.Assert(<-rejected, Equals, PhaseHeader)
+	c.Assert(g.Stats(), Equals, Stats{HeaderRejected: 1})
+}
+
+func (s *GuardSuite) TestIdleKeepAlive(c *C) {
+	g, srv := newServer(c, echo(), HeaderRate(100, 100*time.Millisecond))
+	defer srv.Close()
+
+	conn := dial(c, srv)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		// the wait for the next request is not counted against the header rate
+		if i != 0 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		re, err := http.ReadResponse(r, nil)
+		c.Assert(err, IsNil)
+		io.ReadAll(re.Body)
+		c.Assert(re.StatusCode, Equals, http.StatusOK)
+	}
+	c.Assert(g.Stats(), Equals, Stats{})
+}
+
+func (s *GuardSuite) TestSlowBody(c *C) {
+	errs := make(chan error, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := io.ReadAll(req.Body)
+		errs <- err
+	})
+	g, srv := newServer(c, handler, BodyRate(100, 100*time.Millisecond))
+	defer srv.Close()
+
+	conn := dial(c, srv)
+	defer conn.Close()
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\na"))
+
+	select {
+	case err := <-errs:
+		// the error is returned once the rejection is counted
+		var rateErr *RateError
+		c.Assert(errors.As(err, &rateErr), Equals, true)
+		c.Assert(rateErr.Phase, Equals, PhaseBody)
+		c.Assert(errors.Is(err, utils.ErrClientTimeout), Equals, true)
+	case <-time.After(2 * time.Second):
+		c.Fatalf("timeout waiting for the body rejection")
+	}
+	c.Assert(g.Stats(), Equals, Stats{BodyRejected: 1})
+}
+
+func (s *GuardSuite) TestBodyNotRead(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the time the handler is busy is not counted against the body rate
+		time.Sleep(400 * time.Millisecond)
+		echo().ServeHTTP(w, req)
+	})
+	g, srv := newServer(c, handler, BodyRate(100, 100*time.Millisecond))
+	defer srv.Close()
+
+	conn := dial(c, srv)
+	defer conn.Close()
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\n w"))
+	time.Sleep(300 * time.Millisecond)
+	conn.Write([]byte("orld"))
+
+	re, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	c.Assert(err, IsNil)
+	body, _ := io.ReadAll(re.Body)
+	c.Assert(string(body), Equals, "hello world")
+	c.Assert(g.Stats(), Equals, Stats{})
+}
+
+func (s *GuardSuite) TestNotGuarded(c *C) {
+	g, err := New(echo(), HeaderRate(100, 100*time.Millisecond))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	re, body, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+}
+
+func (s *GuardSuite) TestBadOptions(c *C) {
+	_, err := New(echo())
+	c.Assert(err, NotNil)
+	_, err = New(echo(), HeaderRate(0, time.Second))
+	c.Assert(err, NotNil)
+	_, err = New(echo(), BodyRate(100, 0))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,94 @@
+package statsd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/cbreaker"
+	"github.com/mailgun/oxy/utils"
+)
+
+// Timer records the processing time of the requests passed to the next handler, tagged with
+// the method and the status code:
+//
+//	latency:12.5|ms|#method:GET,status:200,class:2xx
+type Timer struct {
+	next   http.Handler
+	client *Client
+	name   string
+}
+
+// NewTimer returns the handler recording the timings of the requests as the metric with the name
+func NewTimer(next http.Handler, client *Client, name string) (*Timer, error) {
+	if client == nil || name == "" {
+		return nil, fmt.Errorf("client and metric name are required")
+	}
+	return &Timer{next: next, client: client, name: name}, nil
+}
+
+// Wrap sets the next handler to be called by the timer
+func (t *Timer) Wrap(next http.Handler) {
+	t.next = next
+}
+
+func (t *Timer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	pw := &utils.ProxyWriter{W: w}
+	start := t.client.clock.UtcNow()
+	t.next.ServeHTTP(pw, req)
+	code := pw.StatusCode()
+	t.client.Timing(t.name, t.client.clock.UtcNow().Sub(start),
+		Tag("method", req.Method), Tag("status", code), Tag("class", fmt.Sprintf("%dxx", code/100)))
+}
+
+// SideEffect returns the circuit breaker side effect incrementing the counter, e.g. to count
+// the breaker transitions:
+//
+//	cbreaker.New(next, expr, cbreaker.OnTripped(client.SideEffect("breaker", "state:tripped")))
+func (c *Client) SideEffect(name string, tags ...string) cbreaker.SideEffect {
+	return &sideEffect{client: c, name: name, tags: tags}
+}
+
+type sideEffect struct {
+	client *Client
+	name   string
+	tags   []string
+}
+
+func (s *sideEffect) Exec() error {
+	s.client.Incr(s.name, s.tags...)
+	return nil
+}
+
+// Errors returns the error handler counting the rejected requests by the status code, e.g. the requests
+// rejected by the rate limiter. The requests are passed to the next error handler, utils.DefaultHandler if nil.
+//
+//	ratelimit.New(next, extract, rates, ratelimit.ErrorHandler(client.Errors("ratelimit.rejected", nil)))
+func (c *Client) Errors(name string, next utils.ErrorHandler) utils.ErrorHandler {
+	if next == nil {
+		next = utils.DefaultHandler
+	}
+	return utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+		pw := &utils.ProxyWriter{W: w}
+		next.ServeHTTP(pw, req, err)
+		c.Incr(name, Tag("status", pw.StatusCode()))
+	})
+}
+
+// Ejector returns the ejector counting the servers ejected from the load balancer, tagged with the server,
+// before passing them on to the next ejector, e.g. to watch the server health flaps of cbreaker.PerServer
+func (c *Client) Ejector(name string, next cbreaker.Ejector) cbreaker.Ejector {
+	return &ejector{client: c, name: name, next: next}
+}
+
+type ejector struct {
+	client *Client
+	name   string
+	next   cbreaker.Ejector
+}
+
+func (e *ejector) EjectServer(u *url.URL, d time.Duration) error {
+	e.client.Incr(e.name, Tag("server", u.Host))
+	return e.next.EjectServer(u, d)
+}
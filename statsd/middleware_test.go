@@ -0,0 +1,66 @@
+package statsd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/mailgun/oxy/testutils"
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type MiddlewareSuite struct{}
+
+var _ = Suite(&MiddlewareSuite{})
+
+func (s *MiddlewareSuite) TestTimer(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	agent, client := newAgent(c, Clock(clock))
+	defer agent.Close()
+	defer client.Close()
+
+	t, err := NewTimer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		clock.Sleep(25 * time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	}), client, "request")
+	c.Assert(err, IsNil)
+
+	t.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://localhost", nil))
+	c.Assert(receive(c, agent), Equals, "request:25|ms|#method:POST,status:418,class:4xx")
+
+	_, err = NewTimer(nil, nil, "request")
+	c.Assert(err, NotNil)
+}
+
+func (s *MiddlewareSuite) TestAdapters(c *C) {
+	agent, client := newAgent(c)
+	defer agent.Close()
+	defer client.Close()
+
+	c.Assert(client.SideEffect("breaker", "state:tripped").Exec(), IsNil)
+	c.Assert(receive(c, agent), Equals, "breaker:1|c|#state:tripped")
+
+	w := httptest.NewRecorder()
+	client.Errors("rejected", nil).ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil), fmt.Errorf("oops"))
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(receive(c, agent), Equals, "rejected:1|c|#status:500")
+
+	var ejected *url.URL
+	ej := client.Ejector("ejected", ejectorFunc(func(u *url.URL, d time.Duration) error {
+		ejected = u
+		return nil
+	}))
+	c.Assert(ej.EjectServer(testutils.ParseURI("http://10.0.0.1:80"), time.Second), IsNil)
+	c.Assert(ejected.Host, Equals, "10.0.0.1:80")
+	c.Assert(receive(c, agent), Equals, "ejected:1|c|#server:10.0.0.1:80")
+}
+
+type ejectorFunc func(u *url.URL, d time.Duration) error
+
+func (f ejectorFunc) EjectServer(u *url.URL, d time.Duration) error {
+	return f(u, d)
+}
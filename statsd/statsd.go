@@ -0,0 +1,173 @@
+// package statsd emits the middleware metrics to StatsD or DogStatsD over UDP: request timings,
+// circuit breaker transitions, rejected requests and servers ejected from the load balancer
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
+)
+
+// Client sends the metrics to the StatsD agent, every metric is sent in its own datagram.
+// The tags are sent in the DogStatsD format, name:value|type|#key:value,key:value.
+type Client struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+	plain  bool
+	clock  timetools.TimeProvider
+	log    utils.Logger
+
+	// failing is set once a write fails, so the failures are logged once until the next success
+	mtx     sync.Mutex
+	failing bool
+}
+
+// Option is a functional option setter for Client
+type Option func(c *Client) error
+
+// Prefix is prepended to the names of all metrics, e.g. "oxy."
+func Prefix(p string) Option {
+	return func(c *Client) error {
+		c.prefix = p
+		return nil
+	}
+}
+
+// Tags are added to all metrics, e.g. "env:prod"
+func Tags(tags ...string) Option {
+	return func(c *Client) error {
+		c.tags = append(c.tags, tags...)
+		return nil
+	}
+}
+
+// Plain drops the tags, for the StatsD servers not supporting the DogStatsD extensions
+func Plain() Option {
+	return func(c *Client) error {
+		c.plain = true
+		return nil
+	}
+}
+
+// Clock sets the clock measuring the request timings, intended for tests
+func Clock(clock timetools.TimeProvider) Option {
+	return func(c *Client) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// Logger sets the logger reporting the failed writes
+func Logger(l utils.Logger) Option {
+	return func(c *Client) error {
+		c.log = l
+		return nil
+	}
+}
+
+// New returns the client sending the metrics to the agent address, e.g. 127.0.0.1:8125
+func New(addr string, opts ...Option) (*Client, error) {
+	c := &Client{}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if c.clock == nil {
+		c.clock = &timetools.RealTime{}
+	}
+	if c.log == nil {
+		c.log = utils.NullLogger
+	}
+	return c, nil
+}
+
+// Count adds n to the counter
+func (c *Client) Count(name string, n int64, tags ...string) {
+	c.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+// Incr increments the counter
+func (c *Client) Incr(name string, tags ...string) {
+	c.Count(name, 1, tags...)
+}
+
+// Gauge sets the gauge to the value
+func (c *Client) Gauge(name string, value float64, tags ...string) {
+	c.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Timing records the duration in milliseconds
+func (c *Client) Timing(name string, d time.Duration, tags ...string) {
+	c.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Close closes the connection to the agent
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) send(name, value, kind string, tags []string) {
+	var b strings.Builder
+	b.WriteString(sanitize(c.prefix + name))
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if !c.plain && len(c.tags)+len(tags) != 0 {
+		b.WriteString("|#")
+		for i, t := range append(append([]string{}, c.tags...), tags...) {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(sanitizeTag(t))
+		}
+	}
+	_, err := c.conn.Write([]byte(b.String()))
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err != nil && !c.failing {
+		c.log.Warningf("failed to send metric %v: %v", name, err)
+	}
+	c.failing = err != nil
+}
+
+// sanitize replaces the characters of the StatsD protocol in the metric name
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// sanitizeTag replaces the characters of the DogStatsD protocol in the tag, the first colon separates the value
+func sanitizeTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '@', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, tag)
+}
+
+// Tag formats the key and the value of the tag
+func Tag(key string, value interface{}) string {
+	return fmt.Sprintf("%v:%v", key, value)
+}
@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestStatsd(t *testing.T) { TestingT(t) }
+
+type StatsdSuite struct{}
+
+var _ = Suite(&StatsdSuite{})
+
+// newAgent returns the fake agent and the client sending the metrics to it
+func newAgent(c *C, opts ...Option) (*net.UDPConn, *Client) {
+	agent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	client, err := New(agent.LocalAddr().String(), opts...)
+	c.Assert(err, IsNil)
+	return agent, client
+}
+
+// receive returns the next datagram received by the agent
+func receive(c *C, agent *net.UDPConn) string {
+	buf := make([]byte, 1024)
+	agent.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := agent.Read(buf)
+	c.Assert(err, IsNil)
+	return string(buf[:n])
+}
+
+func (s *StatsdSuite) TestMetrics(c *C) {
+	agent, client := newAgent(c, Prefix("oxy."), Tags("env:prod"))
+	defer agent.Close()
+	defer client.Close()
+
+	client.Incr("requests")
+	c.Assert(receive(c, agent), Equals, "oxy.requests:1|c|#env:prod")
+	client.Count("bytes", 512, "route:api")
+	c.Assert(receive(c, agent), Equals, "oxy.bytes:512|c|#env:prod,route:api")
+	client.Gauge("servers", 3)
+	c.Assert(receive(c, agent), Equals, "oxy.servers:3|g|#env:prod")
+	client.Timing("latency", 1500*time.Microsecond, Tag("status", 200))
+	c.Assert(receive(c, agent), Equals, "oxy.latency:1.5|ms|#env:prod,status:200")
+
+	// the protocol characters are replaced
+	client.Incr("a:b|c", "path:/a,b")
+	c.Assert(receive(c, agent), Equals, "oxy.a_b_c:1|c|#env:prod,path:/a_b")
+}
+
+func (s *StatsdSuite) TestPlain(c *C) {
+	agent, client := newAgent(c, Plain(), Tags("env:prod"))
+	defer agent.Close()
+	defer client.Close()
+
+	client.Incr("requests", "route:api")
+	c.Assert(receive(c, agent), Equals, "requests:1|c")
+}
@@ -1,9 +1,11 @@
 package stream
 
 import (
+	gocontext "context"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"time"
 
 	"github.com/mailgun/oxy/forward"
 	"github.com/mailgun/oxy/roundrobin"
@@ -93,3 +95,32 @@ func new(c *C, p string) (*roundrobin.RoundRobin, *Streamer) {
 
 	return lb, st
 }
+
+func (s *RTSuite) TestNoRetryUnderBudget(c *C) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	st, err := New(handler, Retry(`IsNetworkError() && Attempts() <= 2`), MinRetryBudget(time.Second))
+	c.Assert(err, IsNil)
+
+	// plenty of time left, the request is retried
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), time.Minute)
+	defer cancel()
+	w := httptest.NewRecorder()
+	st.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx))
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+	c.Assert(attempts, Equals, 3)
+
+	attempts = 0
+	ctx, cancel = gocontext.WithTimeout(gocontext.Background(), 500*time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	st.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx))
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+	c.Assert(attempts, Equals, 1)
+
+	_, err = New(handler, MinRetryBudget(0))
+	c.Assert(err, NotNil)
+}
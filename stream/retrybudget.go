@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/memmetrics"
+	"github.com/mailgun/timetools"
+)
+
+// RetryBudget caps the retries to the ratio of the requests served over the rolling window, so the correlated
+// failures of the backends do not multiply the load with retries. A budget can be shared by several streamers
+// to make the cap global.
+//
+//	// retries may not exceed 20% of the requests over the last 10 seconds
+//	budget, _ := stream.NewRetryBudget(0.2, 10*time.Second)
+//	stream.New(lb, stream.Retry(`IsNetworkError() && Attempts() <= 2`), stream.LimitRetries(budget))
+type RetryBudget struct {
+	mtx        sync.Mutex
+	ratio      float64
+	minRetries int64
+	clock      timetools.TimeProvider
+	requests   *memmetrics.RollingCounter
+	retries    *memmetrics.RollingCounter
+}
+
+// RetryBudgetOption is a functional option setter for RetryBudget
+type RetryBudgetOption func(b *RetryBudget) error
+
+// MinRetries allows the retries over the window regardless of the ratio, so the low traffic can be retried
+func MinRetries(n int64) RetryBudgetOption {
+	return func(b *RetryBudget) error {
+		if n < 0 {
+			return fmt.Errorf("min retries can not be negative, got %d", n)
+		}
+		b.minRetries = n
+		return nil
+	}
+}
+
+// BudgetClock sets the clock of the rolling window, intended for tests
+func BudgetClock(clock timetools.TimeProvider) RetryBudgetOption {
+	return func(b *RetryBudget) error {
+		b.clock = clock
+		return nil
+	}
+}
+
+// NewRetryBudget returns the budget allowing the retries up to the ratio of the requests over the window,
+// the window is counted with the second resolution
+func NewRetryBudget(ratio float64, window time.Duration, opts ...RetryBudgetOption) (*RetryBudget, error) {
+	if ratio < 0 {
+		return nil, fmt.Errorf("ratio can not be negative, got %v", ratio)
+	}
+	if window < time.Second {
+		return nil, fmt.Errorf("window should be at least a second, got %v", window)
+	}
+	b := &RetryBudget{ratio: ratio}
+	for _, o := range opts {
+		if err := o(b); err != nil {
+			return nil, err
+		}
+	}
+	if b.clock == nil {
+		b.clock = &timetools.RealTime{}
+	}
+	var err error
+	buckets := int(window / time.Second)
+	if b.requests, err = memmetrics.NewCounter(buckets, time.Second, memmetrics.CounterClock(b.clock)); err != nil {
+		return nil, err
+	}
+	if b.retries, err = memmetrics.NewCounter(buckets, time.Second, memmetrics.CounterClock(b.clock)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// LimitRetries makes the streamer consult the budget before every retry attempt
+func LimitRetries(b *RetryBudget) optSetter {
+	return func(s *Streamer) error {
+		s.retryBudget = b
+		return nil
+	}
+}
+
+// Counts returns the requests and retries over the window
+func (b *RetryBudget) Counts() (requests int64, retries int64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.requests.Count(), b.retries.Count()
+}
+
+func (b *RetryBudget) onRequest() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.requests.Inc(1)
+}
+
+// withdraw records the retry if it fits in the budget
+func (b *RetryBudget) withdraw() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	allowed := int64(b.ratio * float64(b.requests.Count()))
+	if allowed < b.minRetries {
+		allowed = b.minRetries
+	}
+	if b.retries.Count() >= allowed {
+		return false
+	}
+	b.retries.Inc(1)
+	return true
+}
@@ -0,0 +1,92 @@
+package stream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+type RetryBudgetSuite struct {
+	clock *timetools.FreezedTime
+}
+
+var _ = Suite(&RetryBudgetSuite{})
+
+func (s *RetryBudgetSuite) SetUpTest(c *C) {
+	s.clock = &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func (s *RetryBudgetSuite) TestLimitsRetries(c *C) {
+	budget, err := NewRetryBudget(0.2, 10*time.Second, BudgetClock(s.clock))
+	c.Assert(err, IsNil)
+
+	attempts := 0
+	fail := true
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	st, err := New(handler, Retry(`IsNetworkError() && Attempts() <= 2`), LimitRetries(budget))
+	c.Assert(err, IsNil)
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		st.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+		return w.Code
+	}
+
+	// 10 successful requests earn 2 retries
+	fail = false
+	for i := 0; i < 9; i++ {
+		c.Assert(serve(), Equals, http.StatusOK)
+	}
+	fail = true
+	attempts = 0
+	c.Assert(serve(), Equals, http.StatusBadGateway)
+	c.Assert(attempts, Equals, 3)
+
+	// the budget is exhausted
+	attempts = 0
+	c.Assert(serve(), Equals, http.StatusBadGateway)
+	c.Assert(attempts, Equals, 1)
+
+	requests, retries := budget.Counts()
+	c.Assert(requests, Equals, int64(11))
+	c.Assert(retries, Equals, int64(2))
+
+	// the window rolls over
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(11 * time.Second)
+	requests, retries = budget.Counts()
+	c.Assert(requests, Equals, int64(0))
+	c.Assert(retries, Equals, int64(0))
+}
+
+func (s *RetryBudgetSuite) TestMinRetries(c *C) {
+	budget, err := NewRetryBudget(0, time.Second, MinRetries(1), BudgetClock(s.clock))
+	c.Assert(err, IsNil)
+
+	c.Assert(budget.withdraw(), Equals, true)
+	c.Assert(budget.withdraw(), Equals, false)
+
+	s.clock.CurrentTime = s.clock.CurrentTime.Add(2 * time.Second)
+	c.Assert(budget.withdraw(), Equals, true)
+}
+
+func (s *RetryBudgetSuite) TestBadOptions(c *C) {
+	_, err := NewRetryBudget(-1, time.Second)
+	c.Assert(err, NotNil)
+	_, err = NewRetryBudget(0.1, time.Millisecond)
+	c.Assert(err, NotNil)
+	_, err = NewRetryBudget(0.1, time.Second, MinRetries(-1))
+	c.Assert(err, NotNil)
+}
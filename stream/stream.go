@@ -36,10 +36,13 @@ Examples of a streaming middleware:
 package stream
 
 import (
+	// aliased as the package has own context type used by the retry predicates
+	gocontext "context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/mailgun/multibuf"
 	"github.com/mailgun/oxy/utils"
@@ -66,10 +69,14 @@ type Streamer struct {
 	memResponseBodyBytes int64
 
 	retryPredicate hpredicate
+	minRetryBudget time.Duration
+	retryBudget    *RetryBudget
 
 	next       http.Handler
 	errHandler utils.ErrorHandler
 	log        utils.Logger
+
+	drain utils.Drainer
 }
 
 // New returns a new streamer middleware. New() function supports optional functional arguments
@@ -123,6 +130,18 @@ func Retry(predicate string) optSetter {
 	}
 }
 
+// MinRetryBudget skips the retries of the requests with less time than d left until the deadline
+// of the request context, e.g. set by the budget middleware, as the retry would not complete in time anyway
+func MinRetryBudget(d time.Duration) optSetter {
+	return func(s *Streamer) error {
+		if d <= 0 {
+			return fmt.Errorf("min retry budget should be > 0, got %v", d)
+		}
+		s.minRetryBudget = d
+		return nil
+	}
+}
+
 // Logger sets the logger that will be used by this middleware.
 func Logger(l utils.Logger) optSetter {
 	return func(s *Streamer) error {
@@ -191,7 +210,19 @@ func (s *Streamer) Wrap(next http.Handler) error {
 	return nil
 }
 
+// Close stops accepting new requests and waits until the requests in flight are served and their
+// buffers and temp files are released, or the context is done
+func (s *Streamer) Close(ctx gocontext.Context) error {
+	return s.drain.Close(ctx)
+}
+
 func (s *Streamer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !s.drain.Enter() {
+		s.errHandler.ServeHTTP(w, req, utils.ErrShuttingDown)
+		return
+	}
+	defer s.drain.Leave()
+
 	if err := s.checkLimit(req); err != nil {
 		s.log.Infof("request body over limit: %v", err)
 		s.errHandler.ServeHTTP(w, req, err)
@@ -223,9 +254,14 @@ func (s *Streamer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	outreq := s.copyRequest(req, body, totalSize)
+	if s.retryBudget != nil {
+		s.retryBudget.onRequest()
+	}
 
 	attempt := 1
 	for {
+		utils.SetBagValue(req, utils.BagAttempt, attempt)
+
 		// We create a special writer that will limit the response size, buffer it to disk if necessary
 		writer, err := multibuf.NewWriterOnce(multibuf.MaxBytes(s.maxResponseBodyBytes), multibuf.MemBytes(s.memResponseBodyBytes))
 		if err != nil {
@@ -255,7 +291,8 @@ func (s *Streamer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 
 		if (s.retryPredicate == nil || attempt > DefaultMaxRetryAttempts) ||
-			!s.retryPredicate(&context{r: req, attempt: attempt, responseCode: b.code, log: s.log}) {
+			!s.retryPredicate(&context{r: req, attempt: attempt, responseCode: b.code, log: s.log}) ||
+			!s.hasRetryBudget(req) || !s.withdrawRetry(req) {
 			utils.CopyHeaders(w.Header(), b.Header())
 			w.WriteHeader(b.code)
 			if reader != nil {
@@ -275,6 +312,26 @@ func (s *Streamer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (s *Streamer) hasRetryBudget(req *http.Request) bool {
+	if s.minRetryBudget == 0 {
+		return true
+	}
+	deadline, ok := req.Context().Deadline()
+	if !ok || time.Until(deadline) >= s.minRetryBudget {
+		return true
+	}
+	s.log.Infof("not retrying Request(%v %v) with %v left", req.Method, req.URL, time.Until(deadline))
+	return false
+}
+
+func (s *Streamer) withdrawRetry(req *http.Request) bool {
+	if s.retryBudget == nil || s.retryBudget.withdraw() {
+		return true
+	}
+	s.log.Infof("not retrying Request(%v %v), retry budget is exhausted", req.Method, req.URL)
+	return false
+}
+
 func (s *Streamer) copyRequest(req *http.Request, body io.ReadCloser, bodySize int64) *http.Request {
 	o := *req
 	o.URL = utils.CopyURL(req.URL)
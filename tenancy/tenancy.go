@@ -0,0 +1,163 @@
+// Package tenancy scopes the policies of the middlewares to the tenants of the requests, so a single chain
+// enforces the isolated per tenant limits instead of a chain per tenant. The tenancy extracts the tenant
+// of the request and looks its config up in the store, the middlewares consume the parts of the config
+// they enforce:
+//
+//	store := tenancy.NewMemoryStore()
+//	store.Set("acme", tenancy.Config{MaxConnections: 100, Servers: []string{"10.0.0.1:80"}})
+//	extract, _ := utils.NewExtractor("request.header.X-Tenant-Id")
+//	tenants, _ := tenancy.New(extract, store)
+//
+//	lb, _ := roundrobin.New(fwd, roundrobin.Selection(roundrobin.TenantServers(tenants)))
+//	cb, _ := cbreaker.NewPerTenant(lb, `NetworkErrorRatio() > 0.5`, tenants)
+//	cl, _ := connlimit.New(cb, tenants, 10, connlimit.TenantLimits(tenants))
+//	rl, _ := ratelimit.New(cl, tenants, defaultRates, ratelimit.ExtractKeyRates(ratelimit.TenantRates(tenants), time.Minute))
+package tenancy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// Rate is the rate limit of the tenant, see ratelimit.TenantRates
+type Rate struct {
+	Period  time.Duration `json:"period"`
+	Average int64         `json:"average"`
+	Burst   int64         `json:"burst"`
+}
+
+// Config is the policy of the tenant, the zero fields leave the defaults of the middlewares
+type Config struct {
+	// Rates limit the requests of the tenant, see ratelimit.TenantRates
+	Rates []Rate `json:"rates,omitempty"`
+	// MaxConnections limits the concurrent requests of the tenant, see connlimit.TenantLimits
+	MaxConnections int64 `json:"max_connections,omitempty"`
+	// Breaker is the condition tripping the circuit breaker of the tenant, see cbreaker.NewPerTenant
+	Breaker string `json:"breaker,omitempty"`
+	// Servers are the hosts of the servers the tenant is routed to, see roundrobin.TenantServers
+	Servers []string `json:"servers,omitempty"`
+}
+
+// Store looks the configs of the tenants up, e.g. in the control plane
+type Store interface {
+	Config(tenant string) (Config, bool)
+}
+
+// MemoryStore is the store of the configs set at runtime, it is safe for the concurrent use
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	configs map[string]Config
+}
+
+// NewMemoryStore returns the empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{configs: make(map[string]Config)}
+}
+
+// Config returns the config of the tenant
+func (s *MemoryStore) Config(tenant string) (Config, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, ok := s.configs[tenant]
+	return c, ok
+}
+
+// Set adds or replaces the config of the tenant
+func (s *MemoryStore) Set(tenant string, c Config) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.configs[tenant] = c
+}
+
+// Remove removes the config of the tenant, the tenant gets the default config
+func (s *MemoryStore) Remove(tenant string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.configs, tenant)
+}
+
+// Tenants returns the tenants with the configs
+func (s *MemoryStore) Tenants() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	out := make([]string, 0, len(s.configs))
+	for tenant := range s.configs {
+		out = append(out, tenant)
+	}
+	return out
+}
+
+// Tenancy extracts the tenants of the requests and looks their configs up. It implements utils.SourceExtractor,
+// so it is passed to the limiters as the source of the requests.
+type Tenancy struct {
+	extract  utils.SourceExtractor
+	store    Store
+	defaults Config
+}
+
+// TenancyOption is a functional option setter for Tenancy
+type TenancyOption func(t *Tenancy) error
+
+// New returns the tenancy extracting the tenants with the extractor, e.g. from the header or the client certificate
+func New(extract utils.SourceExtractor, store Store, opts ...TenancyOption) (*Tenancy, error) {
+	if extract == nil {
+		return nil, fmt.Errorf("tenant extractor can not be nil")
+	}
+	if store == nil {
+		return nil, fmt.Errorf("tenant store can not be nil")
+	}
+	t := &Tenancy{extract: extract, store: store}
+	for _, o := range opts {
+		if err := o(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Defaults sets the config of the tenants missing from the store
+func Defaults(c Config) TenancyOption {
+	return func(t *Tenancy) error {
+		t.defaults = c
+		return nil
+	}
+}
+
+// Extract returns the tenant of the request and the amount of the extractor
+func (t *Tenancy) Extract(req *http.Request) (string, int64, error) {
+	return t.extract.Extract(req)
+}
+
+// Tenant returns the tenant of the request
+func (t *Tenancy) Tenant(req *http.Request) (string, error) {
+	tenant, _, err := t.extract.Extract(req)
+	return tenant, err
+}
+
+// Config returns the config of the tenant or the default one
+func (t *Tenancy) Config(tenant string) Config {
+	c, _ := t.Lookup(tenant)
+	return c
+}
+
+// Lookup returns the config of the tenant and true if the tenant is in the store, the default config
+// and false otherwise
+func (t *Tenancy) Lookup(tenant string) (Config, bool) {
+	if c, ok := t.store.Config(tenant); ok {
+		return c, true
+	}
+	return t.defaults, false
+}
+
+// RequestConfig returns the tenant of the request and its config
+func (t *Tenancy) RequestConfig(req *http.Request) (string, Config, error) {
+	tenant, err := t.Tenant(req)
+	if err != nil {
+		return "", Config{}, err
+	}
+	return tenant, t.Config(tenant), nil
+}
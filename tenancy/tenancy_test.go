@@ -0,0 +1,73 @@
+package tenancy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/mailgun/oxy/utils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestTenancy(t *testing.T) { TestingT(t) }
+
+type TenancySuite struct{}
+
+var _ = Suite(&TenancySuite{})
+
+func tenantRequest(tenant string) *http.Request {
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("X-Tenant-Id", tenant)
+	return req
+}
+
+func (s *TenancySuite) TestConfigs(c *C) {
+	extract, err := utils.NewExtractor("request.header.X-Tenant-Id")
+	c.Assert(err, IsNil)
+	store := NewMemoryStore()
+	store.Set("acme", Config{MaxConnections: 10, Servers: []string{"a:80"}})
+	store.Set("globex", Config{Breaker: "NetworkErrorRatio() > 0.1"})
+
+	t, err := New(extract, store, Defaults(Config{MaxConnections: 1}))
+	c.Assert(err, IsNil)
+
+	tenant, config, err := t.RequestConfig(tenantRequest("acme"))
+	c.Assert(err, IsNil)
+	c.Assert(tenant, Equals, "acme")
+	c.Assert(config, DeepEquals, Config{MaxConnections: 10, Servers: []string{"a:80"}})
+
+	// the unknown tenants get the defaults
+	tenant, config, err = t.RequestConfig(tenantRequest("initech"))
+	c.Assert(err, IsNil)
+	c.Assert(tenant, Equals, "initech")
+	c.Assert(config, DeepEquals, Config{MaxConnections: 1})
+	_, ok := t.Lookup("initech")
+	c.Assert(ok, Equals, false)
+	_, ok = t.Lookup("globex")
+	c.Assert(ok, Equals, true)
+
+	tenants := store.Tenants()
+	sort.Strings(tenants)
+	c.Assert(tenants, DeepEquals, []string{"acme", "globex"})
+
+	store.Remove("acme")
+	c.Assert(t.Config("acme"), DeepEquals, Config{MaxConnections: 1})
+
+	// the tenancy is the source extractor of the limiters
+	source, amount, err := t.Extract(tenantRequest("globex"))
+	c.Assert(err, IsNil)
+	c.Assert(source, Equals, "globex")
+	c.Assert(amount, Equals, int64(1))
+}
+
+func (s *TenancySuite) TestErrors(c *C) {
+	_, err := New(nil, NewMemoryStore())
+	c.Assert(err, NotNil)
+
+	extract, err := utils.NewExtractor("client.ip")
+	c.Assert(err, IsNil)
+	_, err = New(extract, nil)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,228 @@
+package testutils
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/timetools"
+)
+
+// LatencyFunc returns the delay of the next response, the random numbers come from the backend's seeded source
+type LatencyFunc func(r *rand.Rand) time.Duration
+
+// FixedLatency delays every response by d
+func FixedLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// UniformLatency delays the responses uniformly between min and max
+func UniformLatency(min, max time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// NormalLatency delays the responses by normally distributed durations, negative values are cut to 0
+func NormalLatency(mean, stddev time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		d := time.Duration(r.NormFloat64()*float64(stddev)) + mean
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// Backend is a fake backend server with injected latency, errors and connection resets,
+// the random decisions come from the seeded source, so the tests are deterministic
+type Backend struct {
+	*httptest.Server
+
+	mtx       sync.Mutex
+	rng       *rand.Rand
+	clock     timetools.TimeProvider
+	latency   LatencyFunc
+	errorRate float64
+	errorCode int
+	resetRate float64
+	body      string
+	chunks    []string
+	events    []string
+
+	hits int64
+}
+
+// BackendOption is a functional option setter for Backend
+type BackendOption func(b *Backend)
+
+// Response sets the body of the successful responses, "ok" by default
+func Response(body string) BackendOption {
+	return func(b *Backend) {
+		b.body = body
+	}
+}
+
+// Latency sets the latency of the responses
+func Latency(l LatencyFunc) BackendOption {
+	return func(b *Backend) {
+		b.latency = l
+	}
+}
+
+// ErrorRate makes the given ratio of the responses fail with the status code
+func ErrorRate(rate float64, code int) BackendOption {
+	return func(b *Backend) {
+		b.errorRate, b.errorCode = rate, code
+	}
+}
+
+// ResetRate makes the given ratio of the requests end with the connection reset instead of the response
+func ResetRate(rate float64) BackendOption {
+	return func(b *Backend) {
+		b.resetRate = rate
+	}
+}
+
+// Chunked sends the successful responses with chunked encoding, every chunk is flushed separately
+func Chunked(chunks ...string) BackendOption {
+	return func(b *Backend) {
+		b.chunks = chunks
+	}
+}
+
+// SSE sends the successful responses as server sent events stream, every event is flushed separately
+func SSE(events ...string) BackendOption {
+	return func(b *Backend) {
+		b.events = events
+	}
+}
+
+// Seed sets the seed of the random source, 1 by default
+func Seed(seed int64) BackendOption {
+	return func(b *Backend) {
+		b.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// BackendClock sets the clock used to wait the latency, e.g. timetools.FreezedTime to advance time without sleeping
+func BackendClock(clock timetools.TimeProvider) BackendOption {
+	return func(b *Backend) {
+		b.clock = clock
+	}
+}
+
+// NewBackend starts the fake backend
+func NewBackend(options ...BackendOption) *Backend {
+	b := &Backend{body: "ok"}
+	for _, o := range options {
+		o(b)
+	}
+	if b.rng == nil {
+		b.rng = rand.New(rand.NewSource(1))
+	}
+	if b.clock == nil {
+		b.clock = &timetools.RealTime{}
+	}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+	return b
+}
+
+// Hits returns the number of requests the backend has received
+func (b *Backend) Hits() int64 {
+	return atomic.LoadInt64(&b.hits)
+}
+
+// SetErrorRate changes the error rate at runtime, e.g. to make the backend fail in the middle of the test
+func (b *Backend) SetErrorRate(rate float64, code int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.errorRate, b.errorCode = rate, code
+}
+
+// SetLatency changes the latency at runtime
+func (b *Backend) SetLatency(l LatencyFunc) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.latency = l
+}
+
+// decide draws the fate of the request under the lock, so the sequence is the same for the same seed
+func (b *Backend) decide() (delay time.Duration, reset bool, code int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.latency != nil {
+		delay = b.latency(b.rng)
+	}
+	if b.resetRate > 0 && b.rng.Float64() < b.resetRate {
+		return delay, true, 0
+	}
+	if b.errorRate > 0 && b.rng.Float64() < b.errorRate {
+		return delay, false, b.errorCode
+	}
+	return delay, false, http.StatusOK
+}
+
+func (b *Backend) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&b.hits, 1)
+	delay, reset, code := b.decide()
+	if delay > 0 {
+		select {
+		case <-b.clock.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if reset {
+		resetConn(w)
+		return
+	}
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+		return
+	}
+	switch {
+	case len(b.events) != 0:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for _, e := range b.events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+			flush(w)
+		}
+	case len(b.chunks) != 0:
+		for _, c := range b.chunks {
+			w.Write([]byte(c))
+			flush(w)
+		}
+	default:
+		w.Write([]byte(b.body))
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// resetConn closes the connection with TCP RST, so the client sees the connection reset by peer
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+}
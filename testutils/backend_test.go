@@ -0,0 +1,122 @@
+package testutils
+
+import (
+	"bufio"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mailgun/timetools"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestTestutils(t *testing.T) { TestingT(t) }
+
+type BackendSuite struct{}
+
+var _ = Suite(&BackendSuite{})
+
+func (s *BackendSuite) TestResponse(c *C) {
+	b := NewBackend(Response("hello"))
+	defer b.Close()
+
+	re, body, err := Get(b.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+	c.Assert(b.Hits(), Equals, int64(1))
+}
+
+// codes returns the status codes of n requests, -1 for the transport errors
+func codes(c *C, url string, n int) []int {
+	var out []int
+	for i := 0; i < n; i++ {
+		re, _, err := Get(url)
+		if err != nil {
+			out = append(out, -1)
+			continue
+		}
+		out = append(out, re.StatusCode)
+	}
+	return out
+}
+
+func (s *BackendSuite) TestErrorRateDeterministic(c *C) {
+	a := NewBackend(ErrorRate(0.5, http.StatusServiceUnavailable), Seed(42))
+	defer a.Close()
+	b := NewBackend(ErrorRate(0.5, http.StatusServiceUnavailable), Seed(42))
+	defer b.Close()
+
+	ca, cb := codes(c, a.URL, 20), codes(c, b.URL, 20)
+	c.Assert(ca, DeepEquals, cb)
+
+	failed := 0
+	for _, code := range ca {
+		if code == http.StatusServiceUnavailable {
+			failed++
+		}
+	}
+	c.Assert(failed > 0 && failed < 20, Equals, true)
+
+	a.SetErrorRate(0, 0)
+	c.Assert(codes(c, a.URL, 3), DeepEquals, []int{200, 200, 200})
+}
+
+func (s *BackendSuite) TestReset(c *C) {
+	b := NewBackend(ResetRate(1))
+	defer b.Close()
+
+	_, _, err := Get(b.URL)
+	c.Assert(err, NotNil)
+}
+
+func (s *BackendSuite) TestLatency(c *C) {
+	clock := &timetools.FreezedTime{CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)}
+	b := NewBackend(Latency(FixedLatency(time.Hour)), BackendClock(clock))
+	defer b.Close()
+
+	start := clock.UtcNow()
+	_, _, err := Get(b.URL)
+	c.Assert(err, IsNil)
+	c.Assert(clock.UtcNow().Sub(start), Equals, time.Hour)
+}
+
+func (s *BackendSuite) TestLatencyDistributions(c *C) {
+	b := NewBackend()
+	defer b.Close()
+	for i := 0; i < 100; i++ {
+		d := UniformLatency(time.Millisecond, 2*time.Millisecond)(b.rng)
+		c.Assert(d >= time.Millisecond && d < 2*time.Millisecond, Equals, true)
+		c.Assert(NormalLatency(0, time.Second)(b.rng) >= 0, Equals, true)
+	}
+}
+
+func (s *BackendSuite) TestChunked(c *C) {
+	b := NewBackend(Chunked("hello ", "world"))
+	defer b.Close()
+
+	re, body, err := Get(b.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.TransferEncoding, DeepEquals, []string{"chunked"})
+	c.Assert(string(body), Equals, "hello world")
+}
+
+func (s *BackendSuite) TestSSE(c *C) {
+	b := NewBackend(SSE("a", "b"))
+	defer b.Close()
+
+	re, err := http.Get(b.URL)
+	c.Assert(err, IsNil)
+	defer re.Body.Close()
+	c.Assert(re.Header.Get("Content-Type"), Equals, "text/event-stream")
+
+	r := bufio.NewReader(re.Body)
+	var lines []string
+	for i := 0; i < 4; i++ {
+		line, err := r.ReadString('\n')
+		c.Assert(err, IsNil)
+		lines = append(lines, line)
+	}
+	c.Assert(lines, DeepEquals, []string{"data: a\n", "\n", "data: b\n", "\n"})
+}
@@ -0,0 +1,132 @@
+package testutils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock safe for concurrent use, it implements timetools.TimeProvider
+// and can be injected into any middleware that accepts a clock option.
+// Unlike timetools.FreezedTime, Sleep and After block until the test advances the time,
+// so the goroutines waiting on the clock wake up in the order of their deadlines.
+type Clock struct {
+	mtx     sync.Mutex
+	now     time.Time
+	waiters []*clockWaiter
+	changed chan struct{}
+}
+
+type clockWaiter struct {
+	until time.Time
+	c     chan time.Time
+}
+
+// NewClock returns the clock set to 2012-03-04 05:06:07 UTC, the time the tests of this repo use
+func NewClock() *Clock {
+	return NewClockAt(time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC))
+}
+
+// NewClockAt returns the clock set to the given time
+func NewClockAt(t time.Time) *Clock {
+	return &Clock{now: t, changed: make(chan struct{})}
+}
+
+// UtcNow returns the current fake time
+func (c *Clock) UtcNow() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// Sleep blocks until the clock is advanced by d
+func (c *Clock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// After returns the channel that receives the fake time once the clock is advanced by d
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	w := &clockWaiter{until: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	c.notify()
+	return w.c
+}
+
+// Advance moves the clock forward and wakes up the waiters whose deadlines have passed
+func (c *Clock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set sets the clock to t, waking up the waiters whose deadlines have passed
+func (c *Clock) Set(t time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.set(t)
+}
+
+// Waiters returns the number of goroutines blocked in Sleep or waiting on After
+func (c *Clock) Waiters() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until n goroutines are blocked on the clock, so the test can advance
+// the time knowing the code under test is already waiting
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mtx.Lock()
+		if len(c.waiters) >= n {
+			c.mtx.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mtx.Unlock()
+		<-changed
+	}
+}
+
+// AdvanceUntil advances the clock by step until the condition holds or max time passes,
+// it returns the time advanced and whether the condition holds, e.g. to check how long
+// the circuit breaker takes to recover
+func (c *Clock) AdvanceUntil(step, max time.Duration, cond func() bool) (time.Duration, bool) {
+	var elapsed time.Duration
+	for !cond() {
+		if elapsed >= max {
+			return elapsed, false
+		}
+		c.Advance(step)
+		elapsed += step
+	}
+	return elapsed, true
+}
+
+func (c *Clock) set(t time.Time) {
+	c.now = t
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].until.Before(c.waiters[j].until)
+	})
+	i := 0
+	for ; i < len(c.waiters) && !c.waiters[i].until.After(t); i++ {
+		c.waiters[i].c <- t
+	}
+	if i > 0 {
+		c.waiters = c.waiters[i:]
+		c.notify()
+	}
+}
+
+// notify wakes up BlockUntil callers
+func (c *Clock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
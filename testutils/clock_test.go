@@ -0,0 +1,67 @@
+package testutils
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ClockSuite struct{}
+
+var _ = Suite(&ClockSuite{})
+
+func (s *ClockSuite) TestAdvance(c *C) {
+	clock := NewClock()
+	start := clock.UtcNow()
+	clock.Advance(time.Minute)
+	c.Assert(clock.UtcNow().Sub(start), Equals, time.Minute)
+
+	clock.Set(start)
+	c.Assert(clock.UtcNow(), Equals, start)
+}
+
+func (s *ClockSuite) TestSleepBlocks(c *C) {
+	clock := NewClock()
+	woke := make(chan time.Duration, 2)
+	for _, d := range []time.Duration{2 * time.Second, time.Second} {
+		d := d
+		go func() {
+			clock.Sleep(d)
+			woke <- d
+		}()
+	}
+	clock.BlockUntil(2)
+
+	clock.Advance(time.Second)
+	c.Assert(<-woke, Equals, time.Second)
+	c.Assert(clock.Waiters(), Equals, 1)
+	select {
+	case <-woke:
+		c.Fatal("woke up too early")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	c.Assert(<-woke, Equals, 2*time.Second)
+	c.Assert(clock.Waiters(), Equals, 0)
+}
+
+func (s *ClockSuite) TestAfterZero(c *C) {
+	clock := NewClock()
+	c.Assert(<-clock.After(0), Equals, clock.UtcNow())
+}
+
+func (s *ClockSuite) TestAdvanceUntil(c *C) {
+	clock := NewClock()
+	deadline := clock.UtcNow().Add(3 * time.Second)
+
+	elapsed, ok := clock.AdvanceUntil(time.Second, time.Minute, func() bool {
+		return !clock.UtcNow().Before(deadline)
+	})
+	c.Assert(ok, Equals, true)
+	c.Assert(elapsed, Equals, 3*time.Second)
+
+	elapsed, ok = clock.AdvanceUntil(time.Second, 2*time.Second, func() bool { return false })
+	c.Assert(ok, Equals, false)
+	c.Assert(elapsed, Equals, 2*time.Second)
+}
@@ -0,0 +1,155 @@
+package testutils
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+// SSEEmitter is the server sending the events on demand, so the tests can check
+// that every event reaches the client before the next one is sent, i.e. the stream is not buffered
+type SSEEmitter struct {
+	*httptest.Server
+	events    chan string
+	connected chan struct{}
+}
+
+// NewSSEEmitter starts the emitter, it serves one stream at a time
+func NewSSEEmitter() *SSEEmitter {
+	e := &SSEEmitter{events: make(chan string), connected: make(chan struct{}, 1)}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.serveHTTP))
+	return e
+}
+
+// Emit sends the event to the connected client, it blocks until the client is connected
+func (e *SSEEmitter) Emit(data string) {
+	e.events <- data
+}
+
+// Connected returns the channel signalled every time the client connects
+func (e *SSEEmitter) Connected() <-chan struct{} {
+	return e.connected
+}
+
+// Close ends the pending streams and shuts the server down
+func (e *SSEEmitter) Close() {
+	close(e.events)
+	e.Server.Close()
+}
+
+func (e *SSEEmitter) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+	select {
+	case e.connected <- struct{}{}:
+	default:
+	}
+	for {
+		select {
+		case data, ok := <-e.events:
+			if !ok {
+				return
+			}
+			for _, line := range strings.Split(data, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			flush(w)
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// SSEStream reads the server sent events from the response body
+type SSEStream struct {
+	re     *http.Response
+	events chan sseEvent
+	done   chan struct{}
+}
+
+type sseEvent struct {
+	data string
+	err  error
+}
+
+// NewSSEStream starts reading the events of the response, the caller closes the stream when done
+func NewSSEStream(re *http.Response) *SSEStream {
+	s := &SSEStream{re: re, events: make(chan sseEvent), done: make(chan struct{})}
+	go s.read()
+	return s
+}
+
+// Next returns the data of the next event, failing if it does not arrive within timeout
+func (s *SSEStream) Next(timeout time.Duration) (string, error) {
+	select {
+	case e, ok := <-s.events:
+		if !ok {
+			return "", fmt.Errorf("stream is closed")
+		}
+		return e.data, e.err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("no event within %v, is the stream buffered?", timeout)
+	}
+}
+
+// Close closes the response body
+func (s *SSEStream) Close() error {
+	close(s.done)
+	return s.re.Body.Close()
+}
+
+func (s *SSEStream) send(e sseEvent) bool {
+	select {
+	case s.events <- e:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *SSEStream) read() {
+	r := bufio.NewReader(s.re.Body)
+	var data []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			s.send(sseEvent{err: err})
+			close(s.events)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data != nil {
+				if !s.send(sseEvent{data: strings.Join(data, "\n")}) {
+					return
+				}
+				data = nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+// AssertUnbuffered emits the events one by one and checks that each of them is received
+// by the stream before the next one is emitted
+func AssertUnbuffered(e *SSEEmitter, s *SSEStream, timeout time.Duration, events ...string) error {
+	for _, event := range events {
+		e.Emit(event)
+		got, err := s.Next(timeout)
+		if err != nil {
+			return err
+		}
+		if got != event {
+			return fmt.Errorf("expected event %q, got %q", event, got)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package testutils
+
+import (
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type StreamSuite struct{}
+
+var _ = Suite(&StreamSuite{})
+
+func (s *StreamSuite) TestUnbuffered(c *C) {
+	e := NewSSEEmitter()
+	defer e.Close()
+
+	re, err := http.Get(e.URL)
+	c.Assert(err, IsNil)
+	stream := NewSSEStream(re)
+	defer stream.Close()
+
+	c.Assert(re.Header.Get("Content-Type"), Equals, "text/event-stream")
+	c.Assert(AssertUnbuffered(e, stream, time.Second, "a", "multi\nline"), IsNil)
+}
+
+func (s *StreamSuite) TestNoEvent(c *C) {
+	e := NewSSEEmitter()
+	defer e.Close()
+
+	re, err := http.Get(e.URL)
+	c.Assert(err, IsNil)
+	stream := NewSSEStream(re)
+	defer stream.Close()
+	<-e.Connected()
+
+	_, err = stream.Next(10 * time.Millisecond)
+	c.Assert(err, ErrorMatches, ".*buffered.*")
+}
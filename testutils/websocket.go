@@ -0,0 +1,267 @@
+package testutils
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes of the frames, see RFC 6455
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxFrameSize limits the frames the harness accepts, it is meant for test messages only
+const maxFrameSize = 1 << 20
+
+// WSConn is a minimal WebSocket connection, enough to exchange the messages in tests
+type WSConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	wmtx   sync.Mutex
+	client bool
+	// Response is the handshake response, set on the client side only
+	Response *http.Response
+}
+
+// NewWebSocketEcho starts the server that echoes back every text and binary message it receives
+func NewWebSocketEcho() *httptest.Server {
+	return NewWebSocketServer(func(c *WSConn) {
+		for {
+			op, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(op, data); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// NewWebSocketServer starts the server that upgrades every request and passes the connection to the handler,
+// the connection is closed when the handler returns
+func NewWebSocketServer(handler func(c *WSConn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c, err := UpgradeWebSocket(w, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer c.Close()
+		handler(c)
+	}))
+}
+
+// UpgradeWebSocket performs the server side of the handshake
+func UpgradeWebSocket(w http.ResponseWriter, req *http.Request) (*WSConn, error) {
+	if !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support hijacking", w)
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WSConn{conn: conn, r: rw.Reader}, nil
+}
+
+// DialWebSocket connects to the ws:// or http:// URL and performs the client side of the handshake
+func DialWebSocket(rawURL string, header http.Header) (*WSConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = "http"
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	re, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if re.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %v", re.Status)
+	}
+	if re.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: bad Sec-WebSocket-Accept")
+	}
+	return &WSConn{conn: conn, r: r, client: true, Response: re}, nil
+}
+
+// WriteMessage sends the message in a single frame, client frames are masked as the RFC requires
+func (c *WSConn) WriteMessage(opcode int, data []byte) error {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+
+	header := []byte{0x80 | byte(opcode), 0}
+	var mask byte
+	if c.client {
+		mask = 0x80
+	}
+	switch l := len(data); {
+	case l < 126:
+		header[1] = mask | byte(l)
+	case l <= 0xffff:
+		header[1] = mask | 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(l))
+	default:
+		header[1] = mask | 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(l))
+	}
+	payload := data
+	if c.client {
+		key := make([]byte, 4)
+		rand.Read(key)
+		header = append(header, key...)
+		payload = make([]byte, len(data))
+		for i := range data {
+			payload[i] = data[i] ^ key[i%4]
+		}
+	}
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// ReadMessage reads the next data message, answering pings on the way, fragmented messages are not supported
+func (c *WSConn) ReadMessage() (int, []byte, error) {
+	for {
+		op, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, data); err != nil {
+				return 0, nil, err
+			}
+		case PongMessage:
+		case CloseMessage:
+			c.WriteMessage(CloseMessage, data)
+			return 0, nil, io.EOF
+		default:
+			return op, data, nil
+		}
+	}
+}
+
+func (c *WSConn) readFrame() (int, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	if h[0]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("fragmented frames are not supported")
+	}
+	op := int(h[0] & 0x0f)
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds %d", length, maxFrameSize)
+	}
+	var key [4]byte
+	masked := h[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(c.r, key[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= key[i%4]
+		}
+	}
+	return op, data, nil
+}
+
+// Close closes the underlying connection without the closing handshake
+func (c *WSConn) Close() error {
+	return c.conn.Close()
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
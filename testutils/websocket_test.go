@@ -0,0 +1,69 @@
+package testutils
+
+import (
+	"bytes"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+type WebSocketSuite struct{}
+
+var _ = Suite(&WebSocketSuite{})
+
+func (s *WebSocketSuite) TestEcho(c *C) {
+	srv := NewWebSocketEcho()
+	defer srv.Close()
+
+	conn, err := DialWebSocket(srv.URL, nil)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	c.Assert(conn.Response.StatusCode, Equals, http.StatusSwitchingProtocols)
+
+	c.Assert(conn.WriteMessage(TextMessage, []byte("hello")), IsNil)
+	op, data, err := conn.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Assert(op, Equals, TextMessage)
+	c.Assert(string(data), Equals, "hello")
+
+	// extended payload lengths
+	for _, size := range []int{200, 70000} {
+		payload := bytes.Repeat([]byte{'x'}, size)
+		c.Assert(conn.WriteMessage(BinaryMessage, payload), IsNil)
+		op, data, err = conn.ReadMessage()
+		c.Assert(err, IsNil)
+		c.Assert(op, Equals, BinaryMessage)
+		c.Assert(data, DeepEquals, payload)
+	}
+}
+
+func (s *WebSocketSuite) TestPing(c *C) {
+	srv := NewWebSocketServer(func(conn *WSConn) {
+		conn.WriteMessage(PingMessage, []byte("ping"))
+		conn.WriteMessage(TextMessage, []byte("after ping"))
+		conn.ReadMessage()
+	})
+	defer srv.Close()
+
+	conn, err := DialWebSocket(srv.URL, nil)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "after ping")
+}
+
+func (s *WebSocketSuite) TestNotUpgrade(c *C) {
+	srv := NewWebSocketEcho()
+	defer srv.Close()
+
+	re, _, err := Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadRequest)
+
+	plain := NewResponder("ok")
+	defer plain.Close()
+	_, err = DialWebSocket(plain.URL, nil)
+	c.Assert(err, NotNil)
+}
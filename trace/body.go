@@ -0,0 +1,181 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// BodyCapture sets which requests get the first bytes of their bodies into the records, for debugging
+// the malformed payloads. The bodies are recorded as is unless redacted, so the records with the bodies
+// should be exposed to the operators only.
+type BodyCapture struct {
+	// RequestBytes and ResponseBytes cap the bytes of the bodies captured, 0 captures none of the body
+	RequestBytes  int
+	ResponseBytes int
+	// Fraction of the requests sampled, from 0 to 1
+	Fraction float64
+	// Errors captures the bodies of the requests answered with 4xx and 5xx status codes on top of the sampled ones
+	Errors bool
+	// ContentTypes are the media types of the bodies captured, e.g. application/json or text/*, all of them if empty
+	ContentTypes []string
+	// Redact replaces the captured body before it is recorded, e.g. masking the credentials
+	Redact func(contentType string, body []byte) []byte
+}
+
+// CaptureBodies records the first bytes of the bodies of the sampled or errored requests
+func CaptureBodies(b BodyCapture) Option {
+	return func(t *Tracer) error {
+		if b.RequestBytes < 0 || b.ResponseBytes < 0 {
+			return fmt.Errorf("captured body bytes should be >= 0, got %d, %d", b.RequestBytes, b.ResponseBytes)
+		}
+		if b.Fraction < 0 || b.Fraction > 1 {
+			return fmt.Errorf("captured fraction should be in [0, 1], got %v", b.Fraction)
+		}
+		if b.Fraction == 0 && !b.Errors {
+			return fmt.Errorf("either the fraction or the errors should be captured")
+		}
+		t.bodies = &b
+		return nil
+	}
+}
+
+// bodyCapture is the capture of the bodies of the request, the nil capture records nothing
+type bodyCapture struct {
+	b       *BodyCapture
+	sampled bool
+	req     *bodyBuffer
+	resp    *bodyBuffer
+}
+
+// startCapture decides whether the bodies of the request may be recorded and starts capturing them
+func (t *Tracer) startCapture(req *http.Request, w http.ResponseWriter) (*bodyCapture, http.ResponseWriter) {
+	if t.bodies == nil {
+		return nil, w
+	}
+	c := &bodyCapture{b: t.bodies, sampled: rand.Float64() < t.bodies.Fraction}
+	if !c.sampled && !t.bodies.Errors {
+		return nil, w
+	}
+	if c.b.RequestBytes > 0 && req.Body != nil && req.Body != http.NoBody && c.allowed(req.Header.Get("Content-Type")) {
+		c.req = &bodyBuffer{limit: c.b.RequestBytes}
+		req.Body = &captureReader{ReadCloser: req.Body, buf: c.req}
+	}
+	if c.b.ResponseBytes > 0 {
+		c.resp = &bodyBuffer{limit: c.b.ResponseBytes}
+		w = &captureWriter{ResponseWriter: w, buf: c.resp}
+	}
+	return c, w
+}
+
+// record adds the captured bodies to the record of the sampled or errored request
+func (c *bodyCapture) record(r *Record, req *http.Request, respHeader http.Header) {
+	if c == nil || (!c.sampled && r.Response.Code < http.StatusBadRequest) {
+		return
+	}
+	if c.req != nil {
+		r.Request.Body, r.Request.BodyTruncated = c.body(req.Header.Get("Content-Type"), c.req)
+	}
+	if ct := respHeader.Get("Content-Type"); c.resp != nil && c.allowed(ct) {
+		r.Response.Body, r.Response.BodyTruncated = c.body(ct, c.resp)
+	}
+}
+
+func (c *bodyCapture) body(contentType string, b *bodyBuffer) (string, bool) {
+	body, truncated := b.captured()
+	if c.b.Redact != nil {
+		body = c.b.Redact(contentType, body)
+	}
+	return string(body), truncated
+}
+
+// allowed tells whether the bodies of the content type are captured
+func (c *bodyCapture) allowed(contentType string) bool {
+	if len(c.b.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.b.ContentTypes {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+		if prefix := strings.TrimSuffix(t, "*"); prefix != t && strings.HasPrefix(mediaType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyBuffer keeps the first bytes of the body, the round trip may read the request body concurrently
+type bodyBuffer struct {
+	mutex     sync.Mutex
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (b *bodyBuffer) add(p []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	left := b.limit - len(b.buf)
+	if len(p) > left {
+		p = p[:left]
+		b.truncated = true
+	}
+	b.buf = append(b.buf, p...)
+}
+
+func (b *bodyBuffer) captured() ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte(nil), b.buf...), b.truncated
+}
+
+type captureReader struct {
+	io.ReadCloser
+	buf *bodyBuffer
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.buf.add(p[:n])
+	return n, err
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	buf *bodyBuffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.buf.add(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack passes the hijacking to the wrapped writer, the upgraded connections are not captured
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("%T is not a http.Hijacker", w.ResponseWriter)
+}
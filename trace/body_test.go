@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type BodySuite struct{}
+
+var _ = Suite(&BodySuite{})
+
+// echo answers with the request body and the code of the query
+func echo(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	w.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+	if req.URL.Query().Get("fail") != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write(body)
+}
+
+func traceBody(c *C, b BodyCapture, path, contentType, body string) *Record {
+	out := &bytes.Buffer{}
+	t, err := New(http.HandlerFunc(echo), out, CaptureBodies(b))
+	c.Assert(err, IsNil)
+
+	req := httptest.NewRequest("POST", "http://localhost"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	t.ServeHTTP(w, req)
+	c.Assert(w.Body.String(), Equals, body)
+
+	var r *Record
+	c.Assert(json.Unmarshal(out.Bytes(), &r), IsNil)
+	return r
+}
+
+func (s *BodySuite) TestSampled(c *C) {
+	r := traceBody(c, BodyCapture{RequestBytes: 5, ResponseBytes: 100, Fraction: 1}, "/", "application/json", `{"a": 1}`)
+	c.Assert(r.Request.Body, Equals, `{"a":`)
+	c.Assert(r.Request.BodyTruncated, Equals, true)
+	c.Assert(r.Response.Body, Equals, `{"a": 1}`)
+	c.Assert(r.Response.BodyTruncated, Equals, false)
+}
+
+func (s *BodySuite) TestErrors(c *C) {
+	b := BodyCapture{RequestBytes: 100, ResponseBytes: 100, Errors: true}
+
+	r := traceBody(c, b, "/", "application/json", `{"a": 1}`)
+	c.Assert(r.Request.Body, Equals, "")
+	c.Assert(r.Response.Body, Equals, "")
+
+	r = traceBody(c, b, "/?fail=1", "application/json", `{"a": 1`)
+	c.Assert(r.Response.Code, Equals, http.StatusBadRequest)
+	c.Assert(r.Request.Body, Equals, `{"a": 1`)
+	c.Assert(r.Response.Body, Equals, `{"a": 1`)
+}
+
+func (s *BodySuite) TestContentTypes(c *C) {
+	b := BodyCapture{RequestBytes: 100, ResponseBytes: 100, Fraction: 1, ContentTypes: []string{"application/json", "text/*"}}
+
+	r := traceBody(c, b, "/", "application/json; charset=utf-8", "{}")
+	c.Assert(r.Request.Body, Equals, "{}")
+	c.Assert(r.Response.Body, Equals, "{}")
+
+	r = traceBody(c, b, "/", "text/plain", "hello")
+	c.Assert(r.Request.Body, Equals, "hello")
+
+	r = traceBody(c, b, "/", "application/octet-stream", "\x00\x01")
+	c.Assert(r.Request.Body, Equals, "")
+	c.Assert(r.Response.Body, Equals, "")
+}
+
+func (s *BodySuite) TestRedact(c *C) {
+	b := BodyCapture{RequestBytes: 100, Fraction: 1, Redact: func(contentType string, body []byte) []byte {
+		return bytes.ReplaceAll(body, []byte("secret"), []byte("******"))
+	}}
+	r := traceBody(c, b, "/", "text/plain", "password=secret")
+	c.Assert(r.Request.Body, Equals, "password=******")
+	// the response body is not captured
+	c.Assert(r.Response.Body, Equals, "")
+}
+
+func (s *BodySuite) TestConcurrentRead(c *C) {
+	done := make(chan struct{})
+	// the body is still read once the handler returns, e.g. by the transport of the forwarder
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		go func() {
+			io.ReadAll(req.Body)
+			close(done)
+		}()
+	})
+	out := &bytes.Buffer{}
+	t, err := New(handler, out, CaptureBodies(BodyCapture{RequestBytes: 100, Fraction: 1}))
+	c.Assert(err, IsNil)
+
+	req := httptest.NewRequest("POST", "http://localhost", strings.NewReader(strings.Repeat("a", 50)))
+	t.ServeHTTP(httptest.NewRecorder(), req)
+	<-done
+
+	var r *Record
+	c.Assert(json.Unmarshal(out.Bytes(), &r), IsNil)
+	c.Assert(len(r.Request.Body) <= 50, Equals, true)
+}
+
+func (s *BodySuite) TestOptionErrors(c *C) {
+	for _, b := range []BodyCapture{
+		{RequestBytes: -1, Fraction: 1},
+		{RequestBytes: 1, Fraction: 2},
+		{RequestBytes: 1},
+	} {
+		_, err := New(http.HandlerFunc(echo), &bytes.Buffer{}, CaptureBodies(b))
+		c.Assert(err, NotNil)
+	}
+}
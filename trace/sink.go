@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Sink receives the trace records, e.g. to write them to a file or ship them to a log collector
+type Sink interface {
+	// Write emits the record
+	Write(r *Record) error
+	// Close flushes and releases the resources of the sink
+	Close() error
+}
+
+// Output sets the sink receiving the records instead of the writer passed to New
+func Output(s Sink) Option {
+	return func(t *Tracer) error {
+		if s == nil {
+			return fmt.Errorf("sink can not be nil")
+		}
+		t.sink = s
+		return nil
+	}
+}
+
+// WriterSink emits the records as JSON lines to the writer
+func WriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func (s *writerSink) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// FileSink writes the records as JSON lines to the file at path, rotating it once it exceeds
+// maxBytes and keeping up to maxBackups rotated files named path.1 (the newest) to path.N
+func FileSink(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("max bytes should be positive, got %d", maxBytes)
+	}
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("max backups can not be negative, got %d", maxBackups)
+	}
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// RotatingFile is a sink writing to the file rotated by size
+type RotatingFile struct {
+	mtx        sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func (f *RotatingFile) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.file == nil {
+		return fmt.Errorf("sink is closed")
+	}
+	if f.size > 0 && f.size+int64(len(data)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return f.open()
+	}
+	for i := f.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(backupName(f.path, i), backupName(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func backupName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// OverflowPolicy tells what the buffered sink does with the records when the buffer is full
+type OverflowPolicy int
+
+const (
+	// Drop discards the records that do not fit in the buffer, the request path is never blocked
+	Drop OverflowPolicy = iota
+	// Block waits for the room in the buffer, applying the backpressure of the sink to the requests
+	Block
+)
+
+// Buffered returns the sink writing the records to the next sink in the background,
+// queueing up to size records and handling the overflow according to the policy
+func Buffered(next Sink, size int, policy OverflowPolicy) (*BufferedSink, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("buffer size should be positive, got %d", size)
+	}
+	if policy != Drop && policy != Block {
+		return nil, fmt.Errorf("unknown overflow policy: %d", policy)
+	}
+	b := &BufferedSink{
+		next:    next,
+		policy:  policy,
+		records: make(chan *Record, size),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+// BufferedSink decouples the request path from the slow sinks, see Buffered
+type BufferedSink struct {
+	next    Sink
+	policy  OverflowPolicy
+	records chan *Record
+	done    chan struct{}
+
+	mtx    sync.RWMutex
+	closed bool
+
+	dropped int64
+
+	errMtx  sync.Mutex
+	failed  int64
+	lastErr error
+}
+
+func (b *BufferedSink) Write(r *Record) error {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	if b.closed {
+		return fmt.Errorf("sink is closed")
+	}
+	if b.policy == Block {
+		b.records <- r
+		return nil
+	}
+	select {
+	case b.records <- r:
+	default:
+		atomic.AddInt64(&b.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped returns the amount of records discarded due to the full buffer
+func (b *BufferedSink) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// Failed returns the amount of records the next sink has failed to write and the last error
+func (b *BufferedSink) Failed() (int64, error) {
+	b.errMtx.Lock()
+	defer b.errMtx.Unlock()
+	return b.failed, b.lastErr
+}
+
+// Close writes the queued records and closes the next sink
+func (b *BufferedSink) Close() error {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.records)
+	b.mtx.Unlock()
+
+	<-b.done
+	return b.next.Close()
+}
+
+func (b *BufferedSink) run() {
+	defer close(b.done)
+	for r := range b.records {
+		if err := b.next.Write(r); err != nil {
+			b.errMtx.Lock()
+			b.failed++
+			b.lastErr = err
+			b.errMtx.Unlock()
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+
+package trace
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink sends the records as JSON messages with the info severity to the syslog daemon,
+// the local one when the network and address are empty
+func SyslogSink(network, raddr string, facility syslog.Priority, tag string) (Sink, error) {
+	w, err := syslog.Dial(network, raddr, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *syslogSink) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(data))
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
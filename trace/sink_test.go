@@ -0,0 +1,194 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type SinkSuite struct{}
+
+var _ = Suite(&SinkSuite{})
+
+// memSink collects the records, blocking the writes while the gate is held
+type memSink struct {
+	mtx     sync.Mutex
+	gate    sync.Mutex
+	records []*Record
+	closed  bool
+	err     error
+}
+
+func (m *memSink) Write(r *Record) error {
+	m.gate.Lock()
+	defer m.gate.Unlock()
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.records = append(m.records, r)
+	return nil
+}
+
+func (m *memSink) Close() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memSink) len() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.records)
+}
+
+func record(url string) *Record {
+	return &Record{Request: Request{Method: "GET", URL: url}}
+}
+
+func (s *SinkSuite) TestOutput(c *C) {
+	sink := &memSink{}
+	t, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}), nil, Output(sink))
+	c.Assert(err, IsNil)
+
+	t.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/hello", nil))
+	c.Assert(sink.records, HasLen, 1)
+	c.Assert(sink.records[0].Request.URL, Equals, "http://localhost/hello")
+	c.Assert(sink.records[0].Response.Code, Equals, http.StatusCreated)
+
+	_, err = New(nil, nil)
+	c.Assert(err, NotNil)
+	_, err = New(nil, nil, Output(nil))
+	c.Assert(err, NotNil)
+}
+
+func (s *SinkSuite) TestWriterSink(c *C) {
+	buf := &bytes.Buffer{}
+	sink := WriterSink(buf)
+	c.Assert(sink.Write(record("/a")), IsNil)
+	c.Assert(sink.Write(record("/b")), IsNil)
+
+	scanner := bufio.NewScanner(buf)
+	var urls []string
+	for scanner.Scan() {
+		var r Record
+		c.Assert(json.Unmarshal(scanner.Bytes(), &r), IsNil)
+		urls = append(urls, r.Request.URL)
+	}
+	c.Assert(urls, DeepEquals, []string{"/a", "/b"})
+	c.Assert(sink.Close(), IsNil)
+}
+
+func (s *SinkSuite) TestFileSinkRotates(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "trace.log")
+
+	line, err := json.Marshal(record("/0"))
+	c.Assert(err, IsNil)
+	size := int64(len(line) + 1)
+
+	// two records fit in the file
+	f, err := FileSink(path, 2*size, 2)
+	c.Assert(err, IsNil)
+	for i := 0; i < 7; i++ {
+		c.Assert(f.Write(record(fmt.Sprintf("/%d", i))), IsNil)
+	}
+	c.Assert(f.Close(), IsNil)
+	c.Assert(f.Write(record("/closed")), NotNil)
+
+	c.Assert(readURLs(c, path), DeepEquals, []string{"/6"})
+	c.Assert(readURLs(c, path+".1"), DeepEquals, []string{"/4", "/5"})
+	c.Assert(readURLs(c, path+".2"), DeepEquals, []string{"/2", "/3"})
+	_, err = os.Stat(path + ".3")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// the existing file is appended to
+	f, err = FileSink(path, 2*size, 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Write(record("/7")), IsNil)
+	c.Assert(f.Write(record("/8")), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(readURLs(c, path), DeepEquals, []string{"/8"})
+	c.Assert(readURLs(c, path+".1"), DeepEquals, []string{"/4", "/5"})
+
+	_, err = FileSink(path, 0, 1)
+	c.Assert(err, NotNil)
+	_, err = FileSink(path, 1, -1)
+	c.Assert(err, NotNil)
+}
+
+func readURLs(c *C, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var r Record
+		c.Assert(json.Unmarshal(scanner.Bytes(), &r), IsNil)
+		urls = append(urls, r.Request.URL)
+	}
+	return urls
+}
+
+func (s *SinkSuite) TestBufferedDrops(c *C) {
+	next := &memSink{}
+	b, err := Buffered(next, 2, Drop)
+	c.Assert(err, IsNil)
+
+	// the sink is stuck, the first record is taken by the writer, two are queued
+	next.gate.Lock()
+	for i := 0; i < 10; i++ {
+		c.Assert(b.Write(record("/")), IsNil)
+	}
+	c.Assert(b.Dropped() >= 7, Equals, true)
+	next.gate.Unlock()
+
+	c.Assert(b.Close(), IsNil)
+	c.Assert(int64(next.len())+b.Dropped(), Equals, int64(10))
+	c.Assert(next.closed, Equals, true)
+	c.Assert(b.Write(record("/")), NotNil)
+	c.Assert(b.Close(), IsNil)
+}
+
+func (s *SinkSuite) TestBufferedBlocks(c *C) {
+	next := &memSink{}
+	b, err := Buffered(next, 1, Block)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 10; i++ {
+		c.Assert(b.Write(record("/")), IsNil)
+	}
+	c.Assert(b.Close(), IsNil)
+	c.Assert(next.len(), Equals, 10)
+	c.Assert(b.Dropped(), Equals, int64(0))
+}
+
+func (s *SinkSuite) TestBufferedFailures(c *C) {
+	next := &memSink{err: fmt.Errorf("broken")}
+	b, err := Buffered(next, 1, Block)
+	c.Assert(err, IsNil)
+	c.Assert(b.Write(record("/")), IsNil)
+	c.Assert(b.Close(), IsNil)
+
+	failed, err := b.Failed()
+	c.Assert(failed, Equals, int64(1))
+	c.Assert(err, ErrorMatches, "broken")
+
+	_, err = Buffered(next, 0, Drop)
+	c.Assert(err, NotNil)
+	_, err = Buffered(next, 1, OverflowPolicy(3))
+	c.Assert(err, NotNil)
+}
@@ -3,7 +3,6 @@ package trace
 
 import (
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +10,7 @@ import (
 	"time"
 
 	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
 )
 
 // Option is a functional option setter for Tracer
@@ -48,46 +48,68 @@ func Logger(l utils.Logger) Option {
 	}
 }
 
+// Clock sets the time provider used to measure round trip time, intended for tests
+func Clock(clock timetools.TimeProvider) Option {
+	return func(t *Tracer) error {
+		t.clock = clock
+		return nil
+	}
+}
+
 // Tracer records request and response emitting JSON structured data to the output
 type Tracer struct {
 	errHandler  utils.ErrorHandler
 	next        http.Handler
 	reqHeaders  []string
 	respHeaders []string
-	writer      io.Writer
+	sink        Sink
 	log         utils.Logger
+	clock       timetools.TimeProvider
+	// bodies is set by CaptureBodies
+	bodies *BodyCapture
 }
 
 // New creates a new Tracer middleware that emits all the request/response information in structured format
 // to writer and passes the request to the next handler. It can optionally capture request and response headers,
-// see RequestHeaders and ResponseHeaders options for details.
+// see RequestHeaders and ResponseHeaders options for details. The Output option replaces the writer with the sink.
 func New(next http.Handler, writer io.Writer, opts ...Option) (*Tracer, error) {
 	t := &Tracer{
-		writer: writer,
-		next:   next,
+		next: next,
 	}
 	for _, o := range opts {
 		if err := o(t); err != nil {
 			return nil, err
 		}
 	}
+	if t.sink == nil {
+		if writer == nil {
+			return nil, fmt.Errorf("either the writer or the sink should be set")
+		}
+		t.sink = WriterSink(writer)
+	}
 	if t.errHandler == nil {
 		t.errHandler = utils.DefaultHandler
 	}
 	if t.log == nil {
 		t.log = utils.NullLogger
 	}
+	if t.clock == nil {
+		t.clock = &timetools.RealTime{}
+	}
 	return t, nil
 }
 
 func (t *Tracer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	start := time.Now()
+	start := t.clock.UtcNow()
+	req, _ = utils.WithTimings(req)
+	bodies, w := t.startCapture(req, w)
 	pw := &utils.ProxyWriter{W: w}
 	t.next.ServeHTTP(pw, req)
 
-	l := t.newRecord(req, pw, time.Since(start))
-	if err := json.NewEncoder(t.writer).Encode(l); err != nil {
-		t.log.Errorf("Failed to marshal request: %v", err)
+	l := t.newRecord(req, pw, t.clock.UtcNow().Sub(start))
+	bodies.record(l, req, pw.Header())
+	if err := t.sink.Write(l); err != nil {
+		t.log.Errorf("Failed to write trace record: %v", err)
 	}
 }
 
@@ -99,16 +121,47 @@ func (t *Tracer) newRecord(req *http.Request, pw *utils.ProxyWriter, diff time.D
 			TLS:       newTLS(req),
 			BodyBytes: bodyBytes(req.Header),
 			Headers:   captureHeaders(req.Header, t.reqHeaders),
+			TraceID:   bagString(req, utils.BagTraceID),
+			SpanID:    bagString(req, utils.BagSpanID),
 		},
 		Response: Response{
 			Code:      pw.StatusCode(),
 			BodyBytes: bodyBytes(pw.Header()),
 			Roundtrip: float64(diff) / float64(time.Millisecond),
 			Headers:   captureHeaders(pw.Header(), t.respHeaders),
+			Timings:   newTimings(utils.TimingsFromRequest(req)),
 		},
 	}
 }
 
+func newTimings(t *utils.Timings) *Timings {
+	if t == nil {
+		return nil
+	}
+	b := t.Breakdown()
+	return &Timings{
+		Queue:        millis(b.Queue),
+		Balance:      millis(b.Balance),
+		UpstreamTTFB: millis(b.UpstreamTTFB),
+		Upstream:     millis(b.Upstream),
+		Write:        millis(b.Write),
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// bagString returns the string stored in the request bag, the bag is filled in down the chain
+func bagString(req *http.Request, key string) string {
+	if bag := utils.BagFromRequest(req); bag != nil {
+		v, _ := bag.Get(key)
+		s, _ := v.(string)
+		return s
+	}
+	return ""
+}
+
 func newTLS(req *http.Request) *TLS {
 	if req.TLS == nil {
 		return nil
@@ -146,11 +199,16 @@ type Record struct {
 
 // Req contains information about an HTTP request
 type Request struct {
-	Method    string      `json:"method"`            // Method - request method
-	BodyBytes int64       `json:"body_bytes"`        // BodyBytes - size of request body in bytes
-	URL       string      `json:"url"`               // URL - Request URL
-	Headers   http.Header `json:"headers,omitempty"` // Headers - optional request headers, will be recorded if configured
-	TLS       *TLS        `json:"tls,omitempty"`     // TLS - optional TLS record, will be recorded if it's a TLS connection
+	Method    string      `json:"method"`             // Method - request method
+	BodyBytes int64       `json:"body_bytes"`         // BodyBytes - size of request body in bytes
+	URL       string      `json:"url"`                // URL - Request URL
+	Headers   http.Header `json:"headers,omitempty"`  // Headers - optional request headers, will be recorded if configured
+	TLS       *TLS        `json:"tls,omitempty"`      // TLS - optional TLS record, will be recorded if it's a TLS connection
+	TraceID   string      `json:"trace_id,omitempty"` // TraceID - trace propagated to the backend, see forward.TraceRewriter
+	SpanID    string      `json:"span_id,omitempty"`  // SpanID - span of the proxy, see forward.TraceRewriter
+	// Body - first bytes of the request body read by the next handler, see CaptureBodies
+	Body          string `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"` // BodyTruncated - set if the body is longer than captured
 }
 
 // Resp contains information about HTTP response
@@ -159,6 +217,19 @@ type Response struct {
 	Roundtrip float64     `json:"roundtrip"`         // Roundtrip - round trip time in milliseconds
 	Headers   http.Header `json:"headers,omitempty"` // Headers - optional headers, will be recorded if configured
 	BodyBytes int64       `json:"body_bytes"`        // BodyBytes - size of response body in bytes
+	Timings   *Timings    `json:"timings,omitempty"` // Timings - breakdown of the round trip, see utils.Timings
+	// Body - first bytes of the response body, see CaptureBodies
+	Body          string `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"` // BodyTruncated - set if the body is longer than captured
+}
+
+// Timings is the breakdown of the round trip in milliseconds, see utils.Timings
+type Timings struct {
+	Queue        float64 `json:"queue"`         // Queue - time waited in the queues of the limiters
+	Balance      float64 `json:"balance"`       // Balance - time taken by the load balancer to select the backend
+	UpstreamTTFB float64 `json:"upstream_ttfb"` // UpstreamTTFB - time until the response headers of the backend
+	Upstream     float64 `json:"upstream"`      // Upstream - time the backend took to send the response
+	Write        float64 `json:"write"`         // Write - time spent writing the response to the client
 }
 
 // TLS contains information about this TLS connection
@@ -10,9 +10,11 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/mailgun/oxy/testutils"
 	"github.com/mailgun/oxy/utils"
+	"github.com/mailgun/timetools"
 
 	. "gopkg.in/check.v1"
 )
@@ -123,3 +125,69 @@ func (s *TraceSuite) TestTraceTLS(c *C) {
 	c.Assert(json.Unmarshal(trace.Bytes(), &r), IsNil)
 	c.Assert(r.Request.TLS.Version, Equals, versionToString(state.Version))
 }
+
+func (s *TraceSuite) TestTraceClock(c *C) {
+	clock := &timetools.FreezedTime{
+		CurrentTime: time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		clock.Sleep(1500 * time.Millisecond)
+		w.Write([]byte("hello"))
+	})
+
+	trace := &bytes.Buffer{}
+	t, err := New(handler, trace, Clock(clock))
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(t)
+	defer srv.Close()
+
+	re, _, err := testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	var r *Record
+	c.Assert(json.Unmarshal(trace.Bytes(), &r), IsNil)
+	c.Assert(r.Response.Roundtrip, Equals, float64(1500))
+}
+
+func (s *TraceSuite) TestTraceIDs(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// set down the chain, e.g. by forward.TraceRewriter
+		utils.SetBagValue(req, utils.BagTraceID, "4bf92f3577b34da6a3ce929d0e0e4736")
+		utils.SetBagValue(req, utils.BagSpanID, "00f067aa0ba902b7")
+		w.Write([]byte("hello"))
+	})
+	trace := &bytes.Buffer{}
+	t, err := New(handler, trace)
+	c.Assert(err, IsNil)
+
+	req := utils.WithBag(httptest.NewRequest("GET", "http://localhost", nil), utils.NewBag())
+	t.ServeHTTP(httptest.NewRecorder(), req)
+
+	var r *Record
+	c.Assert(json.Unmarshal(trace.Bytes(), &r), IsNil)
+	c.Assert(r.Request.TraceID, Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Assert(r.Request.SpanID, Equals, "00f067aa0ba902b7")
+}
+
+func (s *TraceSuite) TestTraceTimings(c *C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		utils.AddTiming(req, utils.TimingQueue, 2*time.Millisecond)
+		utils.AddTiming(req, utils.TimingUpstreamTTFB, 5*time.Millisecond)
+		w.Write([]byte("hello"))
+	})
+	trace := &bytes.Buffer{}
+	t, err := New(handler, trace)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(t)
+	defer srv.Close()
+
+	_, _, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+
+	var r *Record
+	c.Assert(json.Unmarshal(trace.Bytes(), &r), IsNil)
+	c.Assert(*r.Response.Timings, Equals, Timings{Queue: 2, UpstreamTTFB: 5})
+}
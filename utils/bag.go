@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Keys of the well known values stored in the Bag by oxy middlewares
+const (
+	// BagRequestID - string identifier of the request
+	BagRequestID = "request_id"
+	// BagBackend - *url.URL of the backend selected by the load balancer
+	BagBackend = "backend"
+	// BagTraceID - string ID of the trace propagated to the backend
+	BagTraceID = "trace_id"
+	// BagSpanID - string ID of the span of the proxy, the parent span of the backend
+	BagSpanID = "span_id"
+	// BagAttempt - int number of the attempt to serve the request, starting from 1
+	BagAttempt = "attempt"
+	// BagTimings - *Timings of the request, see WithTimings
+	BagTimings = "timings"
+)
+
+// Bag is a per-request storage shared by the middlewares serving the request. Middlewares
+// can use it to pass information down and up the chain, e.g. the load balancer records the selected backend
+// and the tracer reads it. It is safe for concurrent use.
+type Bag struct {
+	mtx    sync.RWMutex
+	values map[string]interface{}
+}
+
+// NewBag returns a new empty Bag
+func NewBag() *Bag {
+	return &Bag{values: make(map[string]interface{})}
+}
+
+// Set stores the value under the key, overwriting the previous value
+func (b *Bag) Set(key string, val interface{}) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.values[key] = val
+}
+
+// Get returns the value stored under the key
+func (b *Bag) Get(key string) (interface{}, bool) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	val, ok := b.values[key]
+	return val, ok
+}
+
+type bagKey struct{}
+
+// WithBag returns a shallow copy of the request carrying the bag
+func WithBag(req *http.Request, b *Bag) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), bagKey{}, b))
+}
+
+// BagFromRequest returns the bag attached to the request or nil if there's none
+func BagFromRequest(req *http.Request) *Bag {
+	b, _ := req.Context().Value(bagKey{}).(*Bag)
+	return b
+}
+
+// SetBagValue stores the value in the request's bag if the request carries one
+func SetBagValue(req *http.Request, key string, val interface{}) {
+	if b := BagFromRequest(req); b != nil {
+		b.Set(key, val)
+	}
+}
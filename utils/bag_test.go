@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+type BagSuite struct{}
+
+var _ = Suite(&BagSuite{})
+
+func (s *BagSuite) TestSetGet(c *C) {
+	b := NewBag()
+	_, ok := b.Get(BagAttempt)
+	c.Assert(ok, Equals, false)
+
+	b.Set(BagAttempt, 1)
+	b.Set(BagAttempt, 2)
+	val, ok := b.Get(BagAttempt)
+	c.Assert(ok, Equals, true)
+	c.Assert(val, Equals, 2)
+}
+
+func (s *BagSuite) TestRequest(c *C) {
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	c.Assert(err, IsNil)
+	c.Assert(BagFromRequest(req), IsNil)
+
+	// no bag, no op
+	SetBagValue(req, BagRequestID, "a")
+
+	b := NewBag()
+	out := WithBag(req, b)
+	c.Assert(BagFromRequest(out), Equals, b)
+
+	// copies of the request share the same bag
+	cp := *out
+	SetBagValue(&cp, BagRequestID, "b")
+	val, ok := b.Get(BagRequestID)
+	c.Assert(ok, Equals, true)
+	c.Assert(val, Equals, "b")
+}
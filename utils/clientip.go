@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses the list of CIDRs, plain IP addresses are treated as single address networks
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: '%v'", c)
+			}
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// ContainsIP tells whether any of the networks contains the IP
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client that sent the request. If the request came from one of the trusted
+// proxies, X-Forwarded-For header is walked from right to left skipping trusted proxies, the first untrusted
+// address is the client. X-Forwarded-For is ignored for requests coming from untrusted addresses, as it can be spoofed.
+func ClientIP(req *http.Request, trusted []*net.IPNet) (net.IP, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("failed to parse client IP: %v", req.RemoteAddr)
+	}
+	if !ContainsIP(trusted, ip) {
+		return ip, nil
+	}
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// malformed entry, the last address we could verify is the best guess
+			return ip, nil
+		}
+		ip = hop
+		if !ContainsIP(trusted, ip) {
+			return ip, nil
+		}
+	}
+	return ip, nil
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+type ClientIPSuite struct{}
+
+var _ = Suite(&ClientIPSuite{})
+
+func (s *ClientIPSuite) TestParseCIDRs(c *C) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "::1", "2001:db8::/32"})
+	c.Assert(err, IsNil)
+	c.Assert(len(nets), Equals, 4)
+	c.Assert(nets[1].String(), Equals, "192.168.1.1/32")
+	c.Assert(nets[2].String(), Equals, "::1/128")
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	c.Assert(err, NotNil)
+	_, err = ParseCIDRs([]string{"not-an-ip"})
+	c.Assert(err, NotNil)
+}
+
+func (s *ClientIPSuite) TestClientIP(c *C) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	c.Assert(err, IsNil)
+
+	testCases := []struct {
+		remoteAddr string
+		xff        string
+		expected   string
+	}{
+		// untrusted peer can't spoof the header
+		{remoteAddr: "1.2.3.4:5000", xff: "5.6.7.8", expected: "1.2.3.4"},
+		// trusted peer without the header
+		{remoteAddr: "10.0.0.1:5000", expected: "10.0.0.1"},
+		// the first untrusted address from the right is the client
+		{remoteAddr: "10.0.0.1:5000", xff: "9.9.9.9, 5.6.7.8, 10.0.0.2", expected: "5.6.7.8"},
+		// all hops are trusted
+		{remoteAddr: "10.0.0.1:5000", xff: "10.0.0.3, 10.0.0.2", expected: "10.0.0.3"},
+		// malformed hop
+		{remoteAddr: "10.0.0.1:5000", xff: "garbage, 10.0.0.2", expected: "10.0.0.2"},
+	}
+	for _, tc := range testCases {
+		req := &http.Request{RemoteAddr: tc.remoteAddr, Header: make(http.Header)}
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		ip, err := ClientIP(req, trusted)
+		c.Assert(err, IsNil)
+		c.Assert(ip.String(), Equals, tc.expected, Commentf("%v %v", tc.remoteAddr, tc.xff))
+	}
+
+	_, err = ClientIP(&http.Request{RemoteAddr: "bad"}, trusted)
+	c.Assert(err, NotNil)
+}
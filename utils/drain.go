@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrShuttingDown is reported for the requests arriving at the middleware after it has been closed
+var ErrShuttingDown = errors.New("shutting down")
+
+// Drainer tracks the requests in flight, so the middlewares can stop accepting new requests
+// on shutdown and wait for the ones being served. The zero value is ready to use.
+type Drainer struct {
+	mtx      sync.Mutex
+	closed   bool
+	inflight int64
+	// idle is closed once the drainer is closed and the last request has left
+	idle chan struct{}
+}
+
+// Enter registers the request, it returns false if the drainer is closed and the request has to be rejected
+func (d *Drainer) Enter() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.closed {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+// Leave has to be called once the request registered by Enter is served
+func (d *Drainer) Leave() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.inflight--
+	if d.closed && d.inflight == 0 {
+		close(d.idle)
+	}
+}
+
+// InFlight returns the number of requests being served
+func (d *Drainer) InFlight() int64 {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.inflight
+}
+
+// Closed tells whether the drainer rejects new requests
+func (d *Drainer) Closed() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.closed
+}
+
+// Close stops accepting new requests and waits for the requests in flight,
+// it returns the context error if the context is done before they are served
+func (d *Drainer) Close(ctx context.Context) error {
+	d.mtx.Lock()
+	if !d.closed {
+		d.closed = true
+		d.idle = make(chan struct{})
+		if d.inflight == 0 {
+			close(d.idle)
+		}
+	}
+	idle := d.idle
+	d.mtx.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type DrainSuite struct{}
+
+var _ = Suite(&DrainSuite{})
+
+func (s *DrainSuite) TestCloseIdle(c *C) {
+	var d Drainer
+	c.Assert(d.Close(context.Background()), IsNil)
+	c.Assert(d.Closed(), Equals, true)
+	c.Assert(d.Enter(), Equals, false)
+	// closing twice is fine
+	c.Assert(d.Close(context.Background()), IsNil)
+}
+
+func (s *DrainSuite) TestCloseWaits(c *C) {
+	var d Drainer
+	c.Assert(d.Enter(), Equals, true)
+	c.Assert(d.Enter(), Equals, true)
+	c.Assert(d.InFlight(), Equals, int64(2))
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- d.Close(context.Background())
+	}()
+
+	d.Leave()
+	select {
+	case <-closed:
+		c.Fatalf("closed with request in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Assert(d.Enter(), Equals, false)
+
+	d.Leave()
+	c.Assert(<-closed, IsNil)
+	c.Assert(d.InFlight(), Equals, int64(0))
+}
+
+func (s *DrainSuite) TestCloseTimeout(c *C) {
+	var d Drainer
+	c.Assert(d.Enter(), Equals, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(d.Close(ctx), Equals, context.DeadlineExceeded)
+
+	// the request can still leave after the timeout
+	d.Leave()
+	c.Assert(d.Close(context.Background()), IsNil)
+}
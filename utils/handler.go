@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
@@ -10,6 +12,17 @@ type ErrorHandler interface {
 	ServeHTTP(w http.ResponseWriter, req *http.Request, err error)
 }
 
+// StatusClientClosedRequest is the non-standard status code of the requests canceled by the client
+const StatusClientClosedRequest = 499
+
+// ErrInvalidResponse is wrapped by the errors reporting the backend responses rejected by the proxy,
+// they are served with 502 status code
+var ErrInvalidResponse = errors.New("invalid response")
+
+// ErrClientTimeout is wrapped by the errors reporting the clients too slow to send the requests,
+// they are served with 408 status code
+var ErrClientTimeout = errors.New("client timeout")
+
 var DefaultHandler ErrorHandler = &StdHandler{}
 
 type StdHandler struct {
@@ -17,7 +30,13 @@ type StdHandler struct {
 
 func (e *StdHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
 	statusCode := http.StatusInternalServerError
-	if e, ok := err.(net.Error); ok {
+	if errors.Is(err, context.Canceled) {
+		statusCode = StatusClientClosedRequest
+	} else if errors.Is(err, ErrInvalidResponse) {
+		statusCode = http.StatusBadGateway
+	} else if errors.Is(err, ErrClientTimeout) {
+		statusCode = http.StatusRequestTimeout
+	} else if e, ok := err.(net.Error); ok {
 		if e.Timeout() {
 			statusCode = http.StatusGatewayTimeout
 		} else {
@@ -25,9 +44,18 @@ func (e *StdHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err err
 		}
 	} else if err == io.EOF {
 		statusCode = http.StatusBadGateway
+	} else if err == ErrShuttingDown {
+		statusCode = http.StatusServiceUnavailable
 	}
 	w.WriteHeader(statusCode)
-	w.Write([]byte(http.StatusText(statusCode)))
+	w.Write([]byte(statusText(statusCode)))
+}
+
+func statusText(code int) string {
+	if code == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(code)
 }
 
 type ErrorHandlerFunc func(http.ResponseWriter, *http.Request, error)
@@ -2,8 +2,11 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -32,3 +35,28 @@ func (s *UtilsSuite) TestDefaultHandlerErrors(c *C) {
 
 	c.Assert(w.Code, Equals, http.StatusBadGateway)
 }
+
+func (s *UtilsSuite) TestDefaultHandlerShuttingDown(c *C) {
+	w := NewBufferWriter(NopWriteCloser(&bytes.Buffer{}))
+	DefaultHandler.ServeHTTP(w, nil, ErrShuttingDown)
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *UtilsSuite) TestDefaultHandlerCanceled(c *C) {
+	w := httptest.NewRecorder()
+	DefaultHandler.ServeHTTP(w, nil, &url.Error{Op: "Get", URL: "http://localhost", Err: context.Canceled})
+	c.Assert(w.Code, Equals, StatusClientClosedRequest)
+	c.Assert(w.Body.String(), Equals, "Client Closed Request")
+}
+
+func (s *UtilsSuite) TestDefaultHandlerInvalidResponse(c *C) {
+	w := httptest.NewRecorder()
+	DefaultHandler.ServeHTTP(w, nil, fmt.Errorf("too many headers: %w", ErrInvalidResponse))
+	c.Assert(w.Code, Equals, http.StatusBadGateway)
+}
+
+func (s *UtilsSuite) TestDefaultHandlerClientTimeout(c *C) {
+	w := httptest.NewRecorder()
+	DefaultHandler.ServeHTTP(w, nil, fmt.Errorf("slow body: %w", ErrClientTimeout))
+	c.Assert(w.Code, Equals, http.StatusRequestTimeout)
+}
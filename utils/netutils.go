@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"bufio"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 // ProxyWriter helps to capture response headers and status code
@@ -12,6 +16,8 @@ import (
 type ProxyWriter struct {
 	W    http.ResponseWriter
 	Code int
+	// length is the number of the body bytes written
+	length int64
 }
 
 func (p *ProxyWriter) StatusCode() int {
@@ -28,7 +34,28 @@ func (p *ProxyWriter) Header() http.Header {
 }
 
 func (p *ProxyWriter) Write(buf []byte) (int, error) {
-	return p.W.Write(buf)
+	n, err := p.W.Write(buf)
+	p.length += int64(n)
+	return n, err
+}
+
+// ReadFrom passes the copy to the wrapped writer if it implements io.ReaderFrom, so the net/http server
+// sends the file bodies with sendfile, counting the bytes written
+func (p *ProxyWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := p.W.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{p.W}, r)
+	}
+	p.length += n
+	return n, err
+}
+
+// Length returns the number of the body bytes written
+func (p *ProxyWriter) Length() int64 {
+	return p.length
 }
 
 func (p *ProxyWriter) WriteHeader(code int) {
@@ -42,6 +69,19 @@ func (p *ProxyWriter) Flush() {
 	}
 }
 
+// Unwrap returns the wrapped writer, so http.ResponseController reaches the connection through the middlewares
+func (p *ProxyWriter) Unwrap() http.ResponseWriter {
+	return p.W
+}
+
+// Hijack passes the hijacking to the wrapped writer, so the upgraded connections pass through the middlewares
+func (p *ProxyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := p.W.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("%T is not a http.Hijacker", p.W)
+}
+
 func NewBufferWriter(w io.WriteCloser) *BufferWriter {
 	return &BufferWriter{
 		W: w,
@@ -96,11 +136,67 @@ func CopyURL(i *url.URL) *url.URL {
 // CopyHeaders copies http headers from source to destination, it
 // does not overide, but adds multiple headers
 func CopyHeaders(dst, src http.Header) {
+	CopyHeadersFiltered(dst, src, nil)
+}
+
+// HeaderFilter is the set of the canonical header names skipped by CopyHeadersFiltered
+type HeaderFilter map[string]struct{}
+
+// NewHeaderFilter returns the filter skipping the headers with the given names
+func NewHeaderFilter(names ...string) HeaderFilter {
+	f := make(HeaderFilter, len(names))
+	for _, n := range names {
+		f[http.CanonicalHeaderKey(n)] = struct{}{}
+	}
+	return f
+}
+
+// With returns the copy of the filter skipping the given names as well
+func (f HeaderFilter) With(names ...string) HeaderFilter {
+	out := make(HeaderFilter, len(f)+len(names))
+	for n := range f {
+		out[n] = struct{}{}
+	}
+	for _, n := range names {
+		out[http.CanonicalHeaderKey(n)] = struct{}{}
+	}
+	return out
+}
+
+// Skips tells whether the header with the canonical name is skipped
+func (f HeaderFilter) Skips(name string) bool {
+	_, ok := f[name]
+	return ok
+}
+
+// CopyHeadersFiltered adds the http headers from source to destination except the skipped ones,
+// the order of the values is preserved. The values of all the headers are copied into the single
+// slice allocated upfront, so the headers already canonical cost no per value allocations.
+func CopyHeadersFiltered(dst, src http.Header, skip HeaderFilter) {
+	n := 0
 	for k, vv := range src {
-		for _, v := range vv {
-			dst.Add(k, v)
+		if !skip.Skips(http.CanonicalHeaderKey(k)) {
+			n += len(vv)
 		}
 	}
+	if n == 0 {
+		return
+	}
+	values := make([]string, n)
+	for k, vv := range src {
+		k = http.CanonicalHeaderKey(k)
+		if len(vv) == 0 || skip.Skips(k) {
+			continue
+		}
+		n = copy(values, vv)
+		if prior, ok := dst[k]; ok {
+			dst[k] = append(prior, values[:n]...)
+		} else {
+			// the capacity is cut, so appending to one header never overwrites the next one
+			dst[k] = values[:n:n]
+		}
+		values = values[n:]
+	}
 }
 
 // HasHeaders determines whether any of the header names is present in the http headers
@@ -119,3 +215,34 @@ func RemoveHeaders(headers http.Header, names ...string) {
 		headers.Del(h)
 	}
 }
+
+// ValidHeaderName tells whether the header name is a non-empty token as defined by RFC 7230
+func ValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidHeaderValue tells whether the header value is free of the control characters other than
+// horizontal tab, in particular of CR and LF used to inject the headers
+func ValidHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if b := value[i]; (b < ' ' && b != '\t') || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(b byte) bool {
+	if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", b) != -1
+}
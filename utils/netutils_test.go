@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -49,6 +52,61 @@ func (s *NetUtilsSuite) TestCopyHeaders(c *C) {
 	c.Assert(destination.Get("a"), Equals, "b")
 }
 
+func (s *NetUtilsSuite) TestCopyHeadersFiltered(c *C) {
+	source, destination := make(http.Header), make(http.Header)
+	source.Add("a", "b")
+	source.Add("a", "c")
+	source.Add("Connection", "close")
+	source["x-lower"] = []string{"l"}
+	source.Add("Secret", "s")
+	destination.Add("a", "z")
+
+	CopyHeadersFiltered(destination, source, NewHeaderFilter("connection").With("secret"))
+
+	c.Assert(destination["A"], DeepEquals, []string{"z", "b", "c"})
+	c.Assert(destination.Get("X-Lower"), Equals, "l")
+	c.Assert(destination.Get("Connection"), Equals, "")
+	c.Assert(destination.Get("Secret"), Equals, "")
+
+	// appending to the copied values does not overwrite the other headers sharing the slice
+	copied := make(http.Header)
+	CopyHeadersFiltered(copied, source, nil)
+	copied.Add("A", "d")
+	c.Assert(copied["A"], DeepEquals, []string{"b", "c", "d"})
+	c.Assert(copied.Get("Connection"), Equals, "close")
+	c.Assert(copied.Get("Secret"), Equals, "s")
+	c.Assert(source["A"], DeepEquals, []string{"b", "c"})
+}
+
+// readerFromWriter is the response writer copying with ReadFrom
+type readerFromWriter struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (w *readerFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func (s *NetUtilsSuite) TestProxyWriterReadFrom(c *C) {
+	w := &readerFromWriter{ResponseRecorder: httptest.NewRecorder()}
+	pw := &ProxyWriter{W: w}
+	n, err := pw.ReadFrom(strings.NewReader("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(5))
+	c.Assert(w.readFrom, Equals, true)
+	c.Assert(pw.Length(), Equals, int64(5))
+
+	rec := httptest.NewRecorder()
+	pw = &ProxyWriter{W: rec}
+	n, err = pw.ReadFrom(strings.NewReader("hi"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(2))
+	c.Assert(rec.Body.String(), Equals, "hi")
+	c.Assert(pw.Length(), Equals, int64(2))
+}
+
 func (s *NetUtilsSuite) TestHasHeaders(c *C) {
 	source := make(http.Header)
 	source.Add("a", "b")
@@ -66,3 +124,45 @@ func (s *NetUtilsSuite) TestRemoveHeaders(c *C) {
 	c.Assert(source.Get("a"), Equals, "")
 	c.Assert(source.Get("c"), Equals, "d")
 }
+
+func (s *NetUtilsSuite) TestValidHeaders(c *C) {
+	c.Assert(ValidHeaderName("X-Request-Id"), Equals, true)
+	c.Assert(ValidHeaderName("x_custom.header~1"), Equals, true)
+	c.Assert(ValidHeaderName(""), Equals, false)
+	c.Assert(ValidHeaderName("X Header"), Equals, false)
+	c.Assert(ValidHeaderName("X-Header:"), Equals, false)
+	c.Assert(ValidHeaderName("X-Héader"), Equals, false)
+
+	c.Assert(ValidHeaderValue("text/html; charset=utf-8"), Equals, true)
+	c.Assert(ValidHeaderValue("a\tb"), Equals, true)
+	c.Assert(ValidHeaderValue(""), Equals, true)
+	c.Assert(ValidHeaderValue("a\r\nSet-Cookie: x"), Equals, false)
+	c.Assert(ValidHeaderValue("a\x00b"), Equals, false)
+	c.Assert(ValidHeaderValue("a\x7fb"), Equals, false)
+}
+
+func benchmarkHeaders() http.Header {
+	h := make(http.Header)
+	for _, name := range []string{"Accept", "Accept-Encoding", "Accept-Language", "Cookie", "User-Agent", "Connection", "Keep-Alive", "X-Request-Id"} {
+		h.Add(name, "value")
+	}
+	h.Add("Accept", "text/html")
+	return h
+}
+
+func BenchmarkCopyHeaders(b *testing.B) {
+	src := benchmarkHeaders()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CopyHeaders(make(http.Header, len(src)), src)
+	}
+}
+
+func BenchmarkCopyHeadersFiltered(b *testing.B) {
+	src := benchmarkHeaders()
+	skip := NewHeaderFilter("Connection", "Keep-Alive")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CopyHeadersFiltered(make(http.Header, len(src)), src, skip)
+	}
+}
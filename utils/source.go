@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
@@ -21,6 +24,8 @@ func (f ExtractorFunc) Extract(req *http.Request) (string, int64, error) {
 
 type ExtractSource func(req *http.Request)
 
+// NewExtractor returns the extractor of the variable: client.ip, request.host, request.header.<name>,
+// tls.server_name, tls.client.fingerprint (hex SHA-256 of the client certificate) or tls.client.cn
 func NewExtractor(variable string) (SourceExtractor, error) {
 	if variable == "client.ip" {
 		return ExtractorFunc(extractClientIP), nil
@@ -28,6 +33,15 @@ func NewExtractor(variable string) (SourceExtractor, error) {
 	if variable == "request.host" {
 		return ExtractorFunc(extractHost), nil
 	}
+	if variable == "tls.server_name" {
+		return ExtractorFunc(extractServerName), nil
+	}
+	if variable == "tls.client.fingerprint" {
+		return ExtractorFunc(extractClientFingerprint), nil
+	}
+	if variable == "tls.client.cn" {
+		return ExtractorFunc(extractClientCN), nil
+	}
 	if strings.HasPrefix(variable, "request.header.") {
 		header := strings.TrimPrefix(variable, "request.header.")
 		if len(header) == 0 {
@@ -50,6 +64,44 @@ func extractHost(req *http.Request) (string, int64, error) {
 	return req.Host, 1, nil
 }
 
+// extractServerName returns the server name (SNI) the client has sent in the TLS handshake
+func extractServerName(req *http.Request) (string, int64, error) {
+	if req.TLS == nil {
+		return "", 0, fmt.Errorf("Request is not served over TLS")
+	}
+	return req.TLS.ServerName, 1, nil
+}
+
+// extractClientFingerprint returns the hex SHA-256 fingerprint of the client certificate, e.g. for the mTLS
+// clients sharing the IP address behind NAT
+func extractClientFingerprint(req *http.Request) (string, int64, error) {
+	cert, err := clientCert(req)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), 1, nil
+}
+
+// extractClientCN returns the subject common name of the client certificate
+func extractClientCN(req *http.Request) (string, int64, error) {
+	cert, err := clientCert(req)
+	if err != nil {
+		return "", 0, err
+	}
+	if cert.Subject.CommonName == "" {
+		return "", 0, fmt.Errorf("Client certificate has no common name")
+	}
+	return cert.Subject.CommonName, 1, nil
+}
+
+func clientCert(req *http.Request) (*x509.Certificate, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("Request has no client certificate")
+	}
+	return req.TLS.PeerCertificates[0], nil
+}
+
 func makeHeaderExtractor(header string) SourceExtractor {
 	return ExtractorFunc(func(req *http.Request) (string, int64, error) {
 		return req.Header.Get(header), 1, nil
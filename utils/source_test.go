@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SourceSuite struct{}
+
+var _ = Suite(&SourceSuite{})
+
+func newClientCert(c *C, cn string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return cert
+}
+
+func (s *SourceSuite) TestClientCert(c *C) {
+	cert := newClientCert(c, "client-a")
+	req := httptest.NewRequest("GET", "https://localhost", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	e, err := NewExtractor("tls.client.cn")
+	c.Assert(err, IsNil)
+	token, amount, err := e.Extract(req)
+	c.Assert(err, IsNil)
+	c.Assert(token, Equals, "client-a")
+	c.Assert(amount, Equals, int64(1))
+
+	e, err = NewExtractor("tls.client.fingerprint")
+	c.Assert(err, IsNil)
+	token, _, err = e.Extract(req)
+	c.Assert(err, IsNil)
+	sum := sha256.Sum256(cert.Raw)
+	c.Assert(token, Equals, hex.EncodeToString(sum[:]))
+
+	// the requests without the client certificate can't be keyed
+	req.TLS = &tls.ConnectionState{}
+	_, _, err = e.Extract(req)
+	c.Assert(err, NotNil)
+	req.TLS = nil
+	_, _, err = e.Extract(req)
+	c.Assert(err, NotNil)
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{newClientCert(c, "")}}
+	e, err = NewExtractor("tls.client.cn")
+	c.Assert(err, IsNil)
+	_, _, err = e.Extract(req)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimingPhase is the part of the time spent serving the request
+type TimingPhase int
+
+const (
+	// TimingQueue is the time the request waited in the queues of the limiters, e.g. bulkhead
+	TimingQueue TimingPhase = iota
+	// TimingBalance is the time the load balancer took to select the backend
+	TimingBalance
+	// TimingUpstreamTTFB is the time until the response headers of the backend arrived
+	TimingUpstreamTTFB
+	// TimingUpstream is the time the backend took to send the whole response, without the time spent writing to the client
+	TimingUpstream
+	// TimingWrite is the time spent writing the response body to the client
+	TimingWrite
+
+	timingPhases
+)
+
+// Timings is the breakdown of the time spent serving the request, the middlewares down the chain add
+// their phases and the tracer or the observers read them. It is safe for concurrent use.
+type Timings struct {
+	mtx    sync.Mutex
+	phases [timingPhases]time.Duration
+}
+
+// TimingBreakdown is the snapshot of the Timings
+type TimingBreakdown struct {
+	Queue        time.Duration
+	Balance      time.Duration
+	UpstreamTTFB time.Duration
+	Upstream     time.Duration
+	Write        time.Duration
+}
+
+// Add adds d to the phase, e.g. for the retried round trips
+func (t *Timings) Add(phase TimingPhase, d time.Duration) {
+	if phase < 0 || phase >= timingPhases {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.phases[phase] += d
+}
+
+// Breakdown returns the time spent in the phases so far
+func (t *Timings) Breakdown() TimingBreakdown {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return TimingBreakdown{
+		Queue:        t.phases[TimingQueue],
+		Balance:      t.phases[TimingBalance],
+		UpstreamTTFB: t.phases[TimingUpstreamTTFB],
+		Upstream:     t.phases[TimingUpstream],
+		Write:        t.phases[TimingWrite],
+	}
+}
+
+// WithTimings attaches the timings to the bag of the request, the request is copied with the new bag
+// if it carries none. The timings already attached are kept.
+func WithTimings(req *http.Request) (*http.Request, *Timings) {
+	bag := BagFromRequest(req)
+	if bag == nil {
+		bag = NewBag()
+		req = WithBag(req, bag)
+	}
+	if t := timings(bag); t != nil {
+		return req, t
+	}
+	t := &Timings{}
+	bag.Set(BagTimings, t)
+	return req, t
+}
+
+// TimingsFromRequest returns the timings attached to the request or nil if there are none
+func TimingsFromRequest(req *http.Request) *Timings {
+	if bag := BagFromRequest(req); bag != nil {
+		return timings(bag)
+	}
+	return nil
+}
+
+// AddTiming adds d to the phase of the timings of the request if it carries them
+func AddTiming(req *http.Request, phase TimingPhase, d time.Duration) {
+	if t := TimingsFromRequest(req); t != nil {
+		t.Add(phase, d)
+	}
+}
+
+func timings(bag *Bag) *Timings {
+	v, _ := bag.Get(BagTimings)
+	t, _ := v.(*Timings)
+	return t
+}
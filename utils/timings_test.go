@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type TimingsSuite struct{}
+
+var _ = Suite(&TimingsSuite{})
+
+func (s *TimingsSuite) TestTimings(c *C) {
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	c.Assert(err, IsNil)
+
+	// no timings, no op
+	AddTiming(req, TimingQueue, time.Second)
+	c.Assert(TimingsFromRequest(req), IsNil)
+
+	req, t := WithTimings(req)
+	c.Assert(BagFromRequest(req), NotNil)
+	c.Assert(TimingsFromRequest(req), Equals, t)
+
+	AddTiming(req, TimingQueue, time.Second)
+	AddTiming(req, TimingUpstreamTTFB, time.Millisecond)
+	AddTiming(req, TimingUpstreamTTFB, time.Millisecond)
+	AddTiming(req, timingPhases, time.Millisecond)
+	c.Assert(t.Breakdown(), Equals, TimingBreakdown{Queue: time.Second, UpstreamTTFB: 2 * time.Millisecond})
+
+	// the attached timings are kept
+	again, same := WithTimings(req)
+	c.Assert(again, Equals, req)
+	c.Assert(same, Equals, t)
+}
@@ -0,0 +1,158 @@
+package validate
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// StrictMode tells how the ambiguous requests that could be used for request smuggling are handled
+type StrictMode int
+
+const (
+	// Reject rejects all the ambiguous requests with 400 status code
+	Reject StrictMode = iota + 1
+	// Normalize rewrites the ambiguous requests with a single safe interpretation: Content-Length is dropped
+	// in favor of Transfer-Encoding, repeated identical Content-Length values are merged, the headers
+	// with invalid characters are dropped and Host is lowercased without the trailing dot. The requests
+	// that can not be interpreted safely, e.g. with conflicting Content-Length values, are still rejected.
+	Normalize
+)
+
+// Strict validates the framing and the headers of the requests, rejecting or normalizing the ambiguous ones
+// according to the mode: requests with both Content-Length and Transfer-Encoding, multiple or malformed
+// Content-Length values, transfer codings other than chunked, header names or values with invalid characters,
+// including the CR and LF of the obsolete line folding, and abnormal Host values.
+// The checks run before the body size and contents are validated.
+func Strict(mode StrictMode) ValidatorOption {
+	return func(v *Validator) error {
+		if mode != Reject && mode != Normalize {
+			return fmt.Errorf("unknown strict mode: %d", mode)
+		}
+		v.strict = mode
+		return nil
+	}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &ValidationError{Code: http.StatusBadRequest, Reason: fmt.Sprintf(format, args...)}
+}
+
+// validateFraming checks the request with the strict mode set
+func (v *Validator) validateFraming(req *http.Request) error {
+	if err := v.validateLength(req); err != nil {
+		return err
+	}
+	if err := v.validateHeaders(req); err != nil {
+		return err
+	}
+	return v.validateHost(req)
+}
+
+func (v *Validator) validateLength(req *http.Request) error {
+	te := req.TransferEncoding
+	if vals := req.Header["Transfer-Encoding"]; len(vals) != 0 {
+		te = vals
+	}
+	for _, coding := range te {
+		if !strings.EqualFold(strings.TrimSpace(coding), "chunked") {
+			return badRequest("unsupported transfer coding '%v'", coding)
+		}
+	}
+	if len(te) > 1 {
+		return badRequest("chunked transfer coding is applied more than once")
+	}
+
+	lengths := req.Header["Content-Length"]
+	var distinct []string
+	for _, l := range lengths {
+		for _, part := range strings.Split(l, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" || strings.Trim(part, "0123456789") != "" {
+				return badRequest("malformed Content-Length '%v'", l)
+			}
+			if len(distinct) == 0 || distinct[0] != part {
+				distinct = append(distinct, part)
+			}
+		}
+	}
+	if len(distinct) > 1 {
+		return badRequest("conflicting Content-Length values %v", lengths)
+	}
+	if len(lengths) > 1 || (len(lengths) == 1 && lengths[0] != distinct[0]) {
+		if v.strict == Reject {
+			return badRequest("multiple Content-Length values %v", lengths)
+		}
+		req.Header.Set("Content-Length", distinct[0])
+	}
+	if len(te) != 0 && len(lengths) != 0 {
+		if v.strict == Reject {
+			return badRequest("both Content-Length and Transfer-Encoding are set")
+		}
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+	}
+	return nil
+}
+
+func (v *Validator) validateHeaders(req *http.Request) error {
+	for name, values := range req.Header {
+		valid := utils.ValidHeaderName(name)
+		for _, val := range values {
+			valid = valid && utils.ValidHeaderValue(val)
+		}
+		if valid {
+			continue
+		}
+		if v.strict == Reject {
+			return badRequest("header %q has invalid characters", name)
+		}
+		delete(req.Header, name)
+	}
+	return nil
+}
+
+func (v *Validator) validateHost(req *http.Request) error {
+	if req.Host == "" {
+		if req.ProtoAtLeast(1, 1) {
+			return badRequest("missing Host")
+		}
+		return nil
+	}
+	if !validHost(req.Host) {
+		return badRequest("invalid Host '%v'", req.Host)
+	}
+	if v.strict == Normalize {
+		req.Host = normalizeHost(req.Host)
+		return nil
+	}
+	if normalizeHost(req.Host) != req.Host {
+		return badRequest("non canonical Host '%v'", req.Host)
+	}
+	return nil
+}
+
+// validHost allows the registered names, IPv4 and bracketed IPv6 addresses with optional port
+func validHost(host string) bool {
+	for i := 0; i < len(host); i++ {
+		b := host[i]
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' {
+			continue
+		}
+		if strings.IndexByte("-._:[]", b) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	// the port follows the last colon unless it is a part of the bracketed IPv6 address
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		return strings.TrimSuffix(host[:i], ".") + host[i:]
+	}
+	return strings.TrimSuffix(host, ".")
+}
@@ -0,0 +1,138 @@
+package validate
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type StrictSuite struct{}
+
+var _ = Suite(&StrictSuite{})
+
+// serve passes the request through the validator and returns the status code and the request seen by the next handler
+func serve(c *C, mode StrictMode, req *http.Request) (int, *http.Request) {
+	var seen *http.Request
+	v, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = req
+	}), Strict(mode))
+	c.Assert(err, IsNil)
+	w := httptest.NewRecorder()
+	v.ServeHTTP(w, req)
+	return w.Code, seen
+}
+
+func newRequest(header http.Header) *http.Request {
+	req := httptest.NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return req
+}
+
+func (s *StrictSuite) TestReject(c *C) {
+	for _, h := range []http.Header{
+		{"Content-Length": {"5"}, "Transfer-Encoding": {"chunked"}},
+		{"Content-Length": {"5", "5"}},
+		{"Content-Length": {"5, 5"}},
+		{"Content-Length": {"5", "6"}},
+		{"Content-Length": {"-5"}},
+		{"Transfer-Encoding": {"gzip"}},
+		{"Transfer-Encoding": {"chunked", "chunked"}},
+		{"X-Folded": {"a\r\n b"}},
+		{"X Bad": {"a"}},
+	} {
+		code, _ := serve(c, Reject, newRequest(h))
+		c.Assert(code, Equals, http.StatusBadRequest, Commentf("%v", h))
+	}
+
+	code, _ := serve(c, Reject, newRequest(http.Header{"Content-Length": {"5"}, "X-Good": {"a\tb"}}))
+	c.Assert(code, Equals, http.StatusOK)
+}
+
+func (s *StrictSuite) TestNormalize(c *C) {
+	req := newRequest(http.Header{"Content-Length": {"5"}, "Transfer-Encoding": {"chunked"}})
+	code, seen := serve(c, Normalize, req)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(seen.Header.Get("Content-Length"), Equals, "")
+	c.Assert(seen.ContentLength, Equals, int64(-1))
+
+	code, seen = serve(c, Normalize, newRequest(http.Header{"Content-Length": {"5", "5, 5"}}))
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(seen.Header["Content-Length"], DeepEquals, []string{"5"})
+
+	code, seen = serve(c, Normalize, newRequest(http.Header{"X-Folded": {"a\r\n b"}, "X-Good": {"c"}}))
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(seen.Header["X-Folded"], IsNil)
+	c.Assert(seen.Header.Get("X-Good"), Equals, "c")
+
+	// the unfixable requests are still rejected
+	code, _ = serve(c, Normalize, newRequest(http.Header{"Content-Length": {"5", "6"}}))
+	c.Assert(code, Equals, http.StatusBadRequest)
+	code, _ = serve(c, Normalize, newRequest(http.Header{"Transfer-Encoding": {"identity"}}))
+	c.Assert(code, Equals, http.StatusBadRequest)
+}
+
+func (s *StrictSuite) TestHost(c *C) {
+	for _, t := range []struct {
+		host       string
+		reject     int
+		normalized string
+	}{
+		{"example.com", http.StatusOK, "example.com"},
+		{"example.com:8080", http.StatusOK, "example.com:8080"},
+		{"[2001:db8::1]:443", http.StatusOK, "[2001:db8::1]:443"},
+		{"[2001:db8::1]", http.StatusOK, "[2001:db8::1]"},
+		{"Example.COM.", http.StatusBadRequest, "example.com"},
+		{"example.com.:8080", http.StatusBadRequest, "example.com:8080"},
+		{"user@example.com", http.StatusBadRequest, ""},
+		{"example.com/evil", http.StatusBadRequest, ""},
+		{"exa mple.com", http.StatusBadRequest, ""},
+	} {
+		req := newRequest(nil)
+		req.Host = t.host
+		code, _ := serve(c, Reject, req)
+		c.Assert(code, Equals, t.reject, Commentf("%v", t.host))
+
+		req = newRequest(nil)
+		req.Host = t.host
+		code, seen := serve(c, Normalize, req)
+		if t.normalized == "" {
+			c.Assert(code, Equals, http.StatusBadRequest, Commentf("%v", t.host))
+		} else {
+			c.Assert(seen.Host, Equals, t.normalized)
+		}
+	}
+
+	req := newRequest(nil)
+	req.Host = ""
+	code, _ := serve(c, Reject, req)
+	c.Assert(code, Equals, http.StatusBadRequest)
+}
+
+func (s *StrictSuite) TestServer(c *C) {
+	v, err := New(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}), Strict(Reject))
+	c.Assert(err, IsNil)
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+
+	// the non canonical host is accepted by the server and rejected by the validator
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: Example.com.\r\n\r\n"))
+	re, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *StrictSuite) TestBadMode(c *C) {
+	_, err := New(nil, Strict(StrictMode(0)))
+	c.Assert(err, NotNil)
+}
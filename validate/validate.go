@@ -0,0 +1,194 @@
+// Package validate rejects malformed requests before they reach buffering or the backends.
+// It enforces the maximum body size (413), the allowed content types (415), JSON well-formedness (400)
+// and, with the Strict option, rejects or normalizes the requests with ambiguous framing (400).
+//
+//	v, _ := validate.New(next, validate.MaxBodyBytes(1024*1024), validate.ContentTypes("application/json"), validate.JSON())
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/oxy/utils"
+)
+
+// DefaultJSONMaxBodyBytes limits the bodies buffered for JSON validation when MaxBodyBytes is not set
+const DefaultJSONMaxBodyBytes = 1024 * 1024
+
+// Validator checks the requests against the configured constraints
+type Validator struct {
+	next         http.Handler
+	maxBodyBytes int64
+	contentTypes []string
+	validateJSON bool
+	strict       StrictMode
+	errHandler   utils.ErrorHandler
+	log          utils.Logger
+}
+
+// ValidatorOption is a functional option setter for Validator
+type ValidatorOption func(v *Validator) error
+
+// MaxBodyBytes sets the maximum size of the request body. Requests with Content-Length exceeding the limit
+// are rejected right away, chunked requests are buffered up to the limit to verify the size.
+func MaxBodyBytes(m int64) ValidatorOption {
+	return func(v *Validator) error {
+		if m <= 0 {
+			return fmt.Errorf("max body bytes should be > 0, got %d", m)
+		}
+		v.maxBodyBytes = m
+		return nil
+	}
+}
+
+// ContentTypes sets the media types allowed for the requests with body, e.g. application/json
+func ContentTypes(types ...string) ValidatorOption {
+	return func(v *Validator) error {
+		for _, t := range types {
+			v.contentTypes = append(v.contentTypes, strings.ToLower(t))
+		}
+		return nil
+	}
+}
+
+// JSON requires the request bodies to be well-formed JSON, the body is buffered in memory to validate it
+func JSON() ValidatorOption {
+	return func(v *Validator) error {
+		v.validateJSON = true
+		return nil
+	}
+}
+
+// ErrorHandler sets the handler rejecting the requests, it receives *ValidationError
+func ErrorHandler(h utils.ErrorHandler) ValidatorOption {
+	return func(v *Validator) error {
+		v.errHandler = h
+		return nil
+	}
+}
+
+// Logger sets the logger that will be used by this middleware.
+func Logger(l utils.Logger) ValidatorOption {
+	return func(v *Validator) error {
+		v.log = l
+		return nil
+	}
+}
+
+// New returns a new validating middleware
+func New(next http.Handler, options ...ValidatorOption) (*Validator, error) {
+	v := &Validator{next: next}
+	for _, o := range options {
+		if err := o(v); err != nil {
+			return nil, err
+		}
+	}
+	if v.validateJSON && v.maxBodyBytes == 0 {
+		v.maxBodyBytes = DefaultJSONMaxBodyBytes
+	}
+	if v.errHandler == nil {
+		v.errHandler = defaultErrHandler
+	}
+	if v.log == nil {
+		v.log = utils.NullLogger
+	}
+	return v, nil
+}
+
+func (v *Validator) Wrap(next http.Handler) {
+	v.next = next
+}
+
+func (v *Validator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := v.validate(req); err != nil {
+		v.log.Infof("rejecting %v %v: %v", req.Method, req.URL, err)
+		v.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	v.next.ServeHTTP(w, req)
+}
+
+func (v *Validator) validate(req *http.Request) error {
+	if v.strict != 0 {
+		if err := v.validateFraming(req); err != nil {
+			return err
+		}
+	}
+	if req.Body == nil || req.ContentLength == 0 {
+		return nil
+	}
+	if v.maxBodyBytes > 0 && req.ContentLength > v.maxBodyBytes {
+		return tooLarge(v.maxBodyBytes)
+	}
+	if len(v.contentTypes) != 0 && !v.contentTypeAllowed(req.Header.Get("Content-Type")) {
+		return &ValidationError{Code: http.StatusUnsupportedMediaType, Reason: fmt.Sprintf("unsupported content type '%v'", req.Header.Get("Content-Type"))}
+	}
+	// the size of the body is known and the contents are not inspected, so there's no need to buffer it
+	if !v.validateJSON && (v.maxBodyBytes == 0 || req.ContentLength > 0) {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, v.maxBodyBytes+1))
+	req.Body.Close()
+	if err != nil {
+		return &ValidationError{Code: http.StatusBadRequest, Reason: fmt.Sprintf("failed to read body: %v", err)}
+	}
+	if int64(len(body)) > v.maxBodyBytes {
+		return tooLarge(v.maxBodyBytes)
+	}
+	if v.validateJSON && len(body) != 0 && !json.Valid(body) {
+		return &ValidationError{Code: http.StatusBadRequest, Reason: "malformed JSON body"}
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	return nil
+}
+
+func (v *Validator) contentTypeAllowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range v.contentTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func tooLarge(max int64) error {
+	return &ValidationError{Code: http.StatusRequestEntityTooLarge, Reason: fmt.Sprintf("body exceeds %d bytes", max)}
+}
+
+// ValidationError is passed to the error handler when the request fails validation
+type ValidationError struct {
+	// Code is the suggested status code of the reply
+	Code   int
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+type ValidatorErrHandler struct {
+}
+
+func (e *ValidatorErrHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error) {
+	if ve, ok := err.(*ValidationError); ok {
+		w.WriteHeader(ve.Code)
+		w.Write([]byte(ve.Reason))
+		return
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+var defaultErrHandler = &ValidatorErrHandler{}
@@ -0,0 +1,103 @@
+package validate
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/oxy/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestValidate(t *testing.T) { TestingT(t) }
+
+type ValidateSuite struct{}
+
+var _ = Suite(&ValidateSuite{})
+
+var echo = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	w.Write(body)
+})
+
+func (s *ValidateSuite) newServer(c *C, options ...ValidatorOption) *httptest.Server {
+	v, err := New(echo, options...)
+	c.Assert(err, IsNil)
+	return httptest.NewServer(v)
+}
+
+func post(url, contentType, body string) (*http.Response, []byte, error) {
+	return testutils.MakeRequest(url, testutils.Method("POST"), testutils.Body(body), testutils.Header("Content-Type", contentType))
+}
+
+func (s *ValidateSuite) TestMaxBodyBytes(c *C) {
+	srv := s.newServer(c, MaxBodyBytes(5))
+	defer srv.Close()
+
+	re, body, err := post(srv.URL, "text/plain", "hello")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "hello")
+
+	re, _, err = post(srv.URL, "text/plain", "hello, world")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusRequestEntityTooLarge)
+}
+
+func (s *ValidateSuite) TestChunked(c *C) {
+	srv := s.newServer(c, MaxBodyBytes(5))
+	defer srv.Close()
+
+	send := func(body string) *http.Response {
+		// wrapping the reader hides the length, so the body is sent chunked
+		req, err := http.NewRequest("POST", srv.URL, ioutil.NopCloser(strings.NewReader(body)))
+		c.Assert(err, IsNil)
+		re, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		re.Body.Close()
+		return re
+	}
+	c.Assert(send("hello").StatusCode, Equals, http.StatusOK)
+	c.Assert(send("hello, world").StatusCode, Equals, http.StatusRequestEntityTooLarge)
+}
+
+func (s *ValidateSuite) TestContentTypes(c *C) {
+	srv := s.newServer(c, ContentTypes("application/json", "text/plain"))
+	defer srv.Close()
+
+	re, _, err := post(srv.URL, "application/json; charset=utf-8", "{}")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+
+	re, _, err = post(srv.URL, "application/xml", "<a/>")
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusUnsupportedMediaType)
+
+	// requests without body are not checked
+	re, _, err = testutils.Get(srv.URL)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *ValidateSuite) TestJSON(c *C) {
+	srv := s.newServer(c, JSON())
+	defer srv.Close()
+
+	re, body, err := post(srv.URL, "application/json", `{"a": [1, 2]}`)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, `{"a": [1, 2]}`)
+
+	re, body, err = post(srv.URL, "application/json", `{"a": [1, 2}`)
+	c.Assert(err, IsNil)
+	c.Assert(re.StatusCode, Equals, http.StatusBadRequest)
+	c.Assert(string(body), Equals, "malformed JSON body")
+}
+
+func (s *ValidateSuite) TestBadOptions(c *C) {
+	_, err := New(echo, MaxBodyBytes(0))
+	c.Assert(err, NotNil)
+}